@@ -0,0 +1,274 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func runAggregate(args []string) {
+	fs := flag.NewFlagSet("aggregate", flag.ExitOnError)
+	outputPath := fs.String("output", stdioPath, "Output CSV file for the summary, or - for stdout")
+	groupBy := fs.String("group-by", "", "Comma-separated field paths to group events by, e.g. event_name,geo.country (default one group of all events)")
+	aggList := fs.String("agg", "count", "Comma-separated aggregates per group: count, count_distinct(field), sum(field), avg(field), min(field) or max(field)")
+	events := addEventFilterFlags(fs)
+	csvOutput := addCSVReportFlags(fs)
+	logging := addLogFlags(fs)
+	parseFlags(fs, args)
+	logging.setup()
+
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: avroparser aggregate [-group-by a,b] [-agg count,sum(x),avg(y)] [-output <file>|-] <avro_or_ndjson_file|dir|glob|->...")
+		os.Exit(exitFatal)
+	}
+	dialect, err := csvOutput.dialect()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	var groupPaths [][]string
+	for _, path := range splitFieldList(*groupBy) {
+		if path == "" || strings.HasPrefix(path, ".") || strings.HasSuffix(path, ".") || strings.Contains(path, "..") {
+			fmt.Fprintf(os.Stderr, "Invalid -group-by path %q\n", path)
+			os.Exit(exitFatal)
+		}
+		groupPaths = append(groupPaths, strings.Split(path, "."))
+	}
+	aggs, err := parseAggregates(*aggList)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	source, err := events.source()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+
+	var inputs []inputFile
+	for _, arg := range fs.Args() {
+		inputs = append(inputs, mustExpandInputs(arg)...)
+	}
+
+	groups := make(map[string]*aggregateGroup)
+	ignored := make([]int, len(aggs))
+	failed := source.each(inputs, func(event interface{}) {
+		values := make([]interface{}, len(groupPaths))
+		keys := make([]string, len(groupPaths))
+		for i, path := range groupPaths {
+			values[i] = lookupPath(event, path)
+			keys[i] = countKey(values[i])
+		}
+		// Joined by the unit separator, which values don't hold in practice
+		key := strings.Join(keys, "\x1f")
+		g := groups[key]
+		if g == nil {
+			g = &aggregateGroup{values: values, states: make([]aggregateState, len(aggs))}
+			groups[key] = g
+		}
+		for i, agg := range aggs {
+			if !g.states[i].add(agg, event) {
+				ignored[i]++
+			}
+		}
+	})
+	if failed == len(inputs) {
+		os.Exit(exitFatal)
+	}
+	for i, agg := range aggs {
+		if ignored[i] > 0 {
+			slog.Warn("Left out values that aren't numbers", "aggregate", agg.name, "events", ignored[i])
+		}
+	}
+
+	out, err := openOutput(*outputPath)
+	if err == nil {
+		err = writeAggregateCSV(out, splitFieldList(*groupBy), aggs, groups, dialect)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		slog.Error("Cannot write summary", "output", displayPath(*outputPath), "error", err)
+		os.Exit(exitFatal)
+	}
+	slog.Info("Wrote summary", "groups", len(groups), "output", displayPath(*outputPath))
+	if failed > 0 {
+		os.Exit(exitPartial)
+	}
+}
+
+// aggregate is one of the -agg functions, over the field at path.
+type aggregate struct {
+	name string // as given, e.g. sum(payload_amount), which heads its column
+	fn   string
+	path []string // nil for count
+}
+
+// parseAggregates parses -agg, e.g. count,sum(payload_amount).
+func parseAggregates(list string) ([]aggregate, error) {
+	var aggs []aggregate
+	for _, name := range splitFieldList(list) {
+		if name == "count" {
+			aggs = append(aggs, aggregate{name: name, fn: name})
+			continue
+		}
+		open := strings.IndexByte(name, '(')
+		if open < 0 || !strings.HasSuffix(name, ")") {
+			return nil, fmt.Errorf("invalid -agg entry %q (expected count or a function of a field, e.g. sum(amount))", name)
+		}
+		fn, path := name[:open], name[open+1:len(name)-1]
+		switch fn {
+		case "count_distinct", "sum", "avg", "min", "max":
+		default:
+			return nil, fmt.Errorf("unknown -agg function %q (expected count, count_distinct, sum, avg, min or max)", fn)
+		}
+		if path == "" || strings.HasPrefix(path, ".") || strings.HasSuffix(path, ".") || strings.Contains(path, "..") {
+			return nil, fmt.Errorf("invalid -agg field path %q", path)
+		}
+		aggs = append(aggs, aggregate{name: name, fn: fn, path: strings.Split(path, ".")})
+	}
+	if len(aggs) == 0 {
+		return nil, fmt.Errorf("-agg must name at least one aggregate")
+	}
+	return aggs, nil
+}
+
+// aggregateGroup is the events sharing the values of the -group-by fields.
+type aggregateGroup struct {
+	values []interface{}
+	states []aggregateState // by aggregate
+}
+
+// aggregateState accumulates an aggregate of a group. Sums of integers are
+// kept exact until a fraction comes along.
+type aggregateState struct {
+	count    int64 // events, or number values of the field
+	sum      float64
+	intSum   int64
+	fraction bool // sum holds the sum, rather than intSum
+	min, max float64
+	distinct map[string]bool
+}
+
+// add adds an event to the state. It returns false when the field is there
+// but isn't a number where one is needed; missing and null fields are left
+// out silently, as in SQL.
+func (s *aggregateState) add(agg aggregate, event interface{}) bool {
+	if agg.fn == "count" {
+		s.count++
+		return true
+	}
+	v := lookupPath(event, agg.path)
+	if v == nil {
+		return true
+	}
+	if agg.fn == "count_distinct" {
+		if s.distinct == nil {
+			s.distinct = make(map[string]bool)
+		}
+		s.distinct[countKey(v)] = true
+		return true
+	}
+	n, ok := filterNumeric(v)
+	if !ok {
+		return false
+	}
+	f := filterFloat(n)
+	if s.count == 0 || f < s.min {
+		s.min = f
+	}
+	if s.count == 0 || f > s.max {
+		s.max = f
+	}
+	s.count++
+	i, isInt := n.(int64)
+	switch {
+	case s.fraction:
+		s.sum += f
+	case !isInt || i > 0 && s.intSum > math.MaxInt64-i || i < 0 && s.intSum < math.MinInt64-i:
+		s.sum = float64(s.intSum) + f
+		s.fraction = true
+	default:
+		s.intSum += i
+	}
+	return true
+}
+
+// cell renders the result of the aggregate. The aggregates of numbers are
+// empty for a group without any.
+func (s *aggregateState) cell(agg aggregate) string {
+	switch agg.fn {
+	case "count":
+		return strconv.FormatInt(s.count, 10)
+	case "count_distinct":
+		return strconv.Itoa(len(s.distinct))
+	}
+	if s.count == 0 {
+		return ""
+	}
+	switch agg.fn {
+	case "sum":
+		if !s.fraction {
+			return strconv.FormatInt(s.intSum, 10)
+		}
+		return strconv.FormatFloat(s.sum, 'f', -1, 64)
+	case "avg":
+		sum := s.sum
+		if !s.fraction {
+			sum = float64(s.intSum)
+		}
+		return strconv.FormatFloat(sum/float64(s.count), 'f', -1, 64)
+	case "min":
+		return strconv.FormatFloat(s.min, 'f', -1, 64)
+	default:
+		return strconv.FormatFloat(s.max, 'f', -1, 64)
+	}
+}
+
+// writeAggregateCSV writes a row per group, ordered by the values of the
+// -group-by fields. Missing and null group values are written empty.
+func writeAggregateCSV(w io.Writer, groupBy []string, aggs []aggregate, groups map[string]*aggregateGroup, dialect csvDialect) error {
+	cw := newCSVWriter(w, dialect)
+	header := append([]string(nil), groupBy...)
+	for _, agg := range aggs {
+		header = append(header, agg.name)
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	rows := make([][]string, 0, len(groups))
+	for _, g := range groups {
+		row := make([]string, 0, len(header))
+		for _, v := range g.values {
+			if v == nil {
+				row = append(row, "")
+			} else {
+				row = append(row, countKey(v))
+			}
+		}
+		for i, agg := range aggs {
+			row = append(row, g.states[i].cell(agg))
+		}
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		for k := range groupBy {
+			if rows[i][k] != rows[j][k] {
+				return rows[i][k] < rows[j][k]
+			}
+		}
+		return false
+	})
+	if err := cw.WriteAll(rows); err != nil {
+		return err
+	}
+	return cw.Error()
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestParseAggregates(t *testing.T) {
+	aggs, err := parseAggregates("count, sum(payload.amount),count_distinct(user)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(aggs) != 3 || aggs[1].fn != "sum" || strings.Join(aggs[1].path, "/") != "payload/amount" || aggs[0].path != nil {
+		t.Fatalf("parsed %+v", aggs)
+	}
+	for _, list := range []string{"", "median(x)", "sum", "sum()", "avg(a..b)"} {
+		if _, err := parseAggregates(list); err == nil {
+			t.Errorf("parseAggregates(%q) succeeded", list)
+		}
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	aggs, err := parseAggregates("count,count_distinct(user),sum(amount),avg(amount),min(amount),max(amount)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups := make(map[string]*aggregateGroup)
+	ignored := 0
+	for _, msg := range []string{
+		`{"event":"buy","user":"a","amount":5}`,
+		`{"event":"buy","user":"b","amount":2}`,
+		`{"event":"buy","user":"a","amount":"free"}`,
+		`{"event":"buy","user":"a"}`,
+		`{"event":"refund","user":"b","amount":-1.5}`,
+		`{"user":"c","amount":9007199254740993}`,
+	} {
+		event, err := parseMessage(json.RawMessage(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		value := lookupPath(event, []string{"event"})
+		key := countKey(value)
+		g := groups[key]
+		if g == nil {
+			g = &aggregateGroup{values: []interface{}{value}, states: make([]aggregateState, len(aggs))}
+			groups[key] = g
+		}
+		for i, agg := range aggs {
+			if !g.states[i].add(agg, event) {
+				ignored++
+			}
+		}
+	}
+	// "free" isn't a number for any of sum, avg, min and max
+	if ignored != 4 {
+		t.Fatalf("ignored %d values, want 4", ignored)
+	}
+
+	var buf bytes.Buffer
+	if err := writeAggregateCSV(&buf, []string{"event"}, aggs, groups, csvDialect{}); err != nil {
+		t.Fatal(err)
+	}
+	want := "event,count,count_distinct(user),sum(amount),avg(amount),min(amount),max(amount)\n" +
+		",1,1,9007199254740993,9007199254740992,9007199254740992,9007199254740992\n" +
+		"buy,4,2,7,3.5,2,5\n" +
+		"refund,1,1,-1.5,-1.5,-1.5,-1.5\n"
+	if buf.String() != want {
+		t.Fatalf("wrote %q, want %q", buf.String(), want)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// archiveSeparator divides the path of an archive entry input, such as
+// bundle.zip!/exports/events.avro, into the archive and the entry.
+const archiveSeparator = "!/"
+
+// errNoArchiveEntry is returned for entries an archive doesn't have.
+var errNoArchiveEntry = errors.New("no such entry in the archive")
+
+// archiveSuffixes are the extensions of archives whose entries are read as
+// inputs. Tar archives may be compressed as any input can.
+var archiveSuffixes = []string{".zip", ".tar", ".tar.gz", ".tgz", ".tar.zst"}
+
+// archiveSuffix returns the archive extension of a file name, or "".
+func archiveSuffix(name string) string {
+	lower := strings.ToLower(name)
+	for _, suffix := range archiveSuffixes {
+		if strings.HasSuffix(lower, suffix) {
+			return name[len(name)-len(suffix):]
+		}
+	}
+	return ""
+}
+
+// isArchiveEntry reports whether an input is an entry of a local archive.
+func isArchiveEntry(p string) bool {
+	archive, _, ok := strings.Cut(p, archiveSeparator)
+	return ok && !isGCSPath(archive) && !isS3Path(archive) && !isHTTPPath(archive) && archiveSuffix(archive) != ""
+}
+
+// isInputEntry reports whether an archive entry is read as an input: Avro
+// and NDJSON files, possibly compressed.
+func isInputEntry(name string) bool {
+	switch strings.ToLower(path.Ext(trimCompressionSuffix(name))) {
+	case ".avro", ".ndjson", ".jsonl", ".json":
+		return true
+	}
+	return false
+}
+
+// expandArchive returns an input for each Avro and NDJSON entry of a local
+// archive, in name order, named under rel without its extension so that
+// entries of different archives don't share output names.
+func expandArchive(archive, rel string) ([]inputFile, error) {
+	names, err := archiveEntries(archive)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list %s: %w", archive, err)
+	}
+	base := strings.TrimSuffix(rel, archiveSuffix(rel))
+	var inputs []inputFile
+	for _, name := range names {
+		if isInputEntry(name) {
+			inputs = append(inputs, inputFile{path: archive + archiveSeparator + name, rel: filepath.Join(base, filepath.FromSlash(name))})
+		}
+	}
+	return inputs, nil
+}
+
+// archiveEntries lists the files of an archive.
+func archiveEntries(archive string) ([]string, error) {
+	var names []string
+	if strings.EqualFold(archiveSuffix(archive), ".zip") {
+		zr, err := zip.OpenReader(archive)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		for _, f := range zr.File {
+			if !f.FileInfo().IsDir() {
+				names = append(names, f.Name)
+			}
+		}
+	} else {
+		input, err := openInput(archive)
+		if err != nil {
+			return nil, err
+		}
+		defer input.Close()
+		tr := tar.NewReader(input)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if header.Typeflag == tar.TypeReg {
+				names = append(names, header.Name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// archiveSource is an entry of a local archive, read without extracting
+// it. Zip entries are found through the archive's directory; tar archives
+// are read up to the entry.
+type archiveSource struct {
+	archive string
+	entry   string
+}
+
+func newArchiveSource(p string) archiveSource {
+	archive, entry, _ := strings.Cut(p, archiveSeparator)
+	return archiveSource{archive: archive, entry: entry}
+}
+
+func (as archiveSource) Name() string { return as.archive + archiveSeparator + as.entry }
+
+func (as archiveSource) Open() (io.ReadCloser, error) {
+	r, err := as.open()
+	if err != nil {
+		return nil, fmt.Errorf("cannot open %s: %w", as.Name(), err)
+	}
+	return r, nil
+}
+
+func (as archiveSource) open() (io.ReadCloser, error) {
+	if strings.EqualFold(archiveSuffix(as.archive), ".zip") {
+		zr, err := zip.OpenReader(as.archive)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range zr.File {
+			if f.Name != as.entry {
+				continue
+			}
+			r, err := f.Open()
+			if err != nil {
+				zr.Close()
+				return nil, err
+			}
+			return readCloser{Reader: r, close: func() error { r.Close(); return zr.Close() }}, nil
+		}
+		zr.Close()
+		return nil, errNoArchiveEntry
+	}
+
+	input, err := openInput(as.archive)
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(input)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			input.Close()
+			return nil, errNoArchiveEntry
+		}
+		if err != nil {
+			input.Close()
+			return nil, err
+		}
+		if header.Name == as.entry && header.Typeflag == tar.TypeReg {
+			return readCloser{Reader: tr, close: input.Close}, nil
+		}
+	}
+}
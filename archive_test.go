@@ -0,0 +1,121 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// archiveTestEntries are the files of the test archives, of which the Avro
+// and NDJSON ones are inputs.
+var archiveTestEntries = []struct{ name, data string }{
+	{"exports/b.ndjson", "{\"id\":2}\n"},
+	{"README.txt", "not an input"},
+	{"exports/a.avro", "Obj\x01"},
+}
+
+func archiveTestData(name string) string {
+	for _, e := range archiveTestEntries {
+		if e.name == name {
+			return e.data
+		}
+	}
+	return ""
+}
+
+func writeTestZip(t *testing.T) string {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range archiveTestEntries {
+		w, err := zw.Create(e.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, e.data)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return writeTestFile(t, "bundle.zip", buf.Bytes())
+}
+
+func writeTestTarGz(t *testing.T) string {
+	t.Helper()
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	tw.WriteHeader(&tar.Header{Name: "exports/", Typeflag: tar.TypeDir, Mode: 0o755})
+	for _, e := range archiveTestEntries {
+		if err := tw.WriteHeader(&tar.Header{Name: e.name, Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(e.data))}); err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(tw, e.data)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return writeTestFile(t, "bundle.tar.gz", buf.Bytes())
+}
+
+func TestExpandArchive(t *testing.T) {
+	for name, archive := range map[string]string{"zip": writeTestZip(t), "tar.gz": writeTestTarGz(t)} {
+		t.Run(name, func(t *testing.T) {
+			inputs, err := expandInputs(archive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []inputFile{
+				{path: archive + "!/exports/a.avro", rel: filepath.Join("bundle", "exports", "a.avro")},
+				{path: archive + "!/exports/b.ndjson", rel: filepath.Join("bundle", "exports", "b.ndjson")},
+			}
+			if !reflect.DeepEqual(inputs, want) {
+				t.Fatalf("expanded %+v, want %+v", inputs, want)
+			}
+			for _, in := range inputs {
+				if !isRemotePath(in.path) {
+					t.Errorf("%s is not remote", in.path)
+				}
+				rc, err := newSource(in.path).Open()
+				if err != nil {
+					t.Fatal(err)
+				}
+				data, err := io.ReadAll(rc)
+				rc.Close()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if entry := newArchiveSource(in.path).entry; string(data) != archiveTestData(entry) {
+					t.Errorf("%s read %q, want %q", in.path, data, archiveTestData(entry))
+				}
+			}
+		})
+	}
+}
+
+func TestArchiveEntryInput(t *testing.T) {
+	archive := writeTestZip(t)
+	inputs, err := expandInputs(archive + "!/exports/b.ndjson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []inputFile{{path: archive + "!/exports/b.ndjson", rel: "b.ndjson"}}; !reflect.DeepEqual(inputs, want) {
+		t.Fatalf("expanded %+v, want %+v", inputs, want)
+	}
+	if _, err := newSource(archive + "!/missing.avro").Open(); !errors.Is(err, errNoArchiveEntry) {
+		t.Fatalf("opened a missing entry: %v", err)
+	}
+	if isArchiveEntry("gs://bucket/bundle.zip!/a.avro") || isArchiveEntry(filepath.Join(os.TempDir(), "a.avro")) {
+		t.Error("isArchiveEntry accepted a path that is not a local archive entry")
+	}
+}
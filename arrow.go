@@ -0,0 +1,496 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"time"
+
+	"avroparser/pkg/avroconvert"
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/decimal128"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+// arrowBatchRows is the most rows buffered before a record batch is
+// written.
+const arrowBatchRows = 64 * 1024
+
+// arrowNativeWriter writes whole Avro records as an Arrow IPC file, with the
+// Arrow schema derived from the Avro writer schema. The file is
+// uncompressed, so readers can memory-map it.
+type arrowNativeWriter struct {
+	w         io.Writer
+	fw        *ipc.FileWriter
+	rb        *array.RecordBuilder
+	rows      int
+	schema    *avroconvert.Schema
+	converter *avroconvert.JSONConverter
+}
+
+func newArrowNativeWriter(w io.Writer, converter *avroconvert.JSONConverter) *arrowNativeWriter {
+	return &arrowNativeWriter{w: w, converter: converter}
+}
+
+// SetSchema creates the Arrow writer for the Avro writer schema.
+func (aw *arrowNativeWriter) SetSchema(schema *avroconvert.Schema) error {
+	if schema.Kind != "record" {
+		return fmt.Errorf("arrow output needs a record schema, got %s", schema.Kind)
+	}
+	inProgress := make(map[*avroconvert.Schema]bool)
+	fields := make([]arrow.Field, len(schema.Fields))
+	for i, f := range schema.Fields {
+		field, err := arrowField(f.Name, f.Schema, inProgress)
+		if err != nil {
+			return err
+		}
+		fields[i] = field
+	}
+	fw, rb, err := newArrowFile(aw.w, arrow.NewSchema(fields, nil))
+	if err != nil {
+		return err
+	}
+	aw.schema, aw.fw, aw.rb = schema, fw, rb
+	return nil
+}
+
+func (aw *arrowNativeWriter) WriteNative(record interface{}) error {
+	m, _ := record.(map[string]interface{})
+	for i, f := range aw.schema.Fields {
+		aw.appendValue(aw.rb.Field(i), f.Schema, m[f.Name])
+	}
+	aw.rows++
+	if aw.rows >= arrowBatchRows {
+		return aw.Flush()
+	}
+	return nil
+}
+
+func (aw *arrowNativeWriter) WriteRecord(msg json.RawMessage) error {
+	return errors.New("arrow output of extracted fields needs column discovery")
+}
+
+// Flush writes the buffered rows as a record batch.
+func (aw *arrowNativeWriter) Flush() error {
+	if aw.fw == nil || aw.rows == 0 {
+		return nil
+	}
+	aw.rows = 0
+	return writeArrowBatch(aw.fw, aw.rb)
+}
+
+func (aw *arrowNativeWriter) Close() error {
+	if aw.fw == nil {
+		return nil
+	}
+	err := aw.Flush()
+	aw.rb.Release()
+	if closeErr := aw.fw.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// newArrowFile starts an Arrow IPC file of the schema, and a builder for its
+// record batches.
+func newArrowFile(w io.Writer, schema *arrow.Schema) (*ipc.FileWriter, *array.RecordBuilder, error) {
+	fw, err := ipc.NewFileWriter(w, ipc.WithSchema(schema), ipc.WithAllocator(memory.DefaultAllocator))
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot create arrow writer: %w", err)
+	}
+	return fw, array.NewRecordBuilder(memory.DefaultAllocator, schema), nil
+}
+
+func writeArrowBatch(fw *ipc.FileWriter, rb *array.RecordBuilder) error {
+	batch := rb.NewRecord()
+	defer batch.Release()
+	return fw.Write(batch)
+}
+
+// arrowField maps an Avro field to an Arrow field. inProgress tracks the
+// records being mapped, as Arrow cannot represent recursive types.
+func arrowField(name string, s *avroconvert.Schema, inProgress map[*avroconvert.Schema]bool) (arrow.Field, error) {
+	nullable := false
+	if s.Kind == "union" {
+		if branch := singleNonNullBranch(s); branch != nil {
+			s, nullable = branch, true
+		} else {
+			// Unions of several types are kept as JSON text
+			return arrow.Field{Name: name, Type: arrow.BinaryTypes.String, Nullable: true}, nil
+		}
+	}
+	t, err := arrowType(s, inProgress)
+	if err != nil {
+		return arrow.Field{}, err
+	}
+	return arrow.Field{Name: name, Type: t, Nullable: nullable || s.Kind == "null"}, nil
+}
+
+func arrowType(s *avroconvert.Schema, inProgress map[*avroconvert.Schema]bool) (arrow.DataType, error) {
+	switch s.Kind {
+	case "union":
+		field, err := arrowField("", s, inProgress)
+		return field.Type, err
+
+	case "null":
+		return arrow.Null, nil
+
+	case "boolean":
+		return arrow.FixedWidthTypes.Boolean, nil
+
+	case "int":
+		switch s.LogicalType {
+		case "date":
+			return arrow.FixedWidthTypes.Date32, nil
+		case "time-millis":
+			return arrow.FixedWidthTypes.Time32ms, nil
+		}
+		return arrow.PrimitiveTypes.Int32, nil
+
+	case "long":
+		switch s.LogicalType {
+		case "timestamp-millis":
+			return &arrow.TimestampType{Unit: arrow.Millisecond, TimeZone: "UTC"}, nil
+		case "timestamp-micros":
+			return &arrow.TimestampType{Unit: arrow.Microsecond, TimeZone: "UTC"}, nil
+		case "timestamp-nanos":
+			return &arrow.TimestampType{Unit: arrow.Nanosecond, TimeZone: "UTC"}, nil
+		case "local-timestamp-millis":
+			return &arrow.TimestampType{Unit: arrow.Millisecond}, nil
+		case "local-timestamp-micros":
+			return &arrow.TimestampType{Unit: arrow.Microsecond}, nil
+		case "local-timestamp-nanos":
+			return &arrow.TimestampType{Unit: arrow.Nanosecond}, nil
+		case "time-micros":
+			return arrow.FixedWidthTypes.Time64us, nil
+		}
+		return arrow.PrimitiveTypes.Int64, nil
+
+	case "float":
+		return arrow.PrimitiveTypes.Float32, nil
+
+	case "double":
+		return arrow.PrimitiveTypes.Float64, nil
+
+	case "string", "enum":
+		return arrow.BinaryTypes.String, nil
+
+	case "bytes", "fixed":
+		if s.LogicalType == "decimal" && s.Precision <= 38 {
+			return &arrow.Decimal128Type{Precision: int32(s.Precision), Scale: int32(s.Scale)}, nil
+		}
+		if s.Kind == "fixed" {
+			return &arrow.FixedSizeBinaryType{ByteWidth: s.Size}, nil
+		}
+		return arrow.BinaryTypes.Binary, nil
+
+	case "array":
+		items, err := arrowField("item", s.Items, inProgress)
+		if err != nil {
+			return nil, err
+		}
+		return arrow.ListOfField(items), nil
+
+	case "map":
+		values, err := arrowField("value", s.Values, inProgress)
+		if err != nil {
+			return nil, err
+		}
+		return arrow.MapOf(arrow.BinaryTypes.String, values.Type), nil
+
+	case "record":
+		if inProgress[s] {
+			return nil, fmt.Errorf("recursive record %s cannot be written to arrow", s.Name)
+		}
+		inProgress[s] = true
+		defer delete(inProgress, s)
+
+		fields := make([]arrow.Field, len(s.Fields))
+		for i, f := range s.Fields {
+			field, err := arrowField(f.Name, f.Schema, inProgress)
+			if err != nil {
+				return nil, err
+			}
+			fields[i] = field
+		}
+		return arrow.StructOf(fields...), nil
+	}
+	return nil, fmt.Errorf("unsupported avro type %q", s.Kind)
+}
+
+// appendValue appends a goavro native value to the builder arrowType made
+// for s. Values of an unexpected type are appended as nulls.
+func (aw *arrowNativeWriter) appendValue(b array.Builder, s *avroconvert.Schema, v interface{}) {
+	if v == nil {
+		b.AppendNull()
+		return
+	}
+	if s.Kind == "union" {
+		branch, value := avroconvert.UnwrapUnion(s, v)
+		if value == nil {
+			b.AppendNull()
+			return
+		}
+		if singleNonNullBranch(s) == nil {
+			if branch != nil {
+				value = aw.converter.Value(branch, value)
+			} else {
+				value = aw.converter.Generic(value)
+			}
+			text, err := json.Marshal(value)
+			if err != nil {
+				b.AppendNull()
+				return
+			}
+			b.(*array.StringBuilder).Append(string(text))
+			return
+		}
+		if branch == nil {
+			b.AppendNull()
+			return
+		}
+		aw.appendValue(b, branch, value)
+		return
+	}
+
+	ok := true
+	switch b := b.(type) {
+	case *array.BooleanBuilder:
+		var x bool
+		if x, ok = v.(bool); ok {
+			b.Append(x)
+		}
+	case *array.Int32Builder:
+		var x int32
+		if x, ok = v.(int32); ok {
+			b.Append(x)
+		}
+	case *array.Int64Builder:
+		var x int64
+		if x, ok = v.(int64); ok {
+			b.Append(x)
+		}
+	case *array.Float32Builder:
+		var x float32
+		if x, ok = v.(float32); ok {
+			b.Append(x)
+		}
+	case *array.Float64Builder:
+		var x float64
+		if x, ok = v.(float64); ok {
+			b.Append(x)
+		}
+	case *array.Date32Builder:
+		var t time.Time
+		if t, ok = v.(time.Time); ok {
+			b.Append(arrow.Date32FromTime(t))
+		}
+	case *array.Time32Builder:
+		var d time.Duration
+		if d, ok = v.(time.Duration); ok {
+			b.Append(arrow.Time32(d / time.Millisecond))
+		}
+	case *array.Time64Builder:
+		var d time.Duration
+		if d, ok = v.(time.Duration); ok {
+			b.Append(arrow.Time64(d / time.Microsecond))
+		}
+	case *array.TimestampBuilder:
+		switch t := v.(type) {
+		case time.Time:
+			switch s.LogicalType {
+			case "timestamp-micros":
+				b.Append(arrow.Timestamp(t.UnixMicro()))
+			case "timestamp-nanos":
+				b.Append(arrow.Timestamp(t.UnixNano()))
+			default:
+				b.Append(arrow.Timestamp(t.UnixMilli()))
+			}
+		case int64:
+			b.Append(arrow.Timestamp(t))
+		default:
+			ok = false
+		}
+	case *array.StringBuilder:
+		var x string
+		if x, ok = v.(string); ok {
+			b.Append(x)
+		}
+	case *array.BinaryBuilder:
+		switch x := v.(type) {
+		case []byte:
+			b.Append(x)
+		case *big.Rat: // decimals too precise for Decimal128
+			b.Append(decimalBytes(x, s.Scale, 0))
+		default:
+			ok = false
+		}
+	case *array.FixedSizeBinaryBuilder:
+		switch x := v.(type) {
+		case []byte:
+			ok = len(x) == s.Size
+			if ok {
+				b.Append(x)
+			}
+		case *big.Rat:
+			b.Append(decimalBytes(x, s.Scale, s.Size))
+		default:
+			ok = false
+		}
+	case *array.Decimal128Builder:
+		var r *big.Rat
+		if r, ok = v.(*big.Rat); ok {
+			unscaled := new(big.Int).Mul(r.Num(), new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(s.Scale)), nil))
+			b.Append(decimal128.FromBigInt(unscaled.Quo(unscaled, r.Denom())))
+		}
+	case *array.ListBuilder:
+		var items []interface{}
+		if items, ok = v.([]interface{}); ok {
+			b.Append(true)
+			for _, item := range items {
+				aw.appendValue(b.ValueBuilder(), s.Items, item)
+			}
+		}
+	case *array.MapBuilder:
+		var m map[string]interface{}
+		if m, ok = v.(map[string]interface{}); ok {
+			keys := make([]string, 0, len(m))
+			for k := range m {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			b.Append(true)
+			for _, k := range keys {
+				b.KeyBuilder().(*array.StringBuilder).Append(k)
+				aw.appendValue(b.ItemBuilder(), s.Values, m[k])
+			}
+		}
+	case *array.StructBuilder:
+		var m map[string]interface{}
+		if m, ok = v.(map[string]interface{}); ok {
+			b.Append(true)
+			for i, f := range s.Fields {
+				aw.appendValue(b.FieldBuilder(i), f.Schema, m[f.Name])
+			}
+		}
+	default:
+		ok = false
+	}
+	if !ok {
+		b.AppendNull()
+	}
+}
+
+// arrowFlatWriter writes flattened JSON messages as Arrow rows of nullable
+// columns discovered in a first pass, typed as inferred there.
+type arrowFlatWriter struct {
+	fw      *ipc.FileWriter
+	rb      *array.RecordBuilder
+	rows    int
+	columns map[string]int // index by name
+	types   []string
+}
+
+func newArrowFlatWriter(w io.Writer, columns []string, types map[string]string) (*arrowFlatWriter, error) {
+	if len(columns) == 0 {
+		return nil, errors.New("no columns found for arrow output")
+	}
+	fields := make([]arrow.Field, len(columns))
+	index := make(map[string]int, len(columns))
+	columnTypes := make([]string, len(columns))
+	for i, name := range columns {
+		typ := types[name]
+		var dt arrow.DataType
+		switch typ {
+		case columnInt:
+			dt = arrow.PrimitiveTypes.Int64
+		case columnFloat:
+			dt = arrow.PrimitiveTypes.Float64
+		case columnBool:
+			dt = arrow.FixedWidthTypes.Boolean
+		case columnTimestamp:
+			dt = &arrow.TimestampType{Unit: arrow.Microsecond, TimeZone: "UTC"}
+		default:
+			typ, dt = columnString, arrow.BinaryTypes.String
+		}
+		fields[i] = arrow.Field{Name: name, Type: dt, Nullable: true}
+		index[name] = i
+		columnTypes[i] = typ
+	}
+	fw, rb, err := newArrowFile(w, arrow.NewSchema(fields, nil))
+	if err != nil {
+		return nil, err
+	}
+	return &arrowFlatWriter{fw: fw, rb: rb, columns: index, types: columnTypes}, nil
+}
+
+func (fw *arrowFlatWriter) WriteRecord(msg json.RawMessage) error {
+	v, err := parseMessage(msg)
+	if err != nil {
+		return err
+	}
+	row := make([]interface{}, len(fw.columns))
+	for _, f := range flattenRecord(v, parquetFlatSeparator) {
+		if i, ok := fw.columns[f.name]; ok {
+			row[i], _ = typedValue(fw.types[i], f.value)
+		}
+	}
+	for i, cell := range row {
+		switch b := fw.rb.Field(i).(type) {
+		case *array.Int64Builder:
+			if n, ok := cell.(int64); ok {
+				b.Append(n)
+				continue
+			}
+		case *array.Float64Builder:
+			if f, ok := cell.(float64); ok {
+				b.Append(f)
+				continue
+			}
+		case *array.BooleanBuilder:
+			if v, ok := cell.(bool); ok {
+				b.Append(v)
+				continue
+			}
+		case *array.TimestampBuilder:
+			if t, ok := cell.(time.Time); ok {
+				b.Append(arrow.Timestamp(t.UnixMicro()))
+				continue
+			}
+		case *array.StringBuilder:
+			if text, ok := cell.(string); ok {
+				b.Append(text)
+				continue
+			}
+		}
+		fw.rb.Field(i).AppendNull()
+	}
+	fw.rows++
+	if fw.rows >= arrowBatchRows {
+		return fw.Flush()
+	}
+	return nil
+}
+
+// Flush writes the buffered rows as a record batch.
+func (fw *arrowFlatWriter) Flush() error {
+	if fw.rows == 0 {
+		return nil
+	}
+	fw.rows = 0
+	return writeArrowBatch(fw.fw, fw.rb)
+}
+
+func (fw *arrowFlatWriter) Close() error {
+	err := fw.Flush()
+	fw.rb.Release()
+	if closeErr := fw.fw.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
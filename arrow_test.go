@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/linkedin/goavro/v2"
+)
+
+// readArrowFile reads the schema and the single record batch of an Arrow
+// IPC file.
+func readArrowFile(t *testing.T, path string) (*arrow.Schema, arrow.Record) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := ipc.NewFileReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	if r.NumRecords() != 1 {
+		t.Fatalf("read %d record batches, want 1", r.NumRecords())
+	}
+	batch, err := r.Record(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r.Schema(), batch
+}
+
+func TestConvertArrowWholeRecords(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := goavro.NewOCFWriter(goavro.OCFConfig{W: &buf, Schema: sessionSchema})
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i, user := range []interface{}{goavro.Union("string", "ana"), nil} {
+		record := map[string]interface{}{
+			"id": int64(i + 1), "user": user, "started": started,
+			"tags": []interface{}{"a", "b"}, "geo": map[string]interface{}{"country": "DE"},
+		}
+		if err := w.Append([]interface{}{record}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dir := t.TempDir()
+	opts := testOptions(t, "")
+	opts.outputDir, opts.format = dir, "arrow"
+	result := convertFile(inputFile{path: writeTestFile(t, "sessions.avro", buf.Bytes()), rel: "sessions.avro"}, opts)
+	if result.err != nil || result.stats.Messages != 2 {
+		t.Fatalf("converted %+v: %v", result.stats, result.err)
+	}
+
+	schema, batch := readArrowFile(t, filepath.Join(dir, "sessions.arrow"))
+	if batch.NumRows() != 2 || schema.NumFields() != 5 {
+		t.Fatalf("read %d rows of %s", batch.NumRows(), schema)
+	}
+	for i, want := range []arrow.Type{arrow.INT64, arrow.STRING, arrow.TIMESTAMP, arrow.LIST, arrow.STRUCT} {
+		if got := schema.Field(i).Type.ID(); got != want {
+			t.Errorf("field %s has type %s, want %s", schema.Field(i).Name, got, want)
+		}
+	}
+	if !schema.Field(1).Nullable || schema.Field(0).Nullable {
+		t.Errorf("nullability of %s", schema)
+	}
+	ids, users := batch.Column(0).(*array.Int64), batch.Column(1).(*array.String)
+	if ids.Value(1) != 2 || users.Value(0) != "ana" || !users.IsNull(1) {
+		t.Fatalf("read columns %v and %v", ids, users)
+	}
+	country := batch.Column(4).(*array.Struct).Field(0).(*array.String)
+	if country.Value(0) != "DE" {
+		t.Fatalf("read geo.country %v", country)
+	}
+}
+
+func TestConvertArrowField(t *testing.T) {
+	data := writeMessageOCF(t,
+		`{"id":1,"geo":{"country":"DE"},"score":1,"ok":true,"at":"2026-01-02T03:04:05Z"}`,
+		`{"id":2,"tags":[1,2],"score":1.5,"ok":null,"at":"soon"}`,
+	)
+	dir := t.TempDir()
+	opts := testOptions(t, "message")
+	opts.outputDir, opts.format = dir, "arrow"
+	result := convertFile(inputFile{path: writeTestFile(t, "events.avro", data), rel: "events.avro"}, opts)
+	if result.err != nil || result.stats.Messages != 2 {
+		t.Fatalf("converted %+v: %v", result.stats, result.err)
+	}
+
+	schema, batch := readArrowFile(t, filepath.Join(dir, "events.arrow"))
+	types := make(map[string]string)
+	got := make(map[string][]interface{})
+	for i, field := range schema.Fields() {
+		types[field.Name] = field.Type.String()
+		column := batch.Column(i)
+		for row := 0; row < column.Len(); row++ {
+			got[field.Name] = append(got[field.Name], column.GetOneForMarshal(row))
+		}
+	}
+	wantTypes := map[string]string{
+		"id": "int64", "geo_country": "utf8", "tags": "utf8",
+		"score": "float64", "ok": "bool", "at": "utf8",
+	}
+	if !reflect.DeepEqual(types, wantTypes) {
+		t.Errorf("column types %v, want %v", types, wantTypes)
+	}
+	want := map[string][]interface{}{
+		"id":          {int64(1), int64(2)},
+		"geo_country": {"DE", nil},
+		"tags":        {nil, "[1,2]"},
+		"score":       {float64(1), 1.5},
+		"ok":          {true, nil},
+		"at":          {"2026-01-02T03:04:05Z", "soon"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("read columns %v, want %v", got, want)
+	}
+}
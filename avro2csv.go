@@ -0,0 +1,825 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"avroparser/pkg/avroconvert"
+)
+
+// Ways -items writes the items array of e-commerce events.
+const (
+	itemsRows = "rows" // a row per item
+	itemsJSON = "json" // a column of JSON text
+)
+
+// metricNameField is the field -split-by-metric partitions metrics by.
+const metricNameField = "metric_name"
+
+// metricPartition returns the -partition-by of -split-by-metric, which
+// can't be combined with a -partition-by of another field.
+func metricPartition(partitionBy string) (string, error) {
+	if partitionBy != "" && partitionBy != metricNameField {
+		return "", fmt.Errorf("-split-by-metric partitions by %s, so it cannot be combined with -partition-by %s", metricNameField, partitionBy)
+	}
+	return metricNameField, nil
+}
+
+// csvOptions holds the settings for avro2csv.
+type csvOptions struct {
+	decode  decodeOptions
+	flatten flattener
+	long    *longOptions // set for the long layout
+	// singlePass decodes the input once, spilling the messages to a
+	// temporary file while the columns are collected
+	singlePass bool
+	columns    []csvColumn // pinned by -columns, skipping column discovery
+	// partitionColumns gives each -partition-by partition only the columns
+	// its records have
+	partitionColumns bool
+	xlsx             bool     // write an Excel workbook instead of CSV
+	sheetBy          []string // field path naming the sheet of each record
+	appendTo         string   // CSV file every input's rows are appended to
+	// columnTypes writes the discovered columns with their inferred types
+	// next to each output
+	columnTypes bool
+	dialect     csvDialect
+}
+
+func runAvro2CSV(args []string) {
+	fs := flag.NewFlagSet("avro2csv", flag.ExitOnError)
+	inputPath := fs.String("input", "", "Input Avro file, directory or glob pattern, or - for stdin")
+	outputDir := fs.String("output", "output", "Output directory for CSV files, a path template such as out/{date}/{event_name}/{basename}.csv, or - for stdout")
+	format := fs.String("format", "csv", "Output format: csv, or xlsx for an Excel workbook with a sheet per -sheet-by value")
+	sheetBy := fs.String("sheet-by", "", "With -format xlsx, field path whose value names the sheet of each record, e.g. event_name or metric_name (default one sheet)")
+	separator := fs.String("separator", ".", "Separator joining nested field names into column names (e.g. . or _)")
+	maxDepth := fs.Int("max-depth", 0, "Most levels of nested objects flattened into columns, e.g. 2 for payload.progress; deeper values are written as JSON text (0 for no limit)")
+	arrays := fs.String("arrays", arraysJSON, "How arrays become columns: json (one column of JSON text) or index (a column per element, e.g. items.0.item_id)")
+	explode := fs.String("explode", "", "Field path of an array to write a row per element of, e.g. items")
+	items := fs.String("items", "", "How the items array of e-commerce events is written: rows (a row per item, as -explode items) or json (one column of JSON text, whatever -arrays says)")
+	preset := fs.String("preset", "", "Column layout preset: firebase pivots event_params and user_properties into a column per key")
+	long := fs.String("long", "", "Write a row per entry of this key-value array (e.g. event_params), with columns event_id, key, value_type and value, instead of a column per key")
+	eventID := fs.String("event-id", "", "With -long, field identifying each event in the event_id column (default the event's number in its input)")
+	appendPath := fs.String("append", "", "CSV file to append the rows of every input to, created with a header if missing, instead of a file per input under -output; columns its header lacks are added by rewriting it")
+	columnTypes := fs.Bool("column-types", false, "Write the columns with their inferred types (int, float, bool, timestamp or string) to a .columns.yaml file next to each CSV file, in the -columns format")
+	singlePass := fs.Bool("single-pass", false, "Decode each input once, spilling rows to a temporary file while collecting the columns, instead of reading it twice")
+	partitionColumns := fs.Bool("partition-columns", false, "With -partition-by, give each partition's files only the columns its records have, e.g. a file per metric_name with that metric's payload columns")
+	splitByMetric := fs.Bool("split-by-metric", false, "Write a CSV per metric_name with only the columns that metric uses; short for -partition-by metric_name -partition-columns")
+	columnsPath := fs.String("columns", "", "YAML file declaring the output columns, with optional defaults and types, instead of discovering them from the records")
+	workers := fs.Int("workers", 1, "Number of files to convert concurrently")
+	blockWorkers := fs.Int("block-workers", 0, "Number of blocks of each container file to decompress and decode concurrently (default: one per CPU)")
+	compress := fs.String("compress", compressNone, "Compress output files: gzip, zstd or none")
+	statePath := fs.String("state", "", "State file recording what has been converted, so later runs only convert new data")
+	watch := fs.Bool("watch", false, "Keep watching the input directory and convert new Avro files as they appear")
+	settle := fs.Duration("settle", 10*time.Second, "With -watch, how long a file must go unchanged before it is converted")
+	progress := fs.Bool("progress", false, "Report bytes read, records per second and the time left on stderr while converting")
+	summaryPath := fs.String("summary-json", "", "Write a JSON summary of the run, with record counts and failures per input, to this file")
+	unify := fs.Bool("unify-schemas", false, "Give every CSV file the columns of all inputs, read as a superset of their writer schemas, instead of only the columns of its own records")
+	manifestPath := fs.String("manifest", "", "Write a JSON manifest of the CSV files, with their records, sizes and SHA-256 checksums, to this file once every input is converted")
+	overwrite := fs.Bool("overwrite", true, "Replace output files that exist; with -overwrite=false, an input whose output file exists fails instead")
+	skipWritten := fs.Bool("skip-existing", false, "Skip inputs whose output file exists, e.g. to finish a run that stopped part way")
+	records := addRecordFlags(fs)
+	csvOutput := addCSVFlags(fs)
+	redaction := addRedactFlags(fs)
+	joining := addJoinFlags(fs)
+	rawInput := addRawFlags(fs)
+	splitOutput := addSplitFlags(fs)
+	logging := addLogFlags(fs)
+	parseFlags(fs, args)
+	logging.setup()
+
+	if *inputPath == "" && fs.NArg() > 0 {
+		*inputPath = fs.Arg(0)
+	}
+
+	if *inputPath == "" {
+		fmt.Fprintln(os.Stderr, "Usage: avroparser avro2csv -input <avro_file|dir|glob|-> [-output <output_dir>|-] [-separator .|_]")
+		os.Exit(exitFatal)
+	}
+
+	if err := checkCompression(*compress); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	if *format != "csv" && *format != "xlsx" {
+		fmt.Fprintf(os.Stderr, "Unknown format %q (expected csv or xlsx)\n", *format)
+		os.Exit(exitFatal)
+	}
+	var sheetPath []string
+	if *sheetBy != "" {
+		if *format != "xlsx" {
+			fmt.Fprintln(os.Stderr, "-sheet-by is only used with -format xlsx")
+			os.Exit(exitFatal)
+		}
+		if strings.HasPrefix(*sheetBy, ".") || strings.HasSuffix(*sheetBy, ".") || strings.Contains(*sheetBy, "..") {
+			fmt.Fprintf(os.Stderr, "Invalid -sheet-by field path %q\n", *sheetBy)
+			os.Exit(exitFatal)
+		}
+		sheetPath = strings.Split(*sheetBy, ".")
+	}
+	if *arrays != arraysJSON && *arrays != arraysIndex {
+		fmt.Fprintf(os.Stderr, "Unknown array handling %q (expected json or index)\n", *arrays)
+		os.Exit(exitFatal)
+	}
+	if *maxDepth < 0 {
+		fmt.Fprintf(os.Stderr, "-max-depth must not be negative, got %d\n", *maxDepth)
+		os.Exit(exitFatal)
+	}
+	if *preset != "" && *preset != "firebase" {
+		fmt.Fprintf(os.Stderr, "Unknown preset %q (expected firebase)\n", *preset)
+		os.Exit(exitFatal)
+	}
+	switch *items {
+	case "", itemsJSON:
+	case itemsRows:
+		if *explode != "" {
+			fmt.Fprintln(os.Stderr, "-items rows explodes items, so it cannot be combined with -explode")
+			os.Exit(exitFatal)
+		}
+		*explode = "items"
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown items handling %q (expected rows or json)\n", *items)
+		os.Exit(exitFatal)
+	}
+	exploder, err := parseExplode(*explode)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	longLayout, err := parseLong(*long, *eventID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	if longLayout != nil && (exploder != nil || *preset != "" || *arrays != arraysJSON || *items != "") {
+		fmt.Fprintln(os.Stderr, "-long has fixed columns, so it cannot be combined with -explode, -items, -preset or -arrays")
+		os.Exit(exitFatal)
+	}
+	var columns []csvColumn
+	if *columnsPath != "" {
+		if longLayout != nil || *singlePass {
+			fmt.Fprintln(os.Stderr, "-columns cannot be combined with -long or -single-pass")
+			os.Exit(exitFatal)
+		}
+		if columns, err = loadColumns(*columnsPath); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(exitFatal)
+		}
+	}
+
+	if *splitByMetric {
+		if *splitOutput.partitionBy, err = metricPartition(*splitOutput.partitionBy); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(exitFatal)
+		}
+		*partitionColumns = true
+	}
+
+	pathTemplate, err := parseOutputTemplate(*outputDir, *records.timeField, *records.timeFormat)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	if pathTemplate != nil {
+		*outputDir = pathTemplate.dir
+	}
+	split, err := splitOutput.limits(*outputDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	partitionBy, err := splitOutput.partitions(*outputDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	// Record fields in the -output template partition the output as
+	// -partition-by does
+	partitioned := len(partitionBy) > 0 || pathTemplate.routes()
+	if *partitionColumns && (!partitioned || longLayout != nil || *singlePass || *columnsPath != "") {
+		fmt.Fprintln(os.Stderr, "-partition-columns needs -partition-by or record fields in an -output template, and cannot be combined with -long, -single-pass or -columns")
+		os.Exit(exitFatal)
+	}
+	if *format == "xlsx" && (longLayout != nil || *singlePass || *columnsPath != "" || partitioned || split.enabled() || *compress != compressNone) {
+		fmt.Fprintln(os.Stderr, "-format xlsx writes one workbook per input, so it cannot be combined with -long, -single-pass, -columns, -partition-by, record fields in -output, -max-records-per-file, -max-file-size or -compress")
+		os.Exit(exitFatal)
+	}
+
+	if *columnTypes && (*format != "csv" || longLayout != nil || *columnsPath != "" || *appendPath != "" || *outputDir == stdioPath || pathTemplate.routes()) {
+		fmt.Fprintln(os.Stderr, "-column-types needs CSV files under -output, and cannot be combined with -format xlsx, -long, -columns, -append or record fields in an -output template")
+		os.Exit(exitFatal)
+	}
+	if *unify && (*inputPath == stdioPath || *format != "csv" || longLayout != nil || *singlePass || *columnsPath != "" || *partitionColumns || *appendPath != "" || *columnTypes || *watch) {
+		fmt.Fprintln(os.Stderr, "-unify-schemas collects the columns of all inputs first, so it cannot be combined with stdin input, -format xlsx, -long, -single-pass, -columns, -partition-columns, -append, -column-types or -watch")
+		os.Exit(exitFatal)
+	}
+	if *skipWritten && (*outputDir == stdioPath || *appendPath != "" || partitioned) {
+		fmt.Fprintln(os.Stderr, "-skip-existing needs an output directory, and cannot be combined with -append, or with -partition-by or record fields in an -output template, whose files depend on the records")
+		os.Exit(exitFatal)
+	}
+	if *manifestPath != "" && (*outputDir == stdioPath || *format != "csv" || *appendPath != "" || *statePath != "" || *watch || *skipWritten) {
+		fmt.Fprintln(os.Stderr, "-manifest lists the CSV files written under -output, so it needs an output directory, and cannot be combined with -format xlsx, -append, -state, -watch or -skip-existing")
+		os.Exit(exitFatal)
+	}
+	if (*records.offset != 0 || *records.count != 0) && *statePath != "" {
+		fmt.Fprintln(os.Stderr, "-offset and -count select records by their position in each input, so they cannot be combined with -state, which converts the blocks added since the last run")
+		os.Exit(exitFatal)
+	}
+	if *appendPath != "" {
+		if *format != "csv" || longLayout != nil || *singlePass || *columnsPath != "" || partitioned || split.enabled() || *compress != compressNone {
+			fmt.Fprintln(os.Stderr, "-append cannot be combined with -format xlsx, -long, -single-pass, -columns, -partition-by, record fields in -output, -max-records-per-file, -max-file-size or -compress")
+			os.Exit(exitFatal)
+		}
+		if *workers != 1 {
+			fmt.Fprintln(os.Stderr, "-append writes every input to one file, so it cannot be combined with -workers")
+			os.Exit(exitFatal)
+		}
+		if *appendPath == stdioPath || isRemotePath(*appendPath) {
+			fmt.Fprintln(os.Stderr, "-append needs a local file")
+			os.Exit(exitFatal)
+		}
+	}
+
+	dialect, err := csvOutput.dialect()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+
+	converter, err := records.converter()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	readerSchema, err := records.schema()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	filter, err := records.recordFilter()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	transform, err := records.recordTransform()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	plugin, err := records.recordPlugin()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	script, err := records.recordScript()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	redact, err := redaction.redactor()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	epoch, err := records.epochFields(converter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	coerce, err := records.coercion()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	join, err := joining.lookupJoin()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	sample, err := records.sampling()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	raw, err := rawInput.input()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	onError, err := records.errorPolicy(*outputDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	if *blockWorkers < 0 {
+		fmt.Fprintf(os.Stderr, "-block-workers must not be negative, got %d\n", *blockWorkers)
+		os.Exit(exitFatal)
+	}
+
+	opts := csvOptions{
+		decode:           decodeOptions{outputDir: *outputDir, compress: *compress, split: split, partitionBy: partitionBy, outputTemplate: pathTemplate, field: *records.field, converter: converter, readerSchema: readerSchema, filter: filter, transform: transform, extendedJSON: records.extendedJSON(converter), epochFields: epoch, coerce: coerce, redact: redact, join: join, plugin: plugin, script: script, explode: exploder, sampling: sample, raw: raw, blockWorkers: *blockWorkers, onError: onError},
+		flatten:          flattener{separator: *separator, indexArrays: *arrays == arraysIndex, maxDepth: *maxDepth, firebase: *preset == "firebase", itemsJSON: *items == itemsJSON},
+		long:             longLayout,
+		singlePass:       *singlePass,
+		columns:          columns,
+		partitionColumns: *partitionColumns,
+		xlsx:             *format == "xlsx",
+		sheetBy:          sheetPath,
+		appendTo:         *appendPath,
+		columnTypes:      *columnTypes,
+		dialect:          dialect,
+	}
+	opts.decode.keepExisting, opts.decode.skipExisting = !*overwrite, *skipWritten
+
+	convert := func(in inputFile) (result fileResult) {
+		opts := opts
+		defer withDeadLetters(in, &opts.decode)(&result)
+		ext := outputExt("csv", *compress)
+		if opts.xlsx {
+			ext = "xlsx"
+		}
+		output := opts.decode.bindOutput(in, ext)
+		if opts.appendTo != "" {
+			output = opts.appendTo
+		}
+		if result, skip := skipExisting(in, output, outputExt("csv", *compress), opts.decode); skip {
+			return result
+		}
+		return runFile(in, output, func() (avroconvert.Stats, error) {
+			switch {
+			case opts.appendTo != "":
+				return appendCSV(in, output, opts)
+			case opts.xlsx:
+				return convertXLSX(in, output, opts)
+			case opts.long != nil:
+				return convertLongCSV(in, output, opts)
+			case opts.columns != nil:
+				return convertPinnedCSV(in, output, opts)
+			case opts.singlePass:
+				return convertCSVSinglePass(in, output, opts)
+			}
+			return convertCSV(in, output, opts)
+		})
+	}
+
+	// CSV columns depend on every record, so grown inputs are converted again,
+	// unless their new rows are appended to one file
+	if *watch {
+		if *summaryPath != "" {
+			fmt.Fprintln(os.Stderr, "-summary-json cannot be combined with -watch, which runs until interrupted")
+			os.Exit(exitFatal)
+		}
+		if *progress {
+			opts.decode.progress = newProgressMeter(nil)
+			opts.decode.progress.start()
+		}
+		runWatch(*inputPath, *statePath, *appendPath != "", *workers, *settle, convert)
+		return
+	}
+	state, inputs := mustPlanState(*statePath, mustExpandInputs(*inputPath), *appendPath != "")
+	if *unify && len(inputs) > 0 {
+		// Avro inputs are read as one schema, unless -reader-schema or -raw
+		// gives it, and then their columns are pinned as with -columns
+		if readerSchema == nil && raw == nil {
+			if opts.decode.readerSchema, err = unifiedSchema(inputs); err != nil {
+				slog.Error("Cannot unify schemas", "error", err)
+				os.Exit(exitFatal)
+			}
+		}
+		if opts.columns, err = unifiedColumns(inputs, opts); err != nil {
+			slog.Error("Cannot collect columns", "error", err)
+			os.Exit(exitFatal)
+		}
+	}
+	if opts.decode.manifest, err = newOutputManifest(*manifestPath, *outputDir); err != nil {
+		slog.Error("Cannot start manifest", "error", err)
+		os.Exit(exitFatal)
+	}
+	if *progress {
+		opts.decode.progress = newProgressMeter(inputs)
+	}
+	runBatch(inputs, *outputDir, *workers, opts.decode.progress, *summaryPath, opts.decode.manifest, state.track(convert))
+}
+
+// convertCSV converts an Avro input to CSV in two passes: the first collects
+// the union of flattened column names across all records, the second writes
+// the rows. Stdin is spooled to a temporary file so it can be read twice.
+func convertCSV(in inputFile, output string, opts csvOptions) (avroconvert.Stats, error) {
+	path, cleanup, err := spoolInput(in.path)
+	if err != nil {
+		return avroconvert.Stats{}, err
+	}
+	defer cleanup()
+	opts.decode.blocks = in.blocks
+
+	// Pass 1: discover columns, of each partition with -partition-columns
+	columns := newColumnCollector(opts.flatten)
+	var sink avroconvert.Sink = columns
+	var partitions *partitionColumns
+	if opts.partitionColumns {
+		partitions = newPartitionColumns(output, opts)
+		sink = partitions
+	}
+	stats, err := decodeFile(path, in.path, opts.decode, sink)
+	if err != nil {
+		return stats, err
+	}
+
+	// Pass 2: write rows, without repeating the warnings from pass 1. Each
+	// part or partition of the output gets the header
+	split := openPartitionedOutputSet(output, outputExt("csv", opts.decode.compress), opts.decode, func(partition string) func(w io.Writer) (avroconvert.Sink, error) {
+		names := columns.names
+		if partitions != nil {
+			names = partitions.columns[partition].names
+		}
+		return func(w io.Writer) (avroconvert.Sink, error) {
+			rows := newCSVRowWriter(w, names, opts.flatten, opts.dialect)
+			if err := rows.writeHeader(); err != nil {
+				return nil, fmt.Errorf("cannot write output file: %w", err)
+			}
+			return rows, nil
+		}
+	})
+	defer split.abort()
+
+	quiet := opts.decode
+	quiet.quiet = true
+	if _, err := decodeFile(path, in.path, quiet, split); err != nil {
+		return stats, err
+	}
+
+	if err := split.Close(); err != nil {
+		return stats, err
+	}
+
+	if partitions != nil {
+		columns = partitions.union()
+	}
+	if err := opts.writeColumnTypes(output, columns); err != nil {
+		return stats, err
+	}
+	slog.Info("Wrote CSV rows", "input", in.path, "rows", stats.Messages, "columns", len(columns.names), "output", split.written(), filteredAttr(stats))
+	return stats, nil
+}
+
+// partitionColumns collects the columns of each partition of an output
+// separately, by the partition's output path.
+type partitionColumns struct {
+	partitions *partitionWriter // names the partitions; nothing is written to it
+	flatten    flattener
+	columns    map[string]*columnCollector
+	order      []string
+}
+
+func newPartitionColumns(output string, opts csvOptions) *partitionColumns {
+	return &partitionColumns{
+		partitions: newPartitionWriter(output, opts.decode.partitionBy, opts.decode.outputTemplate, opts.decode.converter, nil),
+		flatten:    opts.flatten,
+		columns:    make(map[string]*columnCollector),
+	}
+}
+
+func (pc *partitionColumns) WriteRecord(msg json.RawMessage) error {
+	v, err := parseMessage(msg)
+	if err != nil {
+		return err
+	}
+	path := pc.partitions.path(v)
+	columns, ok := pc.columns[path]
+	if !ok {
+		columns = newColumnCollector(pc.flatten)
+		pc.columns[path] = columns
+		pc.order = append(pc.order, path)
+	}
+	return columns.WriteRecord(msg)
+}
+
+func (pc *partitionColumns) Flush() error {
+	return nil
+}
+
+func (pc *partitionColumns) Close() error {
+	return nil
+}
+
+// union returns the columns of all partitions together.
+func (pc *partitionColumns) union() *columnCollector {
+	all := newColumnCollector(pc.flatten)
+	for _, path := range pc.order {
+		columns := pc.columns[path]
+		for _, name := range columns.names {
+			if !all.seen[name] {
+				all.seen[name] = true
+				all.names = append(all.names, name)
+			}
+			all.types[name] = widenType(all.types[name], columns.types[name])
+		}
+	}
+	return all
+}
+
+// writeColumnTypes writes the columns of an output with their types to
+// e.g. events.columns.yaml for events.csv.gz, with -column-types.
+func (opts csvOptions) writeColumnTypes(output string, columns *columnCollector) error {
+	if !opts.columnTypes {
+		return nil
+	}
+	path := strings.TrimSuffix(output, "."+outputExt("csv", opts.decode.compress)) + ".columns.yaml"
+	return writeColumnTypes(path, columns.names, columns.types)
+}
+
+// convertCSVSinglePass converts an input to CSV decoding it only once: the
+// messages are spilled to a zstd-compressed temporary file while the
+// columns are collected, and the rows are written from the spill. Stdin and
+// remote inputs are read as they stream in, without spooling them.
+func convertCSVSinglePass(in inputFile, output string, opts csvOptions) (avroconvert.Stats, error) {
+	opts.decode.blocks = in.blocks
+	input, err := openDecodeInput(in.path, opts.decode)
+	if err != nil {
+		return avroconvert.Stats{}, err
+	}
+	defer input.Close()
+
+	spill, err := newMessageSpill(opts.flatten)
+	if err != nil {
+		return avroconvert.Stats{}, err
+	}
+	defer spill.remove()
+	stats, err := decodeMessages(input, in.path, opts.decode, spill)
+	if err == nil {
+		err = spill.Close()
+	}
+	if err != nil {
+		return stats, err
+	}
+
+	split := openOutputSet(output, outputExt("csv", opts.decode.compress), opts.decode, func(w io.Writer) (avroconvert.Sink, error) {
+		rows := newCSVRowWriter(w, spill.columns.names, opts.flatten, opts.dialect)
+		if err := rows.writeHeader(); err != nil {
+			return nil, fmt.Errorf("cannot write output file: %w", err)
+		}
+		return rows, nil
+	})
+	defer split.abort()
+	if err := spill.replay(split); err != nil {
+		return stats, err
+	}
+	if err := split.Close(); err != nil {
+		return stats, err
+	}
+	if err := opts.writeColumnTypes(output, spill.columns); err != nil {
+		return stats, err
+	}
+
+	slog.Info("Wrote CSV rows", "input", in.path, "rows", stats.Messages, "columns", len(spill.columns.names), "output", split.written(), filteredAttr(stats))
+	return stats, nil
+}
+
+// messageSpill keeps messages in a temporary NDJSON file, collecting their
+// columns as they are written.
+type messageSpill struct {
+	columns *columnCollector
+	file    *os.File
+	w       io.WriteCloser // compresses into file
+	bw      *bufio.Writer
+	line    bytes.Buffer
+}
+
+func newMessageSpill(flatten flattener) (*messageSpill, error) {
+	file, err := os.CreateTemp("", "avroparser-rows-*.ndjson.zst")
+	if err != nil {
+		return nil, fmt.Errorf("cannot create spill file: %w", err)
+	}
+	w, err := compressOutput(file, compressZstd)
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return &messageSpill{columns: newColumnCollector(flatten), file: file, w: w, bw: bufio.NewWriter(w)}, nil
+}
+
+func (ms *messageSpill) WriteRecord(msg json.RawMessage) error {
+	if err := ms.columns.WriteRecord(msg); err != nil {
+		return err
+	}
+	// Messages extracted with -field may span lines
+	ms.line.Reset()
+	if err := json.Compact(&ms.line, msg); err != nil {
+		return err
+	}
+	ms.line.WriteByte('\n')
+	if _, err := ms.bw.Write(ms.line.Bytes()); err != nil {
+		return fmt.Errorf("cannot write spill file: %w", err)
+	}
+	return nil
+}
+
+func (ms *messageSpill) Flush() error {
+	return nil
+}
+
+// Close finishes the spill file, so it can be replayed.
+func (ms *messageSpill) Close() error {
+	if err := ms.bw.Flush(); err != nil {
+		ms.w.Close()
+		return fmt.Errorf("cannot write spill file: %w", err)
+	}
+	if err := ms.w.Close(); err != nil {
+		return fmt.Errorf("cannot write spill file: %w", err)
+	}
+	return nil
+}
+
+// replay writes the spilled messages to sink.
+func (ms *messageSpill) replay(sink avroconvert.Sink) error {
+	r, err := openInput(ms.file.Name())
+	if err != nil {
+		return fmt.Errorf("cannot read spill file: %w", err)
+	}
+	defer r.Close()
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 1 {
+			if err := sink.WriteRecord(line[:len(line)-1]); err != nil {
+				return fmt.Errorf("cannot write message: %w", err)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("cannot read spill file: %w", err)
+		}
+	}
+}
+
+// remove deletes the spill file.
+func (ms *messageSpill) remove() {
+	ms.file.Close()
+	os.Remove(ms.file.Name())
+}
+
+// decodeFile runs decodeMessages over a file on disk. name identifies the
+// original input in warnings.
+func decodeFile(path, name string, opts decodeOptions, writer avroconvert.Sink) (avroconvert.Stats, error) {
+	input, err := openDecodeInput(path, opts)
+	if err != nil {
+		return avroconvert.Stats{}, err
+	}
+	defer input.Close()
+	return decodeMessages(input, name, opts, writer)
+}
+
+// spoolInput returns a local path that can be opened more than once. Local
+// files are returned as-is; stdin and remote inputs are copied to a
+// temporary file, which cleanup removes.
+func spoolInput(path string) (string, func(), error) {
+	if path != stdioPath && !isRemotePath(path) {
+		return path, func() {}, nil
+	}
+
+	input, err := openInput(path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer input.Close()
+
+	tmp, err := os.CreateTemp("", "avroparser-spool-*.avro")
+	if err != nil {
+		return "", nil, fmt.Errorf("cannot spool input: %w", err)
+	}
+	cleanup := func() { os.Remove(tmp.Name()) }
+
+	if _, err := io.Copy(tmp, input); err != nil {
+		tmp.Close()
+		cleanup()
+		return "", nil, fmt.Errorf("cannot spool input: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("cannot spool input: %w", err)
+	}
+	return tmp.Name(), cleanup, nil
+}
+
+// columnCollector records flattened column names in first-seen order, and
+// the type of each column's values.
+type columnCollector struct {
+	flatten flattener
+	names   []string
+	seen    map[string]bool
+	types   map[string]string // widened over every value; "" while only null
+}
+
+func newColumnCollector(flatten flattener) *columnCollector {
+	return &columnCollector{flatten: flatten, seen: make(map[string]bool), types: make(map[string]string)}
+}
+
+func (cc *columnCollector) WriteRecord(msg json.RawMessage) error {
+	v, err := parseMessage(msg)
+	if err != nil {
+		return err
+	}
+	for _, f := range cc.flatten.flatten(v) {
+		if !cc.seen[f.name] {
+			cc.seen[f.name] = true
+			cc.names = append(cc.names, f.name)
+		}
+		cc.types[f.name] = widenType(cc.types[f.name], valueType(f.value))
+	}
+	return nil
+}
+
+func (cc *columnCollector) Flush() error {
+	return nil
+}
+
+func (cc *columnCollector) Close() error {
+	return nil
+}
+
+// csvRowWriter writes each message as a CSV row with a fixed set of columns.
+type csvRowWriter struct {
+	w       *csvWriter
+	columns []string
+	index   map[string]int
+	flatten flattener
+	row     []string
+	nulls   []bool // cells of the row that are null or missing
+}
+
+func newCSVRowWriter(w io.Writer, columns []string, flatten flattener, dialect csvDialect) *csvRowWriter {
+	index := make(map[string]int, len(columns))
+	for i, name := range columns {
+		index[name] = i
+	}
+	return &csvRowWriter{w: newCSVWriter(w, dialect), columns: columns, index: index, flatten: flatten, row: make([]string, len(columns)), nulls: make([]bool, len(columns))}
+}
+
+func (cw *csvRowWriter) writeHeader() error {
+	return cw.w.Write(cw.columns)
+}
+
+func (cw *csvRowWriter) WriteRecord(msg json.RawMessage) error {
+	v, err := parseMessage(msg)
+	if err != nil {
+		return err
+	}
+	cw.fill(v, nil)
+	return cw.w.WriteRow(cw.row, cw.nulls)
+}
+
+// fill sets the row to the flattened fields of a parsed message. unknown,
+// when not nil, is called with the fields that have no column.
+func (cw *csvRowWriter) fill(v interface{}, unknown func(name string)) {
+	for i := range cw.row {
+		cw.row[i] = ""
+		cw.nulls[i] = true
+	}
+	for _, f := range cw.flatten.flatten(v) {
+		if i, ok := cw.index[f.name]; ok {
+			cw.row[i] = csvValue(f.value)
+			cw.nulls[i] = f.value == nil
+		} else if unknown != nil {
+			unknown(f.name)
+		}
+	}
+}
+
+// Flush writes buffered rows through to the underlying writer.
+func (cw *csvRowWriter) Flush() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+func (cw *csvRowWriter) Close() error {
+	return cw.Flush()
+}
+
+// csvValue renders a flattened value as a CSV cell.
+func csvValue(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case json.Number:
+		return t.String()
+	case bool:
+		if t {
+			return "true"
+		}
+		return "false"
+	}
+	return fmt.Sprint(v)
+}
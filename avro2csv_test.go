@@ -0,0 +1,106 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConvertCSV(t *testing.T) {
+	data := writeMessageOCF(t,
+		`{"id":1,"geo":{"country":"DE"}}`,
+		`{"id":2,"note":"a, \"quoted\" note"}`,
+		`{"id":3,"geo":{"country":"FR","city":"Paris"},"tags":[1,2]}`,
+	)
+	in := inputFile{path: writeTestFile(t, "events.avro", data), rel: "events.avro"}
+	output := filepath.Join(t.TempDir(), "events.csv")
+	opts := csvOptions{decode: testOptions(t, "message"), flatten: flattener{separator: "."}}
+
+	stats, err := convertCSV(in, output, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "geo.country,id,note,geo.city,tags\n" +
+		"DE,1,,,\n" +
+		",2,\"a, \"\"quoted\"\" note\",,\n" +
+		"FR,3,,Paris,\"[1,2]\"\n"
+	if string(got) != want || stats.Messages != 3 {
+		t.Fatalf("wrote %q (%+v), want %q", got, stats, want)
+	}
+}
+
+func TestConvertCSVSinglePass(t *testing.T) {
+	data := writeMessageOCF(t,
+		`{"id":1,"geo":{"country":"DE"}}`,
+		"{\"id\":2,\n\"note\":\"a, \\\"quoted\\\" note\"}",
+		`{"id":3,"geo":{"country":"FR","city":"Paris"},"tags":[1,2]}`,
+	)
+	in := inputFile{path: writeTestFile(t, "events.avro", data), rel: "events.avro"}
+	opts := csvOptions{decode: testOptions(t, "message"), flatten: flattener{separator: "."}}
+
+	twoPass := filepath.Join(t.TempDir(), "events.csv")
+	if _, err := convertCSV(in, twoPass, opts); err != nil {
+		t.Fatal(err)
+	}
+	opts.singlePass = true
+	onePass := filepath.Join(t.TempDir(), "events.csv")
+	stats, err := convertCSVSinglePass(in, onePass, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile(twoPass)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(onePass)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) || stats.Messages != 3 {
+		t.Fatalf("single pass wrote %q (%+v), want %q", got, stats, want)
+	}
+}
+
+func TestConvertCSVPartitionColumns(t *testing.T) {
+	data := writeMessageOCF(t,
+		`{"metric_name":"fps","value":60}`,
+		`{"metric_name":"latency","ms":12,"region":"eu"}`,
+		`{"metric_name":"fps","value":58,"scene":"menu"}`,
+	)
+	in := inputFile{path: writeTestFile(t, "metrics.avro", data), rel: "metrics.avro"}
+	dir := t.TempDir()
+	output := filepath.Join(dir, "metrics.csv")
+	opts := csvOptions{decode: testOptions(t, "message"), flatten: flattener{separator: "."}, partitionColumns: true}
+	opts.decode.outputDir, opts.decode.partitionBy = dir, []string{"metric_name"}
+
+	if _, err := convertCSV(in, output, opts); err != nil {
+		t.Fatal(err)
+	}
+	for partition, want := range map[string]string{
+		"metric_name=fps":     "metric_name,value,scene\nfps,60,\nfps,58,menu\n",
+		"metric_name=latency": "metric_name,ms,region\nlatency,12,eu\n",
+	} {
+		got, err := os.ReadFile(filepath.Join(dir, partition, "metrics.csv"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s: wrote %q, want %q", partition, got, want)
+		}
+	}
+}
+
+func TestMetricPartition(t *testing.T) {
+	for _, partitionBy := range []string{"", "metric_name"} {
+		if got, err := metricPartition(partitionBy); got != "metric_name" || err != nil {
+			t.Errorf("-partition-by %q: %q, %v", partitionBy, got, err)
+		}
+	}
+	if _, err := metricPartition("event_name"); err == nil {
+		t.Error("combined -split-by-metric with -partition-by event_name")
+	}
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// awsRefreshWindow is how long before they expire temporary credentials
+// are fetched again.
+const awsRefreshWindow = 5 * time.Minute
+
+// awsAuth provides the credentials S3 requests are signed with.
+var awsAuth = &awsCredentials{}
+
+// awsKeys is a set of AWS credentials. expiry is zero for long-term keys.
+type awsKeys struct {
+	accessKey string
+	secretKey string
+	token     string
+	expiry    time.Time
+}
+
+type awsCredentials struct {
+	mu       sync.Mutex
+	keys     *awsKeys
+	resolved bool
+}
+
+// credentials returns the AWS credentials found in the same places as the
+// AWS CLI, in its order: the AWS_ACCESS_KEY_ID environment variables, a
+// web identity token (AWS_WEB_IDENTITY_TOKEN_FILE and AWS_ROLE_ARN), the
+// AWS_PROFILE profile of the shared credentials and config files, the ECS
+// or EKS container endpoint, and the EC2 instance metadata service. It
+// returns nil without error if there are none, and reuses temporary
+// credentials until shortly before they expire.
+func (c *awsCredentials) credentials(region string) (*awsKeys, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.resolved && (c.keys == nil || c.keys.expiry.IsZero() || time.Now().Before(c.keys.expiry.Add(-awsRefreshWindow))) {
+		return c.keys, nil
+	}
+	keys, err := resolveAWSCredentials(region)
+	if err != nil {
+		return nil, err
+	}
+	c.keys, c.resolved = keys, true
+	return keys, nil
+}
+
+func resolveAWSCredentials(region string) (*awsKeys, error) {
+	if accessKey := os.Getenv("AWS_ACCESS_KEY_ID"); accessKey != "" {
+		return &awsKeys{
+			accessKey: accessKey,
+			secretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			token:     os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	}
+
+	if tokenFile, role := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"), os.Getenv("AWS_ROLE_ARN"); tokenFile != "" && role != "" {
+		keys, err := webIdentityCredentials(region, tokenFile, role)
+		if err != nil {
+			return nil, fmt.Errorf("cannot assume role %s with web identity: %w", role, err)
+		}
+		return keys, nil
+	}
+
+	if keys, err := profileCredentials(); keys != nil || err != nil {
+		return keys, err
+	}
+
+	if keys, err := containerCredentials(); keys != nil || err != nil {
+		return keys, err
+	}
+
+	if !strings.EqualFold(os.Getenv("AWS_EC2_METADATA_DISABLED"), "true") {
+		if keys, err := instanceCredentials(); err == nil {
+			return keys, nil
+		}
+	}
+	return nil, nil
+}
+
+// awsProfile returns the name of the shared config profile in use.
+func awsProfile() string {
+	if profile := os.Getenv("AWS_PROFILE"); profile != "" {
+		return profile
+	}
+	return "default"
+}
+
+// awsConfigPath returns the shared credentials or config file named by an
+// environment variable, or its default under ~/.aws.
+func awsConfigPath(env, name string) string {
+	if p := os.Getenv(env); p != "" {
+		return p
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".aws", name)
+}
+
+// readAWSConfig returns the settings of a section of a shared credentials
+// or config file, or nil if the file or section doesn't exist.
+func readAWSConfig(p, section string) (map[string]string, error) {
+	if p == "" {
+		return nil, nil
+	}
+	f, err := os.Open(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var settings map[string]string
+	inSection := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || line[0] == '#' || line[0] == ';':
+		case line[0] == '[':
+			name := strings.TrimSpace(strings.Trim(line, "[]"))
+			inSection = name == section
+			if inSection && settings == nil {
+				settings = map[string]string{}
+			}
+		case inSection:
+			if key, value, ok := strings.Cut(line, "="); ok {
+				settings[strings.TrimSpace(key)] = strings.TrimSpace(value)
+			}
+		}
+	}
+	return settings, scanner.Err()
+}
+
+// profileSettings returns the settings of the current profile, those of the
+// shared credentials file taking precedence over the config file.
+func profileSettings() (map[string]string, error) {
+	profile := awsProfile()
+	configSection := "profile " + profile
+	if profile == "default" {
+		configSection = "default"
+	}
+	config, err := readAWSConfig(awsConfigPath("AWS_CONFIG_FILE", "config"), configSection)
+	if err != nil {
+		return nil, err
+	}
+	credentials, err := readAWSConfig(awsConfigPath("AWS_SHARED_CREDENTIALS_FILE", "credentials"), profile)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return credentials, nil
+	}
+	for key, value := range credentials {
+		config[key] = value
+	}
+	return config, nil
+}
+
+// profileRegion returns the region of the current profile, if it has one.
+func profileRegion() string {
+	settings, _ := profileSettings()
+	return settings["region"]
+}
+
+// profileCredentials returns the keys of the current profile, or those
+// printed by its credential_process.
+func profileCredentials() (*awsKeys, error) {
+	settings, err := profileSettings()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read AWS profile %s: %w", awsProfile(), err)
+	}
+	if accessKey := settings["aws_access_key_id"]; accessKey != "" {
+		return &awsKeys{
+			accessKey: accessKey,
+			secretKey: settings["aws_secret_access_key"],
+			token:     settings["aws_session_token"],
+		}, nil
+	}
+	if process := settings["credential_process"]; process != "" {
+		keys, err := processCredentials(process)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get credentials for AWS profile %s: %w", awsProfile(), err)
+		}
+		return keys, nil
+	}
+	if os.Getenv("AWS_PROFILE") == "" {
+		return nil, nil
+	}
+	if settings == nil {
+		return nil, fmt.Errorf("AWS profile %s not found", awsProfile())
+	}
+	return nil, fmt.Errorf("AWS profile %s has no credentials this tool can use", awsProfile())
+}
+
+// roleCredentials is the JSON form of temporary credentials returned by
+// credential processes and the container and instance endpoints.
+type roleCredentials struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	SessionToken    string `json:"SessionToken"`
+	Token           string `json:"Token"`
+	Expiration      string `json:"Expiration"`
+}
+
+func (rc *roleCredentials) keys() (*awsKeys, error) {
+	if rc.AccessKeyID == "" || rc.SecretAccessKey == "" {
+		return nil, errors.New("response has no access key")
+	}
+	keys := &awsKeys{accessKey: rc.AccessKeyID, secretKey: rc.SecretAccessKey, token: rc.SessionToken}
+	if keys.token == "" {
+		keys.token = rc.Token
+	}
+	if rc.Expiration != "" {
+		expiry, err := time.Parse(time.RFC3339, rc.Expiration)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expiration %q: %w", rc.Expiration, err)
+		}
+		keys.expiry = expiry
+	}
+	return keys, nil
+}
+
+// processCredentials runs a profile's credential_process command.
+func processCredentials(command string) (*awsKeys, error) {
+	cmd := exec.Command("sh", "-c", command)
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	}
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	var rc roleCredentials
+	if err := json.Unmarshal(out, &rc); err != nil {
+		return nil, err
+	}
+	return rc.keys()
+}
+
+// webIdentityCredentials exchanges the token in a file, such as an EKS
+// service account token, for temporary credentials of a role.
+func webIdentityCredentials(region, tokenFile, role string) (*awsKeys, error) {
+	token, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return nil, err
+	}
+	session := os.Getenv("AWS_ROLE_SESSION_NAME")
+	if session == "" {
+		session = fmt.Sprintf("avroparser-%d", time.Now().Unix())
+	}
+	form := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {role},
+		"RoleSessionName":  {session},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	}
+	resp, err := http.PostForm(fmt.Sprintf("https://sts.%s.amazonaws.com/", region), form)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Credentials struct {
+			AccessKeyID     string `xml:"AccessKeyId"`
+			SecretAccessKey string `xml:"SecretAccessKey"`
+			SessionToken    string `xml:"SessionToken"`
+			Expiration      string `xml:"Expiration"`
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+		Message string `xml:"Error>Message"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("STS returned %s: %w", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("STS returned %s: %s", resp.Status, result.Message)
+	}
+	rc := roleCredentials{
+		AccessKeyID:     result.Credentials.AccessKeyID,
+		SecretAccessKey: result.Credentials.SecretAccessKey,
+		SessionToken:    result.Credentials.SessionToken,
+		Expiration:      result.Credentials.Expiration,
+	}
+	return rc.keys()
+}
+
+// containerCredentials fetches the task role credentials of ECS tasks, or
+// the pod identity credentials on EKS.
+func containerCredentials() (*awsKeys, error) {
+	endpoint := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+	if uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); uri != "" {
+		endpoint = "http://169.254.170.2" + uri
+	}
+	if endpoint == "" {
+		return nil, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	authorization := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+	if p := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"); p != "" {
+		token, err := os.ReadFile(p)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read container authorization token: %w", err)
+		}
+		authorization = strings.TrimSpace(string(token))
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	var rc roleCredentials
+	if err := getMetadataJSON(req, &rc); err != nil {
+		return nil, fmt.Errorf("cannot get container credentials: %w", err)
+	}
+	return rc.keys()
+}
+
+// imdsEndpoint is the EC2 instance metadata service.
+const imdsEndpoint = "http://169.254.169.254"
+
+// instanceCredentials fetches the credentials of the instance profile role
+// from the EC2 instance metadata service, using IMDSv2 session tokens.
+func instanceCredentials() (*awsKeys, error) {
+	req, err := http.NewRequest(http.MethodPut, imdsEndpoint+"/latest/api/token", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Aws-Ec2-Metadata-Token-Ttl-Seconds", "21600")
+	token, err := getMetadata(req)
+	if err != nil {
+		return nil, err
+	}
+
+	const credentialsPath = "/latest/meta-data/iam/security-credentials/"
+	req, err = http.NewRequest(http.MethodGet, imdsEndpoint+credentialsPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Aws-Ec2-Metadata-Token", string(token))
+	roles, err := getMetadata(req)
+	if err != nil {
+		return nil, err
+	}
+	role, _, _ := strings.Cut(strings.TrimSpace(string(roles)), "\n")
+	if role == "" {
+		return nil, errors.New("the instance has no role")
+	}
+
+	req, err = http.NewRequest(http.MethodGet, imdsEndpoint+credentialsPath+role, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Aws-Ec2-Metadata-Token", string(token))
+	var rc roleCredentials
+	if err := getMetadataJSON(req, &rc); err != nil {
+		return nil, err
+	}
+	return rc.keys()
+}
+
+// getMetadata sends a request to a metadata endpoint, giving up quickly
+// off AWS, and returns the response body.
+func getMetadata(req *http.Request) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("metadata endpoint returned %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func getMetadataJSON(req *http.Request, v interface{}) error {
+	body, err := getMetadata(req)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
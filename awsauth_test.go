@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// isolateAWS clears the environment AWS credentials are found in, so only
+// what a test sets is used.
+func isolateAWS(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, env := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_WEB_IDENTITY_TOKEN_FILE", "AWS_ROLE_ARN", "AWS_PROFILE",
+		"AWS_CONTAINER_CREDENTIALS_FULL_URI", "AWS_CONTAINER_CREDENTIALS_RELATIVE_URI", "AWS_CONTAINER_AUTHORIZATION_TOKEN", "AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"} {
+		t.Setenv(env, "")
+	}
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	return dir
+}
+
+func TestProfileCredentials(t *testing.T) {
+	dir := isolateAWS(t)
+	os.WriteFile(filepath.Join(dir, "config"), []byte("[default]\nregion = eu-west-1\n\n[profile ci]\nregion = us-west-2\naws_access_key_id = CONFIGKEY\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "credentials"), []byte("# keys\n[ci]\naws_access_key_id = AKID\naws_secret_access_key = secret\n[other]\naws_access_key_id = OTHER\n"), 0o644)
+
+	if keys, err := resolveAWSCredentials("us-east-1"); keys != nil || err != nil {
+		t.Fatalf("the default profile without keys resolved %+v, %v", keys, err)
+	}
+	if region := profileRegion(); region != "eu-west-1" {
+		t.Errorf("default profile region %q", region)
+	}
+
+	// The credentials file takes precedence over the config file
+	t.Setenv("AWS_PROFILE", "ci")
+	keys, err := resolveAWSCredentials("us-east-1")
+	if err != nil || keys == nil || keys.accessKey != "AKID" || keys.secretKey != "secret" {
+		t.Fatalf("profile ci resolved %+v, %v", keys, err)
+	}
+	if region := profileRegion(); region != "us-west-2" {
+		t.Errorf("profile ci region %q", region)
+	}
+
+	t.Setenv("AWS_PROFILE", "missing")
+	if _, err := resolveAWSCredentials("us-east-1"); err == nil {
+		t.Error("resolved a missing profile")
+	}
+
+	// The environment comes first
+	t.Setenv("AWS_ACCESS_KEY_ID", "ENVKEY")
+	if keys, err := resolveAWSCredentials("us-east-1"); err != nil || keys.accessKey != "ENVKEY" {
+		t.Errorf("resolved %+v, %v with keys in the environment", keys, err)
+	}
+}
+
+func TestProcessCredentials(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the credential process is a shell command")
+	}
+	dir := isolateAWS(t)
+	os.WriteFile(filepath.Join(dir, "config"), []byte(`[default]
+credential_process = echo '{"Version":1,"AccessKeyId":"PROC","SecretAccessKey":"s","SessionToken":"tok","Expiration":"2030-01-02T03:04:05Z"}'
+`), 0o644)
+	keys, err := resolveAWSCredentials("us-east-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys.accessKey != "PROC" || keys.token != "tok" || !keys.expiry.Equal(time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Errorf("resolved %+v", keys)
+	}
+}
+
+func TestContainerCredentials(t *testing.T) {
+	isolateAWS(t)
+	fetches := 0
+	expiry := time.Now().Add(3 * time.Minute).UTC()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		if r.Header.Get("Authorization") != "pod-token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(roleCredentials{AccessKeyID: "TASK", SecretAccessKey: "s", Token: "tok", Expiration: expiry.Format(time.RFC3339)})
+	}))
+	defer server.Close()
+	t.Setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", server.URL+"/creds")
+	t.Setenv("AWS_CONTAINER_AUTHORIZATION_TOKEN", "pod-token")
+
+	var c awsCredentials
+	keys, err := c.credentials("us-east-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys.accessKey != "TASK" || keys.token != "tok" {
+		t.Errorf("resolved %+v", keys)
+	}
+	// Credentials expiring within the refresh window are fetched again
+	if _, err := c.credentials("us-east-1"); err != nil || fetches != 2 {
+		t.Errorf("fetched %d times, %v", fetches, err)
+	}
+
+	t.Setenv("AWS_CONTAINER_AUTHORIZATION_TOKEN", "wrong")
+	if _, err := containerCredentials(); err == nil {
+		t.Error("resolved credentials the endpoint refused")
+	}
+}
+
+func TestAWSCredentialsCached(t *testing.T) {
+	isolateAWS(t)
+	t.Setenv("AWS_ACCESS_KEY_ID", "FIRST")
+	var c awsCredentials
+	if keys, err := c.credentials("us-east-1"); err != nil || keys.accessKey != "FIRST" {
+		t.Fatalf("resolved %+v, %v", keys, err)
+	}
+	// Long-term keys are kept for the run
+	t.Setenv("AWS_ACCESS_KEY_ID", "SECOND")
+	if keys, err := c.credentials("us-east-1"); err != nil || keys.accessKey != "FIRST" {
+		t.Errorf("resolved %+v, %v again", keys, err)
+	}
+}
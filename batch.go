@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"avroparser/pkg/avroconvert"
+)
+
+// fileResult records the outcome of converting one input file.
+type fileResult struct {
+	input    inputFile
+	output   string
+	stats    avroconvert.Stats
+	duration time.Duration
+	err      error
+	skipped  bool // the output existed, with -skip-existing
+}
+
+// runBatch converts every input with a pool of workers, reports failures
+// and, for more than one input or with -progress, a summary table, and
+// writes the run summary to summaryPath if set. The manifest, if set, is
+// only written when every input was converted. It exits with the code of
+// batchExitCode.
+func runBatch(inputs []inputFile, outputDir string, workers int, progress *progressMeter, summaryPath string, manifest *outputManifest, convert func(inputFile) fileResult) {
+	if workers < 1 {
+		fmt.Fprintf(os.Stderr, "-workers must be at least 1, got %d\n", workers)
+		os.Exit(exitFatal)
+	}
+
+	// Concurrent writers would interleave their output on stdout
+	if outputDir == stdioPath {
+		workers = 1
+	}
+
+	if progress != nil {
+		progress.start()
+	}
+	start := time.Now()
+	results := convertAll(inputs, workers, convert)
+	wall := time.Since(start)
+	if progress != nil {
+		progress.stop()
+	}
+
+	for _, result := range results {
+		switch {
+		case result.err != nil:
+			slog.Error("Cannot convert input", "input", result.input.path, "error", result.err)
+		case result.stats.Failures[avroconvert.ReasonUnreadTail] > 0:
+			slog.Error("Input was only converted up to where it cannot be read", "input", result.input.path, "records", result.stats.Read)
+		}
+	}
+
+	if len(inputs) > 1 || progress != nil {
+		printSummary(results, wall)
+	}
+
+	code := batchExitCode(results)
+	if manifest != nil {
+		if code == exitOK {
+			if err := manifest.write(); err != nil {
+				slog.Error("Cannot write manifest", "path", manifest.path, "error", err)
+				code = exitPartial
+			}
+		} else {
+			slog.Warn("Not writing the manifest, since some inputs failed", "path", manifest.path)
+		}
+	}
+	if summaryPath != "" {
+		if err := writeRunSummary(summaryPath, results, start, wall, code); err != nil {
+			slog.Error("Cannot write run summary", "path", summaryPath, "error", err)
+			code = max(code, exitPartial)
+		}
+	}
+	if code != exitOK {
+		os.Exit(code)
+	}
+}
+
+// batchExitCode returns exitPartial if some inputs failed or could only
+// be read in part, and exitFatal if all of them failed, unless those were
+// only missing rows a database rejected while writing the rest.
+func batchExitCode(results []fileResult) int {
+	failed, rejected, unread := 0, 0, 0
+	for _, result := range results {
+		switch {
+		case result.err != nil:
+			failed++
+			if errors.Is(result.err, errRowsRejected) {
+				rejected++
+			}
+		case result.stats.Failures[avroconvert.ReasonUnreadTail] > 0:
+			unread++
+		}
+	}
+	switch {
+	case failed > 0 && failed == len(results) && rejected < failed:
+		return exitFatal
+	case failed > 0 || unread > 0:
+		return exitPartial
+	}
+	return exitOK
+}
+
+// runFile times a single conversion and wraps its outcome in a fileResult.
+func runFile(in inputFile, output string, convert func() (avroconvert.Stats, error)) fileResult {
+	slog.Debug("Converting input", "input", in.path, "output", output)
+	start := time.Now()
+	result := fileResult{input: in, output: output}
+	result.stats, result.err = convert()
+	result.duration = time.Since(start)
+	return result
+}
+
+// skipExisting returns the result of an input whose output was written
+// before, with -skip-existing, and whether the input is skipped.
+func skipExisting(in inputFile, output, ext string, opts decodeOptions) (fileResult, bool) {
+	if !opts.skipExisting {
+		return fileResult{}, false
+	}
+	written, err := opts.outputWritten(output, ext)
+	if err != nil {
+		return fileResult{input: in, output: output, err: err}, true
+	}
+	if !written {
+		return fileResult{}, false
+	}
+	slog.Info("Skipping input whose output exists", "input", in.path, "output", displayPath(output))
+	return fileResult{input: in, output: output, skipped: true}, true
+}
+
+// convertAll converts every input using a pool of workers and returns the
+// results in input order.
+func convertAll(inputs []inputFile, workers int, convert func(inputFile) fileResult) []fileResult {
+	results := make([]fileResult, len(inputs))
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i] = convert(inputs[i])
+			}
+		}()
+	}
+
+	for i := range inputs {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	return results
+}
+
+// printSummary writes a per-file table and logs the totals. wall is the
+// elapsed time for the whole run. With -log-format json each file is logged
+// as a record instead of a table row.
+func printSummary(results []fileResult, wall time.Duration) {
+	var total avroconvert.Stats
+	var elapsed time.Duration
+	failed := 0
+
+	table := !jsonLogs && slog.Default().Enabled(context.Background(), slog.LevelInfo)
+	tw := tabwriter.NewWriter(os.Stderr, 0, 0, 2, ' ', 0)
+	if table {
+		fmt.Fprintln(tw, "FILE\tMESSAGES\tSKIPPED\tINVALID JSON\tDURATION\tSTATUS")
+	}
+	for _, result := range results {
+		status := "ok"
+		switch {
+		case result.err != nil:
+			status = "failed"
+			failed++
+		case result.skipped:
+			status = "skipped"
+		}
+		if table {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%s\t%s\n", result.input.path, result.stats.Messages,
+				result.stats.Skipped, result.stats.InvalidJSON, result.duration.Round(time.Millisecond), status)
+		} else {
+			slog.Info("Converted file", "input", result.input.path, "messages", result.stats.Messages, "skipped", result.stats.Skipped,
+				"invalid_json", result.stats.InvalidJSON, "duration", result.duration.Round(time.Millisecond), "status", status)
+		}
+
+		total.Add(result.stats)
+		elapsed += result.duration
+	}
+	tw.Flush()
+
+	slog.Info("Converted files", "files", len(results)-failed, "of", len(results), "duration", wall.Round(time.Millisecond),
+		"messages", total.Messages, "skipped", total.Skipped, "invalid_json", total.InvalidJSON, filteredAttr(total),
+		"decode_time", elapsed.Round(time.Millisecond))
+}
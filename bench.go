@@ -0,0 +1,468 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"avroparser/pkg/avroconvert"
+	"github.com/linkedin/goavro/v2"
+)
+
+// benchSchema is the writer schema of the synthetic fixtures: game events
+// with the kinds of values real exports hold, nested records, arrays, maps,
+// unions and logical types.
+const benchSchema = `{
+  "type": "record",
+  "name": "Event",
+  "namespace": "avroparser.bench",
+  "fields": [
+    {"name": "event_name", "type": "string"},
+    {"name": "event_timestamp", "type": {"type": "long", "logicalType": "timestamp-micros"}},
+    {"name": "user_id", "type": ["null", "string"]},
+    {"name": "platform", "type": {"type": "enum", "name": "Platform", "symbols": ["ANDROID", "IOS", "WEB"]}},
+    {"name": "level", "type": "int"},
+    {"name": "score", "type": "double"},
+    {"name": "geo", "type": {"type": "record", "name": "Geo", "fields": [
+      {"name": "country", "type": "string"},
+      {"name": "city", "type": ["null", "string"]}
+    ]}},
+    {"name": "event_params", "type": {"type": "array", "items": {"type": "record", "name": "Param", "fields": [
+      {"name": "key", "type": "string"},
+      {"name": "value", "type": ["null", "string", "long", "double"]}
+    ]}}},
+    {"name": "user_properties", "type": {"type": "map", "values": "string"}},
+    {"name": "message", "type": "bytes"}
+  ]
+}`
+
+// benchOutputs are the output formats bench converts fixtures to.
+var benchOutputs = []string{"json", "ndjson", "csv", "parquet"}
+
+func runBench(args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	records := fs.Int("records", 100000, "Records in each generated fixture")
+	codecs := fs.String("codecs", "null,deflate,snappy,zstd", "Comma-separated block codecs of the container file fixtures: null, deflate, snappy, xz or zstd")
+	outputs := fs.String("outputs", strings.Join(benchOutputs, ","), "Comma-separated output formats to convert the fixtures to: json, ndjson, csv or parquet")
+	ndjsonInput := fs.Bool("ndjson-input", true, "Also measure converting an NDJSON fixture of the same records")
+	runs := fs.Int("runs", 3, "Times each path is run; the fastest run is reported")
+	blockWorkers := fs.Int("block-workers", 0, "Number of blocks of each container file to decompress and decode concurrently (default: one per CPU)")
+	fixtures := fs.String("fixtures", "", "Directory to write the fixtures to and keep them in (default a temporary directory, removed afterwards)")
+	format := fs.String("format", "text", "Report format: text or json")
+	baseline := fs.String("baseline", "", "JSON report of an earlier run to compare against; paths slower by more than -max-slowdown fail the run")
+	maxSlowdown := fs.Float64("max-slowdown", 10, "With -baseline, the percentage by which records per second may drop before a path counts as a regression")
+	logging := addLogFlags(fs)
+	parseFlags(fs, args)
+	logging.setup()
+
+	if *records <= 0 {
+		fmt.Fprintf(os.Stderr, "-records must be positive, got %d\n", *records)
+		os.Exit(exitFatal)
+	}
+	if *runs <= 0 {
+		fmt.Fprintf(os.Stderr, "-runs must be positive, got %d\n", *runs)
+		os.Exit(exitFatal)
+	}
+	if *blockWorkers < 0 {
+		fmt.Fprintf(os.Stderr, "-block-workers must not be negative, got %d\n", *blockWorkers)
+		os.Exit(exitFatal)
+	}
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "Unknown report format %q (expected text or json)\n", *format)
+		os.Exit(exitFatal)
+	}
+	if *maxSlowdown < 0 {
+		fmt.Fprintf(os.Stderr, "-max-slowdown must not be negative, got %g\n", *maxSlowdown)
+		os.Exit(exitFatal)
+	}
+	var codecNames []string
+	for _, codec := range splitFieldList(*codecs) {
+		if _, ok := ocfCodecs[codec]; !ok {
+			fmt.Fprintf(os.Stderr, "Unknown codec %q (expected null, deflate, snappy, xz or zstd)\n", codec)
+			os.Exit(exitFatal)
+		}
+		codecNames = append(codecNames, codec)
+	}
+	outputFormats := splitFieldList(*outputs)
+	for _, output := range outputFormats {
+		switch output {
+		case "json", "ndjson", "csv", "parquet":
+		default:
+			fmt.Fprintf(os.Stderr, "Unknown output format %q (expected json, ndjson, csv or parquet)\n", output)
+			os.Exit(exitFatal)
+		}
+	}
+	var previous *benchReport
+	if *baseline != "" {
+		report, err := readBenchReport(*baseline)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(exitFatal)
+		}
+		previous = report
+	}
+
+	dir := *fixtures
+	if dir == "" {
+		temp, err := os.MkdirTemp("", "avroparser-bench-*")
+		if err != nil {
+			slog.Error("Cannot create fixture directory", "error", err)
+			os.Exit(exitFatal)
+		}
+		defer os.RemoveAll(temp)
+		dir = temp
+	} else if err := os.MkdirAll(dir, 0o755); err != nil {
+		slog.Error("Cannot create fixture directory", "directory", dir, "error", err)
+		os.Exit(exitFatal)
+	}
+
+	converter, err := avroconvert.NewJSONConverter(avroconvert.ConverterOptions{TimeZone: "UTC"})
+	if err != nil {
+		slog.Error("Cannot create converter", "error", err)
+		os.Exit(exitFatal)
+	}
+	opts := decodeOptions{converter: converter, blockWorkers: *blockWorkers, quiet: true}
+
+	paths, err := writeBenchFixtures(dir, *records, codecNames, *ndjsonInput, opts)
+	if err != nil {
+		slog.Error("Cannot write fixtures", "directory", dir, "error", err)
+		os.Exit(exitFatal)
+	}
+
+	report := benchReport{Records: *records, Results: []benchResult{}}
+	for _, fixture := range paths {
+		data, err := os.ReadFile(fixture.path)
+		if err != nil {
+			slog.Error("Cannot read fixture", "fixture", fixture.path, "error", err)
+			os.Exit(exitFatal)
+		}
+		for _, output := range outputFormats {
+			// Parquet columns of schema-less input are only known after a
+			// first pass, which decode spools the input for
+			if fixture.input == "ndjson" && output == "parquet" {
+				continue
+			}
+			result, err := measureBenchPath(data, fixture.input, output, *runs, opts)
+			if err != nil {
+				slog.Error("Cannot convert fixture", "input", fixture.input, "output", output, "error", err)
+				os.Exit(exitFatal)
+			}
+			report.Results = append(report.Results, result)
+		}
+	}
+
+	regressions := 0
+	if previous != nil {
+		regressions = report.compare(previous, *maxSlowdown)
+	}
+	if *format == "json" {
+		err = writeBenchJSON(os.Stdout, report)
+	} else {
+		err = writeBenchText(os.Stdout, report, previous != nil)
+	}
+	if err != nil {
+		slog.Error("Cannot write report", "error", err)
+		os.Exit(exitFatal)
+	}
+	if regressions > 0 {
+		slog.Error("Conversion paths slowed down", "paths", regressions, "max_slowdown_percent", *maxSlowdown)
+		os.Exit(exitPartial)
+	}
+}
+
+// benchFixture is a generated input file and the input kind it stands for,
+// e.g. ocf-zstd or ndjson.
+type benchFixture struct {
+	input string
+	path  string
+}
+
+// writeBenchFixtures writes a container file of the same synthetic records
+// for each codec, and with ndjson the records as NDJSON, converted from the
+// first container file as decode would.
+func writeBenchFixtures(dir string, records int, codecs []string, ndjson bool, opts decodeOptions) ([]benchFixture, error) {
+	var fixtures []benchFixture
+	for _, codec := range codecs {
+		path := filepath.Join(dir, fmt.Sprintf("events-%s.avro", codec))
+		if err := writeBenchOCF(path, records, ocfCodecs[codec]); err != nil {
+			return nil, err
+		}
+		fixtures = append(fixtures, benchFixture{input: "ocf-" + codec, path: path})
+	}
+	if ndjson {
+		source := filepath.Join(dir, "events-null.avro")
+		if len(fixtures) > 0 {
+			source = fixtures[0].path
+		} else if err := writeBenchOCF(source, records, ocfCodecNull); err != nil {
+			return nil, err
+		}
+		path := filepath.Join(dir, "events.ndjson")
+		if err := writeBenchNDJSON(path, source, opts); err != nil {
+			return nil, err
+		}
+		fixtures = append(fixtures, benchFixture{input: "ndjson", path: path})
+	}
+	for _, fixture := range fixtures {
+		if info, err := os.Stat(fixture.path); err == nil {
+			slog.Info("Generated fixture", "fixture", fixture.path, "records", records, "bytes", info.Size())
+		}
+	}
+	return fixtures, nil
+}
+
+// writeBenchOCF writes a container file of synthetic records. The records
+// are the same for every codec, from a fixed seed.
+func writeBenchOCF(path string, records int, codec string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	ow, err := newOCFWriter(w, benchSchema, codec)
+	if err != nil {
+		return err
+	}
+	gen := newBenchRecords()
+	for i := 0; i < records; i++ {
+		if err := ow.Write(gen.next()); err != nil {
+			ow.Close()
+			return fmt.Errorf("cannot encode record: %w", err)
+		}
+	}
+	if err := ow.Close(); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// writeBenchNDJSON converts a container file fixture to NDJSON.
+func writeBenchNDJSON(path, source string, opts decodeOptions) error {
+	data, err := os.ReadFile(source)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	sink := avroconvert.NewNDJSONSink(w)
+	if _, err := decodeMessages(bytes.NewReader(data), source, opts, sink); err != nil {
+		return err
+	}
+	if err := sink.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// benchRecords generates synthetic records as goavro native values.
+type benchRecords struct {
+	rand *rand.Rand
+	time time.Time
+}
+
+func newBenchRecords() *benchRecords {
+	return &benchRecords{rand: rand.New(rand.NewSource(1)), time: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+var (
+	benchEventNames = []string{"session_start", "level_start", "level_complete", "purchase", "ad_impression", "screen_view"}
+	benchPlatforms  = []string{"ANDROID", "IOS", "WEB"}
+	benchCountries  = []string{"US", "DE", "BR", "JP", "IN", "FR", "GB"}
+	benchCities     = []string{"Springfield", "Berlin", "São Paulo", "Tokyo", "Mumbai", "Paris", "London"}
+)
+
+func (br *benchRecords) next() map[string]interface{} {
+	r := br.rand
+	br.time = br.time.Add(time.Duration(r.Intn(5000)) * time.Millisecond)
+
+	var userID, city interface{}
+	if r.Intn(10) > 0 {
+		userID = goavro.Union("string", fmt.Sprintf("user-%08x", r.Intn(1<<20)))
+	}
+	country := r.Intn(len(benchCountries))
+	if r.Intn(4) > 0 {
+		city = goavro.Union("string", benchCities[country])
+	}
+
+	params := make([]interface{}, 2+r.Intn(6))
+	for i := range params {
+		var value interface{}
+		switch i % 4 {
+		case 0:
+			value = goavro.Union("string", fmt.Sprintf("screen_%d", r.Intn(50)))
+		case 1:
+			value = goavro.Union("long", r.Int63n(1000000))
+		case 2:
+			value = goavro.Union("double", r.Float64()*100)
+		}
+		params[i] = map[string]interface{}{"key": fmt.Sprintf("param_%d", i), "value": value}
+	}
+
+	message, _ := json.Marshal(map[string]interface{}{"session": r.Intn(100000), "ok": r.Intn(2) == 0, "tags": []string{"a", "b"}})
+	return map[string]interface{}{
+		"event_name":      benchEventNames[r.Intn(len(benchEventNames))],
+		"event_timestamp": br.time,
+		"user_id":         userID,
+		"platform":        benchPlatforms[r.Intn(len(benchPlatforms))],
+		"level":           int32(r.Intn(200)),
+		"score":           r.Float64() * 10000,
+		"geo":             map[string]interface{}{"country": benchCountries[country], "city": city},
+		"event_params":    params,
+		"user_properties": map[string]interface{}{"tier": fmt.Sprintf("tier-%d", r.Intn(4)), "locale": "en_US"},
+		"message":         message,
+	}
+}
+
+// measureBenchPath converts a fixture to an output format runs times,
+// discarding the output, and returns the fastest run.
+func measureBenchPath(data []byte, input, output string, runs int, opts decodeOptions) (benchResult, error) {
+	result := benchResult{Input: input, Output: output, Bytes: int64(len(data))}
+	var best time.Duration
+	for i := 0; i < runs; i++ {
+		start := time.Now()
+		stats, err := convertBenchFixture(data, output, opts)
+		elapsed := time.Since(start)
+		if err != nil {
+			return result, err
+		}
+		if i == 0 || elapsed < best {
+			best = elapsed
+		}
+		result.Records = stats.Messages
+	}
+	result.Seconds = best.Seconds()
+	if best > 0 {
+		result.RecordsPerSecond = float64(result.Records) / best.Seconds()
+		result.MBPerSecond = float64(result.Bytes) / 1e6 / best.Seconds()
+	}
+	return result, nil
+}
+
+// convertBenchFixture converts a fixture as decode and avro2csv do,
+// writing the output to io.Discard. CSV takes both of avro2csv's passes.
+func convertBenchFixture(data []byte, output string, opts decodeOptions) (avroconvert.Stats, error) {
+	var sink avroconvert.Sink
+	switch output {
+	case "json":
+		sink = avroconvert.NewJSONArraySink(io.Discard, false)
+	case "ndjson":
+		sink = avroconvert.NewNDJSONSink(io.Discard)
+	case "parquet":
+		sink = newParquetNativeWriter(io.Discard, opts.converter)
+	case "csv":
+		flatten := flattener{separator: "."}
+		columns := newColumnCollector(flatten)
+		if _, err := decodeMessages(bytes.NewReader(data), output, opts, columns); err != nil {
+			return avroconvert.Stats{}, err
+		}
+		sink = newCSVRowWriter(io.Discard, columns.names, flatten, csvDialect{delimiter: ',', quote: quoteMinimal})
+	}
+	stats, err := decodeMessages(bytes.NewReader(data), output, opts, sink)
+	if err != nil {
+		return stats, err
+	}
+	return stats, sink.Close()
+}
+
+// benchReport is the result of a bench run, as written with -format json
+// and read back with -baseline.
+type benchReport struct {
+	Records int           `json:"records"`
+	Results []benchResult `json:"results"`
+}
+
+type benchResult struct {
+	Input            string  `json:"input"`
+	Output           string  `json:"output"`
+	Records          int     `json:"records"`
+	Bytes            int64   `json:"bytes"`
+	Seconds          float64 `json:"seconds"`
+	RecordsPerSecond float64 `json:"records_per_second"`
+	MBPerSecond      float64 `json:"mb_per_second"`
+	// Change is the change in records per second from the baseline, in
+	// percent, when a baseline has the same path
+	Change *float64 `json:"change_percent,omitempty"`
+}
+
+func readBenchReport(path string) (*benchReport, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read baseline: %w", err)
+	}
+	var report benchReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("cannot parse baseline %s: %w", path, err)
+	}
+	return &report, nil
+}
+
+// compare sets the change of every path the baseline also measured and
+// returns how many slowed down by more than maxSlowdown percent.
+func (report *benchReport) compare(baseline *benchReport, maxSlowdown float64) int {
+	regressions := 0
+	for i := range report.Results {
+		result := &report.Results[i]
+		for _, before := range baseline.Results {
+			if before.Input != result.Input || before.Output != result.Output || before.RecordsPerSecond <= 0 {
+				continue
+			}
+			change := (result.RecordsPerSecond/before.RecordsPerSecond - 1) * 100
+			result.Change = &change
+			if -change > maxSlowdown {
+				regressions++
+				slog.Warn("Conversion path slowed down", "input", result.Input, "output", result.Output, "records_per_second", int(result.RecordsPerSecond), "baseline", int(before.RecordsPerSecond), "change_percent", fmt.Sprintf("%.1f", change))
+			}
+		}
+	}
+	if report.Records != baseline.Records {
+		slog.Warn("Baseline fixtures have a different number of records", "records", report.Records, "baseline", baseline.Records)
+	}
+	return regressions
+}
+
+func writeBenchText(w io.Writer, report benchReport, compared bool) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	header := "INPUT\tOUTPUT\tRECORDS\tMB\tSECONDS\tRECORDS/S\tMB/S\t"
+	if compared {
+		header += "CHANGE\t"
+	}
+	fmt.Fprintln(tw, header)
+	for _, result := range report.Results {
+		line := fmt.Sprintf("%s\t%s\t%d\t%.1f\t%.3f\t%.0f\t%.1f\t", result.Input, result.Output, result.Records, float64(result.Bytes)/1e6, result.Seconds, result.RecordsPerSecond, result.MBPerSecond)
+		if compared {
+			if result.Change != nil {
+				line += fmt.Sprintf("%+.1f%%\t", *result.Change)
+			} else {
+				line += "-\t"
+			}
+		}
+		fmt.Fprintln(tw, line)
+	}
+	return tw.Flush()
+}
+
+func writeBenchJSON(w io.Writer, report benchReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
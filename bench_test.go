@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"avroparser/pkg/avroconvert"
+)
+
+func TestBenchFixtures(t *testing.T) {
+	const records = 200
+	opts := testOptions(t, "")
+	opts.quiet = true
+	fixtures, err := writeBenchFixtures(t.TempDir(), records, []string{"null", "zstd"}, true, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var inputs []string
+	for _, fixture := range fixtures {
+		inputs = append(inputs, fixture.input)
+	}
+	if got := strings.Join(inputs, ","); got != "ocf-null,ocf-zstd,ndjson" {
+		t.Fatalf("wrote fixtures %s", got)
+	}
+
+	// Every codec holds the same records
+	ndjson, err := os.ReadFile(fixtures[2].path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(ndjson, []byte("\n")); n != records {
+		t.Fatalf("NDJSON fixture has %d records, want %d", n, records)
+	}
+	zstd, err := os.ReadFile(fixtures[1].path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if _, err := decodeMessages(bytes.NewReader(zstd), fixtures[1].path, opts, avroconvert.NewNDJSONSink(&out)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), ndjson) {
+		t.Fatal("the zstd fixture holds other records than the null one")
+	}
+
+	for _, fixture := range fixtures {
+		data, err := os.ReadFile(fixture.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, output := range benchOutputs {
+			if fixture.input == "ndjson" && output == "parquet" {
+				continue
+			}
+			result, err := measureBenchPath(data, fixture.input, output, 2, opts)
+			if err != nil {
+				t.Fatalf("%s to %s: %v", fixture.input, output, err)
+			}
+			if result.Records != records || result.Seconds <= 0 || result.RecordsPerSecond <= 0 {
+				t.Errorf("%s to %s: measured %+v", fixture.input, output, result)
+			}
+		}
+	}
+}
+
+func TestBenchCompare(t *testing.T) {
+	baseline := &benchReport{Records: 100, Results: []benchResult{
+		{Input: "ocf-null", Output: "json", RecordsPerSecond: 1000},
+		{Input: "ocf-null", Output: "csv", RecordsPerSecond: 1000},
+	}}
+	report := benchReport{Records: 100, Results: []benchResult{
+		{Input: "ocf-null", Output: "json", RecordsPerSecond: 950},
+		{Input: "ocf-null", Output: "csv", RecordsPerSecond: 800},
+		{Input: "ndjson", Output: "json", RecordsPerSecond: 500},
+	}}
+	if regressions := report.compare(baseline, 10); regressions != 1 {
+		t.Errorf("found %d regressions, want 1", regressions)
+	}
+	if c := report.Results[0].Change; c == nil || *c > -4.9 || *c < -5.1 {
+		t.Errorf("json change %v, want -5%%", c)
+	}
+	if report.Results[2].Change != nil {
+		t.Error("set the change of a path the baseline didn't measure")
+	}
+
+	// A report read back as a baseline compares equal to itself
+	var buf bytes.Buffer
+	if err := writeBenchJSON(&buf, report); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "baseline.json")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	previous, err := readBenchReport(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if regressions := report.compare(previous, 0); regressions != 0 {
+		t.Errorf("found %d regressions against the same report", regressions)
+	}
+}
+
+// benchTestRecords is the number of records in each fixture of
+// BenchmarkConvertFixtures, fewer than bench's default to keep go test
+// -bench quick.
+const benchTestRecords = 10000
+
+// BenchmarkConvertFixtures converts the synthetic fixtures of the bench
+// subcommand along each of its paths: container files of every codec and
+// NDJSON, to every output format.
+func BenchmarkConvertFixtures(b *testing.B) {
+	converter, err := avroconvert.NewJSONConverter(avroconvert.ConverterOptions{TimeZone: "UTC"})
+	if err != nil {
+		b.Fatal(err)
+	}
+	opts := decodeOptions{converter: converter, quiet: true}
+	fixtures, err := writeBenchFixtures(b.TempDir(), benchTestRecords, []string{"null", "deflate", "snappy", "zstd"}, true, opts)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, fixture := range fixtures {
+		data, err := os.ReadFile(fixture.path)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for _, output := range benchOutputs {
+			// As bench, which doesn't measure this path either
+			if fixture.input == "ndjson" && output == "parquet" {
+				continue
+			}
+			b.Run(fixture.input+"/"+output, func(b *testing.B) {
+				b.ReportAllocs()
+				b.SetBytes(int64(len(data)))
+				for i := 0; i < b.N; i++ {
+					stats, err := convertBenchFixture(data, output, opts)
+					if err != nil {
+						b.Fatal(err)
+					}
+					if stats.Messages != benchTestRecords {
+						b.Fatalf("converted %d records, want %d", stats.Messages, benchTestRecords)
+					}
+				}
+			})
+		}
+	}
+}
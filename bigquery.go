@@ -0,0 +1,1096 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"avroparser/pkg/avroconvert"
+	"cloud.google.com/go/bigquery/storage/apiv1/storagepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// bqScheme prefixes BigQuery tables, e.g. bq://project.dataset.table.
+const bqScheme = "bq://"
+
+func isBigQueryPath(p string) bool {
+	return strings.HasPrefix(p, bqScheme)
+}
+
+// bqTable identifies a BigQuery table.
+type bqTable struct {
+	project, dataset, table string
+}
+
+func parseBigQueryPath(p string) (bqTable, error) {
+	parts := strings.Split(strings.TrimPrefix(p, bqScheme), ".")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return bqTable{}, fmt.Errorf("invalid BigQuery table %q (expected bq://project.dataset.table)", p)
+	}
+	return bqTable{project: parts[0], dataset: parts[1], table: parts[2]}, nil
+}
+
+func (t bqTable) String() string {
+	return t.project + "." + t.dataset + "." + t.table
+}
+
+// Rows are sent in batches of at most this many rows or bytes of JSON,
+// below the 10 MB limit of an AppendRows request.
+const (
+	bqBatchRows  = 500
+	bqBatchBytes = 8 << 20
+)
+
+// bqAttempts is how often a failed request is tried in all, waiting twice
+// as long after each failure, from bqRetryWait.
+const bqAttempts = 5
+
+var bqRetryWait = time.Second
+
+// errRowsRejected is returned for an input some of whose rows BigQuery
+// rejected, while the others were written.
+var errRowsRejected = errors.New("rows rejected")
+
+// bigquery talks to the BigQuery REST API, for tables, and to the Storage
+// Write API, for rows, with the same credentials as Cloud Storage. Set
+// BIGQUERY_EMULATOR_HOST and BIGQUERY_STORAGE_EMULATOR_HOST to use an
+// emulator.
+var bigquery = newBQClient()
+
+type bqClient struct {
+	endpoint string // base URL of the v2 API
+	storage  string // gRPC address of the Storage Write API
+	emulator bool   // emulators don't need credentials
+	http     *http.Client
+
+	connOnce sync.Once
+	conn     *grpc.ClientConn
+	connErr  error
+}
+
+func newBQClient() *bqClient {
+	c := &bqClient{endpoint: "https://bigquery.googleapis.com/bigquery/v2", storage: "bigquerystorage.googleapis.com:443", http: &http.Client{}}
+	if host := os.Getenv("BIGQUERY_EMULATOR_HOST"); host != "" {
+		if !strings.Contains(host, "://") {
+			host = "http://" + host
+		}
+		c.endpoint, c.emulator = strings.TrimSuffix(host, "/"), true
+		c.storage = os.Getenv("BIGQUERY_STORAGE_EMULATOR_HOST")
+	}
+	return c
+}
+
+func (c *bqClient) tableURL(t bqTable) string {
+	return fmt.Sprintf("%s/projects/%s/datasets/%s/tables/%s", c.endpoint, url.PathEscape(t.project), url.PathEscape(t.dataset), url.PathEscape(t.table))
+}
+
+// googleStatusError is an error response of a Google Cloud API.
+type googleStatusError struct {
+	status  int
+	message string
+}
+
+func (e *googleStatusError) Error() string {
+	return e.message
+}
+
+// retryable reports whether a request that failed with err may succeed
+// when tried again.
+func retryable(err error) bool {
+	if st, ok := status.FromError(err); ok && err != nil {
+		switch st.Code() {
+		case codes.Unavailable, codes.ResourceExhausted, codes.Internal, codes.Aborted, codes.DeadlineExceeded:
+			return true
+		}
+		return false
+	}
+	var statusErr *googleStatusError
+	if !errors.As(err, &statusErr) {
+		return true // network errors
+	}
+	switch statusErr.status {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// do sends a JSON request, retrying transient failures, and decodes the
+// response into out unless it is nil.
+func (c *bqClient) do(method, target string, body, out interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	return bqRetry(func() error {
+		return c.send(method, target, payload, out)
+	})
+}
+
+// bqRetry calls try until it succeeds, fails with an error that isn't
+// retryable, or has been tried bqAttempts times.
+func bqRetry(try func() error) error {
+	wait := bqRetryWait
+	for attempt := 1; ; attempt++ {
+		err := try()
+		if err == nil || attempt == bqAttempts || !retryable(err) {
+			return err
+		}
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+func (c *bqClient) send(method, target string, payload []byte, out interface{}) error {
+	req, err := http.NewRequest(method, target, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if !c.emulator {
+		token, err := googleAuth.accessToken()
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		message := resp.Status
+		if json.NewDecoder(resp.Body).Decode(&apiErr) == nil && apiErr.Error.Message != "" {
+			message += ": " + apiErr.Error.Message
+		}
+		return &googleStatusError{status: resp.StatusCode, message: message}
+	}
+	if out == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// bqField is a column of a BigQuery table schema.
+type bqField struct {
+	Name   string    `json:"name"`
+	Type   string    `json:"type"`
+	Mode   string    `json:"mode,omitempty"`
+	Fields []bqField `json:"fields,omitempty"`
+}
+
+type bqSchema struct {
+	Fields []bqField `json:"fields"`
+}
+
+// tableSchema returns the schema of a table, or nil when it doesn't exist.
+func (c *bqClient) tableSchema(t bqTable) ([]bqField, error) {
+	var table struct {
+		Schema bqSchema `json:"schema"`
+	}
+	err := c.do(http.MethodGet, c.tableURL(t)+"?fields=schema", nil, &table)
+	var statusErr *googleStatusError
+	if errors.As(err, &statusErr) && statusErr.status == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read table %s: %w", t, err)
+	}
+	return table.Schema.Fields, nil
+}
+
+// createTable creates a table with the given schema. A table created
+// concurrently by another worker is not an error.
+func (c *bqClient) createTable(t bqTable, fields []bqField) error {
+	body := map[string]interface{}{
+		"tableReference": map[string]string{"projectId": t.project, "datasetId": t.dataset, "tableId": t.table},
+		"schema":         bqSchema{Fields: fields},
+	}
+	target := fmt.Sprintf("%s/projects/%s/datasets/%s/tables", c.endpoint, url.PathEscape(t.project), url.PathEscape(t.dataset))
+	err := c.do(http.MethodPost, target, body, nil)
+	var statusErr *googleStatusError
+	if errors.As(err, &statusErr) && statusErr.status == http.StatusConflict {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot create table %s: %w", t, err)
+	}
+	return nil
+}
+
+// writeClient returns a client of the Storage Write API, connecting on
+// first use.
+func (c *bqClient) writeClient() (storagepb.BigQueryWriteClient, error) {
+	c.connOnce.Do(func() {
+		if c.storage == "" {
+			c.connErr = errors.New("BIGQUERY_EMULATOR_HOST is set, but not BIGQUERY_STORAGE_EMULATOR_HOST, the emulator's gRPC address")
+			return
+		}
+		opts := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+		if !c.emulator {
+			opts = []grpc.DialOption{
+				grpc.WithTransportCredentials(credentials.NewClientTLSFromCert(nil, "")),
+				grpc.WithPerRPCCredentials(googleRPCCredentials{}),
+			}
+		}
+		c.conn, c.connErr = grpc.NewClient(c.storage, opts...)
+	})
+	if c.connErr != nil {
+		return nil, c.connErr
+	}
+	return storagepb.NewBigQueryWriteClient(c.conn), nil
+}
+
+// googleRPCCredentials authenticates gRPC calls with the access token of
+// googleAuth.
+type googleRPCCredentials struct{}
+
+func (googleRPCCredentials) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	token, err := googleAuth.accessToken()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"authorization": "Bearer " + token}, nil
+}
+
+func (googleRPCCredentials) RequireTransportSecurity() bool {
+	return true
+}
+
+// bqRouting returns a context for a call about a resource, which the API
+// routes by the x-goog-request-params header.
+func bqRouting(ctx context.Context, key, value string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "x-goog-request-params", key+"="+url.QueryEscape(value))
+}
+
+func (t bqTable) resource() string {
+	return fmt.Sprintf("projects/%s/datasets/%s/tables/%s", t.project, t.dataset, t.table)
+}
+
+// createWriteStream opens a committed write stream to a table, for rows
+// described by row. Rows appended to it are visible at once.
+func (c *bqClient) createWriteStream(t bqTable, row *descriptorpb.DescriptorProto) (*bqWriteStream, error) {
+	client, err := c.writeClient()
+	if err != nil {
+		return nil, err
+	}
+	var stream *storagepb.WriteStream
+	err = bqRetry(func() error {
+		ctx, cancel := context.WithTimeout(bqRouting(context.Background(), "parent", t.resource()), time.Minute)
+		defer cancel()
+		stream, err = client.CreateWriteStream(ctx, &storagepb.CreateWriteStreamRequest{
+			Parent:      t.resource(),
+			WriteStream: &storagepb.WriteStream{Type: storagepb.WriteStream_COMMITTED},
+		})
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot open a write stream to %s: %w", t, err)
+	}
+	return &bqWriteStream{client: client, name: stream.GetName(), schema: row}, nil
+}
+
+// bqWriteStream appends rows to a write stream, one request at a time.
+// Every request carries the offset its rows go to, so rows sent again
+// after a failure are written once.
+type bqWriteStream struct {
+	client storagepb.BigQueryWriteClient
+	name   string
+	schema *descriptorpb.DescriptorProto
+	call   storagepb.BigQueryWrite_AppendRowsClient // open AppendRows call, if any
+	cancel context.CancelFunc
+	offset int64 // rows written so far
+}
+
+// append writes serialized rows at the end of the stream. If BigQuery
+// rejects some of them, none are written, and the rejected ones are
+// returned by their index with the reason.
+func (ws *bqWriteStream) append(rows [][]byte) (map[int]string, error) {
+	var rejected map[int]string
+	err := bqRetry(func() error {
+		req := &storagepb.AppendRowsRequest{
+			Offset: wrapperspb.Int64(ws.offset),
+			Rows: &storagepb.AppendRowsRequest_ProtoRows{ProtoRows: &storagepb.AppendRowsRequest_ProtoData{
+				Rows: &storagepb.ProtoRows{SerializedRows: rows},
+			}},
+		}
+		// The first request of a call names the stream and the schema
+		if ws.call == nil {
+			ctx, cancel := context.WithCancel(bqRouting(context.Background(), "write_stream", ws.name))
+			call, err := ws.client.AppendRows(ctx)
+			if err != nil {
+				cancel()
+				return err
+			}
+			ws.call, ws.cancel = call, cancel
+			req.WriteStream = ws.name
+			req.GetProtoRows().WriterSchema = &storagepb.ProtoSchema{ProtoDescriptor: ws.schema}
+		}
+
+		err := ws.call.Send(req)
+		var resp *storagepb.AppendRowsResponse
+		if err == nil || err == io.EOF {
+			// Send returns io.EOF when the call failed; Recv tells why
+			resp, err = ws.call.Recv()
+		}
+		if err != nil {
+			ws.close()
+			return err
+		}
+		if len(resp.GetRowErrors()) > 0 {
+			rejected = make(map[int]string, len(resp.GetRowErrors()))
+			for _, rowErr := range resp.GetRowErrors() {
+				rejected[int(rowErr.GetIndex())] = rowErr.GetMessage()
+			}
+			return nil
+		}
+		if e := resp.GetError(); e != nil {
+			// Rows at this offset were written by a request whose response
+			// was lost
+			if codes.Code(e.GetCode()) == codes.AlreadyExists {
+				ws.offset += int64(len(rows))
+				return nil
+			}
+			ws.close()
+			return status.ErrorProto(e)
+		}
+		ws.offset += int64(len(rows))
+		return nil
+	})
+	return rejected, err
+}
+
+// close ends the open AppendRows call, if any.
+func (ws *bqWriteStream) close() {
+	if ws.call != nil {
+		ws.call.CloseSend()
+		ws.cancel()
+		ws.call, ws.cancel = nil, nil
+	}
+}
+
+// finalize closes the stream, so nothing more can be appended to it.
+func (ws *bqWriteStream) finalize() error {
+	ws.close()
+	return bqRetry(func() error {
+		ctx, cancel := context.WithTimeout(bqRouting(context.Background(), "name", ws.name), time.Minute)
+		defer cancel()
+		_, err := ws.client.FinalizeWriteStream(ctx, &storagepb.FinalizeWriteStreamRequest{Name: ws.name})
+		return err
+	})
+}
+
+// bqDescriptor describes the protocol buffer message rows of a table are
+// sent as, with a field per column. It is proto2, so that columns without
+// a value are NULL. Records are messages nested in the row message, since
+// the descriptor must be self-contained.
+func bqDescriptor(fields []bqField) (*descriptorpb.DescriptorProto, protoreflect.MessageDescriptor, error) {
+	row := &descriptorpb.DescriptorProto{Name: proto.String("Row")}
+	row.Field = bqProtoFields(row, "Row", fields)
+	file, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:        proto.String("row.proto"),
+		Syntax:      proto.String("proto2"),
+		MessageType: []*descriptorpb.DescriptorProto{row},
+	}, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot describe rows of the table: %w", err)
+	}
+	return row, file.Messages().Get(0), nil
+}
+
+func bqProtoFields(row *descriptorpb.DescriptorProto, name string, fields []bqField) []*descriptorpb.FieldDescriptorProto {
+	out := make([]*descriptorpb.FieldDescriptorProto, len(fields))
+	for i, f := range fields {
+		field := &descriptorpb.FieldDescriptorProto{
+			Name:   proto.String(f.Name),
+			Number: proto.Int32(int32(i + 1)),
+			Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:   bqProtoType(f.Type).Enum(),
+		}
+		if f.Mode == "REPEATED" {
+			field.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+		}
+		if field.GetType() == descriptorpb.FieldDescriptorProto_TYPE_MESSAGE {
+			nested := &descriptorpb.DescriptorProto{Name: proto.String(fmt.Sprintf("%s_%d", name, i+1))}
+			row.NestedType = append(row.NestedType, nested)
+			nested.Field = bqProtoFields(row, nested.GetName(), f.Fields)
+			field.TypeName = nested.Name
+		}
+		out[i] = field
+	}
+	return out
+}
+
+// bqProtoType returns the type a column's values are sent as. Timestamps
+// are microseconds since the epoch and dates days since it; numerics,
+// datetimes, times and JSON are sent as text.
+func bqProtoType(columnType string) descriptorpb.FieldDescriptorProto_Type {
+	switch columnType {
+	case "INT64", "INTEGER", "TIMESTAMP":
+		return descriptorpb.FieldDescriptorProto_TYPE_INT64
+	case "DATE":
+		return descriptorpb.FieldDescriptorProto_TYPE_INT32
+	case "FLOAT64", "FLOAT":
+		return descriptorpb.FieldDescriptorProto_TYPE_DOUBLE
+	case "BOOL", "BOOLEAN":
+		return descriptorpb.FieldDescriptorProto_TYPE_BOOL
+	case "BYTES":
+		return descriptorpb.FieldDescriptorProto_TYPE_BYTES
+	case "RECORD", "STRUCT":
+		return descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
+	}
+	return descriptorpb.FieldDescriptorProto_TYPE_STRING
+}
+
+// bqEncodeRow serializes a row as the message md describes. Fields the
+// table doesn't have are left out, as are nulls and null array items.
+func bqEncodeRow(md protoreflect.MessageDescriptor, fields []bqField, v interface{}) ([]byte, error) {
+	m := dynamicpb.NewMessage(md)
+	if err := bqSetFields(m, fields, v); err != nil {
+		return nil, err
+	}
+	return proto.Marshal(m)
+}
+
+func bqSetFields(m protoreflect.Message, fields []bqField, v interface{}) error {
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%s is not an object", bqShow(v))
+	}
+	for i, f := range fields {
+		value := obj[f.Name]
+		if value == nil {
+			continue
+		}
+		fd := m.Descriptor().Fields().Get(i)
+		if f.Mode != "REPEATED" {
+			pv, err := bqProtoValue(f, value, func() protoreflect.Message { return m.NewField(fd).Message() })
+			if err != nil {
+				return err
+			}
+			m.Set(fd, pv)
+			continue
+		}
+		items, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: %s is not an array", f.Name, bqShow(value))
+		}
+		list := m.Mutable(fd).List()
+		for _, item := range items {
+			if item == nil {
+				continue
+			}
+			pv, err := bqProtoValue(f, item, func() protoreflect.Message { return list.NewElement().Message() })
+			if err != nil {
+				return err
+			}
+			list.Append(pv)
+		}
+	}
+	return nil
+}
+
+// bqProtoValue converts a value for a column, as bqProtoType says it is
+// sent. newMessage makes the message of a record.
+func bqProtoValue(f bqField, v interface{}, newMessage func() protoreflect.Message) (protoreflect.Value, error) {
+	bad := func() (protoreflect.Value, error) {
+		return protoreflect.Value{}, fmt.Errorf("%s: %s is not a valid %s", f.Name, bqShow(v), f.Type)
+	}
+	switch f.Type {
+	case "RECORD", "STRUCT":
+		m := newMessage()
+		if err := bqSetFields(m, f.Fields, v); err != nil {
+			return protoreflect.Value{}, fmt.Errorf("%s.%w", f.Name, err)
+		}
+		return protoreflect.ValueOfMessage(m), nil
+	case "INT64", "INTEGER":
+		if n, ok := bqInt(v); ok {
+			return protoreflect.ValueOfInt64(n), nil
+		}
+	case "FLOAT64", "FLOAT":
+		if x, ok := bqFloat(v); ok {
+			return protoreflect.ValueOfFloat64(x), nil
+		}
+	case "BOOL", "BOOLEAN":
+		if b, ok := v.(bool); ok {
+			return protoreflect.ValueOfBool(b), nil
+		}
+	case "TIMESTAMP":
+		if text, ok := v.(string); ok {
+			if t, err := time.Parse(time.RFC3339Nano, text); err == nil {
+				return protoreflect.ValueOfInt64(t.UnixMicro()), nil
+			}
+		}
+	case "DATE":
+		if text, ok := v.(string); ok {
+			if t, err := time.Parse("2006-01-02", text); err == nil {
+				return protoreflect.ValueOfInt32(int32(t.Unix() / 86400)), nil
+			}
+		}
+	case "BYTES":
+		// Bytes are base64 encoded, as insertAll takes them
+		if text, ok := v.(string); ok {
+			if b, err := base64.StdEncoding.DecodeString(text); err == nil {
+				return protoreflect.ValueOfBytes(b), nil
+			}
+		}
+	case "JSON":
+		text, err := json.Marshal(v)
+		if err != nil {
+			return protoreflect.Value{}, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		return protoreflect.ValueOfString(string(text)), nil
+	default:
+		switch t := v.(type) {
+		case string:
+			return protoreflect.ValueOfString(t), nil
+		case json.Number:
+			return protoreflect.ValueOfString(t.String()), nil
+		case map[string]interface{}, []interface{}:
+		default:
+			text, err := json.Marshal(t)
+			if err == nil {
+				return protoreflect.ValueOfString(string(text)), nil
+			}
+		}
+	}
+	return bad()
+}
+
+func bqInt(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case int:
+		return int64(n), true
+	case json.Number:
+		i, err := n.Int64()
+		return i, err == nil
+	case float64:
+		if n == math.Trunc(n) && math.Abs(n) < 1<<63 {
+			return int64(n), true
+		}
+	case string:
+		i, err := strconv.ParseInt(n, 10, 64)
+		return i, err == nil
+	}
+	return 0, false
+}
+
+func bqFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case json.Number:
+		x, err := n.Float64()
+		return x, err == nil
+	case string:
+		// NaN and infinities are written as strings
+		x, err := strconv.ParseFloat(n, 64)
+		return x, err == nil
+	}
+	return 0, false
+}
+
+// bqShow renders a value for an error message, shortened.
+func bqShow(v interface{}) string {
+	text, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	if len(text) > 60 {
+		return string(text[:57]) + "..."
+	}
+	return string(text)
+}
+
+// bqFieldsFromAvro derives a table schema from an Avro record schema.
+func bqFieldsFromAvro(s *avroconvert.Schema) ([]bqField, error) {
+	if s.Kind != "record" {
+		return nil, fmt.Errorf("BigQuery output needs a record schema, got %s", s.Kind)
+	}
+	return bqRecordFields(s, make(map[*avroconvert.Schema]bool)), nil
+}
+
+func bqRecordFields(s *avroconvert.Schema, visiting map[*avroconvert.Schema]bool) []bqField {
+	visiting[s] = true
+	defer delete(visiting, s)
+	fields := make([]bqField, len(s.Fields))
+	for i, f := range s.Fields {
+		fields[i] = bqFieldFromAvro(f.Name, f.Schema, "REQUIRED", visiting)
+	}
+	return fields
+}
+
+// bqFieldFromAvro maps an Avro type to a column. Types BigQuery has no
+// equivalent for, such as maps, unions of several types and recursive
+// records, become JSON columns.
+func bqFieldFromAvro(name string, s *avroconvert.Schema, mode string, visiting map[*avroconvert.Schema]bool) bqField {
+	field := bqField{Name: name, Mode: mode}
+	switch s.LogicalType {
+	case "timestamp-millis", "timestamp-micros", "timestamp-nanos":
+		field.Type = "TIMESTAMP"
+		return field
+	case "local-timestamp-millis", "local-timestamp-micros", "local-timestamp-nanos":
+		field.Type = "DATETIME"
+		return field
+	case "date":
+		field.Type = "DATE"
+		return field
+	case "time-millis", "time-micros":
+		field.Type = "TIME"
+		return field
+	case "decimal":
+		field.Type = "BIGNUMERIC"
+		if s.Precision-s.Scale <= 29 && s.Scale <= 9 {
+			field.Type = "NUMERIC"
+		}
+		return field
+	case "duration":
+		field.Type = "JSON"
+		return field
+	}
+
+	switch s.Kind {
+	case "union":
+		var branches []*avroconvert.Schema
+		for _, branch := range s.Branches {
+			if branch.Kind != "null" {
+				branches = append(branches, branch)
+			}
+		}
+		if len(branches) == 1 {
+			nullable := mode
+			if len(branches) < len(s.Branches) {
+				nullable = "NULLABLE"
+			}
+			return bqFieldFromAvro(name, branches[0], nullable, visiting)
+		}
+		field.Type, field.Mode = "JSON", "NULLABLE"
+	case "record":
+		if visiting[s] {
+			field.Type = "JSON"
+			break
+		}
+		field.Type, field.Fields = "RECORD", bqRecordFields(s, visiting)
+	case "array":
+		items := bqFieldFromAvro(name, s.Items, "REPEATED", visiting)
+		// BigQuery has no arrays of arrays, nor nullable array elements
+		if items.Mode != "REPEATED" {
+			field.Type = "JSON"
+			break
+		}
+		return items
+	case "boolean":
+		field.Type = "BOOL"
+	case "int", "long":
+		field.Type = "INT64"
+	case "float", "double":
+		field.Type = "FLOAT64"
+	case "null":
+		field.Type, field.Mode = "STRING", "NULLABLE"
+	case "map":
+		field.Type = "JSON"
+	default:
+		// string, bytes, fixed and enum values are all written as text
+		field.Type = "STRING"
+	}
+	return field
+}
+
+// bqInferFields derives a table schema from JSON records, for input that
+// has no Avro schema. All columns are nullable; values of conflicting types
+// make a JSON column.
+func bqInferFields(records []interface{}) []bqField {
+	var fields []bqField
+	for _, record := range records {
+		if m, ok := record.(map[string]interface{}); ok {
+			fields = bqMergeObject(fields, m)
+		}
+	}
+	return bqDefaultTypes(fields)
+}
+
+func bqMergeObject(fields []bqField, m map[string]interface{}) []bqField {
+	for _, key := range sortedKeys(m) {
+		inferred, ok := bqInferField(key, m[key])
+		if !ok {
+			inferred = bqField{Name: key, Mode: "NULLABLE"}
+		}
+		i := 0
+		for i < len(fields) && fields[i].Name != key {
+			i++
+		}
+		if i == len(fields) {
+			fields = append(fields, inferred)
+			continue
+		}
+		fields[i] = bqMergeField(fields[i], inferred, m[key])
+	}
+	return fields
+}
+
+// bqInferField returns the column for a value, or false for null.
+func bqInferField(name string, v interface{}) (bqField, bool) {
+	field := bqField{Name: name, Mode: "NULLABLE"}
+	switch t := v.(type) {
+	case nil:
+		return field, false
+	case bool:
+		field.Type = "BOOL"
+	case json.Number:
+		field.Type = "FLOAT64"
+		if _, err := strconv.ParseInt(t.String(), 10, 64); err == nil {
+			field.Type = "INT64"
+		}
+	case string:
+		field.Type = "STRING"
+		if _, err := time.Parse(time.RFC3339Nano, t); err == nil {
+			field.Type = "TIMESTAMP"
+		} else if _, err := time.Parse("2006-01-02", t); err == nil {
+			field.Type = "DATE"
+		}
+	case map[string]interface{}:
+		field.Type, field.Fields = "RECORD", bqMergeObject(nil, t)
+	case []interface{}:
+		var items bqField
+		found := false
+		for _, item := range t {
+			if _, nested := item.([]interface{}); nested {
+				field.Type = "JSON"
+				return field, true
+			}
+			if next, ok := bqInferField(name, item); ok {
+				if found {
+					items = bqMergeField(items, next, item)
+				} else {
+					items, found = next, true
+				}
+			}
+		}
+		if !found {
+			return field, false
+		}
+		items.Mode = "REPEATED"
+		return items, true
+	default:
+		field.Type = "JSON"
+	}
+	return field, true
+}
+
+// bqMergeField combines the columns inferred for two values of a field.
+func bqMergeField(a, b bqField, v interface{}) bqField {
+	switch {
+	case b.Type == "":
+		return a
+	case a.Type == "":
+		return b
+	case a.Mode != b.Mode:
+		a.Type, a.Mode, a.Fields = "JSON", "NULLABLE", nil
+	case a.Type == "RECORD" && b.Type == "RECORD":
+		if m, ok := v.(map[string]interface{}); ok {
+			a.Fields = bqMergeObject(a.Fields, m)
+		} else {
+			for _, f := range b.Fields {
+				a.Fields = bqMergeObject(a.Fields, map[string]interface{}{f.Name: nil})
+			}
+		}
+	case a.Type == b.Type:
+	case a.Type == "INT64" && b.Type == "FLOAT64", a.Type == "FLOAT64" && b.Type == "INT64":
+		a.Type = "FLOAT64"
+	case a.Type == "STRING" && (b.Type == "TIMESTAMP" || b.Type == "DATE"):
+	case b.Type == "STRING" && (a.Type == "TIMESTAMP" || a.Type == "DATE"):
+		a.Type = "STRING"
+	default:
+		a.Type, a.Fields = "JSON", nil
+	}
+	return a
+}
+
+// bqDefaultTypes makes columns that only held nulls strings.
+func bqDefaultTypes(fields []bqField) []bqField {
+	for i := range fields {
+		if fields[i].Type == "" {
+			fields[i].Type = "STRING"
+		}
+		fields[i].Fields = bqDefaultTypes(fields[i].Fields)
+	}
+	return fields
+}
+
+// bigQuerySink batches records and writes them to a table through a write
+// stream. The table is created on the first flush if it doesn't exist. Its
+// schema comes from the Avro writer schema when records are whole, and is
+// inferred from the first batch otherwise. Rows BigQuery rejects are
+// reported and left out, and the others written.
+type bigQuerySink struct {
+	client    *bqClient
+	table     bqTable
+	input     string
+	converter *avroconvert.JSONConverter
+	schema    *avroconvert.Schema
+	fields    []bqField // schema of the table, once known to exist
+	created   []bqField // schema to create the table with, if known
+	row       protoreflect.MessageDescriptor
+	stream    *bqWriteStream // open once the table is known
+	unknown   map[string]bool
+	pending   []interface{}
+	size      int
+	rows      int // rows flushed, written or rejected
+	inserted  int
+	rejected  int
+}
+
+func newBigQuerySink(client *bqClient, table bqTable, input string, converter *avroconvert.JSONConverter) *bigQuerySink {
+	return &bigQuerySink{client: client, table: table, input: input, converter: converter, unknown: make(map[string]bool)}
+}
+
+func (bs *bigQuerySink) SetSchema(schema *avroconvert.Schema) error {
+	fields, err := bqFieldsFromAvro(schema)
+	if err != nil {
+		return err
+	}
+	bs.schema, bs.created = schema, fields
+	return nil
+}
+
+func (bs *bigQuerySink) WriteNative(record interface{}) error {
+	return bs.add(bs.converter.Value(bs.schema, record))
+}
+
+func (bs *bigQuerySink) WriteRecord(msg json.RawMessage) error {
+	v, err := parseMessage(msg)
+	if err != nil {
+		return err
+	}
+	if _, ok := v.(map[string]interface{}); !ok {
+		return errors.New("BigQuery rows must be JSON objects")
+	}
+	return bs.add(v)
+}
+
+func (bs *bigQuerySink) add(v interface{}) error {
+	text, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	bs.pending = append(bs.pending, v)
+	bs.size += len(text)
+	if len(bs.pending) >= bqBatchRows || bs.size >= bqBatchBytes {
+		return bs.Flush()
+	}
+	return nil
+}
+
+// Flush writes the pending rows. Rows that cannot be converted to the
+// table's columns, or that BigQuery rejects, are reported and left out;
+// since BigQuery writes none of the rows of a request with a rejected one,
+// the others are sent again without it.
+func (bs *bigQuerySink) Flush() error {
+	if len(bs.pending) == 0 {
+		return nil
+	}
+	if bs.stream == nil {
+		if err := bs.open(); err != nil {
+			return err
+		}
+	}
+
+	rows := make([][]byte, 0, len(bs.pending))
+	index := make([]int, 0, len(bs.pending))
+	for i, v := range bs.pending {
+		bs.checkFields(v)
+		row, err := bqEncodeRow(bs.row, bs.fields, v)
+		if err != nil {
+			bs.reject(i, err.Error())
+			continue
+		}
+		rows, index = append(rows, row), append(index, i)
+	}
+	for len(rows) > 0 {
+		rejected, err := bs.stream.append(rows)
+		if err != nil {
+			return fmt.Errorf("cannot write rows to %s: %w", bs.table, err)
+		}
+		if len(rejected) == 0 {
+			break
+		}
+		kept := 0
+		for j := range rows {
+			if reason, ok := rejected[j]; ok {
+				bs.reject(index[j], reason)
+				continue
+			}
+			rows[kept], index[kept] = rows[j], index[j]
+			kept++
+		}
+		if kept == len(rows) {
+			return fmt.Errorf("cannot write rows to %s: rows were rejected without saying which", bs.table)
+		}
+		rows, index = rows[:kept], index[:kept]
+	}
+	bs.inserted += len(rows)
+	bs.rows += len(bs.pending)
+	bs.pending, bs.size = bs.pending[:0], 0
+	return nil
+}
+
+// reject reports pending row i as not written.
+func (bs *bigQuerySink) reject(i int, reason string) {
+	slog.Error("BigQuery rejected row", "input", bs.input, "row", bs.rows+i+1, "table", bs.table.String(), "error", reason)
+	bs.rejected++
+}
+
+// checkFields warns once about each top-level field of a row that the
+// table has no column for, as it is left out.
+func (bs *bigQuerySink) checkFields(v interface{}) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return
+	}
+	for name := range m {
+		if bs.unknown[name] {
+			continue
+		}
+		found := false
+		for _, f := range bs.fields {
+			if f.Name == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			bs.unknown[name] = true
+			slog.Warn("Leaving out a field the table has no column for", "input", bs.input, "table", bs.table.String(), "field", name)
+		}
+	}
+}
+
+// open finds or creates the table and opens a write stream to it.
+func (bs *bigQuerySink) open() error {
+	if err := bs.ensureTable(); err != nil {
+		return err
+	}
+	row, md, err := bqDescriptor(bs.fields)
+	if err != nil {
+		return err
+	}
+	bs.row = md
+	bs.stream, err = bs.client.createWriteStream(bs.table, row)
+	return err
+}
+
+// ensureTable reads the table's schema, creating the table first if it
+// doesn't exist.
+func (bs *bigQuerySink) ensureTable() error {
+	fields, err := bs.client.tableSchema(bs.table)
+	if err != nil || fields != nil {
+		bs.fields = fields
+		return err
+	}
+	if bs.created == nil {
+		bs.created = bqInferFields(bs.pending)
+	}
+	if err := bs.client.createTable(bs.table, bs.created); err != nil {
+		return err
+	}
+	if bs.fields, err = bs.client.tableSchema(bs.table); err == nil && bs.fields == nil {
+		bs.fields = bs.created
+	}
+	return err
+}
+
+// Close writes the pending rows and finalizes the write stream.
+func (bs *bigQuerySink) Close() error {
+	if err := bs.Flush(); err != nil {
+		bs.abort()
+		return err
+	}
+	if bs.stream == nil {
+		return nil
+	}
+	if err := bs.stream.finalize(); err != nil {
+		return fmt.Errorf("cannot finalize the write stream to %s: %w", bs.table, err)
+	}
+	return nil
+}
+
+// abort ends the write stream's call after a failure. Rows already written
+// stay in the table.
+func (bs *bigQuerySink) abort() {
+	if bs.stream != nil {
+		bs.stream.close()
+	}
+}
+
+// loadBigQuery writes the records of an input to a table. It returns an
+// error wrapping errRowsRejected if BigQuery rejected some of the rows.
+func loadBigQuery(in inputFile, table bqTable, opts decodeOptions) (avroconvert.Stats, error) {
+	input, err := openDecodeInput(in.path, opts)
+	if err != nil {
+		return avroconvert.Stats{}, err
+	}
+	defer input.Close()
+
+	sink := newBigQuerySink(bigquery, table, in.path, opts.converter)
+	stats, err := decodeMessages(input, in.path, opts, sink)
+	if err != nil {
+		sink.abort()
+		return stats, err
+	}
+	if err := sink.Close(); err != nil {
+		return stats, err
+	}
+	slog.Info("Loaded rows into BigQuery", "input", in.path, "rows", sink.inserted, "table", table.String(), filteredAttr(stats))
+	if sink.rejected > 0 {
+		return stats, fmt.Errorf("%d of %d %w by %s", sink.rejected, sink.rows, errRowsRejected, table)
+	}
+	return stats, nil
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery/storage/apiv1/storagepb"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"avroparser/pkg/avroconvert"
+)
+
+func TestParseBigQueryPath(t *testing.T) {
+	table, err := parseBigQueryPath("bq://game-prod.analytics.events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (table != bqTable{project: "game-prod", dataset: "analytics", table: "events"}) {
+		t.Fatalf("parsed %+v", table)
+	}
+	for _, p := range []string{"bq://analytics.events", "bq://a..c", "bq://a.b.c.d"} {
+		if _, err := parseBigQueryPath(p); err == nil {
+			t.Errorf("parsed %s", p)
+		}
+	}
+}
+
+func TestBQFieldsFromAvro(t *testing.T) {
+	schema, err := avroconvert.ParseSchema(eventSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields, err := bqFieldsFromAvro(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []bqField{
+		{Name: "id", Type: "INT64", Mode: "REQUIRED"},
+		{Name: "kind", Type: "STRING", Mode: "REQUIRED"},
+		{Name: "user", Type: "STRING", Mode: "NULLABLE"},
+		{Name: "score", Type: "FLOAT64", Mode: "REQUIRED"},
+		{Name: "raw", Type: "STRING", Mode: "REQUIRED"},
+		{Name: "tags", Type: "STRING", Mode: "REPEATED"},
+		{Name: "props", Type: "JSON", Mode: "REQUIRED"},
+		// The recursive reference becomes a JSON column
+		{Name: "next", Type: "JSON", Mode: "NULLABLE"},
+	}
+	if !reflect.DeepEqual(fields, want) {
+		t.Fatalf("derived %+v, want %+v", fields, want)
+	}
+}
+
+func TestBQInferFields(t *testing.T) {
+	var records []interface{}
+	for _, msg := range []string{
+		`{"id": 1, "at": "2026-01-02T03:04:05Z", "geo": {"country": "DE"}, "tags": ["a"], "x": 1, "none": null}`,
+		`{"id": 2.5, "at": "later", "geo": {"city": "Berlin"}, "tags": [], "x": "one"}`,
+	} {
+		v, err := parseMessage(json.RawMessage(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, v)
+	}
+	want := []bqField{
+		{Name: "at", Type: "STRING", Mode: "NULLABLE"},
+		{Name: "geo", Type: "RECORD", Mode: "NULLABLE", Fields: []bqField{
+			{Name: "country", Type: "STRING", Mode: "NULLABLE"},
+			{Name: "city", Type: "STRING", Mode: "NULLABLE"},
+		}},
+		{Name: "id", Type: "FLOAT64", Mode: "NULLABLE"},
+		{Name: "none", Type: "STRING", Mode: "NULLABLE"},
+		{Name: "tags", Type: "STRING", Mode: "REPEATED"},
+		{Name: "x", Type: "JSON", Mode: "NULLABLE"},
+	}
+	if got := bqInferFields(records); !reflect.DeepEqual(got, want) {
+		t.Fatalf("inferred %+v, want %+v", got, want)
+	}
+}
+
+// fakeBigQuery serves a table over the REST API and its write streams
+// over the Storage Write API, as far as the sink uses them.
+type fakeBigQuery struct {
+	storagepb.UnimplementedBigQueryWriteServer
+	t      *testing.T
+	schema []bqField // nil until the table is created
+
+	mu        sync.Mutex
+	rows      []map[string]interface{} // written, as JSON
+	appends   int
+	dropAfter int            // append after which the call fails as if its response was lost
+	reject    map[int64]bool // ids rejected as invalid
+	finalized bool
+}
+
+// serve starts both APIs and returns a client of them.
+func (fb *fakeBigQuery) serve() *bqClient {
+	rest := httptest.NewServer(http.HandlerFunc(fb.serveREST))
+	fb.t.Cleanup(rest.Close)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		fb.t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	storagepb.RegisterBigQueryWriteServer(server, fb)
+	go server.Serve(listener)
+	fb.t.Cleanup(server.Stop)
+
+	wait := bqRetryWait
+	bqRetryWait = time.Millisecond
+	fb.t.Cleanup(func() { bqRetryWait = wait })
+	return &bqClient{endpoint: rest.URL, storage: listener.Addr().String(), emulator: true, http: rest.Client()}
+}
+
+func (fb *fakeBigQuery) serveREST(w http.ResponseWriter, r *http.Request) {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/projects/p/datasets/d/tables/t":
+		if fb.schema == nil {
+			http.Error(w, `{"error":{"message":"Not found: Table p:d.t"}}`, http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"schema": bqSchema{Fields: fb.schema}})
+	case r.Method == http.MethodPost && r.URL.Path == "/projects/p/datasets/d/tables":
+		var table struct {
+			Schema bqSchema `json:"schema"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&table); err != nil {
+			fb.t.Error(err)
+		}
+		fb.schema = table.Schema.Fields
+		io.WriteString(w, "{}")
+	default:
+		fb.t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		http.NotFound(w, r)
+	}
+}
+
+func (fb *fakeBigQuery) CreateWriteStream(ctx context.Context, req *storagepb.CreateWriteStreamRequest) (*storagepb.WriteStream, error) {
+	if req.GetParent() != "projects/p/datasets/d/tables/t" || req.GetWriteStream().GetType() != storagepb.WriteStream_COMMITTED {
+		fb.t.Errorf("stream created with %v", req)
+	}
+	return &storagepb.WriteStream{Name: req.GetParent() + "/streams/s", Type: storagepb.WriteStream_COMMITTED}, nil
+}
+
+func (fb *fakeBigQuery) FinalizeWriteStream(ctx context.Context, req *storagepb.FinalizeWriteStreamRequest) (*storagepb.FinalizeWriteStreamResponse, error) {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	fb.finalized = true
+	return &storagepb.FinalizeWriteStreamResponse{RowCount: int64(len(fb.rows))}, nil
+}
+
+// AppendRows writes a request's rows unless one is invalid, as BigQuery
+// does for committed streams.
+func (fb *fakeBigQuery) AppendRows(call storagepb.BigQueryWrite_AppendRowsServer) error {
+	var row protoreflect.MessageDescriptor
+	for {
+		req, err := call.Recv()
+		if err != nil {
+			return nil
+		}
+		if row == nil {
+			if req.GetWriteStream() == "" || req.GetProtoRows().GetWriterSchema() == nil {
+				return grpcstatus.Error(codes.InvalidArgument, "the first request must name the stream and schema")
+			}
+			file, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+				Name:        proto.String("row.proto"),
+				Syntax:      proto.String("proto2"),
+				MessageType: []*descriptorpb.DescriptorProto{req.GetProtoRows().GetWriterSchema().GetProtoDescriptor()},
+			}, nil)
+			if err != nil {
+				return grpcstatus.Errorf(codes.InvalidArgument, "invalid descriptor: %v", err)
+			}
+			row = file.Messages().Get(0)
+		}
+
+		fb.mu.Lock()
+		resp, fail := fb.append(row, req)
+		fb.mu.Unlock()
+		if fail != nil {
+			return fail
+		}
+		if err := call.Send(resp); err != nil {
+			return err
+		}
+	}
+}
+
+func (fb *fakeBigQuery) append(row protoreflect.MessageDescriptor, req *storagepb.AppendRowsRequest) (*storagepb.AppendRowsResponse, error) {
+	offset := req.GetOffset().GetValue()
+	switch {
+	case offset < int64(len(fb.rows)):
+		return &storagepb.AppendRowsResponse{Response: &storagepb.AppendRowsResponse_Error{Error: &status.Status{Code: int32(codes.AlreadyExists), Message: "offset already written"}}}, nil
+	case offset > int64(len(fb.rows)):
+		return &storagepb.AppendRowsResponse{Response: &storagepb.AppendRowsResponse_Error{Error: &status.Status{Code: int32(codes.OutOfRange), Message: "offset past the end"}}}, nil
+	}
+
+	var rows []map[string]interface{}
+	var rowErrors []*storagepb.RowError
+	for i, data := range req.GetProtoRows().GetRows().GetSerializedRows() {
+		m := dynamicpb.NewMessage(row)
+		if err := proto.Unmarshal(data, m); err != nil {
+			return nil, grpcstatus.Errorf(codes.InvalidArgument, "row %d: %v", i, err)
+		}
+		text, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(m)
+		if err != nil {
+			return nil, grpcstatus.Error(codes.Internal, err.Error())
+		}
+		var v map[string]interface{}
+		if err := json.Unmarshal(text, &v); err != nil {
+			return nil, grpcstatus.Error(codes.Internal, err.Error())
+		}
+		// int64 values are strings in JSON
+		if id, _ := strconv.ParseInt(fmt.Sprint(v["id"]), 10, 64); fb.reject[id] {
+			rowErrors = append(rowErrors, &storagepb.RowError{Index: int64(i), Code: storagepb.RowError_FIELDS_ERROR, Message: fmt.Sprintf("id %d is not allowed", id)})
+		}
+		rows = append(rows, v)
+	}
+	if len(rowErrors) > 0 {
+		return &storagepb.AppendRowsResponse{
+			Response:  &storagepb.AppendRowsResponse_Error{Error: &status.Status{Code: int32(codes.InvalidArgument), Message: "rows rejected"}},
+			RowErrors: rowErrors,
+		}, nil
+	}
+	fb.rows = append(fb.rows, rows...)
+	fb.appends++
+	if fb.appends == fb.dropAfter {
+		return nil, grpcstatus.Error(codes.Unavailable, "connection reset")
+	}
+	return &storagepb.AppendRowsResponse{Response: &storagepb.AppendRowsResponse_AppendResult_{AppendResult: &storagepb.AppendRowsResponse_AppendResult{}}}, nil
+}
+
+func TestBigQueryLoadRejectsRows(t *testing.T) {
+	fb := &fakeBigQuery{t: t, dropAfter: 2, reject: map[int64]bool{13: true, 600: true, 601: true}}
+	fb.schema = []bqField{
+		{Name: "id", Type: "INTEGER", Mode: "REQUIRED"},
+		{Name: "name", Type: "STRING"},
+		{Name: "at", Type: "TIMESTAMP"},
+		{Name: "day", Type: "DATE"},
+		{Name: "tags", Type: "STRING", Mode: "REPEATED"},
+		{Name: "nested", Type: "RECORD", Fields: []bqField{{Name: "x", Type: "FLOAT"}, {Name: "extra", Type: "JSON"}}},
+	}
+	client := fb.serve()
+	saved := bigquery
+	bigquery = client
+	t.Cleanup(func() { bigquery = saved })
+
+	const n = 1200
+	var input strings.Builder
+	for i := 0; i < n; i++ {
+		id := fmt.Sprint(i)
+		if i == 77 {
+			id = `"seventy-seven"` // cannot be an INTEGER
+		}
+		fmt.Fprintf(&input, `{"id":%s,"name":"event-%d","at":"2024-01-02T03:04:05.5Z","day":"2024-01-02","tags":["a",null,"b"],"nested":{"x":1.5,"extra":{"k":[1]}},"unknown":true}`+"\n", id, i)
+	}
+	path := writeTestFile(t, "events.ndjson", []byte(input.String()))
+	_, err := loadBigQuery(inputFile{path: path}, bqTable{project: "p", dataset: "d", table: "t"}, testOptions(t, ""))
+	if !errors.Is(err, errRowsRejected) || !strings.Contains(err.Error(), "4 of 1200") {
+		t.Fatalf("load returned %v, want 4 of 1200 rows rejected", err)
+	}
+
+	if !fb.finalized {
+		t.Fatal("the write stream wasn't finalized")
+	}
+	// Every row but the rejected ones, once each and in order
+	if len(fb.rows) != n-4 {
+		t.Fatalf("wrote %d rows, want %d", len(fb.rows), n-4)
+	}
+	next := 0
+	for _, row := range fb.rows {
+		for next == 13 || next == 77 || next == 600 || next == 601 {
+			next++
+		}
+		if row["id"] != fmt.Sprint(next) {
+			t.Fatalf("row has id %v, want %d", row["id"], next)
+		}
+		next++
+	}
+	want := `{"at":"1704164645500000","day":19724,"id":"0","name":"event-0","nested":{"extra":"{\"k\":[1]}","x":1.5},"tags":["a","b"]}`
+	if got, _ := json.Marshal(fb.rows[0]); string(got) != want {
+		t.Fatalf("row is %s, want %s", got, want)
+	}
+}
+
+func TestBigQueryLoadCreatesTable(t *testing.T) {
+	fb := &fakeBigQuery{t: t}
+	client := fb.serve()
+	sink := newBigQuerySink(client, bqTable{project: "p", dataset: "d", table: "t"}, "input", nil)
+	for i := 0; i < 10; i++ {
+		if err := sink.WriteRecord(json.RawMessage(fmt.Sprintf(`{"id":%d,"score":%d.5,"ok":true,"when":"2024-05-06"}`, i, i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if sink.inserted != 10 || sink.rejected != 0 || len(fb.rows) != 10 {
+		t.Fatalf("inserted %d, rejected %d, wrote %d rows", sink.inserted, sink.rejected, len(fb.rows))
+	}
+	types := map[string]string{}
+	for _, f := range fb.schema {
+		types[f.Name] = f.Type
+	}
+	if types["id"] != "INT64" || types["score"] != "FLOAT64" || types["ok"] != "BOOL" || types["when"] != "DATE" {
+		t.Fatalf("table created with %v", fb.schema)
+	}
+	if got, _ := json.Marshal(fb.rows[9]); string(got) != `{"id":"9","ok":true,"score":9.5,"when":19849}` {
+		t.Fatalf("row is %s", got)
+	}
+}
+
+func TestBigQueryDescriptorNested(t *testing.T) {
+	fields := []bqField{
+		{Name: "a", Type: "RECORD", Fields: []bqField{
+			{Name: "b", Type: "RECORD", Mode: "REPEATED", Fields: []bqField{{Name: "c", Type: "INT64"}}},
+		}},
+		{Name: "d", Type: "RECORD", Fields: []bqField{{Name: "c", Type: "BYTES"}}},
+	}
+	row, md, err := bqDescriptor(fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(row.GetNestedType()) != 3 {
+		t.Fatalf("%d nested messages, want 3", len(row.GetNestedType()))
+	}
+	data, err := bqEncodeRow(md, fields, map[string]interface{}{
+		"a": map[string]interface{}{"b": []interface{}{map[string]interface{}{"c": int64(1)}, nil, map[string]interface{}{"c": json.Number("2")}}},
+		"d": map[string]interface{}{"c": "aGk="},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := dynamicpb.NewMessage(md)
+	if err := proto.Unmarshal(data, m); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := protojson.Marshal(m); !strings.Contains(string(got), `"c":"aGk="`) || strings.Count(string(got), `"c":"`) != 3 {
+		t.Fatalf("row is %s", got)
+	}
+
+	if _, err := bqEncodeRow(md, fields, map[string]interface{}{"a": map[string]interface{}{"b": []interface{}{map[string]interface{}{"c": "x"}}}}); err == nil || !strings.HasPrefix(err.Error(), "a.b.c: ") {
+		t.Fatalf("encoding an invalid value returned %v", err)
+	}
+}
+
+func TestBQRetryable(t *testing.T) {
+	if !retryable(&googleStatusError{status: http.StatusServiceUnavailable}) || retryable(&googleStatusError{status: http.StatusBadRequest}) {
+		t.Fatal("wrong statuses retried")
+	}
+	if !retryable(errors.New("connection reset")) {
+		t.Fatal("network errors aren't retried")
+	}
+}
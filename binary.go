@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// binaryEncoding writes JSON values in a binary format. Integers that fit
+// 64 bits stay integers; other numbers become doubles.
+type binaryEncoding interface {
+	null(b *bytes.Buffer)
+	boolean(b *bytes.Buffer, v bool)
+	integer(b *bytes.Buffer, v int64)
+	unsigned(b *bytes.Buffer, v uint64)
+	float(b *bytes.Buffer, v float64)
+	text(b *bytes.Buffer, s string)
+	arrayHeader(b *bytes.Buffer, n int)
+	mapHeader(b *bytes.Buffer, n int)
+}
+
+// binaryWriter writes each message as a MessagePack or CBOR value, one
+// after another with nothing in between, as msgpack stream readers and
+// CBOR sequence (RFC 8742) readers take them. Object keys keep the order
+// of the message.
+type binaryWriter struct {
+	w        io.Writer
+	encoding binaryEncoding
+	buf      bytes.Buffer
+}
+
+func newBinaryWriter(w io.Writer, format string) *binaryWriter {
+	var encoding binaryEncoding = msgpackEncoding{}
+	if format == "cbor" {
+		encoding = cborEncoding{}
+	}
+	return &binaryWriter{w: w, encoding: encoding}
+}
+
+func (bw *binaryWriter) WriteRecord(msg json.RawMessage) error {
+	dec := json.NewDecoder(bytes.NewReader(msg))
+	dec.UseNumber()
+	bw.buf.Reset()
+	if err := bw.encode(dec, &bw.buf); err != nil {
+		return fmt.Errorf("invalid JSON message: %w", err)
+	}
+	_, err := bw.w.Write(bw.buf.Bytes())
+	return err
+}
+
+func (bw *binaryWriter) Flush() error { return nil }
+
+func (bw *binaryWriter) Close() error { return nil }
+
+// encode writes the next value of dec to b. Arrays and objects are encoded
+// into a buffer of their own first, as both formats give their length up
+// front.
+func (bw *binaryWriter) encode(dec *json.Decoder, b *bytes.Buffer) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	switch t := token.(type) {
+	case nil:
+		bw.encoding.null(b)
+	case bool:
+		bw.encoding.boolean(b, t)
+	case string:
+		bw.encoding.text(b, t)
+	case json.Number:
+		if n, err := strconv.ParseInt(t.String(), 10, 64); err == nil {
+			bw.encoding.integer(b, n)
+		} else if n, err := strconv.ParseUint(t.String(), 10, 64); err == nil {
+			bw.encoding.unsigned(b, n)
+		} else {
+			f, err := t.Float64()
+			if err != nil {
+				return err
+			}
+			bw.encoding.float(b, f)
+		}
+	case json.Delim:
+		var body bytes.Buffer
+		n := 0
+		for ; dec.More(); n++ {
+			if t == '{' {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				bw.encoding.text(&body, key.(string))
+			}
+			if err := bw.encode(dec, &body); err != nil {
+				return err
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			return err
+		}
+		if t == '{' {
+			bw.encoding.mapHeader(b, n)
+		} else {
+			bw.encoding.arrayHeader(b, n)
+		}
+		body.WriteTo(b)
+	}
+	return nil
+}
+
+// msgpackEncoding writes MessagePack, using the smallest form of each value.
+type msgpackEncoding struct{}
+
+func (msgpackEncoding) null(b *bytes.Buffer) {
+	b.WriteByte(0xc0)
+}
+
+func (msgpackEncoding) boolean(b *bytes.Buffer, v bool) {
+	if v {
+		b.WriteByte(0xc3)
+	} else {
+		b.WriteByte(0xc2)
+	}
+}
+
+func (e msgpackEncoding) integer(b *bytes.Buffer, v int64) {
+	switch {
+	case v >= 0:
+		e.unsigned(b, uint64(v))
+	case v >= -32:
+		b.WriteByte(byte(int8(v)))
+	case v >= math.MinInt8:
+		b.Write([]byte{0xd0, byte(int8(v))})
+	case v >= math.MinInt16:
+		b.WriteByte(0xd1)
+		b.Write(binary.BigEndian.AppendUint16(nil, uint16(v)))
+	case v >= math.MinInt32:
+		b.WriteByte(0xd2)
+		b.Write(binary.BigEndian.AppendUint32(nil, uint32(v)))
+	default:
+		b.WriteByte(0xd3)
+		b.Write(binary.BigEndian.AppendUint64(nil, uint64(v)))
+	}
+}
+
+func (msgpackEncoding) unsigned(b *bytes.Buffer, v uint64) {
+	switch {
+	case v <= 0x7f:
+		b.WriteByte(byte(v))
+	case v <= math.MaxUint8:
+		b.Write([]byte{0xcc, byte(v)})
+	case v <= math.MaxUint16:
+		b.WriteByte(0xcd)
+		b.Write(binary.BigEndian.AppendUint16(nil, uint16(v)))
+	case v <= math.MaxUint32:
+		b.WriteByte(0xce)
+		b.Write(binary.BigEndian.AppendUint32(nil, uint32(v)))
+	default:
+		b.WriteByte(0xcf)
+		b.Write(binary.BigEndian.AppendUint64(nil, v))
+	}
+}
+
+func (msgpackEncoding) float(b *bytes.Buffer, v float64) {
+	b.WriteByte(0xcb)
+	b.Write(binary.BigEndian.AppendUint64(nil, math.Float64bits(v)))
+}
+
+func (msgpackEncoding) text(b *bytes.Buffer, s string) {
+	msgpackHeader(b, len(s), 0xa0, 32, 0xd9, 0xda)
+	b.WriteString(s)
+}
+
+func (msgpackEncoding) arrayHeader(b *bytes.Buffer, n int) {
+	msgpackHeader(b, n, 0x90, 16, 0, 0xdc)
+}
+
+func (msgpackEncoding) mapHeader(b *bytes.Buffer, n int) {
+	msgpackHeader(b, n, 0x80, 16, 0, 0xde)
+}
+
+// msgpackHeader writes the header of a string, array or map of length n:
+// the fix form below fixMax, the 8-bit form if the type has one, or the
+// 16-bit form, whose 32-bit form is the next byte.
+func msgpackHeader(b *bytes.Buffer, n int, fix byte, fixMax int, form8, form16 byte) {
+	switch {
+	case n < fixMax:
+		b.WriteByte(fix | byte(n))
+	case form8 != 0 && n <= math.MaxUint8:
+		b.Write([]byte{form8, byte(n)})
+	case n <= math.MaxUint16:
+		b.WriteByte(form16)
+		b.Write(binary.BigEndian.AppendUint16(nil, uint16(n)))
+	default:
+		b.WriteByte(form16 + 1)
+		b.Write(binary.BigEndian.AppendUint32(nil, uint32(n)))
+	}
+}
+
+// cborEncoding writes CBOR (RFC 8949) with definite lengths.
+type cborEncoding struct{}
+
+func (cborEncoding) null(b *bytes.Buffer) {
+	b.WriteByte(0xf6)
+}
+
+func (cborEncoding) boolean(b *bytes.Buffer, v bool) {
+	if v {
+		b.WriteByte(0xf5)
+	} else {
+		b.WriteByte(0xf4)
+	}
+}
+
+func (cborEncoding) integer(b *bytes.Buffer, v int64) {
+	if v < 0 {
+		cborHeader(b, 1, uint64(^v))
+		return
+	}
+	cborHeader(b, 0, uint64(v))
+}
+
+func (cborEncoding) unsigned(b *bytes.Buffer, v uint64) {
+	cborHeader(b, 0, v)
+}
+
+func (cborEncoding) float(b *bytes.Buffer, v float64) {
+	b.WriteByte(0xfb)
+	b.Write(binary.BigEndian.AppendUint64(nil, math.Float64bits(v)))
+}
+
+func (cborEncoding) text(b *bytes.Buffer, s string) {
+	cborHeader(b, 3, uint64(len(s)))
+	b.WriteString(s)
+}
+
+func (cborEncoding) arrayHeader(b *bytes.Buffer, n int) {
+	cborHeader(b, 4, uint64(n))
+}
+
+func (cborEncoding) mapHeader(b *bytes.Buffer, n int) {
+	cborHeader(b, 5, uint64(n))
+}
+
+// cborHeader writes the initial byte of a data item of a major type with
+// its argument, in the shortest form.
+func cborHeader(b *bytes.Buffer, major byte, v uint64) {
+	major <<= 5
+	switch {
+	case v < 24:
+		b.WriteByte(major | byte(v))
+	case v <= math.MaxUint8:
+		b.Write([]byte{major | 24, byte(v)})
+	case v <= math.MaxUint16:
+		b.WriteByte(major | 25)
+		b.Write(binary.BigEndian.AppendUint16(nil, uint16(v)))
+	case v <= math.MaxUint32:
+		b.WriteByte(major | 26)
+		b.Write(binary.BigEndian.AppendUint32(nil, uint32(v)))
+	default:
+		b.WriteByte(major | 27)
+		b.Write(binary.BigEndian.AppendUint64(nil, v))
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+)
+
+func TestBinaryWriter(t *testing.T) {
+	tests := []struct {
+		format string
+		msg    string
+		want   string
+	}{
+		{"msgpack", `null`, "c0"},
+		{"msgpack", `[true,false]`, "92c3c2"},
+		{"msgpack", `{"a":1,"b":-1}`, "82a16101a162ff"},
+		{"msgpack", `[127,128,-33,65536,-129]`, "957fcc80d0dfce00010000d1ff7f"},
+		{"msgpack", `18446744073709551615`, "cfffffffffffffffff"},
+		{"msgpack", `1.5`, "cb3ff8000000000000"},
+		{"msgpack", `"` + string(bytes.Repeat([]byte("x"), 32)) + `"`, "d920" + hex.EncodeToString(bytes.Repeat([]byte("x"), 32))},
+		{"cbor", `null`, "f6"},
+		{"cbor", `[true,false]`, "82f5f4"},
+		{"cbor", `{"a":1,"b":-1}`, "a2616101616220"},
+		{"cbor", `[23,24,-25,256]`, "841718183818190100"},
+		{"cbor", `18446744073709551615`, "1bffffffffffffffff"},
+		{"cbor", `1.5`, "fb3ff8000000000000"},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		w := newBinaryWriter(&out, tt.format)
+		if err := w.WriteRecord(json.RawMessage(tt.msg)); err != nil {
+			t.Fatalf("%s %s: %v", tt.format, tt.msg, err)
+		}
+		if got := hex.EncodeToString(out.Bytes()); got != tt.want {
+			t.Errorf("%s %s = %s, want %s", tt.format, tt.msg, got, tt.want)
+		}
+	}
+}
+
+func TestBinaryWriterSequence(t *testing.T) {
+	var out bytes.Buffer
+	w := newBinaryWriter(&out, "cbor")
+	for _, msg := range []string{`{"n":1}`, `{"n":2}`} {
+		if err := w.WriteRecord(json.RawMessage(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := hex.EncodeToString(out.Bytes()), "a1616e01a1616e02"; got != want {
+		t.Errorf("sequence = %s, want %s", got, want)
+	}
+}
+
+func TestBinaryWriterInvalidJSON(t *testing.T) {
+	w := newBinaryWriter(&bytes.Buffer{}, "msgpack")
+	if err := w.WriteRecord(json.RawMessage(`{"a":`)); err == nil {
+		t.Error("expected an error for truncated JSON")
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"avroparser/pkg/avroconvert"
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+func isClickHouseURL(p string) bool {
+	return strings.HasPrefix(p, "clickhouse://")
+}
+
+// clickHouseOptions holds the settings for loading into ClickHouse.
+type clickHouseOptions struct {
+	url         string
+	table       string // possibly database-qualified, e.g. analytics.events
+	batchSize   int    // rows per INSERT
+	asyncInsert bool   // let the server buffer and merge small inserts
+}
+
+// clickHouseSettings make the server parse rows leniently enough for the
+// JSON written by decode: fields the table lacks are skipped, and RFC 3339
+// timestamps are accepted for DateTime columns.
+var clickHouseSettings = clickhouse.Settings{
+	"input_format_skip_unknown_fields": 1,
+	"date_time_input_format":           "best_effort",
+}
+
+// quoteClickHouseTable quotes a table name, split at its database.
+func quoteClickHouseTable(table string) string {
+	parts := strings.Split(table, ".")
+	for i, part := range parts {
+		parts[i] = "`" + strings.ReplaceAll(part, "`", "``") + "`"
+	}
+	return strings.Join(parts, ".")
+}
+
+// clickHouseSink sends records in batches over the native protocol, each
+// as an INSERT with its rows inline in the JSONEachRow format. The server
+// parses the rows against the column types, so Int64 values and
+// timestamps keep their precision.
+type clickHouseSink struct {
+	conn     driver.Conn
+	opts     clickHouseOptions
+	insert   string
+	buf      bytes.Buffer
+	pending  int
+	inserted int64
+}
+
+func newClickHouseSink(conn driver.Conn, opts clickHouseOptions) *clickHouseSink {
+	cs := &clickHouseSink{conn: conn, opts: opts}
+	cs.insert = fmt.Sprintf("INSERT INTO %s FORMAT JSONEachRow\n", quoteClickHouseTable(opts.table))
+	return cs
+}
+
+func (cs *clickHouseSink) WriteRecord(msg json.RawMessage) error {
+	// JSONEachRow needs one row per line
+	if err := json.Compact(&cs.buf, msg); err != nil {
+		return err
+	}
+	cs.buf.WriteByte('\n')
+	cs.pending++
+	if cs.pending >= cs.opts.batchSize {
+		return cs.Flush()
+	}
+	return nil
+}
+
+// Flush inserts the pending rows.
+func (cs *clickHouseSink) Flush() error {
+	if cs.pending == 0 {
+		return nil
+	}
+	ctx := clickhouse.Context(context.Background(), clickhouse.WithSettings(clickHouseSettings))
+	query := cs.insert + cs.buf.String()
+	var err error
+	if cs.opts.asyncInsert {
+		// Waiting for the server to write the buffered rows still reports
+		// rows it rejects
+		err = cs.conn.AsyncInsert(ctx, query, true)
+	} else {
+		err = cs.conn.Exec(ctx, query)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot insert rows into %s: %w", cs.opts.table, err)
+	}
+	cs.inserted += int64(cs.pending)
+	cs.buf.Reset()
+	cs.pending = 0
+	return nil
+}
+
+func (cs *clickHouseSink) Close() error {
+	return cs.Flush()
+}
+
+// loadClickHouse inserts the records of an input into a table in batches.
+// Batches already inserted stay when a later one fails.
+func loadClickHouse(in inputFile, co clickHouseOptions, opts decodeOptions) (avroconvert.Stats, error) {
+	input, err := openDecodeInput(in.path, opts)
+	if err != nil {
+		return avroconvert.Stats{}, err
+	}
+	defer input.Close()
+
+	chOpts, err := clickhouse.ParseDSN(co.url)
+	if err != nil {
+		return avroconvert.Stats{}, fmt.Errorf("invalid ClickHouse URL: %w", err)
+	}
+	conn, err := clickhouse.Open(chOpts)
+	if err != nil {
+		return avroconvert.Stats{}, fmt.Errorf("cannot connect to ClickHouse: %w", err)
+	}
+	defer conn.Close()
+
+	sink := newClickHouseSink(conn, co)
+	stats, err := decodeMessages(input, in.path, opts, sink)
+	if err != nil {
+		return stats, err
+	}
+	if err := sink.Close(); err != nil {
+		return stats, err
+	}
+	slog.Info("Inserted rows into ClickHouse", "input", in.path, "rows", sink.inserted, "table", co.table, filteredAttr(stats))
+	return stats, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+// fakeClickHouse records the queries a clickHouseSink sends.
+type fakeClickHouse struct {
+	driver.Conn
+	queries []string
+	async   []bool
+	fail    error
+}
+
+func (f *fakeClickHouse) Exec(ctx context.Context, query string, args ...any) error {
+	f.queries, f.async = append(f.queries, query), append(f.async, false)
+	return f.fail
+}
+
+func (f *fakeClickHouse) AsyncInsert(ctx context.Context, query string, wait bool, args ...any) error {
+	f.queries, f.async = append(f.queries, query), append(f.async, true)
+	return f.fail
+}
+
+func TestClickHouseSink(t *testing.T) {
+	for _, async := range []bool{false, true} {
+		conn := &fakeClickHouse{}
+		sink := newClickHouseSink(conn, clickHouseOptions{table: "analytics.events", batchSize: 2, asyncInsert: async})
+		for _, msg := range []string{`{"id": 1}`, "{\n  \"id\": 2\n}", `{"id": 3}`} {
+			if err := sink.WriteRecord(json.RawMessage(msg)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatal(err)
+		}
+		want := []string{
+			"INSERT INTO `analytics`.`events` FORMAT JSONEachRow\n{\"id\":1}\n{\"id\":2}\n",
+			"INSERT INTO `analytics`.`events` FORMAT JSONEachRow\n{\"id\":3}\n",
+		}
+		if len(conn.queries) != 2 || conn.queries[0] != want[0] || conn.queries[1] != want[1] || conn.async[0] != async || sink.inserted != 3 {
+			t.Fatalf("async %v: sent %q, inserted %d", async, conn.queries, sink.inserted)
+		}
+	}
+}
+
+func TestClickHouseSinkFailure(t *testing.T) {
+	conn := &fakeClickHouse{fail: errors.New("type mismatch")}
+	sink := newClickHouseSink(conn, clickHouseOptions{table: "events", batchSize: 10})
+	if err := sink.WriteRecord(json.RawMessage(`{"id": 1}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err == nil || sink.inserted != 0 {
+		t.Fatalf("closed with %v after inserting %d rows", err, sink.inserted)
+	}
+}
+
+func TestQuoteClickHouseTable(t *testing.T) {
+	if got := quoteClickHouseTable("db.we`ird"); got != "`db`.`we``ird`" {
+		t.Fatalf("quoted %s", got)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Avro codec names of block compressions.
+const (
+	ocfCodecNull      = "null"
+	ocfCodecDeflate   = "deflate"
+	ocfCodecSnappy    = "snappy"
+	ocfCodecZstandard = "zstandard"
+	ocfCodecBzip2     = "bzip2"
+	ocfCodecXZ        = "xz"
+)
+
+// blockCodec compresses and decompresses the data blocks of container
+// files. compress is nil for codecs that can only be read.
+type blockCodec struct {
+	compress   func(data []byte) ([]byte, error)
+	decompress func(data []byte) ([]byte, error)
+	close      func() // releases the codec's state, if it has any
+}
+
+// blockCodecs make a codec for each Avro codec name a container file's
+// avro.codec entry may give.
+var blockCodecs = make(map[string]func() (*blockCodec, error))
+
+// registerBlockCodec makes container files compressed with the codec name
+// readable, and writable if the codec compresses.
+func registerBlockCodec(name string, open func() (*blockCodec, error)) {
+	blockCodecs[name] = open
+}
+
+func init() {
+	registerBlockCodec(ocfCodecNull, func() (*blockCodec, error) {
+		same := func(data []byte) ([]byte, error) { return data, nil }
+		return &blockCodec{compress: same, decompress: same}, nil
+	})
+	registerBlockCodec(ocfCodecDeflate, func() (*blockCodec, error) {
+		return &blockCodec{compress: deflateBlock, decompress: inflateBlock}, nil
+	})
+	registerBlockCodec(ocfCodecSnappy, func() (*blockCodec, error) {
+		return &blockCodec{compress: snappyEncodeBlock, decompress: snappyDecodeBlock}, nil
+	})
+	registerBlockCodec(ocfCodecZstandard, newZstdCodec)
+	registerBlockCodec(ocfCodecBzip2, func() (*blockCodec, error) {
+		return &blockCodec{decompress: func(data []byte) ([]byte, error) {
+			return io.ReadAll(bzip2.NewReader(bytes.NewReader(data)))
+		}}, nil
+	})
+	registerBlockCodec(ocfCodecXZ, func() (*blockCodec, error) {
+		return &blockCodec{compress: xzCompressBlock, decompress: xzDecompressBlock}, nil
+	})
+}
+
+// newBlockCodec returns the codec of an Avro codec name.
+func newBlockCodec(name string) (*blockCodec, error) {
+	open, ok := blockCodecs[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported codec %q (expected one of %s)", name, strings.Join(registeredCodecs(), ", "))
+	}
+	codec, err := open()
+	if err != nil {
+		return nil, fmt.Errorf("cannot create %s codec: %w", name, err)
+	}
+	if codec.compress == nil {
+		codec.compress = func([]byte) ([]byte, error) {
+			return nil, fmt.Errorf("%s blocks can only be read; recompress the file with another codec first", name)
+		}
+	}
+	if codec.close == nil {
+		codec.close = func() {}
+	}
+	return codec, nil
+}
+
+// registeredCodecs returns the names of the registered codecs, sorted.
+func registeredCodecs() []string {
+	names := make([]string, 0, len(blockCodecs))
+	for name := range blockCodecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func deflateBlock(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	fw, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := fw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func inflateBlock(data []byte) ([]byte, error) {
+	fr := flate.NewReader(bytes.NewReader(data))
+	defer fr.Close()
+	return io.ReadAll(fr)
+}
+
+// Snappy blocks end with the CRC-32 of the uncompressed data.
+func snappyEncodeBlock(data []byte) ([]byte, error) {
+	out := snappy.Encode(nil, data)
+	return binary.BigEndian.AppendUint32(out, crc32.ChecksumIEEE(data)), nil
+}
+
+func snappyDecodeBlock(data []byte) ([]byte, error) {
+	if len(data) < 4 {
+		return nil, errors.New("snappy block too short")
+	}
+	out, err := snappy.Decode(nil, data[:len(data)-4])
+	if err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(out) != binary.BigEndian.Uint32(data[len(data)-4:]) {
+		return nil, errors.New("snappy block checksum mismatch")
+	}
+	return out, nil
+}
+
+// newZstdCodec makes a zstandard codec, whose encoder and decoder are made
+// when first needed and kept for all the blocks of a file. Blocks may be
+// compressed or decompressed concurrently.
+func newZstdCodec() (*blockCodec, error) {
+	var encoderOnce, decoderOnce sync.Once
+	var enc *zstd.Encoder
+	var dec *zstd.Decoder
+	var encErr, decErr error
+	codec := &blockCodec{
+		compress: func(data []byte) ([]byte, error) {
+			encoderOnce.Do(func() { enc, encErr = zstd.NewWriter(nil) })
+			if encErr != nil {
+				return nil, encErr
+			}
+			return enc.EncodeAll(data, nil), nil
+		},
+		decompress: func(data []byte) ([]byte, error) {
+			decoderOnce.Do(func() { dec, decErr = zstd.NewReader(nil) })
+			if decErr != nil {
+				return nil, decErr
+			}
+			return dec.DecodeAll(data, nil)
+		},
+		close: func() {
+			// Neither is made after closing
+			encoderOnce.Do(func() {})
+			decoderOnce.Do(func() {})
+			if enc != nil {
+				enc.Close()
+			}
+			if dec != nil {
+				dec.Close()
+			}
+		},
+	}
+	return codec, nil
+}
+
+// xz blocks are whole .xz streams, as the Java implementation writes them.
+func xzCompressBlock(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	xw, err := xz.NewWriter(&buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := xw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := xw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func xzDecompressBlock(data []byte) ([]byte, error) {
+	xr, err := xz.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(xr)
+}
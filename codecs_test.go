@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestBlockCodecRoundTrip(t *testing.T) {
+	blocks := [][]byte{
+		{},
+		[]byte("a"),
+		bytes.Repeat([]byte("avro block "), 10000),
+	}
+	for _, name := range registeredCodecs() {
+		if name == ocfCodecBzip2 {
+			continue
+		}
+		t.Run(name, func(t *testing.T) {
+			codec, err := newBlockCodec(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer codec.close()
+			for _, block := range blocks {
+				compressed, err := codec.compress(block)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := codec.decompress(compressed)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, block) {
+					t.Fatalf("%d bytes came back as %d", len(block), len(got))
+				}
+			}
+		})
+	}
+}
+
+// TestBlockCodecConcurrent checks codecs may be shared by the workers of
+// ocfRecordReader.
+func TestBlockCodecConcurrent(t *testing.T) {
+	for _, name := range []string{ocfCodecDeflate, ocfCodecSnappy, ocfCodecZstandard} {
+		t.Run(name, func(t *testing.T) {
+			codec, err := newBlockCodec(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer codec.close()
+			var wg sync.WaitGroup
+			errs := make(chan error, 8)
+			for i := 0; i < 8; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					block := bytes.Repeat([]byte{byte(i)}, 1000+i)
+					for j := 0; j < 50; j++ {
+						compressed, err := codec.compress(block)
+						if err == nil {
+							var got []byte
+							if got, err = codec.decompress(compressed); err == nil && !bytes.Equal(got, block) {
+								t.Errorf("block %d came back changed", i)
+							}
+						}
+						if err != nil {
+							errs <- err
+							return
+						}
+					}
+				}(i)
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestBlockCodecBzip2ReadOnly(t *testing.T) {
+	codec, err := newBlockCodec(ocfCodecBzip2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer codec.close()
+	// bzip2 -c of "hello, avro"
+	compressed := []byte{
+		0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0xe5, 0x0f,
+		0x89, 0x52, 0x00, 0x00, 0x02, 0x11, 0x80, 0x40, 0x04, 0x22, 0x44, 0x91,
+		0x00, 0x20, 0x00, 0x22, 0x0d, 0x31, 0x34, 0x20, 0xc9, 0x88, 0xc6, 0x10,
+		0x4e, 0xba, 0x4f, 0x17, 0x72, 0x45, 0x38, 0x50, 0x90, 0xe5, 0x0f, 0x89,
+		0x52,
+	}
+	got, err := codec.decompress(compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello, avro" {
+		t.Fatalf("decompressed %q", got)
+	}
+	if _, err := codec.compress(got); err == nil || !strings.Contains(err.Error(), "can only be read") {
+		t.Fatalf("compress returned %v, want a read-only error", err)
+	}
+}
+
+func TestBlockCodecUnknown(t *testing.T) {
+	if _, err := newBlockCodec("lz4"); err == nil || !strings.Contains(err.Error(), ocfCodecZstandard) {
+		t.Fatalf("newBlockCodec(lz4) returned %v, want an error listing the codecs", err)
+	}
+}
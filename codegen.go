@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"go/token"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"avroparser/pkg/avroconvert"
+)
+
+func runCodegen(args []string) {
+	fs := flag.NewFlagSet("codegen", flag.ExitOnError)
+	inputPath := fs.String("input", "", "Avro file whose writer schema to generate types for, an .avsc file, or - for stdin")
+	outputPath := fs.String("output", stdioPath, "Output Go file, or - for stdout")
+	pkg := fs.String("package", "", "Package name of the generated file (default the name of the -output directory, or events)")
+	logging := addLogFlags(fs)
+	parseFlags(fs, args)
+	logging.setup()
+
+	if *inputPath == "" && fs.NArg() > 0 {
+		*inputPath = fs.Arg(0)
+	}
+	if *inputPath == "" {
+		fmt.Fprintln(os.Stderr, "Usage: avroparser codegen -input <avro_file|avsc_file|-> [-output <file.go>|-] [-package <name>]")
+		os.Exit(exitFatal)
+	}
+	if *pkg == "" {
+		*pkg = "events"
+		if *outputPath != stdioPath {
+			if dir := filepath.Base(filepath.Dir(*outputPath)); token.IsIdentifier(dir) {
+				*pkg = dir
+			}
+		}
+	}
+	if !token.IsIdentifier(*pkg) {
+		fmt.Fprintf(os.Stderr, "Invalid -package name %q\n", *pkg)
+		os.Exit(exitFatal)
+	}
+
+	spec, err := readSchemaSpec(*inputPath)
+	if err != nil {
+		slog.Error("Cannot read schema", "input", displayPath(*inputPath), "error", err)
+		os.Exit(exitFatal)
+	}
+	schema, err := avroconvert.ParseSchema(string(spec))
+	if err != nil {
+		slog.Error("Cannot parse schema", "input", displayPath(*inputPath), "error", err)
+		os.Exit(exitFatal)
+	}
+	if schema.Kind != "record" {
+		slog.Error("Cannot generate types for a schema that isn't a record", "input", displayPath(*inputPath), "type", schema.Kind)
+		os.Exit(exitFatal)
+	}
+
+	source, err := generateGoTypes(schema, *pkg, filepath.Base(displayPath(*inputPath)))
+	if err != nil {
+		slog.Error("Cannot generate types", "input", displayPath(*inputPath), "error", err)
+		os.Exit(exitFatal)
+	}
+	out, err := openOutput(*outputPath)
+	if err == nil {
+		_, err = out.Write(source)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		slog.Error("Cannot write types", "output", displayPath(*outputPath), "error", err)
+		os.Exit(exitFatal)
+	}
+}
+
+// goTypeGenerator writes Go types for the named types of a schema, each
+// once, in the order they are first referenced.
+type goTypeGenerator struct {
+	decls   bytes.Buffer
+	names   map[string]string // Go type name by Avro full name
+	taken   map[string]bool   // Go type names in use
+	pending []*avroconvert.Schema
+	imports map[string]bool
+}
+
+// generateGoTypes returns the gofmt'ed source of a file declaring a struct
+// for every record of the schema and a string type for every enum.
+func generateGoTypes(schema *avroconvert.Schema, pkg, input string) ([]byte, error) {
+	g := &goTypeGenerator{names: make(map[string]string), taken: make(map[string]bool), imports: make(map[string]bool)}
+	g.typeName(schema)
+	for len(g.pending) > 0 {
+		s := g.pending[0]
+		g.pending = g.pending[1:]
+		g.declare(s)
+	}
+
+	var src bytes.Buffer
+	fmt.Fprintf(&src, "// Code generated by avroparser codegen from %s. DO NOT EDIT.\n\npackage %s\n\n", input, pkg)
+	if len(g.imports) > 0 {
+		src.WriteString("import (\n")
+		for _, path := range sortedKeys(g.imports) {
+			fmt.Fprintf(&src, "\t%q\n", path)
+		}
+		src.WriteString(")\n\n")
+	}
+	g.decls.WriteTo(&src)
+	return format.Source(src.Bytes())
+}
+
+// typeName returns the Go type of a named type, queueing its declaration
+// the first time it is seen.
+func (g *goTypeGenerator) typeName(s *avroconvert.Schema) string {
+	if name, ok := g.names[s.Name]; ok {
+		return name
+	}
+	short := s.Name[strings.LastIndex(s.Name, ".")+1:]
+	name := goName(short)
+	if g.taken[name] {
+		// The same name in another namespace
+		name = goName(strings.ReplaceAll(s.Name, ".", "_"))
+	}
+	g.names[s.Name] = name
+	g.taken[name] = true
+	g.pending = append(g.pending, s)
+	return name
+}
+
+func (g *goTypeGenerator) declare(s *avroconvert.Schema) {
+	name := g.names[s.Name]
+	w := &g.decls
+	writeDoc(w, name, s.Doc, s.Name)
+	switch s.Kind {
+	case "record":
+		fmt.Fprintf(w, "type %s struct {\n", name)
+		fields := make(map[string]bool, len(s.Fields))
+		for _, f := range s.Fields {
+			field := goName(f.Name)
+			for n := 2; fields[field]; n++ {
+				// e.g. user_id and userId
+				field = goName(f.Name) + strconv.Itoa(n)
+			}
+			fields[field] = true
+			if f.Doc != "" {
+				for _, line := range strings.Split(strings.TrimSpace(f.Doc), "\n") {
+					fmt.Fprintf(w, "\t// %s\n", strings.TrimSpace(line))
+				}
+			}
+			tag := fmt.Sprintf("avro:%q json:%q", f.Name, f.Name)
+			fmt.Fprintf(w, "\t%s %s `%s`\n", field, g.goType(f.Schema), tag)
+		}
+		w.WriteString("}\n\n")
+	case "enum":
+		fmt.Fprintf(w, "type %s string\n\n", name)
+		if len(s.Symbols) > 0 {
+			fmt.Fprintf(w, "// %s symbols.\nconst (\n", name)
+			for _, symbol := range s.Symbols {
+				fmt.Fprintf(w, "\t%s %s = %q\n", name+goName(strings.ToLower(symbol)), name, symbol)
+			}
+			w.WriteString(")\n\n")
+		}
+	case "fixed":
+		fmt.Fprintf(w, "type %s [%d]byte\n\n", name, s.Size)
+	}
+}
+
+// writeDoc writes the doc comment of a type: the schema's doc, or the Avro
+// name it is generated from.
+func writeDoc(w *bytes.Buffer, name, doc, avroName string) {
+	if doc == "" {
+		fmt.Fprintf(w, "// %s is the Avro type %s.\n", name, avroName)
+		return
+	}
+	for _, line := range strings.Split(strings.TrimSpace(doc), "\n") {
+		fmt.Fprintf(w, "// %s\n", strings.TrimSpace(line))
+	}
+}
+
+// goType returns the Go type of a value of the schema. Unions of null and
+// one other type become pointers, or stay slices and maps; other unions
+// become interface{}.
+func (g *goTypeGenerator) goType(s *avroconvert.Schema) string {
+	switch s.LogicalType {
+	case "timestamp-millis", "timestamp-micros", "timestamp-nanos",
+		"local-timestamp-millis", "local-timestamp-micros", "local-timestamp-nanos", "date":
+		g.imports["time"] = true
+		return "time.Time"
+	case "time-millis", "time-micros":
+		g.imports["time"] = true
+		return "time.Duration"
+	case "decimal":
+		g.imports["math/big"] = true
+		return "*big.Rat"
+	case "uuid":
+		if s.Kind == "string" {
+			return "string"
+		}
+	}
+	switch s.Kind {
+	case "null":
+		return "interface{}"
+	case "boolean":
+		return "bool"
+	case "int":
+		return "int32"
+	case "long":
+		return "int64"
+	case "float":
+		return "float32"
+	case "double":
+		return "float64"
+	case "bytes":
+		return "[]byte"
+	case "string":
+		return "string"
+	case "array":
+		return "[]" + g.goType(s.Items)
+	case "map":
+		return "map[string]" + g.goType(s.Values)
+	case "record", "enum", "fixed":
+		return g.typeName(s)
+	case "union":
+		var other *avroconvert.Schema
+		for _, branch := range s.Branches {
+			if branch.Kind != "null" {
+				if other != nil {
+					return "interface{}"
+				}
+				other = branch
+			}
+		}
+		if other == nil {
+			return "interface{}"
+		}
+		t := g.goType(other)
+		if len(s.Branches) == 1 || strings.HasPrefix(t, "[]") || strings.HasPrefix(t, "map[") || strings.HasPrefix(t, "*") || t == "interface{}" {
+			return t
+		}
+		return "*" + t
+	}
+	return "interface{}"
+}
+
+// goName turns an Avro name into an exported Go identifier, e.g.
+// event_timestamp into EventTimestamp and user_id into UserID.
+func goName(name string) string {
+	var b strings.Builder
+	for _, word := range strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if upper := strings.ToUpper(word); goInitialisms[upper] {
+			b.WriteString(upper)
+			continue
+		}
+		runes := []rune(word)
+		runes[0] = unicode.ToUpper(runes[0])
+		b.WriteString(string(runes))
+	}
+	ident := b.String()
+	if ident == "" || !unicode.IsLetter([]rune(ident)[0]) {
+		ident = "X" + ident
+	}
+	return ident
+}
+
+// goInitialisms are written in capitals, as in Go names.
+var goInitialisms = map[string]bool{
+	"ID": true, "URL": true, "URI": true, "UUID": true, "IP": true, "API": true,
+	"HTTP": true, "JSON": true, "SDK": true, "OS": true, "UTC": true, "LTV": true,
+}
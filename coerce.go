@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Types a -coerce file can give a field.
+const (
+	coerceString = "string"
+	coerceInt    = "int64"
+	coerceFloat  = "float"
+	coerceBool   = "bool"
+)
+
+// coerceTypes maps the type names a -coerce file accepts to the types.
+var coerceTypes = map[string]string{
+	"string":  coerceString,
+	"int":     coerceInt,
+	"int64":   coerceInt,
+	"float":   coerceFloat,
+	"float64": coerceFloat,
+	"double":  coerceFloat,
+	"bool":    coerceBool,
+	"boolean": coerceBool,
+}
+
+// What -coerce does with a value that cannot be converted to its type.
+const (
+	coerceNull   = "null"   // write null instead
+	coerceKeep   = "keep"   // write the value as it is
+	coerceRemove = "remove" // leave the field out
+	coerceFail   = "fail"   // fail the record, which -on-error handles
+)
+
+// coercion converts the values of fields of JSON messages to fixed types,
+// so a field written as a number by some clients and as text by others has
+// one type downstream. Paths follow nested objects, and through arrays to
+// every element, as -redact's do.
+type coercion struct {
+	rules []coercionRule
+}
+
+type coercionRule struct {
+	path     []string
+	typ      string
+	onError  string
+	explicit bool // onError was given for the field
+}
+
+// UnmarshalYAML accepts a field given by its type alone as well as one with
+// its own on_error.
+func (r *coercionRule) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		r.typ = node.Value
+		return nil
+	}
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: expected a type, or a mapping with type and on_error", node.Line)
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		switch key.Value {
+		case "type":
+			r.typ = value.Value
+		case "on_error":
+			// Read as written, as YAML takes an unquoted null for no value
+			r.onError, r.explicit = value.Value, true
+		default:
+			return fmt.Errorf("line %d: unknown setting %q (expected type or on_error)", key.Line, key.Value)
+		}
+	}
+	return nil
+}
+
+// loadCoercion reads a -coerce file: the type of each field path under
+// "fields", and what happens to values that cannot be converted under
+// "on_error", for all fields or each one:
+//
+//	on_error: null
+//	fields:
+//	  payload_score: int64
+//	  param_value: float
+//	  payload.level: {type: int64, on_error: fail}
+func loadCoercion(path string) (*coercion, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read coercion file: %w", err)
+	}
+	var doc yaml.Node
+	if err := yaml.NewDecoder(bytes.NewReader(data)).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("cannot parse coercion file %s: %w", path, err)
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s is not a mapping of on_error and fields", path)
+	}
+
+	onError := coerceNull
+	var fields *yaml.Node
+	root := doc.Content[0]
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		switch key.Value {
+		case "on_error":
+			onError = value.Value
+		case "fields":
+			fields = value
+		default:
+			return nil, fmt.Errorf("%s: line %d: unknown setting %q (expected on_error or fields)", path, key.Line, key.Value)
+		}
+	}
+	if err := checkCoercePolicy(onError); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if fields == nil || fields.Kind != yaml.MappingNode || len(fields.Content) == 0 {
+		return nil, fmt.Errorf("%s declares no fields", path)
+	}
+
+	c := &coercion{}
+	seen := make(map[string]bool)
+	for i := 0; i+1 < len(fields.Content); i += 2 {
+		field := fields.Content[i].Value
+		var rule coercionRule
+		if err := fields.Content[i+1].Decode(&rule); err != nil {
+			return nil, fmt.Errorf("%s: field %s: %w", path, field, err)
+		}
+		switch {
+		case field == "" || strings.HasPrefix(field, ".") || strings.HasSuffix(field, ".") || strings.Contains(field, ".."):
+			return nil, fmt.Errorf("%s: invalid field path %q", path, field)
+		case seen[field]:
+			return nil, fmt.Errorf("%s: field %s is declared twice", path, field)
+		}
+		seen[field] = true
+		typ, ok := coerceTypes[rule.typ]
+		if !ok {
+			return nil, fmt.Errorf("%s: field %s has unknown type %q (expected string, int64, float or bool)", path, field, rule.typ)
+		}
+		if !rule.explicit {
+			rule.onError = onError
+		} else if err := checkCoercePolicy(rule.onError); err != nil {
+			return nil, fmt.Errorf("%s: field %s: %w", path, field, err)
+		}
+		rule.path, rule.typ = strings.Split(field, "."), typ
+		c.rules = append(c.rules, rule)
+	}
+	return c, nil
+}
+
+func checkCoercePolicy(policy string) error {
+	switch policy {
+	case coerceNull, coerceKeep, coerceRemove, coerceFail:
+		return nil
+	}
+	return fmt.Errorf("unknown on_error %q (expected null, keep, remove or fail)", policy)
+}
+
+// apply coerces the fields of a JSON message. It is used as a decoder
+// transform, after -epoch-fields and ahead of -redact.
+func (c *coercion) apply(msg json.RawMessage) ([]json.RawMessage, error) {
+	v, err := parseMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+	for _, rule := range c.rules {
+		if err := rule.coercePath(v, rule.path); err != nil {
+			return nil, err
+		}
+	}
+	text, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return []json.RawMessage{text}, nil
+}
+
+func (r *coercionRule) coercePath(v interface{}, path []string) error {
+	switch t := v.(type) {
+	case []interface{}:
+		for _, item := range t {
+			if err := r.coercePath(item, path); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		value, ok := t[path[0]]
+		if !ok {
+			return nil
+		}
+		if len(path) > 1 {
+			return r.coercePath(value, path[1:])
+		}
+		if value == nil {
+			return nil
+		}
+		coerced, ok := coerceValue(value, r.typ)
+		if ok {
+			t[path[0]] = coerced
+			return nil
+		}
+		switch r.onError {
+		case coerceNull:
+			t[path[0]] = nil
+		case coerceRemove:
+			delete(t, path[0])
+		case coerceFail:
+			return fmt.Errorf("%s cannot be converted to %s: %s", strings.Join(r.path, "."), r.typ, truncateRunes(fmt.Sprint(value), 40))
+		}
+	}
+	return nil
+}
+
+// coerceValue converts a parsed JSON value to a type. Text is trimmed of
+// spaces before it is parsed, and numbers are only converted to int64 when
+// they are whole.
+func coerceValue(v interface{}, typ string) (interface{}, bool) {
+	if s, ok := v.(string); ok && typ != coerceString {
+		v = strings.TrimSpace(s)
+	}
+	switch typ {
+	case coerceString:
+		switch t := v.(type) {
+		case string:
+			return t, true
+		case json.Number:
+			return t.String(), true
+		case bool:
+			return strconv.FormatBool(t), true
+		}
+		text, err := json.Marshal(v)
+		return string(text), err == nil
+
+	case coerceInt:
+		switch n, _ := filterNumeric(v); n := n.(type) {
+		case int64:
+			return json.Number(strconv.FormatInt(n, 10)), true
+		case float64:
+			if n != math.Trunc(n) || n < math.MinInt64 || n >= math.MaxInt64 {
+				return nil, false
+			}
+			return json.Number(strconv.FormatInt(int64(n), 10)), true
+		}
+
+	case coerceFloat:
+		n, ok := filterNumeric(v)
+		if !ok {
+			return nil, false
+		}
+		f, isFloat := n.(float64)
+		if !isFloat {
+			f = float64(n.(int64))
+		}
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, false
+		}
+		// A decimal point keeps whole numbers floats to column inference
+		text := strconv.FormatFloat(f, 'g', -1, 64)
+		if !strings.ContainsAny(text, ".eE") {
+			text += ".0"
+		}
+		return json.Number(text), true
+
+	case coerceBool:
+		switch t := v.(type) {
+		case bool:
+			return t, true
+		case string:
+			b, err := strconv.ParseBool(t)
+			return b, err == nil
+		case json.Number:
+			switch t.String() {
+			case "0":
+				return false, true
+			case "1":
+				return true, true
+			}
+		}
+	}
+	return nil, false
+}
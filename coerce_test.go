@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func loadTestCoercion(t *testing.T, config string) *coercion {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "coerce.yaml")
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := loadCoercion(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestCoercionApply(t *testing.T) {
+	c := loadTestCoercion(t, `
+on_error: null
+fields:
+  score: int64
+  value: float
+  flag: bool
+  code: string
+  items.qty: {type: int, on_error: remove}
+  level: {type: int64, on_error: keep}
+`)
+	tests := []struct{ in, want string }{
+		{`{"score":" 12 ","value":3,"flag":"true","code":7}`, `{"code":"7","flag":true,"score":12,"value":3.0}`},
+		{`{"score":1.5,"value":"x","flag":1,"code":true}`, `{"code":"true","flag":true,"score":null,"value":null}`},
+		{`{"score":12345678901234567890,"flag":"maybe","code":{"a":1}}`, `{"code":"{\"a\":1}","flag":null,"score":null}`},
+		{`{"items":[{"qty":"2"},{"qty":"many"},{}],"level":"high"}`, `{"items":[{"qty":2},{},{}],"level":"high"}`},
+		{`{"score":null,"value":"1e3"}`, `{"score":null,"value":1000.0}`},
+	}
+	for _, tt := range tests {
+		got, err := c.apply(json.RawMessage(tt.in))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.in, err)
+		}
+		if len(got) != 1 || string(got[0]) != tt.want {
+			t.Errorf("%s coerced to %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCoercionFail(t *testing.T) {
+	c := loadTestCoercion(t, "on_error: fail\nfields:\n  payload.level: int64\n")
+	if _, err := c.apply(json.RawMessage(`{"payload":{"level":"high"}}`)); err == nil || !strings.Contains(err.Error(), "payload.level cannot be converted to int64: high") {
+		t.Fatalf("coerced an invalid value: %v", err)
+	}
+	if got, err := c.apply(json.RawMessage(`{"payload":{"level":"3"}}`)); err != nil || string(got[0]) != `{"payload":{"level":3}}` {
+		t.Errorf("coerced %s, %v", got, err)
+	}
+}
+
+func TestLoadCoercionErrors(t *testing.T) {
+	dir := t.TempDir()
+	for name, config := range map[string]string{
+		"list":     "- a\n",
+		"setting":  "fields:\n  a: int\nstrict: true\n",
+		"policy":   "on_error: drop\nfields:\n  a: int\n",
+		"nofields": "on_error: null\n",
+		"path":     "fields:\n  a..b: int\n",
+		"type":     "fields:\n  a: decimal\n",
+		"twice":    "fields:\n  a: int\n  a: float\n",
+		"field":    "fields:\n  a: {type: int, on_error: ignore}\n",
+		"key":      "fields:\n  a: {type: int, default: 0}\n",
+	} {
+		path := filepath.Join(dir, name+".yaml")
+		if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadCoercion(path); err == nil {
+			t.Errorf("%s: loaded %q", name, config)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"avroparser/pkg/avroconvert"
+)
+
+// Column types a columns file can declare, and column discovery infers.
+const (
+	columnString    = "string"
+	columnInt       = "int"
+	columnFloat     = "float"
+	columnBool      = "bool"
+	columnTimestamp = "timestamp" // RFC 3339
+)
+
+// csvColumn is a column pinned by a -columns file.
+type csvColumn struct {
+	Name    string  `yaml:"name"`
+	Default *string `yaml:"default,omitempty"` // written when the value is missing or null
+	Type    string  `yaml:"type"`              // values not of the type are written empty
+}
+
+// UnmarshalYAML accepts a column given by its name alone as well as one
+// with settings.
+func (c *csvColumn) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&c.Name)
+	}
+	type plain csvColumn
+	return node.Decode((*plain)(c))
+}
+
+// loadColumns reads a -columns file: a list of columns under "columns", in
+// the order they are written.
+func loadColumns(path string) ([]csvColumn, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read columns file: %w", err)
+	}
+	var spec struct {
+		Columns []csvColumn `yaml:"columns"`
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&spec); err != nil {
+		return nil, fmt.Errorf("cannot parse columns file %s: %w", path, err)
+	}
+	if len(spec.Columns) == 0 {
+		return nil, fmt.Errorf("%s declares no columns", path)
+	}
+	seen := make(map[string]bool)
+	for i, c := range spec.Columns {
+		switch {
+		case c.Name == "":
+			return nil, fmt.Errorf("%s: column %d has no name", path, i+1)
+		case seen[c.Name]:
+			return nil, fmt.Errorf("%s: column %s is declared twice", path, c.Name)
+		}
+		seen[c.Name] = true
+		switch c.Type {
+		case "", columnString, columnInt, columnFloat, columnBool, columnTimestamp:
+		default:
+			return nil, fmt.Errorf("%s: column %s has unknown type %q (expected string, int, float, bool or timestamp)", path, c.Name, c.Type)
+		}
+		if c.Default != nil && !c.matches(*c.Default) {
+			return nil, fmt.Errorf("%s: default of column %s is not a %s", path, c.Name, c.Type)
+		}
+	}
+	return spec.Columns, nil
+}
+
+// columnNames returns the names of columns, in order.
+func columnNames(columns []csvColumn) []string {
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = c.Name
+	}
+	return names
+}
+
+// matches reports whether a cell holds a value of the column's type.
+func (c csvColumn) matches(cell string) bool {
+	var err error
+	switch c.Type {
+	case columnInt:
+		_, err = strconv.ParseInt(cell, 10, 64)
+	case columnFloat:
+		_, err = strconv.ParseFloat(cell, 64)
+	case columnBool:
+		_, err = strconv.ParseBool(cell)
+	case columnTimestamp:
+		_, err = time.Parse(time.RFC3339Nano, cell)
+	}
+	return err == nil
+}
+
+// valueType returns the column type of a flattened value, or "" for null.
+// Text is a timestamp when it is RFC 3339, as the default -time-format
+// writes timestamps.
+func valueType(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case bool:
+		return columnBool
+	case json.Number:
+		if _, err := strconv.ParseInt(t.String(), 10, 64); err == nil {
+			return columnInt
+		}
+		return columnFloat
+	case string:
+		// Cheap checks first, as most text is not a timestamp
+		if len(t) >= len("2006-01-02T15:04:05Z") && t[4] == '-' && t[10] == 'T' {
+			if _, err := time.Parse(time.RFC3339Nano, t); err == nil {
+				return columnTimestamp
+			}
+		}
+	}
+	return columnString
+}
+
+// widenType returns the narrowest type holding values of both types: int
+// and float make float, and any other mix string. "" is no type yet.
+func widenType(a, b string) string {
+	switch {
+	case a == "" || a == b:
+		return b
+	case b == "":
+		return a
+	case (a == columnInt && b == columnFloat) || (a == columnFloat && b == columnInt):
+		return columnFloat
+	}
+	return columnString
+}
+
+// typedValue converts a flattened value to the Go value of a column type:
+// int64, float64, bool, time.Time or string. It reports false for null and
+// for values not of the type.
+func typedValue(typ string, v interface{}) (interface{}, bool) {
+	if v == nil {
+		return nil, false
+	}
+	switch typ {
+	case columnInt:
+		if n, ok := v.(json.Number); ok {
+			i, err := n.Int64()
+			return i, err == nil
+		}
+	case columnFloat:
+		if n, ok := v.(json.Number); ok {
+			f, err := n.Float64()
+			return f, err == nil
+		}
+	case columnBool:
+		b, ok := v.(bool)
+		return b, ok
+	case columnTimestamp:
+		if text, ok := v.(string); ok {
+			t, err := time.Parse(time.RFC3339Nano, text)
+			return t, err == nil
+		}
+	default:
+		return csvValue(v), true
+	}
+	return nil, false
+}
+
+// writeColumnTypes writes the columns and their inferred types next to a
+// CSV output, in the -columns format, so a later run can pin them.
+func writeColumnTypes(path string, names []string, types map[string]string) error {
+	columns := make([]csvColumn, len(names))
+	for i, name := range names {
+		columns[i] = csvColumn{Name: name, Type: types[name]}
+		if columns[i].Type == "" {
+			columns[i].Type = columnString
+		}
+	}
+	data, err := yaml.Marshal(struct {
+		Columns []csvColumn `yaml:"columns"`
+	}{columns})
+	if err != nil {
+		return err
+	}
+	out, err := openOutput(path)
+	if err == nil {
+		_, err = out.Write(data)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("cannot write column types: %w", err)
+	}
+	return nil
+}
+
+// convertPinnedCSV converts an input to CSV with the columns of a -columns
+// file. The header is known up front, so unlike convertCSV it reads the
+// input once.
+func convertPinnedCSV(in inputFile, output string, opts csvOptions) (avroconvert.Stats, error) {
+	opts.decode.blocks = in.blocks
+	input, err := openDecodeInput(in.path, opts.decode)
+	if err != nil {
+		return avroconvert.Stats{}, err
+	}
+	defer input.Close()
+
+	// Warnings are given once per input, not once per part of the output
+	dropped, mistyped := make(map[string]bool), make(map[string]bool)
+	names := columnNames(opts.columns)
+	split := openOutputSet(output, outputExt("csv", opts.decode.compress), opts.decode, func(w io.Writer) (avroconvert.Sink, error) {
+		pw := &pinnedCSVWriter{rows: newCSVRowWriter(w, names, opts.flatten, opts.dialect), columns: opts.columns, input: in.path, dropped: dropped, mistyped: mistyped}
+		if err := pw.rows.writeHeader(); err != nil {
+			return nil, fmt.Errorf("cannot write output file: %w", err)
+		}
+		return pw, nil
+	})
+	defer split.abort()
+
+	stats, err := decodeMessages(input, in.path, opts.decode, split)
+	if err != nil {
+		return stats, err
+	}
+	if err := split.Close(); err != nil {
+		return stats, err
+	}
+
+	slog.Info("Wrote CSV rows", "input", in.path, "rows", stats.Messages, "columns", len(names), "output", split.written(), filteredAttr(stats))
+	return stats, nil
+}
+
+// pinnedCSVWriter writes rows with pinned columns, filling in defaults and
+// emptying values not of their column's type. Fields without a column are
+// dropped, with a warning the first time each is seen.
+type pinnedCSVWriter struct {
+	rows    *csvRowWriter
+	columns []csvColumn
+	input   string
+	// fields and columns already warned about
+	dropped  map[string]bool
+	mistyped map[string]bool
+}
+
+func (pw *pinnedCSVWriter) WriteRecord(msg json.RawMessage) error {
+	v, err := parseMessage(msg)
+	if err != nil {
+		return err
+	}
+	pw.rows.fill(v, func(name string) {
+		if !pw.dropped[name] {
+			pw.dropped[name] = true
+			slog.Warn("Dropping field not in -columns", "input", displayPath(pw.input), "field", name)
+		}
+	})
+	for i, c := range pw.columns {
+		cell, null := pw.rows.row[i], pw.rows.nulls[i]
+		if !null && cell != "" && !c.matches(cell) {
+			if !pw.mistyped[c.Name] {
+				pw.mistyped[c.Name] = true
+				slog.Warn("Emptying values not of their column's type", "input", displayPath(pw.input), "column", c.Name, "type", c.Type, "value", cell)
+			}
+			cell, null = "", true
+		}
+		if cell == "" && c.Default != nil {
+			cell, null = *c.Default, false
+		}
+		pw.rows.row[i], pw.rows.nulls[i] = cell, null
+	}
+	return pw.rows.w.WriteRow(pw.rows.row, pw.rows.nulls)
+}
+
+func (pw *pinnedCSVWriter) Flush() error {
+	return pw.rows.Flush()
+}
+
+func (pw *pinnedCSVWriter) Close() error {
+	return pw.rows.Close()
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadColumns(t *testing.T) {
+	path := writeTestFile(t, "columns.yaml", []byte("columns:\n"+
+		"  - id\n"+
+		"  - name: level\n"+
+		"    type: int\n"+
+		"    default: \"0\"\n"+
+		"  - name: geo.country\n"+
+		"    default: unknown\n"))
+	columns, err := loadColumns(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := columnNames(columns); !reflect.DeepEqual(names, []string{"id", "level", "geo.country"}) {
+		t.Fatalf("loaded columns %v", names)
+	}
+	if columns[1].Type != columnInt || *columns[1].Default != "0" || columns[0].Default != nil {
+		t.Fatalf("loaded %+v", columns)
+	}
+
+	for name, spec := range map[string]string{
+		"empty":       "columns: []\n",
+		"unnamed":     "columns:\n  - type: int\n",
+		"duplicate":   "columns:\n  - id\n  - name: id\n",
+		"bad type":    "columns:\n  - name: id\n    type: uuid\n",
+		"bad default": "columns:\n  - name: id\n    type: int\n    default: none\n",
+	} {
+		path := writeTestFile(t, "columns.yaml", []byte(spec))
+		if _, err := loadColumns(path); err == nil {
+			t.Errorf("%s: loadColumns succeeded", name)
+		}
+	}
+}
+
+func TestConvertPinnedCSV(t *testing.T) {
+	data := writeMessageOCF(t,
+		`{"id":1,"level":3,"geo":{"country":"DE"}}`,
+		`{"id":2,"level":"high","extra":true}`,
+		`{"id":3,"level":null,"geo":{"country":null}}`,
+	)
+	in := inputFile{path: writeTestFile(t, "events.avro", data), rel: "events.avro"}
+	columns, err := loadColumns(writeTestFile(t, "columns.yaml", []byte("columns:\n"+
+		"  - geo.country\n"+
+		"  - id\n"+
+		"  - name: level\n"+
+		"    type: int\n"+
+		"    default: \"0\"\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(t.TempDir(), "events.csv")
+	opts := csvOptions{decode: testOptions(t, "message"), flatten: flattener{separator: "."}, columns: columns}
+
+	stats, err := convertPinnedCSV(in, output, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "geo.country,id,level\n" +
+		"DE,1,3\n" +
+		",2,0\n" +
+		",3,0\n"
+	if string(got) != want || stats.Messages != 3 {
+		t.Fatalf("wrote %q (%+v), want %q", got, stats, want)
+	}
+	if strings.Contains(string(got), "extra") {
+		t.Fatalf("wrote a column for a field not in -columns")
+	}
+}
+
+func TestValueType(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  string
+	}{
+		{nil, ""},
+		{true, columnBool},
+		{json.Number("42"), columnInt},
+		{json.Number("-1.5"), columnFloat},
+		{json.Number("1e3"), columnFloat},
+		{"2026-01-02T03:04:05.123Z", columnTimestamp},
+		{"2026-01-02T03:04:05+02:00", columnTimestamp},
+		{"2026-01-02 03:04:05", columnString},
+		{"2026-13-02T03:04:05Z", columnString},
+		{"DE", columnString},
+	}
+	for _, tt := range tests {
+		if got := valueType(tt.value); got != tt.want {
+			t.Errorf("valueType(%#v) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestWidenType(t *testing.T) {
+	tests := []struct{ a, b, want string }{
+		{"", columnInt, columnInt},
+		{columnInt, "", columnInt},
+		{columnInt, columnInt, columnInt},
+		{columnInt, columnFloat, columnFloat},
+		{columnFloat, columnInt, columnFloat},
+		{columnInt, columnBool, columnString},
+		{columnTimestamp, columnString, columnString},
+	}
+	for _, tt := range tests {
+		if got := widenType(tt.a, tt.b); got != tt.want {
+			t.Errorf("widenType(%q, %q) = %q, want %q", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestConvertCSVColumnTypes(t *testing.T) {
+	data := writeMessageOCF(t,
+		`{"id":1,"score":3,"ok":true,"at":"2026-01-02T03:04:05Z"}`,
+		`{"id":2,"score":2.5,"ok":null,"at":"2026-01-02T04:00:00Z","note":"x"}`,
+		`{"id":3,"score":1,"note":7}`,
+	)
+	in := inputFile{path: writeTestFile(t, "events.avro", data), rel: "events.avro"}
+	dir := t.TempDir()
+	opts := csvOptions{decode: testOptions(t, "message"), flatten: flattener{separator: "."}, columnTypes: true}
+
+	if _, err := convertCSV(in, filepath.Join(dir, "events.csv"), opts); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "events.columns.yaml")
+	columns, err := loadColumns(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []csvColumn{
+		{Name: "at", Type: columnTimestamp},
+		{Name: "id", Type: columnInt},
+		{Name: "ok", Type: columnBool},
+		{Name: "score", Type: columnFloat},
+		{Name: "note", Type: columnString},
+	}
+	if !reflect.DeepEqual(columns, want) {
+		data, _ := os.ReadFile(path)
+		t.Fatalf("wrote columns %s, want %v", data, want)
+	}
+}
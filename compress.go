@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Magic bytes of the compression formats inputs are checked for.
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// compressionSuffixes are stripped from input names before their extension
+// is checked or swapped, so events.avro.gz is treated like events.avro.
+var compressionSuffixes = []string{".gz", ".gzip", ".zst", ".zstd"}
+
+// decompress detects gzip or zstd compressed input by its magic bytes and
+// returns a reader that decompresses it on the fly. Other input is returned
+// unchanged. Closing the result closes r.
+func decompress(r io.ReadCloser) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(len(zstdMagic))
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		// Concatenated gzip members, as written by appending, are read as one stream
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			r.Close()
+			return nil, fmt.Errorf("cannot read gzip input: %w", err)
+		}
+		return readCloser{Reader: zr, close: func() error { zr.Close(); return r.Close() }}, nil
+
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			r.Close()
+			return nil, fmt.Errorf("cannot read zstd input: %w", err)
+		}
+		return readCloser{Reader: zr, close: func() error { zr.Close(); return r.Close() }}, nil
+	}
+
+	return readCloser{Reader: br, close: r.Close}, nil
+}
+
+// readCloser pairs a reader with the function that releases it.
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (rc readCloser) Close() error { return rc.close() }
+
+// trimCompressionSuffix removes a compression extension from a file name.
+func trimCompressionSuffix(name string) string {
+	lower := strings.ToLower(name)
+	for _, suffix := range compressionSuffixes {
+		if strings.HasSuffix(lower, suffix) {
+			return name[:len(name)-len(suffix)]
+		}
+	}
+	return name
+}
+
+// Output compressions accepted by -compress.
+const (
+	compressNone = "none"
+	compressGzip = "gzip"
+	compressZstd = "zstd"
+)
+
+// checkCompression validates a -compress value.
+func checkCompression(compression string) error {
+	switch compression {
+	case compressNone, compressGzip, compressZstd:
+		return nil
+	}
+	return fmt.Errorf("unknown compression %q (expected gzip, zstd or none)", compression)
+}
+
+// outputExt returns the extension of output files in format, with the
+// compression's extension appended, e.g. ndjson.gz.
+func outputExt(format, compression string) string {
+	if format == "protobuf" {
+		format = "pb"
+	}
+	switch compression {
+	case compressGzip:
+		return format + ".gz"
+	case compressZstd:
+		return format + ".zst"
+	}
+	return format
+}
+
+// compressOutput wraps an output so everything written to it is compressed.
+// Closing the result finishes the compressed stream and closes w.
+func compressOutput(w io.WriteCloser, compression string) (io.WriteCloser, error) {
+	switch compression {
+	case compressGzip:
+		return &compressedWriter{compressor: gzip.NewWriter(w), w: w}, nil
+	case compressZstd:
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create zstd writer: %w", err)
+		}
+		return &compressedWriter{compressor: zw, w: w}, nil
+	}
+	return w, nil
+}
+
+// compressedWriter is an output behind a gzip or zstd compressor.
+type compressedWriter struct {
+	compressor interface {
+		io.WriteCloser
+		Flush() error
+	}
+	w      io.WriteCloser
+	closed bool
+}
+
+func (cw *compressedWriter) Write(p []byte) (int, error) {
+	return cw.compressor.Write(p)
+}
+
+// Flush writes everything compressed so far through to the output, so it
+// can be decompressed up to this point.
+func (cw *compressedWriter) Flush() error {
+	return cw.compressor.Flush()
+}
+
+// abort stops the compressor, releasing its buffers and goroutines, and
+// aborts the output.
+func (cw *compressedWriter) abort() {
+	if !cw.closed {
+		cw.closed = true
+		cw.compressor.Close()
+	}
+	abortOutput(cw.w)
+}
+
+// Close finishes the compressed stream and closes the output. Closing again
+// does nothing.
+func (cw *compressedWriter) Close() error {
+	if cw.closed {
+		return nil
+	}
+	cw.closed = true
+	if err := cw.compressor.Close(); err != nil {
+		cw.w.Close()
+		return err
+	}
+	return cw.w.Close()
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestOpenInputDecompresses(t *testing.T) {
+	data := writeMessageOCF(t, `{"id":1}`)
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(data[:10])
+	zw.Close()
+	// A second member, as appending to a .gz file writes
+	zw = gzip.NewWriter(&gz)
+	zw.Write(data[10:])
+	zw.Close()
+
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zst := enc.EncodeAll(data, nil)
+	enc.Close()
+
+	for name, content := range map[string][]byte{
+		"plain.avro":      data,
+		"events.avro.gz":  gz.Bytes(),
+		"events.avro.zst": zst,
+	} {
+		r, err := openInput(writeTestFile(t, name, content))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		got, err := io.ReadAll(r)
+		r.Close()
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("%s: read %d bytes, %v", name, len(got), err)
+		}
+	}
+}
+
+func TestCompressedInputNames(t *testing.T) {
+	for name, want := range map[string]bool{
+		"a.avro": true, "a.AVRO.GZ": true, "a.avro.zst": true, "a.avro.zstd": true, "a.json.gz": false, "a.gz": false,
+	} {
+		if got := isAvroName(name); got != want {
+			t.Errorf("isAvroName(%q) = %v", name, got)
+		}
+	}
+	if got := outputPath(inputFile{rel: "2026/events.avro.gz"}, "out", "json"); got != filepath.Join("out", "2026", "events.json") {
+		t.Errorf("output path %s", got)
+	}
+}
+
+func TestConvertCompressed(t *testing.T) {
+	data := writeMessageOCF(t, `{"id":1}`, `{"id":2}`)
+	in := inputFile{path: writeTestFile(t, "events.avro", data), rel: "events.avro"}
+	for compression, ext := range map[string]string{compressGzip: "ndjson.gz", compressZstd: "ndjson.zst"} {
+		dir := t.TempDir()
+		opts := testOptions(t, "message")
+		opts.outputDir, opts.format, opts.compress = dir, "ndjson", compression
+		if result := convertFile(in, opts); result.err != nil {
+			t.Fatal(result.err)
+		}
+
+		// Reading compressed input back goes through the same detection
+		r, err := openInput(filepath.Join(dir, "events."+ext))
+		if err != nil {
+			t.Fatalf("%s: %v", compression, err)
+		}
+		got, err := io.ReadAll(r)
+		r.Close()
+		if want := "{\"id\":1}\n{\"id\":2}\n"; err != nil || string(got) != want {
+			t.Fatalf("%s: read %q, %v", compression, got, err)
+		}
+	}
+	if err := checkCompression("brotli"); err == nil {
+		t.Fatal("accepted an unknown compression")
+	}
+}
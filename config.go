@@ -0,0 +1,122 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// parseFlags parses the command line of a subcommand, adding -config. The
+// config file sets flags by name, and flags given on the command line take
+// precedence over it. A job of run then gets the defaults of its jobs file
+// for the flags still unset. Errors exit like flag.ExitOnError does. A
+// command whose own -config names another file, as run's jobs file, is
+// parsed without one.
+func parseFlags(fs *flag.FlagSet, args []string) {
+	if fs.Lookup("config") != nil {
+		fs.Parse(args)
+		return
+	}
+	configPath := fs.String("config", "", "YAML file setting this command's flags by name, e.g. 'format: ndjson'; flags on the command line take precedence")
+	fs.Parse(args)
+	if *configPath != "" {
+		config, err := loadConfig(*configPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(exitFatal)
+		}
+		if err := config.apply(fs); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(exitFatal)
+		}
+	}
+	if err := applyJobDefaults(fs); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+}
+
+// flagConfig holds flag values read from a config file, by flag name.
+type flagConfig struct {
+	path   string
+	values map[string]interface{}
+}
+
+// loadConfig reads a YAML config file, a mapping of flag names to values.
+func loadConfig(path string) (*flagConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read config: %w", err)
+	}
+	config := &flagConfig{path: path}
+	if err := yaml.Unmarshal(data, &config.values); err != nil {
+		return nil, fmt.Errorf("cannot parse config %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// apply sets the flags of fs the config has values for, unless they were
+// given on the command line. Lists set a flag once per item, for flags that
+// may be repeated like -header.
+func (c *flagConfig) apply(fs *flag.FlagSet) error {
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	names := make([]string, 0, len(c.values))
+	for name := range c.values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if name == "config" {
+			return fmt.Errorf("%s: config files cannot set -config", c.path)
+		}
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("%s: %s has no flag -%s", c.path, fs.Name(), name)
+		}
+		if given[name] {
+			continue
+		}
+		values, err := configValues(c.values[name])
+		if err != nil {
+			return fmt.Errorf("%s: invalid %s: %w", c.path, name, err)
+		}
+		for _, value := range values {
+			if err := fs.Set(name, value); err != nil {
+				return fmt.Errorf("%s: invalid %s: %w", c.path, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// configValues renders a config value as the flag values it stands for.
+func configValues(v interface{}) ([]string, error) {
+	items, ok := v.([]interface{})
+	if !ok {
+		items = []interface{}{v}
+	}
+	values := make([]string, 0, len(items))
+	for _, item := range items {
+		switch t := item.(type) {
+		case string:
+			values = append(values, t)
+		case bool:
+			values = append(values, strconv.FormatBool(t))
+		case int:
+			values = append(values, strconv.Itoa(t))
+		case float64:
+			values = append(values, strconv.FormatFloat(t, 'g', -1, 64))
+		case nil:
+			return nil, fmt.Errorf("missing value")
+		default:
+			return nil, fmt.Errorf("expected a string, number, boolean or a list of them, got %T", item)
+		}
+	}
+	return values, nil
+}
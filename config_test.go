@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// configFlagSet returns a flag set with flags of every kind a config sets.
+func configFlagSet() (*flag.FlagSet, *string, *bool, *int, *float64, *headerFlags) {
+	fs := flag.NewFlagSet("decode", flag.ContinueOnError)
+	format := fs.String("format", "json", "")
+	pretty := fs.Bool("pretty", true, "")
+	workers := fs.Int("workers", 1, "")
+	rate := fs.Float64("sample-rate", 1, "")
+	var headers headerFlags
+	fs.Var(&headers, "header", "")
+	return fs, format, pretty, workers, rate, &headers
+}
+
+func writeConfig(t *testing.T, text string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfigApply(t *testing.T) {
+	path := writeConfig(t, `
+format: ndjson
+pretty: false
+workers: 8
+sample-rate: 0.25
+header:
+  - "Authorization: Bearer abc"
+  - "X-Source: avroparser"
+`)
+	fs, format, pretty, workers, rate, headers := configFlagSet()
+	// Flags on the command line take precedence
+	if err := fs.Parse([]string{"-workers", "2"}); err != nil {
+		t.Fatal(err)
+	}
+	config, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := config.apply(fs); err != nil {
+		t.Fatal(err)
+	}
+	if *format != "ndjson" || *pretty || *workers != 2 || *rate != 0.25 {
+		t.Fatalf("set format %s, pretty %v, workers %d, sample rate %g", *format, *pretty, *workers, *rate)
+	}
+	if want := (headerFlags{"Authorization: Bearer abc", "X-Source: avroparser"}); !reflect.DeepEqual(*headers, want) {
+		t.Fatalf("set headers %q", *headers)
+	}
+}
+
+func TestConfigApplyErrors(t *testing.T) {
+	for _, text := range []string{
+		"output: out",        // no such flag
+		"config: other.yaml", // nested configs
+		"workers: eight",     // invalid value
+		"format:",            // missing value
+		"format: {a: b}",     // not a flag value
+		"- not a mapping",    // not a mapping
+	} {
+		fs, _, _, _, _, _ := configFlagSet()
+		fs.String("config", "", "")
+		config, err := loadConfig(writeConfig(t, text))
+		if err == nil {
+			err = config.apply(fs)
+		}
+		if err == nil {
+			t.Errorf("applied %q", text)
+		}
+	}
+}
+
+func TestParseFlagsOwnConfig(t *testing.T) {
+	// run's -config names its jobs file, which isn't a flags config
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	jobs := fs.String("config", "", "")
+	parallel := fs.Int("parallel", 0, "")
+	parseFlags(fs, []string{"-config", writeConfig(t, "jobs: []\n"), "-parallel", "2"})
+	if *jobs == "" || *parallel != 2 {
+		t.Fatalf("parsed -config %q and -parallel %d", *jobs, *parallel)
+	}
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"avroparser/pkg/avroconvert"
+	"github.com/linkedin/goavro/v2"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func runConsume(args []string) {
+	fs := flag.NewFlagSet("consume", flag.ExitOnError)
+	brokers := fs.String("brokers", "localhost:9092", "Comma-separated Kafka bootstrap brokers")
+	topic := fs.String("topic", "", "Topic to consume")
+	group := fs.String("group", "avroparser", "Consumer group; its committed offsets checkpoint progress between runs")
+	start := fs.String("start", "earliest", "Where a group without committed offsets starts: earliest or latest")
+	schemaPath := fs.String("schema", "", "Writer schema (.avsc) of the topic's Confluent-framed values")
+	outputDir := fs.String("output", "output", "Output directory, or - for stdout")
+	format := fs.String("format", "ndjson", "Output format: ndjson or csv")
+	separator := fs.String("separator", ".", "Separator joining nested field names into CSV column names")
+	compress := fs.String("compress", compressNone, "Compress the output: gzip, zstd or none")
+	csvOutput := addCSVFlags(fs)
+	records := addRecordFlags(fs)
+	redaction := addRedactFlags(fs)
+	joining := addJoinFlags(fs)
+	logging := addLogFlags(fs)
+	parseFlags(fs, args)
+	logging.setup()
+
+	if *topic == "" || *schemaPath == "" {
+		fmt.Fprintln(os.Stderr, "Usage: avroparser consume -topic <topic> -schema <schema.avsc> [-brokers host:port] [-group name] [-output <output_dir>|-] [-format ndjson|csv]")
+		os.Exit(exitFatal)
+	}
+	if isGCSPath(*outputDir) {
+		fmt.Fprintln(os.Stderr, "consume appends to local files; gs:// output is not supported")
+		os.Exit(exitFatal)
+	}
+	if *format != "ndjson" && *format != "csv" {
+		fmt.Fprintf(os.Stderr, "Unknown output format %q (expected ndjson or csv)\n", *format)
+		os.Exit(exitFatal)
+	}
+
+	if err := checkCompression(*compress); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	dialect, err := csvOutput.dialect()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+
+	var resetOffset kgo.Offset
+	switch *start {
+	case "earliest":
+		resetOffset = kgo.NewOffset().AtStart()
+	case "latest":
+		resetOffset = kgo.NewOffset().AtEnd()
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown start position %q (expected earliest or latest)\n", *start)
+		os.Exit(exitFatal)
+	}
+
+	codec, err := loadCodec(*schemaPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	schema, err := avroconvert.ParseSchema(codec.Schema())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot parse schema %s: %v\n", *schemaPath, err)
+		os.Exit(exitFatal)
+	}
+	converter, err := records.converter()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	readerSchema, err := records.schema()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	filter, err := records.recordFilter()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	transform, err := records.recordTransform()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	plugin, err := records.recordPlugin()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	script, err := records.recordScript()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	redact, err := redaction.redactor()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	epoch, err := records.epochFields(converter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	coerce, err := records.coercion()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	join, err := joining.lookupJoin()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	sample, err := records.sampling()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	if sample.offset > 0 || sample.count > 0 {
+		fmt.Fprintln(os.Stderr, "consume reads a stream without positions; use -skip and -limit instead of -offset and -count")
+		os.Exit(exitFatal)
+	}
+	onError, err := records.errorPolicy(*outputDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+
+	client, err := kgo.NewClient(
+		kgo.SeedBrokers(strings.Split(*brokers, ",")...),
+		kgo.ConsumeTopics(*topic),
+		kgo.ConsumerGroup(*group),
+		kgo.ConsumeResetOffset(resetOffset),
+		kgo.DisableAutoCommit(),
+	)
+	if err != nil {
+		slog.Error("Cannot create Kafka client", "error", err)
+		os.Exit(exitFatal)
+	}
+	defer client.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	output := stdioPath
+	if *outputDir != stdioPath {
+		output = filepath.Join(*outputDir, *topic+"."+outputExt(*format, *compress))
+	}
+	opts := decodeOptions{outputDir: *outputDir, format: *format, compress: *compress, field: *records.field, converter: converter, readerSchema: readerSchema, filter: filter, transform: transform, extendedJSON: records.extendedJSON(converter), epochFields: epoch, coerce: coerce, redact: redact, join: join, plugin: plugin, script: script, sampling: sample, onError: onError}
+	if onError.mode == onErrorCollect {
+		// Like the output, the dead-letter file is appended to by every run
+		opts.deadLetters = newDeadLetterFile(filepath.Join(onError.dir, *topic+"."+deadLetterExt), *topic, appendOutput)
+	}
+
+	began := time.Now()
+	stats, err := consumeTopic(ctx, client, *topic, codec, schema, output, *separator, dialect, opts)
+	if opts.deadLetters != nil {
+		if closeErr := opts.deadLetters.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	slog.Info("Consumed messages", "topic", *topic, "messages", stats.Messages, "skipped", stats.Skipped, "duration", time.Since(began).Round(time.Millisecond))
+	if err != nil {
+		slog.Error("Cannot consume topic", "topic", *topic, "error", err)
+		os.Exit(exitFatal)
+	}
+}
+
+// consumeTopic decodes messages until ctx is cancelled, appending them to
+// output. Offsets are committed only after the messages before them have
+// been flushed to the output, so a restart resumes without losing messages
+// (a crash between flush and commit can repeat some).
+func consumeTopic(ctx context.Context, client *kgo.Client, topic string, codec *goavro.Codec, schema *avroconvert.Schema, output, separator string, dialect csvDialect, opts decodeOptions) (avroconvert.Stats, error) {
+	out, err := appendOutput(output)
+	if err != nil {
+		return avroconvert.Stats{}, err
+	}
+	// Each run appends a new gzip member or zstd frame, which decompress as
+	// one stream
+	if out, err = compressOutput(out, opts.compress); err != nil {
+		return avroconvert.Stats{}, err
+	}
+	defer out.Close()
+
+	buffered := bufio.NewWriter(out)
+	var writer avroconvert.Sink
+	if opts.format == "csv" {
+		header, err := existingCSVHeader(output, dialect)
+		if err != nil {
+			return avroconvert.Stats{}, err
+		}
+		writer = newStreamCSVWriter(buffered, header, separator, dialect)
+	} else {
+		writer = avroconvert.NewNDJSONSink(buffered)
+	}
+
+	flush := func() error {
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+		if opts.deadLetters != nil {
+			if err := opts.deadLetters.Flush(); err != nil {
+				return err
+			}
+		}
+		if err := buffered.Flush(); err != nil {
+			return err
+		}
+		if f, ok := out.(interface{ Flush() error }); ok {
+			return f.Flush()
+		}
+		return nil
+	}
+
+	reader := &kafkaReader{ctx: ctx, client: client, codec: codec, flush: flush, name: topic}
+	stats, err := decodeRecords(reader, schema, topic, opts, writer)
+	if err != nil {
+		return stats, err
+	}
+
+	// Commit what was written before the shutdown
+	if err := writer.Close(); err != nil {
+		return stats, fmt.Errorf("cannot write output: %w", err)
+	}
+	if err := reader.commit(context.Background()); err != nil {
+		return stats, err
+	}
+	return stats, reader.err
+}
+
+// existingCSVHeader returns the header of a CSV file being appended to, or
+// nil when there is none yet.
+func existingCSVHeader(path string, dialect csvDialect) ([]string, error) {
+	if path == stdioPath {
+		return nil, nil
+	}
+	// openInput also reads compressed files
+	f, err := openInput(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read output file: %w", err)
+	}
+	defer f.Close()
+
+	header, err := dialect.reader(f).Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read CSV header of %s: %w", path, err)
+	}
+	return header, nil
+}
+
+// kafkaReader is an avroconvert.RecordReader over Confluent-framed Avro
+// messages of a consumer group. Before polling for more messages it flushes the output
+// and commits the offsets of the messages already handed out.
+type kafkaReader struct {
+	ctx    context.Context
+	client *kgo.Client
+	codec  *goavro.Codec
+	flush  func() error
+	name   string
+
+	pending   []*kgo.Record // fetched but not yet decoded
+	delivered []*kgo.Record // decoded since the last commit
+	record    interface{}
+	readErr   error
+	err       error
+}
+
+func (kr *kafkaReader) Scan() bool {
+	for len(kr.pending) == 0 {
+		if err := kr.commit(kr.ctx); err != nil {
+			kr.err = err
+			return false
+		}
+
+		fetches := kr.client.PollFetches(kr.ctx)
+		if fetches.IsClientClosed() || kr.ctx.Err() != nil {
+			return false
+		}
+		fetches.EachError(func(topic string, partition int32, err error) {
+			slog.Error("Cannot fetch records", "input", kr.name, "topic", topic, "partition", partition, "error", err)
+		})
+		kr.pending = fetches.Records()
+	}
+
+	msg := kr.pending[0]
+	kr.pending = kr.pending[1:]
+	kr.delivered = append(kr.delivered, msg)
+
+	kr.record, kr.readErr = nil, nil
+	if len(msg.Value) < 5 || msg.Value[0] != 0 {
+		kr.readErr = fmt.Errorf("partition %d offset %d: missing Confluent wire format header", msg.Partition, msg.Offset)
+		return true
+	}
+	record, _, err := kr.codec.NativeFromBinary(msg.Value[5:])
+	if err != nil {
+		kr.readErr = fmt.Errorf("partition %d offset %d: %w", msg.Partition, msg.Offset, err)
+		return true
+	}
+	kr.record = record
+	return true
+}
+
+// Read returns the current record. Messages that cannot be decoded are
+// reported here, so they are skipped rather than stopping the consumer.
+func (kr *kafkaReader) Read() (interface{}, error) {
+	return kr.record, kr.readErr
+}
+
+// Raw returns the value of the current message.
+func (kr *kafkaReader) Raw() []byte {
+	if len(kr.delivered) == 0 {
+		return nil
+	}
+	return kr.delivered[len(kr.delivered)-1].Value
+}
+
+func (kr *kafkaReader) Err() error {
+	return kr.err
+}
+
+// commit flushes the output and commits the offsets of every message
+// delivered so far.
+func (kr *kafkaReader) commit(ctx context.Context) error {
+	if len(kr.delivered) == 0 {
+		return nil
+	}
+	if err := kr.flush(); err != nil {
+		return fmt.Errorf("cannot write output: %w", err)
+	}
+	if err := kr.client.CommitRecords(ctx, kr.delivered...); err != nil {
+		return fmt.Errorf("cannot commit offsets: %w", err)
+	}
+	kr.delivered = kr.delivered[:0]
+	return nil
+}
+
+// streamCSVWriter writes CSV without knowing every column up front: the
+// columns are those of the existing file, or else of the first message.
+// Columns that only appear later are dropped with a warning.
+type streamCSVWriter struct {
+	w         *bufio.Writer
+	rows      *csvRowWriter
+	separator string
+	dialect   csvDialect
+	dropped   map[string]bool
+}
+
+func newStreamCSVWriter(w *bufio.Writer, header []string, separator string, dialect csvDialect) *streamCSVWriter {
+	sw := &streamCSVWriter{w: w, separator: separator, dialect: dialect, dropped: make(map[string]bool)}
+	if header != nil {
+		sw.rows = newCSVRowWriter(w, header, flattener{separator: separator}, dialect.appending())
+	}
+	return sw
+}
+
+func (sw *streamCSVWriter) WriteRecord(msg json.RawMessage) error {
+	v, err := parseMessage(msg)
+	if err != nil {
+		return err
+	}
+	fields := flattenRecord(v, sw.separator)
+
+	if sw.rows == nil {
+		columns := make([]string, len(fields))
+		for i, f := range fields {
+			columns[i] = f.name
+		}
+		sw.rows = newCSVRowWriter(sw.w, columns, flattener{separator: sw.separator}, sw.dialect)
+		if err := sw.rows.writeHeader(); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range fields {
+		if _, ok := sw.rows.index[f.name]; !ok && !sw.dropped[f.name] {
+			sw.dropped[f.name] = true
+			slog.Warn("Dropping column, which is not in the CSV header", "column", f.name)
+		}
+	}
+	return sw.rows.WriteRecord(msg)
+}
+
+// Flush writes buffered rows through to the underlying writer.
+func (sw *streamCSVWriter) Flush() error {
+	if sw.rows == nil {
+		return nil
+	}
+	return sw.rows.Flush()
+}
+
+func (sw *streamCSVWriter) Close() error {
+	return sw.Flush()
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// appendConsumedCSV appends msgs to the CSV file at path the way consume does,
+// continuing the existing header if there is one.
+func appendConsumedCSV(t *testing.T, path string, msgs ...string) {
+	t.Helper()
+	header, err := existingCSVHeader(path, csvDialect{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := appendOutput(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	w := newStreamCSVWriter(bufio.NewWriter(out), header, ".", csvDialect{})
+	for _, msg := range msgs {
+		if err := w.WriteRecord(json.RawMessage(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStreamCSVAppend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "topic", "events.csv")
+	if header, err := existingCSVHeader(path, csvDialect{}); err != nil || header != nil {
+		t.Fatalf("header of a missing file: %v, %v", header, err)
+	}
+
+	appendConsumedCSV(t, path, `{"id":1,"geo":{"country":"DE"}}`, `{"id":2}`)
+	appendConsumedCSV(t, path, `{"id":3,"extra":true}`)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "geo.country,id\nDE,1\n,2\n,3\n"; string(data) != want {
+		t.Fatalf("wrote %q, want %q", data, want)
+	}
+	if header, err := existingCSVHeader(path, csvDialect{}); err != nil || !reflect.DeepEqual(header, []string{"geo.country", "id"}) {
+		t.Fatalf("read header %v, %v", header, err)
+	}
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"avroparser/pkg/avroconvert"
+)
+
+func runCSV2Avro(args []string) {
+	fs := flag.NewFlagSet("csv2avro", flag.ExitOnError)
+	inputPath := fs.String("input", "", "Input CSV file with a header row, or - for stdin")
+	outputPath := fs.String("output", "", "Output Avro file, or - for stdout (default: the input name with an .avro extension)")
+	typesPath := fs.String("types", "", "JSON file mapping column names to Avro types, overriding the inferred ones")
+	schemaOutput := fs.String("schema-output", "", "Also write the schema used to this .avsc file")
+	name := fs.String("name", "", "Name of the record type (default: derived from the input name)")
+	sampleRows := fs.Int("sample", 1000, "Number of rows used to infer column types")
+	codec := fs.String("codec", "null", "Block compression: null, deflate, snappy, xz or zstd")
+	timeFormat := fs.String("time-format", avroconvert.TimeFormatRFC3339, "Timestamp format of the input: rfc3339, unix, unixmilli, unixmicro, unixnano or a Go time layout")
+	timeZone := fs.String("timezone", "UTC", "Time zone of timestamps whose -time-format has none (IANA name, e.g. Europe/Berlin)")
+	logging := addLogFlags(fs)
+	parseFlags(fs, args)
+	logging.setup()
+
+	if *inputPath == "" && fs.NArg() > 0 {
+		*inputPath = fs.Arg(0)
+	}
+
+	if *inputPath == "" {
+		fmt.Fprintln(os.Stderr, "Usage: avroparser csv2avro -input <csv_file|-> [-output <avro_file>|-] [-types <types.json>] [-codec null|deflate|snappy|xz|zstd]")
+		os.Exit(exitFatal)
+	}
+
+	codecName, ok := ocfCodecs[*codec]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown codec %q (expected null, deflate, snappy, xz or zstd)\n", *codec)
+		os.Exit(exitFatal)
+	}
+	if *sampleRows < 1 {
+		fmt.Fprintf(os.Stderr, "-sample must be at least 1, got %d\n", *sampleRows)
+		os.Exit(exitFatal)
+	}
+	converter, err := newNativeConverter(*timeFormat, *timeZone)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	types, err := loadColumnTypes(*typesPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+
+	if *name == "" {
+		*name = "Record"
+		if *inputPath != stdioPath {
+			base := filepath.Base(trimCompressionSuffix(*inputPath))
+			*name = avroName(strings.TrimSuffix(base, filepath.Ext(base)))
+		}
+	}
+	if *outputPath == "" {
+		*outputPath = avroOutputPath(*inputPath)
+	}
+
+	input := *inputPath
+	if input == stdioPath {
+		input = "stdin"
+	}
+	opts := csvImport{name: *name, types: types, sample: *sampleRows, codec: codecName, schemaOutput: *schemaOutput, converter: converter}
+	count, err := convertCSVToAvro(*inputPath, *outputPath, opts)
+	if err != nil {
+		slog.Error("Cannot convert input", "input", input, "error", err)
+		os.Exit(exitFatal)
+	}
+	slog.Info("Encoded rows", "input", input, "rows", count, "output", displayPath(*outputPath))
+}
+
+// csvImport holds the settings for csv2avro.
+type csvImport struct {
+	name         string
+	types        map[string]json.RawMessage // Avro types by column name, from -types
+	sample       int
+	codec        string
+	schemaOutput string
+	converter    *nativeConverter
+}
+
+// loadColumnTypes reads a -types file: a JSON object mapping column names to
+// Avro types, e.g. {"score": "double", "day": {"type": "int", "logicalType": "date"}}.
+func loadColumnTypes(path string) (map[string]json.RawMessage, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read types: %w", err)
+	}
+	var types map[string]json.RawMessage
+	if err := json.Unmarshal(data, &types); err != nil {
+		return nil, fmt.Errorf("cannot parse types %s: %w", path, err)
+	}
+	return types, nil
+}
+
+// convertCSVToAvro infers a schema from the header and the first rows of a
+// CSV input and writes every row as an Avro record. It returns the number
+// of rows written.
+func convertCSVToAvro(input, output string, opts csvImport) (int, error) {
+	in, err := openInput(input)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	r := csv.NewReader(in)
+	header, err := r.Read()
+	if errors.Is(err, io.EOF) {
+		return 0, errors.New("input has no header row")
+	}
+	if err != nil {
+		return 0, fmt.Errorf("cannot read CSV: %w", err)
+	}
+
+	var sample [][]string
+	for len(sample) < opts.sample {
+		row, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("cannot read CSV: %w", err)
+		}
+		sample = append(sample, row)
+	}
+
+	spec, schema, err := csvSchema(header, sample, opts)
+	if err != nil {
+		return 0, err
+	}
+	if opts.schemaOutput != "" {
+		if err := os.WriteFile(opts.schemaOutput, append(spec, '\n'), 0o644); err != nil {
+			return 0, fmt.Errorf("cannot write schema: %w", err)
+		}
+	}
+
+	out, err := openOutput(output)
+	if err != nil {
+		return 0, err
+	}
+	ow, err := newOCFWriter(out, string(spec), opts.codec)
+	if err != nil {
+		abortOutput(out)
+		return 0, err
+	}
+
+	count := 0
+	write := func(row []string) error {
+		record := make(map[string]interface{}, len(schema.Fields))
+		for i, f := range schema.Fields {
+			if i < len(row) {
+				record[f.Name] = csvCellValue(f.Schema, row[i])
+			}
+		}
+		// Rows are counted from the header, as spreadsheets show them
+		native, err := opts.converter.native(schema, record)
+		if err != nil {
+			return fmt.Errorf("row %d: %w (see -types and -sample)", count+2, err)
+		}
+		if err := ow.Write(native); err != nil {
+			return fmt.Errorf("row %d: %w", count+2, err)
+		}
+		count++
+		return nil
+	}
+
+	for _, row := range sample {
+		if err = write(row); err != nil {
+			break
+		}
+	}
+	for err == nil {
+		var row []string
+		if row, err = r.Read(); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = nil
+				break
+			}
+			err = fmt.Errorf("cannot read CSV: %w", err)
+			break
+		}
+		err = write(row)
+	}
+
+	if err == nil {
+		err = ow.Close()
+	}
+	if err != nil {
+		abortOutput(out)
+		return count, err
+	}
+	if err := out.Close(); err != nil {
+		return count, fmt.Errorf("cannot write output file: %w", err)
+	}
+	return count, nil
+}
+
+// csvSchema builds the record schema of a CSV input, returning its JSON and
+// parsed forms: a field per column, in order, typed by -types or inferred
+// from the sampled rows.
+func csvSchema(header []string, sample [][]string, opts csvImport) ([]byte, *avroconvert.Schema, error) {
+	type field struct {
+		Name string          `json:"name"`
+		Type json.RawMessage `json:"type"`
+	}
+	record := struct {
+		Type   string  `json:"type"`
+		Name   string  `json:"name"`
+		Fields []field `json:"fields"`
+	}{Type: "record", Name: opts.name}
+
+	seen := make(map[string]string)
+	for i, name := range header {
+		fieldName := avroName(name)
+		if previous, ok := seen[fieldName]; ok {
+			return nil, nil, fmt.Errorf("columns %q and %q both become field %s", previous, name, fieldName)
+		}
+		seen[fieldName] = name
+
+		fieldType, ok := opts.types[name]
+		if !ok {
+			values := make([]string, 0, len(sample))
+			for _, row := range sample {
+				if i < len(row) {
+					values = append(values, row[i])
+				}
+			}
+			fieldType = inferCSVType(values, opts.converter)
+		}
+		record.Fields = append(record.Fields, field{Name: fieldName, Type: fieldType})
+	}
+	for name := range opts.types {
+		if seen[avroName(name)] != name {
+			return nil, nil, fmt.Errorf("-types names column %q, which the input doesn't have", name)
+		}
+	}
+
+	spec, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return nil, nil, err
+	}
+	schema, err := avroconvert.ParseSchema(string(spec))
+	if err != nil {
+		return nil, nil, err
+	}
+	return spec, schema, nil
+}
+
+// inferCSVType picks the narrowest Avro type every sampled value of a
+// column fits: boolean, long, double, a timestamp in the -time-format, a
+// date, or else string. Columns with empty values are nullable.
+func inferCSVType(values []string, converter *nativeConverter) json.RawMessage {
+	candidates := []struct {
+		schema string
+		fits   func(string) bool
+	}{
+		{`"boolean"`, func(v string) bool { return strings.EqualFold(v, "true") || strings.EqualFold(v, "false") }},
+		{`"long"`, func(v string) bool { _, err := strconv.ParseInt(v, 10, 64); return err == nil }},
+		{`"double"`, func(v string) bool { _, err := strconv.ParseFloat(v, 64); return err == nil }},
+		{`{"type": "long", "logicalType": "timestamp-micros"}`, func(v string) bool {
+			if isUnixTimeFormat(converter.timeFormat) {
+				return false
+			}
+			_, _, err := converter.timestamp("timestamp-micros", v, false)
+			return err == nil
+		}},
+		{`{"type": "int", "logicalType": "date"}`, func(v string) bool { _, err := time.Parse("2006-01-02", v); return err == nil }},
+	}
+
+	nullable, present := false, false
+	fits := make([]bool, len(candidates))
+	for i := range fits {
+		fits[i] = true
+	}
+	for _, v := range values {
+		if v == "" {
+			nullable = true
+			continue
+		}
+		present = true
+		for i, candidate := range candidates {
+			fits[i] = fits[i] && candidate.fits(v)
+		}
+	}
+
+	schema := `"string"`
+	if present {
+		for i, candidate := range candidates {
+			if fits[i] {
+				schema = candidate.schema
+				break
+			}
+		}
+	}
+	if nullable {
+		schema = `["null", ` + schema + `]`
+	}
+	return json.RawMessage(schema)
+}
+
+// csvCellValue turns a CSV cell into the JSON value nativeConverter expects
+// for a schema: numbers and booleans are parsed, nested types read as JSON
+// text (as avro2csv writes arrays), and empty cells of nullable columns
+// become null.
+func csvCellValue(s *avroconvert.Schema, cell string) interface{} {
+	if s.Kind == "union" {
+		var branch *avroconvert.Schema
+		for _, b := range s.Branches {
+			if b.Kind == "null" {
+				if cell == "" {
+					return nil
+				}
+				continue
+			}
+			if branch == nil {
+				branch = b
+			}
+		}
+		if branch == nil {
+			return cell
+		}
+		s = branch
+	}
+
+	switch s.Kind {
+	case "boolean":
+		if b, err := strconv.ParseBool(cell); err == nil {
+			return b
+		}
+	case "int", "long", "float", "double":
+		if _, err := strconv.ParseFloat(cell, 64); err == nil {
+			return json.Number(cell)
+		}
+	case "record", "array", "map":
+		if v, err := parseMessage(json.RawMessage(cell)); err == nil {
+			return v
+		}
+	}
+	return cell
+}
+
+// avroName turns a column name into a valid Avro name, replacing the
+// characters Avro doesn't allow with underscores.
+func avroName(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		switch {
+		case r == '_', r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case r >= '0' && r <= '9' && i > 0:
+		case r >= '0' && r <= '9':
+			b.WriteByte('_')
+		default:
+			r = '_'
+		}
+		b.WriteRune(r)
+	}
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"avroparser/pkg/avroconvert"
+)
+
+func TestInferCSVType(t *testing.T) {
+	converter, err := newNativeConverter(avroconvert.TimeFormatRFC3339, "UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		values []string
+		want   string
+	}{
+		{[]string{"true", "FALSE"}, `"boolean"`},
+		{[]string{"1", "-20"}, `"long"`},
+		{[]string{"1", "2.5"}, `"double"`},
+		{[]string{"2026-01-02T03:04:05Z"}, `{"type": "long", "logicalType": "timestamp-micros"}`},
+		{[]string{"2026-01-02", ""}, `["null", {"type": "int", "logicalType": "date"}]`},
+		{[]string{"1", "one"}, `"string"`},
+		{[]string{"", ""}, `["null", "string"]`},
+	} {
+		if got := string(inferCSVType(tt.values, converter)); got != tt.want {
+			t.Errorf("inferCSVType(%q) = %s, want %s", tt.values, got, tt.want)
+		}
+	}
+}
+
+func TestAvroName(t *testing.T) {
+	for in, want := range map[string]string{"user_id": "user_id", "2nd": "_2nd", "First Name": "First_Name", "": "_"} {
+		if got := avroName(in); got != want {
+			t.Errorf("avroName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestConvertCSVToAvro(t *testing.T) {
+	input := writeTestFile(t, "scores.csv", []byte("id,player name,score,day,tags\n"+
+		"1,ana,10,2026-01-02,\"[\"\"a\"\"]\"\n"+
+		"2,bo,,2026-01-03,[]\n"))
+	dir := t.TempDir()
+	output := filepath.Join(dir, "scores.avro")
+	schemaOutput := filepath.Join(dir, "scores.avsc")
+
+	converter, err := newNativeConverter(avroconvert.TimeFormatRFC3339, "UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := csvImport{
+		name:         "Score",
+		types:        map[string]json.RawMessage{"tags": json.RawMessage(`{"type": "array", "items": "string"}`)},
+		sample:       10,
+		codec:        ocfCodecDeflate,
+		schemaOutput: schemaOutput,
+		converter:    converter,
+	}
+	count, err := convertCSVToAvro(input, output, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatalf("wrote %d rows", count)
+	}
+	if _, err := os.Stat(schemaOutput); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if _, err := decodeMessages(bytes.NewReader(data), "scores.avro", testOptions(t, ""), avroconvert.NewNDJSONSink(&out)); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"day":"2026-01-02","id":1,"player_name":"ana","score":10,"tags":["a"]}` + "\n" +
+		`{"day":"2026-01-03","id":2,"player_name":"bo","score":null,"tags":[]}` + "\n"
+	if out.String() != want {
+		t.Fatalf("decoded\n%s\nwant\n%s", out.String(), want)
+	}
+
+	opts.types = map[string]json.RawMessage{"missing": json.RawMessage(`"string"`)}
+	if _, err := convertCSVToAvro(input, output, opts); err == nil {
+		t.Fatal("accepted -types for a column the input doesn't have")
+	}
+}
+
+func TestConvertCSVToAvroFails(t *testing.T) {
+	// The sampled rows type the column as long, which a later row isn't
+	input := writeTestFile(t, "scores.csv", []byte("id,score\n1,10\n2,ten\n"))
+	converter, err := newNativeConverter(avroconvert.TimeFormatRFC3339, "UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	opts := csvImport{name: "Score", sample: 1, codec: ocfCodecNull, converter: converter}
+	if _, err := convertCSVToAvro(input, filepath.Join(dir, "scores.avro"), opts); err == nil || !strings.HasPrefix(err.Error(), "row 3:") {
+		t.Fatalf("converted a row that doesn't match the schema: %v", err)
+	}
+	checkNoOutputs(t, dir)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"avroparser/pkg/avroconvert"
+)
+
+// appendCSV converts an input to CSV rows appended to the file output,
+// which is created with a header if it doesn't exist. Rows are written in
+// the columns of the existing header; columns it lacks are added by
+// rewriting the file with the wider header first.
+func appendCSV(in inputFile, output string, opts csvOptions) (avroconvert.Stats, error) {
+	path, cleanup, err := spoolInput(in.path)
+	if err != nil {
+		return avroconvert.Stats{}, err
+	}
+	defer cleanup()
+	opts.decode.blocks = in.blocks
+
+	columns := newColumnCollector(opts.flatten)
+	stats, err := decodeFile(path, in.path, opts.decode, columns)
+	if err != nil {
+		return stats, err
+	}
+
+	header, err := readCSVHeader(output, opts.dialect)
+	if err != nil {
+		return stats, err
+	}
+	names := header
+	var added []string
+	seen := make(map[string]bool, len(header))
+	for _, name := range header {
+		seen[name] = true
+	}
+	for _, name := range columns.names {
+		if !seen[name] {
+			names = append(names, name)
+			added = append(added, name)
+		}
+	}
+
+	var out io.WriteCloser
+	var size int64 // of the file appended to, which a failure truncates back to
+	switch {
+	case header == nil:
+		out, err = openOutput(output)
+	case len(added) > 0:
+		slog.Info("Adding columns to the header of the appended file", "output", output, "columns", added)
+		out, err = rewriteCSV(output, names, len(header), opts.dialect)
+	default:
+		var info os.FileInfo
+		if info, err = os.Stat(output); err == nil {
+			size = info.Size()
+			out, err = appendOutput(output)
+		}
+	}
+	if err != nil {
+		return stats, err
+	}
+	bw := bufio.NewWriter(out)
+	dialect := opts.dialect
+	if header != nil {
+		dialect = dialect.appending()
+	}
+	rows := newCSVRowWriter(bw, names, opts.flatten, dialect)
+	if header == nil {
+		err = rows.writeHeader()
+	}
+	if err == nil {
+		quiet := opts.decode
+		quiet.quiet = true
+		_, err = decodeFile(path, in.path, quiet, rows)
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		// Leave the file as it was, so the input can be appended again
+		if af, ok := out.(*atomicFile); ok {
+			af.abort()
+		} else {
+			out.Close()
+			if header != nil {
+				os.Truncate(output, size)
+			}
+		}
+		return stats, fmt.Errorf("cannot append to %s: %w", output, err)
+	}
+	if err := out.Close(); err != nil {
+		return stats, fmt.Errorf("cannot append to %s: %w", output, err)
+	}
+	slog.Info("Appended CSV rows", "input", in.path, "rows", stats.Messages, "columns", len(names), "output", output, filteredAttr(stats))
+	return stats, nil
+}
+
+// readCSVHeader returns the header of an existing CSV file, or nil if the
+// file doesn't exist or is empty.
+func readCSVHeader(path string, dialect csvDialect) ([]string, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot open %s: %w", path, err)
+	}
+	defer f.Close()
+	header, err := dialect.reader(f).Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the header of %s: %w", path, err)
+	}
+	seen := make(map[string]bool, len(header))
+	for _, name := range header {
+		if seen[name] {
+			return nil, fmt.Errorf("%s has the column %q twice", path, name)
+		}
+		seen[name] = true
+	}
+	return header, nil
+}
+
+// rewriteCSV copies a CSV file of width columns to a temporary file next to
+// it, with the wider header and every row padded to its columns with nulls,
+// and returns it for appending further rows; closing it replaces the file.
+// Quoting isn't kept, so cells that read as the null text are written as
+// nulls.
+func rewriteCSV(path string, header []string, width int, dialect csvDialect) (*atomicFile, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open %s: %w", path, err)
+	}
+	defer in.Close()
+	perm := os.FileMode(0o644)
+	if info, err := in.Stat(); err == nil {
+		perm = info.Mode().Perm()
+	}
+	rewrite, err := createAtomic(path, perm)
+	if err != nil {
+		return nil, fmt.Errorf("cannot rewrite %s: %w", path, err)
+	}
+
+	r := dialect.reader(in)
+	r.FieldsPerRecord = width
+	r.ReuseRecord = true
+	bw := bufio.NewWriter(rewrite)
+	w := newCSVWriter(bw, dialect)
+	row := make([]string, len(header))
+	nulls := make([]bool, len(header))
+	for first := true; ; first = false {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			rewrite.abort()
+			return nil, fmt.Errorf("cannot read %s: %w", path, err)
+		}
+		if first {
+			w.Write(header)
+			continue
+		}
+		for i := range row {
+			row[i] = ""
+			if i < width {
+				row[i] = record[i]
+			}
+			nulls[i] = row[i] == dialect.nullAs
+		}
+		w.WriteRow(row, nulls)
+	}
+	w.Flush()
+	err = w.Error()
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		rewrite.abort()
+		return nil, fmt.Errorf("cannot rewrite %s: %w", path, err)
+	}
+	return rewrite, nil
+}
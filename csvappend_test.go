@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAppendCSV(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "events.csv")
+	opts := csvOptions{decode: testOptions(t, "message"), flatten: flattener{separator: "."}}
+	appendInput := func(name string, msgs ...string) {
+		t.Helper()
+		in := inputFile{path: writeTestFile(t, name, writeMessageOCF(t, msgs...)), rel: name}
+		if _, err := appendCSV(in, output, opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	appendInput("day1.avro", `{"id":1,"level":3}`)
+	appendInput("day2.avro", `{"level":4,"id":2}`, `{"id":3}`)
+	appendInput("day3.avro", `{"id":4,"score":10}`)
+
+	got, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "id,level,score\n" +
+		"1,3,\n" +
+		"2,4,\n" +
+		"3,,\n" +
+		"4,,10\n"
+	if string(got) != want {
+		t.Fatalf("appended %q, want %q", got, want)
+	}
+	if tmps, _ := filepath.Glob(output + ".*.tmp"); len(tmps) > 0 {
+		t.Errorf("left temporary files %v", tmps)
+	}
+}
+
+func TestAppendCSVMismatchedRows(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "events.csv")
+	const existing = "id,level\n1,3,extra\n"
+	if err := os.WriteFile(output, []byte(existing), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	opts := csvOptions{decode: testOptions(t, "message"), flatten: flattener{separator: "."}}
+	in := inputFile{path: writeTestFile(t, "day.avro", writeMessageOCF(t, `{"id":2,"score":1}`)), rel: "day.avro"}
+
+	if _, err := appendCSV(in, output, opts); err == nil {
+		t.Fatal("expected an error for rows not matching the header")
+	}
+	got, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != existing {
+		t.Errorf("file changed to %q", got)
+	}
+	if tmps, _ := filepath.Glob(output + ".*.tmp"); len(tmps) > 0 {
+		t.Errorf("left temporary files %v", tmps)
+	}
+}
+
+func TestReadCSVHeader(t *testing.T) {
+	dir := t.TempDir()
+	if header, err := readCSVHeader(filepath.Join(dir, "missing.csv"), csvDialect{}); header != nil || err != nil {
+		t.Errorf("missing file: %v, %v", header, err)
+	}
+	empty := filepath.Join(dir, "empty.csv")
+	os.WriteFile(empty, nil, 0o644)
+	if header, err := readCSVHeader(empty, csvDialect{}); header != nil || err != nil {
+		t.Errorf("empty file: %v, %v", header, err)
+	}
+	twice := filepath.Join(dir, "twice.csv")
+	os.WriteFile(twice, []byte("id,id\n"), 0o644)
+	if _, err := readCSVHeader(twice, csvDialect{}); err == nil {
+		t.Error("expected an error for a repeated column")
+	}
+}
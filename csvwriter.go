@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Quoting modes of -quote.
+const (
+	quoteMinimal    = "minimal"    // fields that need it
+	quoteAll        = "all"        // every field but nulls
+	quoteNonNumeric = "nonnumeric" // fields that aren't numbers
+)
+
+// utf8BOM starts files written with -bom, which Excel needs to read them as
+// UTF-8 rather than the system code page.
+const utf8BOM = "\uFEFF"
+
+// csvFlags set how CSV files are written.
+type csvFlags struct {
+	delimiter *string
+	quote     *string
+	crlf      *bool
+	bom       *bool
+	nullAs    *string // nil where there are no nulls, as in reports
+}
+
+// addCSVFlags adds the flags of CSV output of records.
+func addCSVFlags(fs *flag.FlagSet) *csvFlags {
+	cf := addCSVReportFlags(fs)
+	cf.nullAs = fs.String("null-as", "", `Text of null and missing values in CSV files, e.g. \N or NULL; values equal to it, such as empty strings by default, are quoted`)
+	return cf
+}
+
+// addCSVReportFlags adds the flags of CSV reports, which have no nulls.
+func addCSVReportFlags(fs *flag.FlagSet) *csvFlags {
+	return &csvFlags{
+		delimiter: fs.String("delimiter", ",", "CSV field delimiter: a character, or comma, tab, semicolon or pipe"),
+		quote:     fs.String("quote", quoteMinimal, "Which CSV fields are quoted: minimal (those that need it), all or nonnumeric"),
+		crlf:      fs.Bool("crlf", false, "End CSV lines with CRLF, as Excel does, rather than LF"),
+		bom:       fs.Bool("bom", false, "Start CSV files with a UTF-8 byte order mark, so Excel reads them as UTF-8"),
+	}
+}
+
+// dialect validates the flags.
+func (cf *csvFlags) dialect() (csvDialect, error) {
+	d := csvDialect{delimiter: ',', quote: *cf.quote, crlf: *cf.crlf, bom: *cf.bom}
+	if cf.nullAs != nil {
+		d.nullAs = *cf.nullAs
+	}
+	switch *cf.delimiter {
+	case "comma":
+	case "tab", `\t`:
+		d.delimiter = '\t'
+	case "semicolon":
+		d.delimiter = ';'
+	case "pipe":
+		d.delimiter = '|'
+	default:
+		r, size := utf8.DecodeRuneInString(*cf.delimiter)
+		if size == 0 || size != len(*cf.delimiter) || r == '"' || r == '\r' || r == '\n' || r == utf8.RuneError {
+			return d, fmt.Errorf("invalid -delimiter %q (expected a single character other than a quote or line break, or comma, tab, semicolon or pipe)", *cf.delimiter)
+		}
+		d.delimiter = r
+	}
+	switch d.quote {
+	case quoteMinimal, quoteAll, quoteNonNumeric:
+	default:
+		return d, fmt.Errorf("unknown -quote mode %q (expected minimal, all or nonnumeric)", d.quote)
+	}
+	if strings.ContainsRune(d.nullAs, d.delimiter) || strings.ContainsAny(d.nullAs, "\"\r\n") {
+		return d, fmt.Errorf("-null-as %q cannot hold the delimiter, a quote or a line break", d.nullAs)
+	}
+	return d, nil
+}
+
+// csvDialect is how CSV files are written.
+type csvDialect struct {
+	delimiter rune
+	quote     string
+	crlf      bool
+	bom       bool
+	nullAs    string // text of null and missing values
+}
+
+// appending returns the dialect for adding rows to an existing file, which
+// already starts with any byte order mark.
+func (d csvDialect) appending() csvDialect {
+	d.bom = false
+	return d
+}
+
+// reader returns a reader of CSV in the dialect, skipping a byte order
+// mark.
+func (d csvDialect) reader(r io.Reader) *csv.Reader {
+	br := bufio.NewReader(r)
+	if head, err := br.Peek(len(utf8BOM)); err == nil && string(head) == utf8BOM {
+		br.Discard(len(utf8BOM))
+	}
+	cr := csv.NewReader(br)
+	if d.delimiter != 0 {
+		cr.Comma = d.delimiter
+	}
+	return cr
+}
+
+// csvWriter writes CSV records as encoding/csv does, in a dialect. Rows
+// written with WriteRow tell null cells apart from empty strings: nulls are
+// written as the dialect's null text, unquoted, and values equal to it are
+// quoted. Database loaders such as PostgreSQL's COPY read a quoted value as
+// a value, never as null.
+type csvWriter struct {
+	w       *bufio.Writer
+	dialect csvDialect
+	started bool
+	err     error
+}
+
+func newCSVWriter(w io.Writer, dialect csvDialect) *csvWriter {
+	if dialect.delimiter == 0 {
+		dialect.delimiter = ','
+	}
+	return &csvWriter{w: bufio.NewWriter(w), dialect: dialect}
+}
+
+// Write writes a record without nulls, such as a header.
+func (cw *csvWriter) Write(record []string) error {
+	return cw.WriteRow(record, nil)
+}
+
+// WriteRow writes a record whose cells are null where nulls is set. Without
+// nulls, fields equal to the null text are not quoted for it.
+func (cw *csvWriter) WriteRow(record []string, nulls []bool) error {
+	if cw.err != nil {
+		return cw.err
+	}
+	if !cw.started && cw.dialect.bom {
+		cw.w.WriteString(utf8BOM)
+	}
+	cw.started = true
+	for i, field := range record {
+		if i > 0 {
+			cw.w.WriteRune(cw.dialect.delimiter)
+		}
+		if nulls != nil && nulls[i] {
+			cw.w.WriteString(cw.dialect.nullAs)
+			continue
+		}
+		if !cw.needsQuotes(field) && (nulls == nil || field != cw.dialect.nullAs) {
+			cw.w.WriteString(field)
+			continue
+		}
+		cw.w.WriteByte('"')
+		cw.w.WriteString(strings.ReplaceAll(field, `"`, `""`))
+		cw.w.WriteByte('"')
+	}
+	if cw.dialect.crlf {
+		_, cw.err = cw.w.WriteString("\r\n")
+	} else {
+		_, cw.err = cw.w.WriteString("\n")
+	}
+	return cw.err
+}
+
+// WriteAll writes records without nulls and flushes them.
+func (cw *csvWriter) WriteAll(records [][]string) error {
+	for _, record := range records {
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.err
+}
+
+// Flush writes buffered records through to the underlying writer.
+func (cw *csvWriter) Flush() {
+	if err := cw.w.Flush(); cw.err == nil {
+		cw.err = err
+	}
+}
+
+// Error returns the first error writing or flushing.
+func (cw *csvWriter) Error() error {
+	return cw.err
+}
+
+// needsQuotes reports whether a field is quoted. Fields must be when they
+// hold the delimiter, a quote or a line break, start with a space, or are
+// \. which PostgreSQL reads as the end of the data, as in encoding/csv.
+func (cw *csvWriter) needsQuotes(field string) bool {
+	switch cw.dialect.quote {
+	case quoteAll:
+		return true
+	case quoteNonNumeric:
+		if _, err := strconv.ParseFloat(field, 64); err != nil {
+			return true
+		}
+	}
+	if field == "" {
+		return false
+	}
+	if field == `\.` || strings.ContainsRune(field, cw.dialect.delimiter) || strings.ContainsAny(field, "\"\r\n") {
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(field)
+	return unicode.IsSpace(r)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCSVWriter(t *testing.T) {
+	tests := []struct {
+		nullAs string
+		want   string
+	}{
+		{"", "a,,\"\",\"x,y\",\" lead\",\"\\.\",NULL\n"},
+		{`\N`, "a,\\N,,\"x,y\",\" lead\",\"\\.\",NULL\n"},
+		{"NULL", "a,NULL,,\"x,y\",\" lead\",\"\\.\",\"NULL\"\n"},
+	}
+	record := []string{"a", "", "", "x,y", " lead", `\.`, "NULL"}
+	nulls := []bool{false, true, false, false, false, false, false}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		w := newCSVWriter(&out, csvDialect{nullAs: tt.nullAs})
+		w.WriteRow(record, nulls)
+		w.Flush()
+		if err := w.Error(); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != tt.want {
+			t.Errorf("-null-as %q wrote %q, want %q", tt.nullAs, out.String(), tt.want)
+		}
+	}
+}
+
+func TestConvertCSVNullAs(t *testing.T) {
+	data := writeMessageOCF(t,
+		`{"id":1,"campaign":null,"name":""}`,
+		`{"id":2,"name":"\\N"}`,
+	)
+	in := inputFile{path: writeTestFile(t, "events.avro", data), rel: "events.avro"}
+	output := filepath.Join(t.TempDir(), "events.csv")
+	opts := csvOptions{decode: testOptions(t, "message"), flatten: flattener{separator: "."}, dialect: csvDialect{nullAs: `\N`}}
+
+	if _, err := convertCSV(in, output, opts); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "campaign,id,name\n" +
+		"\\N,1,\n" +
+		"\\N,2,\"\\N\"\n"
+	if string(got) != want {
+		t.Fatalf("wrote %q, want %q", got, want)
+	}
+}
+
+func TestCSVFlagsDialect(t *testing.T) {
+	tests := []struct {
+		args []string
+		want csvDialect
+		ok   bool
+	}{
+		{nil, csvDialect{delimiter: ',', quote: quoteMinimal}, true},
+		{[]string{"-delimiter", "tab", "-crlf", "-bom"}, csvDialect{delimiter: '\t', quote: quoteMinimal, crlf: true, bom: true}, true},
+		{[]string{"-delimiter", ";", "-quote", "all"}, csvDialect{delimiter: ';', quote: quoteAll}, true},
+		{[]string{"-delimiter", "pipe", "-null-as", "NULL"}, csvDialect{delimiter: '|', quote: quoteMinimal, nullAs: "NULL"}, true},
+		{[]string{"-delimiter", "ab"}, csvDialect{}, false},
+		{[]string{"-delimiter", `"`}, csvDialect{}, false},
+		{[]string{"-quote", "some"}, csvDialect{}, false},
+		{[]string{"-delimiter", "|", "-null-as", "a|b"}, csvDialect{}, false},
+	}
+	for _, tt := range tests {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		cf := addCSVFlags(fs)
+		if err := fs.Parse(tt.args); err != nil {
+			t.Fatal(err)
+		}
+		got, err := cf.dialect()
+		if (err == nil) != tt.ok {
+			t.Errorf("%v: error %v", tt.args, err)
+			continue
+		}
+		if tt.ok && got != tt.want {
+			t.Errorf("%v: dialect %+v, want %+v", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestCSVWriterDialect(t *testing.T) {
+	tests := []struct {
+		dialect csvDialect
+		want    string
+	}{
+		{csvDialect{delimiter: '\t', quote: quoteMinimal}, "id\tnote\n1\ta,b\n2\t\"x\ty\"\n"},
+		{csvDialect{delimiter: ',', quote: quoteAll, crlf: true}, "\"id\",\"note\"\r\n\"1\",\"a,b\"\r\n\"2\",\"x\ty\"\r\n"},
+		{csvDialect{delimiter: ';', quote: quoteNonNumeric, bom: true}, utf8BOM + "\"id\";\"note\"\n1;\"a,b\"\n2;\"x\ty\"\n"},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		w := newCSVWriter(&out, tt.dialect)
+		if err := w.WriteAll([][]string{{"id", "note"}, {"1", "a,b"}, {"2", "x\ty"}}); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != tt.want {
+			t.Errorf("%+v wrote %q, want %q", tt.dialect, out.String(), tt.want)
+		}
+	}
+}
+
+func TestCSVDialectReader(t *testing.T) {
+	dialect := csvDialect{delimiter: ';', bom: true}
+	var out bytes.Buffer
+	w := newCSVWriter(&out, dialect)
+	if err := w.WriteAll([][]string{{"id", "note"}, {"1", "a;b"}}); err != nil {
+		t.Fatal(err)
+	}
+	records, err := dialect.reader(strings.NewReader(out.String())).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]string{{"id", "note"}, {"1", "a;b"}}; !reflect.DeepEqual(records, want) {
+		t.Errorf("read %q, want %q", records, want)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"avroparser/pkg/avroconvert"
+)
+
+// Policies accepted by -on-error for records that cannot be decoded.
+const (
+	onErrorSkip    = "skip"    // report the record and go on
+	onErrorFail    = "fail"    // stop converting the input
+	onErrorCollect = "collect" // write the record to the input's dead-letter file
+)
+
+// deadLetterExt is the extension of the files failed records are collected
+// in.
+const deadLetterExt = "errors.ndjson"
+
+// errorPolicy is what happens to records that cannot be decoded, and to
+// messages that aren't valid JSON.
+type errorPolicy struct {
+	mode string // one of the onError constants
+	dir  string // directory of the dead-letter files, with onErrorCollect
+}
+
+// strict reports whether messages that aren't valid JSON fail like
+// malformed records rather than being written as strings.
+func (p errorPolicy) strict() bool {
+	return p.mode == onErrorFail || p.mode == onErrorCollect
+}
+
+// isFileOutput reports whether -output is a directory (local or gs://) that
+// output files are written to, rather than stdout or a database or URL
+// records are loaded into.
+func isFileOutput(outputDir string) bool {
+	switch {
+	case outputDir == stdioPath, isPostgresURL(outputDir), isBigQueryPath(outputDir), isClickHouseURL(outputDir),
+		isDuckDBPath(outputDir), isElasticsearchURL(outputDir), isHTTPPath(outputDir):
+		return false
+	}
+	return true
+}
+
+// deadLetterFile collects the failed records of one input as NDJSON. The
+// file is only created once the first record fails.
+type deadLetterFile struct {
+	path  string
+	input string
+	open  func(path string) (io.WriteCloser, error)
+	out   io.WriteCloser
+	w     *bufio.Writer
+	count int
+}
+
+func newDeadLetterFile(path, input string, open func(path string) (io.WriteCloser, error)) *deadLetterFile {
+	return &deadLetterFile{path: path, input: input, open: open}
+}
+
+// deadLetter is a line of a dead-letter file.
+type deadLetter struct {
+	Input  string `json:"input"`
+	Record int    `json:"record"`
+	Reason string `json:"reason"`
+	Field  string `json:"field,omitempty"`
+	Error  string `json:"error,omitempty"`
+	Raw    []byte `json:"raw,omitempty"` // base64 encoded by encoding/json
+}
+
+// collect writes a failed record. It is an avroconvert.DecoderOptions
+// OnError hook, stopping decoding only when the file cannot be written.
+func (df *deadLetterFile) collect(e *avroconvert.RecordError) error {
+	if df.out == nil {
+		out, err := df.open(df.path)
+		if err != nil {
+			return fmt.Errorf("cannot create dead-letter file: %w", err)
+		}
+		df.out, df.w = out, bufio.NewWriter(out)
+	}
+
+	letter := deadLetter{Input: df.input, Record: e.Record, Reason: e.Reason, Field: e.Field, Raw: e.Raw}
+	if e.Err != nil {
+		letter.Error = e.Err.Error()
+	}
+	line, err := json.Marshal(letter)
+	if err != nil {
+		return err
+	}
+	if _, err := df.w.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("cannot write dead-letter file: %w", err)
+	}
+	df.count++
+	return nil
+}
+
+// Flush writes the collected records through to the file.
+func (df *deadLetterFile) Flush() error {
+	if df.w == nil {
+		return nil
+	}
+	if err := df.w.Flush(); err != nil {
+		return fmt.Errorf("cannot write dead-letter file: %w", err)
+	}
+	return nil
+}
+
+// Close finishes the file, if any record failed.
+func (df *deadLetterFile) Close() error {
+	if df.out == nil {
+		return nil
+	}
+	if err := df.Flush(); err != nil {
+		df.out.Close()
+		return err
+	}
+	if err := df.out.Close(); err != nil {
+		return fmt.Errorf("cannot write dead-letter file: %w", err)
+	}
+	slog.Warn("Collected failed records", "input", df.input, "records", df.count, "output", df.path)
+	return nil
+}
+
+// withDeadLetters points opts at the dead-letter file of an input when
+// -on-error collect is given. The returned function closes the file,
+// failing result if it cannot be written.
+func withDeadLetters(in inputFile, opts *decodeOptions) func(result *fileResult) {
+	if opts.onError.mode != onErrorCollect {
+		return func(*fileResult) {}
+	}
+	df := newDeadLetterFile(outputPath(in, opts.onError.dir, deadLetterExt), in.path, openOutput)
+	opts.deadLetters = df
+	return func(result *fileResult) {
+		if err := df.Close(); err != nil && result.err == nil {
+			result.err = err
+		}
+	}
+}
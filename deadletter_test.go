@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestErrorPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		onError, deadLetter, output string
+		want                        errorPolicy
+		ok                          bool
+	}{
+		{onErrorSkip, "", "out", errorPolicy{mode: onErrorSkip}, true},
+		{onErrorFail, "", "out", errorPolicy{mode: onErrorFail}, true},
+		{onErrorCollect, "", "out", errorPolicy{mode: onErrorCollect, dir: "out"}, true},
+		{onErrorCollect, "errors", stdioPath, errorPolicy{mode: onErrorCollect, dir: "errors"}, true},
+		{onErrorCollect, "", stdioPath, errorPolicy{}, false},
+		{onErrorCollect, "", "postgres://db/events", errorPolicy{}, false},
+		{onErrorSkip, "errors", "out", errorPolicy{}, false},
+		{"ignore", "", "out", errorPolicy{}, false},
+	} {
+		rf := &recordFlags{onError: &tc.onError, deadLetter: &tc.deadLetter}
+		got, err := rf.errorPolicy(tc.output)
+		if (err == nil) != tc.ok || tc.ok && got != tc.want {
+			t.Errorf("-on-error %s -dead-letter %q -output %s: %+v, %v", tc.onError, tc.deadLetter, tc.output, got, err)
+		}
+	}
+}
+
+func TestConvertFileOnError(t *testing.T) {
+	rel := "events.avro"
+	in := inputFile{path: writeTestFile(t, rel, writeMessageOCF(t, `{"id":1}`, `not json`, `{"id":3}`)), rel: rel}
+
+	// collect writes the records that fail next to the output
+	dir := t.TempDir()
+	opts := testOptions(t, "message")
+	opts.outputDir, opts.format = dir, "ndjson"
+	opts.onError = errorPolicy{mode: onErrorCollect, dir: dir}
+	result := convertFile(in, opts)
+	if result.err != nil {
+		t.Fatal(result.err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "events.ndjson"))
+	if err != nil || string(data) != "{\"id\":1}\n{\"id\":3}\n" {
+		t.Fatalf("wrote %q: %v", data, err)
+	}
+	data, err = os.ReadFile(filepath.Join(dir, "events."+deadLetterExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var letter deadLetter
+	if err := json.Unmarshal(data, &letter); err != nil {
+		t.Fatal(err)
+	}
+	if letter.Input != in.path || letter.Record != 2 || letter.Field != "message" || string(letter.Raw) != "not json" || !strings.Contains(letter.Reason, "not valid JSON") {
+		t.Fatalf("collected %+v", letter)
+	}
+
+	// Without failures no dead-letter file is created
+	clean := inputFile{path: writeTestFile(t, "clean.avro", writeMessageOCF(t, `{"id":1}`)), rel: "clean.avro"}
+	if result := convertFile(clean, opts); result.err != nil {
+		t.Fatal(result.err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "clean."+deadLetterExt)); !os.IsNotExist(err) {
+		t.Fatalf("created a dead-letter file for a clean input: %v", err)
+	}
+
+	// fail stops at the first bad record
+	opts.outputDir = t.TempDir()
+	opts.onError = errorPolicy{mode: onErrorFail}
+	if result := convertFile(in, opts); result.err == nil || !strings.Contains(result.err.Error(), "record 2") {
+		t.Fatalf("converted with -on-error fail: %v", result.err)
+	}
+}
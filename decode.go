@@ -0,0 +1,737 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"avroparser/pkg/avroconvert"
+)
+
+// stdioPath selects stdin for -input and stdout for -output.
+const stdioPath = "-"
+
+// pulsarMessageField is the bytes field of Pulsar sink records holding the
+// JSON message.
+const pulsarMessageField = "message"
+
+// legacyMessageWarning warns once per run that the message field is
+// extracted without -field.
+var legacyMessageWarning sync.Once
+
+// decodeOptions holds the settings shared by every converted file.
+type decodeOptions struct {
+	outputDir      string
+	format         string
+	compress       string // output compression, one of the compress constants
+	pretty         bool
+	field          string
+	converter      *avroconvert.JSONConverter
+	readerSchema   *avroconvert.Schema // schema records are resolved to, if set
+	split          splitLimits         // limits after which output moves on to a new part
+	partitionBy    []string            // field paths output is partitioned by
+	outputTemplate *outputTemplate     // names the output files, when -output is a template
+	filter         *recordFilter
+	sampling       sampling
+	transform      *recordTransform
+	extendedJSON   *extendedJSON      // converts MongoDB Extended JSON values, before -redact
+	epochFields    *epochFields       // renders epoch number fields as timestamps, before -redact
+	coerce         *coercion          // converts field values to the types of a -coerce file, before -redact
+	redact         *redactor          // removes or masks personal data before -transform
+	join           *lookupJoin        // adds the fields of a lookup table, after -redact
+	plugin         *recordPlugin      // runs a Go plugin's Transform, after -join
+	script         *recordScript      // runs a Starlark transform function, after -plugin
+	proto          *protoMessage      // the message records are written as, with -format protobuf
+	explode        *arrayExploder     // turns each element of an array into a message of its own, after -transform
+	raw            *rawInput          // set when the input is bare datums rather than a container file
+	blocks         *blockRange        // blocks of the current input to convert, with -state
+	blockWorkers   int                // blocks of a container file decoded at once, 0 for one per CPU
+	quiet          bool               // suppress per-record warnings, e.g. on a second pass
+	onError        errorPolicy        // what happens to records that cannot be decoded
+	deadLetters    *deadLetterFile    // collects the failed records of the current input, with -on-error collect
+	postgres       *postgresOptions   // set when records are loaded into Postgres instead of files
+	bigquery       *bqTable           // set when records are loaded into BigQuery instead of files
+	clickhouse     *clickHouseOptions // set when records are loaded into ClickHouse instead of files
+	duckdb         *duckDBOptions     // set when records are loaded into a DuckDB database instead of files
+	es             *esOptions         // set when records are indexed into Elasticsearch instead of files
+	webhook        *webhookOptions    // set when records are posted to an HTTP endpoint instead of files
+	progress       *progressMeter     // counts bytes and records read, with -progress
+	manifest       *outputManifest    // lists the output files written, with -manifest
+	memory         *memoryBudget      // bounds the records columnar outputs hold, with -max-memory
+	keepExisting   bool               // fail inputs whose output file exists, with -overwrite=false
+	skipExisting   bool               // skip inputs whose output file exists
+	legacyMessage  bool               // extract the message field of Pulsar sink records when -field isn't given
+}
+
+func runDecode(args []string) {
+	decodeCommand("decode", args)
+}
+
+// runBareDecode is decode run without a command name, as scripts written
+// when the tool only read Pulsar sink files call it. Without -field, the
+// message field of Pulsar sink records is still extracted for them, with a
+// warning that this is deprecated, and other records are converted whole.
+func runBareDecode(args []string) {
+	decodeCommand("", args)
+}
+
+// runJoin is decode with a -join file required, for the records enriched
+// from a lookup table.
+func runJoin(args []string) {
+	decodeCommand("join", args)
+}
+
+func decodeCommand(name string, args []string) {
+	bare := name == ""
+	if bare {
+		name = "decode"
+	}
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	inputPath := fs.String("input", "", "Input Avro file, directory or glob pattern, or - for stdin")
+	outputDir := fs.String("output", "output", "Output directory for JSON files, a path template such as out/{date}/{event_name}/{basename}.ndjson, - for stdout, a .duckdb database, a postgres://, clickhouse:// or elasticsearch+https:// URL or bq://project.dataset.table to load into, or an http(s):// URL to post records to")
+	prettyPrint := fs.Bool("pretty", true, "Pretty print JSON output")
+	format := fs.String("format", "json", "Output format: json (single array), ndjson (one message per line), parquet, arrow (IPC file), protobuf (length-delimited messages, see -proto-message), msgpack or cbor")
+	compress := fs.String("compress", compressNone, "Compress output files: gzip, zstd or none")
+	workers := fs.Int("workers", 1, "Number of files to convert concurrently")
+	blockWorkers := fs.Int("block-workers", 0, "Number of blocks of each container file to decompress and decode concurrently (default: one per CPU)")
+	statePath := fs.String("state", "", "State file recording what has been converted, so later runs only convert new data")
+	watch := fs.Bool("watch", false, "Keep watching the input directory and convert new Avro files as they appear")
+	settle := fs.Duration("settle", 10*time.Second, "With -watch, how long a file must go unchanged before it is converted")
+	progress := fs.Bool("progress", false, "Report bytes read, records per second and the time left on stderr while converting")
+	summaryPath := fs.String("summary-json", "", "Write a JSON summary of the run, with record counts and failures per input, to this file")
+	unify := fs.Bool("unify-schemas", false, "Read every input as a superset of all their writer schemas, so records, and Parquet and Arrow files, have the same fields whichever schema version wrote them")
+	manifestPath := fs.String("manifest", "", "Write a JSON manifest of the output files, with their records, sizes and SHA-256 checksums, to this file once every input is converted")
+	overwrite := fs.Bool("overwrite", true, "Replace output files that exist; with -overwrite=false, an input whose output file exists fails instead")
+	skipWritten := fs.Bool("skip-existing", false, "Skip inputs whose output file exists, e.g. to finish a run that stopped part way")
+	maxMemory := fs.String("max-memory", "", "Memory budget of the records Parquet and Arrow outputs hold before writing them, e.g. 4GB, shared by -workers; a row group or record batch is written early rather than outgrow it")
+	table := fs.String("table", "", "With a postgres:// or clickhouse:// -output, the table to load records into, e.g. analytics.events; with a .duckdb -output, the table instead of one per input")
+	tableBy := fs.String("table-by", "", "With a .duckdb -output, load records into a table per value of this field, e.g. event_name")
+	createTable := fs.Bool("create-table", false, "With a postgres:// -output, create the table from the record columns if it doesn't exist")
+	batchSize := fs.Int("batch-size", 0, "Records per INSERT, bulk or POST request when loading into ClickHouse, Elasticsearch or a webhook (default 10000, or 100 for webhooks)")
+	asyncInsert := fs.Bool("async-insert", false, "With a clickhouse:// -output, use asynchronous inserts, letting the server merge small batches")
+	index := fs.String("index", "", "With an elasticsearch+https:// -output, the index to write to; {field} and {field|2006.01.02} are replaced with record values, e.g. events-{event_date}")
+	var headers headerFlags
+	fs.Var(&headers, "header", "With an http(s):// -output, a \"Name: value\" header to send; may be repeated")
+	concurrency := fs.Int("concurrency", 4, "With an http(s):// -output, requests in flight per input")
+	retries := fs.Int("retries", 5, "With an http(s):// -output, how often a failed request is sent again")
+	retryBackoff := fs.Duration("retry-backoff", time.Second, "With an http(s):// -output, the wait before the first retry, doubled after each")
+	idField := fs.String("id-field", "", "With an elasticsearch+https:// -output, the field giving document IDs, so indexing again replaces documents")
+	records := addRecordFlags(fs)
+	redaction := addRedactFlags(fs)
+	joining := addJoinFlags(fs)
+	protoOutput := addProtoFlags(fs)
+	rawInput := addRawFlags(fs)
+	splitOutput := addSplitFlags(fs)
+	logging := addLogFlags(fs)
+	parseFlags(fs, args)
+	logging.setup()
+	fieldGiven := false
+	fs.Visit(func(f *flag.Flag) { fieldGiven = fieldGiven || f.Name == "field" })
+
+	// Allow the input to be given positionally, e.g. "avroparser decode -"
+	if *inputPath == "" && fs.NArg() > 0 {
+		*inputPath = fs.Arg(0)
+	}
+
+	if *inputPath == "" {
+		fmt.Fprintln(os.Stderr, "Usage: avroparser [decode] -input <avro_file|dir|glob|-> [-output <output_dir>|-] [-format json|ndjson] [-pretty=true|false]")
+		os.Exit(exitFatal)
+	}
+
+	switch *format {
+	case "json", "ndjson", "parquet", "arrow", "protobuf", "msgpack", "cbor":
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown output format %q (expected json, ndjson, parquet, arrow, protobuf, msgpack or cbor)\n", *format)
+		os.Exit(exitFatal)
+	}
+	protoMessage, err := protoOutput.protoMessage(*format)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	if err := checkCompression(*compress); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+
+	pathTemplate, err := parseOutputTemplate(*outputDir, *records.timeField, *records.timeFormat)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	if pathTemplate != nil {
+		*outputDir = pathTemplate.dir
+	}
+	split, err := splitOutput.limits(*outputDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	partitionBy, err := splitOutput.partitions(*outputDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	// Parquet and Arrow buffer rows in memory, so the file size isn't known
+	// while writing
+	if split.bytes > 0 && columnarFormat(*format) {
+		fmt.Fprintf(os.Stderr, "-max-file-size is not supported for %s output; use -max-records-per-file\n", *format)
+		os.Exit(exitFatal)
+	}
+
+	converter, err := records.converter()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	readerSchema, err := records.schema()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	filter, err := records.recordFilter()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	transform, err := records.recordTransform()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	plugin, err := records.recordPlugin()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	script, err := records.recordScript()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	redact, err := redaction.redactor()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	epoch, err := records.epochFields(converter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	coerce, err := records.coercion()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	join, err := joining.lookupJoin()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	if name == "join" && join == nil {
+		fmt.Fprintln(os.Stderr, "Usage: avroparser join -join <lookup.csv|.ndjson|.json> -join-on <field> [-join-key <column>] [-join-prefix <prefix>] -input <avro_file|dir|glob|-> [-output <output_dir>|-]")
+		os.Exit(exitFatal)
+	}
+	sample, err := records.sampling()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	raw, err := rawInput.input()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	onError, err := records.errorPolicy(*outputDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	if *blockWorkers < 0 {
+		fmt.Fprintf(os.Stderr, "-block-workers must not be negative, got %d\n", *blockWorkers)
+		os.Exit(exitFatal)
+	}
+	var memory *memoryBudget
+	if *maxMemory != "" {
+		limit, err := parseSize(*maxMemory)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -max-memory: %v\n", err)
+			os.Exit(exitFatal)
+		}
+		memory = newMemoryBudget(limit)
+	}
+
+	// Only NDJSON can be appended to when a grown input is resumed
+	canAppend := *format == "ndjson" && !split.enabled() && partitionBy == nil && !pathTemplate.routes() && *outputDir != stdioPath && !isGCSPath(*outputDir)
+	opts := decodeOptions{outputDir: *outputDir, format: *format, compress: *compress, split: split, partitionBy: partitionBy, outputTemplate: pathTemplate, pretty: *prettyPrint, field: *records.field, converter: converter, readerSchema: readerSchema, filter: filter, transform: transform, extendedJSON: records.extendedJSON(converter), epochFields: epoch, coerce: coerce, redact: redact, join: join, plugin: plugin, script: script, proto: protoMessage, sampling: sample, raw: raw, blockWorkers: *blockWorkers, onError: onError, memory: memory}
+	// Only JSON-like file output existed when the message field was always
+	// extracted
+	opts.legacyMessage = bare && !fieldGiven && !columnarFormat(*format) && (isFileOutput(*outputDir) || *outputDir == stdioPath)
+	if *batchSize < 0 {
+		fmt.Fprintln(os.Stderr, "-batch-size must be at least 1")
+		os.Exit(exitFatal)
+	}
+	if isPostgresURL(*outputDir) {
+		if *table == "" {
+			fmt.Fprintln(os.Stderr, "-table is required with a postgres:// -output")
+			os.Exit(exitFatal)
+		}
+		if split.enabled() || partitionBy != nil {
+			fmt.Fprintln(os.Stderr, "Postgres output cannot be split or partitioned")
+			os.Exit(exitFatal)
+		}
+		// Rows are appended to the table, so grown inputs only need their
+		// new blocks
+		opts.postgres = &postgresOptions{url: *outputDir, table: *table, createTable: *createTable}
+		canAppend = true
+	}
+	if isBigQueryPath(*outputDir) {
+		table, err := parseBigQueryPath(*outputDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(exitFatal)
+		}
+		if split.enabled() || partitionBy != nil {
+			fmt.Fprintln(os.Stderr, "BigQuery output cannot be split or partitioned")
+			os.Exit(exitFatal)
+		}
+		// BigQuery parses timestamps as RFC 3339 text
+		if *records.timeFormat != avroconvert.TimeFormatRFC3339 {
+			fmt.Fprintln(os.Stderr, "BigQuery output needs the default -time-format rfc3339")
+			os.Exit(exitFatal)
+		}
+		opts.bigquery = &table
+		canAppend = true
+	}
+	if isClickHouseURL(*outputDir) {
+		if *table == "" {
+			fmt.Fprintln(os.Stderr, "-table is required with a clickhouse:// -output")
+			os.Exit(exitFatal)
+		}
+		if split.enabled() || partitionBy != nil {
+			fmt.Fprintln(os.Stderr, "ClickHouse output cannot be split or partitioned")
+			os.Exit(exitFatal)
+		}
+		opts.clickhouse = &clickHouseOptions{url: *outputDir, table: *table, batchSize: batchSizeOr(*batchSize, 10000), asyncInsert: *asyncInsert}
+		canAppend = true
+	}
+	if isDuckDBPath(*outputDir) {
+		if *table != "" && *tableBy != "" {
+			fmt.Fprintln(os.Stderr, "-table and -table-by cannot be combined")
+			os.Exit(exitFatal)
+		}
+		if split.enabled() || partitionBy != nil {
+			fmt.Fprintln(os.Stderr, "DuckDB output cannot be split or partitioned")
+			os.Exit(exitFatal)
+		}
+		opts.duckdb = &duckDBOptions{path: *outputDir, table: *table, tableBy: *tableBy}
+		canAppend = true
+	} else if *tableBy != "" {
+		fmt.Fprintln(os.Stderr, "-table-by needs a .duckdb -output")
+		os.Exit(exitFatal)
+	}
+	if isElasticsearchURL(*outputDir) {
+		if *index == "" {
+			fmt.Fprintln(os.Stderr, "-index is required with an elasticsearch+https:// -output")
+			os.Exit(exitFatal)
+		}
+		template, err := parseIndexTemplate(*index)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(exitFatal)
+		}
+		if split.enabled() || partitionBy != nil {
+			fmt.Fprintln(os.Stderr, "Elasticsearch output cannot be split or partitioned")
+			os.Exit(exitFatal)
+		}
+		opts.es = &esOptions{url: *outputDir, indexName: *index, index: template, batchSize: batchSizeOr(*batchSize, 10000)}
+		if *idField != "" {
+			opts.es.idField = strings.Split(*idField, ".")
+		}
+		canAppend = true
+	}
+	if isHTTPPath(*outputDir) {
+		if *concurrency < 1 {
+			fmt.Fprintln(os.Stderr, "-concurrency must be at least 1")
+			os.Exit(exitFatal)
+		}
+		if split.enabled() || partitionBy != nil {
+			fmt.Fprintln(os.Stderr, "Webhook output cannot be split or partitioned")
+			os.Exit(exitFatal)
+		}
+		opts.webhook = &webhookOptions{url: *outputDir, header: headers.header(), batchSize: batchSizeOr(*batchSize, 100), concurrency: *concurrency, retries: *retries, backoff: *retryBackoff}
+		canAppend = true
+	}
+	if *unify && (*inputPath == stdioPath || readerSchema != nil || raw != nil || *watch) {
+		fmt.Fprintln(os.Stderr, "-unify-schemas reads the writer schemas of all inputs first, so it cannot be combined with stdin input, -reader-schema, -raw or -watch")
+		os.Exit(exitFatal)
+	}
+	if *skipWritten && (!isFileOutput(*outputDir) || partitionBy != nil || pathTemplate.routes()) {
+		fmt.Fprintln(os.Stderr, "-skip-existing needs an output directory, and cannot be combined with -partition-by or record fields in an -output template, whose files depend on the records")
+		os.Exit(exitFatal)
+	}
+	opts.keepExisting, opts.skipExisting = !*overwrite, *skipWritten
+	if *manifestPath != "" && (!isFileOutput(*outputDir) || *statePath != "" || *watch || *skipWritten) {
+		fmt.Fprintln(os.Stderr, "-manifest lists the files written under -output, so it needs an output directory, and cannot be combined with -state, -watch or -skip-existing")
+		os.Exit(exitFatal)
+	}
+	if (*records.offset != 0 || *records.count != 0) && *statePath != "" {
+		fmt.Fprintln(os.Stderr, "-offset and -count select records by their position in each input, so they cannot be combined with -state, which converts the blocks added since the last run")
+		os.Exit(exitFatal)
+	}
+	convert := func(in inputFile) fileResult {
+		return convertFile(in, opts)
+	}
+
+	if *watch {
+		if *summaryPath != "" {
+			fmt.Fprintln(os.Stderr, "-summary-json cannot be combined with -watch, which runs until interrupted")
+			os.Exit(exitFatal)
+		}
+		if *progress {
+			opts.progress = newProgressMeter(nil)
+			opts.progress.start()
+		}
+		runWatch(*inputPath, *statePath, canAppend, *workers, *settle, convert)
+		return
+	}
+	state, inputs := mustPlanState(*statePath, mustExpandInputs(*inputPath), canAppend)
+	if *unify && len(inputs) > 0 {
+		if opts.readerSchema, err = unifiedSchema(inputs); err != nil {
+			slog.Error("Cannot unify schemas", "error", err)
+			os.Exit(exitFatal)
+		}
+	}
+	if opts.manifest, err = newOutputManifest(*manifestPath, *outputDir); err != nil {
+		slog.Error("Cannot start manifest", "error", err)
+		os.Exit(exitFatal)
+	}
+	if *progress {
+		opts.progress = newProgressMeter(inputs)
+	}
+	runBatch(inputs, *outputDir, *workers, opts.progress, *summaryPath, opts.manifest, state.track(convert))
+}
+
+// batchSizeOr returns the -batch-size given, or def when it wasn't.
+func batchSizeOr(n, def int) int {
+	if n == 0 {
+		return def
+	}
+	return n
+}
+
+// convertFile decodes a single Avro input and writes it to its output file,
+// or to stdout.
+func convertFile(in inputFile, opts decodeOptions) (result fileResult) {
+	defer withDeadLetters(in, &opts)(&result)
+	if opts.postgres != nil {
+		opts.blocks = in.blocks
+		return runFile(in, opts.postgres.table, func() (avroconvert.Stats, error) {
+			return loadPostgres(in, *opts.postgres, opts)
+		})
+	}
+	if opts.bigquery != nil {
+		opts.blocks = in.blocks
+		return runFile(in, opts.bigquery.String(), func() (avroconvert.Stats, error) {
+			return loadBigQuery(in, *opts.bigquery, opts)
+		})
+	}
+	if opts.clickhouse != nil {
+		opts.blocks = in.blocks
+		return runFile(in, opts.clickhouse.table, func() (avroconvert.Stats, error) {
+			return loadClickHouse(in, *opts.clickhouse, opts)
+		})
+	}
+	if opts.duckdb != nil {
+		opts.blocks = in.blocks
+		return runFile(in, opts.duckdb.path, func() (avroconvert.Stats, error) {
+			return loadDuckDB(in, *opts.duckdb, opts)
+		})
+	}
+	if opts.es != nil {
+		opts.blocks = in.blocks
+		return runFile(in, opts.es.indexName, func() (avroconvert.Stats, error) {
+			return indexElasticsearch(in, *opts.es, opts)
+		})
+	}
+	if opts.webhook != nil {
+		opts.blocks = in.blocks
+		return runFile(in, opts.webhook.url, func() (avroconvert.Stats, error) {
+			return postWebhook(in, *opts.webhook, opts)
+		})
+	}
+	output := opts.bindOutput(in, outputExt(opts.format, opts.compress))
+	if result, skip := skipExisting(in, output, outputExt(opts.format, opts.compress), opts); skip {
+		return result
+	}
+	opts.blocks = in.blocks
+	return runFile(in, output, func() (avroconvert.Stats, error) {
+		return convertStream(in, output, opts)
+	})
+}
+
+// convertStream opens the input and output for a file and runs the decoder
+// between them.
+func convertStream(in inputFile, output string, opts decodeOptions) (avroconvert.Stats, error) {
+	var stats avroconvert.Stats
+
+	// Extracted JSON messages carry no schema, so Parquet and Arrow columns
+	// are discovered in a first pass over the flattened messages
+	path := in.path
+	var columns []string
+	var types map[string]string
+	if columnarFormat(opts.format) && opts.jsonMessages() {
+		spooled, cleanup, err := spoolInput(in.path)
+		if err != nil {
+			return stats, err
+		}
+		defer cleanup()
+
+		collector := newColumnCollector(flattener{separator: parquetFlatSeparator})
+		if _, err := decodeFile(spooled, in.path, opts, collector); err != nil {
+			return stats, err
+		}
+		path, columns, types = spooled, collector.names, collector.types
+		opts.quiet = true
+	}
+
+	input, err := openDecodeInput(path, opts)
+	if err != nil {
+		return stats, err
+	}
+	defer input.Close()
+
+	newWriter := func(w io.Writer) (avroconvert.Sink, error) {
+		switch {
+		case opts.format == "ndjson":
+			return avroconvert.NewNDJSONSink(w), nil
+		case opts.format == "protobuf":
+			return newProtoWriter(w, opts.proto), nil
+		case opts.format == "msgpack" || opts.format == "cbor":
+			return newBinaryWriter(w, opts.format), nil
+		case opts.format == "parquet" && opts.jsonMessages():
+			return newParquetFlatWriter(w, columns, types)
+		case opts.format == "parquet":
+			return newParquetNativeWriter(w, opts.converter), nil
+		case opts.format == "arrow" && opts.jsonMessages():
+			return newArrowFlatWriter(w, columns, types)
+		case opts.format == "arrow":
+			return newArrowNativeWriter(w, opts.converter), nil
+		}
+		return avroconvert.NewJSONArraySink(w, opts.pretty), nil
+	}
+	split := openOutputSet(output, outputExt(opts.format, opts.compress), opts, newWriter)
+	defer split.abort()
+
+	var writer avroconvert.Sink = split
+	if columnarFormat(opts.format) && !opts.jsonMessages() {
+		writer = split.native()
+	}
+
+	if columnarFormat(opts.format) {
+		// Only the columnar formats hold records; the others stream them
+		var release func()
+		writer, release = opts.memory.guard(writer)
+		defer release()
+	}
+
+	stats, err = decodeMessages(bufio.NewReader(input), in.path, opts, writer)
+	if err != nil {
+		return stats, err
+	}
+
+	if err := split.Close(); err != nil {
+		return stats, err
+	}
+
+	slog.Info("Decoded messages", "input", in.path, "messages", stats.Messages, "output", split.written(), filteredAttr(stats))
+	return stats, nil
+}
+
+// columnarFormat reports whether records are written with a columnar
+// schema, derived from the Avro schema or discovered in a first pass.
+func columnarFormat(format string) bool {
+	return format == "parquet" || format == "arrow"
+}
+
+// jsonMessages reports whether the output is arbitrary JSON messages, which
+// the Avro schema doesn't describe, rather than whole records.
+func (opts decodeOptions) jsonMessages() bool {
+	return opts.field != "" || opts.transform != nil || opts.redact != nil || opts.explode != nil || opts.extendedJSON != nil || opts.epochFields != nil || opts.coerce != nil || opts.join != nil || opts.plugin != nil || opts.script != nil
+}
+
+// messageTransform returns the steps messages go through before they are
+// written, -extended-json, -epoch-fields, -coerce, -redact, -join, -plugin,
+// -script, -transform and -explode in that order, as one transform, or nil when
+// there are none.
+func (opts decodeOptions) messageTransform() func(json.RawMessage) ([]json.RawMessage, error) {
+	var steps []func(json.RawMessage) ([]json.RawMessage, error)
+	if opts.extendedJSON != nil {
+		steps = append(steps, opts.extendedJSON.apply)
+	}
+	if opts.epochFields != nil {
+		steps = append(steps, opts.epochFields.apply)
+	}
+	if opts.coerce != nil {
+		steps = append(steps, opts.coerce.apply)
+	}
+	if opts.redact != nil {
+		steps = append(steps, opts.redact.apply)
+	}
+	if opts.join != nil {
+		steps = append(steps, opts.join.apply)
+	}
+	if opts.plugin != nil {
+		steps = append(steps, opts.plugin.apply)
+	}
+	if opts.script != nil {
+		steps = append(steps, opts.script.apply)
+	}
+	if opts.transform != nil {
+		steps = append(steps, opts.transform.apply)
+	}
+	if opts.explode != nil {
+		steps = append(steps, opts.explode.apply)
+	}
+	switch len(steps) {
+	case 0:
+		return nil
+	case 1:
+		return steps[0]
+	}
+	return func(msg json.RawMessage) ([]json.RawMessage, error) {
+		msgs := []json.RawMessage{msg}
+		for _, step := range steps {
+			var next []json.RawMessage
+			for _, m := range msgs {
+				outputs, err := step(m)
+				if err != nil {
+					return nil, err
+				}
+				next = append(next, outputs...)
+			}
+			msgs = next
+		}
+		return msgs, nil
+	}
+}
+
+// decodeMessages reads an Avro OCF stream (or bare datums with opts.raw) and
+// hands each record to the writer as JSON. With an empty opts.field the
+// whole record is converted using the writer schema; otherwise only that
+// field is extracted, and bytes or string values are treated as embedded
+// JSON. Malformed records are reported and skipped, or fail or are
+// collected as opts.onError says; otherwise only OCF framing, schema and
+// write errors are returned. name identifies the input in warnings.
+func decodeMessages(r io.Reader, name string, opts decodeOptions, writer avroconvert.Sink) (avroconvert.Stats, error) {
+	records, schema, err := openRecords(r, opts.raw, opts.blockWorkers, opts.sampling.offset)
+	if err != nil {
+		return avroconvert.Stats{}, err
+	}
+	// Decoding may stop before the end, e.g. with -limit
+	if ocfRecords, ok := records.(*ocfRecordReader); ok {
+		defer ocfRecords.Close()
+	}
+	return decodeRecords(windowRecords(records, 0, opts.sampling.count), schema, name, opts, writer)
+}
+
+// decodeRecords converts the records of any RecordReader, decoded with the
+// writer schema, as described for decodeMessages.
+func decodeRecords(records avroconvert.RecordReader, schema *avroconvert.Schema, name string, opts decodeOptions, writer avroconvert.Sink) (avroconvert.Stats, error) {
+	decoderOpts := avroconvert.DecoderOptions{
+		Field:        opts.field,
+		ReaderSchema: opts.readerSchema,
+		Converter:    opts.converter,
+		Sampler:      newRecordSampler(opts.sampling, name),
+		StrictJSON:   opts.onError.strict(),
+	}
+	// A second pass over an input would repeat the warnings and failed
+	// records of the first
+	if !opts.quiet {
+		decoderOpts.Logger = slog.Default().With("input", name)
+		switch {
+		case opts.onError.mode == onErrorFail:
+			decoderOpts.OnError = func(e *avroconvert.RecordError) error { return e }
+		case opts.deadLetters != nil:
+			decoderOpts.OnError = opts.deadLetters.collect
+		}
+	}
+	if opts.filter != nil {
+		decoderOpts.Filter = opts.filter.match
+	}
+	if transform := opts.messageTransform(); transform != nil {
+		decoderOpts.Transform = transform
+	}
+	if opts.legacyMessage && opts.field == "" && pulsarRecords(schema) {
+		decoderOpts.Field = pulsarMessageField
+		legacyMessageWarning.Do(func() {
+			slog.Warn("Extracting the message field of Pulsar sink records without -field is deprecated; pass -field message to keep doing so, or -field= to convert whole records", "input", name)
+		})
+	}
+	return avroconvert.NewDecoder(decoderOpts).DecodeRecords(records, schema, meterRecords(writer, opts))
+}
+
+// openRecords starts reading an OCF stream, or bare datums when raw is set,
+// and returns the parsed writer schema. Both readers keep the bytes of
+// records that cannot be decoded, for dead-letter files. NDJSON and JSON
+// array input is recognized by its content and read without a schema. The
+// blocks of a container file are decoded up to workers at a time. Reading
+// starts at the record at position skip.
+func openRecords(r io.Reader, raw *rawInput, workers, skip int) (avroconvert.RecordReader, *avroconvert.Schema, error) {
+	var records avroconvert.RecordReader
+	var spec string
+	if raw != nil {
+		rr, err := newRawReader(r, raw)
+		if err != nil {
+			return nil, nil, err
+		}
+		records, spec = windowRecords(rr, skip, 0), raw.codec.Schema()
+	} else {
+		br := bufio.NewReader(r)
+		format, err := avroconvert.SniffFormat(br)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot read input: %w", err)
+		}
+		if format == avroconvert.FormatNDJSON || format == avroconvert.FormatJSONArray {
+			// JSON input has no schema, so its records are passed on as
+			// they are
+			records, err := avroconvert.NewJSONRecordReader(br)
+			if err != nil {
+				return nil, nil, err
+			}
+			return windowRecords(records, skip, 0), nil, nil
+		}
+		ocfRecords, err := newOCFRecordReader(br, workers, skip)
+		if err != nil {
+			return nil, nil, err
+		}
+		records, spec = ocfRecords, ocfRecords.codec.Schema()
+	}
+
+	schema, err := avroconvert.ParseSchema(spec)
+	if err != nil {
+		if ocfRecords, ok := records.(*ocfRecordReader); ok {
+			ocfRecords.Close()
+		}
+		return nil, nil, fmt.Errorf("cannot parse writer schema: %w", err)
+	}
+	return records, schema, nil
+}
+
+// pulsarRecords reports whether a writer schema is that of Pulsar sink
+// records, with their JSON messages in a bytes field.
+func pulsarRecords(schema *avroconvert.Schema) bool {
+	if schema == nil || schema.Kind != "record" {
+		return false
+	}
+	field := schema.Field(pulsarMessageField)
+	return field != nil && field.Schema.Kind == "bytes"
+}
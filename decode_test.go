@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"avroparser/pkg/avroconvert"
+	"github.com/linkedin/goavro/v2"
+)
+
+// messageSchema is the schema of exported files, each record holding one
+// JSON message.
+const messageSchema = `{"type":"record","name":"Export","fields":[{"name":"message","type":"bytes"}]}`
+
+// writeMessageOCF returns a container file of records holding msgs.
+func writeMessageOCF(t *testing.T, msgs ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := goavro.NewOCFWriter(goavro.OCFConfig{W: &buf, Schema: messageSchema})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range msgs {
+		if err := w.Append([]interface{}{map[string]interface{}{"message": []byte(msg)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+// testOptions returns decode options converting with the defaults, and
+// extracting field if set.
+func testOptions(t *testing.T, field string) decodeOptions {
+	t.Helper()
+	converter, err := avroconvert.NewJSONConverter(avroconvert.ConverterOptions{TimeZone: "UTC"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decodeOptions{field: field, compress: compressNone, converter: converter}
+}
+
+func TestDecodeMessages(t *testing.T) {
+	data := writeMessageOCF(t, `{"id":1}`, `not json`, `{"id": 3}`)
+	var out bytes.Buffer
+	stats, err := decodeMessages(bytes.NewReader(data), "test.avro", testOptions(t, "message"), avroconvert.NewNDJSONSink(&out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "{\"id\":1}\n\"not json\"\n{\"id\":3}\n"
+	if stats.Messages != 3 || stats.InvalidJSON != 1 || out.String() != want {
+		t.Fatalf("decoded %+v as %q, want %q", stats, out.String(), want)
+	}
+
+	if _, err := decodeMessages(strings.NewReader("not a container"), "test.avro", testOptions(t, ""), avroconvert.NewNDJSONSink(&out)); err == nil {
+		t.Fatal("decoded a stream that isn't a container file")
+	}
+}
+
+func TestOutputPath(t *testing.T) {
+	for in, want := range map[string]string{
+		"stdin":           filepath.Join("out", "stdin.ndjson"),
+		"events.avro":     filepath.Join("out", "events.ndjson"),
+		"2024/day.1.avro": filepath.Join("out", "2024", "day.1.ndjson"),
+		"noext":           filepath.Join("out", "noext.ndjson"),
+	} {
+		if got := outputPath(inputFile{rel: in}, "out", "ndjson"); got != want {
+			t.Errorf("outputPath(%q) = %q, want %q", in, got, want)
+		}
+	}
+	if got := outputPath(inputFile{rel: "events.avro"}, stdioPath, "ndjson"); got != stdioPath {
+		t.Errorf("outputPath to stdout = %q", got)
+	}
+}
+
+func TestConvertAll(t *testing.T) {
+	dir := t.TempDir()
+	var inputs []inputFile
+	for i := 0; i < 6; i++ {
+		rel := fmt.Sprintf("part-%d.avro", i)
+		data := writeMessageOCF(t, fmt.Sprintf(`{"file":%d}`, i), `{"n":2}`)
+		if i == 4 {
+			data = []byte("damaged")
+		}
+		inputs = append(inputs, inputFile{path: writeTestFile(t, rel, data), rel: rel})
+	}
+	opts := testOptions(t, "message")
+	opts.outputDir, opts.format = dir, "ndjson"
+	results := convertAll(inputs, 3, func(in inputFile) fileResult {
+		return convertFile(in, opts)
+	})
+	for i, result := range results {
+		if result.input != inputs[i] {
+			t.Fatalf("result %d is for %s", i, result.input.path)
+		}
+		if (result.err != nil) != (i == 4) {
+			t.Fatalf("input %d: %v", i, result.err)
+		}
+		if i == 4 {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf("part-%d.ndjson", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf("{\"file\":%d}\n{\"n\":2}\n", i); string(data) != want || result.stats.Messages != 2 {
+			t.Fatalf("input %d: wrote %q, %+v", i, data, result.stats)
+		}
+	}
+}
+
+func TestConvertFileTruncated(t *testing.T) {
+	data := writeTestOCF(t, ocfCodecNull, 500, nil, nil)
+	in := inputFile{path: writeTestFile(t, "events.avro", data[:len(data)-10]), rel: "events.avro"}
+	dir := t.TempDir()
+	opts := testOptions(t, "")
+	opts.outputDir, opts.format = dir, "ndjson"
+	opts.onError = errorPolicy{mode: onErrorSkip}
+	result := convertFile(in, opts)
+	if result.err != nil {
+		t.Fatal(result.err)
+	}
+	// The records before the damage are converted, and the lost rest counts
+	// as a failure that makes the run partial
+	if result.stats.Messages == 0 || result.stats.Messages >= 500 || result.stats.Skipped != 1 || result.stats.Failures[avroconvert.ReasonUnreadTail] != 1 {
+		t.Fatalf("converted %+v", result.stats)
+	}
+	ok := fileResult{input: in}
+	if code := batchExitCode([]fileResult{ok, result}); code != exitPartial {
+		t.Errorf("exit code %d with a truncated input, want %d", code, exitPartial)
+	}
+	if code := batchExitCode([]fileResult{ok, ok}); code != exitOK {
+		t.Errorf("exit code %d, want %d", code, exitOK)
+	}
+}
+
+// writeTestFile writes data to a file in a temporary directory.
+func writeTestFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const eventSchema = `{
+  "type": "record", "name": "Event", "namespace": "game",
+  "fields": [
+    {"name": "id", "type": "long"},
+    {"name": "kind", "type": {"type": "enum", "name": "Kind", "symbols": ["START", "END"]}},
+    {"name": "user", "type": ["null", "string"]},
+    {"name": "score", "type": "double"},
+    {"name": "raw", "type": "bytes"},
+    {"name": "tags", "type": {"type": "array", "items": "string"}},
+    {"name": "props", "type": {"type": "map", "values": ["null", "long"]}},
+    {"name": "next", "type": ["null", "Event"]}
+  ]
+}`
+
+// writeEventOCF returns a container file of records with eventSchema.
+func writeEventOCF(t *testing.T, records ...map[string]interface{}) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := goavro.NewOCFWriter(goavro.OCFConfig{W: &buf, Schema: eventSchema})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range records {
+		if err := w.Append([]interface{}{r}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func testEvent(id int64) map[string]interface{} {
+	return map[string]interface{}{
+		"id": id, "kind": "START", "user": goavro.Union("string", "ana"), "score": math.Inf(1),
+		"raw": []byte{0xff, 0x00}, "tags": []interface{}{"a"},
+		"props": map[string]interface{}{"level": goavro.Union("long", int64(3)), "none": nil},
+		"next":  goavro.Union("game.Event", map[string]interface{}{"id": id + 1, "kind": "END", "user": nil, "score": 0.5, "raw": []byte("ok"), "tags": []interface{}{}, "props": map[string]interface{}{}, "next": nil}),
+	}
+}
+
+func TestDecodeWholeRecords(t *testing.T) {
+	var out bytes.Buffer
+	stats, err := decodeMessages(bytes.NewReader(writeEventOCF(t, testEvent(1))), "test.avro", testOptions(t, ""), avroconvert.NewNDJSONSink(&out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"id":1,"kind":"START","next":{"id":2,"kind":"END","next":null,"props":{},"raw":"ok","score":0.5,"tags":[],"user":null},` +
+		`"props":{"level":3,"none":null},"raw":"/wA=","score":"Infinity","tags":["a"],"user":"ana"}` + "\n"
+	if stats.Messages != 1 || out.String() != want {
+		t.Fatalf("decoded %+v as\n%s\nwant\n%s", stats, out.String(), want)
+	}
+}
+
+func TestDecodeField(t *testing.T) {
+	var out bytes.Buffer
+	data := writeEventOCF(t, testEvent(1))
+	if _, err := decodeMessages(bytes.NewReader(data), "test.avro", testOptions(t, "props"), avroconvert.NewNDJSONSink(&out)); err != nil {
+		t.Fatal(err)
+	}
+	if want := "{\"level\":3,\"none\":null}\n"; out.String() != want {
+		t.Fatalf("extracted %q, want %q", out.String(), want)
+	}
+	if _, err := decodeMessages(bytes.NewReader(data), "test.avro", testOptions(t, "missing"), avroconvert.NewNDJSONSink(&out)); err == nil {
+		t.Fatal("extracted a field the schema doesn't have")
+	}
+}
+
+const scoreWriterSchema = `{
+  "type": "record", "name": "Score", "namespace": "game",
+  "fields": [
+    {"name": "id", "type": "int"},
+    {"name": "points", "type": "float"},
+    {"name": "level", "type": {"type": "enum", "name": "Level", "symbols": ["EASY", "HARD", "NIGHTMARE"]}},
+    {"name": "player", "type": "string"},
+    {"name": "debug", "type": "string"}
+  ]
+}`
+
+const scoreReaderSchema = `{
+  "type": "record", "name": "Score", "namespace": "game",
+  "fields": [
+    {"name": "id", "type": "long"},
+    {"name": "points", "type": "double"},
+    {"name": "level", "type": {"type": "enum", "name": "Level", "symbols": ["EASY", "HARD", "OTHER"], "default": "OTHER"}},
+    {"name": "user", "aliases": ["player"], "type": ["null", "string"]},
+    {"name": "region", "type": "string", "default": "eu"}
+  ]
+}`
+
+func TestDecodeReaderSchema(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := goavro.NewOCFWriter(goavro.OCFConfig{W: &buf, Schema: scoreWriterSchema})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, level := range []string{"HARD", "NIGHTMARE"} {
+		record := map[string]interface{}{"id": int32(i + 1), "points": float32(1.5), "level": level, "player": "ana", "debug": "x"}
+		if err := w.Append([]interface{}{record}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reader, err := avroconvert.ParseSchema(scoreReaderSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := testOptions(t, "")
+	opts.readerSchema = reader
+
+	var out bytes.Buffer
+	stats, err := decodeMessages(bytes.NewReader(buf.Bytes()), "test.avro", opts, avroconvert.NewNDJSONSink(&out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"id":1,"level":"HARD","points":1.5,"region":"eu","user":"ana"}` + "\n" +
+		`{"id":2,"level":"OTHER","points":1.5,"region":"eu","user":"ana"}` + "\n"
+	if stats.Messages != 2 || out.String() != want {
+		t.Fatalf("decoded %+v as\n%s\nwant\n%s", stats, out.String(), want)
+	}
+}
+
+func TestDecodeLegacyMessage(t *testing.T) {
+	opts := testOptions(t, "")
+	opts.legacyMessage = true
+	var out bytes.Buffer
+	if _, err := decodeMessages(bytes.NewReader(writeMessageOCF(t, `{"id":1}`)), "legacy.avro", opts, avroconvert.NewNDJSONSink(&out)); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "{\"id\":1}\n" {
+		t.Errorf("decoded Pulsar sink records as %q", out.String())
+	}
+
+	// Other records are converted whole
+	out.Reset()
+	if _, err := decodeMessages(bytes.NewReader(writeEventOCF(t, testEvent(1))), "events.avro", opts, avroconvert.NewNDJSONSink(&out)); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), `{"id":1,"kind":"START",`) {
+		t.Errorf("decoded other records as %q", out.String())
+	}
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"math/big"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"avroparser/pkg/avroconvert"
+)
+
+// Statuses of records that differ between the two sides of a diff.
+const (
+	diffChanged = "changed"
+	diffAdded   = "added"   // only on the right
+	diffRemoved = "removed" // only on the left
+)
+
+// diffReport counts the records of two inputs that are equal or differ, and
+// lists up to -max-diffs of those that differ.
+type diffReport struct {
+	LeftRecords   int64        `json:"left_records"`
+	RightRecords  int64        `json:"right_records"`
+	Equal         int64        `json:"equal"`
+	Changed       int64        `json:"changed"`
+	Added         int64        `json:"added"`
+	Removed       int64        `json:"removed"`
+	DuplicateKeys int64        `json:"duplicate_keys,omitempty"` // records skipped for a key seen before on their side
+	MissingKeys   int64        `json:"missing_keys,omitempty"`   // records skipped for having no key
+	Records       []recordDiff `json:"records"`
+}
+
+// recordDiff is a record that differs, with the fields that do if it is on
+// both sides.
+type recordDiff struct {
+	Key    string      `json:"key"`
+	Status string      `json:"status"`
+	Fields []fieldDiff `json:"fields,omitempty"`
+}
+
+// fieldDiff is a value that differs between the sides. A value missing on
+// one side is left out.
+type fieldDiff struct {
+	Path  string          `json:"path"`
+	Left  json.RawMessage `json:"left,omitempty"`
+	Right json.RawMessage `json:"right,omitempty"`
+}
+
+func runDiff(args []string) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	key := fs.String("key", "", "Field path identifying records on both sides, e.g. event_id (default: compare records by their position)")
+	ignore := fs.String("ignore", "", "Comma-separated field paths left out of the comparison, e.g. processed_at")
+	field := fs.String("field", "", "Compare this record field's embedded JSON messages instead of whole records (e.g. message)")
+	filterExpr := fs.String("filter", "", `Only compare records matching this expression, e.g. 'event_name == "purchase"'`)
+	format := fs.String("format", "text", "Report format: text or json")
+	maxDiffs := fs.Int("max-diffs", 100, "Most differing records to list (0 for all); all of them are counted")
+	logging := addLogFlags(fs)
+	parseFlags(fs, args)
+	logging.setup()
+
+	if fs.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "Usage: avroparser diff [-key <field>] [-ignore <field>,...] [-format text|json] <left_file|dir|glob> <right_file|dir|glob>")
+		os.Exit(exitFatal)
+	}
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "Unknown report format %q (expected text or json)\n", *format)
+		os.Exit(exitFatal)
+	}
+	if *maxDiffs < 0 {
+		fmt.Fprintf(os.Stderr, "-max-diffs must not be negative, got %d\n", *maxDiffs)
+		os.Exit(exitFatal)
+	}
+	var keyPath []string
+	if *key != "" {
+		keyPath = strings.Split(*key, ".")
+	}
+	var ignored [][]string
+	for _, path := range splitFieldList(*ignore) {
+		ignored = append(ignored, strings.Split(path, "."))
+	}
+	converter, err := avroconvert.NewJSONConverter(avroconvert.ConverterOptions{TimeZone: "UTC"})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	var filter *recordFilter
+	if *filterExpr != "" {
+		if filter, err = parseFilter(*filterExpr); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(exitFatal)
+		}
+	}
+
+	d := &recordDiffer{
+		opts:     decodeOptions{field: *field, converter: converter, filter: filter, sampling: sampling{rate: 1}, onError: errorPolicy{mode: onErrorSkip}},
+		keyPath:  keyPath,
+		ignored:  ignored,
+		maxDiffs: *maxDiffs,
+		report:   &diffReport{Records: []recordDiff{}},
+	}
+	if err := d.run(mustExpandInputs(fs.Arg(0)), mustExpandInputs(fs.Arg(1))); err != nil {
+		slog.Error("Cannot compare inputs", "error", err)
+		os.Exit(exitFatal)
+	}
+
+	report := d.report
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(report)
+	} else {
+		err = writeDiffText(os.Stdout, report, *key)
+	}
+	if err != nil {
+		slog.Error("Cannot write report", "error", err)
+		os.Exit(exitFatal)
+	}
+	if report.DuplicateKeys > 0 || report.MissingKeys > 0 {
+		slog.Warn("Skipped records without a unique -key", "duplicate", report.DuplicateKeys, "missing", report.MissingKeys)
+	}
+	slog.Info("Compared records", "left", report.LeftRecords, "right", report.RightRecords, "equal", report.Equal, "changed", report.Changed, "added", report.Added, "removed", report.Removed)
+	if report.Changed > 0 || report.Added > 0 || report.Removed > 0 {
+		os.Exit(exitPartial)
+	}
+}
+
+// recordDiffer compares the records of two sides by key. The left side is
+// held in memory as JSON, and the right side compared with it as it is read.
+type recordDiffer struct {
+	opts     decodeOptions
+	keyPath  []string // nil to key records by position
+	ignored  [][]string
+	maxDiffs int
+	report   *diffReport
+
+	left     map[string]*leftRecord
+	order    []string // keys of the left records, in order
+	position int64
+}
+
+type leftRecord struct {
+	msg     json.RawMessage
+	matched bool
+}
+
+// run reads the left inputs and then compares the right inputs with them.
+func (d *recordDiffer) run(left, right []inputFile) error {
+	d.left = make(map[string]*leftRecord)
+	if err := d.read(left, d.addLeft); err != nil {
+		return err
+	}
+	d.position = 0
+	if err := d.read(right, d.compareRight); err != nil {
+		return err
+	}
+	for _, key := range d.order {
+		if lr := d.left[key]; !lr.matched {
+			d.report.Removed++
+			d.list(recordDiff{Key: key, Status: diffRemoved})
+		}
+	}
+	return nil
+}
+
+// read passes every message of the inputs, in order, with its key to add.
+func (d *recordDiffer) read(inputs []inputFile, add func(key string, msg json.RawMessage, v interface{})) error {
+	sink := messageSink(func(msg json.RawMessage) error {
+		v, err := parseMessage(msg)
+		if err != nil {
+			return err
+		}
+		d.position++
+		var key string
+		if d.keyPath == nil {
+			key = strconv.FormatInt(d.position, 10)
+		} else {
+			value := lookupPath(v, d.keyPath)
+			if value == nil {
+				d.report.MissingKeys++
+				return nil
+			}
+			key = countKey(value)
+		}
+		add(key, msg, v)
+		return nil
+	})
+	for _, in := range inputs {
+		input, err := openDecodeInput(in.path, d.opts)
+		if err == nil {
+			_, err = decodeMessages(input, in.path, d.opts, sink)
+			input.Close()
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", displayPath(in.path), err)
+		}
+	}
+	return nil
+}
+
+func (d *recordDiffer) addLeft(key string, msg json.RawMessage, _ interface{}) {
+	d.report.LeftRecords++
+	if _, ok := d.left[key]; ok {
+		d.report.DuplicateKeys++
+		return
+	}
+	// The decoder may reuse the message's buffer
+	d.left[key] = &leftRecord{msg: append(json.RawMessage(nil), msg...)}
+	d.order = append(d.order, key)
+}
+
+func (d *recordDiffer) compareRight(key string, _ json.RawMessage, v interface{}) {
+	d.report.RightRecords++
+	lr, ok := d.left[key]
+	switch {
+	case !ok:
+		d.report.Added++
+		d.list(recordDiff{Key: key, Status: diffAdded})
+		return
+	case lr.matched:
+		d.report.DuplicateKeys++
+		return
+	}
+	lr.matched = true
+	lv, err := parseMessage(lr.msg)
+	if err != nil {
+		return
+	}
+	lr.msg = nil // no longer needed
+	for _, path := range d.ignored {
+		deletePath(lv, path)
+		deletePath(v, path)
+	}
+	var fields []fieldDiff
+	compareValues("", lv, v, 0, &fields)
+	if len(fields) == 0 {
+		d.report.Equal++
+		return
+	}
+	d.report.Changed++
+	d.list(recordDiff{Key: key, Status: diffChanged, Fields: fields})
+}
+
+// list adds a differing record to the report, up to -max-diffs of them.
+func (d *recordDiffer) list(rd recordDiff) {
+	if d.maxDiffs == 0 || len(d.report.Records) < d.maxDiffs {
+		d.report.Records = append(d.report.Records, rd)
+	}
+}
+
+// compareValues adds the differences between two parsed JSON values to
+// diffs. Objects are compared key by key and arrays element by element,
+// so only the values that differ are listed; numbers are equal as
+// numbersEqual says.
+func compareValues(path string, left, right interface{}, tolerance float64, diffs *[]fieldDiff) {
+	child := func(name string) string {
+		if path == "" {
+			return name
+		}
+		return path + "." + name
+	}
+	switch l := left.(type) {
+	case map[string]interface{}:
+		if r, ok := right.(map[string]interface{}); ok {
+			keys := make([]string, 0, len(l)+len(r))
+			for k := range l {
+				keys = append(keys, k)
+			}
+			for k := range r {
+				if _, ok := l[k]; !ok {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				lv, inLeft := l[k]
+				rv, inRight := r[k]
+				switch {
+				case !inLeft:
+					*diffs = append(*diffs, fieldDiff{Path: child(k), Right: diffValue(rv)})
+				case !inRight:
+					*diffs = append(*diffs, fieldDiff{Path: child(k), Left: diffValue(lv)})
+				default:
+					compareValues(child(k), lv, rv, tolerance, diffs)
+				}
+			}
+			return
+		}
+	case []interface{}:
+		if r, ok := right.([]interface{}); ok {
+			for i := 0; i < max(len(l), len(r)); i++ {
+				switch {
+				case i >= len(l):
+					*diffs = append(*diffs, fieldDiff{Path: child(strconv.Itoa(i)), Right: diffValue(r[i])})
+				case i >= len(r):
+					*diffs = append(*diffs, fieldDiff{Path: child(strconv.Itoa(i)), Left: diffValue(l[i])})
+				default:
+					compareValues(child(strconv.Itoa(i)), l[i], r[i], tolerance, diffs)
+				}
+			}
+			return
+		}
+	case json.Number:
+		if r, ok := right.(json.Number); ok && numbersEqual(l.String(), r.String(), tolerance) {
+			return
+		}
+	case string, bool, nil:
+		if left == right {
+			return
+		}
+	}
+	*diffs = append(*diffs, fieldDiff{Path: path, Left: diffValue(left), Right: diffValue(right)})
+}
+
+// numbersEqual reports whether two numbers have the same value, e.g. 1.0
+// and 1, or, with a tolerance, differ by at most that fraction of the
+// larger of them.
+func numbersEqual(a, b string, tolerance float64) bool {
+	x, xok := new(big.Rat).SetString(a)
+	y, yok := new(big.Rat).SetString(b)
+	if xok && yok && x.Cmp(y) == 0 {
+		return true
+	}
+	if tolerance == 0 {
+		return false
+	}
+	fx, err := strconv.ParseFloat(a, 64)
+	if err != nil {
+		return false
+	}
+	fy, err := strconv.ParseFloat(b, 64)
+	if err != nil {
+		return false
+	}
+	return math.Abs(fx-fy) <= tolerance*math.Max(math.Abs(fx), math.Abs(fy))
+}
+
+// diffValue returns the JSON text of a value.
+func diffValue(v interface{}) json.RawMessage {
+	text, err := json.Marshal(v)
+	if err != nil {
+		return json.RawMessage(strconv.Quote(fmt.Sprint(v)))
+	}
+	return text
+}
+
+// deletePath removes the field at path from the objects of a parsed JSON
+// value, in every element where the path passes through an array.
+func deletePath(v interface{}, path []string) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		if len(path) == 1 {
+			delete(t, path[0])
+			return
+		}
+		deletePath(t[path[0]], path[1:])
+	case []interface{}:
+		for _, item := range t {
+			deletePath(item, path)
+		}
+	}
+}
+
+// messageSink passes each message to a function.
+type messageSink func(msg json.RawMessage) error
+
+func (ms messageSink) WriteRecord(msg json.RawMessage) error { return ms(msg) }
+
+func (ms messageSink) Flush() error { return nil }
+
+func (ms messageSink) Close() error { return nil }
+
+// writeDiffText writes the differing records, with a line per differing
+// value, followed by the counts.
+func writeDiffText(w io.Writer, report *diffReport, key string) error {
+	for _, rd := range report.Records {
+		name := "record " + rd.Key
+		if key != "" {
+			name = key + "=" + rd.Key
+		}
+		fmt.Fprintf(w, "%s %s\n", rd.Status, name)
+		for _, f := range rd.Fields {
+			fmt.Fprintf(w, "  %s: %s -> %s\n", f.Path, diffText(f.Left), diffText(f.Right))
+		}
+	}
+	listed := int64(len(report.Records))
+	if differing := report.Changed + report.Added + report.Removed; listed < differing {
+		fmt.Fprintf(w, "... and %s more differing records\n", formatCount(differing-listed))
+	}
+	_, err := fmt.Fprintf(w, "\nLeft: %s records, right: %s records\nEqual: %s, changed: %s, added: %s, removed: %s\n",
+		formatCount(report.LeftRecords), formatCount(report.RightRecords),
+		formatCount(report.Equal), formatCount(report.Changed), formatCount(report.Added), formatCount(report.Removed))
+	return err
+}
+
+// diffText shows a missing value as (missing).
+func diffText(v json.RawMessage) string {
+	if v == nil {
+		return "(missing)"
+	}
+	return string(v)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newTestDiffer(t *testing.T, key string, ignored ...string) *recordDiffer {
+	t.Helper()
+	d := &recordDiffer{opts: testOptions(t, "message"), maxDiffs: 100, report: &diffReport{Records: []recordDiff{}}}
+	if key != "" {
+		d.keyPath = strings.Split(key, ".")
+	}
+	for _, path := range ignored {
+		d.ignored = append(d.ignored, strings.Split(path, "."))
+	}
+	return d
+}
+
+func TestDiffByKey(t *testing.T) {
+	left := []inputFile{{path: writeTestFile(t, "left.avro", writeMessageOCF(t,
+		`{"id":1,"score":1.0,"at":"x"}`,
+		`{"id":2,"tags":["a","b"]}`,
+		`{"id":3}`,
+		`{"id":3,"dup":true}`,
+		`{"name":"no key"}`,
+	))}}
+	right := []inputFile{{path: writeTestFile(t, "right.avro", writeMessageOCF(t,
+		`{"id":4}`,
+		`{"id":2,"tags":["a"],"new":null}`,
+		`{"id":1,"score":1,"at":"y"}`,
+	))}}
+	d := newTestDiffer(t, "id", "at")
+	if err := d.run(left, right); err != nil {
+		t.Fatal(err)
+	}
+	report := d.report
+	counts := [...]int64{report.LeftRecords, report.RightRecords, report.Equal, report.Changed, report.Added, report.Removed, report.DuplicateKeys, report.MissingKeys}
+	if counts != [...]int64{4, 3, 1, 1, 1, 1, 1, 1} {
+		t.Fatalf("counted %v", counts)
+	}
+	want := []recordDiff{
+		{Key: "4", Status: diffAdded},
+		{Key: "2", Status: diffChanged, Fields: []fieldDiff{
+			{Path: "new", Right: json.RawMessage("null")},
+			{Path: "tags.1", Left: json.RawMessage(`"b"`)},
+		}},
+		{Key: "3", Status: diffRemoved},
+	}
+	if !reflect.DeepEqual(report.Records, want) {
+		t.Fatalf("listed %+v, want %+v", report.Records, want)
+	}
+
+	var out bytes.Buffer
+	if err := writeDiffText(&out, report, "id"); err != nil {
+		t.Fatal(err)
+	}
+	wantText := "added id=4\n" +
+		"changed id=2\n" +
+		"  new: (missing) -> null\n" +
+		"  tags.1: \"b\" -> (missing)\n" +
+		"removed id=3\n" +
+		"\nLeft: 4 records, right: 3 records\nEqual: 1, changed: 1, added: 1, removed: 1\n"
+	if out.String() != wantText {
+		t.Errorf("wrote\n%s\nwant\n%s", out.String(), wantText)
+	}
+}
+
+func TestDiffByPosition(t *testing.T) {
+	left := []inputFile{{path: writeTestFile(t, "left.avro", writeMessageOCF(t, `{"a":1}`, `{"a":2}`, `{"a":3}`))}}
+	right := []inputFile{{path: writeTestFile(t, "right.avro", writeMessageOCF(t, `{"a":1}`, `{"a":"2"}`))}}
+	d := newTestDiffer(t, "")
+	d.maxDiffs = 1
+	if err := d.run(left, right); err != nil {
+		t.Fatal(err)
+	}
+	want := []recordDiff{{Key: "2", Status: diffChanged, Fields: []fieldDiff{{Path: "a", Left: json.RawMessage("2"), Right: json.RawMessage(`"2"`)}}}}
+	if !reflect.DeepEqual(d.report.Records, want) || d.report.Removed != 1 {
+		t.Fatalf("report %+v", d.report)
+	}
+
+	var out bytes.Buffer
+	writeDiffText(&out, d.report, "")
+	if !strings.Contains(out.String(), "changed record 2\n  a: 2 -> \"2\"\n... and 1 more differing records\n") {
+		t.Errorf("wrote\n%s", out.String())
+	}
+}
+
+func TestDeletePath(t *testing.T) {
+	v, err := parseMessage(json.RawMessage(`{"items":[{"id":1,"at":2},{"at":3}],"meta":{"at":4,"keep":5}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deletePath(v, []string{"items", "at"})
+	deletePath(v, []string{"meta", "at"})
+	deletePath(v, []string{"missing", "at"})
+	if got := string(diffValue(v)); got != `{"items":[{"id":1},{}],"meta":{"keep":5}}` {
+		t.Fatalf("left %s", got)
+	}
+}
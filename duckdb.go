@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"avroparser/pkg/avroconvert"
+	_ "github.com/duckdb/duckdb-go/v2"
+)
+
+func isDuckDBPath(p string) bool {
+	return strings.EqualFold(filepath.Ext(p), ".duckdb")
+}
+
+// duckDBOptions holds the settings for loading into a DuckDB database.
+type duckDBOptions struct {
+	path    string
+	table   string // table every input is loaded into, if set
+	tableBy string // field whose values name the tables, e.g. event_name
+}
+
+// duckDBMu serializes loads, since a database file has a single writer.
+var duckDBMu sync.Mutex
+
+// duckDBTableName turns an input file name or field value into a table
+// name, e.g. events-2026-01-10.avro.gz into events_2026_01_10.
+func duckDBTableName(name string) string {
+	var b strings.Builder
+	for _, c := range name {
+		if c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+			b.WriteRune(c)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	table := b.String()
+	if table == "" {
+		return "records"
+	}
+	if table[0] >= '0' && table[0] <= '9' {
+		table = "t_" + table
+	}
+	return table
+}
+
+// quoteDuckDBName quotes an identifier.
+func quoteDuckDBName(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// duckDBTables spools records as NDJSON into a temporary file per table.
+type duckDBTables struct {
+	dir     string
+	table   string   // table of every record, unless tableBy is set
+	tableBy []string // field path naming the table of each record
+	files   map[string]*duckDBTableFile
+	order   []string
+	line    bytes.Buffer
+}
+
+type duckDBTableFile struct {
+	file *os.File
+	w    *bufio.Writer
+	rows int64
+}
+
+func newDuckDBTables(dir, table, tableBy string) *duckDBTables {
+	dt := &duckDBTables{dir: dir, table: table, files: make(map[string]*duckDBTableFile)}
+	if tableBy != "" {
+		dt.tableBy = strings.Split(tableBy, ".")
+	}
+	return dt
+}
+
+func (dt *duckDBTables) WriteRecord(msg json.RawMessage) error {
+	table := dt.table
+	if dt.tableBy != nil {
+		v, err := parseMessage(msg)
+		if err != nil {
+			return err
+		}
+		value := lookupPath(v, dt.tableBy)
+		if value == nil {
+			table = "null"
+		} else {
+			table = duckDBTableName(partitionValue(value))
+		}
+	}
+
+	tf, ok := dt.files[table]
+	if !ok {
+		f, err := os.Create(filepath.Join(dt.dir, fmt.Sprintf("%d.ndjson", len(dt.order))))
+		if err != nil {
+			return fmt.Errorf("cannot spool records: %w", err)
+		}
+		tf = &duckDBTableFile{file: f, w: bufio.NewWriter(f)}
+		dt.files[table] = tf
+		dt.order = append(dt.order, table)
+	}
+	// read_json needs one record per line
+	dt.line.Reset()
+	if err := json.Compact(&dt.line, msg); err != nil {
+		return err
+	}
+	dt.line.WriteByte('\n')
+	tf.rows++
+	_, err := tf.w.Write(dt.line.Bytes())
+	return err
+}
+
+func (dt *duckDBTables) Flush() error {
+	for _, table := range dt.order {
+		if err := dt.files[table].w.Flush(); err != nil {
+			return fmt.Errorf("cannot spool records: %w", err)
+		}
+	}
+	return nil
+}
+
+// Close flushes and closes the spool files, reporting the first error.
+func (dt *duckDBTables) Close() error {
+	first := dt.Flush()
+	for _, table := range dt.order {
+		if err := dt.files[table].file.Close(); err != nil && first == nil {
+			first = fmt.Errorf("cannot spool records: %w", err)
+		}
+	}
+	return first
+}
+
+// loadDuckDB loads the records of an input into a DuckDB database, into
+// -table, a table named after the input, or a table per value of the
+// -table-by field. DuckDB's read_json infers the column types, nested
+// records becoming STRUCTs and arrays LISTs. Missing tables are created
+// and existing ones appended to by column name. All tables of an input are
+// loaded in one transaction, so a failure adds no rows.
+func loadDuckDB(in inputFile, do duckDBOptions, opts decodeOptions) (avroconvert.Stats, error) {
+	input, err := openDecodeInput(in.path, opts)
+	if err != nil {
+		return avroconvert.Stats{}, err
+	}
+	defer input.Close()
+
+	dir, err := os.MkdirTemp("", "avroparser-duckdb-*")
+	if err != nil {
+		return avroconvert.Stats{}, fmt.Errorf("cannot spool records: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	table := do.table
+	if table == "" {
+		name := trimCompressionSuffix(filepath.Base(in.rel))
+		table = duckDBTableName(strings.TrimSuffix(name, filepath.Ext(name)))
+	}
+	tables := newDuckDBTables(dir, table, do.tableBy)
+	stats, err := decodeMessages(input, in.path, opts, tables)
+	if closeErr := tables.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil || len(tables.order) == 0 {
+		return stats, err
+	}
+
+	duckDBMu.Lock()
+	defer duckDBMu.Unlock()
+	db, err := sql.Open("duckdb", do.path)
+	if err != nil {
+		return stats, fmt.Errorf("cannot open DuckDB database %s: %w", do.path, err)
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return stats, fmt.Errorf("cannot open DuckDB database %s: %w", do.path, err)
+	}
+	defer tx.Rollback()
+
+	var rows int64
+	for _, table := range tables.order {
+		var exists bool
+		if err := tx.QueryRow(`SELECT count(*) > 0 FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = ?`, table).Scan(&exists); err != nil {
+			return stats, fmt.Errorf("cannot look up table %s: %w", table, err)
+		}
+		query := "CREATE TABLE %s AS SELECT * FROM read_json(?, format = 'newline_delimited')"
+		if exists {
+			query = "INSERT INTO %s BY NAME SELECT * FROM read_json(?, format = 'newline_delimited')"
+		}
+		tf := tables.files[table]
+		if _, err := tx.Exec(fmt.Sprintf(query, quoteDuckDBName(table)), tf.file.Name()); err != nil {
+			return stats, fmt.Errorf("cannot load table %s: %w", table, err)
+		}
+		rows += tf.rows
+	}
+	if err := tx.Commit(); err != nil {
+		return stats, fmt.Errorf("cannot load into %s: %w", do.path, err)
+	}
+
+	slog.Info("Loaded rows into DuckDB", "input", in.path, "rows", rows, "tables", len(tables.order), "database", do.path, filteredAttr(stats))
+	return stats, nil
+}
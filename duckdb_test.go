@@ -0,0 +1,61 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestDuckDBTableName(t *testing.T) {
+	for in, want := range map[string]string{
+		"events-2026-01-10": "events_2026_01_10",
+		"level_complete":    "level_complete",
+		"2026":              "t_2026",
+		"":                  "records",
+	} {
+		if got := duckDBTableName(in); got != want {
+			t.Errorf("duckDBTableName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestLoadDuckDB(t *testing.T) {
+	db := filepath.Join(t.TempDir(), "events.duckdb")
+	var msgs []string
+	for i := 0; i < 5; i++ {
+		msgs = append(msgs, fmt.Sprintf(`{"id": %d, "event_name": "%s", "geo": {"country": "DE"}}`, i, []string{"start", "level-end"}[i%2]))
+	}
+	in := inputFile{path: writeTestFile(t, "events-1.avro", writeMessageOCF(t, msgs...)), rel: "events-1.avro"}
+	opts := testOptions(t, "message")
+
+	// A table named after the input, appended to on a second load
+	for i := 0; i < 2; i++ {
+		if _, err := loadDuckDB(in, duckDBOptions{path: db}, opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := loadDuckDB(in, duckDBOptions{path: db, tableBy: "event_name"}, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := sql.Open("duckdb", db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for query, want := range map[string]int{
+		`SELECT count(*) FROM events_1`:                          10,
+		`SELECT count(*) FROM events_1 WHERE geo.country = 'DE'`: 10,
+		`SELECT count(*) FROM start`:                             3,
+		`SELECT count(*) FROM level_end`:                         2,
+	} {
+		var n int
+		if err := conn.QueryRow(query).Scan(&n); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		if n != want {
+			t.Errorf("%s = %d, want %d", query, n, want)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestIndexTemplate(t *testing.T) {
+	record := map[string]interface{}{"event_name": "Level_End", "ts": "2026-01-10T12:00:00Z", "geo": map[string]interface{}{"country": "DE"}}
+	for template, want := range map[string]string{
+		"events":                       "events",
+		"events-{event_name}":          "events-level_end",
+		"events-{ts|2006.01.02}":       "events-2026.01.10",
+		"{geo.country}-{event_name}-x": "de-level_end-x",
+	} {
+		tmpl, err := parseIndexTemplate(template)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := tmpl.name(record); err != nil || got != want {
+			t.Errorf("%s named %q (%v), want %q", template, got, err, want)
+		}
+	}
+	for _, template := range []string{"events-{missing}", "events-{event_name|2006}"} {
+		tmpl, err := parseIndexTemplate(template)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := tmpl.name(record); err == nil {
+			t.Errorf("%s named %q", template, got)
+		}
+	}
+	for _, template := range []string{"events-{", "events-{}"} {
+		if _, err := parseIndexTemplate(template); err == nil {
+			t.Errorf("parsed %q", template)
+		}
+	}
+}
+
+// fakeElasticsearch serves the _bulk endpoint, rejecting the first busy
+// documents with 429 and documents with a "bad" field with a mapping error.
+type fakeElasticsearch struct {
+	mu    sync.Mutex
+	busy  int
+	auth  string
+	metas []map[string]map[string]string
+	docs  []map[string]interface{}
+}
+
+func (f *fakeElasticsearch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.URL.Path != "/_bulk" || r.Header.Get("Content-Type") != "application/x-ndjson" {
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+		return
+	}
+	f.auth = r.Header.Get("Authorization")
+	var items []map[string]interface{}
+	scanner := bufio.NewScanner(r.Body)
+	for scanner.Scan() {
+		var meta map[string]map[string]string
+		json.Unmarshal(scanner.Bytes(), &meta)
+		scanner.Scan()
+		var doc map[string]interface{}
+		json.Unmarshal(scanner.Bytes(), &doc)
+		switch {
+		case f.busy > 0:
+			f.busy--
+			items = append(items, map[string]interface{}{"index": map[string]interface{}{"status": 429}})
+		case doc["bad"] != nil:
+			items = append(items, map[string]interface{}{"index": map[string]interface{}{
+				"status": 400, "error": map[string]string{"type": "mapper_parsing_exception", "reason": "failed to parse field [bad]"}}})
+		default:
+			f.metas = append(f.metas, meta)
+			f.docs = append(f.docs, doc)
+			items = append(items, map[string]interface{}{"index": map[string]interface{}{"status": 201}})
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+}
+
+func TestESSink(t *testing.T) {
+	fake := &fakeElasticsearch{busy: 1}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	t.Setenv("ES_API_KEY", "")
+	client, err := newESClient("elasticsearch+http://elastic:secret@" + server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	template, err := parseIndexTemplate("events-{kind}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sink := newESSink(client, esOptions{index: template, idField: []string{"id"}, batchSize: 2})
+	for _, msg := range []string{`{"id": 1, "kind": "start"}`, `{"id": "b", "kind": "end"}`, `{"kind": "end"}`} {
+		if err := sink.WriteRecord(json.RawMessage(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.WriteRecord(json.RawMessage(`[1]`)); err == nil {
+		t.Fatal("indexed a document that isn't an object")
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The busy document is sent again after the rest of its batch
+	wantMetas := []map[string]map[string]string{
+		{"index": {"_index": "events-end", "_id": "b"}},
+		{"index": {"_index": "events-start", "_id": "1"}},
+		{"index": {"_index": "events-end"}},
+	}
+	if !reflect.DeepEqual(fake.metas, wantMetas) || sink.indexed != 3 || len(sink.indices) != 2 {
+		t.Fatalf("indexed %d documents as %v into %v", sink.indexed, fake.metas, sink.indices)
+	}
+	if fake.auth != "Basic ZWxhc3RpYzpzZWNyZXQ=" {
+		t.Fatalf("sent Authorization %q", fake.auth)
+	}
+
+	if err := sink.WriteRecord(json.RawMessage(`{"kind": "end", "bad": 1}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Flush(); err == nil {
+		t.Fatal("flushed a document the cluster rejected")
+	}
+}
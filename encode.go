@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"avroparser/pkg/avroconvert"
+)
+
+// ocfCodecs maps -codec values to Avro codec names.
+var ocfCodecs = map[string]string{
+	"null":      ocfCodecNull,
+	"deflate":   ocfCodecDeflate,
+	"snappy":    ocfCodecSnappy,
+	"xz":        ocfCodecXZ,
+	"zstd":      ocfCodecZstandard,
+	"zstandard": ocfCodecZstandard,
+}
+
+func runEncode(args []string) {
+	fs := flag.NewFlagSet("encode", flag.ExitOnError)
+	inputPath := fs.String("input", "", "Input NDJSON or JSON array file, or - for stdin")
+	outputPath := fs.String("output", "", "Output Avro file, or - for stdout (default: the input name with an .avro extension)")
+	schemaPath := fs.String("schema", "", "Avro schema (.avsc) of the records")
+	codec := fs.String("codec", "null", "Block compression: null, deflate, snappy, xz or zstd")
+	timeFormat := fs.String("time-format", avroconvert.TimeFormatRFC3339, "Timestamp format of the input: rfc3339, unix, unixmilli, unixmicro, unixnano or a Go time layout")
+	timeZone := fs.String("timezone", "UTC", "Time zone of timestamps whose -time-format has none (IANA name, e.g. Europe/Berlin)")
+	redaction := addRedactFlags(fs)
+	logging := addLogFlags(fs)
+	parseFlags(fs, args)
+	logging.setup()
+
+	if *inputPath == "" && fs.NArg() > 0 {
+		*inputPath = fs.Arg(0)
+	}
+
+	if *inputPath == "" || *schemaPath == "" {
+		fmt.Fprintln(os.Stderr, "Usage: avroparser encode -input <ndjson_file|-> -schema <avsc_file> [-output <avro_file>|-] [-codec null|deflate|snappy|xz|zstd]")
+		os.Exit(exitFatal)
+	}
+
+	codecName, ok := ocfCodecs[*codec]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown codec %q (expected null, deflate, snappy, xz or zstd)\n", *codec)
+		os.Exit(exitFatal)
+	}
+	redact, err := redaction.redactor()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	converter, err := newNativeConverter(*timeFormat, *timeZone)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	spec, err := os.ReadFile(*schemaPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot read schema: %v\n", err)
+		os.Exit(exitFatal)
+	}
+	schema, err := avroconvert.ParseSchema(string(spec))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot parse schema %s: %v\n", *schemaPath, err)
+		os.Exit(exitFatal)
+	}
+
+	if *outputPath == "" {
+		*outputPath = avroOutputPath(*inputPath)
+	}
+	name := *inputPath
+	if name == stdioPath {
+		name = "stdin"
+	}
+	count, err := encodeFile(*inputPath, *outputPath, string(spec), codecName, schema, converter, redact)
+	if err != nil {
+		slog.Error("Cannot encode input", "input", name, "error", err)
+		os.Exit(exitFatal)
+	}
+	slog.Info("Encoded records", "input", name, "records", count, "output", displayPath(*outputPath))
+}
+
+// avroOutputPath is the default output of an input: its name with the
+// extension swapped for .avro, or stdout for stdin.
+func avroOutputPath(input string) string {
+	if input == stdioPath {
+		return stdioPath
+	}
+	name := trimCompressionSuffix(input)
+	return strings.TrimSuffix(name, filepath.Ext(name)) + ".avro"
+}
+
+// encodeFile writes the JSON records of an input as an Avro container file,
+// redacted with redact if set, and returns how many it wrote.
+func encodeFile(input, output, spec, codec string, schema *avroconvert.Schema, converter *nativeConverter, redact *redactor) (int, error) {
+	in, err := openInput(input)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	out, err := openOutput(output)
+	if err != nil {
+		return 0, err
+	}
+	ow, err := newOCFWriter(out, spec, codec)
+	if err != nil {
+		abortOutput(out)
+		return 0, err
+	}
+
+	count, err := encodeRecords(in, schema, converter, redact, ow)
+	if err == nil {
+		err = ow.Close()
+	}
+	if err != nil {
+		abortOutput(out)
+		return count, err
+	}
+	if err := out.Close(); err != nil {
+		return count, fmt.Errorf("cannot write output file: %w", err)
+	}
+	return count, nil
+}
+
+// encodeRecords reads JSON records, either one per line or as a single
+// array, and writes them to ow.
+func encodeRecords(r io.Reader, schema *avroconvert.Schema, converter *nativeConverter, redact *redactor, ow *ocfWriter) (int, error) {
+	br := bufio.NewReader(r)
+	if format, err := avroconvert.SniffFormat(br); err != nil {
+		return 0, err
+	} else if format == avroconvert.FormatAvro {
+		return 0, errors.New("input is already an Avro container file")
+	}
+	records, err := avroconvert.NewJSONRecordReader(br)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for records.Scan() {
+		v, err := records.Read()
+		if err != nil {
+			return count, fmt.Errorf("record %d: %w", count+1, err)
+		}
+		if redact != nil {
+			v = redact.value(v)
+		}
+		record, err := converter.native(schema, v)
+		if err != nil {
+			return count, fmt.Errorf("record %d: %w", count+1, err)
+		}
+		if err := ow.Write(record); err != nil {
+			return count, fmt.Errorf("record %d: %w", count+1, err)
+		}
+		count++
+	}
+	if err := records.Err(); err != nil {
+		return count, fmt.Errorf("record %d: %w", count+1, err)
+	}
+	return count, nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"avroparser/pkg/avroconvert"
+)
+
+const encodeSchema = `{
+  "type": "record", "name": "Purchase",
+  "fields": [
+    {"name": "id", "type": "long"},
+    {"name": "user", "type": ["null", "string"]},
+    {"name": "at", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+    {"name": "price", "type": {"type": "bytes", "logicalType": "decimal", "precision": 6, "scale": 2}},
+    {"name": "tags", "type": {"type": "array", "items": "string"}}
+  ]
+}`
+
+func TestEncodeRoundTrip(t *testing.T) {
+	schema, err := avroconvert.ParseSchema(encodeSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	converter, err := newNativeConverter(avroconvert.TimeFormatRFC3339, "UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"at":"2026-01-02T03:04:05.5Z","id":1,"price":"19.99","tags":["a"],"user":"ana"}` + "\n" +
+		`{"at":"2026-01-02T03:04:06Z","id":2,"price":"-0.50","tags":[],"user":null}` + "\n"
+
+	for name, input := range map[string]string{
+		"ndjson": want,
+		"array":  "[\n" + strings.Replace(strings.TrimSpace(want), "\n", ",\n", 1) + "\n]\n",
+	} {
+		for _, codec := range []string{ocfCodecNull, ocfCodecDeflate} {
+			var buf bytes.Buffer
+			ow, err := newOCFWriter(&buf, encodeSchema, codec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			n, err := encodeRecords(strings.NewReader(input), schema, converter, nil, ow)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if err := ow.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			var out bytes.Buffer
+			if _, err := decodeMessages(bytes.NewReader(buf.Bytes()), "test.avro", testOptions(t, ""), avroconvert.NewNDJSONSink(&out)); err != nil {
+				t.Fatal(err)
+			}
+			if n != 2 || out.String() != want {
+				t.Fatalf("%s, %s: encoded %d records, decoded as\n%s\nwant\n%s", name, codec, n, out.String(), want)
+			}
+		}
+	}
+}
+
+func TestEncodeRejectsMismatch(t *testing.T) {
+	schema, err := avroconvert.ParseSchema(encodeSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	converter, err := newNativeConverter(avroconvert.TimeFormatRFC3339, "UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ow, err := newOCFWriter(&bytes.Buffer{}, encodeSchema, ocfCodecNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := `{"id":1,"user":null,"at":0,"price":"1.00","tags":[]}` + "\n" + `{"id":"two"}` + "\n"
+	if n, err := encodeRecords(strings.NewReader(input), schema, converter, nil, ow); err == nil || !strings.HasPrefix(err.Error(), "record 2:") || n != 1 {
+		t.Fatalf("encoded %d records, error %v", n, err)
+	}
+}
+
+func TestEncodeFileFails(t *testing.T) {
+	schema, err := avroconvert.ParseSchema(encodeSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	converter, err := newNativeConverter(avroconvert.TimeFormatRFC3339, "UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := writeTestFile(t, "purchases.ndjson", []byte(`{"id":1,"user":null,"at":0,"price":"1.00","tags":[]}`+"\n"+`{"id":"two"}`+"\n"))
+	dir := t.TempDir()
+	if _, err := encodeFile(input, filepath.Join(dir, "purchases.avro"), encodeSchema, ocfCodecNull, schema, converter, nil); err == nil {
+		t.Fatal("encoded a record that doesn't match the schema")
+	}
+	checkNoOutputs(t, dir)
+}
+
+func TestAvroOutputPath(t *testing.T) {
+	for in, want := range map[string]string{"-": "-", "events.ndjson": "events.avro", "dir/events.json.gz": "dir/events.avro"} {
+		if got := avroOutputPath(in); got != want {
+			t.Errorf("avroOutputPath(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"avroparser/pkg/avroconvert"
+)
+
+// epochFields renders the epoch numbers of plain number fields, which the
+// converter can't tell from other numbers, as timestamps, optionally adding
+// columns for their date, hour and day of the week.
+type epochFields struct {
+	paths     [][]string
+	converter *avroconvert.JSONConverter
+	location  *time.Location // of the derived columns
+	parts     bool
+}
+
+// parseEpochFields parses -epoch-fields, returning nil when none are given.
+func parseEpochFields(fields string, parts bool, converter *avroconvert.JSONConverter, timeZone string) (*epochFields, error) {
+	if fields == "" {
+		if parts {
+			return nil, fmt.Errorf("-time-columns is only used with -epoch-fields")
+		}
+		return nil, nil
+	}
+	location, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone %q: %w", timeZone, err)
+	}
+	ef := &epochFields{converter: converter, location: location, parts: parts}
+	for _, path := range splitFieldList(fields) {
+		if path == "" || strings.HasPrefix(path, ".") || strings.HasSuffix(path, ".") || strings.Contains(path, "..") {
+			return nil, fmt.Errorf("invalid -epoch-fields path %q", path)
+		}
+		ef.paths = append(ef.paths, strings.Split(path, "."))
+	}
+	return ef, nil
+}
+
+// apply converts the fields of a JSON message. It is used as a decoder
+// transform, ahead of -redact and -transform.
+func (ef *epochFields) apply(msg json.RawMessage) ([]json.RawMessage, error) {
+	v, err := parseMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range ef.paths {
+		parent, _ := lookupPath(v, path[:len(path)-1]).(map[string]interface{})
+		name := path[len(path)-1]
+		t, ok := eventTime(parent[name], "")
+		if !ok {
+			continue
+		}
+		parent[name] = ef.converter.Timestamp(t)
+		if ef.parts {
+			local := t.In(ef.location)
+			parent[name+"_date"] = local.Format(time.DateOnly)
+			parent[name+"_hour"] = local.Hour()
+			parent[name+"_day_of_week"] = local.Weekday().String()
+		}
+	}
+	text, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return []json.RawMessage{text}, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"avroparser/pkg/avroconvert"
+)
+
+func TestEpochFields(t *testing.T) {
+	converter, err := avroconvert.NewJSONConverter(avroconvert.ConverterOptions{TimeZone: "UTC"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ef, err := parseEpochFields("timestamp,payload.ends_at,missing", true, converter, "Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ef.apply(json.RawMessage(`{"timestamp":1760529600,"payload":{"ends_at":1760572800000,"score":5},"name":"x"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"name":"x","payload":{"ends_at":"2025-10-16T00:00:00Z","ends_at_date":"2025-10-16","ends_at_day_of_week":"Thursday","ends_at_hour":2,"score":5},` +
+		`"timestamp":"2025-10-15T12:00:00Z","timestamp_date":"2025-10-15","timestamp_day_of_week":"Wednesday","timestamp_hour":14}`
+	if len(got) != 1 || string(got[0]) != want {
+		t.Fatalf("apply = %s, want %s", got, want)
+	}
+
+	// Values that aren't epoch numbers are left as they are
+	got, err = ef.apply(json.RawMessage(`{"timestamp":"soon"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got[0]) != `{"timestamp":"soon"}` {
+		t.Fatalf("apply = %s", got)
+	}
+}
+
+func TestParseEpochFieldsErrors(t *testing.T) {
+	if ef, err := parseEpochFields("", false, nil, "UTC"); ef != nil || err != nil {
+		t.Fatalf("no -epoch-fields gave %+v, %v", ef, err)
+	}
+	for _, tt := range []struct {
+		fields   string
+		parts    bool
+		timeZone string
+	}{
+		{"", true, "UTC"},
+		{"ts.", false, "UTC"},
+		{"ts", false, "Mars/Olympus"},
+	} {
+		if _, err := parseEpochFields(tt.fields, tt.parts, nil, tt.timeZone); err == nil {
+			t.Errorf("parseEpochFields(%q, %v, %q) succeeded", tt.fields, tt.parts, tt.timeZone)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"avroparser/pkg/avroconvert"
+)
+
+// eventFlags select the events read by the commands that analyze them
+// across all their inputs, such as sessions, funnel and retention.
+// userField is nil for the commands that don't follow users.
+type eventFlags struct {
+	field     *string
+	filter    *string
+	since     *string
+	until     *string
+	timeField *string
+	userField *string
+}
+
+func addEventFlags(fs *flag.FlagSet) *eventFlags {
+	ef := addEventFilterFlags(fs)
+	ef.userField = fs.String("user-field", "user_pseudo_id", "Field path identifying the user, e.g. playerID for metrics events")
+	return ef
+}
+
+// addEventFilterFlags adds the flags selecting events, without -user-field.
+func addEventFilterFlags(fs *flag.FlagSet) *eventFlags {
+	return &eventFlags{
+		field:     fs.String("field", "", "Read the events from this record field's embedded JSON messages instead of whole records (e.g. message)"),
+		filter:    fs.String("filter", "", `Only read events matching this expression, e.g. 'platform == "ANDROID"'`),
+		since:     fs.String("since", "", "Only read events whose -timestamp-field is at or after this time (RFC 3339, date or Unix epoch)"),
+		until:     fs.String("until", "", "Only read events whose -timestamp-field is before this time (RFC 3339, date or Unix epoch)"),
+		timeField: fs.String("timestamp-field", "event_timestamp", "Field path of the event time"),
+	}
+}
+
+// eventSource reads events for analysis. The user and time of each event
+// are looked up at the paths the flags give.
+type eventSource struct {
+	opts     decodeOptions
+	userPath []string
+	timePath []string
+}
+
+// source validates the flags.
+func (ef *eventFlags) source() (*eventSource, error) {
+	paths := []string{*ef.timeField}
+	if ef.userField != nil {
+		paths = append(paths, *ef.userField)
+	}
+	for _, path := range paths {
+		if path == "" || strings.HasPrefix(path, ".") || strings.HasSuffix(path, ".") || strings.Contains(path, "..") {
+			return nil, fmt.Errorf("invalid field path %q", path)
+		}
+	}
+	converter, err := avroconvert.NewJSONConverter(avroconvert.ConverterOptions{TimeZone: "UTC"})
+	if err != nil {
+		return nil, err
+	}
+	var filter *recordFilter
+	if *ef.filter != "" {
+		if filter, err = parseFilter(*ef.filter); err != nil {
+			return nil, err
+		}
+	}
+	timeRange, err := parseTimeRange(*ef.timeField, *ef.since, *ef.until, avroconvert.TimeFormatRFC3339)
+	if err != nil {
+		return nil, err
+	}
+	if timeRange != nil {
+		filter = timeRange.and(filter)
+	}
+	es := &eventSource{
+		opts:     decodeOptions{field: *ef.field, converter: converter, filter: filter, sampling: sampling{rate: 1}, onError: errorPolicy{mode: onErrorSkip}},
+		timePath: strings.Split(*ef.timeField, "."),
+	}
+	if ef.userField != nil {
+		es.userPath = strings.Split(*ef.userField, ".")
+	}
+	return es, nil
+}
+
+// read passes the events of every input to add, returning how many inputs
+// failed. Events without a user or a time are counted as skipped and left
+// out.
+func (es *eventSource) read(inputs []inputFile, add func(user string, t time.Time, event interface{})) (failed, skipped int) {
+	failed = es.each(inputs, func(event interface{}) {
+		user := lookupPath(event, es.userPath)
+		t, ok := eventTime(lookupPath(event, es.timePath), "")
+		if user == nil || !ok {
+			skipped++
+			return
+		}
+		add(countKey(user), t, event)
+	})
+	return failed, skipped
+}
+
+// each passes every event of the inputs to add, whatever fields it has,
+// returning how many inputs failed.
+func (es *eventSource) each(inputs []inputFile, add func(event interface{})) (failed int) {
+	sink := eventSink(add)
+	for _, in := range inputs {
+		input, err := openDecodeInput(in.path, es.opts)
+		if err == nil {
+			_, err = decodeMessages(input, in.path, es.opts, sink)
+			input.Close()
+		}
+		if err != nil {
+			slog.Error("Cannot read input", "input", displayPath(in.path), "error", err)
+			failed++
+		}
+	}
+	return failed
+}
+
+// eventSink passes each message, parsed, to a function.
+type eventSink func(event interface{})
+
+func (es eventSink) WriteRecord(msg json.RawMessage) error {
+	v, err := parseMessage(msg)
+	if err != nil {
+		return err
+	}
+	es(v)
+	return nil
+}
+
+func (es eventSink) Flush() error { return nil }
+
+func (es eventSink) Close() error { return nil }
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// arrayExploder turns each element of an array field into a message of its
+// own, a copy of the message with the element in place of the array. A
+// message whose field is missing, empty or not an array is kept as it is.
+type arrayExploder struct {
+	path []string
+}
+
+// parseExplode parses the -explode field path, returning nil when none is
+// given.
+func parseExplode(field string) (*arrayExploder, error) {
+	if field == "" {
+		return nil, nil
+	}
+	if strings.HasPrefix(field, ".") || strings.HasSuffix(field, ".") || strings.Contains(field, "..") {
+		return nil, fmt.Errorf("invalid -explode field %q", field)
+	}
+	return &arrayExploder{path: strings.Split(field, ".")}, nil
+}
+
+func (ae *arrayExploder) apply(msg json.RawMessage) ([]json.RawMessage, error) {
+	v, err := parseMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+	items, ok := lookupPath(v, ae.path).([]interface{})
+	if !ok || len(items) == 0 {
+		return []json.RawMessage{msg}, nil
+	}
+	outputs := make([]json.RawMessage, 0, len(items))
+	for _, item := range items {
+		text, err := json.Marshal(withPath(v, ae.path, item))
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, text)
+	}
+	return outputs, nil
+}
+
+// withPath returns a copy of the object v with the value at path replaced.
+// Only the objects on the path are copied.
+func withPath(v interface{}, path []string, value interface{}) interface{} {
+	m, _ := v.(map[string]interface{})
+	c := make(map[string]interface{}, len(m))
+	for k, field := range m {
+		c[k] = field
+	}
+	if len(path) == 1 {
+		c[path[0]] = value
+	} else {
+		c[path[0]] = withPath(m[path[0]], path[1:], value)
+	}
+	return c
+}
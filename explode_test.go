@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestArrayExploder(t *testing.T) {
+	ae, err := parseExplode("order.items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for msg, want := range map[string]string{
+		`{"id":1,"order":{"items":[{"sku":"a"},{"sku":"b"}],"total":3}}`: `{"id":1,"order":{"items":{"sku":"a"},"total":3}}|{"id":1,"order":{"items":{"sku":"b"},"total":3}}`,
+		// Messages without a non-empty array are kept as they are
+		`{"id":2,"order":{"items":[]}}`:  `{"id":2,"order":{"items":[]}}`,
+		`{"id":3,"order":{"items":"a"}}`: `{"id":3,"order":{"items":"a"}}`,
+		`{"id":4}`:                       `{"id":4}`,
+	} {
+		outputs, err := ae.apply(json.RawMessage(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]string, len(outputs))
+		for i, out := range outputs {
+			got[i] = string(out)
+		}
+		if strings.Join(got, "|") != want {
+			t.Errorf("exploded %s into %s, want %s", msg, strings.Join(got, "|"), want)
+		}
+	}
+
+	if ae, err := parseExplode(""); ae != nil || err != nil {
+		t.Fatalf("parsed no field as %+v, %v", ae, err)
+	}
+	if _, err := parseExplode("order..items"); err == nil {
+		t.Fatal("parsed an invalid path")
+	}
+}
+
+func TestMessageTransform(t *testing.T) {
+	opts := testOptions(t, "message")
+	if opts.messageTransform() != nil {
+		t.Fatal("transform without steps")
+	}
+	var err error
+	if opts.transform, err = parseTransform(`{items: [.items[] | .sku]}`); err != nil {
+		t.Fatal(err)
+	}
+	if opts.explode, err = parseExplode("items"); err != nil {
+		t.Fatal(err)
+	}
+	// -explode applies to what -transform returns
+	outputs, err := opts.messageTransform()(json.RawMessage(`{"items":[{"sku":"a"},{"sku":"b"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outputs) != 2 || string(outputs[0]) != `{"items":"a"}` || string(outputs[1]) != `{"items":"b"}` {
+		t.Fatalf("transformed into %s", outputs)
+	}
+}
@@ -0,0 +1,1078 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+
+	"avroparser/pkg/avroconvert"
+)
+
+// exploreAhead is how many records the explorer decodes past the furthest
+// one shown, so paging forward doesn't wait for the decoder while the rest
+// of a large file isn't held in memory.
+const exploreAhead = 1000
+
+// exploreBehind is how many records before the furthest one shown the
+// explorer keeps, so paging back doesn't read the input again.
+const exploreBehind = 1000
+
+// errExploreClosed stops the decoder when the explorer quits.
+var errExploreClosed = errors.New("explorer closed")
+
+// errInputChanged is returned when blocks are read again from an input
+// that was replaced since it was decoded.
+var errInputChanged = errors.New("the input has changed")
+
+// exploreView is the screen the explorer shows.
+type exploreView int
+
+const (
+	viewRecord exploreView = iota
+	viewSchema
+	viewHelp
+)
+
+// exploreHelp is the help screen's text.
+var exploreHelp = []string{
+	"Records",
+	"  ←/→ h/l        previous/next record",
+	"  ↑/↓ j/k        scroll one line",
+	"  space b        scroll one page (also PgDn PgUp)",
+	"  g G            first/last record (also Home End)",
+	"  :              go to a record by number",
+	"  /              search: text to find, or a -filter expression such as score >= 100",
+	"  n N            next/previous record matching the search",
+	"  x              select or unselect the record",
+	"  w              write the selected records, or the record shown, to an NDJSON file",
+	"  s tab          schema view",
+	"",
+	"Schema",
+	"  ↑/↓ space b    scroll",
+	"  /  n N         search field names",
+	"  s tab esc      back to the records",
+	"",
+	"  esc            cancel a prompt or stop a long search",
+	"  q              quit",
+	"",
+	"Press any key to return.",
+}
+
+func runExplore(args []string) {
+	fs := flag.NewFlagSet("explore", flag.ExitOnError)
+	inputPath := fs.String("input", "", "Input Avro or NDJSON file (not stdin, which is the keyboard)")
+	records := addRecordFlags(fs)
+	logging := addLogFlags(fs)
+	parseFlags(fs, args)
+	logging.setup()
+
+	if *inputPath == "" && fs.NArg() > 0 {
+		*inputPath = fs.Arg(0)
+	}
+	if *inputPath == "" {
+		fmt.Fprintln(os.Stderr, "Usage: avroparser explore [-field <field>] [-filter <expr>] <avro_file>")
+		os.Exit(exitFatal)
+	}
+	if *inputPath == stdioPath {
+		fmt.Fprintln(os.Stderr, "explore reads keys from stdin, so it cannot read records from it; give it a file")
+		os.Exit(exitFatal)
+	}
+	if *records.onError == onErrorCollect {
+		fmt.Fprintln(os.Stderr, "explore cannot collect failed records; use -on-error skip or fail")
+		os.Exit(exitFatal)
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		fmt.Fprintln(os.Stderr, "explore needs a terminal; use decode to convert records in scripts")
+		os.Exit(exitFatal)
+	}
+	opts := mustRecordOptions(records)
+
+	if err := explore(*inputPath, opts); err != nil {
+		slog.Error("Cannot explore input", "input", *inputPath, "error", err)
+		os.Exit(exitFatal)
+	}
+}
+
+// explore runs the explorer on the terminal until it quits.
+func explore(path string, opts decodeOptions) error {
+	e := &explorer{name: path, selected: make(map[int]bool), out: bufio.NewWriter(os.Stdout)}
+	if spec, err := readWriterSchema(path); err != nil {
+		e.schema = []string{"No writer schema: " + err.Error()}
+	} else if schema, err := avroconvert.ParseSchema(string(spec)); err != nil {
+		e.schema = []string{"Cannot parse writer schema: " + err.Error()}
+	} else {
+		e.schema = schemaTree(schema)
+	}
+
+	// Warnings would scroll the screen; the last one is shown on the status
+	// line instead
+	e.logs = &lastLog{}
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(e.logs, &slog.HandlerOptions{Level: slog.LevelWarn})))
+
+	e.records = newRecordLoader(path, opts)
+	defer e.records.close()
+
+	fd := int(os.Stdin.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(fd, state)
+	keys := make(chan string, 16)
+	go readKeys(os.Stdin, keys)
+	e.keys = keys
+
+	// The alternate screen leaves the shell's screen as it was on exit
+	e.out.WriteString("\x1b[?1049h\x1b[?25l")
+	defer func() {
+		e.out.WriteString("\x1b[?25h\x1b[?1049l")
+		e.out.Flush()
+	}()
+	e.refresh()
+	e.show(0)
+	e.run()
+	return nil
+}
+
+// explorer is the state of the explore terminal UI.
+type explorer struct {
+	name    string
+	records *recordLoader
+	schema  []string // the writer schema tree, one field per line
+	logs    *lastLog
+	keys    <-chan string
+	out     *bufio.Writer
+
+	width, height int
+	loaded        int // records decoded so far
+	loadDone      bool
+
+	view         exploreView
+	index        int      // of the record shown
+	lines        []string // of the record shown, as indented JSON; nil without records
+	scroll       int      // first line shown of the record
+	schemaScroll int
+	selected     map[int]bool
+
+	search       string        // text searched for in records
+	filter       *recordFilter // the search, when it is a filter expression
+	schemaSearch string        // text searched for in field names
+
+	prompt  string // label of the line being edited, empty when none
+	input   []rune
+	onInput func(text string)
+	status  string
+}
+
+// run redraws the screen and handles keys until the explorer quits. While
+// records load, the screen is redrawn as their count changes; the terminal
+// size is also checked then, as resizing doesn't send a key.
+func (e *explorer) run() {
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+	redraw := true
+	for {
+		if redraw {
+			e.draw()
+		}
+		select {
+		case key, ok := <-e.keys:
+			if !ok || e.handle(key) {
+				return
+			}
+			redraw = true
+		case <-ticker.C:
+			redraw = e.refresh()
+		}
+	}
+}
+
+// refresh updates the terminal size, the decoding progress and the status
+// line's log message, and reports whether any of them changed.
+func (e *explorer) refresh() bool {
+	changed := false
+	if width, height, err := term.GetSize(int(os.Stdout.Fd())); err == nil && (width != e.width || height != e.height) {
+		e.width, e.height = width, height
+		changed = true
+	}
+	loaded, done, err := e.records.progress()
+	if loaded != e.loaded || done != e.loadDone {
+		e.loaded, e.loadDone = loaded, done
+		if err != nil {
+			e.status = "Decoding stopped: " + err.Error()
+		}
+		changed = true
+	}
+	if line := e.logs.take(); line != "" {
+		e.status = line
+		changed = true
+	}
+	return changed
+}
+
+// bodyHeight is the number of lines between the title and the status line.
+func (e *explorer) bodyHeight() int {
+	return max(e.height-2, 1)
+}
+
+// handle acts on a key press and reports whether the explorer quits.
+func (e *explorer) handle(key string) bool {
+	if e.prompt != "" {
+		e.edit(key)
+		return false
+	}
+	e.status = ""
+	if key == "q" || key == "ctrl-c" {
+		return true
+	}
+	switch e.view {
+	case viewHelp:
+		e.view = viewRecord
+	case viewSchema:
+		e.handleSchema(key)
+	default:
+		e.handleRecord(key)
+	}
+	return false
+}
+
+func (e *explorer) handleRecord(key string) {
+	page := e.bodyHeight()
+	switch key {
+	case "down", "j", "enter":
+		e.scrollTo(e.scroll + 1)
+	case "up", "k":
+		e.scrollTo(e.scroll - 1)
+	case " ", "pgdn", "f":
+		e.scrollTo(e.scroll + page)
+	case "b", "pgup":
+		e.scrollTo(e.scroll - page)
+	case "right", "l":
+		if !e.show(e.index + 1) {
+			e.status = "Last record"
+		}
+	case "left", "h":
+		if !e.show(e.index - 1) {
+			e.status = "First record"
+		}
+	case "home", "g":
+		e.show(0)
+	case "end", "G":
+		e.last()
+	case ":":
+		e.ask("Go to record: ", func(text string) {
+			n, err := strconv.Atoi(strings.TrimSpace(text))
+			if err != nil || n < 1 {
+				e.status = fmt.Sprintf("Not a record number: %q", text)
+			} else if !e.show(n - 1) {
+				e.status = fmt.Sprintf("There are only %s records", formatCount(int64(e.loaded)))
+			}
+		})
+	case "/":
+		e.ask("Search: ", func(text string) {
+			if text != "" && !e.setSearch(text) {
+				return
+			}
+			e.findRecord(1)
+		})
+	case "n":
+		e.findRecord(1)
+	case "N":
+		e.findRecord(-1)
+	case "x":
+		if e.lines != nil {
+			if e.selected[e.index] {
+				delete(e.selected, e.index)
+			} else {
+				e.selected[e.index] = true
+			}
+		}
+	case "w":
+		label := "Write record to: "
+		if len(e.selected) > 0 {
+			label = fmt.Sprintf("Write %s selected records to: ", formatCount(int64(len(e.selected))))
+		}
+		e.ask(label, e.export)
+	case "s", "tab":
+		e.view = viewSchema
+	case "?":
+		e.view = viewHelp
+	}
+}
+
+func (e *explorer) handleSchema(key string) {
+	page := e.bodyHeight()
+	switch key {
+	case "down", "j", "enter":
+		e.schemaScroll++
+	case "up", "k":
+		e.schemaScroll--
+	case " ", "pgdn", "f":
+		e.schemaScroll += page
+	case "b", "pgup":
+		e.schemaScroll -= page
+	case "home", "g":
+		e.schemaScroll = 0
+	case "end", "G":
+		e.schemaScroll = len(e.schema)
+	case "/":
+		e.ask("Search fields: ", func(text string) {
+			if text != "" {
+				e.schemaSearch = text
+			}
+			e.findField(1)
+		})
+	case "n":
+		e.findField(1)
+	case "N":
+		e.findField(-1)
+	case "s", "tab", "esc":
+		e.view = viewRecord
+	case "?":
+		e.view = viewHelp
+	}
+	e.schemaScroll = min(max(e.schemaScroll, 0), max(len(e.schema)-page, 0))
+}
+
+// ask shows a prompt on the status line and calls onInput with the text
+// entered, unless the prompt is cancelled.
+func (e *explorer) ask(label string, onInput func(text string)) {
+	e.prompt, e.input, e.onInput = label, nil, onInput
+}
+
+// edit handles a key while a prompt is shown.
+func (e *explorer) edit(key string) {
+	switch key {
+	case "enter":
+		text, onInput := string(e.input), e.onInput
+		e.prompt, e.input, e.onInput = "", nil, nil
+		onInput(text)
+	case "esc", "ctrl-c":
+		e.prompt, e.input, e.onInput = "", nil, nil
+	case "backspace":
+		if len(e.input) > 0 {
+			e.input = e.input[:len(e.input)-1]
+		}
+	default:
+		if utf8.RuneCountInString(key) == 1 {
+			e.input = append(e.input, []rune(key)...)
+		}
+	}
+}
+
+// show makes record i the one shown, and reports whether the input has it.
+func (e *explorer) show(i int) bool {
+	if i < 0 {
+		return false
+	}
+	msg, ok := e.records.get(i)
+	if !ok {
+		return false
+	}
+	e.index, e.scroll = i, 0
+	e.lines = indentLines(msg)
+	return true
+}
+
+// last shows the last record, decoding the rest of the input to find it.
+func (e *explorer) last() {
+	e.notify("Reading to the end… (esc to stop)")
+	i := e.index
+	for ; ; i++ {
+		if i%exploreAhead == 0 && e.interrupted() {
+			break
+		}
+		if _, ok := e.records.get(i + 1); !ok {
+			break
+		}
+	}
+	e.show(i)
+}
+
+// setSearch sets the text to search records for. Text with an operator of
+// the filter language is a filter expression, matching whole records;
+// other text is found in the records' JSON.
+func (e *explorer) setSearch(text string) bool {
+	e.search, e.filter = text, nil
+	for _, op := range []string{"==", "!=", "<", ">", "&&", "||"} {
+		if strings.Contains(text, op) {
+			filter, err := parseFilter(text)
+			if err != nil {
+				e.search = ""
+				e.status = err.Error()
+				return false
+			}
+			e.filter = filter
+			break
+		}
+	}
+	return true
+}
+
+// matches reports whether a record matches the search.
+func (e *explorer) matches(msg json.RawMessage) bool {
+	if e.filter == nil {
+		return bytes.Contains(msg, []byte(e.search)) || containsLine(indentLines(msg), e.search)
+	}
+	v, err := parseMessage(msg)
+	return err == nil && e.filter.match(v)
+}
+
+// findRecord shows the next record matching the search, looking forward
+// with step 1 and backward with step -1.
+func (e *explorer) findRecord(step int) {
+	if e.search == "" {
+		e.status = "Nothing to search for; press / to search"
+		return
+	}
+	e.notify("Searching… (esc to stop)")
+	for i, n := e.index+step, 0; i >= 0; i, n = i+step, n+1 {
+		if n%exploreAhead == 0 && e.interrupted() {
+			e.status = "Search stopped"
+			return
+		}
+		msg, ok := e.records.get(i)
+		if !ok {
+			break
+		}
+		if e.matches(msg) {
+			e.show(i)
+			// Scroll to the first line with the text found
+			for j, line := range e.lines {
+				if e.filter == nil && strings.Contains(line, e.search) {
+					e.scrollTo(j)
+					break
+				}
+			}
+			return
+		}
+	}
+	e.status = "Not found: " + e.search
+}
+
+// findField scrolls the schema view to the next line with the field
+// search, looking forward with step 1 and backward with step -1.
+func (e *explorer) findField(step int) {
+	if e.schemaSearch == "" {
+		e.status = "Nothing to search for; press / to search"
+		return
+	}
+	for i := e.schemaScroll + step; i >= 0 && i < len(e.schema); i += step {
+		if strings.Contains(e.schema[i], e.schemaSearch) {
+			e.schemaScroll = i
+			return
+		}
+	}
+	e.status = "Not found: " + e.schemaSearch
+}
+
+// export writes the selected records, or the record shown, to path as
+// NDJSON, in the order of the input.
+func (e *explorer) export(path string) {
+	path = strings.TrimSpace(path)
+	if path == "" || path == stdioPath {
+		e.status = "Give a file to write the records to"
+		return
+	}
+	indexes := make([]int, 0, len(e.selected))
+	for i := range e.selected {
+		indexes = append(indexes, i)
+	}
+	if len(indexes) == 0 {
+		if e.lines == nil {
+			e.status = "No record to write"
+			return
+		}
+		indexes = append(indexes, e.index)
+	}
+	sort.Ints(indexes)
+	if err := e.writeRecords(path, indexes); err != nil {
+		e.status = "Cannot write records: " + err.Error()
+		return
+	}
+	e.status = fmt.Sprintf("Wrote %s records to %s", formatCount(int64(len(indexes))), path)
+}
+
+func (e *explorer) writeRecords(path string, indexes []int) error {
+	out, err := openOutput(path)
+	if err != nil {
+		return err
+	}
+	sink := avroconvert.NewNDJSONSink(out)
+	for _, i := range indexes {
+		msg, _ := e.records.get(i)
+		if err := sink.WriteRecord(msg); err != nil {
+			abortOutput(out)
+			return err
+		}
+	}
+	if err := sink.Close(); err != nil {
+		abortOutput(out)
+		return err
+	}
+	return out.Close()
+}
+
+// notify shows a status message right away, before a long operation.
+func (e *explorer) notify(message string) {
+	e.status = message
+	e.draw()
+	e.status = ""
+}
+
+// interrupted reports whether esc or ctrl-c was pressed, to stop a long
+// operation. Other keys pressed meanwhile are dropped.
+func (e *explorer) interrupted() bool {
+	for {
+		select {
+		case key := <-e.keys:
+			if key == "esc" || key == "ctrl-c" {
+				return true
+			}
+		default:
+			return false
+		}
+	}
+}
+
+func (e *explorer) scrollTo(line int) {
+	e.scroll = min(max(line, 0), max(len(e.lines)-e.bodyHeight(), 0))
+}
+
+// draw writes the whole screen: a title line, the record or schema, and a
+// status line.
+func (e *explorer) draw() {
+	e.out.WriteString("\x1b[H")
+
+	count := formatCount(int64(e.loaded))
+	if !e.loadDone {
+		count += "+"
+	}
+	title := fmt.Sprintf(" %s  record %s of %s", e.name, formatCount(int64(e.index+1)), count)
+	if e.lines == nil {
+		title = fmt.Sprintf(" %s  no records", e.name)
+	}
+	if e.selected[e.index] {
+		title += "  [selected]"
+	}
+	if len(e.selected) > 0 {
+		title += fmt.Sprintf("  %s selected", formatCount(int64(len(e.selected))))
+	}
+	switch e.view {
+	case viewSchema:
+		title = fmt.Sprintf(" %s  writer schema", e.name)
+	case viewHelp:
+		title = " Help"
+	}
+	e.writeLine(title, "", true)
+
+	lines, scroll, highlight := e.lines, e.scroll, e.search
+	switch e.view {
+	case viewSchema:
+		lines, scroll, highlight = e.schema, e.schemaScroll, e.schemaSearch
+	case viewHelp:
+		lines, scroll, highlight = exploreHelp, 0, ""
+	}
+	if e.filter != nil {
+		highlight = ""
+	}
+	for i := 0; i < e.bodyHeight(); i++ {
+		line := "~"
+		if scroll+i < len(lines) {
+			line = lines[scroll+i]
+		}
+		e.writeLine(line, highlight, false)
+	}
+
+	status := e.status
+	switch {
+	case e.prompt != "":
+		status = e.prompt + string(e.input)
+	case status == "" && e.view == viewRecord:
+		status = "←/→ record  ↑/↓ scroll  / search  x select  w write  s schema  ? help  q quit"
+	case status == "" && e.view == viewSchema:
+		status = "↑/↓ scroll  / search  s records  q quit"
+	}
+	// The last line has no line break, which would scroll the screen
+	e.out.WriteString(truncateRunes(status, max(e.width-1, 0)) + "\x1b[K")
+	if e.prompt != "" {
+		e.out.WriteString("\x1b[?25h")
+	} else {
+		e.out.WriteString("\x1b[?25l")
+	}
+	e.out.Flush()
+}
+
+// writeLine writes a line of the screen, cut to the terminal's width, with
+// the text highlighted in it shown in reverse video, or the whole line for
+// the title.
+func (e *explorer) writeLine(line, highlight string, title bool) {
+	if utf8.RuneCountInString(line) > e.width {
+		line = truncateRunes(line, max(e.width-1, 0)) + "…"
+	}
+	switch {
+	case title:
+		line = "\x1b[7m" + line + strings.Repeat(" ", max(e.width-utf8.RuneCountInString(line), 0)) + "\x1b[27m"
+	case highlight != "":
+		line = strings.ReplaceAll(line, highlight, "\x1b[7m"+highlight+"\x1b[27m")
+	}
+	e.out.WriteString(line + "\x1b[K\r\n")
+}
+
+// indentLines returns the lines of a record as indented JSON.
+func indentLines(msg json.RawMessage) []string {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, msg, "", "  "); err != nil {
+		return strings.Split(string(msg), "\n")
+	}
+	return strings.Split(buf.String(), "\n")
+}
+
+// containsLine reports whether any of lines contains text, for searches
+// that match the indented form of a record, e.g. "score": 100.
+func containsLine(lines []string, text string) bool {
+	for _, line := range lines {
+		if strings.Contains(line, text) {
+			return true
+		}
+	}
+	return false
+}
+
+// readKeys reads key presses from the terminal, in raw mode, and sends
+// their names until reading fails: the character typed, or enter, esc,
+// backspace, tab, ctrl-c, up, down, left, right, home, end, pgup and pgdn.
+func readKeys(r *os.File, keys chan<- string) {
+	defer close(keys)
+	buf := make([]byte, 256)
+	for {
+		n, err := r.Read(buf)
+		if err != nil {
+			return
+		}
+		for b := buf[:n]; len(b) > 0; {
+			key, size := parseKey(b)
+			if key != "" {
+				keys <- key
+			}
+			b = b[size:]
+		}
+	}
+}
+
+// escapeKeys names the escape sequences of special keys, without the ESC.
+var escapeKeys = map[string]string{
+	"[A": "up", "[B": "down", "[C": "right", "[D": "left",
+	"[H": "home", "[F": "end", "OH": "home", "OF": "end",
+	"[1~": "home", "[7~": "home", "[4~": "end", "[8~": "end",
+	"[5~": "pgup", "[6~": "pgdn",
+}
+
+// parseKey returns the name of the key at the start of b, empty for keys
+// the explorer doesn't use, and the number of bytes it takes.
+func parseKey(b []byte) (string, int) {
+	switch b[0] {
+	case 0x1b:
+		if len(b) > 2 && (b[1] == '[' || b[1] == 'O') {
+			// Sequences end with a letter or ~
+			for i := 2; i < len(b); i++ {
+				if b[i] >= 0x40 && b[i] <= 0x7e {
+					return escapeKeys[string(b[1:i+1])], i + 1
+				}
+			}
+		}
+		return "esc", 1
+	case '\r', '\n':
+		return "enter", 1
+	case 0x7f, 0x08:
+		return "backspace", 1
+	case '\t':
+		return "tab", 1
+	case 0x03:
+		return "ctrl-c", 1
+	}
+	r, size := utf8.DecodeRune(b)
+	if r < 0x20 || r == utf8.RuneError {
+		return "", size
+	}
+	return string(r), size
+}
+
+// recordLoader decodes an input in the background for the explorer. It
+// stays exploreAhead records ahead of the furthest one asked for, and keeps
+// only the exploreBehind records before that one, so the memory used
+// doesn't grow with the input. Records asked for again after they were
+// dropped are read again: of a container file, only the blocks holding
+// them, found by the byte ranges noted while decoding; of other input, from
+// the start.
+type recordLoader struct {
+	path string
+	opts decodeOptions
+
+	mu      sync.Mutex
+	changed *sync.Cond
+	records []json.RawMessage // decoded records from first on
+	first   int
+	loaded  int // records decoded so far
+	want    int // furthest record asked for
+	done    bool
+	err     error
+	closed  bool
+
+	// Where the records are in a container file, when its blocks can be
+	// read again on their own; headerSize is 0 otherwise
+	headerSize int64
+	sync       [16]byte
+	blocks     []loadedBlock
+
+	// Records read again, from backFirst on
+	back      []json.RawMessage
+	backFirst int
+}
+
+// loadedBlock is a block of a container file that records came from.
+type loadedBlock struct {
+	start, end int64 // byte range in the file
+	first      int   // its first record
+}
+
+func newRecordLoader(path string, opts decodeOptions) *recordLoader {
+	l := &recordLoader{path: path, opts: opts}
+	l.changed = sync.NewCond(&l.mu)
+	go func() {
+		err := l.decode()
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.done = true
+		if !l.closed {
+			l.err = err
+		}
+		l.changed.Broadcast()
+	}()
+	return l
+}
+
+// decode decodes the input as decodeMessages does, noting the block each
+// record comes from.
+func (l *recordLoader) decode() error {
+	input, err := openDecodeInput(l.path, l.opts)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+	records, schema, err := openRecords(input, l.opts.raw, l.opts.blockWorkers, l.opts.sampling.offset)
+	if err != nil {
+		return err
+	}
+	blocks, _ := records.(*ocfRecordReader)
+	if blocks != nil {
+		defer blocks.Close()
+		// Which records a block yields mustn't depend on those before it
+		if s := l.opts.sampling; s.offset != 0 || s.count != 0 || s.skip != 0 || s.limit != 0 || s.rate > 0 && s.rate < 1 {
+			blocks = nil
+		} else {
+			l.sync = blocks.blocks.header.sync
+		}
+	}
+	_, err = decodeRecords(windowRecords(records, 0, l.opts.sampling.count), schema, l.path, l.opts, messageSink(func(msg json.RawMessage) error {
+		return l.add(msg, blocks)
+	}))
+	return err
+}
+
+// add keeps a decoded record, waiting while the decoder is exploreAhead
+// records ahead of the explorer, and drops those too far behind.
+func (l *recordLoader) add(msg json.RawMessage, blocks *ocfRecordReader) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.loaded > l.want+exploreAhead && !l.closed {
+		l.changed.Wait()
+	}
+	if l.closed {
+		return errExploreClosed
+	}
+	if blocks != nil {
+		start, end := blocks.block()
+		if n := len(l.blocks); n == 0 || l.blocks[n-1].start != start {
+			l.blocks = append(l.blocks, loadedBlock{start: start, end: end, first: l.loaded})
+		}
+		l.headerSize = blocks.blocks.dataStart
+	}
+	l.records = append(l.records, append(json.RawMessage(nil), msg...))
+	l.loaded++
+	if drop := l.want - exploreBehind - l.first; drop > 0 {
+		drop = min(drop, len(l.records))
+		clear(l.records[:drop])
+		l.records = l.records[drop:]
+		l.first += drop
+	}
+	l.changed.Broadcast()
+	return nil
+}
+
+// get returns record i, waiting until it is decoded, or false if the input
+// has fewer records.
+func (l *recordLoader) get(i int) (json.RawMessage, bool) {
+	l.mu.Lock()
+	if i > l.want {
+		l.want = i
+		l.changed.Broadcast()
+	}
+	for i >= l.loaded && !l.done {
+		l.changed.Wait()
+	}
+	switch {
+	case i >= l.loaded:
+		l.mu.Unlock()
+		return nil, false
+	case i >= l.first:
+		msg := l.records[i-l.first]
+		l.mu.Unlock()
+		return msg, true
+	case i >= l.backFirst && i < l.backFirst+len(l.back):
+		msg := l.back[i-l.backFirst]
+		l.mu.Unlock()
+		return msg, true
+	}
+	// The blocks noted so far don't change, and records before first are
+	// never decoded again by the decoder
+	blocks, first, blockwise := l.blocks, l.first, l.headerSize > 0
+	l.mu.Unlock()
+
+	lo, hi := max(i-exploreBehind, 0), min(i+exploreAhead, first)
+	var back []json.RawMessage
+	var err error
+	if blockwise {
+		back, err = l.rereadBlocks(blocks, lo, hi)
+	}
+	if !blockwise || err != nil {
+		back, err = l.reread(lo, hi)
+	}
+	if err != nil {
+		slog.Warn("Cannot read records again", "record", i+1, "error", err)
+		return nil, false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.back, l.backFirst = back, lo
+	if i-lo >= len(back) {
+		return nil, false
+	}
+	return back[i-lo], true
+}
+
+// reread decodes the input again from the start, returning records lo to
+// hi.
+func (l *recordLoader) reread(lo, hi int) ([]json.RawMessage, error) {
+	input, err := openDecodeInput(l.path, l.opts)
+	if err != nil {
+		return nil, err
+	}
+	defer input.Close()
+	return l.collect(input, 0, lo, hi)
+}
+
+// rereadBlocks decodes again the blocks holding records lo to hi, checking
+// first that the input's header is still the one decoded.
+func (l *recordLoader) rereadBlocks(blocks []loadedBlock, lo, hi int) ([]json.RawMessage, error) {
+	from := sort.Search(len(blocks), func(k int) bool { return blocks[k].first > lo }) - 1
+	to := sort.Search(len(blocks), func(k int) bool { return blocks[k].first >= hi }) - 1
+	if from < 0 || to < from {
+		return nil, errors.New("no block holds the records")
+	}
+
+	open, closeInput, err := openRanges(l.path)
+	if err != nil {
+		return nil, err
+	}
+	defer closeInput()
+	r, err := open(0, l.headerSize)
+	if err != nil {
+		return nil, err
+	}
+	header, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := readOCFHeader(bufio.NewReader(bytes.NewReader(header)))
+	if err != nil || parsed.sync != l.sync {
+		return nil, errInputChanged
+	}
+	r, err = open(blocks[from].start, blocks[to].end-blocks[from].start)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return l.collect(io.MultiReader(bytes.NewReader(header), r), blocks[from].first, lo, hi)
+}
+
+// collect decodes the records of an input, numbering them from first, and
+// returns records lo to hi.
+func (l *recordLoader) collect(r io.Reader, first, lo, hi int) ([]json.RawMessage, error) {
+	// Warnings and failed records were reported by the first pass
+	opts := l.opts
+	opts.quiet = true
+	var records []json.RawMessage
+	i := first
+	_, err := decodeMessages(r, l.path, opts, messageSink(func(msg json.RawMessage) error {
+		if i >= hi {
+			return errExploreClosed
+		}
+		if i >= lo {
+			records = append(records, append(json.RawMessage(nil), msg...))
+		}
+		i++
+		return nil
+	}))
+	if err != nil && !errors.Is(err, errExploreClosed) {
+		return nil, err
+	}
+	return records, nil
+}
+
+// progress returns the number of records decoded so far, whether they are
+// all of the input's, and the error that stopped decoding, if any.
+func (l *recordLoader) progress() (int, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.loaded, l.done, l.err
+}
+
+// close stops decoding.
+func (l *recordLoader) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	l.changed.Broadcast()
+}
+
+// lastLog keeps the last line logged while the explorer has the screen.
+type lastLog struct {
+	mu   sync.Mutex
+	line string
+}
+
+func (l *lastLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.line = strings.TrimSpace(string(p))
+	return len(p), nil
+}
+
+// take returns the line logged since the last call, if any.
+func (l *lastLog) take() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	line := l.line
+	l.line = ""
+	return line
+}
+
+// schemaTree renders a schema as indented lines, one per field with its
+// type, for the explorer's schema view.
+func schemaTree(schema *avroconvert.Schema) []string {
+	lines := []string{schemaTypeName(schema)}
+	addSchemaFields(&lines, schema, "  ", make(map[string]bool))
+	return lines
+}
+
+// addSchemaFields adds the fields nested in a value of the schema: those of
+// a record, or of the records in arrays, maps and union branches. Records
+// already being listed are not repeated, so recursive types end.
+func addSchemaFields(lines *[]string, s *avroconvert.Schema, indent string, listing map[string]bool) {
+	switch s.Kind {
+	case "record":
+		if listing[s.Name] {
+			return
+		}
+		listing[s.Name] = true
+		defer delete(listing, s.Name)
+		for _, f := range s.Fields {
+			*lines = append(*lines, indent+f.Name+": "+schemaTypeName(f.Schema))
+			addSchemaFields(lines, f.Schema, indent+"  ", listing)
+		}
+	case "array":
+		addSchemaFields(lines, s.Items, indent, listing)
+	case "map":
+		addSchemaFields(lines, s.Values, indent, listing)
+	case "union":
+		nonNull := 0
+		for _, b := range s.Branches {
+			if b.Kind != "null" {
+				nonNull++
+			}
+		}
+		for _, b := range s.Branches {
+			// With several branches, each one's fields are under its name
+			if nonNull > 1 && hasNestedFields(b) {
+				*lines = append(*lines, indent+"| "+schemaTypeName(b))
+				addSchemaFields(lines, b, indent+"  ", listing)
+			} else {
+				addSchemaFields(lines, b, indent, listing)
+			}
+		}
+	}
+}
+
+// hasNestedFields reports whether values of the schema hold records.
+func hasNestedFields(s *avroconvert.Schema) bool {
+	switch s.Kind {
+	case "record":
+		return true
+	case "array":
+		return hasNestedFields(s.Items)
+	case "map":
+		return hasNestedFields(s.Values)
+	}
+	return false
+}
+
+// schemaTypeName describes a type in one line, e.g. "null | string",
+// "array<record game.Param>" or "long (timestamp-micros)".
+func schemaTypeName(s *avroconvert.Schema) string {
+	var name string
+	switch s.Kind {
+	case "record":
+		name = "record " + s.Name
+	case "enum":
+		name = "enum " + s.Name + " {" + strings.Join(s.Symbols, ", ") + "}"
+	case "fixed":
+		name = fmt.Sprintf("fixed %s[%d]", s.Name, s.Size)
+	case "array":
+		name = "array<" + schemaTypeName(s.Items) + ">"
+	case "map":
+		name = "map<" + schemaTypeName(s.Values) + ">"
+	case "union":
+		branches := make([]string, len(s.Branches))
+		for i, b := range s.Branches {
+			branches[i] = schemaTypeName(b)
+		}
+		name = strings.Join(branches, " | ")
+	default:
+		name = s.Kind
+	}
+	switch {
+	case s.LogicalType == "decimal":
+		name += fmt.Sprintf(" (decimal(%d, %d))", s.Precision, s.Scale)
+	case s.LogicalType != "":
+		name += " (" + s.LogicalType + ")"
+	}
+	return name
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"avroparser/pkg/avroconvert"
+)
+
+func TestParseKey(t *testing.T) {
+	tests := []struct {
+		in   string
+		key  string
+		size int
+	}{
+		{"q", "q", 1},
+		{"é!", "é", 2},
+		{"\x1b[A", "up", 3},
+		{"\x1b[6~x", "pgdn", 4},
+		{"\x1bOH", "home", 3},
+		{"\x1b", "esc", 1},
+		{"\x1b[15~", "", 5}, // F5
+		{"\r", "enter", 1},
+		{"\x7f", "backspace", 1},
+		{"\x03", "ctrl-c", 1},
+		{"\x01", "", 1},
+	}
+	for _, tt := range tests {
+		if key, size := parseKey([]byte(tt.in)); key != tt.key || size != tt.size {
+			t.Errorf("parseKey(%q) = %q, %d, want %q, %d", tt.in, key, size, tt.key, tt.size)
+		}
+	}
+}
+
+func TestSchemaTree(t *testing.T) {
+	schema, err := avroconvert.ParseSchema(`{"type":"record","name":"Event","fields":[
+	  {"name":"at","type":{"type":"long","logicalType":"timestamp-micros"}},
+	  {"name":"params","type":{"type":"array","items":{"type":"record","name":"Param","fields":[{"name":"key","type":"string"}]}}},
+	  {"name":"next","type":["null","Event"]},
+	  {"name":"value","type":["null",{"type":"record","name":"A","fields":[{"name":"a","type":"int"}]},{"type":"record","name":"B","fields":[{"name":"b","type":"int"}]}]}
+	]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"record Event",
+		"  at: long (timestamp-micros)",
+		"  params: array<record Param>",
+		"    key: string",
+		"  next: null | record Event",
+		"  value: null | record A | record B",
+		"    | record A",
+		"      a: int",
+		"    | record B",
+		"      b: int",
+	}
+	if got := schemaTree(schema); !reflect.DeepEqual(got, want) {
+		t.Fatalf("schema tree\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// newTestExplorer explores records written to a test file, drawing to a
+// discarded screen.
+func newTestExplorer(t *testing.T, msgs ...string) *explorer {
+	t.Helper()
+	path := writeTestFile(t, "events.avro", writeMessageOCF(t, msgs...))
+	records := newRecordLoader(path, testOptions(t, "message"))
+	t.Cleanup(records.close)
+	e := &explorer{name: "events.avro", records: records, logs: &lastLog{}, keys: make(chan string), out: bufio.NewWriter(io.Discard), width: 80, height: 10, selected: make(map[int]bool)}
+	e.show(0)
+	return e
+}
+
+func (e *explorer) press(keys ...string) {
+	for _, key := range keys {
+		e.handle(key)
+	}
+}
+
+func TestExplorerNavigate(t *testing.T) {
+	var msgs []string
+	for i := 1; i <= 5; i++ {
+		msgs = append(msgs, fmt.Sprintf(`{"id":%d,"kind":"k%d"}`, i, i%2))
+	}
+	e := newTestExplorer(t, msgs...)
+	e.press("right", "right")
+	if e.index != 2 {
+		t.Fatalf("at record %d after two rights", e.index)
+	}
+	e.press("G")
+	if e.index != 4 {
+		t.Fatalf("at record %d after G", e.index)
+	}
+	e.press("right")
+	if e.status != "Last record" {
+		t.Errorf("status %q past the last record", e.status)
+	}
+	e.press(":", "2", "enter")
+	if e.index != 1 {
+		t.Errorf("at record %d after going to 2", e.index)
+	}
+	e.refresh()
+	e.press(":", "9", "enter")
+	if e.index != 1 || !strings.Contains(e.status, "only 5 records") {
+		t.Errorf("going to 9: record %d, status %q", e.index, e.status)
+	}
+
+	// A filter expression searches whole records, other text their JSON
+	e.press("/", "i", "d", " ", "=", "=", " ", "4", "enter")
+	if e.index != 3 || e.filter == nil {
+		t.Errorf("filter search at record %d", e.index)
+	}
+	e.press("/")
+	for _, r := range `"kind": "k1"` {
+		e.press(string(r))
+	}
+	e.press("enter")
+	if e.index != 4 {
+		t.Errorf("text search at record %d", e.index)
+	}
+	e.press("N")
+	if e.index != 2 {
+		t.Errorf("searching back at record %d", e.index)
+	}
+	e.press("/", "n", "o", "p", "e", "enter")
+	if e.index != 2 || e.status != "Not found: nope" {
+		t.Errorf("missing text: record %d, status %q", e.index, e.status)
+	}
+
+	e.press("/", "x", "esc")
+	if e.prompt != "" || e.search != "nope" {
+		t.Errorf("cancelling left prompt %q, search %q", e.prompt, e.search)
+	}
+	if e.handle("q") != true {
+		t.Error("q did not quit")
+	}
+}
+
+func TestExplorerExport(t *testing.T) {
+	e := newTestExplorer(t, `{"id":1}`, `{"id":2}`, `{"id":3}`)
+	path := filepath.Join(t.TempDir(), "picked.ndjson")
+	e.press("right", "right", "x", "left", "left", "x", "w")
+	for _, r := range path {
+		e.press(string(r))
+	}
+	e.press("enter")
+	if e.status != "Wrote 2 records to "+path {
+		t.Fatalf("status %q", e.status)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "{\"id\":1}\n{\"id\":3}\n" {
+		t.Fatalf("wrote %q", data)
+	}
+
+	// The screen shows the selection
+	var screen strings.Builder
+	e.out = bufio.NewWriter(&screen)
+	e.press("G", "g")
+	e.refresh()
+	e.draw()
+	if !strings.Contains(screen.String(), "record 1 of 3  [selected]  2 selected") {
+		t.Errorf("title not on the screen:\n%q", screen.String())
+	}
+}
+
+func TestRecordLoader(t *testing.T) {
+	var msgs []string
+	for i := 0; i < exploreAhead*3; i++ {
+		msgs = append(msgs, fmt.Sprintf(`{"id":%d}`, i))
+	}
+	path := writeTestFile(t, "events.avro", writeMessageOCF(t, msgs...))
+	l := newRecordLoader(path, testOptions(t, "message"))
+	defer l.close()
+
+	if msg, ok := l.get(0); !ok || string(msg) != `{"id":0}` {
+		t.Fatalf("record 0 is %s", msg)
+	}
+	// Decoding stops ahead of the records asked for
+	for {
+		if loaded, done, _ := l.progress(); loaded > exploreAhead {
+			if done || loaded > exploreAhead+1 {
+				t.Fatalf("decoded %d records ahead of the first", loaded)
+			}
+			break
+		}
+	}
+	if _, ok := l.get(len(msgs)); ok {
+		t.Fatal("got a record past the end")
+	}
+	if loaded, done, err := l.progress(); loaded != len(msgs) || !done || err != nil {
+		t.Fatalf("progress %d, %v, %v", loaded, done, err)
+	}
+}
+
+// loaderID returns the id of record i of a loader.
+func loaderID(t *testing.T, l *recordLoader, i int) int64 {
+	t.Helper()
+	msg, ok := l.get(i)
+	if !ok {
+		t.Fatalf("record %d is missing", i)
+	}
+	return messageIDs(t, []json.RawMessage{msg})[0]
+}
+
+// TestRecordLoaderWindow checks the explorer keeps a bounded window of
+// records, and reads records before it again when they are asked for.
+func TestRecordLoaderWindow(t *testing.T) {
+	const n = 6000
+	avro := writeTestOCF(t, ocfCodecSnappy, n, nil, nil)
+	var ndjson bytes.Buffer
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&ndjson, "{\"id\":%d}\n", i)
+	}
+	for name, data := range map[string][]byte{"avro": avro, "ndjson": ndjson.Bytes()} {
+		t.Run(name, func(t *testing.T) {
+			path := writeTestFile(t, "records."+name, data)
+			l := newRecordLoader(path, testOptions(t, ""))
+			defer l.close()
+
+			for i := 0; i < n; i++ {
+				if id := loaderID(t, l, i); id != int64(i) {
+					t.Fatalf("record %d has id %d", i, id)
+				}
+				l.mu.Lock()
+				kept := len(l.records)
+				l.mu.Unlock()
+				if kept > exploreBehind+exploreAhead+2 {
+					t.Fatalf("at record %d, %d records are kept", i, kept)
+				}
+			}
+			if _, ok := l.get(n); ok {
+				t.Fatal("got a record past the last")
+			}
+			if loaded, done, err := l.progress(); loaded != n || !done || err != nil {
+				t.Fatalf("progress is %d, %v, %v", loaded, done, err)
+			}
+			if l.first == 0 {
+				t.Fatal("no records were dropped")
+			}
+
+			// Records dropped are read again, going back and forth
+			for _, i := range []int{0, 1, 2500, 10, n - exploreBehind - 1, exploreAhead + 5, 3} {
+				if id := loaderID(t, l, i); id != int64(i) {
+					t.Fatalf("record %d read again has id %d", i, id)
+				}
+			}
+			if name == "avro" && l.headerSize == 0 {
+				t.Fatal("the blocks of the container file weren't noted")
+			}
+		})
+	}
+}
+
+// TestRecordLoaderBlocks checks the blocks noted are read again on their
+// own, and not once the input is replaced.
+func TestRecordLoaderBlocks(t *testing.T) {
+	const n = 6000
+	path := writeTestFile(t, "records.avro", writeTestOCF(t, ocfCodecDeflate, n, nil, nil))
+	l := newRecordLoader(path, testOptions(t, ""))
+	defer l.close()
+	// Waits for the whole input
+	if _, ok := l.get(n); ok {
+		t.Fatal("got a record past the last")
+	}
+
+	l.mu.Lock()
+	blocks := l.blocks
+	l.mu.Unlock()
+	if len(blocks) < 10 {
+		t.Fatalf("noted %d blocks", len(blocks))
+	}
+	for k := 1; k < len(blocks); k++ {
+		if blocks[k].start != blocks[k-1].end || blocks[k].first <= blocks[k-1].first {
+			t.Fatalf("block %d (%+v) doesn't follow block %d (%+v)", k, blocks[k], k-1, blocks[k-1])
+		}
+	}
+	for _, lohi := range [][2]int{{0, 10}, {1234, 2345}, {blocks[3].first, blocks[5].first}, {n - 10, n}} {
+		lo, hi := lohi[0], lohi[1]
+		records, err := l.rereadBlocks(blocks, lo, hi)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := messageIDs(t, records)
+		if len(ids) != hi-lo || ids[0] != int64(lo) || ids[len(ids)-1] != int64(hi-1) {
+			t.Fatalf("records %d to %d read again are %d, from %d", lo, hi, len(ids), ids[0])
+		}
+	}
+
+	// Another file at the same path has another sync marker
+	if err := os.WriteFile(path, writeTestOCF(t, ocfCodecDeflate, n, nil, nil), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.rereadBlocks(blocks, 0, 10); err != errInputChanged {
+		t.Fatalf("reading blocks of a replaced input returned %v, want %v", err, errInputChanged)
+	}
+}
+
+// TestRecordLoaderSampled checks a sampled input is read again from the
+// start, as which records a block yields depends on those before it.
+func TestRecordLoaderSampled(t *testing.T) {
+	path := writeTestFile(t, "records.avro", writeTestOCF(t, ocfCodecDeflate, 3000, nil, nil))
+	opts := testOptions(t, "")
+	opts.sampling = sampling{skip: 1}
+	l := newRecordLoader(path, opts)
+	defer l.close()
+	if id := loaderID(t, l, 2500); id != 2501 {
+		t.Fatalf("record 2500 has id %d", id)
+	}
+	if l.headerSize != 0 {
+		t.Fatal("noted the blocks of a sampled input")
+	}
+	if id := loaderID(t, l, 0); id != 1 {
+		t.Fatalf("record 0 read again has id %d", id)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"avroparser/pkg/avroconvert"
+)
+
+// extendedJSON turns the MongoDB Extended JSON wrappers of mongoexport
+// output, such as {"$oid": ...} and {"$date": ...}, into plain values. Both
+// the canonical and the relaxed forms are understood. Dates are rendered as
+// the converter renders Avro timestamps.
+type extendedJSON struct {
+	converter *avroconvert.JSONConverter
+}
+
+// apply converts a JSON message. It is used as a decoder transform, ahead of
+// -redact and -transform.
+func (x *extendedJSON) apply(msg json.RawMessage) ([]json.RawMessage, error) {
+	v, err := parseMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+	text, err := json.Marshal(x.value(v))
+	if err != nil {
+		return nil, err
+	}
+	return []json.RawMessage{text}, nil
+}
+
+func (x *extendedJSON) value(v interface{}) interface{} {
+	switch t := v.(type) {
+	case []interface{}:
+		for i, item := range t {
+			t[i] = x.value(item)
+		}
+	case map[string]interface{}:
+		if plain, ok := x.wrapper(t); ok {
+			return plain
+		}
+		for k, field := range t {
+			t[k] = x.value(field)
+		}
+	}
+	return v
+}
+
+// wrapper returns the plain value of an Extended JSON type wrapper. ok is
+// false for other objects.
+func (x *extendedJSON) wrapper(m map[string]interface{}) (v interface{}, ok bool) {
+	switch len(m) {
+	case 1:
+		for key, value := range m {
+			return x.typed(key, value)
+		}
+	case 2:
+		// Legacy binary: {"$binary": "<base64>", "$type": "00"}
+		if data, isText := m["$binary"].(string); isText {
+			if _, hasType := m["$type"]; hasType {
+				return data, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func (x *extendedJSON) typed(key string, value interface{}) (interface{}, bool) {
+	switch key {
+	case "$oid", "$symbol", "$uuid":
+		if s, ok := value.(string); ok {
+			return s, true
+		}
+	case "$numberInt", "$numberLong", "$numberDouble", "$numberDecimal":
+		s, ok := value.(string)
+		if !ok {
+			return nil, false
+		}
+		// Infinity, -Infinity and NaN have no JSON number, though ParseFloat
+		// accepts them, as it does hexadecimal floats
+		if _, err := strconv.ParseFloat(s, 64); err == nil && json.Valid([]byte(s)) {
+			return json.Number(s), true
+		}
+		return s, true
+	case "$date":
+		if t, ok := extendedDate(value); ok {
+			return x.converter.Timestamp(t), true
+		}
+	case "$timestamp":
+		m, _ := value.(map[string]interface{})
+		if seconds, ok := m["t"].(json.Number); ok {
+			if n, err := seconds.Int64(); err == nil {
+				return x.converter.Timestamp(time.Unix(n, 0)), true
+			}
+		}
+	case "$binary":
+		m, _ := value.(map[string]interface{})
+		if data, ok := m["base64"].(string); ok {
+			return data, true
+		}
+	case "$regularExpression":
+		m, _ := value.(map[string]interface{})
+		pattern, _ := m["pattern"].(string)
+		options, _ := m["options"].(string)
+		return "/" + pattern + "/" + options, true
+	case "$undefined":
+		return nil, true
+	}
+	return nil, false
+}
+
+// extendedDate parses the value of a $date: an RFC 3339 string in relaxed
+// form, or milliseconds since the epoch, canonically as a $numberLong.
+func extendedDate(v interface{}) (time.Time, bool) {
+	switch t := v.(type) {
+	case string:
+		d, err := time.Parse(time.RFC3339Nano, t)
+		return d, err == nil
+	case json.Number:
+		n, err := t.Int64()
+		return time.UnixMilli(n).UTC(), err == nil
+	case map[string]interface{}:
+		if s, ok := t["$numberLong"].(string); ok && len(t) == 1 {
+			n, err := strconv.ParseInt(s, 10, 64)
+			return time.UnixMilli(n).UTC(), err == nil
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"avroparser/pkg/avroconvert"
+)
+
+func TestExtendedJSON(t *testing.T) {
+	converter, err := avroconvert.NewJSONConverter(avroconvert.ConverterOptions{TimeZone: "UTC"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := &extendedJSON{converter: converter}
+	for input, want := range map[string]string{
+		`{"_id":{"$oid":"5f1e9c"}}`:                                   `{"_id":"5f1e9c"}`,
+		`{"n":{"$numberLong":"9007199254740993"}}`:                    `{"n":9007199254740993}`,
+		`{"n":{"$numberDouble":"Infinity"}}`:                          `{"n":"Infinity"}`,
+		`{"n":{"$numberDouble":"0x1p-2"}}`:                            `{"n":"0x1p-2"}`,
+		`{"d":{"$date":"2026-10-15T12:00:00Z"}}`:                      `{"d":"2026-10-15T12:00:00Z"}`,
+		`{"d":{"$date":{"$numberLong":"1760529600000"}}}`:             `{"d":"2025-10-15T12:00:00Z"}`,
+		`{"ts":{"$timestamp":{"t":1760529600,"i":1}}}`:                `{"ts":"2025-10-15T12:00:00Z"}`,
+		`{"b":{"$binary":{"base64":"AQI=","subType":"00"}}}`:          `{"b":"AQI="}`,
+		`{"b":{"$binary":"AQI=","$type":"00"}}`:                       `{"b":"AQI="}`,
+		`{"r":{"$regularExpression":{"pattern":"^a","options":"i"}}}`: `{"r":"/^a/i"}`,
+		`{"u":{"$undefined":true},"tags":[{"$numberInt":"1"}]}`:       `{"tags":[1],"u":null}`,
+		`{"plain":{"$oid":1,"other":2}}`:                              `{"plain":{"$oid":1,"other":2}}`,
+		`{"bad":{"$date":"yesterday"}}`:                               `{"bad":{"$date":"yesterday"}}`,
+	} {
+		got, err := x.apply(json.RawMessage(input))
+		if err != nil {
+			t.Fatalf("apply(%s): %v", input, err)
+		}
+		if len(got) != 1 || string(got[0]) != want {
+			t.Errorf("apply(%s) = %s, want %s", input, got, want)
+		}
+	}
+}
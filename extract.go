@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"avroparser/pkg/avroconvert"
+)
+
+func runExtractUser(args []string) {
+	fs := flag.NewFlagSet("extract-user", flag.ExitOnError)
+	ids := fs.String("ids", "", "Comma-separated user IDs whose records are extracted")
+	idsFile := fs.String("ids-file", "", "File of user IDs whose records are extracted, one per line (# starts a comment)")
+	idFields := fs.String("id-fields", "user_pseudo_id,playerID", "Comma-separated field paths holding the user ID; a record matching any of them is extracted")
+	outputPath := fs.String("output", stdioPath, "JSON file to write the bundle to (.gz or .zst compresses it), or - for stdout")
+	records := addRecordFlags(fs)
+	logging := addLogFlags(fs)
+	parseFlags(fs, args)
+	logging.setup()
+
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: avroparser extract-user -ids <id,...>|-ids-file <file> [-id-fields <field,...>] [-output <file>|-] <avro_or_ndjson_file|dir|glob>...")
+		os.Exit(exitFatal)
+	}
+	if *records.onError == onErrorCollect {
+		fmt.Fprintln(os.Stderr, "extract-user cannot collect failed records; use -on-error skip or fail")
+		os.Exit(exitFatal)
+	}
+	users, err := loadUserIDs(*ids, *idsFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	s := &scrubber{ids: users, field: *records.field}
+	for _, path := range splitFieldList(*idFields) {
+		if path == "" || strings.HasPrefix(path, ".") || strings.HasSuffix(path, ".") || strings.Contains(path, "..") {
+			fmt.Fprintf(os.Stderr, "Invalid -id-fields path %q\n", path)
+			os.Exit(exitFatal)
+		}
+		s.paths = append(s.paths, strings.Split(path, "."))
+	}
+	if len(s.paths) == 0 {
+		fmt.Fprintln(os.Stderr, "-id-fields must name at least one field")
+		os.Exit(exitFatal)
+	}
+	opts := mustRecordOptions(records)
+
+	var inputs []inputFile
+	for _, arg := range fs.Args() {
+		inputs = append(inputs, mustExpandInputs(arg)...)
+	}
+
+	bundle := newUserBundle(users, splitFieldList(*idFields))
+	// The filter finds the users of a record, which the sink files the
+	// messages written for it under
+	var matched []string
+	filter := opts.filter
+	opts.filter = &recordFilter{eval: func(record interface{}) interface{} {
+		v, ok := s.message(record)
+		if matched = nil; ok {
+			matched = s.userIDs(v)
+		}
+		if len(matched) == 0 {
+			return false
+		}
+		if filter == nil {
+			return true
+		}
+		return filter.eval(record)
+	}}
+
+	failed := 0
+	for _, in := range inputs {
+		entry := bundleInput{Input: displayPath(in.path), Status: "ok"}
+		sink := messageSink(func(msg json.RawMessage) error {
+			entry.Matched++
+			for _, id := range matched {
+				bundle.add(id, entry.Input, msg)
+			}
+			return nil
+		})
+		input, err := openDecodeInput(in.path, opts)
+		if err == nil {
+			var stats avroconvert.Stats
+			stats, err = decodeMessages(input, in.path, opts, sink)
+			entry.Records = stats.Read
+			input.Close()
+		}
+		if err != nil {
+			slog.Error("Cannot extract records", "input", displayPath(in.path), "error", err)
+			entry.Status, entry.Error = "failed", err.Error()
+			failed++
+		} else {
+			slog.Info("Extracted records", "input", displayPath(in.path), "records", entry.Records, "matched", entry.Matched)
+		}
+		bundle.Inputs = append(bundle.Inputs, entry)
+	}
+
+	if err := writeUserBundle(*outputPath, bundle); err != nil {
+		slog.Error("Cannot write bundle", "error", err)
+		os.Exit(exitFatal)
+	}
+	switch {
+	case failed == len(inputs):
+		os.Exit(exitFatal)
+	case failed > 0:
+		os.Exit(exitPartial)
+	}
+}
+
+// userBundle is the JSON document extract-user writes: every record of
+// each user, and the inputs searched, so a data-access request can be
+// answered with it as it is.
+type userBundle struct {
+	Created  time.Time     `json:"created"`
+	IDFields []string      `json:"id_fields"`
+	Inputs   []bundleInput `json:"inputs"`
+	Users    []*bundleUser `json:"users"`
+	byID     map[string]*bundleUser
+}
+
+// bundleInput is an input searched for the users' records.
+type bundleInput struct {
+	Input   string `json:"input"`
+	Status  string `json:"status"` // ok or failed
+	Error   string `json:"error,omitempty"`
+	Records int    `json:"records"`
+	Matched int    `json:"matched"` // records written to the bundle
+}
+
+// bundleUser holds the records of one user, in the order of the inputs.
+// A user without records is listed too, to show none were found.
+type bundleUser struct {
+	ID      string         `json:"user_id"`
+	Count   int            `json:"record_count"`
+	Records []bundleRecord `json:"records"`
+}
+
+type bundleRecord struct {
+	Input  string          `json:"input"`
+	Record json.RawMessage `json:"record"`
+}
+
+// newUserBundle returns an empty bundle for the given users, listed in the
+// order of their IDs.
+func newUserBundle(ids map[string]bool, idFields []string) *userBundle {
+	b := &userBundle{Created: time.Now().UTC(), IDFields: idFields, Inputs: []bundleInput{}, byID: make(map[string]*bundleUser)}
+	for id := range ids {
+		user := &bundleUser{ID: id, Records: []bundleRecord{}}
+		b.Users = append(b.Users, user)
+		b.byID[id] = user
+	}
+	sort.Slice(b.Users, func(i, j int) bool { return b.Users[i].ID < b.Users[j].ID })
+	return b
+}
+
+// add files a copy of a record under a user.
+func (b *userBundle) add(id, input string, msg json.RawMessage) {
+	user := b.byID[id]
+	user.Records = append(user.Records, bundleRecord{Input: input, Record: append(json.RawMessage(nil), msg...)})
+	user.Count++
+}
+
+func writeUserBundle(path string, bundle *userBundle) error {
+	data, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return err
+	}
+	out, err := openOutput(path)
+	if err != nil {
+		return err
+	}
+	w, err := compressOutput(out, outputCompression(path))
+	if err != nil {
+		abortOutput(out)
+		return err
+	}
+	if _, err := w.Write(append(data, '\n')); err != nil {
+		abortOutput(w)
+		return err
+	}
+	return w.Close()
+}
+
+// userIDs returns the listed users a JSON value belongs to, by any of the
+// ID paths.
+func (s *scrubber) userIDs(v interface{}) []string {
+	var found []string
+	for _, path := range s.paths {
+		id := lookupPath(v, path)
+		if id == nil {
+			continue
+		}
+		if key := countKey(id); s.ids[key] && !slices.Contains(found, key) {
+			found = append(found, key)
+		}
+	}
+	return found
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestScrubberUserIDs(t *testing.T) {
+	s := testScrubber("", false)
+	tests := []struct {
+		record string
+		want   []string
+	}{
+		{`{"user_pseudo_id":"u-1","player":{"id":7}}`, []string{"u-1", "7"}},
+		{`{"user_pseudo_id":"u-2","player":{"id":7}}`, []string{"7"}},
+		{`{"user_pseudo_id":"7"}`, []string{"7"}},
+		{`{"user_pseudo_id":"u-2"}`, nil},
+		{`{"player":null}`, nil},
+	}
+	for _, tt := range tests {
+		v, err := parseMessage(json.RawMessage(tt.record))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := s.userIDs(v); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: users %v, want %v", tt.record, got, tt.want)
+		}
+	}
+}
+
+func TestWriteUserBundle(t *testing.T) {
+	bundle := newUserBundle(map[string]bool{"u-2": true, "u-1": true}, []string{"user_pseudo_id"})
+	msg := json.RawMessage(`{"user_pseudo_id":"u-1","n":1}`)
+	bundle.add("u-1", "day1.avro", msg)
+	msg[len(msg)-2] = '2' // the bundle keeps a copy
+	bundle.Inputs = append(bundle.Inputs, bundleInput{Input: "day1.avro", Status: "ok", Records: 3, Matched: 1})
+
+	path := filepath.Join(t.TempDir(), "bundle.json.gz")
+	if err := writeUserBundle(path, bundle); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Inputs []bundleInput `json:"inputs"`
+		Users  []struct {
+			ID      string         `json:"user_id"`
+			Count   int            `json:"record_count"`
+			Records []bundleRecord `json:"records"`
+		} `json:"users"`
+	}
+	if err := json.NewDecoder(zr).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Users) != 2 || got.Users[0].ID != "u-1" || got.Users[1].ID != "u-2" {
+		t.Fatalf("users %+v", got.Users)
+	}
+	var record bytes.Buffer
+	if u := got.Users[0]; u.Count != 1 || u.Records[0].Input != "day1.avro" || json.Compact(&record, u.Records[0].Record) != nil || record.String() != `{"user_pseudo_id":"u-1","n":1}` {
+		t.Errorf("u-1 %+v", u)
+	}
+	// A user without records is listed with an empty array
+	if u := got.Users[1]; u.Count != 0 || u.Records == nil {
+		t.Errorf("u-2 %+v", u)
+	}
+	if len(got.Inputs) != 1 || got.Inputs[0].Matched != 1 {
+		t.Errorf("inputs %+v", got.Inputs)
+	}
+}
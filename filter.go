@@ -0,0 +1,425 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// recordFilter is a compiled -filter expression. Expressions compare record
+// fields with literals and combine the comparisons:
+//
+//	event_name == "level_complete" && (geo.country == "US" || score >= 100)
+//
+// Fields are referenced by their dotted path in the converted record, so
+// unions are already unwrapped and logical types have their JSON form.
+// Missing fields are null.
+type recordFilter struct {
+	eval filterFunc
+}
+
+// filterFunc evaluates part of a filter expression against a converted
+// record.
+type filterFunc func(record interface{}) interface{}
+
+// parseFilter compiles a filter expression.
+func parseFilter(expr string) (*recordFilter, error) {
+	tokens, err := lexFilter(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter: %w", err)
+	}
+	p := &filterParser{tokens: tokens}
+	eval, err := p.or()
+	if err == nil && p.peek().kind != filterEOF {
+		err = p.unexpected()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter: %w", err)
+	}
+	return &recordFilter{eval: eval}, nil
+}
+
+// match reports whether a converted record satisfies the filter.
+func (f *recordFilter) match(record interface{}) bool {
+	return f.eval(record) == true
+}
+
+// Kinds of filter tokens.
+const (
+	filterEOF = iota
+	filterIdent
+	filterString
+	filterNumber
+	filterOp
+)
+
+type filterToken struct {
+	kind int
+	text string
+	pos  int
+}
+
+// filterOps are the operator tokens, longest first so "<=" wins over "<".
+var filterOps = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")", "."}
+
+func lexFilter(expr string) ([]filterToken, error) {
+	var tokens []filterToken
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case c == '"' || c == '\'':
+			end := i + 1
+			for end < len(expr) && expr[end] != c {
+				if expr[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(expr) {
+				return nil, fmt.Errorf("unterminated string at position %d", i+1)
+			}
+			text, err := unquoteFilterString(expr[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at position %d: %w", i+1, err)
+			}
+			tokens = append(tokens, filterToken{kind: filterString, text: text, pos: i})
+			i = end + 1
+
+		case c >= '0' && c <= '9' || c == '-' && i+1 < len(expr) && expr[i+1] >= '0' && expr[i+1] <= '9':
+			end := i + 1
+			for end < len(expr) && strings.IndexByte("0123456789.eE+-", expr[end]) >= 0 {
+				// A sign only continues a number right after an exponent
+				if (expr[end] == '+' || expr[end] == '-') && expr[end-1] != 'e' && expr[end-1] != 'E' {
+					break
+				}
+				end++
+			}
+			tokens = append(tokens, filterToken{kind: filterNumber, text: expr[i:end], pos: i})
+			i = end
+
+		case c == '_' || unicode.IsLetter(rune(c)):
+			end := i + 1
+			for end < len(expr) && (expr[end] == '_' || unicode.IsLetter(rune(expr[end])) || unicode.IsDigit(rune(expr[end]))) {
+				end++
+			}
+			tokens = append(tokens, filterToken{kind: filterIdent, text: expr[i:end], pos: i})
+			i = end
+
+		default:
+			op := ""
+			for _, candidate := range filterOps {
+				if strings.HasPrefix(expr[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected %q at position %d", c, i+1)
+			}
+			tokens = append(tokens, filterToken{kind: filterOp, text: op, pos: i})
+			i += len(op)
+		}
+	}
+	return append(tokens, filterToken{kind: filterEOF, pos: len(expr)}), nil
+}
+
+// unquoteFilterString decodes a double- or single-quoted string literal
+// with Go escapes.
+func unquoteFilterString(quoted string) (string, error) {
+	if quoted[0] == '\'' {
+		body := quoted[1 : len(quoted)-1]
+		quoted = `"` + strings.ReplaceAll(strings.ReplaceAll(body, `\'`, `'`), `"`, `\"`) + `"`
+	}
+	return strconv.Unquote(quoted)
+}
+
+// filterParser is a recursive descent parser over the grammar
+//
+//	or         = and { "||" and }
+//	and        = comparison { "&&" comparison }
+//	comparison = unary [ ( "==" | "!=" | "<" | "<=" | ">" | ">=" ) unary ]
+//	unary      = "!" unary | "(" or ")" | literal | path
+//	path       = ident { "." ident }
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+func (p *filterParser) peek() filterToken {
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() filterToken {
+	t := p.tokens[p.pos]
+	if t.kind != filterEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the next token if it is the operator op.
+func (p *filterParser) accept(op string) bool {
+	if t := p.peek(); t.kind == filterOp && t.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) unexpected() error {
+	t := p.peek()
+	if t.kind == filterEOF {
+		return fmt.Errorf("unexpected end of expression")
+	}
+	return fmt.Errorf("unexpected %q at position %d", t.text, t.pos+1)
+}
+
+func (p *filterParser) or() (filterFunc, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(record interface{}) interface{} {
+			return l(record) == true || right(record) == true
+		}
+	}
+	return left, nil
+}
+
+func (p *filterParser) and() (filterFunc, error) {
+	left, err := p.comparison()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		right, err := p.comparison()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(record interface{}) interface{} {
+			return l(record) == true && right(record) == true
+		}
+	}
+	return left, nil
+}
+
+func (p *filterParser) comparison() (filterFunc, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+
+	t := p.peek()
+	if t.kind != filterOp {
+		return left, nil
+	}
+	var test func(a, b interface{}) bool
+	switch t.text {
+	case "==":
+		test = filterEqual
+	case "!=":
+		test = func(a, b interface{}) bool { return !filterEqual(a, b) }
+	case "<":
+		test = func(a, b interface{}) bool { c, ok := filterCompare(a, b); return ok && c < 0 }
+	case "<=":
+		test = func(a, b interface{}) bool { c, ok := filterCompare(a, b); return ok && c <= 0 }
+	case ">":
+		test = func(a, b interface{}) bool { c, ok := filterCompare(a, b); return ok && c > 0 }
+	case ">=":
+		test = func(a, b interface{}) bool { c, ok := filterCompare(a, b); return ok && c >= 0 }
+	default:
+		return left, nil
+	}
+	p.next()
+
+	right, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	return func(record interface{}) interface{} {
+		return test(left(record), right(record))
+	}, nil
+}
+
+func (p *filterParser) unary() (filterFunc, error) {
+	t := p.next()
+	switch t.kind {
+	case filterOp:
+		switch t.text {
+		case "!":
+			operand, err := p.unary()
+			if err != nil {
+				return nil, err
+			}
+			return func(record interface{}) interface{} {
+				return operand(record) != true
+			}, nil
+		case "(":
+			inner, err := p.or()
+			if err != nil {
+				return nil, err
+			}
+			if !p.accept(")") {
+				return nil, p.unexpected()
+			}
+			return inner, nil
+		}
+
+	case filterString:
+		return filterConstant(t.text), nil
+
+	case filterNumber:
+		if n, err := strconv.ParseInt(t.text, 10, 64); err == nil {
+			return filterConstant(n), nil
+		}
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at position %d", t.text, t.pos+1)
+		}
+		return filterConstant(f), nil
+
+	case filterIdent:
+		switch t.text {
+		case "true":
+			return filterConstant(true), nil
+		case "false":
+			return filterConstant(false), nil
+		case "null":
+			return filterConstant(nil), nil
+		}
+		path := []string{t.text}
+		for p.accept(".") {
+			if p.peek().kind != filterIdent {
+				return nil, p.unexpected()
+			}
+			path = append(path, p.next().text)
+		}
+		return func(record interface{}) interface{} {
+			return lookupPath(record, path)
+		}, nil
+	}
+
+	if t.kind != filterEOF {
+		p.pos--
+	}
+	return nil, p.unexpected()
+}
+
+func filterConstant(v interface{}) filterFunc {
+	return func(interface{}) interface{} { return v }
+}
+
+// lookupPath follows field names through nested records and maps, returning
+// nil when any of them is missing.
+func lookupPath(v interface{}, path []string) interface{} {
+	for _, name := range path {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[name]
+	}
+	return v
+}
+
+// filterEqual compares values of the same kind; numbers compare by value
+// whatever their Go type.
+func filterEqual(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	if c, ok := filterCompare(a, b); ok {
+		return c == 0
+	}
+	if x, ok := a.(bool); ok {
+		y, ok := b.(bool)
+		return ok && x == y
+	}
+	return false
+}
+
+// filterCompare orders two strings, or two numbers. Strings holding numbers,
+// like decimals in their default string form, compare as numbers against
+// numbers. ok is false for values that cannot be ordered.
+func filterCompare(a, b interface{}) (int, bool) {
+	if x, ok := a.(string); ok {
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), true
+		}
+	}
+
+	x, ok := filterNumeric(a)
+	if !ok {
+		return 0, false
+	}
+	y, ok := filterNumeric(b)
+	if !ok {
+		return 0, false
+	}
+	if xi, ok := x.(int64); ok {
+		if yi, ok := y.(int64); ok {
+			switch {
+			case xi < yi:
+				return -1, true
+			case xi > yi:
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+	xf, yf := filterFloat(x), filterFloat(y)
+	switch {
+	case xf < yf:
+		return -1, true
+	case xf > yf:
+		return 1, true
+	}
+	return 0, true
+}
+
+// filterNumeric returns v as an int64 or float64, if it is a number.
+func filterNumeric(v interface{}) (interface{}, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case uint32:
+		return int64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	case json.Number:
+		return filterNumeric(string(n))
+	case string:
+		if i, err := strconv.ParseInt(n, 10, 64); err == nil {
+			return i, true
+		}
+		if f, err := strconv.ParseFloat(n, 64); err == nil {
+			return f, true
+		}
+	}
+	return nil, false
+}
+
+func filterFloat(v interface{}) float64 {
+	if i, ok := v.(int64); ok {
+		return float64(i)
+	}
+	return v.(float64)
+}
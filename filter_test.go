@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"avroparser/pkg/avroconvert"
+)
+
+func TestRecordFilter(t *testing.T) {
+	record := map[string]interface{}{
+		"event_name": "level_complete",
+		"score":      int64(120),
+		"ratio":      0.5,
+		"price":      "19.99",
+		"premium":    true,
+		"user":       nil,
+		"geo":        map[string]interface{}{"country": "US"},
+	}
+	for expr, want := range map[string]bool{
+		`event_name == "level_complete"`:                                  true,
+		`event_name != 'level_complete'`:                                  false,
+		`score >= 100 && geo.country == "US"`:                             true,
+		`score > 200 || (geo.country == "DE" || premium)`:                 true,
+		`!(score < 100) && ratio < 1`:                                     true,
+		`price > 19.5`:                                                    true,
+		`price == "19.99"`:                                                true,
+		`user == null && missing.field == null`:                           true,
+		`premium == false`:                                                false,
+		`score == 120.0`:                                                  true,
+		`geo.country > "DE" && geo.country < "ZZ"`:                        true,
+		`event_name > 5`:                                                  false,
+		`event_name == "say \"hi\""`:                                      false,
+		`score >= 100 && geo.country == "US" || event_name == "level_up"`: true,
+	} {
+		f, err := parseFilter(expr)
+		if err != nil {
+			t.Fatalf("%s: %v", expr, err)
+		}
+		if got := f.match(record); got != want {
+			t.Errorf("%s matched %v, want %v", expr, got, want)
+		}
+	}
+
+	for _, expr := range []string{`score >`, `(score > 1`, `score = 1`, `"unterminated`, `geo.`, `score > 1 1`} {
+		if _, err := parseFilter(expr); err == nil {
+			t.Errorf("parsed invalid filter %s", expr)
+		}
+	}
+}
+
+func TestDecodeFilter(t *testing.T) {
+	filter, err := parseFilter(`id >= 2`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := testOptions(t, "")
+	opts.filter = filter
+
+	var out bytes.Buffer
+	data := writeEventOCF(t, testEvent(1), testEvent(2), testEvent(3))
+	stats, err := decodeMessages(bytes.NewReader(data), "test.avro", opts, avroconvert.NewNDJSONSink(&out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Messages != 2 || stats.Filtered != 1 || bytes.Count(out.Bytes(), []byte("\n")) != 2 {
+		t.Fatalf("decoded %+v as %s", stats, out.String())
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/linkedin/goavro/v2"
+
+	"avroparser/pkg/avroconvert"
+)
+
+// schemaGroup is a writer schema and the files written with it.
+type schemaGroup struct {
+	CRC64      string   `json:"crc64_avro"` // Rabin fingerprint of the canonical form, in hex
+	SHA256     string   `json:"sha256"`
+	Type       string   `json:"type"` // e.g. record game.Event
+	Canonical  string   `json:"canonical"`
+	Files      []string `json:"files"`
+	Unexpected bool     `json:"unexpected,omitempty"` // not among -expect
+}
+
+// fingerprintReport groups files by the fingerprint of their writer schema.
+type fingerprintReport struct {
+	Files   int                  `json:"files"`
+	Schemas []*schemaGroup       `json:"schemas"`
+	Failed  []fingerprintFailure `json:"failed,omitempty"`
+}
+
+type fingerprintFailure struct {
+	Input string `json:"input"`
+	Error string `json:"error"`
+}
+
+func runSchemaFingerprint(args []string) {
+	fs := flag.NewFlagSet("schema fingerprint", flag.ExitOnError)
+	inputPath := fs.String("input", "", "Input Avro or .avsc file, directory or glob pattern, or - for stdin; more may be given as arguments")
+	format := fs.String("format", "text", "Report format: text or json")
+	canonical := fs.Bool("canonical", false, "With -format text, print each schema's Parsing Canonical Form")
+	expect := fs.String("expect", "", "Comma-separated fingerprints, CRC-64-AVRO or SHA-256 in hex, the schemas should have; files with other schemas make the exit code 1")
+	logging := addLogFlags(fs)
+	parseFlags(fs, args)
+	logging.setup()
+
+	paths := fs.Args()
+	if *inputPath != "" {
+		paths = append([]string{*inputPath}, paths...)
+	}
+	if len(paths) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: avroparser schema fingerprint [-format text|json] [-canonical] [-expect <fingerprint>,...] <avro_file|avsc_file|dir|glob|->...")
+		os.Exit(exitFatal)
+	}
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "Unknown report format %q (expected text or json)\n", *format)
+		os.Exit(exitFatal)
+	}
+	expected := make(map[string]bool)
+	for _, fp := range splitFieldList(*expect) {
+		expected[strings.TrimPrefix(strings.ToLower(fp), "0x")] = true
+	}
+
+	var inputs []inputFile
+	for _, path := range paths {
+		inputs = append(inputs, mustExpandInputs(path)...)
+	}
+	report := fingerprintFiles(inputs)
+
+	unexpected := 0
+	if len(expected) > 0 {
+		for _, g := range report.Schemas {
+			if !expected[g.CRC64] && !expected[g.SHA256] {
+				g.Unexpected = true
+				unexpected += len(g.Files)
+				slog.Warn("Unexpected writer schema", "crc64_avro", g.CRC64, "files", len(g.Files), "first", g.Files[0])
+			}
+		}
+	}
+
+	var err error
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(report)
+	} else {
+		err = writeFingerprintText(os.Stdout, report, *canonical)
+	}
+	if err != nil {
+		slog.Error("Cannot write report", "error", err)
+		os.Exit(exitFatal)
+	}
+	slog.Info("Fingerprinted writer schemas", "files", report.Files, "schemas", len(report.Schemas), "failed", len(report.Failed))
+
+	switch {
+	case len(report.Failed) == len(inputs):
+		os.Exit(exitFatal)
+	case len(report.Failed) > 0 || unexpected > 0:
+		os.Exit(exitPartial)
+	}
+}
+
+// fingerprintFiles reads the writer schema of each input, only its header,
+// and groups the inputs by the schema's fingerprint, in the order each
+// schema first appears.
+func fingerprintFiles(inputs []inputFile) *fingerprintReport {
+	report := &fingerprintReport{Files: len(inputs), Schemas: []*schemaGroup{}}
+	bySpec := make(map[string]*schemaGroup)
+	byFingerprint := make(map[string]*schemaGroup)
+	for _, in := range inputs {
+		name := in.path
+		if name == stdioPath {
+			name = "stdin"
+		}
+		spec, err := readSchemaSpec(in.path)
+		if err != nil {
+			slog.Error("Cannot read schema", "input", name, "error", err)
+			report.Failed = append(report.Failed, fingerprintFailure{Input: name, Error: err.Error()})
+			continue
+		}
+		// Most files repeat the schema of the previous ones
+		g, ok := bySpec[string(spec)]
+		if !ok {
+			if g, err = fingerprintSchema(spec); err != nil {
+				slog.Error("Cannot parse schema", "input", name, "error", err)
+				report.Failed = append(report.Failed, fingerprintFailure{Input: name, Error: err.Error()})
+				continue
+			}
+			// Schemas differing only in formatting, docs or defaults have
+			// the same canonical form
+			if existing, ok := byFingerprint[g.CRC64]; ok {
+				g = existing
+			} else {
+				byFingerprint[g.CRC64] = g
+				report.Schemas = append(report.Schemas, g)
+			}
+			bySpec[string(spec)] = g
+		}
+		g.Files = append(g.Files, name)
+	}
+	return report
+}
+
+// fingerprintSchema computes the CRC-64-AVRO and SHA-256 fingerprints of a
+// schema's Parsing Canonical Form, as the Avro specification defines them.
+func fingerprintSchema(spec []byte) (*schemaGroup, error) {
+	codec, err := goavro.NewCodec(string(spec))
+	if err != nil {
+		return nil, err
+	}
+	schema, err := avroconvert.ParseSchema(string(spec))
+	if err != nil {
+		return nil, err
+	}
+	typ := schema.Kind
+	if schema.IsNamed() {
+		typ += " " + schema.Name
+	}
+	canonical := codec.CanonicalSchema()
+	sum := sha256.Sum256([]byte(canonical))
+	return &schemaGroup{
+		CRC64:     fmt.Sprintf("%016x", codec.Rabin),
+		SHA256:    hex.EncodeToString(sum[:]),
+		Type:      typ,
+		Canonical: canonical,
+	}, nil
+}
+
+// writeFingerprintText writes each schema's fingerprints followed by the
+// files written with it.
+func writeFingerprintText(w io.Writer, report *fingerprintReport, canonical bool) error {
+	for i, g := range report.Schemas {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		mark := ""
+		if g.Unexpected {
+			mark = " (unexpected)"
+		}
+		fmt.Fprintf(w, "Schema %d: %s%s\n", i+1, g.Type, mark)
+		fmt.Fprintf(w, "  CRC-64-AVRO: %s\n", g.CRC64)
+		fmt.Fprintf(w, "  SHA-256:     %s\n", g.SHA256)
+		if canonical {
+			fmt.Fprintf(w, "  Canonical:   %s\n", g.Canonical)
+		}
+		fmt.Fprintf(w, "  Files (%s):\n", formatCount(int64(len(g.Files))))
+		for _, f := range g.Files {
+			fmt.Fprintf(w, "    %s\n", f)
+		}
+	}
+	_, err := fmt.Fprintf(w, "\nSchemas: %d, files: %s\n", len(report.Schemas), formatCount(int64(report.Files)))
+	return err
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestFingerprintSchema(t *testing.T) {
+	// The fingerprints of "null" in the Avro specification's test vectors
+	g, err := fingerprintSchema([]byte(`{"type": "null"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.CRC64 != "63dd24e7cc258f8a" || g.Canonical != `"null"` || g.Type != "null" {
+		t.Fatalf("fingerprinted %+v", g)
+	}
+	if g.SHA256 != "f072cbec3bf8841871d4284230c5e983dc211a56837aed862487148f947d1a1f" {
+		t.Errorf("SHA-256 %s", g.SHA256)
+	}
+	if _, err := fingerprintSchema([]byte(`{"type":"nope"}`)); err == nil {
+		t.Error("fingerprinted an invalid schema")
+	}
+}
+
+func TestFingerprintFiles(t *testing.T) {
+	// The same schema with other formatting and a doc has the same
+	// canonical form
+	reformatted := strings.Replace(messageSchema, `"name"`, `"doc": "exports", "name"`, 1)
+	inputs := []inputFile{
+		{path: writeTestFile(t, "a.avro", writeMessageOCF(t, `{}`))},
+		{path: writeTestFile(t, "event.avsc", []byte(eventSchema))},
+		{path: writeTestFile(t, "b.avsc", []byte(reformatted))},
+		{path: writeTestFile(t, "broken.avsc", []byte(`{"type":`))},
+	}
+	report := fingerprintFiles(inputs)
+	if report.Files != 4 || len(report.Schemas) != 2 || len(report.Failed) != 1 {
+		t.Fatalf("report %+v", report)
+	}
+	if g := report.Schemas[0]; len(g.Files) != 2 || g.Files[0] != inputs[0].path || g.Files[1] != inputs[2].path {
+		t.Errorf("first schema files %v", g.Files)
+	}
+	if report.Failed[0].Input != inputs[3].path {
+		t.Errorf("failed %+v", report.Failed)
+	}
+
+	report.Schemas[1].Unexpected = true
+	var out bytes.Buffer
+	if err := writeFingerprintText(&out, report, true); err != nil {
+		t.Fatal(err)
+	}
+	text := out.String()
+	for _, want := range []string{
+		"Schema 1: record ",
+		"  CRC-64-AVRO: " + report.Schemas[0].CRC64 + "\n",
+		"  Files (2):\n    " + inputs[0].path + "\n",
+		"Schema 2: record game.Event (unexpected)\n",
+		"  Canonical:   {",
+		"\nSchemas: 2, files: 4\n",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("report lacks %q:\n%s", want, text)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// firebaseCommands are the subcommands of "firebase", tools for the
+// BigQuery export of Firebase Analytics events.
+var firebaseCommands = map[string]func(args []string){
+	"merge":    runFirebaseMerge,
+	"sessions": runFirebaseSessions,
+}
+
+func runFirebase(args []string) {
+	if len(args) > 0 {
+		if command, ok := firebaseCommands[args[0]]; ok {
+			command(args[1:])
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Usage: avroparser firebase <%s> [flags]\n", strings.Join(sortedKeys(firebaseCommands), "|"))
+	os.Exit(exitFatal)
+}
+
+// firebaseExportName matches the names of the export's daily and intraday
+// tables as files, e.g. events_20261015.ndjson and
+// events_intraday_20261015.ndjson.gz.
+var firebaseExportName = regexp.MustCompile(`^events_(intraday_)?(\d{8})(\D|$)`)
+
+// firebaseDedupFields identify an event across the daily and intraday
+// exports.
+const firebaseDedupFields = "event_timestamp,event_name,user_pseudo_id,event_bundle_sequence_id"
+
+func runFirebaseMerge(args []string) {
+	fs := flag.NewFlagSet("firebase merge", flag.ExitOnError)
+	outputDir := fs.String("output", "", "Directory to write a merged events_<date>.ndjson file per day to")
+	dedupFields := fs.String("dedup-fields", firebaseDedupFields, "Comma-separated field paths identifying an event; events equal in all of them are written once")
+	compress := fs.String("compress", compressNone, "Compress output files: gzip, zstd or none")
+	logging := addLogFlags(fs)
+	parseFlags(fs, args)
+	logging.setup()
+
+	if *outputDir == "" || fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: avroparser firebase merge -output <dir> [-compress gzip|zstd|none] <ndjson_file|dir|glob>...")
+		os.Exit(exitFatal)
+	}
+	if err := checkCompression(*compress); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	var keys [][]string
+	for _, path := range splitFieldList(*dedupFields) {
+		if path == "" || strings.HasPrefix(path, ".") || strings.HasSuffix(path, ".") || strings.Contains(path, "..") {
+			fmt.Fprintf(os.Stderr, "Invalid -dedup-fields path %q\n", path)
+			os.Exit(exitFatal)
+		}
+		keys = append(keys, strings.Split(path, "."))
+	}
+	if len(keys) == 0 {
+		fmt.Fprintln(os.Stderr, "-dedup-fields must name at least one field")
+		os.Exit(exitFatal)
+	}
+
+	days := make(map[string]*firebaseDay)
+	for _, arg := range fs.Args() {
+		for _, in := range firebaseExportInputs(arg) {
+			match := firebaseExportName.FindStringSubmatch(filepath.Base(in.path))
+			if match == nil {
+				fmt.Fprintf(os.Stderr, "%s is not named like an export table (events_YYYYMMDD or events_intraday_YYYYMMDD)\n", displayPath(in.path))
+				os.Exit(exitFatal)
+			}
+			day := days[match[2]]
+			if day == nil {
+				day = &firebaseDay{date: match[2]}
+				days[match[2]] = day
+			}
+			if match[1] == "" {
+				day.daily = append(day.daily, in.path)
+			} else {
+				day.intraday = append(day.intraday, in.path)
+			}
+		}
+	}
+
+	failed := 0
+	for _, date := range sortedKeys(days) {
+		day := days[date]
+		output := filepath.Join(*outputDir, "events_"+date+"."+outputExt("ndjson", *compress))
+		stats, err := day.merge(output, *compress, keys)
+		if err != nil {
+			slog.Error("Cannot merge day", "date", date, "error", err)
+			failed++
+			continue
+		}
+		slog.Info("Merged day", "date", date, "daily_events", stats.daily, "intraday_events", stats.intraday, "duplicates", stats.duplicates, "output", output)
+	}
+
+	switch {
+	case failed == len(days):
+		os.Exit(exitFatal)
+	case failed > 0:
+		os.Exit(exitPartial)
+	}
+}
+
+// firebaseExportInputs resolves a merge argument into files. A local
+// directory is searched for export tables, which aren't found by the .avro
+// extension expandInputs looks for.
+func firebaseExportInputs(arg string) []inputFile {
+	info, err := os.Stat(arg)
+	if err != nil || !info.IsDir() {
+		return mustExpandInputs(arg)
+	}
+	entries, err := os.ReadDir(arg)
+	if err != nil {
+		slog.Error("Cannot resolve input", "input", arg, "error", err)
+		os.Exit(exitFatal)
+	}
+	var inputs []inputFile
+	for _, entry := range entries {
+		if !entry.IsDir() && firebaseExportName.MatchString(entry.Name()) {
+			inputs = append(inputs, inputFile{path: filepath.Join(arg, entry.Name()), rel: entry.Name()})
+		}
+	}
+	if len(inputs) == 0 {
+		slog.Error("No export tables found", "input", arg)
+		os.Exit(exitFatal)
+	}
+	return inputs
+}
+
+// firebaseDay holds the export files of one day.
+type firebaseDay struct {
+	date     string
+	daily    []string
+	intraday []string
+}
+
+// firebaseMergeStats counts the events a day's merge wrote and dropped.
+type firebaseMergeStats struct {
+	daily      int // written from the daily export
+	intraday   int // written from the intraday export, not yet in the daily one
+	duplicates int
+}
+
+// merge writes the day's events to output, the daily export's first: it is
+// complete where it covers the day, and the intraday export only adds the
+// events it doesn't have yet. Lines are copied as they are.
+func (d *firebaseDay) merge(output, compression string, keys [][]string) (firebaseMergeStats, error) {
+	var stats firebaseMergeStats
+	out, err := openOutput(output)
+	if err != nil {
+		return stats, err
+	}
+	w, err := compressOutput(out, compression)
+	if err != nil {
+		out.Close()
+		return stats, err
+	}
+	bw := bufio.NewWriter(w)
+
+	seen := make(map[string]bool)
+	paths := append(append([]string(nil), d.daily...), d.intraday...)
+	sort.Strings(paths[:len(d.daily)])
+	sort.Strings(paths[len(d.daily):])
+	for i, path := range paths {
+		written := &stats.daily
+		if i >= len(d.daily) {
+			written = &stats.intraday
+		}
+		if err = mergeEvents(path, bw, keys, seen, written, &stats.duplicates); err != nil {
+			err = fmt.Errorf("%s: %w", displayPath(path), err)
+			break
+		}
+	}
+	if err == nil {
+		if err = bw.Flush(); err != nil {
+			err = fmt.Errorf("cannot write output file: %w", err)
+		}
+	}
+	if closeErr := w.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("cannot write output file: %w", closeErr)
+	}
+	return stats, err
+}
+
+// mergeEvents copies the events of an NDJSON file whose key hasn't been
+// seen to w.
+func mergeEvents(path string, w io.Writer, keys [][]string, seen map[string]bool, written, duplicates *int) error {
+	r, err := openInput(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	br := bufio.NewReader(r)
+	var key strings.Builder
+	for line := 1; ; line++ {
+		text, err := br.ReadBytes('\n')
+		if trimmed := bytes.TrimSpace(text); len(trimmed) > 0 {
+			v, parseErr := parseMessage(trimmed)
+			if parseErr != nil {
+				return fmt.Errorf("line %d: %w", line, parseErr)
+			}
+			key.Reset()
+			for _, path := range keys {
+				key.WriteString(csvValue(flatValue(lookupPath(v, path))))
+				key.WriteByte(0)
+			}
+			if seen[key.String()] {
+				*duplicates++
+			} else {
+				seen[key.String()] = true
+				*written++
+				if _, err := w.Write(append(trimmed, '\n')); err != nil {
+					return fmt.Errorf("cannot write output file: %w", err)
+				}
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("cannot read input: %w", err)
+		}
+	}
+}
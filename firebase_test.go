@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFirebaseMerge(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	daily := write("events_20261015.ndjson",
+		`{"event_timestamp":1,"event_name":"a","user_pseudo_id":"u1","event_bundle_sequence_id":1}`+"\n"+
+			`{"event_timestamp":2,"event_name":"b","user_pseudo_id":"u1","event_bundle_sequence_id":1}`+"\n")
+	intraday := write("events_intraday_20261015.ndjson",
+		"\n"+`{"event_timestamp":2,"event_name":"b","user_pseudo_id":"u1","event_bundle_sequence_id":1,"late":true}`+"\n"+
+			`{"event_timestamp":3, "event_name":"c","user_pseudo_id":"u2","event_bundle_sequence_id":4}`)
+	write("notes.txt", "not an export")
+
+	inputs := firebaseExportInputs(dir)
+	if len(inputs) != 2 {
+		t.Fatalf("found inputs %+v", inputs)
+	}
+
+	day := &firebaseDay{date: "20261015", daily: []string{daily}, intraday: []string{intraday}}
+	output := filepath.Join(t.TempDir(), "events_20261015.ndjson")
+	var keys [][]string
+	for _, path := range splitFieldList(firebaseDedupFields) {
+		keys = append(keys, strings.Split(path, "."))
+	}
+	stats, err := day.merge(output, compressNone, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats != (firebaseMergeStats{daily: 2, intraday: 1, duplicates: 1}) {
+		t.Fatalf("merge counted %+v", stats)
+	}
+	got, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"event_timestamp":1,"event_name":"a","user_pseudo_id":"u1","event_bundle_sequence_id":1}` + "\n" +
+		`{"event_timestamp":2,"event_name":"b","user_pseudo_id":"u1","event_bundle_sequence_id":1}` + "\n" +
+		`{"event_timestamp":3, "event_name":"c","user_pseudo_id":"u2","event_bundle_sequence_id":4}` + "\n"
+	if string(got) != want {
+		t.Fatalf("merged %q, want %q", got, want)
+	}
+}
+
+func TestFirebaseMergeInvalidLine(t *testing.T) {
+	path := writeTestFile(t, "events_20261015.ndjson", []byte("{\"event_name\":\"a\"}\nnot json\n"))
+	day := &firebaseDay{date: "20261015", daily: []string{path}}
+	_, err := day.merge(filepath.Join(t.TempDir(), "out.ndjson"), compressNone, [][]string{{"event_name"}})
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("merge returned %v, want an error on line 2", err)
+	}
+}
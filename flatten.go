@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strconv"
+)
+
+// flatField is one column of a flattened record.
+type flatField struct {
+	name  string
+	value interface{} // string, json.Number, bool, nil, or JSON text for arrays
+	json  bool        // value is the JSON text of an array or empty object
+}
+
+// parseMessage decodes a JSON message, keeping numbers exact.
+func parseMessage(msg json.RawMessage) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(msg))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// flattenRecord flattens nested objects into columns named by joining the
+// keys on the path with sep, e.g. geo.country. Keys of each object are
+// visited in sorted order. Arrays are kept as JSON text, and a value that
+// isn't an object becomes a single "value" column.
+func flattenRecord(v interface{}, sep string) []flatField {
+	return flattener{separator: sep}.flatten(v)
+}
+
+// Ways -arrays flattens arrays.
+const (
+	arraysJSON  = "json"  // a column of JSON text
+	arraysIndex = "index" // a column per element, named by its index
+)
+
+// flattener flattens messages into columns as flattenRecord does, with
+// other treatments of arrays.
+type flattener struct {
+	separator string
+	// indexArrays flattens arrays into a column per element, e.g. items.0.id,
+	// instead of keeping them as JSON text
+	indexArrays bool
+	// firebase pivots the event_params and user_properties key-value arrays
+	// of Firebase events, and the item_params of their items, into a column
+	// per key, e.g. event_params.level, and writes the firebaseBlocks
+	// structs as a column per field even where they are null
+	firebase bool
+	// maxDepth, when not 0, is the most names a column name joins: values
+	// nested deeper are kept as JSON text in the column of their parent
+	maxDepth int
+	// itemsJSON keeps the items array of e-commerce events as one column of
+	// JSON text, even when indexArrays is set
+	itemsJSON bool
+}
+
+func (fl flattener) flatten(v interface{}) []flatField {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return []flatField{{name: "value", value: flatValue(v), json: isJSONContainer(v)}}
+	}
+	var fields []flatField
+	fl.object(m, "", 0, &fields)
+	return fields
+}
+
+func (fl flattener) object(m map[string]interface{}, prefix string, depth int, fields *[]flatField) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		name := k
+		if prefix != "" {
+			name = prefix + fl.separator + k
+		}
+		if fl.itemsJSON && prefix == "" && k == "items" {
+			*fields = append(*fields, flatField{name: name, value: flatValue(m[k]), json: isJSONContainer(m[k])})
+			continue
+		}
+		if fl.firebase && (prefix == "" && (k == "event_params" || k == "user_properties") || k == "item_params") {
+			if params, ok := firebaseParams(m[k]); ok {
+				for _, key := range sortedKeys(params) {
+					*fields = append(*fields, flatField{name: name + fl.separator + key, value: flatValue(params[key]), json: isJSONContainer(params[key])})
+				}
+				continue
+			}
+		}
+		if fl.firebase && prefix == "" {
+			if block, ok := firebaseBlocks[k]; ok {
+				fl.block(m[k], block, name, depth+1, fields)
+				continue
+			}
+		}
+		fl.value(m[k], name, depth+1, fields)
+	}
+}
+
+// block flattens a Firebase struct with a column per declared field, set or
+// not, so events where it is null get the same columns as the others.
+// Fields it doesn't declare are flattened as usual.
+func (fl flattener) block(v interface{}, declared []string, name string, depth int, fields *[]flatField) {
+	m, _ := v.(map[string]interface{})
+	if v != nil && m == nil {
+		fl.value(v, name, depth, fields)
+		return
+	}
+	known := make(map[string]bool, len(declared))
+	for _, field := range declared {
+		known[field] = true
+		fl.value(m[field], name+fl.separator+field, depth+1, fields)
+	}
+	for _, field := range sortedKeys(m) {
+		if !known[field] {
+			fl.value(m[field], name+fl.separator+field, depth+1, fields)
+		}
+	}
+}
+
+func (fl flattener) value(v interface{}, name string, depth int, fields *[]flatField) {
+	nested := fl.maxDepth == 0 || depth < fl.maxDepth
+	switch t := v.(type) {
+	case map[string]interface{}:
+		if len(t) > 0 && nested {
+			fl.object(t, name, depth, fields)
+			return
+		}
+	case []interface{}:
+		if fl.indexArrays && len(t) > 0 && nested {
+			for i, item := range t {
+				fl.value(item, name+fl.separator+strconv.Itoa(i), depth+1, fields)
+			}
+			return
+		}
+	}
+	*fields = append(*fields, flatField{name: name, value: flatValue(v), json: isJSONContainer(v)})
+}
+
+// firebaseBlocks are the structs of the BigQuery Firebase export that
+// -preset firebase writes as a column per field, with their fields.
+var firebaseBlocks = map[string][]string{
+	"event_dimensions": {"hostname"},
+	"ecommerce": {
+		"total_item_quantity", "purchase_revenue_in_usd", "purchase_revenue",
+		"refund_value_in_usd", "refund_value", "shipping_value_in_usd", "shipping_value",
+		"tax_value_in_usd", "tax_value", "unique_items", "transaction_id",
+	},
+	"user_ltv": {"revenue", "currency"},
+}
+
+// firebaseValueFields are the fields of a Firebase typed value, one of
+// which is set.
+var firebaseValueFields = []string{"string_value", "int_value", "float_value", "double_value"}
+
+// firebaseParams returns the values of a Firebase key-value array, such as
+// event_params, by key. Each value is the one of string_value, int_value,
+// float_value and double_value that is set. ok is false when v isn't an
+// array of such entries.
+func firebaseParams(v interface{}) (params map[string]interface{}, ok bool) {
+	entries, ok := v.([]interface{})
+	if !ok {
+		return nil, false
+	}
+	params = make(map[string]interface{}, len(entries))
+	for _, entry := range entries {
+		m, _ := entry.(map[string]interface{})
+		key, isKey := m["key"].(string)
+		if !isKey {
+			return nil, false
+		}
+		value := m["value"]
+		if typed, isTyped := value.(map[string]interface{}); isTyped {
+			value = nil
+			for _, name := range firebaseValueFields {
+				if typed[name] != nil {
+					value = typed[name]
+					break
+				}
+			}
+		}
+		params[key] = value
+	}
+	return params, true
+}
+
+// isJSONContainer reports whether v is an array or object, which flatValue
+// turns into JSON text.
+func isJSONContainer(v interface{}) bool {
+	switch v.(type) {
+	case []interface{}, map[string]interface{}:
+		return true
+	}
+	return false
+}
+
+// flatValue turns arrays and empty objects into JSON text and leaves
+// scalars as they are.
+func flatValue(v interface{}) interface{} {
+	switch v.(type) {
+	case []interface{}, map[string]interface{}:
+		text, err := json.Marshal(v)
+		if err != nil {
+			return nil
+		}
+		return string(text)
+	}
+	return v
+}
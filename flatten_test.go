@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestFlattenRecord(t *testing.T) {
+	v, err := parseMessage(json.RawMessage(`{"id":12345678901234567890,"geo":{"country":"DE","pos":{"lat":1.5}},"tags":["a","b"],"empty":{},"ok":true,"none":null}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []flatField{
+		{name: "empty", value: "{}", json: true},
+		{name: "geo_country", value: "DE"},
+		{name: "geo_pos_lat", value: json.Number("1.5")},
+		{name: "id", value: json.Number("12345678901234567890")},
+		{name: "none", value: nil},
+		{name: "ok", value: true},
+		{name: "tags", value: `["a","b"]`, json: true},
+	}
+	if got := flattenRecord(v, "_"); !reflect.DeepEqual(got, want) {
+		t.Fatalf("flattened to %+v, want %+v", got, want)
+	}
+
+	if got := flattenRecord("text", "."); !reflect.DeepEqual(got, []flatField{{name: "value", value: "text"}}) {
+		t.Fatalf("flattened a string to %+v", got)
+	}
+}
+
+func TestFlattenerArrays(t *testing.T) {
+	v, err := parseMessage(json.RawMessage(`{"items":[{"id":"a","qty":2},{"id":"b"}],"empty":[],"tags":["x"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []flatField{
+		{name: "empty", value: "[]", json: true},
+		{name: "items.0.id", value: "a"},
+		{name: "items.0.qty", value: json.Number("2")},
+		{name: "items.1.id", value: "b"},
+		{name: "tags.0", value: "x"},
+	}
+	if got := (flattener{separator: ".", indexArrays: true}).flatten(v); !reflect.DeepEqual(got, want) {
+		t.Fatalf("flattened to %+v, want %+v", got, want)
+	}
+}
+
+func TestFlattenerFirebase(t *testing.T) {
+	v, err := parseMessage(json.RawMessage(`{
+		"event_name": "level_end",
+		"event_params": [
+			{"key": "level", "value": {"string_value": null, "int_value": 3, "double_value": null}},
+			{"key": "mode", "value": {"string_value": "hard", "int_value": null}},
+			{"key": "unset", "value": {"string_value": null}}
+		],
+		"user_properties": [{"key": "plan", "value": {"string_value": "pro"}}],
+		"items": [{"key": "not", "value": "pivoted"}]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []flatField{
+		{name: "event_name", value: "level_end"},
+		{name: "event_params_level", value: json.Number("3")},
+		{name: "event_params_mode", value: "hard"},
+		{name: "event_params_unset", value: nil},
+		{name: "items", value: `[{"key":"not","value":"pivoted"}]`, json: true},
+		{name: "user_properties_plan", value: "pro"},
+	}
+	if got := (flattener{separator: "_", firebase: true}).flatten(v); !reflect.DeepEqual(got, want) {
+		t.Fatalf("flattened to %+v, want %+v", got, want)
+	}
+
+	// Arrays that aren't key-value entries are flattened as usual
+	if _, ok := firebaseParams([]interface{}{map[string]interface{}{"value": 1}}); ok {
+		t.Fatal("pivoted an entry without a key")
+	}
+}
+
+func TestFlattenerItems(t *testing.T) {
+	v, err := parseMessage(json.RawMessage(`{"items":[{"item_id":"sku1"}],"tags":["a"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []flatField{
+		{name: "items", value: `[{"item_id":"sku1"}]`, json: true},
+		{name: "tags.0", value: "a"},
+	}
+	if got := (flattener{separator: ".", indexArrays: true, itemsJSON: true}).flatten(v); !reflect.DeepEqual(got, want) {
+		t.Fatalf("flattened to %+v, want %+v", got, want)
+	}
+
+	// An exploded item has its item_params pivoted
+	v, err = parseMessage(json.RawMessage(`{"items":{"item_id":"sku1","item_params":[{"key":"rarity","value":{"string_value":"epic"}}]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []flatField{
+		{name: "items.item_id", value: "sku1"},
+		{name: "items.item_params.rarity", value: "epic"},
+	}
+	if got := (flattener{separator: ".", firebase: true}).flatten(v); !reflect.DeepEqual(got, want) {
+		t.Fatalf("flattened to %+v, want %+v", got, want)
+	}
+}
+
+func TestFlattenerFirebaseBlocks(t *testing.T) {
+	v, err := parseMessage(json.RawMessage(`{"user_ltv":{"currency":"EUR","revenue":1.5,"extra":1},"event_dimensions":null}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []flatField{
+		{name: "event_dimensions.hostname", value: nil},
+		{name: "user_ltv.revenue", value: json.Number("1.5")},
+		{name: "user_ltv.currency", value: "EUR"},
+		{name: "user_ltv.extra", value: json.Number("1")},
+	}
+	if got := (flattener{separator: ".", firebase: true}).flatten(v); !reflect.DeepEqual(got, want) {
+		t.Fatalf("flattened to %+v, want %+v", got, want)
+	}
+}
+
+func TestFlattenerMaxDepth(t *testing.T) {
+	v, err := parseMessage(json.RawMessage(`{"id":1,"payload":{"level":3,"progress":{"stage":2,"stars":[1,2]}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []flatField{
+		{name: "id", value: json.Number("1")},
+		{name: "payload.level", value: json.Number("3")},
+		{name: "payload.progress", value: `{"stage":2,"stars":[1,2]}`, json: true},
+	}
+	if got := (flattener{separator: ".", maxDepth: 2}).flatten(v); !reflect.DeepEqual(got, want) {
+		t.Fatalf("flattened to %+v, want %+v", got, want)
+	}
+
+	want = []flatField{
+		{name: "id", value: json.Number("1")},
+		{name: "payload.level", value: json.Number("3")},
+		{name: "payload.progress.stage", value: json.Number("2")},
+		{name: "payload.progress.stars", value: "[1,2]", json: true},
+	}
+	if got := (flattener{separator: ".", indexArrays: true, maxDepth: 3}).flatten(v); !reflect.DeepEqual(got, want) {
+		t.Fatalf("flattened to %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+func runFunnel(args []string) {
+	fs := flag.NewFlagSet("funnel", flag.ExitOnError)
+	steps := fs.String("steps", "", "Comma-separated event names of the funnel's steps, in order, e.g. level_start,level_complete,iap_purchase")
+	eventField := fs.String("event-field", "event_name", "Field path of the event name the steps are matched against")
+	window := fs.Duration("window", 0, "Time from a user's first step within which the others must follow, e.g. 24h (0 for no limit)")
+	format := fs.String("format", "text", "Report format: text, csv or json")
+	events := addEventFlags(fs)
+	csvOutput := addCSVReportFlags(fs)
+	logging := addLogFlags(fs)
+	parseFlags(fs, args)
+	logging.setup()
+
+	if *steps == "" || fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: avroparser funnel -steps <event,event,...> [-window 24h] [-format text|csv|json] <avro_or_ndjson_file|dir|glob|->...")
+		os.Exit(exitFatal)
+	}
+	dialect, err := csvOutput.dialect()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	if *format != "text" && *format != "csv" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "Unknown report format %q (expected text, csv or json)\n", *format)
+		os.Exit(exitFatal)
+	}
+	if *window < 0 {
+		fmt.Fprintf(os.Stderr, "-window must not be negative, got %v\n", *window)
+		os.Exit(exitFatal)
+	}
+	names := splitFieldList(*steps)
+	index := make(map[string]int, len(names))
+	for i, name := range names {
+		if name == "" {
+			fmt.Fprintln(os.Stderr, "-steps must not have empty step names")
+			os.Exit(exitFatal)
+		}
+		if _, ok := index[name]; ok {
+			fmt.Fprintf(os.Stderr, "Step %s is given twice\n", name)
+			os.Exit(exitFatal)
+		}
+		index[name] = i
+	}
+	if *eventField == "" || strings.HasPrefix(*eventField, ".") || strings.HasSuffix(*eventField, ".") || strings.Contains(*eventField, "..") {
+		fmt.Fprintf(os.Stderr, "Invalid -event-field %q\n", *eventField)
+		os.Exit(exitFatal)
+	}
+	eventPath := strings.Split(*eventField, ".")
+	source, err := events.source()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+
+	var inputs []inputFile
+	for _, arg := range fs.Args() {
+		inputs = append(inputs, mustExpandInputs(arg)...)
+	}
+
+	// Only the events of a step are kept, by user
+	users := make(map[string][]funnelEvent)
+	failed, skipped := source.read(inputs, func(user string, t time.Time, event interface{}) {
+		name, _ := lookupPath(event, eventPath).(string)
+		if step, ok := index[name]; ok {
+			users[user] = append(users[user], funnelEvent{t, step})
+		}
+	})
+	if failed == len(inputs) {
+		os.Exit(exitFatal)
+	}
+	if skipped > 0 {
+		slog.Warn("Left out events without a user or time", "events", skipped)
+	}
+
+	var report funnelReport
+	if *window > 0 {
+		report.Window = window.String()
+	}
+	reached := make([]int, len(names))
+	for _, userEvents := range users {
+		for step := 0; step < funnelReach(userEvents, len(names), *window); step++ {
+			reached[step]++
+		}
+	}
+	for i, name := range names {
+		step := funnelStep{Step: name, Users: reached[i]}
+		if i > 0 {
+			step.Dropped = reached[i-1] - reached[i]
+			step.FromPrevious = funnelRate(reached[i], reached[i-1])
+		}
+		step.FromStart = funnelRate(reached[i], reached[0])
+		report.Steps = append(report.Steps, step)
+	}
+
+	switch *format {
+	case "csv":
+		err = writeFunnelCSV(os.Stdout, report, dialect)
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(report)
+	default:
+		err = writeFunnelText(os.Stdout, report)
+	}
+	if err != nil {
+		slog.Error("Cannot write report", "error", err)
+		os.Exit(exitFatal)
+	}
+	if failed > 0 {
+		os.Exit(exitPartial)
+	}
+}
+
+// funnelEvent is a user's event of a funnel step.
+type funnelEvent struct {
+	time time.Time
+	step int
+}
+
+// funnelReach returns how many steps of the funnel a user's events reach:
+// the steps have to follow each other in order, each no earlier than the
+// one before, and within window of the first step when window isn't 0.
+// The events are read once in time order, keeping for each step reached so
+// far the latest start it was reached from, which leaves the most of the
+// window for the steps after it.
+func funnelReach(events []funnelEvent, steps int, window time.Duration) int {
+	sort.SliceStable(events, func(i, j int) bool { return events[i].time.Before(events[j].time) })
+	starts := make([]time.Time, steps)
+	reach := 0
+	for _, e := range events {
+		switch {
+		case e.step == 0:
+			starts[0] = e.time
+		case e.step > reach:
+			// The step before hasn't been reached yet
+			continue
+		case window > 0 && e.time.Sub(starts[e.step-1]) > window:
+			continue
+		case e.step == reach || starts[e.step-1].After(starts[e.step]):
+			starts[e.step] = starts[e.step-1]
+		}
+		reach = max(reach, e.step+1)
+		if reach == steps {
+			break
+		}
+	}
+	return reach
+}
+
+// funnelRate is the percentage of users that went on, or 0 when no users
+// got as far as the base step.
+func funnelRate(users, base int) float64 {
+	if base == 0 {
+		return 0
+	}
+	return float64(users) * 100 / float64(base)
+}
+
+// funnelReport is the conversion of a funnel's steps.
+type funnelReport struct {
+	Window string       `json:"window,omitempty"`
+	Steps  []funnelStep `json:"steps"`
+}
+
+type funnelStep struct {
+	Step         string  `json:"step"`
+	Users        int     `json:"users"`         // users reaching the step
+	FromStart    float64 `json:"from_start"`    // percentage of the first step's users
+	FromPrevious float64 `json:"from_previous"` // percentage of the previous step's users
+	Dropped      int     `json:"dropped"`       // users reaching the previous step but not this one
+}
+
+func writeFunnelText(w io.Writer, report funnelReport) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "Step\tUsers\tFrom start\tFrom previous\tDropped\t")
+	for i, step := range report.Steps {
+		previous := "-"
+		if i > 0 {
+			previous = fmt.Sprintf("%.1f%%", step.FromPrevious)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%.1f%%\t%s\t%s\t\n", step.Step, formatCount(int64(step.Users)), step.FromStart, previous, formatCount(int64(step.Dropped)))
+	}
+	return tw.Flush()
+}
+
+func writeFunnelCSV(w io.Writer, report funnelReport, dialect csvDialect) error {
+	cw := newCSVWriter(w, dialect)
+	cw.Write([]string{"step", "users", "from_start", "from_previous", "dropped"})
+	for _, step := range report.Steps {
+		cw.Write([]string{
+			step.Step,
+			strconv.Itoa(step.Users),
+			strconv.FormatFloat(step.FromStart, 'f', 2, 64),
+			strconv.FormatFloat(step.FromPrevious, 'f', 2, 64),
+			strconv.Itoa(step.Dropped),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestFunnelReach(t *testing.T) {
+	start := time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC)
+	at := func(hours int, step int) funnelEvent {
+		return funnelEvent{start.Add(time.Duration(hours) * time.Hour), step}
+	}
+	for _, tt := range []struct {
+		name   string
+		events []funnelEvent
+		window time.Duration
+		want   int
+	}{
+		{"all steps", []funnelEvent{at(0, 0), at(1, 1), at(2, 2)}, 0, 3},
+		{"out of order", []funnelEvent{at(2, 1), at(1, 0), at(3, 2)}, 0, 3},
+		{"step skipped", []funnelEvent{at(0, 0), at(1, 2)}, 0, 1},
+		{"second step before first", []funnelEvent{at(0, 1), at(1, 0)}, 0, 1},
+		{"no first step", []funnelEvent{at(0, 1), at(1, 2)}, 0, 0},
+		{"outside window", []funnelEvent{at(0, 0), at(1, 1), at(30, 2)}, 24 * time.Hour, 2},
+		{"later start within window", []funnelEvent{at(0, 0), at(1, 1), at(30, 0), at(31, 1), at(32, 2)}, 24 * time.Hour, 3},
+		{"latest start of a step", []funnelEvent{at(0, 0), at(5, 0), at(10, 1), at(28, 2)}, 24 * time.Hour, 3},
+		{"start after a step", []funnelEvent{at(0, 0), at(1, 1), at(20, 0), at(30, 2)}, 24 * time.Hour, 2},
+		{"repeated steps", []funnelEvent{at(0, 0), at(1, 1), at(2, 1), at(3, 0), at(4, 2)}, 0, 3},
+	} {
+		if got := funnelReach(tt.events, 3, tt.window); got != tt.want {
+			t.Errorf("%s: reached %d steps, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	// A user with many starts is read in one pass
+	var events []funnelEvent
+	for i := 0; i < 100000; i++ {
+		events = append(events, at(i, 0))
+	}
+	events = append(events, at(100000, 1), at(100001, 2))
+	if got := funnelReach(events, 3, time.Hour); got != 2 {
+		t.Errorf("reached %d steps of many starts, want 2", got)
+	}
+}
+
+func TestWriteFunnel(t *testing.T) {
+	report := funnelReport{Steps: []funnelStep{
+		{Step: "level_start", Users: 1000, FromStart: 100},
+		{Step: "iap_purchase", Users: 25, FromStart: 2.5, FromPrevious: 2.5, Dropped: 975},
+	}}
+
+	var buf bytes.Buffer
+	if err := writeFunnelCSV(&buf, report, csvDialect{}); err != nil {
+		t.Fatal(err)
+	}
+	want := "step,users,from_start,from_previous,dropped\n" +
+		"level_start,1000,100.00,0.00,0\n" +
+		"iap_purchase,25,2.50,2.50,975\n"
+	if buf.String() != want {
+		t.Fatalf("wrote %q, want %q", buf.String(), want)
+	}
+
+	buf.Reset()
+	if err := writeFunnelText(&buf, report); err != nil {
+		t.Fatal(err)
+	}
+	want = "          Step  Users  From start  From previous  Dropped\n" +
+		"   level_start  1,000      100.0%              -        0\n" +
+		"  iap_purchase     25        2.5%           2.5%      975\n"
+	if buf.String() != want {
+		t.Fatalf("wrote %q, want %q", buf.String(), want)
+	}
+}
@@ -0,0 +1,505 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// gcsScheme prefixes Google Cloud Storage paths, e.g. gs://bucket/exports/.
+const gcsScheme = "gs://"
+
+func isGCSPath(p string) bool {
+	return strings.HasPrefix(p, gcsScheme)
+}
+
+// splitGCSPath splits gs://bucket/object into its bucket and object name.
+func splitGCSPath(p string) (bucket, object string) {
+	bucket, object, _ = strings.Cut(strings.TrimPrefix(p, gcsScheme), "/")
+	return bucket, object
+}
+
+// gcs talks to the Cloud Storage JSON API over plain HTTP. Set
+// STORAGE_EMULATOR_HOST to use an emulator such as fake-gcs-server.
+var gcs = newGCSClient()
+
+type gcsClient struct {
+	endpoint string // base URL of the JSON API
+	emulator bool   // emulators don't need credentials
+	http     *http.Client
+}
+
+// googleAuth provides the access token for Google Cloud APIs, shared by
+// Cloud Storage and BigQuery.
+var googleAuth = &googleCredentials{}
+
+// googleScope is the OAuth scope tokens are requested for, which covers
+// Cloud Storage and BigQuery.
+const googleScope = "https://www.googleapis.com/auth/cloud-platform"
+
+type googleCredentials struct {
+	mu     sync.Mutex
+	source oauth2.TokenSource
+}
+
+func newGCSClient() *gcsClient {
+	c := &gcsClient{endpoint: "https://storage.googleapis.com", http: &http.Client{}}
+	if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+		if !strings.Contains(host, "://") {
+			host = "http://" + host
+		}
+		c.endpoint, c.emulator = strings.TrimSuffix(host, "/"), true
+	}
+	return c
+}
+
+// accessToken returns an OAuth access token from GOOGLE_OAUTH_ACCESS_TOKEN,
+// Application Default Credentials (a GOOGLE_APPLICATION_CREDENTIALS key
+// file, the gcloud auth application-default login credentials, or the
+// metadata server on Google Cloud), or the gcloud CLI, in that order.
+// Tokens are reused until shortly before they expire.
+func (c *googleCredentials) accessToken() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.source == nil {
+		source, err := googleTokenSource()
+		if err != nil {
+			return "", err
+		}
+		c.source = source
+	}
+	token, err := c.source.Token()
+	if err != nil {
+		return "", fmt.Errorf("cannot get Google Cloud access token: %w", err)
+	}
+	return token.AccessToken, nil
+}
+
+func googleTokenSource() (oauth2.TokenSource, error) {
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}), nil
+	}
+
+	creds, err := google.FindDefaultCredentials(context.Background(), googleScope)
+	if err == nil {
+		return oauth2.ReuseTokenSource(nil, creds.TokenSource), nil
+	}
+
+	if _, lookErr := exec.LookPath("gcloud"); lookErr == nil {
+		return oauth2.ReuseTokenSource(nil, gcloudTokenSource{}), nil
+	}
+	return nil, fmt.Errorf("no Google Cloud credentials found (set GOOGLE_APPLICATION_CREDENTIALS, run gcloud auth application-default login or set GOOGLE_OAUTH_ACCESS_TOKEN): %w", err)
+}
+
+// gcloudTokenSource gets tokens from gcloud auth print-access-token, for
+// users logged in to the gcloud CLI without application default
+// credentials.
+type gcloudTokenSource struct{}
+
+func (gcloudTokenSource) Token() (*oauth2.Token, error) {
+	out, err := exec.Command("gcloud", "auth", "print-access-token").Output()
+	if err != nil {
+		return nil, fmt.Errorf("cannot get access token from gcloud: %w", err)
+	}
+	// gcloud tokens are valid for an hour
+	return &oauth2.Token{AccessToken: strings.TrimSpace(string(out)), Expiry: time.Now().Add(50 * time.Minute)}, nil
+}
+
+// do sends an authenticated request and turns error responses into errors.
+// The caller closes the response body.
+func (c *gcsClient) do(req *http.Request) (*http.Response, error) {
+	if err := c.authorize(req); err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+	return nil, statusError(resp)
+}
+
+// authorize adds the access token to a request, unless talking to an
+// emulator.
+func (c *gcsClient) authorize(req *http.Request) error {
+	if c.emulator {
+		return nil
+	}
+	token, err := googleAuth.accessToken()
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// statusError reads an error response and closes its body. It returns
+// errObjectNotFound for 404s and a *googleStatusError otherwise.
+func statusError(resp *http.Response) error {
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errObjectNotFound
+	}
+	var apiErr struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	message := resp.Status
+	if json.NewDecoder(resp.Body).Decode(&apiErr) == nil && apiErr.Error.Message != "" {
+		message += ": " + apiErr.Error.Message
+	}
+	return &googleStatusError{status: resp.StatusCode, message: message}
+}
+
+func (c *gcsClient) objectURL(bucket, object string) string {
+	return fmt.Sprintf("%s/storage/v1/b/%s/o/%s", c.endpoint, url.PathEscape(bucket), url.PathEscape(object))
+}
+
+// exists reports whether an object exists.
+func (c *gcsClient) exists(bucket, object string) (bool, error) {
+	req, err := http.NewRequest(http.MethodGet, c.objectURL(bucket, object)+"?fields=name", nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := c.do(req)
+	if errors.Is(err, errObjectNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return true, nil
+}
+
+// remove deletes an object.
+func (c *gcsClient) remove(p string) error {
+	bucket, object := splitGCSPath(p)
+	req, err := http.NewRequest(http.MethodDelete, c.objectURL(bucket, object), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// list returns the names of all objects starting with prefix, in
+// lexicographic order.
+func (c *gcsClient) list(bucket, prefix string) ([]string, error) {
+	var names []string
+	pageToken := ""
+	for {
+		query := url.Values{"prefix": {prefix}, "fields": {"items(name),nextPageToken"}}
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/storage/v1/b/%s/o?%s", c.endpoint, url.PathEscape(bucket), query.Encode()), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.do(req)
+		if err != nil {
+			return nil, fmt.Errorf("cannot list %s%s/%s: %w", gcsScheme, bucket, prefix, err)
+		}
+
+		var page struct {
+			Items []struct {
+				Name string `json:"name"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("cannot list %s%s/%s: %w", gcsScheme, bucket, prefix, err)
+		}
+
+		for _, item := range page.Items {
+			names = append(names, item.Name)
+		}
+		if page.NextPageToken == "" {
+			return names, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+// open streams an object's contents.
+func (c *gcsClient) open(p string) (io.ReadCloser, error) {
+	bucket, object := splitGCSPath(p)
+	req, err := http.NewRequest(http.MethodGet, c.objectURL(bucket, object)+"?alt=media", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open %s: %w", p, err)
+	}
+	return resp.Body, nil
+}
+
+// openRange streams part of an object's contents, as objectStore describes.
+func (c *gcsClient) openRange(p string, offset, length int64) (io.ReadCloser, error) {
+	bucket, object := splitGCSPath(p)
+	req, err := http.NewRequest(http.MethodGet, c.objectURL(bucket, object)+"?alt=media", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", byteRangeHeader(offset, length))
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open %s: %w", p, err)
+	}
+	return resp.Body, nil
+}
+
+// gcsChunkSize is how much of an upload is buffered and sent at a time. It
+// must be a multiple of 256 KiB.
+const gcsChunkSize = 16 << 20
+
+// gcsAttempts is how often a failed upload request is sent.
+const gcsAttempts = 5
+
+// gcsRetryWait is how long to wait before sending a failed upload request
+// again the first time. The wait doubles with each attempt.
+var gcsRetryWait = time.Second
+
+// create starts uploading an object. Data is sent in chunks with a
+// resumable upload, so a chunk that fails is sent again from where the
+// server stopped rather than failing the whole object, and the upload
+// completes when the writer is closed.
+func (c *gcsClient) create(p string) (io.WriteCloser, error) {
+	bucket, object := splitGCSPath(p)
+	if bucket == "" || object == "" {
+		return nil, fmt.Errorf("invalid Cloud Storage path %q", p)
+	}
+	return &gcsWriter{client: c, bucket: bucket, object: object, path: p}, nil
+}
+
+// gcsWriter is an object upload in progress. buf holds what has been
+// written past offset, the bytes the server has stored so far.
+type gcsWriter struct {
+	client  *gcsClient
+	bucket  string
+	object  string
+	path    string
+	session string // URL of the resumable upload, once started
+	buf     []byte
+	offset  int64
+	closed  bool
+	err     error
+}
+
+func (w *gcsWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	written := 0
+	for len(p) > 0 {
+		// The buffer grows as needed, as small objects are common
+		n := min(len(p), gcsChunkSize-len(w.buf))
+		w.buf = append(w.buf, p[:n]...)
+		p = p[n:]
+		written += n
+		if len(w.buf) == gcsChunkSize {
+			if err := w.send(false); err != nil {
+				w.err = fmt.Errorf("cannot upload %s: %w", w.path, err)
+				return written, w.err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Close finishes the upload and reports whether it succeeded. It is safe to
+// call more than once.
+func (w *gcsWriter) Close() error {
+	if w.closed {
+		return w.err
+	}
+	w.closed = true
+	if w.err != nil {
+		w.cancel()
+		return w.err
+	}
+	if err := w.send(true); err != nil {
+		w.err = fmt.Errorf("cannot upload %s: %w", w.path, err)
+		w.cancel()
+	}
+	return w.err
+}
+
+// abort cancels the upload, so no object is created.
+func (w *gcsWriter) abort() {
+	if w.closed {
+		return
+	}
+	w.closed = true
+	w.err = fmt.Errorf("upload of %s aborted", w.path)
+	w.cancel()
+}
+
+// cancel deletes the upload session, dropping what was sent.
+func (w *gcsWriter) cancel() {
+	if w.session == "" {
+		return
+	}
+	req, err := http.NewRequest(http.MethodDelete, w.session, nil)
+	if err != nil || w.client.authorize(req) != nil {
+		return
+	}
+	if resp, err := w.client.http.Do(req); err == nil {
+		resp.Body.Close()
+	}
+}
+
+// send uploads the buffer, completing the object if final. Failed requests
+// are sent again with exponential backoff, from whatever the server says
+// it has stored.
+func (w *gcsWriter) send(final bool) error {
+	wait := gcsRetryWait
+	for attempt := 1; ; attempt++ {
+		err := w.flush(final)
+		if err == nil || attempt == gcsAttempts || !retryable(err) {
+			return err
+		}
+		time.Sleep(wait)
+		wait *= 2
+		if done, err := w.query(); err == nil && done {
+			return nil
+		}
+	}
+}
+
+// flush sends the buffer until the server has stored all of it.
+func (w *gcsWriter) flush(final bool) error {
+	for {
+		pending := len(w.buf)
+		done, err := w.put(final)
+		switch {
+		case err != nil:
+			return err
+		case done || !final && len(w.buf) == 0:
+			return nil
+		case len(w.buf) == pending:
+			return errors.New("the server stored none of the data sent")
+		}
+	}
+}
+
+// start begins a resumable upload.
+func (w *gcsWriter) start() error {
+	query := url.Values{"uploadType": {"resumable"}, "name": {w.object}}
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/upload/storage/v1/b/%s/o?%s", w.client.endpoint, url.PathEscape(w.bucket), query.Encode()), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Upload-Content-Type", "application/octet-stream")
+	resp, err := w.client.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if w.session = resp.Header.Get("Location"); w.session == "" {
+		return errors.New("no upload session in the response")
+	}
+	return nil
+}
+
+// put sends the buffer as the next chunk, and reports whether the object
+// is complete.
+func (w *gcsWriter) put(final bool) (bool, error) {
+	if w.session == "" {
+		if err := w.start(); err != nil {
+			return false, err
+		}
+	}
+
+	end := w.offset + int64(len(w.buf))
+	total := "*"
+	if final {
+		total = strconv.FormatInt(end, 10)
+	}
+	contentRange := fmt.Sprintf("bytes %d-%d/%s", w.offset, end-1, total)
+	if len(w.buf) == 0 {
+		contentRange = "bytes */" + total
+	}
+	req, err := http.NewRequest(http.MethodPut, w.session, bytes.NewReader(w.buf))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Range", contentRange)
+	return w.exchange(req)
+}
+
+// query asks the server how much of the upload it has stored, and reports
+// whether the object is complete.
+func (w *gcsWriter) query() (bool, error) {
+	if w.session == "" {
+		return false, nil
+	}
+	req, err := http.NewRequest(http.MethodPut, w.session, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Range", "bytes */*")
+	return w.exchange(req)
+}
+
+// exchange sends a request of the upload session. A 308 response carries
+// the range the server has stored, and the buffer is trimmed to what it
+// still needs.
+func (w *gcsWriter) exchange(req *http.Request) (bool, error) {
+	if err := w.client.authorize(req); err != nil {
+		return false, err
+	}
+	resp, err := w.client.http.Do(req)
+	if err != nil {
+		return false, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		resp.Body.Close()
+		w.offset += int64(len(w.buf))
+		w.buf = w.buf[:0]
+		return true, nil
+	case http.StatusPermanentRedirect:
+		resp.Body.Close()
+		stored := int64(0)
+		if _, last, ok := strings.Cut(resp.Header.Get("Range"), "-"); ok {
+			if n, err := strconv.ParseInt(last, 10, 64); err == nil {
+				stored = n + 1
+			}
+		}
+		if drop := stored - w.offset; drop > 0 && drop <= int64(len(w.buf)) {
+			n := copy(w.buf, w.buf[drop:])
+			w.buf = w.buf[:n]
+			w.offset = stored
+		}
+		return false, nil
+	}
+	return false, statusError(resp)
+}
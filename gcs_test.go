@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeBucket serves the parts of the Cloud Storage JSON API the client uses
+// for a single bucket named "bucket". Listings return two objects per page.
+type fakeBucket struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	uploads map[string][]byte // of resumable uploads in progress
+}
+
+func (b *fakeBucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	const objects = "/storage/v1/b/bucket/o"
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == objects:
+		var names []string
+		for name := range b.objects {
+			if strings.HasPrefix(name, r.URL.Query().Get("prefix")) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		start := 0
+		if token := r.URL.Query().Get("pageToken"); token != "" {
+			start = sort.SearchStrings(names, token)
+		}
+		var page struct {
+			Items         []map[string]string `json:"items"`
+			NextPageToken string              `json:"nextPageToken,omitempty"`
+		}
+		for i := start; i < len(names) && i < start+2; i++ {
+			page.Items = append(page.Items, map[string]string{"name": names[i]})
+		}
+		if start+2 < len(names) {
+			page.NextPageToken = names[start+2]
+		}
+		json.NewEncoder(w).Encode(page)
+
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, objects+"/"):
+		data, ok := b.objects[strings.TrimPrefix(r.URL.Path, objects+"/")]
+		if !ok {
+			http.Error(w, `{"error":{"message":"No such object"}}`, http.StatusNotFound)
+			return
+		}
+		if r.URL.Query().Get("alt") == "media" {
+			w.Write(data)
+		} else {
+			w.Write([]byte(`{}`))
+		}
+
+	case r.Method == http.MethodPost && r.URL.Path == "/upload"+objects:
+		// Uploads are resumable, with the object stored once it is complete
+		name := r.URL.Query().Get("name")
+		b.uploads[name] = nil
+		w.Header().Set("Location", "http://"+r.Host+"/session?"+url.Values{"name": {name}}.Encode())
+
+	case r.Method == http.MethodPut && r.URL.Path == "/session":
+		name := r.URL.Query().Get("name")
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		b.uploads[name] = append(b.uploads[name], data...)
+		_, total, _ := strings.Cut(r.Header.Get("Content-Range"), "/")
+		if total != strconv.Itoa(len(b.uploads[name])) {
+			if n := len(b.uploads[name]); n > 0 {
+				w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", n-1))
+			}
+			w.WriteHeader(http.StatusPermanentRedirect)
+			return
+		}
+		b.objects[name] = b.uploads[name]
+		delete(b.uploads, name)
+		w.Write([]byte(`{}`))
+
+	default:
+		http.Error(w, `{"error":{"message":"Bucket not found"}}`, http.StatusNotFound)
+	}
+}
+
+// useFakeGCS points the Cloud Storage client at a fake bucket holding
+// objects for the rest of the test.
+func useFakeGCS(t *testing.T, objects map[string][]byte) *fakeBucket {
+	t.Helper()
+	b := &fakeBucket{objects: objects, uploads: map[string][]byte{}}
+	srv := httptest.NewServer(b)
+	t.Cleanup(srv.Close)
+
+	saved := gcs
+	gcs = &gcsClient{endpoint: srv.URL, emulator: true, http: srv.Client()}
+	t.Cleanup(func() { gcs = saved })
+	return b
+}
+
+func TestExpandGCSInputs(t *testing.T) {
+	useFakeGCS(t, map[string][]byte{
+		"exports/a.avro":       nil,
+		"exports/2026/b.AVRO":  nil,
+		"exports/2026/c.avro":  nil,
+		"exports/notes.txt":    nil,
+		"exports-old/d.avro":   nil,
+		"single/events.1.avro": nil,
+	})
+
+	for _, tc := range []struct {
+		input string
+		want  []inputFile
+	}{
+		{"gs://bucket/single/events.1.avro", []inputFile{{path: "gs://bucket/single/events.1.avro", rel: "events.1.avro"}}},
+		{"gs://bucket/exports", []inputFile{
+			{path: "gs://bucket/exports/2026/b.AVRO", rel: "2026/b.AVRO"},
+			{path: "gs://bucket/exports/2026/c.avro", rel: "2026/c.avro"},
+			{path: "gs://bucket/exports/a.avro", rel: "a.avro"},
+		}},
+		{"gs://bucket/exports/*/*.avro", []inputFile{{path: "gs://bucket/exports/2026/c.avro", rel: "2026/c.avro"}}},
+		{"gs://bucket/missing/", nil},
+	} {
+		got, err := expandStoreInputs(gcs, gcsScheme, tc.input)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.input, err)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: expanded to %+v, want %+v", tc.input, got, tc.want)
+		}
+	}
+}
+
+func TestGCSOutputRoundTrip(t *testing.T) {
+	bucket := useFakeGCS(t, map[string][]byte{})
+
+	path := outputPath(inputFile{rel: "2026/a.avro"}, "gs://bucket/decoded/", "json")
+	if path != "gs://bucket/decoded/2026/a.json" {
+		t.Fatalf("output path %s", path)
+	}
+	out, err := openOutput(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(out, `[{"id":1}]`); err != nil {
+		t.Fatal(err)
+	}
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(bucket.objects["decoded/2026/a.json"]); got != `[{"id":1}]` {
+		t.Fatalf("uploaded %q", got)
+	}
+
+	in, err := openInput(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	if data, err := io.ReadAll(in); err != nil || string(data) != `[{"id":1}]` {
+		t.Fatalf("read %q, %v", data, err)
+	}
+
+	if _, err := openInput("gs://bucket/missing.avro"); err == nil {
+		t.Fatal("opened a missing object")
+	}
+}
+
+// fakeUpload is a Cloud Storage server for one resumable upload. It stores
+// at most storeLimit bytes of each chunk, and fails the requests numbered
+// in fail with a 503.
+type fakeUpload struct {
+	t          *testing.T
+	storeLimit int
+
+	mu        sync.Mutex
+	requests  int
+	fail      map[int]bool
+	started   int
+	stored    []byte
+	complete  bool
+	cancelled bool
+}
+
+func (f *fakeUpload) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests++
+	if f.fail[f.requests] {
+		io.Copy(io.Discard, r.Body)
+		http.Error(w, `{"error":{"message":"try again"}}`, http.StatusServiceUnavailable)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/upload/storage/v1/b/bucket/o":
+		if r.URL.Query().Get("uploadType") != "resumable" || r.URL.Query().Get("name") != "dir/out.json" {
+			f.t.Errorf("upload started with %s", r.URL.RawQuery)
+		}
+		f.started++
+		w.Header().Set("Location", "http://"+r.Host+"/session")
+	case r.Method == http.MethodPut && r.URL.Path == "/session":
+		f.put(w, r)
+	case r.Method == http.MethodDelete && r.URL.Path == "/session":
+		f.cancelled = true
+		w.WriteHeader(499)
+	default:
+		f.t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		http.NotFound(w, r)
+	}
+}
+
+// put handles a chunk, or a query with an empty body, as the service does.
+func (f *fakeUpload) put(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		f.t.Error(err)
+		return
+	}
+	span, total, ok := strings.Cut(strings.TrimPrefix(r.Header.Get("Content-Range"), "bytes "), "/")
+	if !ok {
+		f.t.Errorf("bad Content-Range %q", r.Header.Get("Content-Range"))
+		http.Error(w, "bad range", http.StatusBadRequest)
+		return
+	}
+	if span != "*" {
+		first, _, _ := strings.Cut(span, "-")
+		start, _ := strconv.Atoi(first)
+		if start > len(f.stored) {
+			f.t.Errorf("chunk at %d sent with %d bytes stored", start, len(f.stored))
+			http.Error(w, "gap", http.StatusBadRequest)
+			return
+		}
+		body = body[len(f.stored)-start:]
+		if total == "*" && len(body) > f.storeLimit {
+			body = body[:f.storeLimit]
+		}
+		f.stored = append(f.stored, body...)
+	}
+	if total != "*" && total == strconv.Itoa(len(f.stored)) {
+		f.complete = true
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if len(f.stored) > 0 {
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(f.stored)-1))
+	}
+	w.WriteHeader(http.StatusPermanentRedirect)
+}
+
+func testUpload(t *testing.T, f *fakeUpload, data []byte) (*gcsWriter, error) {
+	t.Helper()
+	wait := gcsRetryWait
+	gcsRetryWait = time.Millisecond
+	t.Cleanup(func() { gcsRetryWait = wait })
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	client := &gcsClient{endpoint: srv.URL, emulator: true, http: srv.Client()}
+	out, err := client.create("gs://bucket/dir/out.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := out.(*gcsWriter)
+	// Written in pieces that don't line up with chunks
+	for p := data; len(p) > 0; {
+		n := min(len(p), 3<<20+17)
+		if _, err := w.Write(p[:n]); err != nil {
+			return w, err
+		}
+		p = p[n:]
+	}
+	return w, w.Close()
+}
+
+func TestGCSResumableUpload(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), (2*gcsChunkSize+12345)/16)
+	for _, tc := range []struct {
+		name  string
+		limit int
+		fail  map[int]bool
+	}{
+		{"whole chunks", gcsChunkSize, nil},
+		{"partial chunks", gcsChunkSize / 4, nil},
+		// The second request is the first chunk, the fourth a chunk sent
+		// again after a query
+		{"failures", gcsChunkSize / 2, map[int]bool{2: true, 4: true}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := &fakeUpload{t: t, storeLimit: tc.limit, fail: tc.fail}
+			if _, err := testUpload(t, f, data); err != nil {
+				t.Fatal(err)
+			}
+			if !f.complete || f.cancelled || f.started != 1 {
+				t.Fatalf("upload complete %v, cancelled %v, started %d times", f.complete, f.cancelled, f.started)
+			}
+			if !bytes.Equal(f.stored, data) {
+				t.Fatalf("stored %d bytes, want %d", len(f.stored), len(data))
+			}
+		})
+	}
+}
+
+func TestGCSSmallUpload(t *testing.T) {
+	for _, data := range [][]byte{nil, []byte("{}\n")} {
+		f := &fakeUpload{t: t, storeLimit: gcsChunkSize}
+		w, err := testUpload(t, f, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !f.complete || !bytes.Equal(f.stored, data) {
+			t.Fatalf("stored %q, complete %v", f.stored, f.complete)
+		}
+		if cap(w.buf) >= gcsChunkSize {
+			t.Fatalf("a %d byte upload buffered %d bytes", len(data), cap(w.buf))
+		}
+	}
+}
+
+func TestGCSUploadAbort(t *testing.T) {
+	f := &fakeUpload{t: t, storeLimit: gcsChunkSize}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	client := &gcsClient{endpoint: srv.URL, emulator: true, http: srv.Client()}
+	out, err := client.create("gs://bucket/dir/out.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := out.(*gcsWriter)
+	if _, err := w.Write(make([]byte, gcsChunkSize+1)); err != nil {
+		t.Fatal(err)
+	}
+	w.abort()
+	if err := w.Close(); err == nil {
+		t.Fatal("an aborted upload closed without an error")
+	}
+	if f.complete || !f.cancelled {
+		t.Fatalf("upload complete %v, cancelled %v", f.complete, f.cancelled)
+	}
+}
+
+func TestGCSUploadFails(t *testing.T) {
+	// Every request after the upload starts fails
+	fail := map[int]bool{}
+	for i := 2; i < 20; i++ {
+		fail[i] = true
+	}
+	f := &fakeUpload{t: t, storeLimit: gcsChunkSize, fail: fail}
+	if _, err := testUpload(t, f, []byte("data")); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Fatalf("upload returned %v, want the server's error", err)
+	}
+	if f.complete {
+		t.Fatal("a failed upload completed")
+	}
+}
+
+func TestGoogleTokenSource(t *testing.T) {
+	t.Setenv("GOOGLE_OAUTH_ACCESS_TOKEN", "static-token")
+	source, err := googleTokenSource()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token, err := source.Token(); err != nil || token.AccessToken != "static-token" {
+		t.Fatalf("token %+v, %v", token, err)
+	}
+
+	// A key file that can't be read is reported, without gcloud to fall
+	// back on
+	t.Setenv("GOOGLE_OAUTH_ACCESS_TOKEN", "")
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", filepath.Join(t.TempDir(), "missing.json"))
+	t.Setenv("PATH", "")
+	if _, err := googleTokenSource(); err == nil || !strings.Contains(err.Error(), "no Google Cloud credentials found") {
+		t.Errorf("found credentials: %v", err)
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// tailChunk is how much of a file tail reads at a time, searching backwards
+// for sync markers.
+const tailChunk = 64 << 10
+
+func runHead(args []string) {
+	previewCommand("head", args)
+}
+
+func runTail(args []string) {
+	previewCommand("tail", args)
+}
+
+// previewCommand prints the first (head) or last (tail) records of the
+// inputs, read as one sequence, without converting the rest of them.
+func previewCommand(name string, args []string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	inputPath := fs.String("input", "", "Input Avro or NDJSON file, directory or glob pattern, or - for stdin; more may be given as arguments")
+	n := fs.Int("n", 10, "Number of records to print")
+	pretty := fs.Bool("pretty", true, "Print each record as indented JSON; false prints NDJSON")
+	records := addRecordFlags(fs)
+	logging := addLogFlags(fs)
+	parseFlags(fs, args)
+	logging.setup()
+
+	paths := fs.Args()
+	if *inputPath != "" {
+		paths = append([]string{*inputPath}, paths...)
+	}
+	if len(paths) == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: avroparser %s [-n 10] [-pretty=true|false] <avro_file|dir|glob|->...\n", name)
+		os.Exit(exitFatal)
+	}
+	if *n < 0 {
+		fmt.Fprintf(os.Stderr, "-n must not be negative, got %d\n", *n)
+		os.Exit(exitFatal)
+	}
+	if *records.limit != 0 {
+		fmt.Fprintf(os.Stderr, "%s takes -n instead of -limit\n", name)
+		os.Exit(exitFatal)
+	}
+	if name == "tail" && (*records.offset != 0 || *records.count != 0 || *records.skip != 0 || *records.sampleRate != 1) {
+		fmt.Fprintln(os.Stderr, "tail cannot be combined with -offset, -count, -skip or -sample-rate, which count records from the start")
+		os.Exit(exitFatal)
+	}
+	if *records.onError == onErrorCollect {
+		fmt.Fprintf(os.Stderr, "%s cannot collect failed records; use -on-error skip or fail\n", name)
+		os.Exit(exitFatal)
+	}
+	opts := mustRecordOptions(records)
+
+	var inputs []inputFile
+	for _, path := range paths {
+		inputs = append(inputs, mustExpandInputs(path)...)
+	}
+	out := bufio.NewWriter(os.Stdout)
+	write := messageSink(func(msg json.RawMessage) error {
+		if *pretty {
+			var buf bytes.Buffer
+			if err := json.Indent(&buf, msg, "", "  "); err == nil {
+				msg = buf.Bytes()
+			}
+		}
+		out.Write(msg)
+		return out.WriteByte('\n')
+	})
+
+	var err error
+	if name == "head" {
+		err = headRecords(inputs, *n, opts, write)
+	} else {
+		err = tailInputs(inputs, *n, opts, write)
+	}
+	if err == nil {
+		err = out.Flush()
+	}
+	if err != nil {
+		slog.Error("Cannot print records", "error", err)
+		os.Exit(exitFatal)
+	}
+}
+
+// headRecords writes the first n records of the inputs to sink, decoding
+// each input only until the records are found.
+func headRecords(inputs []inputFile, n int, opts decodeOptions, sink messageSink) error {
+	written := 0
+	counted := messageSink(func(msg json.RawMessage) error {
+		written++
+		return sink(msg)
+	})
+	for _, in := range inputs {
+		if written >= n {
+			break
+		}
+		opts.sampling.limit = n - written
+		input, err := openDecodeInput(in.path, opts)
+		if err == nil {
+			_, err = decodeMessages(input, in.path, opts, counted)
+			input.Close()
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", displayPath(in.path), err)
+		}
+	}
+	return nil
+}
+
+// tailInputs writes the last n records of the inputs to sink, reading the
+// inputs from the last one back until it has them.
+func tailInputs(inputs []inputFile, n int, opts decodeOptions, sink messageSink) error {
+	var tail []json.RawMessage
+	for i := len(inputs) - 1; i >= 0 && len(tail) < n; i-- {
+		records, err := tailRecords(inputs[i].path, n-len(tail), opts)
+		if err != nil {
+			return fmt.Errorf("%s: %w", displayPath(inputs[i].path), err)
+		}
+		tail = append(records, tail...)
+	}
+	for _, msg := range tail {
+		if err := sink(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tailRecords returns the last n records of an input. Only the last blocks
+// of a local container file are decoded, found from its end; other inputs
+// are read from the start.
+func tailRecords(path string, n int, opts decodeOptions) ([]json.RawMessage, error) {
+	if n == 0 {
+		return nil, nil
+	}
+	for want := n; ; want *= 4 {
+		blocks, err := tailBlocks(path, want)
+		if err != nil {
+			slog.Debug("Reading the whole input for tail", "input", path, "reason", err)
+			return lastRecords(path, nil, n, opts)
+		}
+		records, err := lastRecords(path, &blocks, n, opts)
+		// Filtered and failed records may leave fewer than the blocks hold
+		if err != nil || len(records) >= n || blocks.start == blocks.header {
+			return records, err
+		}
+	}
+}
+
+// lastRecords decodes the given blocks of an input, or all of it when
+// blocks is nil, and returns the last n records.
+func lastRecords(path string, blocks *blockRange, n int, opts decodeOptions) ([]json.RawMessage, error) {
+	var records []json.RawMessage
+	keep := messageSink(func(msg json.RawMessage) error {
+		records = append(records, append(json.RawMessage(nil), msg...))
+		if len(records) > n {
+			records = records[1:]
+		}
+		return nil
+	})
+	opts.blocks = blocks
+	input, err := openDecodeInput(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer input.Close()
+	_, err = decodeMessages(input, path, opts, keep)
+	return records, err
+}
+
+// tailBlocks finds the last blocks of an uncompressed local container file
+// holding at least n records. It searches backwards from the end of the
+// file for the sync markers ending blocks, reading only those blocks'
+// headers, and checks each block's size matches the markers around it, as
+// data may contain the marker's bytes by chance. Data after the last
+// complete block, of a file being written, is left out.
+func tailBlocks(path string, n int) (blockRange, error) {
+	var blocks blockRange
+	f, err := os.Open(path)
+	if err != nil {
+		return blocks, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return blocks, err
+	}
+
+	counter := &countingReader{r: f}
+	r := bufio.NewReader(counter)
+	header, err := readOCFHeader(r)
+	if err != nil {
+		return blocks, err
+	}
+	blocks.header = counter.n - int64(r.Buffered())
+	blocks.start, blocks.end = blocks.header, blocks.header
+
+	markers := &markerScanner{r: f, marker: header.sync, min: blocks.header, pos: info.Size(), size: info.Size()}
+	end, ok, err := markers.prev()
+	if err != nil || !ok {
+		return blocks, err
+	}
+	blocks.start, blocks.end = end, end
+	for records := 0; records < n && blocks.start > blocks.header; {
+		start, ok, err := markers.prev()
+		if err != nil {
+			return blocks, err
+		}
+		if !ok {
+			start = blocks.header
+		}
+		count, valid, err := blockAt(f, start, blocks.start)
+		if err != nil {
+			return blocks, err
+		}
+		if !valid {
+			if !ok {
+				return blocks, errors.New("cannot find the blocks' boundaries")
+			}
+			// A marker inside a block's data; look further back
+			continue
+		}
+		records += count
+		blocks.start = start
+	}
+	return blocks, nil
+}
+
+// blockAt reports whether a block starting at start ends, with its sync
+// marker, at end, and returns its record count.
+func blockAt(f io.ReaderAt, start, end int64) (int, bool, error) {
+	var buf [2 * binary.MaxVarintLen64]byte
+	n, err := f.ReadAt(buf[:min(int64(len(buf)), end-start)], start)
+	if err != nil && err != io.EOF {
+		return 0, false, err
+	}
+	count, k := binary.Varint(buf[:n])
+	if k <= 0 || count < 0 {
+		return 0, false, nil
+	}
+	size, l := binary.Varint(buf[k:n])
+	if l <= 0 || size < 0 {
+		return 0, false, nil
+	}
+	return int(count), start+int64(k+l)+size+16 == end, nil
+}
+
+// markerScanner finds the sync markers of a container file from its end
+// backwards, reading it a chunk at a time.
+type markerScanner struct {
+	r      io.ReaderAt
+	marker [16]byte
+	min    int64   // start of the data blocks
+	pos    int64   // markers starting from pos on were searched
+	size   int64   // of the file
+	found  []int64 // ends of the markers of the last chunk not returned yet, last first
+}
+
+// prev returns the end of the marker before the ones returned so far, or
+// false when there are none left.
+func (ms *markerScanner) prev() (int64, bool, error) {
+	for len(ms.found) == 0 {
+		if ms.pos <= ms.min {
+			return 0, false, nil
+		}
+		start := max(ms.pos-tailChunk, ms.min)
+		// The chunk overlaps the previous one by a marker's length less
+		// one byte, for markers across their boundary
+		buf := make([]byte, min(ms.pos+int64(len(ms.marker))-1, ms.size)-start)
+		if _, err := ms.r.ReadAt(buf, start); err != nil && err != io.EOF {
+			return 0, false, err
+		}
+		for i := len(buf); ; {
+			j := bytes.LastIndex(buf[:i], ms.marker[:])
+			if j < 0 {
+				break
+			}
+			if start+int64(j) < ms.pos {
+				ms.found = append(ms.found, start+int64(j+len(ms.marker)))
+			}
+			i = j
+		}
+		ms.pos = start
+	}
+	end := ms.found[0]
+	ms.found = ms.found[1:]
+	return end, true, nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+// messageIDs returns the id fields of JSON records.
+func messageIDs(t *testing.T, msgs []json.RawMessage) []int64 {
+	t.Helper()
+	ids := make([]int64, len(msgs))
+	for i, msg := range msgs {
+		var record struct {
+			ID int64 `json:"id"`
+		}
+		if err := json.Unmarshal(msg, &record); err != nil {
+			t.Fatalf("record %d: %v", i, err)
+		}
+		ids[i] = record.ID
+	}
+	return ids
+}
+
+// blockEnds returns the offsets at which the blocks of a container file
+// end.
+func blockEnds(t *testing.T, data []byte) []int64 {
+	t.Helper()
+	blocks, err := newOCFReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blocks.Close()
+	// The header ends with the first sync marker
+	end := int64(bytes.Index(data, blocks.header.sync[:]) + len(blocks.header.sync))
+	var ends []int64
+	for {
+		count, block, err := blocks.next()
+		if err != nil {
+			return ends
+		}
+		end += int64(len(binary.AppendVarint(nil, int64(count)))+len(binary.AppendVarint(nil, int64(len(block))))+len(block)) + 16
+		ends = append(ends, end)
+	}
+}
+
+// TestTailBlocksMarkerInData checks tail skips copies of the sync marker in
+// records, across the chunks it reads, and leaves out an incomplete last
+// block.
+func TestTailBlocksMarkerInData(t *testing.T) {
+	sync := [16]byte{'a', 'v', 'r', 'o', 's', 'y', 'n', 'c', 'm', 'a', 'r', 'k', 'e', 'r', '!', '!'}
+	const n = 6000
+	data := writeTestOCF(t, ocfCodecNull, n, &sync, func(i int) []byte {
+		// Every record holds the marker, some several times
+		return bytes.Repeat(sync[:], 1+i%3)
+	})
+	if c := bytes.Count(data, sync[:]); c < n {
+		t.Fatalf("the file holds the marker %d times", c)
+	}
+	if len(data) < 3*tailChunk {
+		t.Fatalf("the file is %d bytes, not several chunks", len(data))
+	}
+	ends := blockEnds(t, data)
+	isEnd := make(map[int64]bool, len(ends))
+	for _, end := range ends {
+		isEnd[end] = true
+	}
+
+	// A block being written: its count and size, and part of its data
+	partial := append(append([]byte(nil), data...), 0x14, 0xc8, 0x01)
+	partial = append(partial, "part of a record"...)
+
+	for name, file := range map[string][]byte{"complete": data, "partial": partial} {
+		path := writeTestFile(t, name+".avro", file)
+		for _, want := range []int{1, 10, 100, 1000, n, n + 1} {
+			blocks, err := tailBlocks(path, want)
+			if err != nil {
+				t.Fatalf("%s, %d records: %v", name, want, err)
+			}
+			if blocks.end != int64(len(data)) {
+				t.Fatalf("%s, %d records: blocks end at %d, want %d", name, want, blocks.end, len(data))
+			}
+			if blocks.start != blocks.header && !isEnd[blocks.start] {
+				t.Fatalf("%s, %d records: %d isn't a block boundary", name, want, blocks.start)
+			}
+
+			records, err := tailRecords(path, min(want, n), testOptions(t, ""))
+			if err != nil {
+				t.Fatalf("%s, %d records: %v", name, want, err)
+			}
+			ids := messageIDs(t, records)
+			if len(ids) != min(want, n) {
+				t.Fatalf("%s: tail %d returned %d records", name, want, len(ids))
+			}
+			for i, id := range ids {
+				if id != int64(n-len(ids)+i) {
+					t.Fatalf("%s: tail %d returned id %d at %d", name, want, id, i)
+				}
+			}
+		}
+	}
+}
+
+func TestTailBlocksEmptyFile(t *testing.T) {
+	data := writeTestOCF(t, ocfCodecDeflate, 0, nil, nil)
+	path := writeTestFile(t, "empty.avro", data)
+	blocks, err := tailBlocks(path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blocks.start != blocks.header || blocks.end != blocks.header || blocks.header != int64(len(data)) {
+		t.Fatalf("blocks of an empty file are %+v, file is %d bytes", blocks, len(data))
+	}
+}
+
+func TestHeadTailInputs(t *testing.T) {
+	var inputs []inputFile
+	for i := 0; i < 3; i++ {
+		msgs := []string{fmt.Sprintf(`{"id":%d}`, 2*i), fmt.Sprintf(`{"id":%d}`, 2*i+1)}
+		inputs = append(inputs, inputFile{path: writeTestFile(t, fmt.Sprintf("day%d.avro", i), writeMessageOCF(t, msgs...))})
+	}
+	opts := testOptions(t, "message")
+	collect := func(records *[]json.RawMessage) messageSink {
+		return func(msg json.RawMessage) error {
+			*records = append(*records, append(json.RawMessage(nil), msg...))
+			return nil
+		}
+	}
+
+	var head, tail []json.RawMessage
+	if err := headRecords(inputs, 3, opts, collect(&head)); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(messageIDs(t, head)); got != "[0 1 2]" {
+		t.Errorf("head 3 returned %s", got)
+	}
+	if err := tailInputs(inputs, 3, opts, collect(&tail)); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(messageIDs(t, tail)); got != "[3 4 5]" {
+		t.Errorf("tail 3 returned %s", got)
+	}
+
+	// NDJSON inputs are read from the start
+	ndjson := writeTestFile(t, "events.ndjson", []byte("{\"id\":7}\n{\"id\":8}\n{\"id\":9}\n"))
+	records, err := tailRecords(ndjson, 2, testOptions(t, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(messageIDs(t, records)); got != "[8 9]" {
+		t.Errorf("tail of NDJSON returned %s", got)
+	}
+}
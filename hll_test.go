@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestHyperLogLog(t *testing.T) {
+	var h, other hyperLogLog
+	if h.estimate() != 0 {
+		t.Fatalf("empty estimate %d", h.estimate())
+	}
+	for _, n := range []int{10, 1000, 100000} {
+		h = hyperLogLog{}
+		for i := 0; i < n; i++ {
+			// Every value twice
+			h.add(fmt.Sprintf("user-%d", i))
+			h.add(fmt.Sprintf("user-%d", i))
+		}
+		if got := h.estimate(); got < int64(n)*98/100 || got > int64(n)*102/100 {
+			t.Errorf("estimated %d distinct values of %d", got, n)
+		}
+	}
+
+	// Merging counts values added to either once
+	for i := 50000; i < 150000; i++ {
+		other.add(fmt.Sprintf("user-%d", i))
+	}
+	h.merge(&other)
+	if got := h.estimate(); got < 147000 || got > 153000 {
+		t.Errorf("estimated %d distinct values of 150000", got)
+	}
+}
@@ -0,0 +1,556 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/linkedin/goavro/v2"
+
+	"avroparser/pkg/avroconvert"
+)
+
+// indexExt is added to the name of an input to name its index.
+const indexExt = ".index.json.gz"
+
+// errIndexStale is returned for an input that changed other than by
+// appending blocks since it was indexed.
+var errIndexStale = errors.New("the input changed since it was indexed; run index again")
+
+// blockIndex is the sidecar index of a container file: the blocks holding
+// each value of a key field, and the range of a timestamp field in each
+// block, so get only reads the blocks that can hold the records asked for.
+type blockIndex struct {
+	KeyField       string           `json:"key_field"`
+	TimestampField string           `json:"timestamp_field,omitempty"`
+	Sync           string           `json:"sync"`   // the file's sync marker, in hex
+	Header         int64            `json:"header"` // length of the file's header
+	End            int64            `json:"end"`    // of the last block indexed
+	Blocks         []indexedBlock   `json:"blocks"`
+	Keys           map[string][]int `json:"keys"` // numbers of the blocks holding each key
+}
+
+// indexedBlock is where a block of a container file is, with the range of
+// its records' timestamps.
+type indexedBlock struct {
+	Offset  int64      `json:"offset"`
+	Length  int64      `json:"length"` // with its record count, size and sync marker
+	Records int        `json:"records"`
+	MinTime *time.Time `json:"min_time,omitempty"`
+	MaxTime *time.Time `json:"max_time,omitempty"`
+	Partial bool       `json:"partial,omitempty"` // some records could not be decoded, so get always reads it
+}
+
+func runIndex(args []string) {
+	fs := flag.NewFlagSet("index", flag.ExitOnError)
+	inputPath := fs.String("input", "", "Input Avro file, directory or glob pattern, local or on gs:// or s3://; more may be given as arguments")
+	key := fs.String("key", "", "Field path of the key to index, e.g. user_id")
+	timeField := fs.String("timestamp-field", "event_timestamp", "Field path of the event time whose range is recorded for each block (empty for none)")
+	indexDir := fs.String("index-dir", "", "Directory to write the indexes under, at the inputs' relative paths (default next to each input)")
+	logging := addLogFlags(fs)
+	parseFlags(fs, args)
+	logging.setup()
+
+	paths := fs.Args()
+	if *inputPath != "" {
+		paths = append([]string{*inputPath}, paths...)
+	}
+	if len(paths) == 0 || *key == "" {
+		fmt.Fprintln(os.Stderr, "Usage: avroparser index -key <field> [-timestamp-field <field>] [-index-dir <dir>] <avro_file|dir|glob>...")
+		os.Exit(exitFatal)
+	}
+	for _, path := range []string{*key, *timeField} {
+		if path != "" && (strings.HasPrefix(path, ".") || strings.HasSuffix(path, ".") || strings.Contains(path, "..")) {
+			fmt.Fprintf(os.Stderr, "Invalid field path %q\n", path)
+			os.Exit(exitFatal)
+		}
+	}
+	if isS3Path(*indexDir) {
+		fmt.Fprintln(os.Stderr, "Indexes cannot be written to S3; give a local or gs:// -index-dir")
+		os.Exit(exitFatal)
+	}
+
+	var inputs []inputFile
+	for _, path := range paths {
+		for _, in := range mustExpandInputs(path) {
+			if !rangeReadable(in.path) {
+				fmt.Fprintf(os.Stderr, "Cannot index %s: only local files and gs:// and s3:// objects can be read in parts\n", displayPath(in.path))
+				os.Exit(exitFatal)
+			}
+			if isS3Path(in.path) && *indexDir == "" {
+				fmt.Fprintln(os.Stderr, "Indexes of s3:// inputs cannot be written next to them; give a local or gs:// -index-dir")
+				os.Exit(exitFatal)
+			}
+			inputs = append(inputs, in)
+		}
+	}
+
+	failed := 0
+	for _, in := range inputs {
+		index, err := indexFile(in.path, *key, *timeField)
+		if err == nil {
+			err = writeIndex(indexPath(in, *indexDir), index)
+		}
+		if err != nil {
+			slog.Error("Cannot index input", "input", in.path, "error", err)
+			failed++
+			continue
+		}
+		slog.Info("Indexed input", "input", in.path, "index", indexPath(in, *indexDir), "blocks", len(index.Blocks), "keys", len(index.Keys))
+	}
+	switch {
+	case failed == len(inputs):
+		os.Exit(exitFatal)
+	case failed > 0:
+		os.Exit(exitPartial)
+	}
+}
+
+func runGet(args []string) {
+	fs := flag.NewFlagSet("get", flag.ExitOnError)
+	inputPath := fs.String("input", "", "Input Avro file, directory or glob pattern indexed with index; more may be given as arguments")
+	key := fs.String("key", "", "Value of the indexed key field of the records to get, e.g. a user ID")
+	indexDir := fs.String("index-dir", "", "Directory the indexes were written under (default next to each input)")
+	outputPath := fs.String("output", stdioPath, "NDJSON file to write the records to, or - for stdout")
+	records := addRecordFlags(fs)
+	logging := addLogFlags(fs)
+	parseFlags(fs, args)
+	logging.setup()
+
+	paths := fs.Args()
+	if *inputPath != "" {
+		paths = append([]string{*inputPath}, paths...)
+	}
+	if len(paths) == 0 || *key == "" {
+		fmt.Fprintln(os.Stderr, "Usage: avroparser get -key <value> [-index-dir <dir>] [-since <time>] [-until <time>] [-output <file>|-] <avro_file|dir|glob>...")
+		os.Exit(exitFatal)
+	}
+	if *records.offset != 0 || *records.count != 0 {
+		fmt.Fprintln(os.Stderr, "get reads only some blocks of each input, so it cannot be combined with -offset or -count")
+		os.Exit(exitFatal)
+	}
+	if *records.onError == onErrorCollect {
+		fmt.Fprintln(os.Stderr, "get cannot collect failed records; use -on-error skip or fail")
+		os.Exit(exitFatal)
+	}
+	opts := mustRecordOptions(records)
+	// Already validated as part of the filter
+	times, _ := parseTimeRange(*records.timeField, *records.since, *records.until, *records.timeFormat)
+
+	var inputs []inputFile
+	for _, path := range paths {
+		inputs = append(inputs, mustExpandInputs(path)...)
+	}
+	out, err := openOutput(*outputPath)
+	if err != nil {
+		slog.Error("Cannot create output", "error", err)
+		os.Exit(exitFatal)
+	}
+	sink := avroconvert.NewNDJSONSink(out)
+
+	failed, found, blocks := 0, 0, 0
+	for _, in := range inputs {
+		stats, read, err := getRecords(in, indexPath(in, *indexDir), *key, times, opts, sink)
+		if err != nil {
+			slog.Error("Cannot get records", "input", in.path, "error", err)
+			failed++
+			continue
+		}
+		slog.Debug("Read indexed blocks", "input", in.path, "blocks", read, "records", stats.Messages)
+		found += stats.Messages
+		blocks += read
+	}
+	if err := sink.Close(); err != nil {
+		abortOutput(out)
+		slog.Error("Cannot write records", "error", err)
+		os.Exit(exitFatal)
+	}
+	if err := out.Close(); err != nil {
+		slog.Error("Cannot write records", "error", err)
+		os.Exit(exitFatal)
+	}
+	slog.Info("Got records", "key", *key, "records", found, "inputs", len(inputs), "blocks", blocks, "failed", failed)
+	switch {
+	case failed == len(inputs):
+		os.Exit(exitFatal)
+	case failed > 0:
+		os.Exit(exitPartial)
+	}
+}
+
+// indexPath returns where the index of an input is: next to it, or under
+// indexDir at the input's relative path.
+func indexPath(in inputFile, indexDir string) string {
+	switch {
+	case indexDir == "":
+		return in.path + indexExt
+	case isGCSPath(indexDir):
+		return strings.TrimSuffix(indexDir, "/") + "/" + filepath.ToSlash(in.rel) + indexExt
+	}
+	return filepath.Join(indexDir, in.rel+indexExt)
+}
+
+// rangeReadable reports whether parts of an input can be read without the
+// rest: local files, and Cloud Storage and S3 objects with range requests.
+func rangeReadable(path string) bool {
+	return isGCSPath(path) || isS3Path(path) || path != stdioPath && !isRemotePath(path)
+}
+
+// indexFile reads a container file block by block, recording where each
+// block is, the key values its records hold and the range of their
+// timestamps. Records are converted as decode converts them by default, so
+// keys and timestamps are those written to JSON.
+func indexFile(path, keyField, timeField string) (*blockIndex, error) {
+	src, err := newSource(path).Open()
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	counter := &countingReader{r: src}
+	blocks, err := newOCFReader(counter)
+	if err != nil {
+		// Compressed files cannot be read in parts
+		return nil, err
+	}
+	defer blocks.Close()
+	offset := func() int64 { return counter.n - int64(blocks.r.Buffered()) }
+
+	codec, err := goavro.NewCodec(string(blocks.header.schema()))
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse writer schema: %w", err)
+	}
+	schema, err := avroconvert.ParseSchema(string(blocks.header.schema()))
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse writer schema: %w", err)
+	}
+	converter, err := avroconvert.NewJSONConverter(avroconvert.ConverterOptions{})
+	if err != nil {
+		return nil, err
+	}
+	keyPath := strings.Split(keyField, ".")
+	var timePath []string
+	if timeField != "" {
+		timePath = strings.Split(timeField, ".")
+	}
+
+	index := &blockIndex{
+		KeyField:       keyField,
+		TimestampField: timeField,
+		Sync:           hex.EncodeToString(blocks.header.sync[:]),
+		Header:         offset(),
+		Blocks:         []indexedBlock{},
+		Keys:           make(map[string][]int),
+	}
+	index.End = index.Header
+	for {
+		start := offset()
+		count, data, err := blocks.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// Blocks being appended are read by get after the indexed ones
+			slog.Warn("Cannot index the rest of the input", "input", path, "block", len(index.Blocks)+1, "error", err)
+			break
+		}
+		number := len(index.Blocks)
+		block := indexedBlock{Offset: start, Length: offset() - start, Records: count}
+		data, err = blocks.decompress(data)
+		if err != nil {
+			slog.Warn("Cannot decompress block", "input", path, "block", number+1, "error", err)
+			block.Partial = true
+		}
+		for i := 0; i < count && !block.Partial; i++ {
+			native, rest, err := codec.NativeFromBinary(data)
+			if err != nil {
+				slog.Warn("Cannot index the rest of a block", "input", path, "block", number+1, "record", i+1, "error", err)
+				block.Partial = true
+				break
+			}
+			data = rest
+			record := converter.ValueInPlace(schema, native)
+			if key, ok := indexKey(lookupPath(record, keyPath)); ok {
+				numbers := index.Keys[key]
+				if len(numbers) == 0 || numbers[len(numbers)-1] != number {
+					index.Keys[key] = append(numbers, number)
+				}
+			}
+			if timePath == nil {
+				continue
+			}
+			if t, ok := eventTime(lookupPath(record, timePath), ""); ok {
+				if block.MinTime == nil || t.Before(*block.MinTime) {
+					block.MinTime = &t
+				}
+				if block.MaxTime == nil || t.After(*block.MaxTime) {
+					block.MaxTime = &t
+				}
+			}
+		}
+		index.Blocks = append(index.Blocks, block)
+		index.End = start + block.Length
+	}
+	return index, nil
+}
+
+// indexKey returns the text of a key value: strings as they are, and
+// numbers and booleans as JSON. Null, objects and arrays aren't keys.
+func indexKey(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case nil, map[string]interface{}, []interface{}:
+		return "", false
+	case string:
+		return v, true
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", false
+	}
+	return string(data), true
+}
+
+// writeIndex writes an index as gzipped JSON.
+func writeIndex(path string, index *blockIndex) error {
+	out, err := openOutput(path)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	if err := json.NewEncoder(zw).Encode(index); err != nil {
+		abortOutput(out)
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		abortOutput(out)
+		return err
+	}
+	return out.Close()
+}
+
+func readIndex(path string) (*blockIndex, error) {
+	r, err := openInput(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var index blockIndex
+	if err := json.NewDecoder(r).Decode(&index); err != nil {
+		return nil, err
+	}
+	return &index, nil
+}
+
+// blocksFor returns the numbers of the blocks that can hold records with a
+// key, in file order: those the index lists for it, and those it could not
+// index completely. With a time range for the indexed timestamp field,
+// blocks whose timestamps are all outside it are left out.
+func (bi *blockIndex) blocksFor(key string, times *timeRange) []int {
+	numbers := append([]int(nil), bi.Keys[key]...)
+	for i, b := range bi.Blocks {
+		if b.Partial {
+			numbers = append(numbers, i)
+		}
+	}
+	sort.Ints(numbers)
+	kept := numbers[:0]
+	for i, n := range numbers {
+		if n >= len(bi.Blocks) || i > 0 && n == numbers[i-1] {
+			continue
+		}
+		if times != nil && strings.Join(times.path, ".") == bi.TimestampField && !bi.Blocks[n].overlaps(times) {
+			continue
+		}
+		kept = append(kept, n)
+	}
+	return kept
+}
+
+// overlaps reports whether a block can hold records in a time range.
+func (b indexedBlock) overlaps(times *timeRange) bool {
+	if b.Partial || b.MinTime == nil {
+		return true
+	}
+	return (times.until.IsZero() || b.MinTime.Before(times.until)) && (times.since.IsZero() || !b.MaxTime.Before(times.since))
+}
+
+// getRecords writes the records of an input whose key is key to sink,
+// reading only the blocks its index lists for the key, and the blocks
+// appended after the input was indexed. It returns the number of indexed
+// blocks read.
+func getRecords(in inputFile, indexFile, key string, times *timeRange, opts decodeOptions, sink avroconvert.Sink) (avroconvert.Stats, int, error) {
+	index, err := readIndex(indexFile)
+	if err != nil {
+		return avroconvert.Stats{}, 0, fmt.Errorf("cannot read index %s: %w", indexFile, err)
+	}
+	numbers := index.blocksFor(key, times)
+	input, err := openIndexedBlocks(in.path, index, numbers)
+	if err != nil {
+		return avroconvert.Stats{}, 0, err
+	}
+	defer input.Close()
+
+	keyPath := strings.Split(index.KeyField, ".")
+	filter := opts.filter
+	opts.filter = &recordFilter{eval: func(record interface{}) interface{} {
+		if k, ok := indexKey(lookupPath(record, keyPath)); !ok || k != key {
+			return false
+		}
+		if filter == nil {
+			return true
+		}
+		return filter.eval(record)
+	}}
+	stats, err := decodeMessages(input, in.path, opts, sink)
+	return stats, len(numbers), err
+}
+
+// openIndexedBlocks opens the header and the given blocks of an indexed
+// container file, followed by any blocks appended since it was indexed, as
+// a container stream of its own. The header's sync marker and the one
+// ending the indexed blocks are checked first, so an input replaced since
+// is reported rather than read as garbage.
+func openIndexedBlocks(path string, index *blockIndex, numbers []int) (io.ReadCloser, error) {
+	open, closeInput, err := openRanges(path)
+	if err != nil {
+		return nil, err
+	}
+	fail := func(err error) (io.ReadCloser, error) {
+		closeInput()
+		return nil, err
+	}
+
+	r, err := open(0, index.Header)
+	if err != nil {
+		return fail(err)
+	}
+	header, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		return fail(err)
+	}
+	parsed, err := readOCFHeader(bufio.NewReader(bytes.NewReader(header)))
+	if err != nil || hex.EncodeToString(parsed.sync[:]) != index.Sync {
+		return fail(errIndexStale)
+	}
+	// The indexed blocks end with the sync marker, which is also the
+	// header's last bytes when there are none
+	rest, err := open(index.End-int64(len(parsed.sync)), -1)
+	if err != nil {
+		return fail(fmt.Errorf("%w: %v", errIndexStale, err))
+	}
+	var marker [16]byte
+	if _, err := io.ReadFull(rest, marker[:]); err != nil || marker != parsed.sync {
+		rest.Close()
+		return fail(errIndexStale)
+	}
+
+	parts := []func() (io.ReadCloser, error){
+		func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(header)), nil },
+	}
+	// Consecutive blocks are read at once
+	for i := 0; i < len(numbers); {
+		start := index.Blocks[numbers[i]]
+		end := start.Offset + start.Length
+		for i++; i < len(numbers) && index.Blocks[numbers[i]].Offset == end; i++ {
+			end += index.Blocks[numbers[i]].Length
+		}
+		parts = append(parts, func() (io.ReadCloser, error) { return open(start.Offset, end-start.Offset) })
+	}
+	parts = append(parts, func() (io.ReadCloser, error) { return rest, nil })
+	return &chainReader{parts: parts, closers: []func() error{rest.Close, closeInput}}, nil
+}
+
+// openRanges returns a function opening byte ranges of an input, as
+// objectStore's openRange, and one releasing the input.
+func openRanges(path string) (func(offset, length int64) (io.ReadCloser, error), func() error, error) {
+	var store objectStore
+	switch {
+	case isGCSPath(path):
+		store = gcs
+	case isS3Path(path):
+		store = s3
+	}
+	if store != nil {
+		open := func(offset, length int64) (io.ReadCloser, error) {
+			return store.openRange(path, offset, length)
+		}
+		return open, func() error { return nil }, nil
+	}
+	if !rangeReadable(path) {
+		return nil, nil, errors.New("only local files and gs:// and s3:// objects can be read in parts")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	open := func(offset, length int64) (io.ReadCloser, error) {
+		if length < 0 {
+			length = info.Size() - offset
+		}
+		return io.NopCloser(io.NewSectionReader(f, offset, length)), nil
+	}
+	return open, f.Close, nil
+}
+
+// chainReader reads parts of an input one after the other, opening each
+// when the one before it ends.
+type chainReader struct {
+	parts   []func() (io.ReadCloser, error)
+	current io.ReadCloser
+	closers []func() error // run by Close
+}
+
+func (cr *chainReader) Read(p []byte) (int, error) {
+	for {
+		if cr.current == nil {
+			if len(cr.parts) == 0 {
+				return 0, io.EOF
+			}
+			r, err := cr.parts[0]()
+			if err != nil {
+				return 0, err
+			}
+			cr.current, cr.parts = r, cr.parts[1:]
+		}
+		n, err := cr.current.Read(p)
+		if err == io.EOF {
+			cr.current.Close()
+			cr.current = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (cr *chainReader) Close() error {
+	if cr.current != nil {
+		cr.current.Close()
+	}
+	var err error
+	for _, close := range cr.closers {
+		if e := close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// getTestIDs returns the ids of the records get finds for a key.
+func getTestIDs(t *testing.T, path, index, key string) ([]int64, int, error) {
+	t.Helper()
+	var msgs []json.RawMessage
+	sink := messageSink(func(msg json.RawMessage) error {
+		msgs = append(msgs, append(json.RawMessage(nil), msg...))
+		return nil
+	})
+	_, blocks, err := getRecords(inputFile{path: path}, index, key, nil, testOptions(t, ""), sink)
+	return messageIDs(t, msgs), blocks, err
+}
+
+// wantNamed returns the ids of the first n test records with a name.
+func wantNamed(name string, n int) []int64 {
+	var ids []int64
+	for i := 0; i < n; i++ {
+		if fmt.Sprintf("event-%d", i/200%7) == name {
+			ids = append(ids, int64(i))
+		}
+	}
+	return ids
+}
+
+func sameIDs(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestIndexGet(t *testing.T) {
+	// Names are spread over blocks, so each key is in only some of them.
+	// The input is first the file's blocks up to the middle one, and then
+	// the whole file, as if blocks had been appended since.
+	data := writeTestOCF(t, ocfCodecDeflate, 3000, nil, nil)
+	ends := blockEnds(t, data)
+	path := writeTestFile(t, "events.avro", data[:ends[len(ends)/2]])
+	index, err := indexFile(path, "name", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index.End != ends[len(ends)/2] || len(index.Blocks) != len(ends)/2+1 {
+		t.Fatalf("indexed %d blocks up to %d, want %d up to %d", len(index.Blocks), index.End, len(ends)/2+1, ends[len(ends)/2])
+	}
+	n := 0
+	for _, b := range index.Blocks {
+		n += b.Records
+	}
+	indexName := filepath.Join(t.TempDir(), "events"+indexExt)
+	if err := writeIndex(indexName, index); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"event-0", "event-3", "event-6"} {
+		ids, blocks, err := getTestIDs(t, path, indexName, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := wantNamed(name, n); !sameIDs(ids, want) {
+			t.Fatalf("get %s returned %d records, want %d", name, len(ids), len(want))
+		}
+		if blocks != len(index.Keys[name]) {
+			t.Fatalf("get %s read %d blocks, want %d", name, blocks, len(index.Keys[name]))
+		}
+	}
+	if ids, blocks, err := getTestIDs(t, path, indexName, "missing"); err != nil || len(ids) != 0 || blocks != 0 {
+		t.Fatalf("get of a missing key returned %d records from %d blocks, %v", len(ids), blocks, err)
+	}
+
+	// Blocks appended since the input was indexed are read too
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ids, _, err := getTestIDs(t, path, indexName, "event-3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := wantNamed("event-3", 3000); !sameIDs(ids, want) {
+		t.Fatalf("get after appending returned %d records, want %d", len(ids), len(want))
+	}
+
+	// An input replaced since is reported, rather than read
+	if err := os.WriteFile(path, writeTestOCF(t, ocfCodecDeflate, 3000, nil, nil), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := getTestIDs(t, path, indexName, "event-3"); !errors.Is(err, errIndexStale) {
+		t.Fatalf("get of a replaced input returned %v, want %v", err, errIndexStale)
+	}
+}
+
+func TestIndexKey(t *testing.T) {
+	tests := []struct {
+		v    interface{}
+		key  string
+		isOK bool
+	}{
+		{"u1", "u1", true},
+		{json.Number("42"), "42", true},
+		{true, "true", true},
+		{nil, "", false},
+		{map[string]interface{}{}, "", false},
+		{[]interface{}{"a"}, "", false},
+	}
+	for _, tt := range tests {
+		if key, ok := indexKey(tt.v); key != tt.key || ok != tt.isOK {
+			t.Errorf("indexKey(%v) = %q, %v", tt.v, key, ok)
+		}
+	}
+}
+
+func TestBlocksFor(t *testing.T) {
+	at := func(day int) *time.Time {
+		ts := time.Date(2026, 1, day, 0, 0, 0, 0, time.UTC)
+		return &ts
+	}
+	index := &blockIndex{
+		TimestampField: "ts",
+		Blocks: []indexedBlock{
+			{MinTime: at(1), MaxTime: at(2)},
+			{Partial: true},
+			{MinTime: at(3), MaxTime: at(4)},
+			{MinTime: at(5), MaxTime: at(6)},
+		},
+		Keys: map[string][]int{"u1": {3, 0, 2}},
+	}
+	if got := index.blocksFor("u1", nil); !reflect.DeepEqual(got, []int{0, 1, 2, 3}) {
+		t.Errorf("blocks for u1 %v", got)
+	}
+	// Blocks that could not be indexed completely are always read
+	if got := index.blocksFor("missing", nil); !reflect.DeepEqual(got, []int{1}) {
+		t.Errorf("blocks for a missing key %v", got)
+	}
+	times := &timeRange{path: []string{"ts"}, since: *at(3), until: *at(5)}
+	if got := index.blocksFor("u1", times); !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Errorf("blocks for u1 in a time range %v", got)
+	}
+	// A range on another field leaves the blocks as they are
+	times.path = []string{"other"}
+	if got := index.blocksFor("u1", times); len(got) != 4 {
+		t.Errorf("blocks for a range on another field %v", got)
+	}
+}
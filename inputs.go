@@ -0,0 +1,167 @@
+package main
+
+import (
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// inputFile is a single Avro file to convert. rel is its path relative to
+// the directory or glob root it was found under, and is mirrored in the
+// output directory. blocks limits conversion to some of the blocks of a
+// container file, for -state.
+type inputFile struct {
+	path   string
+	rel    string
+	blocks *blockRange
+}
+
+// expandInputs resolves the -input value into the list of files to convert.
+// It accepts stdin, a single file, a directory (searched recursively for
+// .avro files) or a glob pattern such as "exports/*.avro", locally or as a
+// gs:// Cloud Storage or s3:// S3 path, or a single http(s):// URL. Local
+// zip and tar archives stand for their Avro and NDJSON entries, and
+// archive.zip!/entry for a single entry.
+func expandInputs(input string) ([]inputFile, error) {
+	if input == stdioPath {
+		return []inputFile{{path: stdioPath, rel: "stdin"}}, nil
+	}
+	if isGCSPath(input) {
+		return expandStoreInputs(gcs, gcsScheme, input)
+	}
+	if isS3Path(input) {
+		return expandStoreInputs(s3, s3Scheme, input)
+	}
+	if isHTTPPath(input) {
+		u, err := url.Parse(input)
+		if err != nil {
+			return nil, err
+		}
+		return []inputFile{{path: input, rel: path.Base(u.Path)}}, nil
+	}
+
+	if isGlob(input) {
+		matches, err := filepath.Glob(input)
+		if err != nil {
+			return nil, err
+		}
+		root := globRoot(input)
+		var inputs []inputFile
+		for _, match := range matches {
+			if info, err := os.Stat(match); err != nil || info.IsDir() {
+				continue
+			}
+			rel, err := filepath.Rel(root, match)
+			if err != nil {
+				return nil, err
+			}
+			if archiveSuffix(match) != "" {
+				entries, err := expandArchive(match, rel)
+				if err != nil {
+					return nil, err
+				}
+				inputs = append(inputs, entries...)
+				continue
+			}
+			inputs = append(inputs, inputFile{path: match, rel: rel})
+		}
+		return inputs, nil
+	}
+
+	if isArchiveEntry(input) {
+		return []inputFile{{path: input, rel: path.Base(newArchiveSource(input).entry)}}, nil
+	}
+	info, err := os.Stat(input)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		if archiveSuffix(input) != "" {
+			return expandArchive(input, filepath.Base(input))
+		}
+		return []inputFile{{path: input, rel: filepath.Base(input)}}, nil
+	}
+
+	var inputs []inputFile
+	err = filepath.WalkDir(input, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !isAvroName(path) {
+			return nil
+		}
+		rel, err := filepath.Rel(input, path)
+		if err != nil {
+			return err
+		}
+		inputs = append(inputs, inputFile{path: path, rel: rel})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(inputs, func(i, j int) bool { return inputs[i].path < inputs[j].path })
+	return inputs, nil
+}
+
+// isAvroName reports whether a file name has an .avro extension, possibly
+// followed by a compression extension such as .avro.gz.
+func isAvroName(name string) bool {
+	return strings.EqualFold(filepath.Ext(trimCompressionSuffix(name)), ".avro")
+}
+
+// isGlob reports whether the path contains glob metacharacters.
+func isGlob(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// globRoot returns the longest leading directory of a glob pattern that
+// contains no metacharacters.
+func globRoot(pattern string) string {
+	dir := filepath.Dir(pattern)
+	for isGlob(dir) {
+		dir = filepath.Dir(dir)
+	}
+	return dir
+}
+
+// mustExpandInputs resolves the -input value and exits when it matches
+// nothing.
+func mustExpandInputs(input string) []inputFile {
+	inputs, err := expandInputs(input)
+	if err != nil {
+		slog.Error("Cannot resolve input", "input", input, "error", err)
+		os.Exit(exitFatal)
+	}
+	if len(inputs) == 0 {
+		slog.Error("No Avro files found", "input", input)
+		os.Exit(exitFatal)
+	}
+	return inputs
+}
+
+// openInput opens an input from the source newSource picks for it. gzip
+// and zstd compressed input is decompressed transparently.
+func openInput(path string) (io.ReadCloser, error) {
+	r, err := newSource(path).Open()
+	if err != nil {
+		return nil, err
+	}
+	return decompress(r)
+}
+
+// openInputBlocks opens the selected blocks of an input, or all of it when
+// blocks is nil.
+func openInputBlocks(path string, blocks *blockRange) (io.ReadCloser, error) {
+	if blocks == nil {
+		return openInput(path)
+	}
+	return openBlocks(path, *blocks)
+}
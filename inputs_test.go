@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// makeTree creates empty files at the given slash-separated paths under a
+// temporary directory, and returns the directory.
+func makeTree(t *testing.T, paths ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, p := range paths {
+		path := filepath.Join(dir, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestExpandInputs(t *testing.T) {
+	dir := makeTree(t, "a.avro", "b.AVRO", "notes.txt", "2024/01/c.avro", "2024/02/d.avro")
+	rels := func(inputs []inputFile) []string {
+		var out []string
+		for _, in := range inputs {
+			out = append(out, filepath.ToSlash(in.rel))
+		}
+		return out
+	}
+
+	for _, tc := range []struct {
+		input string
+		want  []string
+	}{
+		{"-", []string{"stdin"}},
+		{filepath.Join(dir, "a.avro"), []string{"a.avro"}},
+		{dir, []string{"2024/01/c.avro", "2024/02/d.avro", "a.avro", "b.AVRO"}},
+		{filepath.Join(dir, "2024", "*", "*.avro"), []string{"01/c.avro", "02/d.avro"}},
+		{filepath.Join(dir, "*.txt"), []string{"notes.txt"}},
+		{filepath.Join(dir, "none*"), nil},
+	} {
+		inputs, err := expandInputs(tc.input)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.input, err)
+		}
+		if got := rels(inputs); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: found %q, want %q", tc.input, got, tc.want)
+		}
+	}
+
+	if _, err := expandInputs(filepath.Join(dir, "missing.avro")); err == nil {
+		t.Error("a missing file was expanded without an error")
+	}
+}
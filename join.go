@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"avroparser/pkg/avroconvert"
+)
+
+// joinFlags select a lookup table to enrich records from, for the commands
+// that write JSON or CSV records.
+type joinFlags struct {
+	lookup *string
+	on     *string
+	key    *string
+	prefix *string
+}
+
+func addJoinFlags(fs *flag.FlagSet) *joinFlags {
+	return &joinFlags{
+		lookup: fs.String("join", "", "CSV, NDJSON or JSON array file of rows to add to the records matching them on -join-on, e.g. players.csv"),
+		on:     fs.String("join-on", "", "Field path of the records holding the key looked up in the -join file, e.g. playerID"),
+		key:    fs.String("join-key", "", "Column of the -join file holding the key (default the last name of -join-on)"),
+		prefix: fs.String("join-prefix", "", "Prefix of the fields added from the -join file, e.g. player_"),
+	}
+}
+
+// lookupJoin returns the join the flags describe, or nil when -join isn't
+// given.
+func (jf *joinFlags) lookupJoin() (*lookupJoin, error) {
+	if *jf.lookup == "" {
+		if *jf.on != "" || *jf.key != "" || *jf.prefix != "" {
+			return nil, fmt.Errorf("-join-on, -join-key and -join-prefix are only used with -join")
+		}
+		return nil, nil
+	}
+	on := *jf.on
+	if on == "" || strings.HasPrefix(on, ".") || strings.HasSuffix(on, ".") || strings.Contains(on, "..") {
+		return nil, fmt.Errorf("-join needs -join-on, the field path of the key, got %q", on)
+	}
+	lj := &lookupJoin{path: strings.Split(on, "."), prefix: *jf.prefix}
+	key := *jf.key
+	if key == "" {
+		key = lj.path[len(lj.path)-1]
+	}
+	if err := lj.load(*jf.lookup, key); err != nil {
+		return nil, err
+	}
+	slog.Debug("Loaded -join file", "file", *jf.lookup, "rows", len(lj.rows), "columns", len(lj.columns))
+	return lj, nil
+}
+
+// lookupJoin left-joins records against a lookup table held in memory:
+// every record keeps its fields and gets the columns of the row whose key
+// equals its own, or nulls when there is none, so all records have the
+// same fields. Fields the record has already are not overwritten.
+type lookupJoin struct {
+	path    []string
+	prefix  string
+	columns []string // of the lookup table, without the key
+	rows    map[string]map[string]interface{}
+}
+
+// load reads the lookup table, a CSV file with a header or NDJSON or a JSON
+// array of objects, told apart by their content. Keys are compared as
+// text, so a number key of a record matches the same digits in a CSV file.
+func (lj *lookupJoin) load(path, key string) error {
+	input, err := openInput(path)
+	if err != nil {
+		return fmt.Errorf("cannot open -join file: %w", err)
+	}
+	defer input.Close()
+
+	br := bufio.NewReader(input)
+	format, err := avroconvert.SniffFormat(br)
+	if err != nil {
+		return fmt.Errorf("cannot read -join file: %w", err)
+	}
+	lj.rows = make(map[string]map[string]interface{})
+	seen := make(map[string]bool)
+	add := func(row map[string]interface{}) error {
+		k, ok := row[key]
+		if !ok || k == nil {
+			return fmt.Errorf("row without the key column %s", key)
+		}
+		for name := range row {
+			if name != key && !seen[name] {
+				seen[name] = true
+				lj.columns = append(lj.columns, name)
+			}
+		}
+		delete(row, key)
+		lj.rows[joinKey(k)] = row
+		return nil
+	}
+
+	if format == avroconvert.FormatNDJSON || format == avroconvert.FormatJSONArray {
+		records, err := avroconvert.NewJSONRecordReader(br)
+		if err != nil {
+			return fmt.Errorf("cannot read -join file: %w", err)
+		}
+		for n := 1; records.Scan(); n++ {
+			v, err := records.Read()
+			if err == nil {
+				err = add(v.(map[string]interface{}))
+			}
+			if err != nil {
+				return fmt.Errorf("-join file row %d: %w", n, err)
+			}
+		}
+		if err := records.Err(); err != nil {
+			return fmt.Errorf("cannot read -join file: %w", err)
+		}
+		return nil
+	}
+
+	r := csv.NewReader(br)
+	header, err := r.Read()
+	if err != nil {
+		return fmt.Errorf("cannot read -join file header: %w", err)
+	}
+	for n := 2; ; n++ {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("cannot read -join file: %w", err)
+		}
+		row := make(map[string]interface{}, len(header))
+		for i, name := range header {
+			row[name] = record[i]
+		}
+		if err := add(row); err != nil {
+			return fmt.Errorf("-join file line %d: %w", n, err)
+		}
+	}
+}
+
+// joinKey renders a key to look up by: strings as they are and anything
+// else as JSON, so 42 and "42" match.
+func joinKey(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	text, _ := json.Marshal(v)
+	return string(text)
+}
+
+// apply enriches a JSON message. It is used as a decoder transform, after
+// -redact and ahead of -transform, so the expression sees the joined fields.
+func (lj *lookupJoin) apply(msg json.RawMessage) ([]json.RawMessage, error) {
+	v, err := parseMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return []json.RawMessage{msg}, nil
+	}
+	var row map[string]interface{}
+	if key := lookupPath(m, lj.path); key != nil {
+		row = lj.rows[joinKey(key)]
+	}
+	for _, column := range lj.columns {
+		name := lj.prefix + column
+		if _, exists := m[name]; !exists {
+			m[name] = row[column]
+		}
+	}
+	text, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return []json.RawMessage{text}, nil
+}
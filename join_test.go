@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"testing"
+)
+
+// testJoin returns the join flags parsed from args describe.
+func testJoin(t *testing.T, args ...string) (*lookupJoin, error) {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	joining := addJoinFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return joining.lookupJoin()
+}
+
+func TestLookupJoin(t *testing.T) {
+	csvFile := writeTestFile(t, "players.csv", []byte("playerID,country,tier\n42,DE,gold\n7,FR,free\n"))
+	ndjsonFile := writeTestFile(t, "players.ndjson", []byte("{\"id\":42,\"country\":\"DE\",\"tier\":\"gold\"}\n{\"id\":\"7\",\"country\":\"FR\",\"tier\":\"free\"}\n"))
+
+	for _, args := range [][]string{
+		{"-join", csvFile, "-join-on", "player.playerID", "-join-prefix", "p_"},
+		{"-join", ndjsonFile, "-join-on", "player.playerID", "-join-key", "id", "-join-prefix", "p_"},
+	} {
+		lj, err := testJoin(t, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for input, want := range map[string]string{
+			`{"player":{"playerID":42},"score":1}`:       `{"p_country":"DE","p_tier":"gold","player":{"playerID":42},"score":1}`,
+			`{"player":{"playerID":"7"}}`:                `{"p_country":"FR","p_tier":"free","player":{"playerID":"7"}}`,
+			`{"player":{"playerID":99}}`:                 `{"p_country":null,"p_tier":null,"player":{"playerID":99}}`,
+			`{"player":{"playerID":42},"p_tier":"kept"}`: `{"p_country":"DE","p_tier":"kept","player":{"playerID":42}}`,
+			`[1,2]`: `[1,2]`,
+		} {
+			got, err := lj.apply(json.RawMessage(input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 1 || string(got[0]) != want {
+				t.Errorf("%s: apply(%s) = %s, want %s", args[1], input, got, want)
+			}
+		}
+	}
+}
+
+func TestLookupJoinErrors(t *testing.T) {
+	if lj, err := testJoin(t); lj != nil || err != nil {
+		t.Fatalf("no -join gave %+v, %v", lj, err)
+	}
+	players := writeTestFile(t, "players.csv", []byte("playerID,country\n42,DE\n"))
+	for name, args := range map[string][]string{
+		"without -join":    {"-join-on", "playerID"},
+		"without -join-on": {"-join", players},
+		"missing key":      {"-join", players, "-join-on", "playerID", "-join-key", "id"},
+		"missing file":     {"-join", players + ".missing", "-join-on", "playerID"},
+	} {
+		if _, err := testJoin(t, args...); err == nil {
+			t.Errorf("%s: lookupJoin succeeded", name)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"avroparser/pkg/avroconvert"
+)
+
+func runSchemaToJSONSchema(args []string) {
+	fs := flag.NewFlagSet("schema tojsonschema", flag.ExitOnError)
+	inputPath := fs.String("input", "", "Avro file whose writer schema to convert, an .avsc file, or - for stdin")
+	outputPath := fs.String("output", stdioPath, "Output file for the JSON Schema, or - for stdout")
+	timeFormat := fs.String("time-format", avroconvert.TimeFormatRFC3339, "Timestamp format the described records are written in: rfc3339, unix, unixmilli, unixmicro, unixnano or a Go time layout")
+	decimalFormat := fs.String("decimal", "string", "Decimal format the described records are written in: string or number")
+	logging := addLogFlags(fs)
+	parseFlags(fs, args)
+	logging.setup()
+
+	if *inputPath == "" && fs.NArg() > 0 {
+		*inputPath = fs.Arg(0)
+	}
+	if *inputPath == "" {
+		fmt.Fprintln(os.Stderr, "Usage: avroparser schema tojsonschema -input <avro_file|avsc_file|-> [-output <file>|-] [-time-format rfc3339|unix|...] [-decimal string|number]")
+		os.Exit(exitFatal)
+	}
+	if *decimalFormat != "string" && *decimalFormat != "number" {
+		fmt.Fprintf(os.Stderr, "Unknown decimal format %q (expected string or number)\n", *decimalFormat)
+		os.Exit(exitFatal)
+	}
+
+	spec, err := readSchemaSpec(*inputPath)
+	if err != nil {
+		slog.Error("Cannot read schema", "input", displayPath(*inputPath), "error", err)
+		os.Exit(exitFatal)
+	}
+	schema, err := avroconvert.ParseSchema(string(spec))
+	if err != nil {
+		slog.Error("Cannot parse schema", "input", displayPath(*inputPath), "error", err)
+		os.Exit(exitFatal)
+	}
+	gen := jsonSchemaGenerator{timeFormat: *timeFormat, decimalAsNumber: *decimalFormat == "number"}
+	out, err := openOutput(*outputPath)
+	if err == nil {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(gen.document(schema))
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		slog.Error("Cannot write JSON Schema", "output", displayPath(*outputPath), "error", err)
+		os.Exit(exitFatal)
+	}
+}
+
+// jsonSchemaGenerator describes the JSON records the converter writes for
+// an Avro schema as a draft-07 JSON Schema. Named records other than the
+// root go into definitions, so shared and recursive records are described
+// once.
+type jsonSchemaGenerator struct {
+	timeFormat      string
+	decimalAsNumber bool
+	root            *avroconvert.Schema
+	definitions     map[string]interface{}
+}
+
+func (g *jsonSchemaGenerator) document(s *avroconvert.Schema) map[string]interface{} {
+	g.definitions = make(map[string]interface{})
+	var doc map[string]interface{}
+	if s.Kind == "record" {
+		g.root = s
+		doc = g.record(s)
+		doc["title"] = s.Name
+	} else {
+		doc = g.schema(s)
+	}
+	doc["$schema"] = "http://json-schema.org/draft-07/schema#"
+	if len(g.definitions) > 0 {
+		doc["definitions"] = g.definitions
+	}
+	return doc
+}
+
+func (g *jsonSchemaGenerator) schema(s *avroconvert.Schema) map[string]interface{} {
+	if s.LogicalType != "" {
+		if described, ok := g.logical(s); ok {
+			return described
+		}
+	}
+	switch s.Kind {
+	case "null":
+		return map[string]interface{}{"type": "null"}
+	case "boolean":
+		return map[string]interface{}{"type": "boolean"}
+	case "int", "long":
+		return map[string]interface{}{"type": "integer"}
+	case "float", "double":
+		return map[string]interface{}{"type": "number"}
+	case "bytes", "string", "fixed":
+		return map[string]interface{}{"type": "string"}
+	case "enum":
+		return map[string]interface{}{"type": "string", "enum": s.Symbols}
+	case "array":
+		return map[string]interface{}{"type": "array", "items": g.schema(s.Items)}
+	case "map":
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(s.Values)}
+	case "union":
+		return g.union(s)
+	case "record":
+		if s == g.root {
+			return map[string]interface{}{"$ref": "#"}
+		}
+		if _, ok := g.definitions[s.Name]; !ok {
+			// Reserve the name first, so a recursive record refers to it
+			g.definitions[s.Name] = nil
+			g.definitions[s.Name] = g.record(s)
+		}
+		return map[string]interface{}{"$ref": "#/definitions/" + s.Name}
+	}
+	return map[string]interface{}{}
+}
+
+// record describes a record as an object that has every field, as every
+// field is written.
+func (g *jsonSchemaGenerator) record(s *avroconvert.Schema) map[string]interface{} {
+	properties := make(map[string]interface{}, len(s.Fields))
+	required := make([]string, 0, len(s.Fields))
+	for _, f := range s.Fields {
+		property := g.schema(f.Schema)
+		if f.Doc != "" || f.HasDefault {
+			// Copy, as a $ref shared with other fields must not get them
+			described := make(map[string]interface{}, len(property)+2)
+			for k, v := range property {
+				described[k] = v
+			}
+			if f.Doc != "" {
+				described["description"] = f.Doc
+			}
+			if f.HasDefault {
+				described["default"] = f.Default
+			}
+			property = described
+		}
+		properties[f.Name] = property
+		required = append(required, f.Name)
+	}
+	described := map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false,
+	}
+	if s.Doc != "" {
+		described["description"] = s.Doc
+	}
+	return described
+}
+
+// union describes the unwrapped value of any branch. The common union of
+// null and one other type is written as that type being nullable.
+func (g *jsonSchemaGenerator) union(s *avroconvert.Schema) map[string]interface{} {
+	branches := make([]interface{}, 0, len(s.Branches))
+	var other *avroconvert.Schema
+	nullable := false
+	for _, branch := range s.Branches {
+		if branch.Kind == "null" {
+			nullable = true
+		} else {
+			other = branch
+		}
+		branches = append(branches, g.schema(branch))
+	}
+	if nullable && len(s.Branches) == 2 {
+		described := g.schema(other)
+		if t, ok := described["type"].(string); ok {
+			described["type"] = []string{t, "null"}
+			if symbols, ok := described["enum"].([]string); ok {
+				values := make([]interface{}, 0, len(symbols)+1)
+				for _, symbol := range symbols {
+					values = append(values, symbol)
+				}
+				described["enum"] = append(values, nil)
+			}
+			return described
+		}
+	}
+	return map[string]interface{}{"anyOf": branches}
+}
+
+// logical describes the readable forms the converter gives logical types.
+func (g *jsonSchemaGenerator) logical(s *avroconvert.Schema) (map[string]interface{}, bool) {
+	switch s.LogicalType {
+	case "timestamp-millis", "timestamp-micros", "timestamp-nanos",
+		"local-timestamp-millis", "local-timestamp-micros", "local-timestamp-nanos":
+		switch g.timeFormat {
+		case avroconvert.TimeFormatUnix, avroconvert.TimeFormatUnixMilli, avroconvert.TimeFormatUnixMicro, avroconvert.TimeFormatUnixNano:
+			return map[string]interface{}{"type": "integer"}, true
+		case avroconvert.TimeFormatRFC3339:
+			if !strings.HasPrefix(s.LogicalType, "local-") {
+				return map[string]interface{}{"type": "string", "format": "date-time"}, true
+			}
+		}
+		return map[string]interface{}{"type": "string"}, true
+	case "date":
+		return map[string]interface{}{"type": "string", "format": "date"}, true
+	case "time-millis", "time-micros":
+		return map[string]interface{}{"type": "string", "pattern": `^\d{2}:\d{2}:\d{2}(\.\d+)?$`}, true
+	case "decimal":
+		if g.decimalAsNumber {
+			return map[string]interface{}{"type": "number"}, true
+		}
+		return map[string]interface{}{"type": "string", "pattern": `^-?\d+(\.\d+)?$`}, true
+	case "uuid":
+		return map[string]interface{}{"type": "string", "pattern": "^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$"}, true
+	case "duration":
+		return map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"months":       map[string]interface{}{"type": "integer"},
+				"days":         map[string]interface{}{"type": "integer"},
+				"milliseconds": map[string]interface{}{"type": "integer"},
+			},
+			"required":             []string{"months", "days", "milliseconds"},
+			"additionalProperties": false,
+		}, true
+	}
+	return nil, false
+}
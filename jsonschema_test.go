@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"avroparser/pkg/avroconvert"
+)
+
+func TestJSONSchemaGenerator(t *testing.T) {
+	schema, err := avroconvert.ParseSchema(`{"type":"record","name":"Event","doc":"A game event","fields":[
+		{"name":"id","type":"long"},
+		{"name":"kind","type":["null",{"type":"enum","name":"Kind","symbols":["A","B"]}],"default":null},
+		{"name":"ts","type":{"type":"long","logicalType":"timestamp-millis"}},
+		{"name":"price","type":{"type":"bytes","logicalType":"decimal","precision":9,"scale":2}},
+		{"name":"player","type":{"type":"record","name":"Player","fields":[
+			{"name":"name","type":"string","doc":"Display name"},
+			{"name":"friends","type":{"type":"array","items":"Player"}}
+		]}},
+		{"name":"tags","type":{"type":"map","values":["string","int"]}},
+		{"name":"parent","type":["null","Event"]}
+	]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gen := jsonSchemaGenerator{timeFormat: avroconvert.TimeFormatRFC3339}
+	got, err := json.Marshal(gen.document(schema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"$schema":"http://json-schema.org/draft-07/schema#","additionalProperties":false,` +
+		`"definitions":{"Player":{"additionalProperties":false,"properties":{` +
+		`"friends":{"items":{"$ref":"#/definitions/Player"},"type":"array"},` +
+		`"name":{"description":"Display name","type":"string"}},"required":["name","friends"],"type":"object"}},` +
+		`"description":"A game event","properties":{` +
+		`"id":{"type":"integer"},` +
+		`"kind":{"default":null,"enum":["A","B",null],"type":["string","null"]},` +
+		`"parent":{"anyOf":[{"type":"null"},{"$ref":"#"}]},` +
+		`"player":{"$ref":"#/definitions/Player"},` +
+		`"price":{"pattern":"^-?\\d+(\\.\\d+)?$","type":"string"},` +
+		`"tags":{"additionalProperties":{"anyOf":[{"type":"string"},{"type":"integer"}]},"type":"object"},` +
+		`"ts":{"format":"date-time","type":"string"}},` +
+		`"required":["id","kind","ts","price","player","tags","parent"],"title":"Event","type":"object"}`
+	if string(got) != want {
+		t.Fatalf("generated\n%s\nwant\n%s", got, want)
+	}
+
+	gen = jsonSchemaGenerator{timeFormat: avroconvert.TimeFormatUnixMilli, decimalAsNumber: true}
+	got, err = json.Marshal(gen.document(schema.Fields[2].Schema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"$schema":"http://json-schema.org/draft-07/schema#","type":"integer"}`; string(got) != want {
+		t.Fatalf("generated %s, want %s", got, want)
+	}
+}
+
+func TestReadSchemaSpec(t *testing.T) {
+	ocf := writeTestFile(t, "events.avro", writeMessageOCF(t, `{}`))
+	avsc := writeTestFile(t, "events.avsc", []byte(messageSchema))
+	for _, path := range []string{ocf, avsc} {
+		spec, err := readSchemaSpec(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		schema, err := avroconvert.ParseSchema(string(spec))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if schema.Name != "Export" || len(schema.Fields) != 1 {
+			t.Fatalf("%s: read schema %+v", path, schema)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"avroparser/pkg/avroconvert"
+)
+
+// jsonLogs is set when -log-format json is given, for output that has a
+// human-readable form besides its log records, such as the run summary.
+var jsonLogs bool
+
+// logFlags are the logging flags every subcommand takes.
+type logFlags struct {
+	verbose *bool
+	quiet   *bool
+	format  *string
+}
+
+func addLogFlags(fs *flag.FlagSet) *logFlags {
+	return &logFlags{
+		verbose: fs.Bool("v", false, "Verbose logging, including debug messages"),
+		quiet:   fs.Bool("q", false, "Only log warnings and errors"),
+		format:  fs.String("log-format", "text", "Log format on stderr: text or json (one object per line)"),
+	}
+}
+
+// setup installs the logger the flags select, exiting when they conflict.
+func (lf *logFlags) setup() {
+	level := slog.LevelInfo
+	switch {
+	case *lf.verbose && *lf.quiet:
+		fmt.Fprintln(os.Stderr, "-v and -q cannot be combined")
+		os.Exit(exitFatal)
+	case *lf.verbose:
+		level = slog.LevelDebug
+	case *lf.quiet:
+		level = slog.LevelWarn
+	}
+
+	switch *lf.format {
+	case "text":
+		slog.SetDefault(slog.New(newCLIHandler(os.Stderr, level)))
+	case "json":
+		jsonLogs = true
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown log format %q (expected text or json)\n", *lf.format)
+		os.Exit(exitFatal)
+	}
+}
+
+// cliHandler writes log records for people reading a terminal: the message,
+// prefixed by the level for warnings and errors, followed by key=value
+// attributes, e.g.
+//
+//	Warning: Skipping unreadable record input=events.avro error="unexpected EOF"
+type cliHandler struct {
+	w      io.Writer
+	mu     *sync.Mutex
+	level  slog.Leveler
+	attrs  string // attributes added with WithAttrs, rendered
+	prefix string // group prefix of later attribute keys
+}
+
+func newCLIHandler(w io.Writer, level slog.Leveler) *cliHandler {
+	return &cliHandler{w: w, mu: &sync.Mutex{}, level: level}
+}
+
+func (h *cliHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *cliHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	switch {
+	case r.Level >= slog.LevelError:
+		b.WriteString("Error: ")
+	case r.Level >= slog.LevelWarn:
+		b.WriteString("Warning: ")
+	}
+	b.WriteString(r.Message)
+	b.WriteString(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		writeCLIAttr(&b, h.prefix, a)
+		return true
+	})
+	b.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+func (h *cliHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var b strings.Builder
+	for _, a := range attrs {
+		writeCLIAttr(&b, h.prefix, a)
+	}
+	clone := *h
+	clone.attrs += b.String()
+	return &clone
+}
+
+func (h *cliHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	clone := *h
+	clone.prefix += name + "."
+	return &clone
+}
+
+// writeCLIAttr renders an attribute as key=value, quoting values that
+// contain spaces or quotes.
+func writeCLIAttr(b *strings.Builder, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		for _, member := range a.Value.Group() {
+			writeCLIAttr(b, prefix+a.Key+".", member)
+		}
+		return
+	}
+	value := a.Value.String()
+	if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+		value = strconv.Quote(value)
+	}
+	b.WriteString(" " + prefix + a.Key + "=" + value)
+}
+
+// filteredAttr is the attribute counting the records a filter dropped,
+// empty (and so left out) when it dropped none.
+func filteredAttr(stats avroconvert.Stats) slog.Attr {
+	if stats.Filtered == 0 {
+		return slog.Attr{}
+	}
+	return slog.Int("filtered", stats.Filtered)
+}
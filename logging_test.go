@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"avroparser/pkg/avroconvert"
+)
+
+func TestCLIHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(newCLIHandler(&buf, slog.LevelInfo))
+	logger.Debug("Hidden")
+	logger.Info("Decoded events.avro", "messages", 3, filteredAttr(avroconvert.Stats{}))
+	input := logger.With("input", "2026/day 1.avro")
+	input.Warn("Skipping unreadable record", "record", 2, "error", errors.New(`bad "tag"`))
+	input.WithGroup("stats").Error("Failed", slog.Group("rows", "ok", 1), "empty", "")
+
+	want := "Decoded events.avro messages=3\n" +
+		"Warning: Skipping unreadable record input=\"2026/day 1.avro\" record=2 error=\"bad \\\"tag\\\"\"\n" +
+		"Error: Failed input=\"2026/day 1.avro\" stats.rows.ok=1 stats.empty=\"\"\n"
+	if buf.String() != want {
+		t.Fatalf("logged\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestFilteredAttr(t *testing.T) {
+	if a := filteredAttr(avroconvert.Stats{}); !a.Equal(slog.Attr{}) {
+		t.Fatalf("attribute %v for no filtered records", a)
+	}
+	if a := filteredAttr(avroconvert.Stats{Filtered: 4}); a.Key != "filtered" || a.Value.Int64() != 4 {
+		t.Fatalf("attribute %v", a)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"avroparser/pkg/avroconvert"
+)
+
+// longCSVColumns is the header of -long output.
+var longCSVColumns = []string{"event_id", "key", "value_type", "value"}
+
+// longOptions select the long layout of avro2csv, a row per entry of a
+// key-value array instead of a column per key.
+type longOptions struct {
+	path    []string // the key-value array, e.g. event_params
+	eventID []string // field identifying the event, or nil to number the events
+}
+
+// parseLong parses -long and -event-id, returning nil when -long isn't
+// given.
+func parseLong(field, eventID string) (*longOptions, error) {
+	if field == "" {
+		if eventID != "" {
+			return nil, fmt.Errorf("-event-id is only used with -long")
+		}
+		return nil, nil
+	}
+	opts := &longOptions{}
+	for _, path := range []string{field, eventID} {
+		if strings.HasPrefix(path, ".") || strings.HasSuffix(path, ".") || strings.Contains(path, "..") {
+			return nil, fmt.Errorf("invalid field path %q", path)
+		}
+	}
+	opts.path = strings.Split(field, ".")
+	if eventID != "" {
+		opts.eventID = strings.Split(eventID, ".")
+	}
+	return opts, nil
+}
+
+// convertLongCSV converts an input to CSV in the long layout. Its columns
+// are fixed, so unlike convertCSV it reads the input once.
+func convertLongCSV(in inputFile, output string, opts csvOptions) (avroconvert.Stats, error) {
+	opts.decode.blocks = in.blocks
+	input, err := openDecodeInput(in.path, opts.decode)
+	if err != nil {
+		return avroconvert.Stats{}, err
+	}
+	defer input.Close()
+
+	// Events are numbered across the parts of the output
+	var events, rows int
+	split := openOutputSet(output, outputExt("csv", opts.decode.compress), opts.decode, func(w io.Writer) (avroconvert.Sink, error) {
+		lw := &longCSVWriter{w: newCSVWriter(w, opts.dialect), opts: opts.long, events: &events, rows: &rows}
+		if err := lw.w.Write(longCSVColumns); err != nil {
+			return nil, fmt.Errorf("cannot write output file: %w", err)
+		}
+		return lw, nil
+	})
+	defer split.abort()
+
+	stats, err := decodeMessages(input, in.path, opts.decode, split)
+	if err != nil {
+		return stats, err
+	}
+	if err := split.Close(); err != nil {
+		return stats, err
+	}
+
+	slog.Info("Wrote CSV rows", "input", in.path, "events", stats.Messages, "rows", rows, "output", split.written(), filteredAttr(stats))
+	return stats, nil
+}
+
+// longCSVWriter writes a row per entry of each message's key-value array.
+// Messages without entries get no rows.
+type longCSVWriter struct {
+	w      *csvWriter
+	opts   *longOptions
+	events *int
+	rows   *int
+}
+
+func (lw *longCSVWriter) WriteRecord(msg json.RawMessage) error {
+	v, err := parseMessage(msg)
+	if err != nil {
+		return err
+	}
+	*lw.events++
+	id, idNull := strconv.Itoa(*lw.events), false
+	if lw.opts.eventID != nil {
+		value := flatValue(lookupPath(v, lw.opts.eventID))
+		id, idNull = csvValue(value), value == nil
+	}
+
+	entries, _ := lookupPath(v, lw.opts.path).([]interface{})
+	for _, entry := range entries {
+		m, _ := entry.(map[string]interface{})
+		key, ok := m["key"].(string)
+		if !ok {
+			continue
+		}
+		valueType, value := longValue(m["value"])
+		value = flatValue(value)
+		if err := lw.w.WriteRow([]string{id, key, valueType, csvValue(value)}, []bool{idNull, false, false, value == nil}); err != nil {
+			return err
+		}
+		*lw.rows++
+	}
+	return nil
+}
+
+func (lw *longCSVWriter) Flush() error {
+	lw.w.Flush()
+	return lw.w.Error()
+}
+
+func (lw *longCSVWriter) Close() error {
+	return lw.Flush()
+}
+
+// longValue returns the type and value of a key-value entry's value. For
+// Firebase's typed values it is whichever of string_value, int_value,
+// float_value and double_value is set, typed string, int, float or double;
+// other values are typed by their JSON type.
+func longValue(v interface{}) (string, interface{}) {
+	if typed, ok := v.(map[string]interface{}); ok {
+		found := false
+		for _, name := range firebaseValueFields {
+			if value, ok := typed[name]; ok {
+				found = true
+				if value != nil {
+					return strings.TrimSuffix(name, "_value"), value
+				}
+			}
+		}
+		if found {
+			return "null", nil
+		}
+	}
+	if v == nil {
+		return "null", nil
+	}
+	return flatType(flatField{value: flatValue(v), json: isJSONContainer(v)}), v
+}
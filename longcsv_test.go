@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseLong(t *testing.T) {
+	opts, err := parseLong("event.params", "event_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(opts.path) != 2 || opts.path[1] != "params" || len(opts.eventID) != 1 {
+		t.Fatalf("parsed %+v", opts)
+	}
+	if opts, err := parseLong("", ""); opts != nil || err != nil {
+		t.Fatalf("no -long gave %+v, %v", opts, err)
+	}
+	for _, args := range [][2]string{{"", "id"}, {"params.", ""}, {"params", "a..b"}} {
+		if _, err := parseLong(args[0], args[1]); err == nil {
+			t.Errorf("parseLong(%q, %q) succeeded", args[0], args[1])
+		}
+	}
+}
+
+func TestConvertLongCSV(t *testing.T) {
+	data := writeMessageOCF(t,
+		`{"id":"a","event_params":[{"key":"page","value":{"string_value":"home","int_value":null}},{"key":"n","value":{"string_value":null,"int_value":3}}]}`,
+		`{"id":"b"}`,
+		`{"id":"c","event_params":[{"key":"flag","value":true},{"key":"empty","value":{"string_value":null}},{"value":1}]}`,
+	)
+	in := inputFile{path: writeTestFile(t, "events.avro", data), rel: "events.avro"}
+
+	for _, tt := range []struct {
+		eventID string
+		want    string
+	}{
+		{"", "event_id,key,value_type,value\n" +
+			"1,page,string,home\n" +
+			"1,n,int,3\n" +
+			"3,flag,boolean,true\n" +
+			"3,empty,null,\n"},
+		{"id", "event_id,key,value_type,value\n" +
+			"a,page,string,home\n" +
+			"a,n,int,3\n" +
+			"c,flag,boolean,true\n" +
+			"c,empty,null,\n"},
+	} {
+		long, err := parseLong("event_params", tt.eventID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		output := filepath.Join(t.TempDir(), "events.csv")
+		opts := csvOptions{decode: testOptions(t, "message"), long: long}
+		stats, err := convertLongCSV(in, output, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want || stats.Messages != 3 {
+			t.Errorf("-event-id %q wrote %q (%+v), want %q", tt.eventID, got, stats, tt.want)
+		}
+	}
+}
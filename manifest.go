@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// outputManifest collects the output files of a run for -manifest, so a
+// loader can check it received all of them, complete. Files are added as
+// they are closed, by every worker.
+type outputManifest struct {
+	path      string // of the manifest itself
+	outputDir string // file paths are listed relative to it
+	mu        sync.Mutex
+	files     []manifestFile
+}
+
+// manifestDocument is the JSON written to the manifest.
+type manifestDocument struct {
+	Created time.Time      `json:"created"`
+	Files   []manifestFile `json:"files"`
+	Records int64          `json:"total_records"`
+	Bytes   int64          `json:"total_bytes"`
+}
+
+// manifestFile is an output file; Bytes and SHA256 are of the file as
+// written, after compression.
+type manifestFile struct {
+	Path    string `json:"path"`
+	Records int64  `json:"records"`
+	Bytes   int64  `json:"bytes"`
+	SHA256  string `json:"sha256"`
+}
+
+// newOutputManifest returns the manifest of the files written under
+// outputDir, or nil without -manifest. An existing manifest is removed, so
+// one only exists once a run has converted every input.
+func newOutputManifest(path, outputDir string) (*outputManifest, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if !isGCSPath(path) {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("cannot remove previous manifest: %w", err)
+		}
+	}
+	return &outputManifest{path: path, outputDir: outputDir}, nil
+}
+
+// add lists an output file that was closed.
+func (m *outputManifest) add(path string, records, bytes int64, sum []byte) {
+	rel := path
+	if isGCSPath(m.outputDir) {
+		rel = strings.TrimPrefix(path, strings.TrimSuffix(m.outputDir, "/")+"/")
+	} else if r, err := filepath.Rel(m.outputDir, path); err == nil {
+		rel = filepath.ToSlash(r)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files = append(m.files, manifestFile{Path: rel, Records: records, Bytes: bytes, SHA256: hex.EncodeToString(sum)})
+}
+
+// write writes the manifest, with the files in order of their paths.
+func (m *outputManifest) write() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	doc := manifestDocument{Created: time.Now().UTC(), Files: m.files}
+	if doc.Files == nil {
+		doc.Files = []manifestFile{}
+	}
+	sort.Slice(doc.Files, func(i, j int) bool { return doc.Files[i].Path < doc.Files[j].Path })
+	for _, f := range doc.Files {
+		doc.Records += f.Records
+		doc.Bytes += f.Bytes
+	}
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	out, err := openOutput(m.path)
+	if err != nil {
+		return err
+	}
+	if _, err := out.Write(append(data, '\n')); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestManifestSplitOutput(t *testing.T) {
+	var msgs []string
+	for i := 1; i <= 5; i++ {
+		msgs = append(msgs, fmt.Sprintf(`{"id":%d}`, i))
+	}
+	in := inputFile{path: writeTestFile(t, "events.avro", writeMessageOCF(t, msgs...)), rel: filepath.Join("day", "events.avro")}
+
+	dir := t.TempDir()
+	manifestPath := filepath.Join(dir, "manifest.json")
+	// A manifest left by an earlier run is removed
+	if err := os.WriteFile(manifestPath, []byte("stale"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := newOutputManifest(manifestPath, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(manifestPath); !os.IsNotExist(err) {
+		t.Fatalf("previous manifest left: %v", err)
+	}
+
+	opts := testOptions(t, "message")
+	opts.outputDir, opts.format, opts.split, opts.compress, opts.manifest = dir, "ndjson", splitLimits{records: 3}, "gzip", manifest
+	if result := convertFile(in, opts); result.err != nil {
+		t.Fatal(result.err)
+	}
+	if err := manifest.write(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc manifestDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Files) != 2 || doc.Records != 5 {
+		t.Fatalf("manifest %s", data)
+	}
+	var total int64
+	for i, f := range doc.Files {
+		if want := fmt.Sprintf("day/events.part-%04d.ndjson.gz", i+1); f.Path != want {
+			t.Errorf("file %d is %s, want %s", i, f.Path, want)
+		}
+		if want := int64(3 - i); f.Records != want {
+			t.Errorf("%s has %d records, want %d", f.Path, f.Records, want)
+		}
+		// Sizes and checksums are of the compressed file
+		content, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(f.Path)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum := sha256.Sum256(content)
+		if f.Bytes != int64(len(content)) || f.SHA256 != hex.EncodeToString(sum[:]) {
+			t.Errorf("%s listed with %d bytes and %s", f.Path, f.Bytes, f.SHA256)
+		}
+		total += f.Bytes
+	}
+	if doc.Bytes != total {
+		t.Errorf("total bytes %d, want %d", doc.Bytes, total)
+	}
+}
+
+func TestManifestDisabled(t *testing.T) {
+	manifest, err := newOutputManifest("", t.TempDir())
+	if manifest != nil || err != nil {
+		t.Fatalf("newOutputManifest without a path = %v, %v", manifest, err)
+	}
+
+	// Without files the manifest still lists an empty array
+	dir := t.TempDir()
+	if manifest, err = newOutputManifest(filepath.Join(dir, "manifest.json"), dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := manifest.write(); err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]interface{}
+	data, _ := os.ReadFile(manifest.path)
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if files, ok := doc["files"].([]interface{}); !ok || len(files) != 0 {
+		t.Errorf("manifest %s", data)
+	}
+}
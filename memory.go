@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+
+	"avroparser/pkg/avroconvert"
+)
+
+// memoryBudget is the -max-memory budget of a run, shared by the sinks of
+// the inputs converted at once. Each sink is charged for the records it
+// holds in memory until it flushes them to its output, such as the rows of
+// a Parquet row group or an Arrow record batch, and a sink whose next
+// record would take the run over the budget flushes what it holds first,
+// writing a smaller row group or batch rather than holding more.
+type memoryBudget struct {
+	limit int64
+	used  atomic.Int64 // charged to the sinks for records they hold
+}
+
+func newMemoryBudget(limit int64) *memoryBudget {
+	return &memoryBudget{limit: limit}
+}
+
+// guard wraps a sink that holds records until it is flushed to charge them
+// to the budget, returning it with a func releasing what it is charged
+// for, once the sink is closed or abandoned. It returns the sink as it is
+// without a budget.
+func (b *memoryBudget) guard(writer avroconvert.Sink) (avroconvert.Sink, func()) {
+	if b == nil {
+		return writer, func() {}
+	}
+	bs := &budgetSink{Sink: writer, budget: b}
+	if native, ok := writer.(avroconvert.NativeSink); ok {
+		return &budgetNativeSink{budgetSink: bs, native: native}, bs.release
+	}
+	return bs, bs.release
+}
+
+// budgetSink charges the records written through it to a memory budget.
+type budgetSink struct {
+	avroconvert.Sink
+	budget *memoryBudget
+	held   int64 // charged for records written since the last flush
+}
+
+// charge accounts for a record of size bytes about to be written, flushing
+// the records the sink holds first if it would take the run over budget.
+// A record larger than the whole budget fails.
+func (bs *budgetSink) charge(size int64) error {
+	if size > bs.budget.limit {
+		return fmt.Errorf("a record of %s doesn't fit in -max-memory %s", formatBytes(size), formatBytes(bs.budget.limit))
+	}
+	if bs.held > 0 && bs.budget.used.Load()+size > bs.budget.limit {
+		if err := bs.Flush(); err != nil {
+			return err
+		}
+	}
+	bs.held += size
+	bs.budget.used.Add(size)
+	return nil
+}
+
+func (bs *budgetSink) release() {
+	bs.budget.used.Add(-bs.held)
+	bs.held = 0
+}
+
+func (bs *budgetSink) WriteRecord(msg json.RawMessage) error {
+	if err := bs.charge(int64(len(msg))); err != nil {
+		return err
+	}
+	return bs.Sink.WriteRecord(msg)
+}
+
+func (bs *budgetSink) Flush() error {
+	defer bs.release()
+	return bs.Sink.Flush()
+}
+
+func (bs *budgetSink) Close() error {
+	defer bs.release()
+	return bs.Sink.Close()
+}
+
+// budgetNativeSink is a budgetSink for sinks taking whole records.
+type budgetNativeSink struct {
+	*budgetSink
+	native avroconvert.NativeSink
+}
+
+func (bs *budgetNativeSink) SetSchema(schema *avroconvert.Schema) error {
+	return bs.native.SetSchema(schema)
+}
+
+func (bs *budgetNativeSink) WriteNative(record interface{}) error {
+	if err := bs.charge(nativeSize(record)); err != nil {
+		return err
+	}
+	return bs.native.WriteNative(record)
+}
+
+// nativeSize estimates the memory a goavro native record takes: the bytes
+// of its strings and byte arrays, plus the headers of its values.
+func nativeSize(v interface{}) int64 {
+	switch t := v.(type) {
+	case string:
+		return 16 + int64(len(t))
+	case []byte:
+		return 24 + int64(len(t))
+	case []interface{}:
+		n := int64(24)
+		for _, item := range t {
+			n += nativeSize(item)
+		}
+		return n
+	case map[string]interface{}:
+		n := int64(48)
+		for k, item := range t {
+			n += 16 + int64(len(k)) + nativeSize(item)
+		}
+		return n
+	}
+	return 16
+}
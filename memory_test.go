@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+
+	"avroparser/pkg/avroconvert"
+)
+
+// flushCounter is a sink holding the records written since it was last
+// flushed.
+type flushCounter struct {
+	held, flushes int
+}
+
+func (fc *flushCounter) WriteRecord(json.RawMessage) error { fc.held++; return nil }
+func (fc *flushCounter) Flush() error                      { fc.held = 0; fc.flushes++; return nil }
+func (fc *flushCounter) Close() error                      { return fc.Flush() }
+
+func TestMemoryBudget(t *testing.T) {
+	budget := newMemoryBudget(100)
+	var a, b flushCounter
+	sa, _ := budget.guard(&a)
+	sb, release := budget.guard(&b)
+	record := json.RawMessage(strings.Repeat("x", 30))
+
+	for i := 0; i < 3; i++ {
+		if err := sa.WriteRecord(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if a.flushes != 0 || budget.used.Load() != 90 {
+		t.Fatalf("flushed %d times holding %d bytes", a.flushes, budget.used.Load())
+	}
+	// The fourth record would take the run over budget
+	sa.WriteRecord(record)
+	if a.flushes != 1 || a.held != 1 || budget.used.Load() != 30 {
+		t.Fatalf("flushed %d times holding %d records, %d bytes", a.flushes, a.held, budget.used.Load())
+	}
+
+	// Sinks share the budget, and one holding nothing writes on
+	for i := 0; i < 3; i++ {
+		sb.WriteRecord(record)
+	}
+	if b.flushes != 1 || b.held != 1 || budget.used.Load() != 60 {
+		t.Fatalf("second sink flushed %d times holding %d records, %d bytes", b.flushes, b.held, budget.used.Load())
+	}
+	sa.Close()
+	release()
+	if used := budget.used.Load(); used != 0 {
+		t.Errorf("closed and released sinks hold %d bytes", used)
+	}
+
+	if err := sa.WriteRecord(json.RawMessage(strings.Repeat("x", 101))); err == nil || !strings.Contains(err.Error(), "-max-memory") {
+		t.Errorf("wrote a record larger than the budget: %v", err)
+	}
+	if sink, _ := (*memoryBudget)(nil).guard(&a); sink != avroconvert.Sink(&a) {
+		t.Error("guarded a sink without a budget")
+	}
+}
+
+func TestMemoryBudgetRowGroups(t *testing.T) {
+	var msgs []string
+	for i := 0; i < 10; i++ {
+		msgs = append(msgs, fmt.Sprintf(`{"id":%d,"name":"level_start"}`, i))
+	}
+	in := inputFile{path: writeTestFile(t, "events.avro", writeMessageOCF(t, msgs...)), rel: "events.avro"}
+	dir := t.TempDir()
+	opts := testOptions(t, "message")
+	opts.outputDir, opts.format = dir, "parquet"
+	opts.memory = newMemoryBudget(int64(3 * len(msgs[0])))
+	if result := convertFile(in, opts); result.err != nil {
+		t.Fatal(result.err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "events.parquet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := parquet.OpenFile(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sizes []int64
+	for _, rg := range f.RowGroups() {
+		sizes = append(sizes, rg.NumRows())
+	}
+	if f.NumRows() != 10 || len(sizes) != 4 {
+		t.Errorf("wrote %d rows in row groups of %v, want 10 in groups of at most 3", f.NumRows(), sizes)
+	}
+	if used := opts.memory.used.Load(); used != 0 {
+		t.Errorf("the finished conversion holds %d bytes", used)
+	}
+}
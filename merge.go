@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+func runMerge(args []string) {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	outputPath := fs.String("output", "", "Output Avro file, or - for stdout")
+	codec := fs.String("codec", "", "Block compression of the output: null, deflate, snappy, xz or zstd (default: the first input's)")
+	logging := addLogFlags(fs)
+	parseFlags(fs, args)
+	logging.setup()
+
+	if *outputPath == "" || fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: avroparser merge -output <avro_file|-> [-codec null|deflate|snappy|xz|zstd] <avro_file|dir|glob>...")
+		os.Exit(exitFatal)
+	}
+
+	codecName := ""
+	if *codec != "" {
+		var ok bool
+		if codecName, ok = ocfCodecs[*codec]; !ok {
+			fmt.Fprintf(os.Stderr, "Unknown codec %q (expected null, deflate, snappy, xz or zstd)\n", *codec)
+			os.Exit(exitFatal)
+		}
+	}
+
+	var inputs []inputFile
+	for _, arg := range fs.Args() {
+		inputs = append(inputs, mustExpandInputs(arg)...)
+	}
+
+	stats, err := mergeFiles(inputs, *outputPath, codecName)
+	if err != nil {
+		slog.Error("Cannot merge files", "output", displayPath(*outputPath), "error", err)
+		os.Exit(exitFatal)
+	}
+	slog.Info("Merged files", "files", len(inputs), "records", stats.records, "blocks", stats.blocks, "recompressed_blocks", stats.recompressed, "output", displayPath(*outputPath))
+}
+
+// blockStats counts what a block-level command copied.
+type blockStats struct {
+	records      int
+	blocks       int
+	recompressed int // blocks whose codec was changed
+}
+
+// mergeFiles concatenates container files with the same schema into one,
+// block by block. Blocks are copied as they are, and only decompressed and
+// compressed again when their codec differs from the output's, which is
+// the first input's unless codec is set.
+func mergeFiles(inputs []inputFile, output, codec string) (blockStats, error) {
+	var stats blockStats
+	out, err := openOutput(output)
+	if err != nil {
+		return stats, err
+	}
+
+	var ow *ocfWriter
+	var schema []byte
+	for _, in := range inputs {
+		err = func() error {
+			r, err := openInput(in.path)
+			if err != nil {
+				return err
+			}
+			defer r.Close()
+			or, err := newOCFReader(r)
+			if err != nil {
+				return err
+			}
+			defer or.Close()
+
+			if ow == nil {
+				schema = or.header.schema()
+				if codec == "" {
+					codec = or.header.codec()
+				}
+				if ow, err = newOCFWriter(out, string(schema), codec); err != nil {
+					return err
+				}
+			} else if !sameSchema(schema, or.header.schema()) {
+				return fmt.Errorf("schema differs from that of %s", inputs[0].path)
+			}
+			return copyBlocks(or, ow, &stats)
+		}()
+		if err != nil {
+			err = fmt.Errorf("%s: %w", in.path, err)
+			break
+		}
+	}
+
+	if err == nil && ow == nil {
+		err = errors.New("no input files")
+	}
+	if err == nil {
+		err = ow.Close()
+	}
+	if err != nil {
+		abortOutput(out)
+		return stats, err
+	}
+	if err := out.Close(); err != nil {
+		return stats, fmt.Errorf("cannot write output file: %w", err)
+	}
+	return stats, nil
+}
+
+// copyBlocks writes every block of or to ow, recompressing blocks when the
+// codecs differ.
+func copyBlocks(or *ocfReader, ow *ocfWriter, stats *blockStats) error {
+	recompress := or.header.codec() != ow.name
+	for {
+		count, data, err := or.next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if recompress {
+			raw, err := or.decompress(data)
+			if err != nil {
+				return fmt.Errorf("cannot decompress block: %w", err)
+			}
+			if data, err = ow.compress(raw); err != nil {
+				return fmt.Errorf("cannot compress block: %w", err)
+			}
+			stats.recompressed++
+		}
+		if err := ow.writeBlock(count, data); err != nil {
+			return err
+		}
+		stats.records += count
+		stats.blocks++
+	}
+}
+
+// sameSchema reports whether two schemas are the same JSON, ignoring
+// whitespace.
+func sameSchema(a, b []byte) bool {
+	var ca, cb bytes.Buffer
+	if json.Compact(&ca, a) != nil || json.Compact(&cb, b) != nil {
+		return bytes.Equal(a, b)
+	}
+	return bytes.Equal(ca.Bytes(), cb.Bytes())
+}
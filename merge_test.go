@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMergeFiles(t *testing.T) {
+	inputs := []inputFile{
+		{path: writeTestFile(t, "a.avro", writeTestOCF(t, ocfCodecDeflate, 300, nil, nil))},
+		{path: writeTestFile(t, "b.avro", writeTestOCF(t, ocfCodecSnappy, 200, nil, nil))},
+	}
+	for _, tt := range []struct {
+		codec        string
+		recompressed bool
+	}{
+		{"", false},
+		{ocfCodecZstandard, true},
+	} {
+		output := filepath.Join(t.TempDir(), "merged.avro")
+		stats, err := mergeFiles(inputs, output, tt.codec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := readBlockIDs(t, data)
+		if stats.records != 500 || len(ids) != 500 || ids[299] != 299 || ids[300] != 0 {
+			t.Fatalf("codec %q: merged %+v, read %d records", tt.codec, stats, len(ids))
+		}
+		// Only blocks whose codec differs from the output's are recompressed
+		if all := stats.recompressed == stats.blocks; all != tt.recompressed || stats.recompressed == 0 {
+			t.Fatalf("codec %q: recompressed %d of %d blocks", tt.codec, stats.recompressed, stats.blocks)
+		}
+	}
+}
+
+func TestMergeFilesSchemaMismatch(t *testing.T) {
+	inputs := []inputFile{
+		{path: writeTestFile(t, "a.avro", writeTestOCF(t, ocfCodecNull, 3, nil, nil))},
+		{path: writeTestFile(t, "b.avro", writeMessageOCF(t, `{"id":1}`))},
+	}
+	dir := t.TempDir()
+	if _, err := mergeFiles(inputs, filepath.Join(dir, "merged.avro"), ""); err == nil {
+		t.Fatal("merged files with different schemas")
+	}
+	// The blocks of the first input written before it aren't kept
+	checkNoOutputs(t, dir)
+}
+
+func TestSameSchema(t *testing.T) {
+	if !sameSchema([]byte(testSchema), []byte(`{"type":"record","name":"Event","fields":[{"name":"id","type":"long"},{"name":"name","type":"string"},{"name":"payload","type":"bytes"}]}`)) {
+		t.Fatal("schemas differing in whitespace aren't the same")
+	}
+	if sameSchema([]byte(testSchema), []byte(messageSchema)) {
+		t.Fatal("different schemas are the same")
+	}
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"avroparser/pkg/avroconvert"
+	"github.com/linkedin/goavro/v2"
+)
+
+// nativeConverter converts plain JSON values, decoded with UseNumber, into
+// the goavro native values a schema describes. It accepts what the JSON
+// converter writes: unwrapped unions, enums as strings, bytes and fixed as
+// text, and logical types in their readable forms.
+type nativeConverter struct {
+	timeFormat string         // one of the avroconvert.TimeFormat constants or a Go time layout
+	location   *time.Location // zone of timestamps parsed with a layout
+}
+
+func newNativeConverter(timeFormat, timeZone string) (*nativeConverter, error) {
+	if timeFormat == "" {
+		timeFormat = avroconvert.TimeFormatRFC3339
+	}
+	location, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone %q: %w", timeZone, err)
+	}
+	return &nativeConverter{timeFormat: timeFormat, location: location}, nil
+}
+
+// native converts v as described by schema s.
+func (c *nativeConverter) native(s *avroconvert.Schema, v interface{}) (interface{}, error) {
+	if s.LogicalType != "" && v != nil {
+		if converted, ok, err := c.logical(s, v); ok || err != nil {
+			return converted, err
+		}
+	}
+
+	switch s.Kind {
+	case "null":
+		if v != nil {
+			return nil, fmt.Errorf("expected null, got %s", jsonKind(v))
+		}
+		return nil, nil
+
+	case "boolean":
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+
+	case "int":
+		if n, ok := v.(json.Number); ok {
+			i, err := strconv.ParseInt(n.String(), 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("expected int, got %s", n)
+			}
+			return int32(i), nil
+		}
+
+	case "long":
+		if n, ok := v.(json.Number); ok {
+			i, err := n.Int64()
+			if err != nil {
+				return nil, fmt.Errorf("expected long, got %s", n)
+			}
+			return i, nil
+		}
+
+	case "float", "double":
+		f, ok := nativeFloat(v)
+		if !ok {
+			break
+		}
+		if s.Kind == "float" {
+			return float32(f), nil
+		}
+		return f, nil
+
+	case "string":
+		if text, ok := v.(string); ok {
+			return text, nil
+		}
+
+	case "bytes":
+		if text, ok := v.(string); ok {
+			return textBytes(text), nil
+		}
+
+	case "enum":
+		if text, ok := v.(string); ok {
+			for _, symbol := range s.Symbols {
+				if symbol == text {
+					return text, nil
+				}
+			}
+			return nil, fmt.Errorf("%q is not a symbol of enum %s", text, s.Name)
+		}
+
+	case "fixed":
+		if text, ok := v.(string); ok {
+			return fixedBytes(s, text)
+		}
+
+	case "record":
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			break
+		}
+		// Missing fields are left to goavro, which fills in their defaults
+		out := make(map[string]interface{}, len(s.Fields))
+		for _, f := range s.Fields {
+			value, ok := m[f.Name]
+			if !ok {
+				continue
+			}
+			converted, err := c.native(f.Schema, value)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %w", f.Name, err)
+			}
+			out[f.Name] = converted
+		}
+		return out, nil
+
+	case "array":
+		items, ok := v.([]interface{})
+		if !ok {
+			break
+		}
+		out := make([]interface{}, len(items))
+		for i, item := range items {
+			converted, err := c.native(s.Items, item)
+			if err != nil {
+				return nil, fmt.Errorf("item %d: %w", i, err)
+			}
+			out[i] = converted
+		}
+		return out, nil
+
+	case "map":
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			break
+		}
+		out := make(map[string]interface{}, len(m))
+		for k, value := range m {
+			converted, err := c.native(s.Values, value)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: %w", k, err)
+			}
+			out[k] = converted
+		}
+		return out, nil
+
+	case "union":
+		return c.union(s, v)
+	}
+
+	return nil, fmt.Errorf("expected %s, got %s", s.Kind, jsonKind(v))
+}
+
+// union wraps a value in the first union branch it converts to.
+func (c *nativeConverter) union(s *avroconvert.Schema, v interface{}) (interface{}, error) {
+	for _, branch := range s.Branches {
+		if (branch.Kind == "null") != (v == nil) {
+			continue
+		}
+		converted, err := c.native(branch, v)
+		if err == nil {
+			return goavro.Union(avroconvert.UnionBranchName(branch), converted), nil
+		}
+	}
+	return nil, fmt.Errorf("%s matches no branch of the union", jsonKind(v))
+}
+
+// logical converts the readable forms of Avro logical types. Numbers are
+// taken as the underlying value, or as a count of the -time-format unit for
+// timestamps. It reports false when v isn't a form of the logical type, so
+// the caller falls back to the underlying type.
+func (c *nativeConverter) logical(s *avroconvert.Schema, v interface{}) (interface{}, bool, error) {
+	switch s.LogicalType {
+	case "timestamp-millis", "timestamp-micros", "timestamp-nanos":
+		t, ok, err := c.timestamp(s.LogicalType, v, false)
+		if !ok || err != nil {
+			return nil, ok, err
+		}
+		if s.LogicalType == "timestamp-nanos" {
+			// goavro doesn't know timestamp-nanos, so it takes the plain long
+			return t.UnixNano(), true, nil
+		}
+		return t, true, nil
+
+	case "local-timestamp-millis", "local-timestamp-micros", "local-timestamp-nanos":
+		t, ok, err := c.timestamp(strings.TrimPrefix(s.LogicalType, "local-"), v, true)
+		if !ok || err != nil {
+			return nil, ok, err
+		}
+		switch s.LogicalType {
+		case "local-timestamp-millis":
+			return t.UnixMilli(), true, nil
+		case "local-timestamp-micros":
+			return t.UnixMicro(), true, nil
+		}
+		return t.UnixNano(), true, nil
+
+	case "date":
+		text, ok := v.(string)
+		if !ok {
+			return nil, false, nil
+		}
+		t, err := time.Parse("2006-01-02", text)
+		if err != nil {
+			return nil, true, fmt.Errorf("invalid date %q", text)
+		}
+		return t, true, nil
+
+	case "time-millis", "time-micros":
+		text, ok := v.(string)
+		if !ok {
+			return nil, false, nil
+		}
+		t, err := time.Parse("15:04:05.999999", text)
+		if err != nil {
+			return nil, true, fmt.Errorf("invalid time of day %q", text)
+		}
+		return t.Sub(time.Date(0, 1, 1, 0, 0, 0, 0, time.UTC)), true, nil
+
+	case "decimal":
+		var text string
+		switch t := v.(type) {
+		case string:
+			text = t
+		case json.Number:
+			text = t.String()
+		default:
+			return nil, false, nil
+		}
+		r, ok := new(big.Rat).SetString(text)
+		if !ok {
+			return nil, true, fmt.Errorf("invalid decimal %q", text)
+		}
+		return r, true, nil
+
+	case "uuid":
+		text, ok := v.(string)
+		if !ok || s.Kind != "fixed" {
+			return nil, false, nil
+		}
+		b, err := hex.DecodeString(strings.ReplaceAll(text, "-", ""))
+		if err != nil || len(b) != s.Size {
+			return nil, true, fmt.Errorf("invalid uuid %q", text)
+		}
+		return b, true, nil
+
+	case "duration":
+		m, ok := v.(map[string]interface{})
+		if !ok || s.Kind != "fixed" || s.Size != 12 {
+			return nil, false, nil
+		}
+		b := make([]byte, 0, 12)
+		for _, key := range []string{"months", "days", "milliseconds"} {
+			n, _ := m[key].(json.Number)
+			u, err := strconv.ParseUint(n.String(), 10, 32)
+			if err != nil {
+				return nil, true, fmt.Errorf("duration %s: expected an unsigned int, got %v", key, m[key])
+			}
+			b = binary.LittleEndian.AppendUint32(b, uint32(u))
+		}
+		return b, true, nil
+	}
+	return nil, false, nil
+}
+
+// timestamp parses a timestamp written in the -time-format. Strings are
+// parsed with the format's layout, local ones as UTC wall clock times;
+// numbers count the format's unit, or the logical type's own unit when the
+// format is a layout.
+func (c *nativeConverter) timestamp(logicalType string, v interface{}, local bool) (time.Time, bool, error) {
+	switch t := v.(type) {
+	case string:
+		layout, location := c.timeFormat, c.location
+		if layout == avroconvert.TimeFormatRFC3339 || isUnixTimeFormat(layout) {
+			layout = time.RFC3339Nano
+			if local {
+				layout = avroconvert.LocalTimestampLayout
+			}
+		}
+		if local {
+			location = time.UTC
+		}
+		parsed, err := time.ParseInLocation(layout, t, location)
+		if err != nil {
+			return time.Time{}, true, fmt.Errorf("invalid timestamp %q", t)
+		}
+		return parsed, true, nil
+
+	case json.Number:
+		n, err := t.Int64()
+		if err != nil {
+			return time.Time{}, true, fmt.Errorf("invalid timestamp %s", t)
+		}
+		unit := c.timeFormat
+		if !isUnixTimeFormat(unit) {
+			unit = map[string]string{
+				"timestamp-millis": avroconvert.TimeFormatUnixMilli,
+				"timestamp-micros": avroconvert.TimeFormatUnixMicro,
+				"timestamp-nanos":  avroconvert.TimeFormatUnixNano,
+			}[logicalType]
+		}
+		switch unit {
+		case avroconvert.TimeFormatUnix:
+			return time.Unix(n, 0).UTC(), true, nil
+		case avroconvert.TimeFormatUnixMilli:
+			return time.UnixMilli(n).UTC(), true, nil
+		case avroconvert.TimeFormatUnixMicro:
+			return time.UnixMicro(n).UTC(), true, nil
+		}
+		return time.Unix(0, n).UTC(), true, nil
+	}
+	return time.Time{}, false, nil
+}
+
+// isUnixTimeFormat reports whether a -time-format writes numbers.
+func isUnixTimeFormat(format string) bool {
+	switch format {
+	case avroconvert.TimeFormatUnix, avroconvert.TimeFormatUnixMilli, avroconvert.TimeFormatUnixMicro, avroconvert.TimeFormatUnixNano:
+		return true
+	}
+	return false
+}
+
+// nativeFloat reads a float or double, including the strings finiteFloat
+// writes for NaN and infinities.
+func nativeFloat(v interface{}) (float64, bool) {
+	switch t := v.(type) {
+	case json.Number:
+		f, err := t.Float64()
+		return f, err == nil
+	case string:
+		switch t {
+		case "NaN":
+			return math.NaN(), true
+		case "Infinity":
+			return math.Inf(1), true
+		case "-Infinity":
+			return math.Inf(-1), true
+		}
+	}
+	return 0, false
+}
+
+// textBytes reads a bytes value written as text, or base64 encoded as
+// the JSON converter does for values that aren't UTF-8. Text is only taken
+// as base64 when it decodes to bytes that aren't UTF-8, since the converter
+// would have written those as they are.
+func textBytes(text string) []byte {
+	if b, err := base64.StdEncoding.DecodeString(text); err == nil && !utf8.Valid(b) {
+		return b
+	}
+	return []byte(text)
+}
+
+// fixedBytes reads a fixed value the way textBytes does, checking its size.
+func fixedBytes(s *avroconvert.Schema, text string) ([]byte, error) {
+	if b := textBytes(text); len(b) == s.Size {
+		return b, nil
+	}
+	return nil, fmt.Errorf("expected %d bytes for fixed %s, got %q", s.Size, s.Name, text)
+}
+
+// jsonKind names the JSON type of a value for error messages.
+func jsonKind(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number, float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
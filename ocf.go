@@ -0,0 +1,569 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+
+	"github.com/linkedin/goavro/v2"
+)
+
+// ocfMagic starts every Avro Object Container File.
+var ocfMagic = []byte{'O', 'b', 'j', 1}
+
+// ocfHeader is the header of an Avro Object Container File.
+type ocfHeader struct {
+	metadata map[string][]byte
+	sync     [16]byte
+}
+
+// schema returns the writer schema JSON stored in the header.
+func (h *ocfHeader) schema() []byte {
+	return h.metadata["avro.schema"]
+}
+
+// codec returns the compression codec of the file's data blocks. A missing
+// avro.codec entry means the blocks are uncompressed.
+func (h *ocfHeader) codec() string {
+	if codec, ok := h.metadata["avro.codec"]; ok && len(codec) > 0 {
+		return string(codec)
+	}
+	return "null"
+}
+
+// readOCFHeader reads the magic bytes, metadata map and sync marker at the
+// start of an Object Container File.
+func readOCFHeader(r *bufio.Reader) (*ocfHeader, error) {
+	magic := make([]byte, len(ocfMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, fmt.Errorf("cannot read magic bytes: %w", err)
+	}
+	if !bytes.Equal(magic, ocfMagic) {
+		return nil, errors.New("not an Avro object container file")
+	}
+
+	h := &ocfHeader{metadata: make(map[string][]byte)}
+	for {
+		count, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read metadata block count: %w", err)
+		}
+		if count == 0 {
+			break
+		}
+		if count < 0 {
+			// A negative count is followed by the block size in bytes
+			count = -count
+			if _, err := binary.ReadVarint(r); err != nil {
+				return nil, fmt.Errorf("cannot read metadata block size: %w", err)
+			}
+		}
+		for i := int64(0); i < count; i++ {
+			key, err := readAvroBytes(r)
+			if err != nil {
+				return nil, fmt.Errorf("cannot read metadata key: %w", err)
+			}
+			value, err := readAvroBytes(r)
+			if err != nil {
+				return nil, fmt.Errorf("cannot read metadata value for %q: %w", key, err)
+			}
+			h.metadata[string(key)] = value
+		}
+	}
+
+	if _, err := io.ReadFull(r, h.sync[:]); err != nil {
+		return nil, fmt.Errorf("cannot read sync marker: %w", err)
+	}
+	return h, nil
+}
+
+// readAvroBytes reads a length-prefixed Avro bytes or string value.
+func readAvroBytes(r *bufio.Reader) ([]byte, error) {
+	n, err := binary.ReadVarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 {
+		return nil, fmt.Errorf("negative length %d", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// ocfBlockSize is the default uncompressed size at which ocfWriter ends a
+// block.
+const ocfBlockSize = 64 << 10
+
+// ocfWriter writes records as an Avro Object Container File. It supports
+// every registered block codec, where goavro's own OCF writer only has
+// deflate and snappy.
+type ocfWriter struct {
+	w           io.Writer
+	codec       *goavro.Codec
+	name        string // block codec, one of the ocfCodec constants
+	compression *blockCodec
+	sync        [16]byte
+	blockSize   int
+	block       []byte
+	count       int
+	header      bool
+}
+
+// newOCFWriter starts a container file with the given writer schema and
+// block codec. The header is written with the first block, or on Close.
+func newOCFWriter(w io.Writer, schema, name string) (*ocfWriter, error) {
+	codec, err := goavro.NewCodec(schema)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse schema: %w", err)
+	}
+	compression, err := newBlockCodec(name)
+	if err != nil {
+		return nil, err
+	}
+	ow := &ocfWriter{w: w, codec: codec, name: name, compression: compression, blockSize: ocfBlockSize}
+	if _, err := rand.Read(ow.sync[:]); err != nil {
+		compression.close()
+		return nil, err
+	}
+	return ow, nil
+}
+
+// Write appends a record, given as a goavro native value.
+func (ow *ocfWriter) Write(record interface{}) error {
+	block, err := ow.codec.BinaryFromNative(ow.block, record)
+	if err != nil {
+		return err
+	}
+	ow.block = block
+	ow.count++
+	if len(ow.block) >= ow.blockSize {
+		return ow.flush()
+	}
+	return nil
+}
+
+// writeEncoded appends count records that are already binary encoded.
+func (ow *ocfWriter) writeEncoded(count int, data []byte) error {
+	ow.block = append(ow.block, data...)
+	ow.count += count
+	if len(ow.block) >= ow.blockSize {
+		return ow.flush()
+	}
+	return nil
+}
+
+// Close writes the records not written yet. It doesn't close the
+// underlying writer.
+func (ow *ocfWriter) Close() error {
+	defer ow.compression.close()
+	return ow.flush()
+}
+
+// flush writes the pending records as a block, or just the header if
+// nothing was written yet.
+func (ow *ocfWriter) flush() error {
+	if ow.count == 0 {
+		return ow.writeBlock(0, nil)
+	}
+	data, err := ow.compress(ow.block)
+	if err != nil {
+		return fmt.Errorf("cannot compress block: %w", err)
+	}
+	count := ow.count
+	ow.block, ow.count = ow.block[:0], 0
+	return ow.writeBlock(count, data)
+}
+
+// writeBlock writes a block of count records whose data is already
+// compressed with the file's codec, and the header before the first one. An
+// empty block only writes the header.
+func (ow *ocfWriter) writeBlock(count int, data []byte) error {
+	var buf []byte
+	if !ow.header {
+		buf = ow.appendHeader(buf)
+		ow.header = true
+	}
+	if count > 0 {
+		buf = binary.AppendVarint(buf, int64(count))
+		buf = binary.AppendVarint(buf, int64(len(data)))
+		buf = append(buf, data...)
+		buf = append(buf, ow.sync[:]...)
+	}
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := ow.w.Write(buf)
+	return err
+}
+
+func (ow *ocfWriter) appendHeader(buf []byte) []byte {
+	buf = append(buf, ocfMagic...)
+	buf = binary.AppendVarint(buf, 2)
+	for _, entry := range [][2]string{{"avro.schema", ow.codec.Schema()}, {"avro.codec", ow.name}} {
+		buf = appendAvroBytes(buf, []byte(entry[0]))
+		buf = appendAvroBytes(buf, []byte(entry[1]))
+	}
+	buf = binary.AppendVarint(buf, 0)
+	return append(buf, ow.sync[:]...)
+}
+
+// compress encodes a block's data with the file's codec.
+func (ow *ocfWriter) compress(data []byte) ([]byte, error) {
+	return ow.compression.compress(data)
+}
+
+// appendAvroBytes appends a length-prefixed Avro bytes or string value.
+func appendAvroBytes(buf, b []byte) []byte {
+	buf = binary.AppendVarint(buf, int64(len(b)))
+	return append(buf, b...)
+}
+
+// ocfReader reads the data blocks of an Object Container File without
+// decoding their records, for commands that copy or recompress blocks.
+type ocfReader struct {
+	r           *bufio.Reader
+	counter     *countingReader
+	header      *ocfHeader
+	compression *blockCodec
+	dataStart   int64 // offset of the first block
+	start       int64 // offset of the block next returned last
+}
+
+func newOCFReader(r io.Reader) (*ocfReader, error) {
+	counter := &countingReader{r: r}
+	or := &ocfReader{r: bufio.NewReader(counter), counter: counter}
+	var err error
+	if or.header, err = readOCFHeader(or.r); err != nil {
+		return nil, err
+	}
+	or.dataStart = or.offset()
+	if or.compression, err = newBlockCodec(or.header.codec()); err != nil {
+		return nil, err
+	}
+	return or, nil
+}
+
+// offset returns the position in the file of the next byte to read.
+func (or *ocfReader) offset() int64 {
+	return or.counter.n - int64(or.r.Buffered())
+}
+
+// next returns the record count and compressed data of the next block, or
+// io.EOF after the last one.
+func (or *ocfReader) next() (int, []byte, error) {
+	var skip int
+	return or.nextAfter(&skip)
+}
+
+// nextAfter is next for a reader skipping the first *skip records: blocks
+// holding only records to skip are passed over without keeping their data,
+// and *skip reduced by their record counts.
+func (or *ocfReader) nextAfter(skip *int) (int, []byte, error) {
+	for {
+		or.start = or.offset()
+		count, err := binary.ReadVarint(or.r)
+		if err == io.EOF {
+			return 0, nil, io.EOF
+		}
+		if err != nil {
+			return 0, nil, fmt.Errorf("cannot read block count: %w", err)
+		}
+		size, err := binary.ReadVarint(or.r)
+		if err != nil {
+			return 0, nil, fmt.Errorf("cannot read block size: %w", err)
+		}
+		if count < 0 || size < 0 {
+			return 0, nil, fmt.Errorf("invalid block of %d records in %d bytes", count, size)
+		}
+		var data []byte
+		skipped := count > 0 && count <= int64(*skip)
+		if skipped {
+			if _, err := or.r.Discard(int(size)); err != nil {
+				return 0, nil, fmt.Errorf("cannot read block: %w", err)
+			}
+		} else {
+			data = make([]byte, size)
+			if _, err := io.ReadFull(or.r, data); err != nil {
+				return 0, nil, fmt.Errorf("cannot read block: %w", err)
+			}
+		}
+		var marker [16]byte
+		if _, err := io.ReadFull(or.r, marker[:]); err != nil {
+			return 0, nil, fmt.Errorf("cannot read sync marker: %w", err)
+		}
+		if marker != or.header.sync {
+			return 0, nil, errors.New("sync marker mismatch")
+		}
+		if skipped {
+			*skip -= int(count)
+			continue
+		}
+		return int(count), data, nil
+	}
+}
+
+// decompress decodes the data of a block.
+func (or *ocfReader) decompress(data []byte) ([]byte, error) {
+	return or.compression.decompress(data)
+}
+
+// Close releases the reader's codec. It doesn't close the underlying
+// reader.
+func (or *ocfReader) Close() {
+	if or.compression != nil {
+		or.compression.close()
+	}
+}
+
+// ocfRecordReader is an avroconvert.RawRecordReader over a container file,
+// decoding the records of each block itself so it can return their bytes.
+// Records aren't delimited, so a record that cannot be decoded makes the
+// rest of its block unreadable: those bytes are returned as the failed
+// record's, and reading goes on with the next block. A block that cannot be
+// decompressed is skipped the same way.
+//
+// With more than one worker, blocks are decompressed and decoded
+// concurrently, up to workers at a time ahead of the records being read,
+// and their records returned in file order.
+//
+// The records before position skip, for -offset, are passed over: whole
+// blocks of them without decompressing or decoding them.
+type ocfRecordReader struct {
+	blocks  *ocfReader
+	codec   *goavro.Codec
+	workers int
+	skip    int         // records still to pass over, read by one goroutine at a time
+	pending []ocfRecord // records of the current block not read yet
+	end     error       // error after the current block's records
+	record  ocfRecord
+	err     error
+	done    bool
+	closed  bool
+
+	// Decoded blocks in file order, with workers > 1
+	queue    chan chan *ocfBlock
+	quit     chan struct{}
+	decoders sync.WaitGroup
+
+	// Totals of the blocks read so far
+	blockCount   int
+	compressed   int64
+	uncompressed int64
+
+	// Byte range of the block holding the current record
+	blockStart, blockEnd int64
+}
+
+// ocfRecord is a record of a block, or the rest of the block from a record
+// that cannot be decoded.
+type ocfRecord struct {
+	value interface{}
+	raw   []byte
+	err   error
+}
+
+// ocfBlock is a block with its records decoded.
+type ocfBlock struct {
+	records      []ocfRecord
+	err          error // ends the input after the records
+	read         bool  // whether the block was read, even if not decoded
+	compressed   int
+	uncompressed int
+	start, end   int64 // byte range of the block in the file
+}
+
+// newOCFRecordReader reads a container file from the record at position
+// skip on, decoding up to workers blocks at a time; 0 means one per CPU.
+func newOCFRecordReader(r io.Reader, workers, skip int) (*ocfRecordReader, error) {
+	blocks, err := newOCFReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create OCF reader: %w", err)
+	}
+	codec, err := goavro.NewCodec(string(blocks.header.schema()))
+	if err != nil {
+		blocks.Close()
+		return nil, fmt.Errorf("cannot parse writer schema: %w", err)
+	}
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	rr := &ocfRecordReader{blocks: blocks, codec: codec, workers: workers, skip: skip}
+	if workers > 1 {
+		rr.queue = make(chan chan *ocfBlock, workers-1)
+		rr.quit = make(chan struct{})
+		rr.decoders.Add(1)
+		go rr.readBlocks()
+	}
+	return rr, nil
+}
+
+// readBlocks reads the blocks of the file and decodes each in a goroutine
+// of its own, queueing their results in file order. The queue's capacity
+// bounds how many are decoded at once.
+func (rr *ocfRecordReader) readBlocks() {
+	defer rr.decoders.Done()
+	defer close(rr.queue)
+	for {
+		count, data, drop, err := rr.readBlock()
+		if err == io.EOF {
+			return
+		}
+		start, end := rr.blocks.start, rr.blocks.offset()
+		result := make(chan *ocfBlock, 1)
+		select {
+		case rr.queue <- result:
+		case <-rr.quit:
+			return
+		}
+		if err != nil {
+			result <- &ocfBlock{err: err}
+			return
+		}
+		rr.decoders.Add(1)
+		go func() {
+			defer rr.decoders.Done()
+			b := rr.decodeBlock(count, data, drop)
+			b.start, b.end = start, end
+			result <- b
+		}()
+	}
+}
+
+// nextBlock returns the next block, or nil after the last one.
+func (rr *ocfRecordReader) nextBlock() *ocfBlock {
+	if rr.queue != nil {
+		result, ok := <-rr.queue
+		if !ok {
+			return nil
+		}
+		return <-result
+	}
+	count, data, drop, err := rr.readBlock()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return &ocfBlock{err: err}
+	}
+	b := rr.decodeBlock(count, data, drop)
+	b.start, b.end = rr.blocks.start, rr.blocks.offset()
+	return b
+}
+
+// readBlock returns the next block with records from position skip on, and
+// how many of its first records come before it.
+func (rr *ocfRecordReader) readBlock() (int, []byte, int, error) {
+	count, data, err := rr.blocks.nextAfter(&rr.skip)
+	drop := min(rr.skip, count)
+	rr.skip -= drop
+	return count, data, drop, err
+}
+
+// decodeBlock decompresses a block of count records and decodes them,
+// leaving out the first drop.
+func (rr *ocfRecordReader) decodeBlock(count int, data []byte, drop int) *ocfBlock {
+	b := &ocfBlock{read: true, compressed: len(data)}
+	block, err := rr.blocks.decompress(data)
+	if err != nil {
+		b.records = []ocfRecord{{raw: data, err: fmt.Errorf("cannot decompress block of %d records: %w", count, err)}}
+		return b
+	}
+	b.uncompressed = len(block)
+	b.records = make([]ocfRecord, 0, count)
+	for left := count - 1; left >= 0; left-- {
+		record, rest, err := rr.codec.NativeFromBinary(block)
+		if err != nil {
+			b.records = append(b.records, ocfRecord{raw: block, err: fmt.Errorf("%w (skipping the %d further records of the block)", err, left)})
+			return b
+		}
+		if drop > 0 {
+			drop--
+		} else {
+			b.records = append(b.records, ocfRecord{value: record, raw: block[:len(block)-len(rest)]})
+		}
+		block = rest
+	}
+	if len(block) != 0 {
+		b.err = fmt.Errorf("%d extra bytes after the last record of a block", len(block))
+	}
+	return b
+}
+
+func (rr *ocfRecordReader) Scan() bool {
+	if rr.done {
+		return false
+	}
+	for len(rr.pending) == 0 {
+		if rr.end != nil {
+			return rr.stop(rr.end)
+		}
+		b := rr.nextBlock()
+		if b == nil {
+			return rr.stop(nil)
+		}
+		if b.read {
+			rr.blockCount++
+			rr.compressed += int64(b.compressed)
+			rr.uncompressed += int64(b.uncompressed)
+		}
+		rr.pending, rr.end = b.records, b.err
+		rr.blockStart, rr.blockEnd = b.start, b.end
+	}
+	rr.record, rr.pending = rr.pending[0], rr.pending[1:]
+	return true
+}
+
+// stop ends reading, with err unless the file ended cleanly.
+func (rr *ocfRecordReader) stop(err error) bool {
+	rr.err, rr.record, rr.done = err, ocfRecord{}, true
+	rr.Close()
+	return false
+}
+
+// Close stops reading ahead and releases the block codec once the blocks
+// being decoded are done. It doesn't close the underlying reader, which
+// ends a read that is still waiting for input.
+func (rr *ocfRecordReader) Close() {
+	if rr.closed {
+		return
+	}
+	rr.closed, rr.done = true, true
+	if rr.quit == nil {
+		rr.blocks.Close()
+		return
+	}
+	close(rr.quit)
+	go func() {
+		rr.decoders.Wait()
+		rr.blocks.Close()
+	}()
+}
+
+// block returns the byte range in the file of the block holding the current
+// record, so it can be read again on its own.
+func (rr *ocfRecordReader) block() (int64, int64) {
+	return rr.blockStart, rr.blockEnd
+}
+
+func (rr *ocfRecordReader) Read() (interface{}, error) {
+	return rr.record.value, rr.record.err
+}
+
+// Raw returns the bytes of the current record, or of the rest of its block
+// when it cannot be decoded.
+func (rr *ocfRecordReader) Raw() []byte {
+	return rr.record.raw
+}
+
+func (rr *ocfRecordReader) Err() error {
+	return rr.err
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/linkedin/goavro/v2"
+)
+
+// testSchema is the writer schema of the container files tests write.
+const testSchema = `{
+  "type": "record",
+  "name": "Event",
+  "fields": [
+    {"name": "id", "type": "long"},
+    {"name": "name", "type": "string"},
+    {"name": "payload", "type": "bytes"}
+  ]
+}`
+
+// testRecord returns record i of a test file, with a payload from payload.
+func testRecord(i int, payload []byte) map[string]interface{} {
+	return map[string]interface{}{"id": int64(i), "name": fmt.Sprintf("event-%d", i/200%7), "payload": payload}
+}
+
+// writeTestOCF writes n records to a container file with small blocks and
+// returns its bytes. Payloads come from payload, if given.
+func writeTestOCF(t *testing.T, codec string, n int, sync *[16]byte, payload func(i int) []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	ow, err := newOCFWriter(&buf, testSchema, codec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ow.blockSize = 1 << 10
+	if sync != nil {
+		ow.sync = *sync
+	}
+	for i := 0; i < n; i++ {
+		var p []byte
+		if payload != nil {
+			p = payload(i)
+		}
+		if err := ow.Write(testRecord(i, p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ow.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// TestOCFGoavroReadsOutput checks goavro reads what ocfWriter writes, for
+// the codecs both support.
+func TestOCFGoavroReadsOutput(t *testing.T) {
+	for _, codec := range []string{ocfCodecNull, ocfCodecDeflate, ocfCodecSnappy} {
+		t.Run(codec, func(t *testing.T) {
+			data := writeTestOCF(t, codec, 300, nil, nil)
+			r, err := goavro.NewOCFReader(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			n := 0
+			for r.Scan() {
+				v, err := r.Read()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if id := v.(map[string]interface{})["id"].(int64); id != int64(n) {
+					t.Fatalf("record %d has id %d", n, id)
+				}
+				n++
+			}
+			if err := r.Err(); err != nil {
+				t.Fatal(err)
+			}
+			if n != 300 {
+				t.Fatalf("read %d records, want 300", n)
+			}
+		})
+	}
+}
+
+// TestOCFZstandard reads the blocks of a zstandard file by hand, as goavro
+// cannot read them.
+func TestOCFZstandard(t *testing.T) {
+	var sync [16]byte
+	copy(sync[:], "0123456789abcdef")
+	data := writeTestOCF(t, ocfCodecZstandard, 200, &sync, nil)
+
+	r := bufio.NewReader(bytes.NewReader(data))
+	header, err := readOCFHeader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.codec() != ocfCodecZstandard || header.sync != sync {
+		t.Fatalf("header has codec %s and sync %x", header.codec(), header.sync)
+	}
+	codec, err := goavro.NewCodec(string(header.schema()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec, err := zstd.NewReader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+
+	n, blocks := 0, 0
+	for {
+		count, err := binary.ReadVarint(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		size, err := binary.ReadVarint(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		compressed := make([]byte, size+16)
+		if _, err := io.ReadFull(r, compressed); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(compressed[size:], sync[:]) {
+			t.Fatalf("block %d doesn't end with the sync marker", blocks)
+		}
+		block, err := dec.DecodeAll(compressed[:size], nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := int64(0); i < count; i++ {
+			var v interface{}
+			if v, block, err = codec.NativeFromBinary(block); err != nil {
+				t.Fatal(err)
+			}
+			if id := v.(map[string]interface{})["id"].(int64); id != int64(n) {
+				t.Fatalf("record %d has id %d", n, id)
+			}
+			n++
+		}
+		blocks++
+	}
+	if n != 200 || blocks < 2 {
+		t.Fatalf("read %d records in %d blocks", n, blocks)
+	}
+}
+
+// readBlockIDs reads the ids of the records of a container stream, block
+// by block.
+func readBlockIDs(t *testing.T, data []byte) []int64 {
+	t.Helper()
+	or, err := newOCFReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer or.Close()
+	codec, err := goavro.NewCodec(string(or.header.schema()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []int64
+	for {
+		count, data, err := or.next()
+		if err == io.EOF {
+			return ids
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		block, err := or.decompress(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < count; i++ {
+			var v interface{}
+			if v, block, err = codec.NativeFromBinary(block); err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, v.(map[string]interface{})["id"].(int64))
+		}
+	}
+}
+
+func TestOCFReaderRoundTrip(t *testing.T) {
+	const n = 1000
+	for _, codec := range []string{ocfCodecNull, ocfCodecDeflate, ocfCodecSnappy, ocfCodecZstandard, ocfCodecXZ} {
+		t.Run(codec, func(t *testing.T) {
+			data := writeTestOCF(t, codec, n, nil, func(i int) []byte { return bytes.Repeat([]byte{byte(i)}, i%50) })
+			ids := readBlockIDs(t, data)
+			if len(ids) != n {
+				t.Fatalf("read %d records, want %d", len(ids), n)
+			}
+			for i, id := range ids {
+				if id != int64(i) {
+					t.Fatalf("record %d has id %d", i, id)
+				}
+			}
+		})
+	}
+}
+
+func TestOCFEmptyFile(t *testing.T) {
+	data := writeTestOCF(t, ocfCodecDeflate, 0, nil, nil)
+	if ids := readBlockIDs(t, data); len(ids) != 0 {
+		t.Fatalf("read %d records from an empty file", len(ids))
+	}
+}
+
+func TestOCFReaderSyncMismatch(t *testing.T) {
+	data := writeTestOCF(t, ocfCodecNull, 10, nil, nil)
+	data[len(data)-1] ^= 0xff
+	or, err := newOCFReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer or.Close()
+	if _, _, err := or.next(); err == nil || err.Error() != "sync marker mismatch" {
+		t.Fatalf("read a block with a damaged sync marker: %v", err)
+	}
+}
+
+func TestOCFRecordReader(t *testing.T) {
+	// Record 5 gets a negative payload length, making the rest of its
+	// block unreadable
+	data := writeTestOCF(t, ocfCodecNull, 300, nil, func(i int) []byte {
+		if i == 5 {
+			return []byte("MARK")
+		}
+		return nil
+	})
+	data = bytes.Replace(data, []byte("\x08MARK"), []byte("\x07MARK"), 1)
+	or, err := newOCFReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	firstBlock, _, err := or.next()
+	or.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, workers := range []int{1, 4} {
+		rr, err := newOCFRecordReader(bytes.NewReader(data), workers, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []int64
+		var failed [][]byte
+		for rr.Scan() {
+			record, err := rr.Read()
+			if err != nil {
+				failed = append(failed, rr.Raw())
+				continue
+			}
+			if len(rr.Raw()) == 0 {
+				t.Fatalf("workers %d: no bytes for record %d", workers, len(ids))
+			}
+			ids = append(ids, record.(map[string]interface{})["id"].(int64))
+		}
+		if err := rr.Err(); err != nil {
+			t.Fatal(err)
+		}
+
+		// The failed record's bytes run to the end of its block, and reading
+		// goes on with the next block
+		if len(failed) != 1 || !bytes.HasPrefix(failed[0], []byte{10}) || !bytes.Contains(failed[0], []byte("\x07MARK")) {
+			t.Fatalf("workers %d: failed records %q", workers, failed)
+		}
+		if len(ids) != 300-firstBlock+5 || ids[4] != 4 || ids[5] != int64(firstBlock) {
+			t.Fatalf("workers %d: read %d records with a first block of %d", workers, len(ids), firstBlock)
+		}
+	}
+}
+
+// readTestIDs reads the ids of the records of a container stream, decoding
+// up to workers blocks at a time.
+func readTestIDs(t *testing.T, data []byte, workers int) []int64 {
+	t.Helper()
+	rr, err := newOCFRecordReader(bytes.NewReader(data), workers, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rr.Close()
+	var ids []int64
+	for rr.Scan() {
+		v, err := rr.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, v.(map[string]interface{})["id"].(int64))
+	}
+	if err := rr.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+func TestOCFRecordReaderWorkers(t *testing.T) {
+	const n = 1000
+	for _, codec := range []string{ocfCodecNull, ocfCodecDeflate, ocfCodecSnappy, ocfCodecZstandard, ocfCodecXZ} {
+		t.Run(codec, func(t *testing.T) {
+			data := writeTestOCF(t, codec, n, nil, func(i int) []byte { return bytes.Repeat([]byte{byte(i)}, i%50) })
+			for _, workers := range []int{0, 1, 4} {
+				ids := readTestIDs(t, data, workers)
+				if len(ids) != n {
+					t.Fatalf("workers %d: read %d records, want %d", workers, len(ids), n)
+				}
+				for i, id := range ids {
+					if id != int64(i) {
+						t.Fatalf("workers %d: record %d has id %d", workers, i, id)
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestOCFRecordReaderCloseEarly checks a reader stopped midway, as with
+// -limit, can be closed while blocks are still being decoded ahead.
+func TestOCFRecordReaderCloseEarly(t *testing.T) {
+	data := writeTestOCF(t, ocfCodecDeflate, 1000, nil, nil)
+	rr, err := newOCFRecordReader(bytes.NewReader(data), 4, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rr.Scan() {
+		t.Fatal(rr.Err())
+	}
+	rr.Close()
+	rr.Close()
+	if rr.Scan() {
+		t.Fatal("scanned a closed reader")
+	}
+}
+
+func TestOCFBlockOffsets(t *testing.T) {
+	data := writeTestOCF(t, ocfCodecSnappy, 500, nil, nil)
+	blocks, err := newOCFReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blocks.Close()
+	sync := blocks.header.sync[:]
+
+	end := blocks.dataStart
+	if !bytes.Equal(data[end-16:end], sync) {
+		t.Fatalf("the header doesn't end at %d", end)
+	}
+	var ranges [][2]int64
+	for {
+		_, _, err := blocks.next()
+		if err != nil {
+			break
+		}
+		start, end := blocks.start, blocks.offset()
+		if n := len(ranges); n > 0 && ranges[n-1][1] != start {
+			t.Fatalf("block at %d doesn't follow the one ending at %d", start, ranges[n-1][1])
+		}
+		if !bytes.Equal(data[end-16:end], sync) {
+			t.Fatalf("block %d-%d doesn't end with the sync marker", start, end)
+		}
+		ranges = append(ranges, [2]int64{start, end})
+	}
+	if len(ranges) < 2 || ranges[0][0] != blocks.dataStart || ranges[len(ranges)-1][1] != int64(len(data)) {
+		t.Fatalf("blocks %v don't cover the file from %d to %d", ranges, blocks.dataStart, len(data))
+	}
+
+	// The record reader reports the same blocks for its records
+	for _, workers := range []int{1, 4} {
+		rr, err := newOCFRecordReader(bytes.NewReader(data), workers, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen := map[[2]int64]bool{}
+		for rr.Scan() {
+			start, end := rr.block()
+			seen[[2]int64{start, end}] = true
+		}
+		rr.Close()
+		if len(seen) != len(ranges) {
+			t.Fatalf("workers %d: records came from %d blocks, want %d", workers, len(seen), len(ranges))
+		}
+		for _, r := range ranges {
+			if !seen[r] {
+				t.Fatalf("workers %d: no record from block %v", workers, r)
+			}
+		}
+	}
+}
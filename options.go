@@ -0,0 +1,306 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"avroparser/pkg/avroconvert"
+	"github.com/linkedin/goavro/v2"
+)
+
+// recordFlags are the flags shared by every command that turns Avro records
+// into JSON values.
+type recordFlags struct {
+	field         *string
+	timeFormat    *string
+	timeZone      *string
+	decimalFormat *string
+	readerSchema  *string
+	filter        *string
+	since         *string
+	until         *string
+	timeField     *string
+	transform     *string
+	plugin        *string
+	script        *string
+	sampleRate    *float64
+	limit         *int
+	skip          *int
+	offset        *int
+	count         *int
+	onError       *string
+	deadLetter    *string
+	extJSON       *bool
+	epochs        *string
+	timeColumns   *bool
+	coerce        *string
+}
+
+func addRecordFlags(fs *flag.FlagSet) *recordFlags {
+	return &recordFlags{
+		field:         fs.String("field", "", "Extract this record field as the JSON message instead of converting the whole record (e.g. message for Pulsar sink files)"),
+		timeFormat:    fs.String("time-format", avroconvert.TimeFormatRFC3339, "Timestamp format: rfc3339, unix, unixmilli, unixmicro, unixnano or a Go time layout"),
+		timeZone:      fs.String("timezone", "UTC", "Time zone for formatted timestamps (IANA name, e.g. Europe/Berlin)"),
+		decimalFormat: fs.String("decimal", "string", "Decimal format: string (exact) or number"),
+		readerSchema:  fs.String("reader-schema", "", "Reader schema (.avsc) to project records onto using Avro schema resolution"),
+		transform:     fs.String("transform", "", "jq expression applied to every message before it is written, e.g. '{id, country: .geo.country}'"),
+		plugin:        fs.String("plugin", "", "Go plugin (.so) whose Transform function is applied to every message before -transform, e.g. enrich.so"),
+		script:        fs.String("script", "", "Starlark script whose transform(record) function is applied to every message before -transform, e.g. enrich.star"),
+		sampleRate:    fs.Float64("sample-rate", 1, "Fraction of records to keep, chosen at random (reproducibly per input), e.g. 0.01"),
+		limit:         fs.Int("limit", 0, "Stop after this many records of each input (0 for no limit)"),
+		skip:          fs.Int("skip", 0, "Skip this many records at the start of each input"),
+		offset:        fs.Int("offset", 0, "Start at the record of each input at this position, counted from 0 before -filter; the container file blocks before it are not decoded"),
+		count:         fs.Int("count", 0, "Read at most this many records of each input from -offset on, counted before -filter (0 for all)"),
+		filter:        fs.String("filter", "", `Only keep records matching this expression, e.g. 'event_name == "level_complete" && geo.country == "US"'`),
+		since:         fs.String("since", "", "Only keep records whose -timestamp-field is at or after this time (RFC 3339, date or Unix epoch)"),
+		until:         fs.String("until", "", "Only keep records whose -timestamp-field is before this time (RFC 3339, date or Unix epoch)"),
+		timeField:     fs.String("timestamp-field", "event_timestamp", "Field path of the event time -since and -until compare"),
+		onError:       fs.String("on-error", onErrorSkip, "What to do with records that cannot be decoded and messages that aren't valid JSON: skip, fail or collect (into a dead-letter file)"),
+		deadLetter:    fs.String("dead-letter", "", "With -on-error collect, directory of the dead-letter files (default the -output directory)"),
+		epochs:        fs.String("epoch-fields", "", "Comma-separated field paths holding Unix epoch numbers (of any unit) to write as timestamps in -time-format and -timezone, e.g. timestamp"),
+		timeColumns:   fs.Bool("time-columns", false, "Add <field>_date, <field>_hour and <field>_day_of_week fields in -timezone next to each of -epoch-fields"),
+		coerce:        fs.String("coerce", "", "YAML file mapping field paths to the types their values are converted to, e.g. payload_score: int64, with what happens to values that cannot be"),
+		extJSON:       fs.Bool("extended-json", false, "Convert MongoDB Extended JSON values, such as {\"$oid\": ...}, {\"$date\": ...} and {\"$numberLong\": ...} in mongoexport output, into plain values"),
+	}
+}
+
+func (rf *recordFlags) converter() (*avroconvert.JSONConverter, error) {
+	return avroconvert.NewJSONConverter(avroconvert.ConverterOptions{
+		TimeFormat:    *rf.timeFormat,
+		TimeZone:      *rf.timeZone,
+		DecimalFormat: *rf.decimalFormat,
+	})
+}
+
+// schema parses the -reader-schema file, returning nil when none is given.
+func (rf *recordFlags) schema() (*avroconvert.Schema, error) {
+	if *rf.readerSchema == "" {
+		return nil, nil
+	}
+	spec, err := os.ReadFile(*rf.readerSchema)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read reader schema: %w", err)
+	}
+	schema, err := avroconvert.ParseSchema(string(spec))
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse reader schema %s: %w", *rf.readerSchema, err)
+	}
+	return schema, nil
+}
+
+// recordFilter compiles the -filter expression and the -since and -until
+// range, returning nil when neither is given.
+func (rf *recordFlags) recordFilter() (*recordFilter, error) {
+	var filter *recordFilter
+	if *rf.filter != "" {
+		var err error
+		if filter, err = parseFilter(*rf.filter); err != nil {
+			return nil, err
+		}
+	}
+	timeRange, err := parseTimeRange(*rf.timeField, *rf.since, *rf.until, *rf.timeFormat)
+	if err != nil || timeRange == nil {
+		return filter, err
+	}
+	return timeRange.and(filter), nil
+}
+
+// sampling validates -offset, -count, -skip, -sample-rate and -limit.
+func (rf *recordFlags) sampling() (sampling, error) {
+	if *rf.sampleRate <= 0 || *rf.sampleRate > 1 {
+		return sampling{}, fmt.Errorf("-sample-rate must be greater than 0 and at most 1, got %g", *rf.sampleRate)
+	}
+	if *rf.limit < 0 {
+		return sampling{}, fmt.Errorf("-limit must not be negative, got %d", *rf.limit)
+	}
+	if *rf.skip < 0 {
+		return sampling{}, fmt.Errorf("-skip must not be negative, got %d", *rf.skip)
+	}
+	if *rf.offset < 0 {
+		return sampling{}, fmt.Errorf("-offset must not be negative, got %d", *rf.offset)
+	}
+	if *rf.count < 0 {
+		return sampling{}, fmt.Errorf("-count must not be negative, got %d", *rf.count)
+	}
+	return sampling{offset: *rf.offset, count: *rf.count, skip: *rf.skip, rate: *rf.sampleRate, limit: *rf.limit}, nil
+}
+
+// errorPolicy validates -on-error and -dead-letter for output written to
+// outputDir.
+func (rf *recordFlags) errorPolicy(outputDir string) (errorPolicy, error) {
+	policy := errorPolicy{mode: *rf.onError, dir: *rf.deadLetter}
+	switch policy.mode {
+	case onErrorSkip, onErrorFail:
+		if policy.dir != "" {
+			return policy, fmt.Errorf("-dead-letter is only used with -on-error collect")
+		}
+	case onErrorCollect:
+		if policy.dir == "" {
+			if !isFileOutput(outputDir) {
+				return policy, fmt.Errorf("-on-error collect needs -dead-letter unless -output is a directory")
+			}
+			policy.dir = outputDir
+		}
+	default:
+		return policy, fmt.Errorf("unknown -on-error policy %q (expected skip, fail or collect)", policy.mode)
+	}
+	return policy, nil
+}
+
+// extendedJSON returns the conversion of Extended JSON values -extended-json
+// asks for, with dates rendered by converter, or nil.
+func (rf *recordFlags) extendedJSON(converter *avroconvert.JSONConverter) *extendedJSON {
+	if !*rf.extJSON {
+		return nil
+	}
+	return &extendedJSON{converter: converter}
+}
+
+// epochFields parses -epoch-fields and -time-columns, returning nil when no
+// fields are given.
+func (rf *recordFlags) epochFields(converter *avroconvert.JSONConverter) (*epochFields, error) {
+	return parseEpochFields(*rf.epochs, *rf.timeColumns, converter, *rf.timeZone)
+}
+
+// coercion loads the -coerce file, returning nil when none is given.
+func (rf *recordFlags) coercion() (*coercion, error) {
+	if *rf.coerce == "" {
+		return nil, nil
+	}
+	return loadCoercion(*rf.coerce)
+}
+
+// recordTransform compiles the -transform expression, returning nil when
+// none is given.
+func (rf *recordFlags) recordTransform() (*recordTransform, error) {
+	if *rf.transform == "" {
+		return nil, nil
+	}
+	return parseTransform(*rf.transform)
+}
+
+// recordPlugin loads the -plugin, returning nil when none is given.
+func (rf *recordFlags) recordPlugin() (*recordPlugin, error) {
+	if *rf.plugin == "" {
+		return nil, nil
+	}
+	return loadPlugin(*rf.plugin)
+}
+
+// recordScript loads the -script, returning nil when none is given.
+func (rf *recordFlags) recordScript() (*recordScript, error) {
+	if *rf.script == "" {
+		return nil, nil
+	}
+	return loadScript(*rf.script)
+}
+
+// rawFlags select schema-less input for the commands that read files.
+type rawFlags struct {
+	raw     *bool
+	schema  *string
+	framing *string
+}
+
+func addRawFlags(fs *flag.FlagSet) *rawFlags {
+	return &rawFlags{
+		raw:     fs.Bool("raw", false, "Input is bare Avro binary datums without an object container, decoded with -schema"),
+		schema:  fs.String("schema", "", "Writer schema (.avsc) of -raw input"),
+		framing: fs.String("framing", framingNone, "Framing of -raw datums: none, length (4-byte big-endian prefix) or confluent (Confluent wire format)"),
+	}
+}
+
+// input returns the settings for -raw input, or nil for container files.
+func (rf *rawFlags) input() (*rawInput, error) {
+	if !*rf.raw {
+		if *rf.schema != "" {
+			return nil, fmt.Errorf("-schema is only used with -raw; container files carry their own schema")
+		}
+		return nil, nil
+	}
+	if *rf.schema == "" {
+		return nil, fmt.Errorf("-raw requires -schema")
+	}
+	switch *rf.framing {
+	case framingNone, framingLength, framingConfluent:
+	default:
+		return nil, fmt.Errorf("unknown framing %q (expected none, length or confluent)", *rf.framing)
+	}
+
+	codec, err := loadCodec(*rf.schema)
+	if err != nil {
+		return nil, err
+	}
+	return &rawInput{codec: codec, framing: *rf.framing}, nil
+}
+
+// splitFlags divide the output of each input into several files, by size
+// or by field values, for the commands that write one output per input.
+type splitFlags struct {
+	maxRecords  *int
+	maxSize     *string
+	partitionBy *string
+}
+
+func addSplitFlags(fs *flag.FlagSet) *splitFlags {
+	return &splitFlags{
+		maxRecords:  fs.Int("max-records-per-file", 0, "Split output into numbered parts of at most this many records (0 for no limit)"),
+		maxSize:     fs.String("max-file-size", "", "Split output into numbered parts of about this size, e.g. 500MB or 2GB"),
+		partitionBy: fs.String("partition-by", "", "Comma-separated field paths to write output into Hive-style partition directories by, e.g. event_name"),
+	}
+}
+
+// limits validates the flags for output written to outputDir.
+func (sf *splitFlags) limits(outputDir string) (splitLimits, error) {
+	var limits splitLimits
+	if *sf.maxRecords < 0 {
+		return limits, fmt.Errorf("-max-records-per-file must not be negative, got %d", *sf.maxRecords)
+	}
+	limits.records = *sf.maxRecords
+	if *sf.maxSize != "" {
+		size, err := parseSize(*sf.maxSize)
+		if err != nil {
+			return limits, fmt.Errorf("invalid -max-file-size: %w", err)
+		}
+		limits.bytes = size
+	}
+	if limits.enabled() && outputDir == stdioPath {
+		return limits, fmt.Errorf("output to stdout cannot be split into files")
+	}
+	return limits, nil
+}
+
+// partitions returns the field paths of -partition-by for output written
+// to outputDir.
+func (sf *splitFlags) partitions(outputDir string) ([]string, error) {
+	if *sf.partitionBy == "" {
+		return nil, nil
+	}
+	if outputDir == stdioPath {
+		return nil, fmt.Errorf("output to stdout cannot be partitioned")
+	}
+	var fields []string
+	for _, field := range strings.Split(*sf.partitionBy, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" || strings.HasPrefix(field, ".") || strings.HasSuffix(field, ".") || strings.Contains(field, "..") {
+			return nil, fmt.Errorf("invalid -partition-by field %q", field)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// loadCodec reads an .avsc file into a goavro codec.
+func loadCodec(path string) (*goavro.Codec, error) {
+	spec, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read schema: %w", err)
+	}
+	codec, err := goavro.NewCodec(string(spec))
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse schema %s: %w", path, err)
+	}
+	return codec, nil
+}
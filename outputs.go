@@ -0,0 +1,256 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"avroparser/pkg/avroconvert"
+)
+
+// outputPath returns where the output for an input is written: the input's
+// relative path under outputDir with its extension (and any compression
+// extension) swapped for ext, or stdioPath for stdout.
+func outputPath(in inputFile, outputDir, ext string) string {
+	if outputDir == stdioPath {
+		return stdioPath
+	}
+	rel := trimCompressionSuffix(in.rel)
+	rel = rel[:len(rel)-len(filepath.Ext(rel))] + "." + ext
+	if isGCSPath(outputDir) {
+		return strings.TrimSuffix(outputDir, "/") + "/" + filepath.ToSlash(rel)
+	}
+	return filepath.Join(outputDir, rel)
+}
+
+// bindOutput returns where the output of an input with extension ext is
+// written: at outputPath, or where an -output template names it, with
+// opts' template bound to the input for the records' variables.
+func (opts *decodeOptions) bindOutput(in inputFile, ext string) string {
+	if opts.outputTemplate == nil {
+		return outputPath(in, opts.outputDir, ext)
+	}
+	opts.outputTemplate = opts.outputTemplate.bind(in, ext)
+	if opts.outputTemplate.routes() {
+		return opts.outputTemplate.String()
+	}
+	return opts.outputTemplate.render(nil)
+}
+
+// displayPath names an output path in status messages.
+func displayPath(path string) string {
+	if path == stdioPath {
+		return "stdout"
+	}
+	return path
+}
+
+// openOutput creates an output file, including its parent directories, or
+// returns stdout for stdioPath. gs:// outputs are uploaded when closed.
+func openOutput(path string) (io.WriteCloser, error) {
+	if path == stdioPath {
+		return nopWriteCloser{os.Stdout}, nil
+	}
+	if isGCSPath(path) {
+		return gcs.create(path)
+	}
+
+	// Create output directory if it doesn't exist
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("cannot create output directory: %w", err)
+	}
+
+	f, err := createAtomic(path, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create output file: %w", err)
+	}
+	return f, nil
+}
+
+// abortOutput closes an output without finishing it: a file is removed
+// rather than renamed into place, and an upload cancelled.
+func abortOutput(out io.WriteCloser) {
+	if a, ok := out.(interface{ abort() }); ok {
+		a.abort()
+		return
+	}
+	out.Close()
+}
+
+// atomicFile is a file written under a temporary name next to its path,
+// which replaces the path when closed. A run that stops part way, or a
+// crash, leaves only the temporary file, never a partial file under the
+// output's name.
+type atomicFile struct {
+	*os.File
+	path string
+	held bool // Close leaves the file under its temporary name until commit
+}
+
+// createAtomic creates the temporary file of path, with permissions perm.
+func createAtomic(path string, perm os.FileMode) (*atomicFile, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	return &atomicFile{File: tmp, path: path}, nil
+}
+
+// Close writes the file through to disk and renames it into place, unless
+// it is held.
+func (af *atomicFile) Close() error {
+	if err := af.File.Sync(); err != nil {
+		af.abort()
+		return err
+	}
+	if err := af.File.Close(); err != nil {
+		os.Remove(af.Name())
+		return err
+	}
+	if af.held {
+		return nil
+	}
+	return af.commit()
+}
+
+// commit renames the closed file into place, and syncs the directory so the
+// rename survives a crash.
+func (af *atomicFile) commit() error {
+	if err := os.Rename(af.Name(), af.path); err != nil {
+		os.Remove(af.Name())
+		return err
+	}
+	return syncDir(filepath.Dir(af.path))
+}
+
+// abort removes the file, leaving the path as it was. A held file is
+// removed after it was closed too.
+func (af *atomicFile) abort() {
+	af.File.Close()
+	os.Remove(af.Name())
+}
+
+// syncDir flushes a directory's entries to disk. Windows doesn't sync
+// directories, and some file systems refuse to.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil && !errors.Is(err, syscall.EINVAL) {
+		return err
+	}
+	return nil
+}
+
+// appendOutput opens an output file for appending, so consuming again
+// continues the same file, or returns stdout for stdioPath.
+func appendOutput(path string) (io.WriteCloser, error) {
+	if path == stdioPath {
+		return nopWriteCloser{os.Stdout}, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("cannot create output directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open output file: %w", err)
+	}
+	return f, nil
+}
+
+// outputExists reports whether an output file, or Cloud Storage object,
+// exists.
+func outputExists(path string) (bool, error) {
+	switch {
+	case path == stdioPath:
+		return false, nil
+	case isGCSPath(path):
+		return gcs.exists(splitGCSPath(path))
+	}
+	_, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// checkOverwrite fails when an output file exists and may not be replaced,
+// with -overwrite=false.
+func (opts decodeOptions) checkOverwrite(path string) error {
+	if !opts.keepExisting {
+		return nil
+	}
+	exists, err := outputExists(path)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("output file %s exists, and -overwrite is false", path)
+	}
+	return nil
+}
+
+// outputWritten reports whether the output of an input was written before,
+// for -skip-existing: the output itself or, when it is split, its first
+// part.
+func (opts decodeOptions) outputWritten(output, ext string) (bool, error) {
+	if opts.split.enabled() {
+		output = partPath(output, ext, 1)
+	}
+	return outputExists(output)
+}
+
+// nopWriteCloser keeps stdout open when an output is closed.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// openOutputSet returns the writer for the output of an input at path, whose
+// extension is ext, divided into partitions and numbered parts as opts ask.
+// Output resuming an input converted before is appended to.
+// newWriter creates the format writer of each file.
+func openOutputSet(path, ext string, opts decodeOptions, newWriter func(w io.Writer) (avroconvert.Sink, error)) outputSet {
+	return openPartitionedOutputSet(path, ext, opts, func(string) func(w io.Writer) (avroconvert.Sink, error) {
+		return newWriter
+	})
+}
+
+// openPartitionedOutputSet is openOutputSet for files whose writer depends
+// on their partition: newWriter is given the path of the partition's output,
+// before it is divided into parts, which is path itself without -partition-by
+// or an -output template naming files by record values.
+func openPartitionedOutputSet(path, ext string, opts decodeOptions, newWriter func(partition string) func(w io.Writer) (avroconvert.Sink, error)) outputSet {
+	open := func(path string) *splitWriter {
+		partitionWriter := newWriter(path)
+		return newSplitWriter(path, ext, opts.split, func(part string) (*outputFile, error) {
+			appending := opts.blocks.resuming()
+			if !appending {
+				if err := opts.checkOverwrite(part); err != nil {
+					return nil, err
+				}
+			}
+			return openOutputFile(part, opts.compress, appending, opts.manifest, partitionWriter)
+		})
+	}
+	if len(opts.partitionBy) > 0 || opts.outputTemplate.routes() {
+		return newPartitionWriter(path, opts.partitionBy, opts.outputTemplate, opts.converter, open)
+	}
+	return open(path)
+}
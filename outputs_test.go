@@ -0,0 +1,152 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// checkNoTempFiles fails if a temporary output file was left in dir.
+func checkNoTempFiles(t *testing.T, dir string) {
+	t.Helper()
+	if tmps, _ := filepath.Glob(filepath.Join(dir, "*.tmp")); len(tmps) > 0 {
+		t.Errorf("left temporary files %v", tmps)
+	}
+}
+
+// checkNoOutputs checks a failed command left nothing in its output
+// directory, neither a partial output nor a temporary file.
+func checkNoOutputs(t *testing.T, dir string) {
+	t.Helper()
+	if entries, _ := os.ReadDir(dir); len(entries) > 0 {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("left %v after failing", names)
+	}
+}
+
+func TestAtomicOutput(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out", "events.ndjson")
+	out, err := openOutput(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(out, "{}\n")
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("output appeared before it was closed: %v", err)
+	}
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "{}\n" {
+		t.Fatalf("read %q, %v", data, err)
+	}
+
+	// Aborting leaves the previous file as it was
+	out, err = openOutput(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(out, "partial")
+	abortOutput(out)
+	if data, err := os.ReadFile(path); err != nil || string(data) != "{}\n" {
+		t.Fatalf("aborting left %q, %v", data, err)
+	}
+	checkNoTempFiles(t, filepath.Dir(path))
+}
+
+func TestConvertFileFailureLeavesNoOutput(t *testing.T) {
+	in := inputFile{path: writeTestFile(t, "events.avro", writeMessageOCF(t, `{"id":1}`, `{"id":2}`, `not json`)), rel: "events.avro"}
+	for _, split := range []splitLimits{{}, {records: 1}} {
+		dir := t.TempDir()
+		opts := testOptions(t, "message")
+		opts.outputDir, opts.format, opts.compress, opts.split = dir, "ndjson", "gzip", split
+		opts.onError = errorPolicy{mode: onErrorFail}
+		if result := convertFile(in, opts); result.err == nil {
+			t.Fatal("converted an input with an invalid message")
+		}
+		// Parts finished before the failure are removed with the rest
+		if names, _ := filepath.Glob(filepath.Join(dir, "events*.ndjson*")); len(names) != 0 {
+			t.Errorf("split %+v: left %v", split, names)
+		}
+		checkNoTempFiles(t, dir)
+	}
+}
+
+func TestConvertFileOverwrite(t *testing.T) {
+	in := inputFile{path: writeTestFile(t, "events.avro", writeMessageOCF(t, `{"id":1}`)), rel: "events.avro"}
+	dir := t.TempDir()
+	output := filepath.Join(dir, "events.ndjson")
+	if err := os.WriteFile(output, []byte("old\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	opts := testOptions(t, "message")
+	opts.outputDir, opts.format = dir, "ndjson"
+
+	opts.keepExisting = true
+	if result := convertFile(in, opts); result.err == nil || !strings.Contains(result.err.Error(), "-overwrite is false") {
+		t.Fatalf("-overwrite=false returned %v", result.err)
+	}
+	if data, _ := os.ReadFile(output); string(data) != "old\n" {
+		t.Fatalf("-overwrite=false replaced the output with %q", data)
+	}
+
+	opts.keepExisting, opts.skipExisting = false, true
+	if result := convertFile(in, opts); result.err != nil || !result.skipped {
+		t.Fatalf("-skip-existing returned %+v", result)
+	}
+	if data, _ := os.ReadFile(output); string(data) != "old\n" {
+		t.Fatalf("-skip-existing replaced the output with %q", data)
+	}
+
+	opts.skipExisting = false
+	if result := convertFile(in, opts); result.err != nil {
+		t.Fatal(result.err)
+	}
+	if data, _ := os.ReadFile(output); string(data) != "{\"id\":1}\n" {
+		t.Fatalf("converted to %q", data)
+	}
+}
+
+func TestOutputWrittenSplit(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "events.ndjson")
+	opts := decodeOptions{split: splitLimits{records: 10}}
+	if written, err := opts.outputWritten(output, "ndjson"); written || err != nil {
+		t.Fatalf("outputWritten = %v, %v", written, err)
+	}
+	if err := os.WriteFile(partPath(output, "ndjson", 1), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if written, err := opts.outputWritten(output, "ndjson"); !written || err != nil {
+		t.Fatalf("outputWritten after the first part = %v, %v", written, err)
+	}
+	if exists, err := outputExists(stdioPath); exists || err != nil {
+		t.Errorf("stdout exists: %v, %v", exists, err)
+	}
+}
+
+func TestConvertFileSplitCommit(t *testing.T) {
+	in := inputFile{path: writeTestFile(t, "events.avro", writeMessageOCF(t, `{"id":1}`, `{"id":2}`, `{"id":3}`)), rel: "events.avro"}
+	dir := t.TempDir()
+	opts := testOptions(t, "message")
+	opts.outputDir, opts.format, opts.split = dir, "ndjson", splitLimits{records: 1}
+	if result := convertFile(in, opts); result.err != nil {
+		t.Fatal(result.err)
+	}
+	for i, want := range []string{"{\"id\":1}\n", "{\"id\":2}\n", "{\"id\":3}\n"} {
+		path := partPath(filepath.Join(dir, "events.ndjson"), "ndjson", i+1)
+		if data, err := os.ReadFile(path); err != nil || string(data) != want {
+			t.Errorf("part %d: %q, %v", i+1, data, err)
+		}
+	}
+	checkNoTempFiles(t, dir)
+	if err := syncDir(dir); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"time"
+
+	"avroparser/pkg/avroconvert"
+	"github.com/parquet-go/parquet-go"
+)
+
+// parquetFlatSeparator joins nested keys into column names when Parquet
+// columns are derived from flattened JSON messages.
+const parquetFlatSeparator = "_"
+
+// parquetNativeWriter writes whole Avro records as Parquet rows, with the
+// Parquet schema derived from the Avro writer schema.
+type parquetNativeWriter struct {
+	w         io.Writer
+	pw        *parquet.Writer
+	schema    *avroconvert.Schema
+	converter *avroconvert.JSONConverter
+}
+
+func newParquetNativeWriter(w io.Writer, converter *avroconvert.JSONConverter) *parquetNativeWriter {
+	return &parquetNativeWriter{w: w, converter: converter}
+}
+
+// SetSchema creates the Parquet writer for the Avro writer schema.
+func (pw *parquetNativeWriter) SetSchema(schema *avroconvert.Schema) error {
+	if schema.Kind != "record" {
+		return fmt.Errorf("parquet output needs a record schema, got %s", schema.Kind)
+	}
+	node, err := parquetNode(schema, make(map[*avroconvert.Schema]bool))
+	if err != nil {
+		return err
+	}
+	pw.schema = schema
+	pw.pw = parquet.NewWriter(pw.w, parquet.NewSchema(schema.Name, node), parquet.Compression(&parquet.Snappy))
+	return nil
+}
+
+func (pw *parquetNativeWriter) WriteNative(record interface{}) error {
+	return pw.pw.Write(pw.value(pw.schema, record))
+}
+
+func (pw *parquetNativeWriter) WriteRecord(msg json.RawMessage) error {
+	return errors.New("parquet output of extracted fields needs column discovery")
+}
+
+// Flush ends the current row group, so what was written so far is readable
+// once the file is closed even if later rows fail.
+func (pw *parquetNativeWriter) Flush() error {
+	if pw.pw == nil {
+		return nil
+	}
+	return pw.pw.Flush()
+}
+
+func (pw *parquetNativeWriter) Close() error {
+	if pw.pw == nil {
+		return nil
+	}
+	return pw.pw.Close()
+}
+
+// parquetNode maps an Avro schema to a Parquet node. inProgress tracks the
+// records being mapped, as Parquet cannot represent recursive types.
+func parquetNode(s *avroconvert.Schema, inProgress map[*avroconvert.Schema]bool) (parquet.Node, error) {
+	switch s.Kind {
+	case "union":
+		branch := singleNonNullBranch(s)
+		if branch == nil {
+			// Unions of several types are kept as JSON text
+			return parquet.Optional(parquet.JSON()), nil
+		}
+		node, err := parquetNode(branch, inProgress)
+		if err != nil {
+			return nil, err
+		}
+		return parquet.Optional(node), nil
+
+	case "null":
+		return parquet.Optional(parquet.String()), nil
+
+	case "boolean":
+		return parquet.Leaf(parquet.BooleanType), nil
+
+	case "int":
+		switch s.LogicalType {
+		case "date":
+			return parquet.Date(), nil
+		case "time-millis":
+			return parquet.Time(parquet.Millisecond), nil
+		}
+		return parquet.Int(32), nil
+
+	case "long":
+		switch s.LogicalType {
+		case "timestamp-millis":
+			return parquet.Timestamp(parquet.Millisecond), nil
+		case "timestamp-micros":
+			return parquet.Timestamp(parquet.Microsecond), nil
+		case "timestamp-nanos":
+			return parquet.Timestamp(parquet.Nanosecond), nil
+		case "time-micros":
+			return parquet.Time(parquet.Microsecond), nil
+		}
+		return parquet.Int(64), nil
+
+	case "float":
+		return parquet.Leaf(parquet.FloatType), nil
+
+	case "double":
+		return parquet.Leaf(parquet.DoubleType), nil
+
+	case "string":
+		return parquet.String(), nil
+
+	case "bytes":
+		if s.LogicalType == "decimal" {
+			return parquet.Decimal(s.Scale, s.Precision, parquet.ByteArrayType), nil
+		}
+		return parquet.Leaf(parquet.ByteArrayType), nil
+
+	case "fixed":
+		if s.LogicalType == "decimal" {
+			return parquet.Decimal(s.Scale, s.Precision, parquet.FixedLenByteArrayType(s.Size)), nil
+		}
+		return parquet.Leaf(parquet.FixedLenByteArrayType(s.Size)), nil
+
+	case "enum":
+		return parquet.Enum(), nil
+
+	case "array":
+		items, err := parquetNode(s.Items, inProgress)
+		if err != nil {
+			return nil, err
+		}
+		return parquet.List(items), nil
+
+	case "map":
+		values, err := parquetNode(s.Values, inProgress)
+		if err != nil {
+			return nil, err
+		}
+		// parquet-go cannot deconstruct MAP columns from map[string]interface{}
+		// rows, so maps are written as a list of key/value groups
+		return parquet.List(parquet.Group{"key": parquet.String(), "value": values}), nil
+
+	case "record":
+		if inProgress[s] {
+			return nil, fmt.Errorf("recursive record %s cannot be written to parquet", s.Name)
+		}
+		inProgress[s] = true
+		defer delete(inProgress, s)
+
+		group := make(parquet.Group, len(s.Fields))
+		for _, f := range s.Fields {
+			node, err := parquetNode(f.Schema, inProgress)
+			if err != nil {
+				return nil, err
+			}
+			group[f.Name] = node
+		}
+		return group, nil
+	}
+	return nil, fmt.Errorf("unsupported avro type %q", s.Kind)
+}
+
+// singleNonNullBranch returns the only non-null branch of a union, or nil
+// when there are several.
+func singleNonNullBranch(s *avroconvert.Schema) *avroconvert.Schema {
+	var branch *avroconvert.Schema
+	for _, b := range s.Branches {
+		if b.Kind == "null" {
+			continue
+		}
+		if branch != nil {
+			return nil
+		}
+		branch = b
+	}
+	return branch
+}
+
+// value converts a goavro native value to the Go value parquet-go expects
+// for the node parquetNode derived from s.
+func (pw *parquetNativeWriter) value(s *avroconvert.Schema, v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+
+	switch s.Kind {
+	case "union":
+		branch, value := avroconvert.UnwrapUnion(s, v)
+		if value == nil {
+			return nil
+		}
+		if singleNonNullBranch(s) == nil || branch == nil {
+			if branch != nil {
+				value = pw.converter.Value(branch, value)
+			} else {
+				value = pw.converter.Generic(value)
+			}
+			text, err := json.Marshal(value)
+			if err != nil {
+				return nil
+			}
+			return string(text)
+		}
+		return pw.value(branch, value)
+
+	case "int":
+		switch t := v.(type) {
+		case time.Time: // date
+			return int32(t.Unix() / 86400)
+		case time.Duration: // time-millis
+			return int32(t / time.Millisecond)
+		}
+
+	case "long":
+		switch t := v.(type) {
+		case time.Time:
+			if s.LogicalType == "timestamp-micros" {
+				return t.UnixMicro()
+			}
+			return t.UnixMilli()
+		case time.Duration: // time-micros
+			return int64(t / time.Microsecond)
+		}
+
+	case "bytes", "fixed":
+		if r, ok := v.(*big.Rat); ok {
+			size := 0
+			if s.Kind == "fixed" {
+				size = s.Size
+			}
+			return decimalBytes(r, s.Scale, size)
+		}
+
+	case "record":
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		out := make(map[string]interface{}, len(s.Fields))
+		for _, f := range s.Fields {
+			out[f.Name] = pw.value(f.Schema, m[f.Name])
+		}
+		return out
+
+	case "array":
+		items, ok := v.([]interface{})
+		if !ok {
+			return nil
+		}
+		out := make([]interface{}, len(items))
+		for i, item := range items {
+			out[i] = pw.value(s.Items, item)
+		}
+		return out
+
+	case "map":
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		out := make([]interface{}, len(keys))
+		for i, k := range keys {
+			out[i] = map[string]interface{}{"key": k, "value": pw.value(s.Values, m[k])}
+		}
+		return out
+	}
+
+	return v
+}
+
+// decimalBytes encodes a decimal as the big-endian two's complement unscaled
+// value Parquet expects. A non-zero size sign-extends to a fixed width.
+func decimalBytes(r *big.Rat, scale, size int) []byte {
+	unscaled := new(big.Int).Mul(r.Num(), new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil))
+	unscaled.Quo(unscaled, r.Denom())
+
+	n := (unscaled.BitLen() + 8) / 8
+	if size > n {
+		n = size
+	}
+	// Two's complement of negative values: 2^(8n) + x
+	if unscaled.Sign() < 0 {
+		unscaled.Add(unscaled, new(big.Int).Lsh(big.NewInt(1), uint(8*n)))
+	}
+	return unscaled.FillBytes(make([]byte, n))
+}
+
+// parquetFlatWriter writes flattened JSON messages as Parquet rows of
+// optional columns discovered in a first pass, typed as inferred there.
+type parquetFlatWriter struct {
+	pw      *parquet.Writer
+	columns map[string]string // type by name
+}
+
+func newParquetFlatWriter(w io.Writer, columns []string, types map[string]string) (*parquetFlatWriter, error) {
+	if len(columns) == 0 {
+		return nil, errors.New("no columns found for parquet output")
+	}
+	group := make(parquet.Group, len(columns))
+	known := make(map[string]string, len(columns))
+	for _, name := range columns {
+		typ := types[name]
+		var node parquet.Node
+		switch typ {
+		case columnInt:
+			node = parquet.Int(64)
+		case columnFloat:
+			node = parquet.Leaf(parquet.DoubleType)
+		case columnBool:
+			node = parquet.Leaf(parquet.BooleanType)
+		case columnTimestamp:
+			node = parquet.Timestamp(parquet.Microsecond)
+		default:
+			typ, node = columnString, parquet.String()
+		}
+		group[name] = parquet.Optional(node)
+		known[name] = typ
+	}
+	pw := parquet.NewWriter(w, parquet.NewSchema("message", group), parquet.Compression(&parquet.Snappy))
+	return &parquetFlatWriter{pw: pw, columns: known}, nil
+}
+
+func (fw *parquetFlatWriter) WriteRecord(msg json.RawMessage) error {
+	v, err := parseMessage(msg)
+	if err != nil {
+		return err
+	}
+	row := make(map[string]interface{}, len(fw.columns))
+	for name := range fw.columns {
+		row[name] = nil
+	}
+	for _, f := range flattenRecord(v, parquetFlatSeparator) {
+		typ, ok := fw.columns[f.name]
+		if !ok {
+			continue
+		}
+		if value, ok := typedValue(typ, f.value); ok {
+			if t, ok := value.(time.Time); ok {
+				value = t.UnixMicro()
+			}
+			row[f.name] = value
+		}
+	}
+	return fw.pw.Write(row)
+}
+
+// Flush ends the current row group.
+func (fw *parquetFlatWriter) Flush() error {
+	return fw.pw.Flush()
+}
+
+func (fw *parquetFlatWriter) Close() error {
+	return fw.pw.Close()
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/linkedin/goavro/v2"
+	"github.com/parquet-go/parquet-go"
+)
+
+const sessionSchema = `{
+  "type": "record", "name": "Session",
+  "fields": [
+    {"name": "id", "type": "long"},
+    {"name": "user", "type": ["null", "string"]},
+    {"name": "started", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+    {"name": "tags", "type": {"type": "array", "items": "string"}},
+    {"name": "geo", "type": {"type": "record", "name": "Geo", "fields": [{"name": "country", "type": "string"}]}}
+  ]
+}`
+
+// readParquetRows reads every row of a Parquet file as generic maps.
+func readParquetRows(t *testing.T, path string) (*parquet.Schema, []map[string]interface{}) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := parquet.OpenFile(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := parquet.NewReader(f)
+	defer r.Close()
+	var rows []map[string]interface{}
+	for i := int64(0); i < f.NumRows(); i++ {
+		row := map[string]interface{}{}
+		if err := r.Read(&row); err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, row)
+	}
+	return f.Schema(), rows
+}
+
+func TestConvertParquetWholeRecords(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := goavro.NewOCFWriter(goavro.OCFConfig{W: &buf, Schema: sessionSchema})
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i, user := range []interface{}{goavro.Union("string", "ana"), nil} {
+		record := map[string]interface{}{
+			"id": int64(i + 1), "user": user, "started": started,
+			"tags": []interface{}{"a", "b"}, "geo": map[string]interface{}{"country": "DE"},
+		}
+		if err := w.Append([]interface{}{record}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dir := t.TempDir()
+	opts := testOptions(t, "")
+	opts.outputDir, opts.format = dir, "parquet"
+	result := convertFile(inputFile{path: writeTestFile(t, "sessions.avro", buf.Bytes()), rel: "sessions.avro"}, opts)
+	if result.err != nil || result.stats.Messages != 2 {
+		t.Fatalf("converted %+v: %v", result.stats, result.err)
+	}
+
+	schema, rows := readParquetRows(t, filepath.Join(dir, "sessions.parquet"))
+	var columns []string
+	for _, path := range schema.Columns() {
+		columns = append(columns, filepath.Join(path...))
+	}
+	want := []string{"geo/country", "id", "started", "tags/list/element", "user"}
+	if !reflect.DeepEqual(columns, want) {
+		t.Fatalf("columns %v, want %v", columns, want)
+	}
+	if len(rows) != 2 || rows[0]["user"] != "ana" || rows[1]["user"] != nil {
+		t.Fatalf("read rows %v", rows)
+	}
+}
+
+func TestConvertParquetField(t *testing.T) {
+	data := writeMessageOCF(t,
+		`{"id":1,"geo":{"country":"DE"},"score":1,"at":"2026-01-02T03:04:05Z"}`,
+		`{"id":2,"tags":[1,2],"score":1.5,"ok":false}`,
+	)
+	dir := t.TempDir()
+	opts := testOptions(t, "message")
+	opts.outputDir, opts.format = dir, "parquet"
+	result := convertFile(inputFile{path: writeTestFile(t, "events.avro", data), rel: "events.avro"}, opts)
+	if result.err != nil || result.stats.Messages != 2 {
+		t.Fatalf("converted %+v: %v", result.stats, result.err)
+	}
+
+	_, rows := readParquetRows(t, filepath.Join(dir, "events.parquet"))
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC).UnixMicro()
+	want := []map[string]interface{}{
+		{"id": int64(1), "geo_country": "DE", "tags": nil, "score": float64(1), "at": at, "ok": nil},
+		{"id": int64(2), "geo_country": nil, "tags": "[1,2]", "score": 1.5, "at": nil, "ok": false},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Fatalf("read rows %#v, want %#v", rows, want)
+	}
+}
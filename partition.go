@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"avroparser/pkg/avroconvert"
+)
+
+// hiveDefaultPartition names the partition of records whose partition field
+// is null or missing, as Hive does.
+const hiveDefaultPartition = "__HIVE_DEFAULT_PARTITION__"
+
+// outputSet is where the messages of one input are written: a single file,
+// numbered parts, or partitions of those.
+type outputSet interface {
+	avroconvert.Sink
+	native() avroconvert.NativeSink
+	written() string
+	abort()
+}
+
+// partitionWriter routes messages to Hive-style partitions by the values of
+// fields, e.g. output/event_name=session_start/events.ndjson for the output
+// output/events.ndjson, or to the files an -output template names after
+// them, or both. Each partition is written by its own splitWriter, created
+// when its first message arrives, and all of them stay open until the
+// writer is closed.
+type partitionWriter struct {
+	output    string   // path the input's output would have without partitioning
+	fields    []string // field paths, as given to -partition-by
+	paths     [][]string
+	template  *outputTemplate // bound to the input, if -output is a template
+	open      func(path string) *splitWriter
+	converter *avroconvert.JSONConverter
+	schema    *avroconvert.Schema
+	parts     map[string]*splitWriter // by path
+	order     []string
+}
+
+func newPartitionWriter(output string, fields []string, template *outputTemplate, converter *avroconvert.JSONConverter, open func(path string) *splitWriter) *partitionWriter {
+	paths := make([][]string, len(fields))
+	for i, field := range fields {
+		paths[i] = strings.Split(field, ".")
+	}
+	return &partitionWriter{output: output, fields: fields, paths: paths, template: template, open: open, converter: converter, parts: make(map[string]*splitWriter)}
+}
+
+// dir returns the partition directories of a converted message, e.g.
+// event_name=session_start.
+func (pw *partitionWriter) dir(v interface{}) string {
+	dirs := make([]string, len(pw.fields))
+	for i, field := range pw.fields {
+		dirs[i] = escapePartitionName(field) + "=" + partitionValue(lookupPath(v, pw.paths[i]))
+	}
+	return strings.Join(dirs, "/")
+}
+
+// path returns the output path of the partition of a converted message.
+func (pw *partitionWriter) path(v interface{}) string {
+	output := pw.output
+	if pw.template != nil {
+		output = pw.template.render(v)
+	}
+	if len(pw.fields) == 0 {
+		return output
+	}
+	return partitionPath(output, pw.dir(v))
+}
+
+// partition returns the writer for the partition of a converted message.
+func (pw *partitionWriter) partition(v interface{}) (*splitWriter, error) {
+	path := pw.path(v)
+	if part, ok := pw.parts[path]; ok {
+		return part, nil
+	}
+	part := pw.open(path)
+	if pw.schema != nil {
+		if err := part.native().SetSchema(pw.schema); err != nil {
+			return nil, err
+		}
+	}
+	pw.parts[path] = part
+	pw.order = append(pw.order, path)
+	return part, nil
+}
+
+func (pw *partitionWriter) WriteRecord(msg json.RawMessage) error {
+	v, err := parseMessage(msg)
+	if err != nil {
+		return err
+	}
+	part, err := pw.partition(v)
+	if err != nil {
+		return err
+	}
+	return part.WriteRecord(msg)
+}
+
+// Flush flushes every partition, reporting the first error.
+func (pw *partitionWriter) Flush() error {
+	var first error
+	for _, path := range pw.order {
+		if err := pw.parts[path].Flush(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Close closes every partition, reporting the first error.
+func (pw *partitionWriter) Close() error {
+	var first error
+	for _, path := range pw.order {
+		if err := pw.parts[path].Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// abort removes the files every partition is writing.
+func (pw *partitionWriter) abort() {
+	for _, path := range pw.order {
+		pw.parts[path].abort()
+	}
+}
+
+func (pw *partitionWriter) native() avroconvert.NativeSink {
+	return partitionNativeWriter{pw}
+}
+
+// written describes the partitions written, for status messages.
+func (pw *partitionWriter) written() string {
+	output := pw.output
+	if pw.template != nil {
+		output = pw.template.String()
+	}
+	if len(pw.fields) > 0 {
+		dirs := make([]string, len(pw.fields))
+		for i, field := range pw.fields {
+			dirs[i] = escapePartitionName(field) + "=*"
+		}
+		output = partitionPath(output, strings.Join(dirs, "/"))
+	}
+	return fmt.Sprintf("%s (%d partitions)", output, len(pw.parts))
+}
+
+// partitionNativeWriter is a partitionWriter for whole records, which are
+// converted to find their partition.
+type partitionNativeWriter struct {
+	*partitionWriter
+}
+
+func (pw partitionNativeWriter) SetSchema(schema *avroconvert.Schema) error {
+	pw.schema = schema
+	return nil
+}
+
+func (pw partitionNativeWriter) WriteNative(record interface{}) error {
+	part, err := pw.partition(pw.converter.Value(pw.schema, record))
+	if err != nil {
+		return err
+	}
+	return part.native().WriteNative(record)
+}
+
+// partitionPath places an output file inside partition directories next to
+// where it would be written otherwise.
+func partitionPath(output, dir string) string {
+	if isGCSPath(output) {
+		parent, name := path.Split(output)
+		return parent + dir + "/" + name
+	}
+	return filepath.Join(filepath.Dir(output), filepath.FromSlash(dir), filepath.Base(output))
+}
+
+// partitionValue renders a partition field value as a directory name,
+// escaping characters the way Hive does.
+func partitionValue(v interface{}) string {
+	var text string
+	switch t := v.(type) {
+	case nil:
+		return hiveDefaultPartition
+	case string:
+		text = t
+	case json.Number:
+		text = t.String()
+	case bool:
+		text = strconv.FormatBool(t)
+	case float64:
+		text = strconv.FormatFloat(t, 'f', -1, 64)
+	case map[string]interface{}, []interface{}:
+		data, err := json.Marshal(t)
+		if err != nil {
+			return hiveDefaultPartition
+		}
+		text = string(data)
+	default:
+		text = fmt.Sprint(t)
+	}
+	if text == "" {
+		return hiveDefaultPartition
+	}
+	return escapePartitionName(text)
+}
+
+// escapePartitionName percent-encodes the characters Hive escapes in
+// partition directory names.
+func escapePartitionName(text string) string {
+	var b strings.Builder
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if c < 0x20 || c == 0x7f || strings.IndexByte("\"#%'*/:=?\\{[]^", c) >= 0 {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/linkedin/goavro/v2"
+)
+
+func TestConvertPartitioned(t *testing.T) {
+	second := testEvent(2)
+	second["user"] = goavro.Union("string", "b/o=b")
+	third := testEvent(3)
+	third["user"] = nil
+	in := inputFile{path: writeTestFile(t, "events.avro", writeEventOCF(t, testEvent(1), second, third, testEvent(4))), rel: "events.avro"}
+
+	dir := t.TempDir()
+	opts := testOptions(t, "")
+	opts.outputDir, opts.format, opts.partitionBy = dir, "ndjson", []string{"kind", "user"}
+	opts.transform, _ = parseTransform(`{id, kind, user}`)
+	if result := convertFile(in, opts); result.err != nil {
+		t.Fatal(result.err)
+	}
+
+	for path, want := range map[string]string{
+		"kind=START/user=ana/events.ndjson":                        "{\"id\":1,\"kind\":\"START\",\"user\":\"ana\"}\n{\"id\":4,\"kind\":\"START\",\"user\":\"ana\"}\n",
+		"kind=START/user=b%2Fo%3Db/events.ndjson":                  "{\"id\":2,\"kind\":\"START\",\"user\":\"b/o=b\"}\n",
+		"kind=START/user=__HIVE_DEFAULT_PARTITION__/events.ndjson": "{\"id\":3,\"kind\":\"START\",\"user\":null}\n",
+	} {
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(path)))
+		if err != nil || string(data) != want {
+			t.Errorf("%s: %q, %v", path, data, err)
+		}
+	}
+}
+
+func TestPartitionParquetWholeRecords(t *testing.T) {
+	data := writeMessageOCF(t, `{"id":1}`, `DE`)
+	in := inputFile{path: writeTestFile(t, "export.avro", data), rel: "export.avro"}
+
+	dir := t.TempDir()
+	opts := testOptions(t, "")
+	opts.outputDir, opts.format, opts.partitionBy = dir, "parquet", []string{"message"}
+	if result := convertFile(in, opts); result.err != nil {
+		t.Fatal(result.err)
+	}
+	for _, partition := range []string{`message=%7B%22id%22%3A1}`, "message=DE"} {
+		if _, err := os.Stat(filepath.Join(dir, partition, "export.parquet")); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Variables of an -output template filled in from the input.
+const (
+	templateBasename = "basename"  // input file name without its extensions
+	templateInputDir = "input_dir" // input's directory relative to -input
+	templateExt      = "ext"       // extension of the output format
+)
+
+// timeVariables are the variables of an -output template filled in from a
+// record's -timestamp-field, with the Go layouts they are formatted with.
+var timeVariables = map[string]string{
+	"date":  "2006-01-02",
+	"year":  "2006",
+	"month": "01",
+	"day":   "02",
+	"hour":  "15",
+}
+
+// outputTemplate names output files after their input and the values of
+// their records, e.g. out/{date}/{event_name}/{basename}.ndjson. Any
+// variable other than the input and time ones is a field path in the output
+// messages, like those of -partition-by, and {field|layout} formats a
+// timestamp field with a Go time layout, as in -index names.
+type outputTemplate struct {
+	parts    []templatePart
+	dir      string   // leading directories without variables, used as the output directory
+	timePath []string // -timestamp-field, for the time variables
+	layout   string   // Go layout of -time-format, if it is one
+}
+
+// templatePart is literal text or a variable of an output template.
+type templatePart struct {
+	literal string
+	name    string   // of the variable, empty for literal text
+	path    []string // of a field variable
+	layout  string   // Go time layout a field variable is formatted with, if any
+}
+
+// parseOutputTemplate parses -output as a template when it has variables,
+// returning nil when it is a plain output directory or not a file output.
+func parseOutputTemplate(output, timeField, timeFormat string) (*outputTemplate, error) {
+	if !strings.Contains(output, "{") || !isFileOutput(output) {
+		return nil, nil
+	}
+	t := &outputTemplate{layout: timeLayout(timeFormat)}
+	for rest := output; rest != ""; {
+		i := strings.IndexByte(rest, '{')
+		if i < 0 {
+			t.parts = append(t.parts, templatePart{literal: rest})
+			break
+		}
+		if i > 0 {
+			t.parts = append(t.parts, templatePart{literal: rest[:i]})
+		}
+		j := strings.IndexByte(rest[i:], '}')
+		if j < 0 {
+			return nil, fmt.Errorf("-output template %q has an unclosed {", output)
+		}
+		name, layout, timed := strings.Cut(rest[i+1:i+j], "|")
+		if name == "" || strings.ContainsAny(name, "{/") || strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".") || strings.Contains(name, "..") || timed && layout == "" {
+			return nil, fmt.Errorf("invalid -output template variable {%s}", rest[i+1:i+j])
+		}
+		part := templatePart{name: name, layout: layout}
+		if timed {
+			part.path = strings.Split(name, ".")
+		} else if _, ok := timeVariables[name]; ok {
+			if timeField == "" {
+				return nil, fmt.Errorf("-output template variable {%s} needs a -timestamp-field", name)
+			}
+			t.timePath = strings.Split(timeField, ".")
+		} else if !isInputVariable(name) {
+			part.path = strings.Split(name, ".")
+		}
+		t.parts = append(t.parts, part)
+		rest = rest[i+j+1:]
+	}
+	if strings.HasSuffix(output, "/") {
+		return nil, fmt.Errorf("-output template %q names a directory rather than a file", output)
+	}
+
+	// The output directory ends before the first variable
+	var prefix string
+	if t.parts[0].name == "" {
+		prefix = t.parts[0].literal
+	}
+	t.dir = "."
+	if i := strings.LastIndexByte(prefix, '/'); i >= 0 {
+		t.dir = prefix[:max(i, 1)]
+	}
+	if isGCSPath(output) && !strings.Contains(strings.TrimPrefix(prefix, "gs://"), "/") {
+		return nil, fmt.Errorf("the bucket of -output template %q must not be a variable", output)
+	}
+	return t, nil
+}
+
+func isInputVariable(name string) bool {
+	return name == templateBasename || name == templateInputDir || name == templateExt
+}
+
+// bind returns the template with the variables of an input filled in, for
+// output with extension ext.
+func (t *outputTemplate) bind(in inputFile, ext string) *outputTemplate {
+	rel := filepath.ToSlash(trimCompressionSuffix(in.rel))
+	values := map[string]string{
+		templateBasename: strings.TrimSuffix(path.Base(rel), path.Ext(rel)),
+		templateInputDir: path.Dir(rel),
+		templateExt:      ext,
+	}
+	bound := *t
+	bound.parts = nil
+	for _, part := range t.parts {
+		if value, ok := values[part.name]; ok && part.path == nil {
+			part = templatePart{literal: value}
+		}
+		if n := len(bound.parts); n > 0 && part.name == "" && bound.parts[n-1].name == "" {
+			bound.parts[n-1].literal += part.literal
+			continue
+		}
+		bound.parts = append(bound.parts, part)
+	}
+	return &bound
+}
+
+// routes reports whether the template names files by the values of
+// records, so an input may be written to several files.
+func (t *outputTemplate) routes() bool {
+	if t == nil {
+		return false
+	}
+	for _, part := range t.parts {
+		if part.name != "" && (part.path != nil || !isInputVariable(part.name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// render returns the path of the output of a converted message. Values are
+// escaped as partition directory names are, and a missing value, or
+// timestamp, is written as __HIVE_DEFAULT_PARTITION__.
+func (t *outputTemplate) render(v interface{}) string {
+	var b strings.Builder
+	for _, part := range t.parts {
+		switch layout, isTime := timeVariables[part.name]; {
+		case part.name == "":
+			b.WriteString(part.literal)
+		case part.layout != "":
+			if ts, ok := eventTime(lookupPath(v, part.path), t.layout); ok {
+				b.WriteString(ts.Format(part.layout))
+			} else {
+				b.WriteString(hiveDefaultPartition)
+			}
+		case isTime:
+			if ts, ok := eventTime(lookupPath(v, t.timePath), t.layout); ok {
+				b.WriteString(ts.Format(layout))
+			} else {
+				b.WriteString(hiveDefaultPartition)
+			}
+		default:
+			b.WriteString(templateValue(lookupPath(v, part.path)))
+		}
+	}
+	p := b.String()
+	if isGCSPath(p) {
+		return "gs://" + path.Clean(strings.TrimPrefix(p, "gs://"))
+	}
+	return filepath.Clean(p)
+}
+
+// templateValue renders a field value as part of a path, as a partition
+// value, with . and .. escaped so a value can't name another directory.
+func templateValue(v interface{}) string {
+	value := partitionValue(v)
+	if value == "." || value == ".." {
+		return strings.ReplaceAll(value, ".", "%2E")
+	}
+	return value
+}
+
+// String returns the template as given, with the variables bound so far
+// filled in.
+func (t *outputTemplate) String() string {
+	var b strings.Builder
+	for _, part := range t.parts {
+		switch {
+		case part.name == "":
+			b.WriteString(part.literal)
+		case part.layout != "":
+			b.WriteString("{" + part.name + "|" + part.layout + "}")
+		default:
+			b.WriteString("{" + part.name + "}")
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseOutputTemplate(t *testing.T) {
+	for _, output := range []string{"out", "-", "bq://p.d.t", "postgres://db/{table}"} {
+		if tmpl, err := parseOutputTemplate(output, "", ""); tmpl != nil || err != nil {
+			t.Errorf("%s: parsed %v, %v", output, tmpl, err)
+		}
+	}
+	for _, output := range []string{
+		"out/{event_name",
+		"out/{}/a.ndjson",
+		"out/{a..b}/x",
+		"out/{.a}/x",
+		"out/{ts|}/x",
+		"out/{date}/x.ndjson",
+		"out/{event_name}/",
+		"gs://{event_name}/x.ndjson",
+	} {
+		if _, err := parseOutputTemplate(output, "", ""); err == nil {
+			t.Errorf("%s: parsed an invalid template", output)
+		}
+	}
+
+	tests := []struct{ output, dir string }{
+		{"out/data/{event_name}/{basename}.ndjson", "out/data"},
+		{"{basename}.ndjson", "."},
+		{"/{basename}.ndjson", "/"},
+		{"out/x{date}.ndjson", "out"},
+		{"gs://bucket/{date}/{basename}.ndjson", "gs://bucket"},
+	}
+	for _, tt := range tests {
+		tmpl, err := parseOutputTemplate(tt.output, "ts", "")
+		if err != nil {
+			t.Fatalf("%s: %v", tt.output, err)
+		}
+		if tmpl.dir != tt.dir || tmpl.String() != tt.output {
+			t.Errorf("%s: dir %q, string %q", tt.output, tmpl.dir, tmpl.String())
+		}
+	}
+}
+
+func TestOutputTemplateRender(t *testing.T) {
+	tmpl, err := parseOutputTemplate("out/{input_dir}/{date}/{hour}/{user.name}/{ts|2006-01}/{basename}.{ext}", "ts", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bound := tmpl.bind(inputFile{rel: filepath.Join("eu", "day1.avro.gz")}, "ndjson")
+	if got, want := bound.String(), "out/eu/{date}/{hour}/{user.name}/{ts|2006-01}/day1.ndjson"; got != want {
+		t.Fatalf("bound %q, want %q", got, want)
+	}
+	if !bound.routes() {
+		t.Error("a template with record variables doesn't route records")
+	}
+
+	tests := []struct {
+		record interface{}
+		want   string
+	}{
+		{map[string]interface{}{"ts": "2024-05-01T13:04:00Z", "user": map[string]interface{}{"name": "ana"}}, "out/eu/2024-05-01/13/ana/2024-05/day1.ndjson"},
+		{map[string]interface{}{"ts": "2024-05-01T13:04:00Z", "user": map[string]interface{}{"name": ".."}}, "out/eu/2024-05-01/13/%2E%2E/2024-05/day1.ndjson"},
+		{map[string]interface{}{"user": map[string]interface{}{"name": "a/b"}}, "out/eu/__HIVE_DEFAULT_PARTITION__/__HIVE_DEFAULT_PARTITION__/a%2Fb/__HIVE_DEFAULT_PARTITION__/day1.ndjson"},
+	}
+	for _, tt := range tests {
+		if got := bound.render(tt.record); got != filepath.FromSlash(tt.want) {
+			t.Errorf("%v rendered %q, want %q", tt.record, got, tt.want)
+		}
+	}
+
+	// Input variables alone name one file per input
+	tmpl, err = parseOutputTemplate("out/{input_dir}/{basename}.{ext}", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bound = tmpl.bind(inputFile{rel: "day1.avro"}, "csv")
+	if bound.routes() || bound.render(nil) != filepath.Join("out", "day1.csv") {
+		t.Errorf("bound %q routes records or renders %q", bound, bound.render(nil))
+	}
+}
+
+func TestConvertOutputTemplate(t *testing.T) {
+	in := inputFile{path: writeTestFile(t, "day1.avro", writeMessageOCF(t,
+		`{"id":1,"event_name":"start"}`,
+		`{"id":2,"event_name":"end"}`,
+		`{"id":3,"event_name":"start"}`,
+	)), rel: "day1.avro"}
+	dir := t.TempDir()
+	opts := testOptions(t, "message")
+	opts.format = "ndjson"
+	var err error
+	if opts.outputTemplate, err = parseOutputTemplate(filepath.ToSlash(dir)+"/{event_name}/{basename}.{ext}", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	opts.outputDir = opts.outputTemplate.dir
+	if result := convertFile(in, opts); result.err != nil {
+		t.Fatal(result.err)
+	}
+
+	for path, want := range map[string]string{
+		"start/day1.ndjson": "{\"id\":1,\"event_name\":\"start\"}\n{\"id\":3,\"event_name\":\"start\"}\n",
+		"end/day1.ndjson":   "{\"id\":2,\"event_name\":\"end\"}\n",
+	} {
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(path)))
+		if err != nil || string(data) != want {
+			t.Errorf("%s: %q, %v", path, data, err)
+		}
+	}
+	checkNoTempFiles(t, dir)
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// pipelineConfig is a jobs file for the run command: named jobs, each a
+// subcommand with its flags, and flags shared by all of them.
+type pipelineConfig struct {
+	Parallel  int                      `yaml:"parallel"`   // jobs run at once, 1 when unset
+	KeepGoing bool                     `yaml:"keep-going"` // start further jobs after one failed
+	Defaults  map[string]interface{}   `yaml:"defaults"`
+	Jobs      []map[string]interface{} `yaml:"jobs"`
+}
+
+// pipelineJob is a job of a pipeline, ready to run.
+type pipelineJob struct {
+	name     string
+	command  string
+	args     []string // the job's flags, followed by its arguments
+	defaults []string // flags of the defaults the job doesn't set itself
+}
+
+// jobDefaultsEnv passes a job the defaults of its jobs file, as a JSON
+// list of flags. Commands only take those they have a flag for, so a
+// default such as -on-error can be shared by jobs whose commands don't
+// all have it.
+const jobDefaultsEnv = "AVROPARSER_JOB_DEFAULTS"
+
+func runPipeline(args []string) {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	configPath := fs.String("config", "", "Jobs file (YAML) defining the conversion jobs to run")
+	only := fs.String("jobs", "", "Comma-separated names of the jobs to run (default all)")
+	parallel := fs.Int("parallel", 0, "Jobs to run at once (default the jobs file's parallel, or 1)")
+	keepGoing := fs.Bool("keep-going", false, "Start further jobs after one has failed")
+	logging := addLogFlags(fs)
+	parseFlags(fs, args)
+	logging.setup()
+
+	if *configPath == "" {
+		fmt.Fprintln(os.Stderr, "Usage: avroparser run -config <jobs.yaml> [-jobs name,...] [-parallel n] [-keep-going]")
+		os.Exit(exitFatal)
+	}
+
+	config, err := loadPipeline(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	jobs, err := config.jobs(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	if *only != "" {
+		if jobs, err = selectJobs(jobs, strings.Split(*only, ",")); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(exitFatal)
+		}
+	}
+	if *parallel == 0 {
+		*parallel = max(config.Parallel, 1)
+	}
+	if *parallel < 1 {
+		fmt.Fprintf(os.Stderr, "-parallel must be at least 1, got %d\n", *parallel)
+		os.Exit(exitFatal)
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		slog.Error("Cannot find the avroparser executable", "error", err)
+		os.Exit(exitFatal)
+	}
+	codes := runJobs(executable, jobs, *parallel, *keepGoing || config.KeepGoing)
+
+	failed := 0
+	for _, c := range codes {
+		if c != exitOK {
+			failed++
+		}
+	}
+	// The run is fatal only when no job got anything done
+	code := exitOK
+	switch {
+	case failed > 0 && allFatal(codes):
+		code = exitFatal
+	case failed > 0:
+		code = exitPartial
+	}
+	slog.Info("Ran jobs", "succeeded", len(jobs)-failed, "failed", failed)
+	if code != exitOK {
+		os.Exit(code)
+	}
+}
+
+// allFatal reports whether every job exited with exitFatal or wasn't
+// started.
+func allFatal(codes []int) bool {
+	for _, c := range codes {
+		if c != exitFatal {
+			return false
+		}
+	}
+	return true
+}
+
+// loadPipeline reads a jobs file.
+func loadPipeline(path string) (*pipelineConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read jobs file: %w", err)
+	}
+	var config pipelineConfig
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil {
+		return nil, fmt.Errorf("cannot parse jobs file %s: %w", path, err)
+	}
+	return &config, nil
+}
+
+// jobs turns the job definitions into command lines, each job's flags
+// followed by its args. The defaults are kept apart, for the commands to
+// take those they have flags for, and a job's flags override them. Job
+// names must be unique.
+func (pc *pipelineConfig) jobs(path string) ([]pipelineJob, error) {
+	if len(pc.Jobs) == 0 {
+		return nil, fmt.Errorf("%s defines no jobs", path)
+	}
+	seen := make(map[string]bool)
+	jobs := make([]pipelineJob, 0, len(pc.Jobs))
+	for i, spec := range pc.Jobs {
+		name, _ := spec["name"].(string)
+		if name == "" {
+			return nil, fmt.Errorf("%s: job %d has no name", path, i+1)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("%s: there are two jobs named %q", path, name)
+		}
+		seen[name] = true
+
+		command := "decode"
+		if c, ok := spec["command"]; ok {
+			command, _ = c.(string)
+		}
+		if _, ok := commands[command]; !ok || command == "run" {
+			return nil, fmt.Errorf("%s: job %q has unknown command %v", path, name, spec["command"])
+		}
+
+		values := make(map[string]interface{}, len(spec))
+		for flagName, value := range spec {
+			if flagName != "name" && flagName != "command" && flagName != "args" {
+				values[flagName] = value
+			}
+		}
+		args, err := flagArgs(values)
+		if err != nil {
+			return nil, fmt.Errorf("%s: job %q: %w", path, name, err)
+		}
+		if a, ok := spec["args"]; ok {
+			positional, err := configValues(a)
+			if err != nil {
+				return nil, fmt.Errorf("%s: job %q: invalid args: %w", path, name, err)
+			}
+			args = append(args, positional...)
+		}
+
+		defaults := make(map[string]interface{}, len(pc.Defaults))
+		for flagName, value := range pc.Defaults {
+			if _, ok := values[flagName]; !ok {
+				defaults[flagName] = value
+			}
+		}
+		defaultArgs, err := flagArgs(defaults)
+		if err != nil {
+			return nil, fmt.Errorf("%s: defaults: %w", path, err)
+		}
+		jobs = append(jobs, pipelineJob{name: name, command: command, args: args, defaults: defaultArgs})
+	}
+	return jobs, nil
+}
+
+// flagArgs renders flag values from a config file as command line
+// arguments, in the order of the flag names.
+func flagArgs(values map[string]interface{}) ([]string, error) {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var args []string
+	for _, name := range names {
+		if name == "config" {
+			return nil, errors.New("jobs cannot set -config")
+		}
+		rendered, err := configValues(values[name])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", name, err)
+		}
+		for _, value := range rendered {
+			args = append(args, "-"+name+"="+value)
+		}
+	}
+	return args, nil
+}
+
+// selectJobs keeps the named jobs, in the order of the jobs file.
+func selectJobs(jobs []pipelineJob, names []string) ([]pipelineJob, error) {
+	wanted := make(map[string]bool)
+	for _, name := range names {
+		wanted[strings.TrimSpace(name)] = true
+	}
+	var selected []pipelineJob
+	for _, job := range jobs {
+		if wanted[job.name] {
+			selected = append(selected, job)
+			delete(wanted, job.name)
+		}
+	}
+	for name := range wanted {
+		return nil, fmt.Errorf("there is no job named %q", name)
+	}
+	return selected, nil
+}
+
+// runJobs runs the jobs as child processes of executable, parallel at a
+// time in the order given, and returns their exit codes. Unless keepGoing
+// is set, no further jobs are started once one has failed; those that
+// aren't started get exitFatal.
+func runJobs(executable string, jobs []pipelineJob, parallel int, keepGoing bool) []int {
+	codes := make([]int, len(jobs))
+	for i := range codes {
+		codes[i] = exitFatal
+	}
+
+	var mu sync.Mutex
+	stopped := false
+	slots := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, job := range jobs {
+		slots <- struct{}{}
+		mu.Lock()
+		stop := stopped
+		mu.Unlock()
+		if stop {
+			slog.Warn("Not starting job after an earlier one failed", "job", job.name)
+			<-slots
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			code := runJob(executable, job)
+			mu.Lock()
+			codes[i] = code
+			if code != exitOK && !keepGoing {
+				stopped = true
+			}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return codes
+}
+
+// applyJobDefaults sets the flags of fs the defaults of a run's jobs file
+// have values for, unless the command line or a config file set them. It
+// leaves the defaults of flags fs doesn't have.
+func applyJobDefaults(fs *flag.FlagSet) error {
+	encoded, ok := os.LookupEnv(jobDefaultsEnv)
+	if !ok {
+		return nil
+	}
+	// Processes the job starts in turn aren't jobs of the file
+	os.Unsetenv(jobDefaultsEnv)
+	var defaults []string
+	if err := json.Unmarshal([]byte(encoded), &defaults); err != nil {
+		return fmt.Errorf("invalid %s: %w", jobDefaultsEnv, err)
+	}
+
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	for _, arg := range defaults {
+		name, value, ok := strings.Cut(strings.TrimPrefix(arg, "-"), "=")
+		if !ok || given[name] || fs.Lookup(name) == nil {
+			continue
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("invalid default %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// runJob runs a job and returns its exit code. The job writes to the
+// run's own stdout and stderr.
+func runJob(executable string, job pipelineJob) int {
+	slog.Info("Starting job", "job", job.name, "command", job.command)
+	start := time.Now()
+
+	cmd := exec.Command(executable, append([]string{job.command}, job.args...)...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if len(job.defaults) > 0 {
+		defaults, err := json.Marshal(job.defaults)
+		if err != nil {
+			slog.Error("Cannot start job", "job", job.name, "error", err)
+			return exitFatal
+		}
+		cmd.Env = append(os.Environ(), jobDefaultsEnv+"="+string(defaults))
+	}
+	err := cmd.Run()
+
+	code := exitOK
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		code = exitErr.ExitCode()
+	case err != nil:
+		slog.Error("Cannot start job", "job", job.name, "error", err)
+		return exitFatal
+	}
+	duration := time.Since(start).Round(time.Millisecond)
+	if code != exitOK {
+		slog.Error("Job failed", "job", job.name, "exit_code", code, "duration", duration)
+	} else {
+		slog.Info("Finished job", "job", job.name, "duration", duration)
+	}
+	return code
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writePipeline(t *testing.T, text string) *pipelineConfig {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "jobs.yaml")
+	if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	config, err := loadPipeline(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func TestPipelineJobs(t *testing.T) {
+	config := writePipeline(t, `
+parallel: 2
+defaults:
+  format: ndjson
+  workers: 4
+jobs:
+  - name: events
+    input: events/
+    output: out/events
+    workers: 8
+  - name: compact
+    command: merge
+    output: out/events.avro
+    args: [events/, extra.avro]
+`)
+	jobs, err := config.jobs("jobs.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The defaults are left for the command to take, unless the job sets them
+	want := []pipelineJob{
+		{name: "events", command: "decode", args: []string{"-input=events/", "-output=out/events", "-workers=8"}, defaults: []string{"-format=ndjson"}},
+		{name: "compact", command: "merge", args: []string{"-output=out/events.avro", "events/", "extra.avro"}, defaults: []string{"-format=ndjson", "-workers=4"}},
+	}
+	if config.Parallel != 2 || !reflect.DeepEqual(jobs, want) {
+		t.Fatalf("jobs %+v", jobs)
+	}
+
+	selected, err := selectJobs(jobs, []string{" compact"})
+	if err != nil || len(selected) != 1 || selected[0].name != "compact" {
+		t.Fatalf("selected %+v: %v", selected, err)
+	}
+	if _, err := selectJobs(jobs, []string{"missing"}); err == nil {
+		t.Fatal("selected a job that doesn't exist")
+	}
+}
+
+func TestPipelineJobErrors(t *testing.T) {
+	for _, text := range []string{
+		"jobs: []",
+		"jobs: [{input: a}]",
+		"jobs: [{name: a}, {name: a}]",
+		"jobs: [{name: a, command: run}]",
+		"jobs: [{name: a, command: nope}]",
+		"jobs: [{name: a, config: b.yaml}]",
+		"jobs: [{name: a, input: {x: 1}}]",
+		"jobs: [{name: a, args: [{x: 1}]}]",
+		"defaults: {config: b.yaml}\njobs: [{name: a}]",
+	} {
+		if jobs, err := writePipeline(t, text).jobs("jobs.yaml"); err == nil {
+			t.Errorf("%s: made jobs %+v", text, jobs)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "jobs.yaml")
+	if err := os.WriteFile(path, []byte("paralel: 2\njobs: [{name: a}]\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadPipeline(path); err == nil {
+		t.Error("loaded a jobs file with an unknown setting")
+	}
+}
+
+func TestApplyJobDefaults(t *testing.T) {
+	fs := flag.NewFlagSet("merge", flag.ContinueOnError)
+	output := fs.String("output", "", "")
+	codec := fs.String("codec", "", "")
+	if err := fs.Parse([]string{"-output", "merged.avro"}); err != nil {
+		t.Fatal(err)
+	}
+	// -on-error isn't a flag of merge, and the command line wins over -output
+	t.Setenv(jobDefaultsEnv, `["-codec=zstd","-on-error=collect","-output=other.avro"]`)
+	if err := applyJobDefaults(fs); err != nil {
+		t.Fatal(err)
+	}
+	if *output != "merged.avro" || *codec != "zstd" {
+		t.Fatalf("set output %q, codec %q", *output, *codec)
+	}
+	if _, ok := os.LookupEnv(jobDefaultsEnv); ok {
+		t.Error("the defaults are passed on to the job's own processes")
+	}
+
+	t.Setenv(jobDefaultsEnv, `["-codec=zstd"]`)
+	fs = flag.NewFlagSet("merge", flag.ContinueOnError)
+	fs.Int("codec", 0, "")
+	if err := applyJobDefaults(fs); err == nil {
+		t.Error("applied an invalid default")
+	}
+}
+
+func TestPipelineMergeJob(t *testing.T) {
+	dir := t.TempDir()
+	for i, codec := range []string{ocfCodecDeflate, ocfCodecSnappy} {
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("part-%d.avro", i)), writeTestOCF(t, codec, 100, nil, nil), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	output := filepath.Join(t.TempDir(), "merged.avro")
+	config := writePipeline(t, fmt.Sprintf(`
+defaults:
+  on-error: collect
+  codec: zstd
+jobs:
+  - name: compact
+    command: merge
+    output: %s
+    args: [%s]
+`, output, dir))
+	jobs, err := config.jobs("jobs.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Run the job as its process would
+	defaults, err := json.Marshal(jobs[0].defaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(jobDefaultsEnv, string(defaults))
+	commands[jobs[0].command](jobs[0].args)
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blocks, codec := countBlocks(t, data); blocks == 0 || codec != ocfCodecZstandard {
+		t.Errorf("merged %d blocks with codec %s", blocks, codec)
+	}
+	if ids := readBlockIDs(t, data); len(ids) != 200 {
+		t.Errorf("merged %d records", len(ids))
+	}
+}
+
+func TestRunJobs(t *testing.T) {
+	// The executable exits with the code its command names
+	executable := filepath.Join(t.TempDir(), "avroparser")
+	script := "#!/bin/sh\ncase $1 in ok) exit 0;; partial) exit 1;; *) exit 2;; esac\n"
+	if err := os.WriteFile(executable, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	jobs := []pipelineJob{{name: "a", command: "ok"}, {name: "b", command: "partial"}, {name: "c", command: "ok"}}
+
+	// Without keepGoing, the job after the failed one isn't started
+	if codes := runJobs(executable, jobs, 1, false); !reflect.DeepEqual(codes, []int{exitOK, exitPartial, exitFatal}) {
+		t.Fatalf("exit codes %v", codes)
+	}
+	if codes := runJobs(executable, jobs, 2, true); !reflect.DeepEqual(codes, []int{exitOK, exitPartial, exitOK}) {
+		t.Fatalf("exit codes with keepGoing %v", codes)
+	}
+	if !allFatal([]int{exitFatal, exitFatal}) || allFatal([]int{exitFatal, exitPartial}) {
+		t.Fatal("allFatal is wrong")
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+)
+
+func runPivot(args []string) {
+	fs := flag.NewFlagSet("pivot", flag.ExitOnError)
+	outputPath := fs.String("output", stdioPath, "Output CSV file for the table, or - for stdout")
+	rowList := fs.String("rows", "", "Comma-separated field paths whose values make the rows, e.g. event_date")
+	columnField := fs.String("columns", "", "Field path whose values make the columns, e.g. event_name")
+	aggName := fs.String("agg", "count", "Aggregate in each cell: count, count_distinct(field), sum(field), avg(field), min(field) or max(field)")
+	events := addEventFilterFlags(fs)
+	csvOutput := addCSVReportFlags(fs)
+	logging := addLogFlags(fs)
+	parseFlags(fs, args)
+	logging.setup()
+
+	if *rowList == "" || *columnField == "" || fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: avroparser pivot -rows <field,...> -columns <field> [-agg count|sum(x)|...] [-output <file>|-] <avro_or_ndjson_file|dir|glob|->...")
+		os.Exit(exitFatal)
+	}
+	dialect, err := csvOutput.dialect()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	rowFields := splitFieldList(*rowList)
+	var rowPaths [][]string
+	for _, path := range append(rowFields, *columnField) {
+		if path == "" || strings.HasPrefix(path, ".") || strings.HasSuffix(path, ".") || strings.Contains(path, "..") {
+			fmt.Fprintf(os.Stderr, "Invalid field path %q\n", path)
+			os.Exit(exitFatal)
+		}
+		rowPaths = append(rowPaths, strings.Split(path, "."))
+	}
+	columnPath := rowPaths[len(rowPaths)-1]
+	rowPaths = rowPaths[:len(rowPaths)-1]
+	aggs, err := parseAggregates(*aggName)
+	if err == nil && len(aggs) != 1 {
+		err = fmt.Errorf("-agg must name a single aggregate, got %q", *aggName)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	agg := aggs[0]
+	source, err := events.source()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+
+	var inputs []inputFile
+	for _, arg := range fs.Args() {
+		inputs = append(inputs, mustExpandInputs(arg)...)
+	}
+
+	table := pivotTable{rows: make(map[string]*pivotRow), columns: make(map[string]bool)}
+	ignored := 0
+	failed := source.each(inputs, func(event interface{}) {
+		cells := table.row(event, rowPaths)
+		column := pivotValue(lookupPath(event, columnPath))
+		table.columns[column] = true
+		s := cells[column]
+		if s == nil {
+			s = &aggregateState{}
+			cells[column] = s
+		}
+		if !s.add(agg, event) {
+			ignored++
+		}
+	})
+	if failed == len(inputs) {
+		os.Exit(exitFatal)
+	}
+	if ignored > 0 {
+		slog.Warn("Left out values that aren't numbers", "aggregate", agg.name, "events", ignored)
+	}
+
+	out, err := openOutput(*outputPath)
+	if err == nil {
+		err = table.writeCSV(out, rowFields, agg, dialect)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		slog.Error("Cannot write table", "output", displayPath(*outputPath), "error", err)
+		os.Exit(exitFatal)
+	}
+	slog.Info("Wrote table", "rows", len(table.rows), "columns", len(table.columns), "output", displayPath(*outputPath))
+	if failed > 0 {
+		os.Exit(exitPartial)
+	}
+}
+
+// pivotTable holds the aggregate of every cell that has events, by the
+// values of the row fields and then of the column field.
+type pivotTable struct {
+	rows    map[string]*pivotRow
+	columns map[string]bool
+}
+
+type pivotRow struct {
+	values []string
+	cells  map[string]*aggregateState
+}
+
+// row returns the cells of the event's row, adding the row if it's new.
+func (pt *pivotTable) row(event interface{}, paths [][]string) map[string]*aggregateState {
+	values := make([]string, len(paths))
+	for i, path := range paths {
+		values[i] = pivotValue(lookupPath(event, path))
+	}
+	// Joined by the unit separator, which values don't hold in practice
+	key := strings.Join(values, "\x1f")
+	r := pt.rows[key]
+	if r == nil {
+		r = &pivotRow{values: values, cells: make(map[string]*aggregateState)}
+		pt.rows[key] = r
+	}
+	return r.cells
+}
+
+// pivotValue renders a row or column value, with missing and null values
+// empty.
+func pivotValue(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return countKey(v)
+}
+
+// writeCSV writes the table with its rows and columns ordered by value. A
+// cell without events has the aggregate of none: 0 for the counts and
+// empty for the others.
+func (pt *pivotTable) writeCSV(w io.Writer, rowFields []string, agg aggregate, dialect csvDialect) error {
+	columns := sortedKeys(pt.columns)
+	cw := newCSVWriter(w, dialect)
+	header := append(append([]string(nil), rowFields...), columns...)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	rows := make([]*pivotRow, 0, len(pt.rows))
+	for _, r := range pt.rows {
+		rows = append(rows, r)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		for k := range rowFields {
+			if rows[i].values[k] != rows[j].values[k] {
+				return rows[i].values[k] < rows[j].values[k]
+			}
+		}
+		return false
+	})
+	var none aggregateState
+	for _, r := range rows {
+		record := append([]string(nil), r.values...)
+		for _, column := range columns {
+			s := r.cells[column]
+			if s == nil {
+				s = &none
+			}
+			record = append(record, s.cell(agg))
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
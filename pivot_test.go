@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestPivotTable(t *testing.T) {
+	for _, tt := range []struct {
+		agg  string
+		want string
+	}{
+		{"count", "event_date,platform,level_end,level_start\n" +
+			"2026-10-14,IOS,0,1\n" +
+			"2026-10-15,ANDROID,1,2\n" +
+			"2026-10-15,IOS,1,0\n"},
+		{"sum(score)", "event_date,platform,level_end,level_start\n" +
+			"2026-10-14,IOS,,\n" +
+			"2026-10-15,ANDROID,30,\n" +
+			"2026-10-15,IOS,5,\n"},
+	} {
+		aggs, err := parseAggregates(tt.agg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		agg := aggs[0]
+		table := pivotTable{rows: make(map[string]*pivotRow), columns: make(map[string]bool)}
+		rowPaths := [][]string{{"event_date"}, {"platform"}}
+		for _, msg := range []string{
+			`{"event_date":"2026-10-15","platform":"ANDROID","event_name":"level_start"}`,
+			`{"event_date":"2026-10-15","platform":"ANDROID","event_name":"level_start"}`,
+			`{"event_date":"2026-10-15","platform":"ANDROID","event_name":"level_end","score":30}`,
+			`{"event_date":"2026-10-15","platform":"IOS","event_name":"level_end","score":5}`,
+			`{"event_date":"2026-10-14","platform":"IOS","event_name":"level_start"}`,
+		} {
+			event, err := parseMessage(json.RawMessage(msg))
+			if err != nil {
+				t.Fatal(err)
+			}
+			cells := table.row(event, rowPaths)
+			column := pivotValue(lookupPath(event, []string{"event_name"}))
+			table.columns[column] = true
+			if cells[column] == nil {
+				cells[column] = &aggregateState{}
+			}
+			cells[column].add(agg, event)
+		}
+
+		var buf bytes.Buffer
+		if err := table.writeCSV(&buf, []string{"event_date", "platform"}, agg, csvDialect{}); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != tt.want {
+			t.Errorf("%s: wrote %q, want %q", tt.agg, buf.String(), tt.want)
+		}
+	}
+}
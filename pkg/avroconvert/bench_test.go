@@ -0,0 +1,156 @@
+package avroconvert
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/linkedin/goavro/v2"
+)
+
+// benchSchema is an event with the kinds of values real exports hold:
+// nested records, arrays, maps, unions and logical types.
+const benchSchema = `{
+  "type": "record",
+  "name": "Event",
+  "fields": [
+    {"name": "event_name", "type": "string"},
+    {"name": "event_timestamp", "type": {"type": "long", "logicalType": "timestamp-micros"}},
+    {"name": "user_id", "type": ["null", "string"]},
+    {"name": "platform", "type": {"type": "enum", "name": "Platform", "symbols": ["ANDROID", "IOS", "WEB"]}},
+    {"name": "level", "type": "int"},
+    {"name": "score", "type": "double"},
+    {"name": "geo", "type": {"type": "record", "name": "Geo", "fields": [
+      {"name": "country", "type": "string"},
+      {"name": "city", "type": ["null", "string"]}
+    ]}},
+    {"name": "event_params", "type": {"type": "array", "items": {"type": "record", "name": "Param", "fields": [
+      {"name": "key", "type": "string"},
+      {"name": "value", "type": ["null", "string", "long", "double"]}
+    ]}}},
+    {"name": "user_properties", "type": {"type": "map", "values": "string"}},
+    {"name": "message", "type": "bytes"}
+  ]
+}`
+
+// benchRecord returns the binary encoding of an event, with the codec and
+// parsed schema to decode it.
+func benchRecord(b *testing.B) ([]byte, *goavro.Codec, *Schema) {
+	b.Helper()
+	codec, err := goavro.NewCodec(benchSchema)
+	if err != nil {
+		b.Fatal(err)
+	}
+	schema, err := ParseSchema(benchSchema)
+	if err != nil {
+		b.Fatal(err)
+	}
+	params := make([]interface{}, 6)
+	for i := range params {
+		var value interface{}
+		switch i % 3 {
+		case 0:
+			value = goavro.Union("string", fmt.Sprintf("screen_%d", i))
+		case 1:
+			value = goavro.Union("long", int64(i*1000))
+		case 2:
+			value = goavro.Union("double", float64(i)*1.5)
+		}
+		params[i] = map[string]interface{}{"key": fmt.Sprintf("param_%d", i), "value": value}
+	}
+	data, err := codec.BinaryFromNative(nil, map[string]interface{}{
+		"event_name":      "level_complete",
+		"event_timestamp": time.Date(2025, 1, 1, 12, 30, 0, 0, time.UTC),
+		"user_id":         goavro.Union("string", "user-0001f00d"),
+		"platform":        "ANDROID",
+		"level":           int32(42),
+		"score":           1234.5,
+		"geo":             map[string]interface{}{"country": "DE", "city": goavro.Union("string", "Berlin")},
+		"event_params":    params,
+		"user_properties": map[string]interface{}{"tier": "tier-2", "locale": "en_US"},
+		"message":         []byte(`{"session":123,"ok":true}`),
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	return data, codec, schema
+}
+
+// BenchmarkConvert compares converting decoded records in place with
+// converting them into new maps and slices, as records were before.
+func BenchmarkConvert(b *testing.B) {
+	data, codec, schema := benchRecord(b)
+	converter, err := NewJSONConverter(ConverterOptions{TimeZone: "UTC"})
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, bench := range []struct {
+		name    string
+		convert func(*Schema, interface{}) interface{}
+	}{
+		{"in-place", converter.ValueInPlace},
+		{"copy", converter.Value},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				native, _, err := codec.NativeFromBinary(data)
+				if err != nil {
+					b.Fatal(err)
+				}
+				bench.convert(schema, native)
+			}
+		})
+	}
+}
+
+// BenchmarkAppendJSON compares encoding a converted record with appendJSON
+// and with json.Marshal, which records were encoded with before.
+func BenchmarkAppendJSON(b *testing.B) {
+	data, codec, schema := benchRecord(b)
+	converter, err := NewJSONConverter(ConverterOptions{TimeZone: "UTC"})
+	if err != nil {
+		b.Fatal(err)
+	}
+	native, _, err := codec.NativeFromBinary(data)
+	if err != nil {
+		b.Fatal(err)
+	}
+	record := converter.Value(schema, native)
+
+	var keys []string
+	appended, err := appendJSON(nil, record, &keys)
+	if err != nil {
+		b.Fatal(err)
+	}
+	marshaled, err := json.Marshal(record)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if !bytes.Equal(appended, marshaled) {
+		b.Fatalf("appendJSON wrote\n%s\njson.Marshal wrote\n%s", appended, marshaled)
+	}
+
+	b.Run("appendJSON", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(marshaled)))
+		var buf []byte
+		for i := 0; i < b.N; i++ {
+			if buf, err = appendJSON(buf[:0], record, &keys); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("json.Marshal", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(marshaled)))
+		for i := 0; i < b.N; i++ {
+			if _, err := json.Marshal(record); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,426 @@
+package avroconvert
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Timestamp formats accepted by ConverterOptions.TimeFormat besides Go time
+// layouts.
+const (
+	TimeFormatRFC3339   = "rfc3339"
+	TimeFormatUnix      = "unix"
+	TimeFormatUnixMilli = "unixmilli"
+	TimeFormatUnixMicro = "unixmicro"
+	TimeFormatUnixNano  = "unixnano"
+)
+
+// LocalTimestampLayout is used for local-timestamp values, which carry no
+// time zone.
+const LocalTimestampLayout = "2006-01-02T15:04:05.999999999"
+
+// Converter turns goavro native values into values encoding/json can
+// render.
+type Converter interface {
+	// Value converts v as described by schema s, or by v's Go type alone
+	// when s is nil.
+	Value(s *Schema, v interface{}) interface{}
+}
+
+// InPlaceConverter is implemented by Converters that can convert a value by
+// reusing its maps and slices, which saves allocating a copy of every record.
+type InPlaceConverter interface {
+	Converter
+	// ValueInPlace converts v as Value does, overwriting v's maps and slices
+	// with the converted values. v must not be used afterwards.
+	ValueInPlace(s *Schema, v interface{}) interface{}
+}
+
+// ConverterOptions configures a JSONConverter. The zero value renders
+// timestamps as RFC 3339 in UTC and decimals as strings.
+type ConverterOptions struct {
+	TimeFormat    string // one of the TimeFormat constants or a Go time layout
+	TimeZone      string // IANA name of the zone timestamps are rendered in
+	DecimalFormat string // "string" (exact) or "number"
+}
+
+// JSONConverter converts goavro native values into values that
+// encoding/json renders as plain JSON: unions are unwrapped to their value,
+// enums become strings, bytes/fixed become strings (UTF-8 text as-is,
+// anything else base64 encoded) and logical types get readable forms.
+type JSONConverter struct {
+	timeFormat      string         // one of the TimeFormat constants or a Go time layout
+	location        *time.Location // zone timestamps are rendered in
+	decimalAsNumber bool           // write decimals as JSON numbers instead of strings
+}
+
+// NewJSONConverter validates the conversion settings.
+func NewJSONConverter(opts ConverterOptions) (*JSONConverter, error) {
+	timeFormat := opts.TimeFormat
+	if timeFormat == "" {
+		timeFormat = TimeFormatRFC3339
+	}
+	location, err := time.LoadLocation(opts.TimeZone)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone %q: %w", opts.TimeZone, err)
+	}
+	switch opts.DecimalFormat {
+	case "", "string", "number":
+	default:
+		return nil, fmt.Errorf("unknown decimal format %q (expected string or number)", opts.DecimalFormat)
+	}
+	return &JSONConverter{timeFormat: timeFormat, location: location, decimalAsNumber: opts.DecimalFormat == "number"}, nil
+}
+
+// Value converts v as described by schema s, or as Generic does when s is
+// nil.
+func (c *JSONConverter) Value(s *Schema, v interface{}) interface{} {
+	return c.value(s, v, false)
+}
+
+// ValueInPlace converts v as Value does, reusing the maps and slices of v.
+func (c *JSONConverter) ValueInPlace(s *Schema, v interface{}) interface{} {
+	return c.value(s, v, true)
+}
+
+// value converts v, into its own maps and slices if reuse is set.
+func (c *JSONConverter) value(s *Schema, v interface{}, reuse bool) interface{} {
+	if v == nil {
+		return nil
+	}
+	if s == nil {
+		return c.generic(v, reuse)
+	}
+
+	if s.LogicalType != "" {
+		if converted, ok := c.logical(s, v); ok {
+			return converted
+		}
+	}
+
+	switch s.Kind {
+	case "union":
+		branch, value := UnwrapUnion(s, v)
+		if branch == nil {
+			return c.generic(value, reuse)
+		}
+		return c.value(branch, value, reuse)
+
+	case "record":
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return c.generic(v, reuse)
+		}
+		if !reuse {
+			out := make(map[string]interface{}, len(s.Fields))
+			for _, f := range s.Fields {
+				if value, ok := m[f.Name]; ok {
+					out[f.Name] = c.value(f.Schema, value, false)
+				}
+			}
+			return out
+		}
+		present := 0
+		for _, f := range s.Fields {
+			if value, ok := m[f.Name]; ok {
+				m[f.Name] = c.value(f.Schema, value, true)
+				present++
+			}
+		}
+		if present != len(m) {
+			// Only the fields of the schema are written
+			for name := range m {
+				if s.Field(name) == nil {
+					delete(m, name)
+				}
+			}
+		}
+		if m == nil {
+			return map[string]interface{}{}
+		}
+		return m
+
+	case "array":
+		items, ok := v.([]interface{})
+		if !ok {
+			return c.generic(v, reuse)
+		}
+		out := items
+		if !reuse || items == nil {
+			out = make([]interface{}, len(items))
+		}
+		for i, item := range items {
+			out[i] = c.value(s.Items, item, reuse)
+		}
+		return out
+
+	case "map":
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return c.generic(v, reuse)
+		}
+		out := m
+		if !reuse || m == nil {
+			out = make(map[string]interface{}, len(m))
+		}
+		for k, value := range m {
+			out[k] = c.value(s.Values, value, reuse)
+		}
+		return out
+	}
+
+	return c.generic(v, reuse)
+}
+
+// logical converts values of Avro logical types. It reports false when the
+// value doesn't have the expected native type, so the caller falls back to
+// the underlying type.
+func (c *JSONConverter) logical(s *Schema, v interface{}) (interface{}, bool) {
+	switch s.LogicalType {
+	case "timestamp-millis", "timestamp-micros":
+		// goavro decodes these to time.Time
+		t, ok := v.(time.Time)
+		if !ok {
+			return nil, false
+		}
+		return c.timestamp(t), true
+
+	case "timestamp-nanos":
+		n, ok := v.(int64)
+		if !ok {
+			return nil, false
+		}
+		return c.timestamp(time.Unix(0, n)), true
+
+	case "local-timestamp-millis", "local-timestamp-micros", "local-timestamp-nanos":
+		n, ok := v.(int64)
+		if !ok {
+			return nil, false
+		}
+		var t time.Time
+		switch s.LogicalType {
+		case "local-timestamp-millis":
+			t = time.UnixMilli(n)
+		case "local-timestamp-micros":
+			t = time.UnixMicro(n)
+		default:
+			t = time.Unix(0, n)
+		}
+		return c.localTimestamp(t.UTC()), true
+
+	case "date":
+		t, ok := v.(time.Time)
+		if !ok {
+			return nil, false
+		}
+		return t.UTC().Format("2006-01-02"), true
+
+	case "time-millis", "time-micros":
+		d, ok := v.(time.Duration)
+		if !ok {
+			return nil, false
+		}
+		return timeOfDay(d), true
+
+	case "decimal":
+		r, ok := v.(*big.Rat)
+		if !ok {
+			return nil, false
+		}
+		text := r.FloatString(s.Scale)
+		if c.decimalAsNumber {
+			return json.Number(text), true
+		}
+		return text, true
+
+	case "uuid":
+		switch t := v.(type) {
+		case string:
+			return t, true
+		case []byte:
+			if len(t) == 16 {
+				return fmt.Sprintf("%x-%x-%x-%x-%x", t[0:4], t[4:6], t[6:8], t[8:10], t[10:16]), true
+			}
+		}
+		return nil, false
+
+	case "duration":
+		b, ok := v.([]byte)
+		if !ok || len(b) != 12 {
+			return nil, false
+		}
+		return map[string]interface{}{
+			"months":       binary.LittleEndian.Uint32(b[0:4]),
+			"days":         binary.LittleEndian.Uint32(b[4:8]),
+			"milliseconds": binary.LittleEndian.Uint32(b[8:12]),
+		}, true
+	}
+	return nil, false
+}
+
+// Timestamp renders an instant as timestamp values are rendered, for
+// timestamps found outside of Avro logical types.
+func (c *JSONConverter) Timestamp(t time.Time) interface{} {
+	return c.timestamp(t)
+}
+
+// timestamp renders an instant according to the configured format.
+func (c *JSONConverter) timestamp(t time.Time) interface{} {
+	switch c.timeFormat {
+	case TimeFormatUnix:
+		return t.Unix()
+	case TimeFormatUnixMilli:
+		return t.UnixMilli()
+	case TimeFormatUnixMicro:
+		return t.UnixMicro()
+	case TimeFormatUnixNano:
+		return t.UnixNano()
+	case TimeFormatRFC3339:
+		return t.In(c.location).Format(time.RFC3339Nano)
+	}
+	return t.In(c.location).Format(c.timeFormat)
+}
+
+// localTimestamp renders a zone-less wall clock time. Numeric formats count
+// from the local epoch; layouts are applied without zone conversion.
+func (c *JSONConverter) localTimestamp(t time.Time) interface{} {
+	switch c.timeFormat {
+	case TimeFormatUnix, TimeFormatUnixMilli, TimeFormatUnixMicro, TimeFormatUnixNano:
+		return c.timestamp(t)
+	case TimeFormatRFC3339:
+		return t.Format(LocalTimestampLayout)
+	}
+	return t.Format(c.timeFormat)
+}
+
+// timeOfDay renders a time-millis/time-micros value as HH:MM:SS with an
+// optional fractional part.
+func timeOfDay(d time.Duration) string {
+	t := time.Date(0, 1, 1, 0, 0, 0, 0, time.UTC).Add(d)
+	return t.Format("15:04:05.999999")
+}
+
+// Generic converts native values whose JSON form doesn't depend on the
+// schema.
+func (c *JSONConverter) Generic(v interface{}) interface{} {
+	return c.generic(v, false)
+}
+
+// generic converts v as Generic does, into its own maps and slices if reuse
+// is set.
+func (c *JSONConverter) generic(v interface{}, reuse bool) interface{} {
+	switch t := v.(type) {
+	case []byte:
+		if utf8.Valid(t) {
+			return string(t)
+		}
+		return base64.StdEncoding.EncodeToString(t)
+	case float32:
+		return finiteFloat(float64(t))
+	case float64:
+		if math.IsNaN(t) || math.IsInf(t, 0) {
+			return finiteFloat(t)
+		}
+		return v
+	case time.Time:
+		return c.timestamp(t)
+	case time.Duration:
+		return timeOfDay(t)
+	case *big.Rat:
+		text := strings.TrimRight(strings.TrimRight(t.FloatString(18), "0"), ".")
+		if c.decimalAsNumber {
+			return json.Number(text)
+		}
+		return text
+	case map[string]interface{}:
+		out := t
+		if !reuse || t == nil {
+			out = make(map[string]interface{}, len(t))
+		}
+		for k, value := range t {
+			out[k] = c.generic(value, reuse)
+		}
+		return out
+	case []interface{}:
+		out := t
+		if !reuse || t == nil {
+			out = make([]interface{}, len(t))
+		}
+		for i, item := range t {
+			out[i] = c.generic(item, reuse)
+		}
+		return out
+	}
+	return v
+}
+
+// finiteFloat keeps NaN and infinities representable in JSON by turning them
+// into strings.
+func finiteFloat(f float64) interface{} {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	}
+	return f
+}
+
+// UnwrapUnion returns the branch schema and value of a goavro union value,
+// which is either nil or a single-entry map keyed by the branch type name.
+// The branch is nil when it cannot be matched against the schema.
+func UnwrapUnion(s *Schema, v interface{}) (*Schema, interface{}) {
+	if v == nil {
+		for _, branch := range s.Branches {
+			if branch.Kind == "null" {
+				return branch, nil
+			}
+		}
+		return nil, nil
+	}
+
+	m, ok := v.(map[string]interface{})
+	if !ok || len(m) != 1 {
+		return nil, v
+	}
+	for key, value := range m {
+		for _, branch := range s.Branches {
+			if UnionBranchName(branch) == key {
+				return branch, value
+			}
+		}
+		return nil, value
+	}
+	return nil, v
+}
+
+// goavroLogicalTypes are the logical types goavro decodes itself, and which
+// therefore appear in union branch names as "<type>.<logicalType>".
+var goavroLogicalTypes = map[string]bool{
+	"long.timestamp-millis": true,
+	"long.timestamp-micros": true,
+	"int.time-millis":       true,
+	"long.time-micros":      true,
+	"int.date":              true,
+	"bytes.decimal":         true,
+}
+
+// UnionBranchName returns the key goavro uses for a union branch.
+func UnionBranchName(s *Schema) string {
+	if s.IsNamed() {
+		return s.Name
+	}
+	if s.LogicalType != "" && goavroLogicalTypes[s.Kind+"."+s.LogicalType] {
+		return s.Kind + "." + s.LogicalType
+	}
+	return s.Kind
+}
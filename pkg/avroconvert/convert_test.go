@@ -0,0 +1,101 @@
+package avroconvert
+
+import (
+	"encoding/json"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestConvertLogicalTypes(t *testing.T) {
+	schema, err := ParseSchema(`{"type":"record","name":"L","fields":[
+		{"name":"ts","type":{"type":"long","logicalType":"timestamp-millis"}},
+		{"name":"local","type":{"type":"long","logicalType":"local-timestamp-micros"}},
+		{"name":"day","type":{"type":"int","logicalType":"date"}},
+		{"name":"tod","type":{"type":"int","logicalType":"time-millis"}},
+		{"name":"price","type":{"type":"bytes","logicalType":"decimal","precision":6,"scale":2}},
+		{"name":"id","type":{"type":"fixed","name":"U","size":16,"logicalType":"uuid"}},
+		{"name":"maybe","type":["null",{"type":"long","logicalType":"timestamp-micros"}]}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2024, 3, 1, 12, 30, 0, 500e6, time.UTC)
+	record := map[string]interface{}{
+		"ts": at, "local": at.UnixMicro(), "day": time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		"tod": 90*time.Minute + 1500*time.Millisecond, "price": big.NewRat(12345, 100),
+		"id":    []byte{0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0, 0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef},
+		"maybe": map[string]interface{}{"long.timestamp-micros": at},
+	}
+	for _, tc := range []struct {
+		timeFormat, zone, decimal, want string
+	}{
+		{TimeFormatRFC3339, "UTC", "string", `{"day":"2024-03-01","id":"12345678-9abc-def0-0123-456789abcdef","local":"2024-03-01T12:30:00.5",` +
+			`"maybe":"2024-03-01T12:30:00.5Z","price":"123.45","tod":"01:30:01.5","ts":"2024-03-01T12:30:00.5Z"}`},
+		{TimeFormatUnixMilli, "UTC", "number", `{"day":"2024-03-01","id":"12345678-9abc-def0-0123-456789abcdef","local":1709296200500,` +
+			`"maybe":1709296200500,"price":123.45,"tod":"01:30:01.5","ts":1709296200500}`},
+		{"2006-01-02 15:04", "Europe/Berlin", "string", `{"day":"2024-03-01","id":"12345678-9abc-def0-0123-456789abcdef","local":"2024-03-01 12:30",` +
+			`"maybe":"2024-03-01 13:30","price":"123.45","tod":"01:30:01.5","ts":"2024-03-01 13:30"}`},
+	} {
+		c, err := NewJSONConverter(ConverterOptions{TimeFormat: tc.timeFormat, TimeZone: tc.zone, DecimalFormat: tc.decimal})
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := json.Marshal(c.Value(schema, record))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != tc.want {
+			t.Errorf("%s in %s:\n got %s\nwant %s", tc.timeFormat, tc.zone, data, tc.want)
+		}
+	}
+
+	if _, err := NewJSONConverter(ConverterOptions{TimeZone: "Nowhere/City"}); err == nil {
+		t.Error("accepted an unknown time zone")
+	}
+	if _, err := NewJSONConverter(ConverterOptions{TimeZone: "UTC", DecimalFormat: "float"}); err == nil {
+		t.Error("accepted an unknown decimal format")
+	}
+}
+
+func TestConvertInPlace(t *testing.T) {
+	schema, err := ParseSchema(`{"type":"record","name":"E","fields":[
+		{"name":"at","type":{"type":"long","logicalType":"timestamp-millis"}},
+		{"name":"tags","type":{"type":"array","items":"bytes"}},
+		{"name":"params","type":{"type":"map","values":["null","string",{"type":"record","name":"V","fields":[{"name":"n","type":"float"}]}]}},
+		{"name":"maybe","type":["null","string"]}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	record := func() map[string]interface{} {
+		return map[string]interface{}{
+			"at":   at,
+			"tags": []interface{}{[]byte("a"), []byte{0xff}},
+			"params": map[string]interface{}{
+				"level": map[string]interface{}{"string": "3"},
+				"score": map[string]interface{}{"V": map[string]interface{}{"n": float32(1.5)}},
+				"none":  nil,
+			},
+			"maybe": nil,
+			"extra": "not in the schema",
+		}
+	}
+	c, err := NewJSONConverter(ConverterOptions{TimeZone: "UTC"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := c.Value(schema, record())
+	in := record()
+	got := c.ValueInPlace(schema, in)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("converted in place to %#v, want %#v", got, want)
+	}
+	if reflect.ValueOf(got).Pointer() != reflect.ValueOf(in).Pointer() {
+		t.Error("ValueInPlace allocated a new record map")
+	}
+	if _, ok := got.(map[string]interface{})["extra"]; ok {
+		t.Error("kept a field that is not in the schema")
+	}
+}
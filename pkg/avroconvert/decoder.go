@@ -0,0 +1,424 @@
+package avroconvert
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/linkedin/goavro/v2"
+)
+
+// RecordReader iterates over decoded Avro records. goavro.OCFReader
+// implements it for container files. Read returns new maps and slices for
+// every record, which the Decoder may convert in place.
+type RecordReader interface {
+	Scan() bool
+	Read() (interface{}, error)
+	Err() error
+}
+
+// RawRecordReader is implemented by RecordReaders that keep the undecoded
+// bytes of their records, so records that fail can be set aside for later
+// investigation.
+type RawRecordReader interface {
+	RecordReader
+	// Raw returns the undecoded bytes of the record Read last returned, or
+	// of the data Err reports as unreadable.
+	Raw() []byte
+}
+
+// Stats counts what happened to the records of one input.
+type Stats struct {
+	Read        int // records read from the input
+	Messages    int // messages handed to the sink
+	Skipped     int // records that could not be read or converted
+	InvalidJSON int // field values that were not JSON, saved as raw strings unless DecoderOptions.StrictJSON is set
+	Filtered    int // records left out by the filter, sampler or transform
+	// Failures counts the skipped records, including messages skipped for
+	// not being valid JSON, by RecordError.Reason.
+	Failures map[string]int
+}
+
+// Add accumulates the counts of other into s.
+func (s *Stats) Add(other Stats) {
+	s.Read += other.Read
+	s.Messages += other.Messages
+	s.Skipped += other.Skipped
+	s.InvalidJSON += other.InvalidJSON
+	s.Filtered += other.Filtered
+	for reason, n := range other.Failures {
+		if s.Failures == nil {
+			s.Failures = make(map[string]int)
+		}
+		s.Failures[reason] += n
+	}
+}
+
+// ReasonUnreadTail is the RecordError.Reason, and the Stats.Failures key,
+// of the rest of an input that could not be read, such as the end of a
+// truncated file.
+const ReasonUnreadTail = "Cannot read further records"
+
+// RecordError describes a record that was skipped because it could not be
+// read, resolved, converted or transformed, or whose field value was not
+// valid JSON.
+type RecordError struct {
+	Record int    // position of the record in the input, counting from 1
+	Reason string // what went wrong, e.g. "Skipping unreadable record"
+	Field  string // the field being extracted, if any
+	Err    error  // the underlying error, if any
+	// Raw holds the bytes that failed when they are known: the Avro binary
+	// of a record that cannot be read, if the RecordReader is a
+	// RawRecordReader, or a field value that is not valid JSON.
+	Raw []byte
+}
+
+func (e *RecordError) Error() string {
+	msg := fmt.Sprintf("record %d: %s", e.Record, e.Reason)
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
+// Sampler selects a subset of an input's records.
+type Sampler interface {
+	// Done reports whether no more records are wanted.
+	Done() bool
+	// Keep reports whether the next record is part of the sample.
+	Keep() bool
+}
+
+// DecoderOptions configures a Decoder. Only Converter is required.
+type DecoderOptions struct {
+	// Field, if set, selects a single top-level field to write instead of
+	// the whole record. Bytes and string values are taken as embedded JSON.
+	Field string
+	// ReaderSchema, if set, is the schema records are resolved to.
+	ReaderSchema *Schema
+	Converter    Converter
+	// Filter is given each record as it would be written as JSON and
+	// reports whether to keep it.
+	Filter func(record interface{}) bool
+	// Sampler selects the records to keep after filtering.
+	Sampler Sampler
+	// Transform turns each message into zero or more messages.
+	Transform func(msg json.RawMessage) ([]json.RawMessage, error)
+	// Logger receives a warning for every malformed record, which is
+	// skipped, with the record's position in the input. Warnings are
+	// discarded when it is nil.
+	Logger *slog.Logger
+	// StrictJSON skips field values that are not valid JSON like malformed
+	// records, instead of writing them as JSON strings.
+	StrictJSON bool
+	// OnError, if set, is called for every skipped record. Returning an
+	// error stops decoding with that error.
+	OnError func(*RecordError) error
+}
+
+// Decoder converts Avro records to JSON messages for a Sink.
+type Decoder interface {
+	// Decode reads an Avro container file, or NDJSON or a JSON array as
+	// told by SniffFormat.
+	Decode(r io.Reader, sink Sink) (Stats, error)
+	// DecodeRecords converts the records of any RecordReader, decoded with
+	// the writer schema, or with a nil schema for schema-less records.
+	DecodeRecords(records RecordReader, schema *Schema, sink Sink) (Stats, error)
+}
+
+// NewDecoder returns a Decoder using opts.
+func NewDecoder(opts DecoderOptions) Decoder {
+	return &decoder{opts: opts}
+}
+
+type decoder struct {
+	opts DecoderOptions
+}
+
+func (d *decoder) Decode(r io.Reader, sink Sink) (Stats, error) {
+	br := bufio.NewReader(r)
+	format, err := SniffFormat(br)
+	if err != nil {
+		return Stats{}, fmt.Errorf("cannot read input: %w", err)
+	}
+	if format == FormatNDJSON || format == FormatJSONArray {
+		records, err := NewJSONRecordReader(br)
+		if err != nil {
+			return Stats{}, err
+		}
+		return d.DecodeRecords(records, nil, sink)
+	}
+
+	ocfReader, err := goavro.NewOCFReader(br)
+	if err != nil {
+		return Stats{}, fmt.Errorf("cannot create OCF reader: %w", err)
+	}
+	schema, err := ParseSchema(ocfReader.Codec().Schema())
+	if err != nil {
+		return Stats{}, fmt.Errorf("cannot parse writer schema: %w", err)
+	}
+	return d.DecodeRecords(ocfReader, schema, sink)
+}
+
+// DecodeRecords hands each record to the sink as JSON. With an empty
+// Field the whole record is converted; sinks implementing NativeSink
+// then get it unconverted unless a Transform is set or there is no schema.
+// Malformed records are reported and skipped; only schema and write errors,
+// and errors returned by OnError, are returned.
+func (d *decoder) DecodeRecords(records RecordReader, schema *Schema, sink Sink) (Stats, error) {
+	var stats Stats
+	var err error
+	opts := d.opts
+	field, converter := opts.Field, opts.Converter
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
+	}
+
+	var resolver *Resolver
+	if opts.ReaderSchema != nil && schema == nil {
+		return stats, errors.New("a reader schema needs Avro input")
+	}
+	if opts.ReaderSchema != nil {
+		if resolver, err = NewResolver(schema, opts.ReaderSchema); err != nil {
+			return stats, fmt.Errorf("reader schema is incompatible with the writer schema: %w", err)
+		}
+		schema = opts.ReaderSchema
+	}
+
+	// Sinks that understand Avro types get whole records as they are
+	native, _ := sink.(NativeSink)
+	if field != "" || opts.Transform != nil || schema == nil {
+		native = nil
+	}
+	if native != nil {
+		if err := native.SetSchema(schema); err != nil {
+			return stats, err
+		}
+	}
+
+	var fieldSchema *Schema
+	if field != "" && schema != nil {
+		f := schema.Field(field)
+		if f == nil {
+			return stats, fmt.Errorf("schema has no field %q", field)
+		}
+		fieldSchema = f.Schema
+	}
+
+	// Records are converted in place unless a native sink or a selected
+	// field still needs them, or they may hold the resolver's defaults,
+	// which all records share
+	convert := converter.Value
+	if inPlace, ok := converter.(InPlaceConverter); ok && native == nil && resolver == nil && (field == "" || opts.Filter == nil) {
+		convert = inPlace.ValueInPlace
+	}
+	// Messages are written into buf and copied out at their exact size,
+	// with the map keys of a record sorted in keys
+	var buf []byte
+	var keys []string
+	marshal := func(v interface{}) (json.RawMessage, error) {
+		var err error
+		if buf, err = appendJSON(buf[:0], v, &keys); err != nil {
+			keys = keys[:0]
+			return nil, err
+		}
+		return append(json.RawMessage(nil), buf...), nil
+	}
+
+	rawRecords, _ := records.(RawRecordReader)
+	// rawBytes returns the undecoded bytes of the current record, if known
+	rawBytes := func() []byte {
+		if rawRecords == nil {
+			return nil
+		}
+		return rawRecords.Raw()
+	}
+
+	// skip reports a malformed record and hands it to OnError
+	skip := func(e *RecordError) error {
+		args := []any{"record", e.Record}
+		if e.Field != "" {
+			args = append(args, "field", e.Field)
+		}
+		if e.Err != nil {
+			args = append(args, "error", e.Err)
+		}
+		logger.Warn(e.Reason, args...)
+		if stats.Failures == nil {
+			stats.Failures = make(map[string]int)
+		}
+		stats.Failures[e.Reason]++
+		if opts.OnError != nil {
+			return opts.OnError(e)
+		}
+		return nil
+	}
+
+	read := 0
+	for (opts.Sampler == nil || !opts.Sampler.Done()) && records.Scan() {
+		read++
+		stats.Read++
+		record, err := records.Read()
+		if err != nil {
+			stats.Skipped++
+			if err := skip(&RecordError{Record: read, Reason: "Skipping unreadable record", Err: err, Raw: rawBytes()}); err != nil {
+				return stats, err
+			}
+			continue
+		}
+
+		if resolver != nil {
+			if record, err = resolver.Resolve(record); err != nil {
+				stats.Skipped++
+				if err := skip(&RecordError{Record: read, Reason: "Skipping record that cannot be resolved", Err: err}); err != nil {
+					return stats, err
+				}
+				continue
+			}
+		}
+
+		// The filter sees the record as it would be written as JSON
+		var converted interface{}
+		if opts.Filter != nil {
+			converted = convert(schema, record)
+			if !opts.Filter(converted) {
+				stats.Filtered++
+				continue
+			}
+		}
+		if opts.Sampler != nil && !opts.Sampler.Keep() {
+			stats.Filtered++
+			continue
+		}
+
+		if native != nil {
+			if err := native.WriteNative(record); err != nil {
+				return stats, fmt.Errorf("cannot write record: %w", err)
+			}
+			stats.Messages++
+			continue
+		}
+
+		var jsonData json.RawMessage
+		if field == "" {
+			if converted == nil {
+				converted = convert(schema, record)
+			}
+			jsonData, err = marshal(converted)
+			if err != nil {
+				stats.Skipped++
+				if err := skip(&RecordError{Record: read, Reason: "Skipping record that cannot be converted", Err: err}); err != nil {
+					return stats, err
+				}
+				continue
+			}
+		} else {
+			// The record is a map with the selected field
+			recordMap, ok := record.(map[string]interface{})
+			if !ok {
+				stats.Skipped++
+				if err := skip(&RecordError{Record: read, Reason: "Skipping record that is not a map", Err: fmt.Errorf("record is a %T", record)}); err != nil {
+					return stats, err
+				}
+				continue
+			}
+
+			valueSchema, value := fieldSchema, recordMap[field]
+			if valueSchema != nil && valueSchema.Kind == "union" {
+				valueSchema, value = UnwrapUnion(valueSchema, value)
+			}
+
+			switch v := value.(type) {
+			case nil:
+				stats.Skipped++
+				if err := skip(&RecordError{Record: read, Reason: "Skipping record whose field is null", Field: field}); err != nil {
+					return stats, err
+				}
+				continue
+
+			case []byte, string:
+				// The field contains JSON - validate it
+				var text []byte
+				if b, ok := v.([]byte); ok {
+					text = b
+				} else {
+					text = []byte(v.(string))
+				}
+				if err := json.Unmarshal(text, &jsonData); err != nil {
+					stats.InvalidJSON++
+					if opts.StrictJSON {
+						if err := skip(&RecordError{Record: read, Reason: "Skipping message that is not valid JSON", Field: field, Err: err, Raw: text}); err != nil {
+							return stats, err
+						}
+						continue
+					}
+					logger.Warn("Message is not valid JSON, saving as raw bytes", "record", read, "field", field)
+					// Save as raw string if not valid JSON
+					jsonData = json.RawMessage(fmt.Sprintf("%q", string(text)))
+				}
+
+			default:
+				jsonData, err = marshal(convert(valueSchema, value))
+				if err != nil {
+					stats.Skipped++
+					if err := skip(&RecordError{Record: read, Reason: "Skipping record whose field cannot be converted", Field: field, Err: err}); err != nil {
+						return stats, err
+					}
+					continue
+				}
+			}
+		}
+
+		if opts.Transform == nil {
+			if err := sink.WriteRecord(jsonData); err != nil {
+				return stats, fmt.Errorf("cannot write message: %w", err)
+			}
+			stats.Messages++
+			continue
+		}
+
+		outputs, err := opts.Transform(jsonData)
+		if err != nil {
+			stats.Skipped++
+			if err := skip(&RecordError{Record: read, Reason: "Skipping record that cannot be transformed", Err: err}); err != nil {
+				return stats, err
+			}
+			continue
+		}
+		if len(outputs) == 0 {
+			stats.Filtered++
+		}
+		for _, output := range outputs {
+			if err := sink.WriteRecord(output); err != nil {
+				return stats, fmt.Errorf("cannot write message: %w", err)
+			}
+			stats.Messages++
+		}
+	}
+
+	if err := records.Err(); err != nil {
+		// The rest of the input is lost, which is counted, and which OnError
+		// hears about, as the record that could not be read
+		logger.Warn(ReasonUnreadTail, "records", read, "error", err)
+		stats.Skipped++
+		if stats.Failures == nil {
+			stats.Failures = make(map[string]int)
+		}
+		stats.Failures[ReasonUnreadTail]++
+		if opts.OnError != nil {
+			if err := opts.OnError(&RecordError{Record: read + 1, Reason: ReasonUnreadTail, Err: err, Raw: rawBytes()}); err != nil {
+				return stats, err
+			}
+		}
+	}
+
+	return stats, nil
+}
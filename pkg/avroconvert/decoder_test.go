@@ -0,0 +1,220 @@
+package avroconvert
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/linkedin/goavro/v2"
+)
+
+const messageSchema = `{"type":"record","name":"Export","fields":[{"name":"id","type":"long"},{"name":"message","type":["null","bytes"]}]}`
+
+// sliceReader is a RecordReader over records already in memory.
+type sliceReader struct {
+	records []interface{}
+	next    interface{}
+}
+
+func (r *sliceReader) Scan() bool {
+	if len(r.records) == 0 {
+		return false
+	}
+	r.next, r.records = r.records[0], r.records[1:]
+	return true
+}
+
+func (r *sliceReader) Read() (interface{}, error) { return r.next, nil }
+func (r *sliceReader) Err() error                 { return nil }
+
+func testRecords(messages ...string) *sliceReader {
+	r := &sliceReader{}
+	for i, msg := range messages {
+		var v interface{}
+		if msg != "" {
+			v = goavro.Union("bytes", []byte(msg))
+		}
+		r.records = append(r.records, map[string]interface{}{"id": int64(i + 1), "message": v})
+	}
+	return r
+}
+
+// nthSampler keeps every other record and stops after limit.
+type nthSampler struct{ seen, limit int }
+
+func (s *nthSampler) Done() bool { return s.seen >= s.limit }
+func (s *nthSampler) Keep() bool { s.seen++; return s.seen%2 == 1 }
+
+func decodeTest(t *testing.T, opts DecoderOptions, records RecordReader) (Stats, string, []string) {
+	t.Helper()
+	schema, err := ParseSchema(messageSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Converter == nil {
+		if opts.Converter, err = NewJSONConverter(ConverterOptions{TimeZone: "UTC"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var logs bytes.Buffer
+	opts.Logger = slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{
+		ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+	var out bytes.Buffer
+	stats, err := NewDecoder(opts).DecodeRecords(records, schema, NewNDJSONSink(&out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stats, out.String(), strings.Split(strings.TrimSuffix(logs.String(), "\n"), "\n")
+}
+
+func TestDecoderField(t *testing.T) {
+	stats, out, warnings := decodeTest(t, DecoderOptions{Field: "message"}, testRecords(`{"a":1}`, "", "not json"))
+	if want := "{\"a\":1}\n\"not json\"\n"; out != want {
+		t.Fatalf("wrote %q, want %q", out, want)
+	}
+	// Warnings give the position of the record in the input
+	want := []string{
+		`level=WARN msg="Skipping record whose field is null" record=2 field=message`,
+		`level=WARN msg="Message is not valid JSON, saving as raw bytes" record=3 field=message`,
+	}
+	if !reflect.DeepEqual(stats, Stats{Read: 3, Messages: 2, Skipped: 1, InvalidJSON: 1, Failures: map[string]int{"Skipping record whose field is null": 1}}) || strings.Join(warnings, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("decoded %+v with warnings %q", stats, warnings)
+	}
+}
+
+func TestDecoderFilterSampleTransform(t *testing.T) {
+	opts := DecoderOptions{
+		// Records are filtered as JSON, after conversion
+		Filter: func(record interface{}) bool {
+			return record.(map[string]interface{})["id"] != json.Number("2") && record.(map[string]interface{})["id"] != int64(2)
+		},
+		Sampler: &nthSampler{limit: 3},
+		Transform: func(msg json.RawMessage) ([]json.RawMessage, error) {
+			if strings.Contains(string(msg), `"id":5`) {
+				return nil, nil
+			}
+			return []json.RawMessage{msg, json.RawMessage(`"copy"`)}, nil
+		},
+	}
+	stats, out, _ := decodeTest(t, opts, testRecords("1", "2", "3", "4", "5", "6", "7"))
+	// Record 2 is filtered out; the sampler keeps 1 and 4 of 1, 3 and 4, and
+	// stops there
+	want := "{\"id\":1,\"message\":\"1\"}\n\"copy\"\n{\"id\":4,\"message\":\"4\"}\n\"copy\"\n"
+	if out != want || !reflect.DeepEqual(stats, Stats{Read: 4, Messages: 4, Filtered: 2}) {
+		t.Fatalf("decoded %+v as %q, want %q", stats, out, want)
+	}
+}
+
+// nativeSink records what a NativeSink is given.
+type nativeSink struct {
+	NDJSONSink
+	schema  *Schema
+	records []interface{}
+}
+
+func (w *nativeSink) SetSchema(schema *Schema) error { w.schema = schema; return nil }
+func (w *nativeSink) WriteNative(record interface{}) error {
+	w.records = append(w.records, record)
+	return nil
+}
+
+func TestDecoderNativeSink(t *testing.T) {
+	converter, err := NewJSONConverter(ConverterOptions{TimeZone: "UTC"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, err := ParseSchema(messageSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	w := &nativeSink{NDJSONSink: *NewNDJSONSink(&out)}
+	if _, err := NewDecoder(DecoderOptions{Converter: converter}).DecodeRecords(testRecords("1", "2"), schema, w); err != nil {
+		t.Fatal(err)
+	}
+	if w.schema != schema || len(w.records) != 2 || out.Len() != 0 {
+		t.Fatalf("native sink got schema %v, %d records and %q", w.schema, len(w.records), out.String())
+	}
+
+	// Selected fields are JSON, which the schema doesn't describe
+	w = &nativeSink{NDJSONSink: *NewNDJSONSink(&out)}
+	if _, err := NewDecoder(DecoderOptions{Field: "id", Converter: converter}).DecodeRecords(testRecords("1", "2"), schema, w); err != nil {
+		t.Fatal(err)
+	}
+	if w.schema != nil || len(w.records) != 0 || out.String() != "1\n2\n" {
+		t.Fatalf("native sink got schema %v, %d records and %q", w.schema, len(w.records), out.String())
+	}
+}
+
+func TestDecode(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := goavro.NewOCFWriter(goavro.OCFConfig{W: &buf, Schema: messageSchema})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Append([]interface{}{map[string]interface{}{"id": int64(1), "message": nil}}); err != nil {
+		t.Fatal(err)
+	}
+	converter, err := NewJSONConverter(ConverterOptions{TimeZone: "UTC"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	stats, err := NewDecoder(DecoderOptions{Converter: converter}).Decode(&buf, NewNDJSONSink(&out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "{\"id\":1,\"message\":null}\n"; stats.Messages != 1 || out.String() != want {
+		t.Fatalf("decoded %+v as %q, want %q", stats, out.String(), want)
+	}
+	if _, err := NewDecoder(DecoderOptions{Converter: converter}).Decode(strings.NewReader("not avro"), NewNDJSONSink(&out)); err == nil {
+		t.Fatal("decoded a stream that isn't a container file")
+	}
+}
+
+func TestDecoderOnError(t *testing.T) {
+	var failed []string
+	opts := DecoderOptions{Field: "message", StrictJSON: true, OnError: func(e *RecordError) error {
+		failed = append(failed, e.Error())
+		return nil
+	}}
+	stats, out, _ := decodeTest(t, opts, testRecords(`{"a":1}`, "", "not json", `{"b":2}`))
+	want := []string{
+		"record 2: Skipping record whose field is null",
+		"record 3: Skipping message that is not valid JSON: invalid character 'o' in literal null (expecting 'u')",
+	}
+	if out != "{\"a\":1}\n{\"b\":2}\n" || !reflect.DeepEqual(stats, Stats{Read: 4, Messages: 2, Skipped: 1, InvalidJSON: 1, Failures: map[string]int{
+		"Skipping record whose field is null":     1,
+		"Skipping message that is not valid JSON": 1,
+	}}) || strings.Join(failed, "|") != strings.Join(want, "|") {
+		t.Fatalf("decoded %+v as %q, failing %q", stats, out, failed)
+	}
+
+	// An error from OnError stops decoding
+	stop := errors.New("stop")
+	opts.OnError = func(*RecordError) error { return stop }
+	schema, err := ParseSchema(messageSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Converter, err = NewJSONConverter(ConverterOptions{TimeZone: "UTC"}); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	stats, err = NewDecoder(opts).DecodeRecords(testRecords(`{"a":1}`, "", `{"b":2}`), schema, NewNDJSONSink(&buf))
+	if err != stop || stats.Messages != 1 {
+		t.Fatalf("decoded %+v: %v", stats, err)
+	}
+}
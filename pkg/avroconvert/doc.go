@@ -0,0 +1,16 @@
+// Package avroconvert converts Avro records to JSON.
+//
+// A Decoder reads records from a container file or any RecordReader,
+// a Converter turns their goavro native values into plain JSON values, and
+// a Sink serializes the resulting records:
+//
+//	converter, err := avroconvert.NewJSONConverter(avroconvert.ConverterOptions{TimeZone: "UTC"})
+//	if err != nil {
+//		return err
+//	}
+//	sink := avroconvert.NewNDJSONSink(os.Stdout)
+//	stats, err := avroconvert.NewDecoder(avroconvert.DecoderOptions{Converter: converter}).Decode(f, sink)
+//	if err == nil {
+//		err = sink.Close()
+//	}
+package avroconvert
@@ -0,0 +1,161 @@
+package avroconvert
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+)
+
+// appendJSON appends v to dst as json.Marshal would write it. The values a
+// JSONConverter makes are written without reflection or allocations beyond
+// sorting map keys; anything else is handed to json.Marshal.
+func appendJSON(dst []byte, v interface{}, keys *[]string) ([]byte, error) {
+	switch t := v.(type) {
+	case nil:
+		return append(dst, "null"...), nil
+	case string:
+		return appendJSONString(dst, t), nil
+	case bool:
+		return strconv.AppendBool(dst, t), nil
+	case int:
+		return strconv.AppendInt(dst, int64(t), 10), nil
+	case int32:
+		return strconv.AppendInt(dst, int64(t), 10), nil
+	case int64:
+		return strconv.AppendInt(dst, t, 10), nil
+	case uint32:
+		return strconv.AppendUint(dst, uint64(t), 10), nil
+	case float64:
+		if math.IsNaN(t) || math.IsInf(t, 0) {
+			break
+		}
+		return appendJSONFloat(dst, t), nil
+
+	case map[string]interface{}:
+		if t == nil {
+			return append(dst, "null"...), nil
+		}
+		// Keys are sorted in a slice shared by all the maps of a record,
+		// each map using the part after those of the maps it is within
+		start := len(*keys)
+		for k := range t {
+			*keys = append(*keys, k)
+		}
+		sort.Strings((*keys)[start:])
+		dst = append(dst, '{')
+		for i := start; i < len(*keys); i++ {
+			k := (*keys)[i]
+			if i > start {
+				dst = append(dst, ',')
+			}
+			dst = appendJSONString(dst, k)
+			dst = append(dst, ':')
+			var err error
+			if dst, err = appendJSON(dst, t[k], keys); err != nil {
+				return dst, err
+			}
+		}
+		*keys = (*keys)[:start]
+		return append(dst, '}'), nil
+
+	case []interface{}:
+		if t == nil {
+			return append(dst, "null"...), nil
+		}
+		dst = append(dst, '[')
+		for i, item := range t {
+			if i > 0 {
+				dst = append(dst, ',')
+			}
+			var err error
+			if dst, err = appendJSON(dst, item, keys); err != nil {
+				return dst, err
+			}
+		}
+		return append(dst, ']'), nil
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return dst, err
+	}
+	return append(dst, data...), nil
+}
+
+// appendJSONFloat formats a finite float64 as encoding/json does: like
+// strconv, but in exponent form only for very small and very large values,
+// with at least two exponent digits.
+func appendJSONFloat(dst []byte, f float64) []byte {
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+	dst = strconv.AppendFloat(dst, f, format, -1, 64)
+	if format == 'e' {
+		// Clean up e-09 to e-9
+		n := len(dst)
+		if n >= 4 && dst[n-4] == 'e' && dst[n-3] == '-' && dst[n-2] == '0' {
+			dst[n-2] = dst[n-1]
+			dst = dst[:n-1]
+		}
+	}
+	return dst
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendJSONString quotes s as encoding/json does, escaping HTML
+// characters, replacing invalid UTF-8 and escaping the line and paragraph
+// separators JavaScript doesn't allow in strings.
+func appendJSONString(dst []byte, s string) []byte {
+	dst = append(dst, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if b := s[i]; b < utf8.RuneSelf {
+			if b >= ' ' && b != '"' && b != '\\' && b != '<' && b != '>' && b != '&' {
+				i++
+				continue
+			}
+			dst = append(dst, s[start:i]...)
+			switch b {
+			case '"', '\\':
+				dst = append(dst, '\\', b)
+			case '\b':
+				dst = append(dst, '\\', 'b')
+			case '\f':
+				dst = append(dst, '\\', 'f')
+			case '\n':
+				dst = append(dst, '\\', 'n')
+			case '\r':
+				dst = append(dst, '\\', 'r')
+			case '\t':
+				dst = append(dst, '\\', 't')
+			default:
+				dst = append(dst, '\\', 'u', '0', '0', hexDigits[b>>4], hexDigits[b&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			dst = append(dst, s[start:i]...)
+			dst = append(dst, "\uFFFD"...)
+			i += size
+			start = i
+			continue
+		}
+		if r == '\u2028' || r == '\u2029' {
+			dst = append(dst, s[start:i]...)
+			dst = append(dst, '\\', 'u', '2', '0', '2', hexDigits[r&0xF])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	dst = append(dst, s[start:]...)
+	return append(dst, '"')
+}
@@ -0,0 +1,40 @@
+package avroconvert
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestAppendJSON(t *testing.T) {
+	values := []interface{}{
+		nil, true, false, 0, int32(-7), int64(math.MaxInt64), uint32(math.MaxUint32),
+		0.0, -0.0, 1.5, 1e20, 1e21, 1e-6, 1e-7, -123456.789e-12, math.MaxFloat64, math.SmallestNonzeroFloat64,
+		"", "plain", "quote \" and \\ backslash", "<a href='x'>&</a>", "tab\tnew\nline\r\x00\x1f\b\f",
+		"café \U0001F3AE", "bad \xff utf-8", "line para ",
+		[]interface{}{}, []interface{}(nil), map[string]interface{}(nil),
+		map[string]interface{}{"b": 1, "a": []interface{}{map[string]interface{}{"z": nil, "y": "x"}}, "c": map[string]interface{}{}},
+		json.Number("12.50"), []string{"not", "generic"},
+	}
+	var keys []string
+	for _, v := range values {
+		want, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := appendJSON([]byte("prefix"), v, &keys)
+		if err != nil {
+			t.Fatalf("%#v: %v", v, err)
+		}
+		if string(got) != "prefix"+string(want) {
+			t.Errorf("%#v: appended %s, want %s", v, got[len("prefix"):], want)
+		}
+		if len(keys) != 0 {
+			t.Fatalf("%#v: left keys %q", v, keys)
+		}
+	}
+
+	if _, err := appendJSON(nil, math.Inf(1), &keys); err == nil {
+		t.Error("appended an infinite float")
+	}
+}
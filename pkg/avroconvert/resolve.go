@@ -0,0 +1,520 @@
+package avroconvert
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// resolveFunc converts a goavro native value decoded with the writer schema
+// into the native value the reader schema describes.
+type resolveFunc func(v interface{}) (interface{}, error)
+
+// Resolver implements Avro schema resolution on top of goavro, which
+// only decodes with the writer schema. Resolution functions are built once
+// per file, so incompatible schemas are reported before any record is read.
+type Resolver struct {
+	resolve resolveFunc
+	built   map[[2]*Schema]*resolveFunc
+}
+
+// NewResolver checks that data written with writer can be read with
+// reader and prepares the conversion between them.
+func NewResolver(writer, reader *Schema) (*Resolver, error) {
+	r := &Resolver{built: make(map[[2]*Schema]*resolveFunc)}
+	resolve, err := r.build(writer, reader)
+	if err != nil {
+		return nil, err
+	}
+	r.resolve = resolve
+	return r, nil
+}
+
+// Resolve converts a record decoded with the writer schema into the one the
+// reader schema describes.
+func (r *Resolver) Resolve(v interface{}) (interface{}, error) {
+	return r.resolve(v)
+}
+
+func (r *Resolver) build(writer, reader *Schema) (resolveFunc, error) {
+	// Recursive named types refer back to a resolution still being built
+	key := [2]*Schema{writer, reader}
+	if fn, ok := r.built[key]; ok {
+		return func(v interface{}) (interface{}, error) { return (*fn)(v) }, nil
+	}
+
+	switch {
+	case writer.Kind == "union":
+		return r.writerUnion(writer, reader)
+	case reader.Kind == "union":
+		return r.readerUnion(writer, reader)
+	}
+
+	switch reader.Kind {
+	case "record":
+		if writer.Kind != "record" || !namesMatch(writer, reader) {
+			break
+		}
+		fn := new(resolveFunc)
+		r.built[key] = fn
+		resolve, err := r.record(writer, reader)
+		if err != nil {
+			return nil, err
+		}
+		*fn = resolve
+		return resolve, nil
+
+	case "enum":
+		if writer.Kind != "enum" || !namesMatch(writer, reader) {
+			break
+		}
+		return enumResolver(reader), nil
+
+	case "fixed":
+		if writer.Kind != "fixed" || !namesMatch(writer, reader) {
+			break
+		}
+		if writer.Size != reader.Size {
+			return nil, fmt.Errorf("fixed %s has size %d in the writer schema and %d in the reader schema", reader.Name, writer.Size, reader.Size)
+		}
+		return identity, nil
+
+	case "array":
+		if writer.Kind != "array" {
+			break
+		}
+		items, err := r.build(writer.Items, reader.Items)
+		if err != nil {
+			return nil, fmt.Errorf("array items: %w", err)
+		}
+		return func(v interface{}) (interface{}, error) {
+			in, _ := v.([]interface{})
+			out := make([]interface{}, len(in))
+			for i, item := range in {
+				var err error
+				if out[i], err = items(item); err != nil {
+					return nil, err
+				}
+			}
+			return out, nil
+		}, nil
+
+	case "map":
+		if writer.Kind != "map" {
+			break
+		}
+		values, err := r.build(writer.Values, reader.Values)
+		if err != nil {
+			return nil, fmt.Errorf("map values: %w", err)
+		}
+		return func(v interface{}) (interface{}, error) {
+			in, _ := v.(map[string]interface{})
+			out := make(map[string]interface{}, len(in))
+			for k, value := range in {
+				var err error
+				if out[k], err = values(value); err != nil {
+					return nil, err
+				}
+			}
+			return out, nil
+		}, nil
+
+	default:
+		if resolve := promotion(writer.Kind, reader.Kind); resolve != nil {
+			return resolve, nil
+		}
+	}
+
+	return nil, fmt.Errorf("cannot read %s as %s", describeSchema(writer), describeSchema(reader))
+}
+
+// writerUnion resolves the branch each value was written with. Branches the
+// reader cannot represent only fail for records that actually use them.
+func (r *Resolver) writerUnion(writer, reader *Schema) (resolveFunc, error) {
+	branches := make(map[*Schema]resolveFunc, len(writer.Branches))
+	var firstErr error
+	for _, branch := range writer.Branches {
+		resolve, err := r.build(branch, reader)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		branches[branch] = resolve
+	}
+	if len(branches) == 0 {
+		return nil, firstErr
+	}
+
+	return func(v interface{}) (interface{}, error) {
+		branch, value := UnwrapUnion(writer, v)
+		resolve, ok := branches[branch]
+		if !ok {
+			return nil, fmt.Errorf("union value of type %s cannot be read with the reader schema", describeValue(v))
+		}
+		return resolve(value)
+	}, nil
+}
+
+// readerUnion reads a non-union value as the first reader branch that
+// matches it exactly, or else the first one it can be promoted to.
+func (r *Resolver) readerUnion(writer, reader *Schema) (resolveFunc, error) {
+	var target *Schema
+	for _, branch := range reader.Branches {
+		if branch.Kind == writer.Kind && (!branch.IsNamed() || namesMatch(writer, branch)) {
+			target = branch
+			break
+		}
+	}
+	if target == nil {
+		for _, branch := range reader.Branches {
+			if promotion(writer.Kind, branch.Kind) != nil {
+				target = branch
+				break
+			}
+		}
+	}
+	if target == nil {
+		return nil, fmt.Errorf("cannot read %s as any branch of the reader union", describeSchema(writer))
+	}
+
+	resolve, err := r.build(writer, target)
+	if err != nil {
+		return nil, err
+	}
+	return func(v interface{}) (interface{}, error) {
+		value, err := resolve(v)
+		if err != nil || target.Kind == "null" {
+			return nil, err
+		}
+		return map[string]interface{}{UnionBranchName(target): value}, nil
+	}, nil
+}
+
+// record matches reader fields to writer fields by name or alias. Writer
+// fields the reader doesn't declare are dropped; reader fields the writer
+// doesn't have take their default.
+func (r *Resolver) record(writer, reader *Schema) (resolveFunc, error) {
+	type fieldResolution struct {
+		name    string
+		source  string // writer field name, empty when the default is used
+		resolve resolveFunc
+		def     interface{}
+	}
+
+	fields := make([]fieldResolution, len(reader.Fields))
+	for i, rf := range reader.Fields {
+		fields[i].name = rf.Name
+		wf := writer.Field(rf.Name)
+		for _, alias := range rf.Aliases {
+			if wf == nil {
+				wf = writer.Field(alias)
+			}
+		}
+
+		if wf == nil {
+			if !rf.HasDefault {
+				return nil, fmt.Errorf("record %s: field %q is not in the writer schema and has no default", reader.Name, rf.Name)
+			}
+			def, err := nativeDefault(rf.Schema, rf.Default)
+			if err != nil {
+				return nil, fmt.Errorf("record %s field %q default: %w", reader.Name, rf.Name, err)
+			}
+			fields[i].def = def
+			continue
+		}
+
+		resolve, err := r.build(wf.Schema, rf.Schema)
+		if err != nil {
+			return nil, fmt.Errorf("record %s field %q: %w", reader.Name, rf.Name, err)
+		}
+		fields[i].source, fields[i].resolve = wf.Name, resolve
+	}
+
+	return func(v interface{}) (interface{}, error) {
+		in, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("record %s is a %T", reader.Name, v)
+		}
+		out := make(map[string]interface{}, len(fields))
+		for _, f := range fields {
+			if f.resolve == nil {
+				out[f.name] = f.def
+				continue
+			}
+			value, err := f.resolve(in[f.source])
+			if err != nil {
+				return nil, err
+			}
+			out[f.name] = value
+		}
+		return out, nil
+	}, nil
+}
+
+// enumResolver checks symbols against the reader's symbols, falling back to
+// the reader's default symbol.
+func enumResolver(reader *Schema) resolveFunc {
+	symbols := make(map[string]bool, len(reader.Symbols))
+	for _, symbol := range reader.Symbols {
+		symbols[symbol] = true
+	}
+	return func(v interface{}) (interface{}, error) {
+		symbol, _ := v.(string)
+		if symbols[symbol] {
+			return symbol, nil
+		}
+		if reader.EnumDefault != "" {
+			return reader.EnumDefault, nil
+		}
+		return nil, fmt.Errorf("enum %s has no symbol %q", reader.Name, symbol)
+	}
+}
+
+func identity(v interface{}) (interface{}, error) {
+	return v, nil
+}
+
+// promotion returns the conversion for primitive types the Avro
+// specification allows the writer type to be promoted from, or nil.
+func promotion(writer, reader string) resolveFunc {
+	if writer == reader && primitiveTypes[writer] {
+		return identity
+	}
+
+	switch writer + ">" + reader {
+	case "int>long":
+		return func(v interface{}) (interface{}, error) {
+			if n, ok := v.(int32); ok {
+				return int64(n), nil
+			}
+			return v, nil
+		}
+	case "int>float", "long>float":
+		return func(v interface{}) (interface{}, error) {
+			switch n := v.(type) {
+			case int32:
+				return float32(n), nil
+			case int64:
+				return float32(n), nil
+			}
+			return v, nil
+		}
+	case "int>double", "long>double", "float>double":
+		return func(v interface{}) (interface{}, error) {
+			switch n := v.(type) {
+			case int32:
+				return float64(n), nil
+			case int64:
+				return float64(n), nil
+			case float32:
+				return float64(n), nil
+			}
+			return v, nil
+		}
+	case "string>bytes":
+		return func(v interface{}) (interface{}, error) {
+			if s, ok := v.(string); ok {
+				return []byte(s), nil
+			}
+			return v, nil
+		}
+	case "bytes>string":
+		return func(v interface{}) (interface{}, error) {
+			if b, ok := v.([]byte); ok {
+				return string(b), nil
+			}
+			return v, nil
+		}
+	}
+	return nil
+}
+
+// namesMatch reports whether named types match by unqualified name, or the
+// reader lists the writer's name among its aliases.
+func namesMatch(writer, reader *Schema) bool {
+	if shortName(writer.Name) == shortName(reader.Name) {
+		return true
+	}
+	for _, alias := range reader.Aliases {
+		if alias == writer.Name || shortName(alias) == shortName(writer.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+func shortName(name string) string {
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// describeSchema names a schema type for error messages.
+func describeSchema(s *Schema) string {
+	if s.IsNamed() {
+		return s.Kind + " " + s.Name
+	}
+	return s.Kind
+}
+
+// describeValue names the union branch of a goavro union value.
+func describeValue(v interface{}) string {
+	if m, ok := v.(map[string]interface{}); ok {
+		for key := range m {
+			return key
+		}
+	}
+	if v == nil {
+		return "null"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// nativeDefault converts a field default from its JSON form in the schema to
+// the goavro native value decoding would have produced.
+func nativeDefault(s *Schema, def interface{}) (interface{}, error) {
+	mismatch := func() (interface{}, error) {
+		return nil, fmt.Errorf("%s default has invalid value %v", describeSchema(s), def)
+	}
+
+	switch s.Kind {
+	case "union":
+		// Union defaults apply to the first branch
+		if len(s.Branches) == 0 {
+			return mismatch()
+		}
+		branch := s.Branches[0]
+		value, err := nativeDefault(branch, def)
+		if err != nil || branch.Kind == "null" {
+			return nil, err
+		}
+		return map[string]interface{}{UnionBranchName(branch): value}, nil
+
+	case "null":
+		if def != nil {
+			return mismatch()
+		}
+		return nil, nil
+
+	case "boolean":
+		if b, ok := def.(bool); ok {
+			return b, nil
+		}
+
+	case "int", "long":
+		f, ok := def.(float64)
+		if !ok {
+			break
+		}
+		n := int64(f)
+		switch s.LogicalType {
+		case "date":
+			return time.Unix(n*86400, 0).UTC(), nil
+		case "time-millis":
+			return time.Duration(n) * time.Millisecond, nil
+		case "time-micros":
+			return time.Duration(n) * time.Microsecond, nil
+		case "timestamp-millis":
+			return time.UnixMilli(n).UTC(), nil
+		case "timestamp-micros":
+			return time.UnixMicro(n).UTC(), nil
+		}
+		if s.Kind == "int" {
+			return int32(n), nil
+		}
+		return n, nil
+
+	case "float", "double":
+		f, ok := def.(float64)
+		if !ok {
+			break
+		}
+		if s.Kind == "float" {
+			return float32(f), nil
+		}
+		return f, nil
+
+	case "string", "enum":
+		if text, ok := def.(string); ok {
+			return text, nil
+		}
+
+	case "bytes", "fixed":
+		// Byte defaults are strings with one code point per byte
+		text, ok := def.(string)
+		if !ok {
+			break
+		}
+		b := make([]byte, 0, len(text))
+		for _, r := range text {
+			b = append(b, byte(r))
+		}
+		if s.Kind == "bytes" && s.LogicalType == "decimal" {
+			return decimalRat(b, s.Scale), nil
+		}
+		return b, nil
+
+	case "array":
+		items, ok := def.([]interface{})
+		if !ok {
+			break
+		}
+		out := make([]interface{}, len(items))
+		for i, item := range items {
+			value, err := nativeDefault(s.Items, item)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = value
+		}
+		return out, nil
+
+	case "map":
+		m, ok := def.(map[string]interface{})
+		if !ok {
+			break
+		}
+		out := make(map[string]interface{}, len(m))
+		for k, item := range m {
+			value, err := nativeDefault(s.Values, item)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = value
+		}
+		return out, nil
+
+	case "record":
+		m, ok := def.(map[string]interface{})
+		if !ok {
+			break
+		}
+		out := make(map[string]interface{}, len(s.Fields))
+		for _, f := range s.Fields {
+			fieldDef, ok := m[f.Name]
+			if !ok {
+				if !f.HasDefault {
+					return nil, fmt.Errorf("record %s default has no value for field %q", s.Name, f.Name)
+				}
+				fieldDef = f.Default
+			}
+			value, err := nativeDefault(f.Schema, fieldDef)
+			if err != nil {
+				return nil, err
+			}
+			out[f.Name] = value
+		}
+		return out, nil
+	}
+	return mismatch()
+}
+
+// decimalRat decodes a big-endian two's complement unscaled decimal.
+func decimalRat(b []byte, scale int) *big.Rat {
+	unscaled := new(big.Int).SetBytes(b)
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		unscaled.Sub(unscaled, new(big.Int).Lsh(big.NewInt(1), uint(8*len(b))))
+	}
+	return new(big.Rat).SetFrac(unscaled, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil))
+}
@@ -0,0 +1,72 @@
+package avroconvert
+
+import (
+	"reflect"
+	"testing"
+)
+
+const scoreWriterSchema = `{
+  "type": "record", "name": "Score", "namespace": "game",
+  "fields": [
+    {"name": "id", "type": "int"},
+    {"name": "points", "type": "float"},
+    {"name": "level", "type": {"type": "enum", "name": "Level", "symbols": ["EASY", "HARD", "NIGHTMARE"]}},
+    {"name": "player", "type": "string"},
+    {"name": "debug", "type": "string"}
+  ]
+}`
+
+func TestResolve(t *testing.T) {
+	writer, err := ParseSchema(scoreWriterSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := ParseSchema(`{
+	  "type": "record", "name": "Score", "namespace": "game",
+	  "fields": [
+	    {"name": "id", "type": "long"},
+	    {"name": "points", "type": "double"},
+	    {"name": "level", "type": {"type": "enum", "name": "Level", "symbols": ["EASY", "HARD", "OTHER"], "default": "OTHER"}},
+	    {"name": "user", "aliases": ["player"], "type": ["null", "string"]},
+	    {"name": "region", "type": "string", "default": "eu"}
+	  ]
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewResolver(writer, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := r.Resolve(map[string]interface{}{"id": int32(7), "points": float32(1.5), "level": "NIGHTMARE", "player": "ana", "debug": "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"id": int64(7), "points": 1.5, "level": "OTHER", "user": map[string]interface{}{"string": "ana"}, "region": "eu"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("resolved %#v, want %#v", got, want)
+	}
+}
+
+func TestResolverIncompatible(t *testing.T) {
+	writer, err := ParseSchema(scoreWriterSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, spec := range []string{
+		// float cannot be narrowed to int
+		`{"type":"record","name":"Score","namespace":"game","fields":[{"name":"points","type":"int"}]}`,
+		// a new field without a default cannot be filled
+		`{"type":"record","name":"Score","namespace":"game","fields":[{"name":"region","type":"string"}]}`,
+		// record names must match
+		`{"type":"record","name":"Other","fields":[]}`,
+	} {
+		reader, err := ParseSchema(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewResolver(writer, reader); err == nil {
+			t.Errorf("resolved %s against the writer schema", spec)
+		}
+	}
+}
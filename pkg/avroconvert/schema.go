@@ -0,0 +1,217 @@
+package avroconvert
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Schema is a parsed Avro schema node. Named types (records, enums and
+// fixed) are shared between every place they are referenced, so recursive
+// schemas form cycles.
+type Schema struct {
+	Kind        string // primitive type name, or record, enum, array, map, fixed, union
+	Name        string // full name of record, enum and fixed types
+	Aliases     []string
+	Doc         string
+	LogicalType string
+	Precision   int
+	Scale       int
+	Size        int
+	Symbols     []string
+	EnumDefault string // symbol readers use for unknown symbols, if set
+	Fields      []*Field
+	Items       *Schema
+	Values      *Schema
+	Branches    []*Schema
+}
+
+// Field is a single field of a record schema.
+type Field struct {
+	Name       string
+	Aliases    []string
+	Doc        string
+	Schema     *Schema
+	Default    interface{}
+	HasDefault bool
+}
+
+var primitiveTypes = map[string]bool{
+	"null": true, "boolean": true, "int": true, "long": true,
+	"float": true, "double": true, "bytes": true, "string": true,
+}
+
+// ParseSchema parses an Avro schema from its JSON specification.
+func ParseSchema(spec string) (*Schema, error) {
+	var raw interface{}
+	if err := json.Unmarshal([]byte(spec), &raw); err != nil {
+		return nil, fmt.Errorf("cannot parse schema JSON: %w", err)
+	}
+	p := schemaParser{named: make(map[string]*Schema)}
+	return p.parse(raw, "")
+}
+
+// Field returns the record field with the given name, or nil.
+func (s *Schema) Field(name string) *Field {
+	for _, f := range s.Fields {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// IsNamed reports whether the schema defines a named type.
+func (s *Schema) IsNamed() bool {
+	return s.Kind == "record" || s.Kind == "enum" || s.Kind == "fixed"
+}
+
+type schemaParser struct {
+	named map[string]*Schema
+}
+
+func (p *schemaParser) parse(raw interface{}, namespace string) (*Schema, error) {
+	switch v := raw.(type) {
+	case string:
+		if primitiveTypes[v] {
+			return &Schema{Kind: v}, nil
+		}
+		if s, ok := p.named[qualifyName(v, namespace)]; ok {
+			return s, nil
+		}
+		if s, ok := p.named[v]; ok {
+			return s, nil
+		}
+		return nil, fmt.Errorf("unknown type name: %q", v)
+
+	case []interface{}:
+		union := &Schema{Kind: "union"}
+		for i, branch := range v {
+			s, err := p.parse(branch, namespace)
+			if err != nil {
+				return nil, fmt.Errorf("union branch %d: %w", i+1, err)
+			}
+			union.Branches = append(union.Branches, s)
+		}
+		return union, nil
+
+	case map[string]interface{}:
+		return p.parseComplex(v, namespace)
+
+	default:
+		return nil, fmt.Errorf("schema ought to be a string, array or object; received: %T", raw)
+	}
+}
+
+func (p *schemaParser) parseComplex(m map[string]interface{}, namespace string) (*Schema, error) {
+	kind, ok := m["type"].(string)
+	if !ok {
+		// {"type": {...}} wraps another schema
+		return p.parse(m["type"], namespace)
+	}
+
+	s := &Schema{Kind: kind}
+	s.Doc, _ = m["doc"].(string)
+	s.LogicalType, _ = m["logicalType"].(string)
+	s.Precision = jsonInt(m["precision"])
+	s.Scale = jsonInt(m["scale"])
+
+	switch kind {
+	case "record", "error", "enum", "fixed":
+		if kind == "error" {
+			s.Kind = "record"
+		}
+		name, _ := m["name"].(string)
+		if name == "" {
+			return nil, fmt.Errorf("%s ought to have a name", kind)
+		}
+		if ns, ok := m["namespace"].(string); ok && !strings.Contains(name, ".") {
+			namespace = ns
+		}
+		s.Name = qualifyName(name, namespace)
+		if i := strings.LastIndex(s.Name, "."); i >= 0 {
+			namespace = s.Name[:i]
+		} else {
+			namespace = ""
+		}
+		for _, alias := range jsonStrings(m["aliases"]) {
+			s.Aliases = append(s.Aliases, qualifyName(alias, namespace))
+		}
+		// Register before descending so fields can refer back to the record
+		p.named[s.Name] = s
+
+	case "array":
+		items, err := p.parse(m["items"], namespace)
+		if err != nil {
+			return nil, fmt.Errorf("array items: %w", err)
+		}
+		s.Items = items
+		return s, nil
+
+	case "map":
+		values, err := p.parse(m["values"], namespace)
+		if err != nil {
+			return nil, fmt.Errorf("map values: %w", err)
+		}
+		s.Values = values
+		return s, nil
+
+	default:
+		if !primitiveTypes[kind] {
+			// A reference to a named type written as {"type": "Name"}
+			return p.parse(kind, namespace)
+		}
+		return s, nil
+	}
+
+	switch s.Kind {
+	case "enum":
+		s.Symbols = jsonStrings(m["symbols"])
+		s.EnumDefault, _ = m["default"].(string)
+	case "fixed":
+		s.Size = jsonInt(m["size"])
+	case "record":
+		rawFields, _ := m["fields"].([]interface{})
+		for _, rawField := range rawFields {
+			fm, ok := rawField.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("record %s: field ought to be an object", s.Name)
+			}
+			f := &Field{Aliases: jsonStrings(fm["aliases"])}
+			f.Name, _ = fm["name"].(string)
+			f.Doc, _ = fm["doc"].(string)
+			f.Default, f.HasDefault = fm["default"]
+			fieldSchema, err := p.parse(fm["type"], namespace)
+			if err != nil {
+				return nil, fmt.Errorf("record %s field %q: %w", s.Name, f.Name, err)
+			}
+			f.Schema = fieldSchema
+			s.Fields = append(s.Fields, f)
+		}
+	}
+	return s, nil
+}
+
+// qualifyName prefixes a short name with the enclosing namespace.
+func qualifyName(name, namespace string) string {
+	if strings.Contains(name, ".") || namespace == "" {
+		return name
+	}
+	return namespace + "." + name
+}
+
+func jsonInt(v interface{}) int {
+	f, _ := v.(float64)
+	return int(f)
+}
+
+func jsonStrings(v interface{}) []string {
+	items, _ := v.([]interface{})
+	var out []string
+	for _, item := range items {
+		if s, ok := item.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
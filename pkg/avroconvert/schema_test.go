@@ -0,0 +1,34 @@
+package avroconvert
+
+import "testing"
+
+const eventSchema = `{
+  "type": "record", "name": "Event", "namespace": "game",
+  "fields": [
+    {"name": "id", "type": "long"},
+    {"name": "kind", "type": {"type": "enum", "name": "Kind", "symbols": ["START", "END"]}},
+    {"name": "user", "type": ["null", "string"]},
+    {"name": "score", "type": "double"},
+    {"name": "raw", "type": "bytes"},
+    {"name": "tags", "type": {"type": "array", "items": "string"}},
+    {"name": "props", "type": {"type": "map", "values": ["null", "long"]}},
+    {"name": "next", "type": ["null", "Event"]}
+  ]
+}`
+
+func TestParseSchemaNames(t *testing.T) {
+	s, err := ParseSchema(eventSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Name != "game.Event" || s.Field("kind").Schema.Name != "game.Kind" {
+		t.Fatalf("parsed names %q, %q", s.Name, s.Field("kind").Schema.Name)
+	}
+	// The recursive reference is the record itself
+	if next := s.Field("next").Schema.Branches[1]; next != s {
+		t.Fatal("the recursive reference is a different schema")
+	}
+	if _, err := ParseSchema(`{"type":"record","name":"R","fields":[{"name":"a","type":"Missing"}]}`); err == nil {
+		t.Fatal("parsed a reference to an unknown type")
+	}
+}
@@ -0,0 +1,176 @@
+package avroconvert
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+)
+
+// Sink receives decoded records one at a time and serializes them to the
+// underlying output. Flush pushes out whatever the sink has buffered, e.g.
+// before offsets are committed; Close finishes the output.
+type Sink interface {
+	WriteRecord(record json.RawMessage) error
+	Flush() error
+	Close() error
+}
+
+// NativeSink is implemented by sinks that consume whole goavro native
+// records instead of JSON, so they can keep Avro type information. They are
+// used when records are converted as a whole rather than extracted with
+// DecoderOptions.Field.
+type NativeSink interface {
+	Sink
+	SetSchema(schema *Schema) error
+	WriteNative(record interface{}) error
+}
+
+// flushWriter flushes w if it buffers, as bufio.Writer does.
+func flushWriter(w io.Writer) error {
+	if f, ok := w.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// JSONArraySink streams records as the elements of a single JSON array,
+// formatted as json.MarshalIndent (or json.Marshal) would format the whole
+// array.
+type JSONArraySink struct {
+	w      io.Writer
+	pretty bool
+	count  int
+	buf    bytes.Buffer
+	// compact and escaped hold the record compacted and HTML-escaped,
+	// reused for every record
+	compact, escaped bytes.Buffer
+}
+
+func NewJSONArraySink(w io.Writer, pretty bool) *JSONArraySink {
+	return &JSONArraySink{w: w, pretty: pretty}
+}
+
+func (js *JSONArraySink) WriteRecord(record json.RawMessage) error {
+	// The record is validated, compacted and HTML-escaped as encoding/json
+	// does for array elements
+	js.compact.Reset()
+	if err := json.Compact(&js.compact, record); err != nil {
+		return err
+	}
+	js.escaped.Reset()
+	json.HTMLEscape(&js.escaped, js.compact.Bytes())
+	data := js.escaped.Bytes()
+
+	js.buf.Reset()
+	switch {
+	case js.count == 0 && js.pretty:
+		js.buf.WriteString("[\n  ")
+	case js.count == 0:
+		js.buf.WriteByte('[')
+	case js.pretty:
+		js.buf.WriteString(",\n  ")
+	default:
+		js.buf.WriteByte(',')
+	}
+	if js.pretty {
+		if err := json.Indent(&js.buf, data, "  ", "  "); err != nil {
+			return err
+		}
+	} else {
+		js.buf.Write(data)
+	}
+	js.count++
+
+	_, err := js.w.Write(js.buf.Bytes())
+	return err
+}
+
+// Flush writes out what has been buffered below the sink. The array is
+// only complete once the sink is closed.
+func (js *JSONArraySink) Flush() error {
+	return flushWriter(js.w)
+}
+
+func (js *JSONArraySink) Close() error {
+	var err error
+	switch {
+	case js.count == 0:
+		// An empty input has always been written as null
+		_, err = io.WriteString(js.w, "null")
+	case js.pretty:
+		_, err = io.WriteString(js.w, "\n]")
+	default:
+		_, err = io.WriteString(js.w, "]")
+	}
+	if err != nil {
+		return err
+	}
+	return js.Flush()
+}
+
+// NDJSONSink streams each record as a single compact JSON line, so memory
+// use stays flat regardless of the input size.
+type NDJSONSink struct {
+	w   io.Writer
+	buf bytes.Buffer
+}
+
+func NewNDJSONSink(w io.Writer) *NDJSONSink {
+	return &NDJSONSink{w: w}
+}
+
+func (ns *NDJSONSink) WriteRecord(record json.RawMessage) error {
+	ns.buf.Reset()
+	if err := json.Compact(&ns.buf, record); err != nil {
+		return err
+	}
+	ns.buf.WriteByte('\n')
+	_, err := ns.w.Write(ns.buf.Bytes())
+	return err
+}
+
+func (ns *NDJSONSink) Flush() error {
+	return flushWriter(ns.w)
+}
+
+func (ns *NDJSONSink) Close() error {
+	return ns.Flush()
+}
+
+// StdoutSink writes the output of another sink to standard output through
+// a buffer, which Flush and Close empty. Closing it leaves stdout open.
+type StdoutSink struct {
+	sink Sink
+	buf  *bufio.Writer
+}
+
+// NewStdoutSink creates the wrapped sink with newSink, on top of buffered
+// stdout.
+func NewStdoutSink(newSink func(w io.Writer) (Sink, error)) (*StdoutSink, error) {
+	buf := bufio.NewWriter(os.Stdout)
+	sink, err := newSink(buf)
+	if err != nil {
+		return nil, err
+	}
+	return &StdoutSink{sink: sink, buf: buf}, nil
+}
+
+func (ss *StdoutSink) WriteRecord(record json.RawMessage) error {
+	return ss.sink.WriteRecord(record)
+}
+
+func (ss *StdoutSink) Flush() error {
+	if err := ss.sink.Flush(); err != nil {
+		return err
+	}
+	return ss.buf.Flush()
+}
+
+func (ss *StdoutSink) Close() error {
+	if err := ss.sink.Close(); err != nil {
+		return err
+	}
+	return ss.buf.Flush()
+}
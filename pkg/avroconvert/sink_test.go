@@ -0,0 +1,115 @@
+package avroconvert
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+var testMessages = []json.RawMessage{
+	json.RawMessage(`{"id": 1, "name": "a"}`),
+	json.RawMessage("{\n  \"id\": 2,\n  \"tags\": [1, 2]\n}"),
+	json.RawMessage(`"not an object"`),
+}
+
+func TestNDJSONSink(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewNDJSONSink(&buf)
+	for _, msg := range testMessages {
+		if err := w.WriteRecord(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := "{\"id\":1,\"name\":\"a\"}\n{\"id\":2,\"tags\":[1,2]}\n\"not an object\"\n"
+	if buf.String() != want {
+		t.Fatalf("wrote %q, want %q", buf.String(), want)
+	}
+}
+
+func TestJSONArraySink(t *testing.T) {
+	for _, pretty := range []bool{false, true} {
+		var buf bytes.Buffer
+		w := NewJSONArraySink(&buf, pretty)
+		for _, msg := range testMessages {
+			if err := w.WriteRecord(msg); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		var got []interface{}
+		if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+			t.Fatalf("pretty %v: %v", pretty, err)
+		}
+		if len(got) != len(testMessages) || got[2] != "not an object" {
+			t.Fatalf("pretty %v: wrote %s", pretty, buf.Bytes())
+		}
+		if multiline := bytes.Contains(buf.Bytes(), []byte("\n")); multiline != pretty {
+			t.Fatalf("pretty %v: wrote %s", pretty, buf.Bytes())
+		}
+	}
+}
+
+// TestJSONArraySinkStreams checks each record is written as soon as it is
+// received, formatted as if the whole array had been marshalled at once.
+func TestJSONArraySinkStreams(t *testing.T) {
+	for _, pretty := range []bool{false, true} {
+		var buf bytes.Buffer
+		w := NewJSONArraySink(&buf, pretty)
+		written := 0
+		for _, msg := range testMessages {
+			if err := w.WriteRecord(msg); err != nil {
+				t.Fatal(err)
+			}
+			if buf.Len() <= written {
+				t.Fatalf("pretty %v: record %s was held back", pretty, msg)
+			}
+			written = buf.Len()
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		var want []byte
+		var err error
+		if pretty {
+			want, err = json.MarshalIndent(testMessages, "", "  ")
+		} else {
+			want, err = json.Marshal(testMessages)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), want) {
+			t.Fatalf("pretty %v: wrote %s, want %s", pretty, buf.Bytes(), want)
+		}
+	}
+}
+
+// TestSinkFlush checks Flush pushes records through a buffered writer
+// before the sink is closed.
+func TestSinkFlush(t *testing.T) {
+	for name, newSink := range map[string]func(w *bufio.Writer) Sink{
+		"ndjson": func(w *bufio.Writer) Sink { return NewNDJSONSink(w) },
+		"array":  func(w *bufio.Writer) Sink { return NewJSONArraySink(w, false) },
+	} {
+		var buf bytes.Buffer
+		s := newSink(bufio.NewWriter(&buf))
+		if err := s.WriteRecord(testMessages[0]); err != nil {
+			t.Fatal(err)
+		}
+		if buf.Len() != 0 {
+			t.Fatalf("%s: wrote %q before flushing", name, buf.String())
+		}
+		if err := s.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(buf.Bytes(), []byte(`"name":"a"`)) {
+			t.Fatalf("%s: flushed %q", name, buf.String())
+		}
+	}
+}
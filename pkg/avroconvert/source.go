@@ -0,0 +1,360 @@
+package avroconvert
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Source is where an input's bytes come from.
+type Source interface {
+	// Name identifies the input in messages.
+	Name() string
+	Open() (io.ReadCloser, error)
+}
+
+// FileSource reads a local file.
+type FileSource string
+
+func (fs FileSource) Name() string { return string(fs) }
+
+func (fs FileSource) Open() (io.ReadCloser, error) {
+	f, err := os.Open(string(fs))
+	if err != nil {
+		return nil, fmt.Errorf("cannot open input: %w", err)
+	}
+	return f, nil
+}
+
+// StdinSource reads standard input. Closing what it opens leaves stdin
+// open.
+type StdinSource struct{}
+
+func (StdinSource) Name() string { return "stdin" }
+
+func (StdinSource) Open() (io.ReadCloser, error) {
+	return io.NopCloser(os.Stdin), nil
+}
+
+// HTTPSource downloads an http:// or https:// URL with a GET request. A
+// download broken off midway is resumed with a range request from where it
+// stopped; network errors, 429 and 5xx responses are retried with
+// exponential backoff.
+type HTTPSource struct {
+	URL    string
+	Client *http.Client // http.DefaultClient if nil
+	Header http.Header  // sent with every request, e.g. Authorization
+	// Retries is how often a request is sent again after a failure, waiting
+	// Backoff (1s if zero) before the first retry and twice as long before
+	// each next one. Every byte read restores the full count.
+	Retries int
+	Backoff time.Duration
+	// Logger, if set, receives a warning for every retry.
+	Logger *slog.Logger
+}
+
+// Name returns the URL without its query and credentials, which for signed
+// URLs hold the signature.
+func (hs HTTPSource) Name() string {
+	u, err := url.Parse(hs.URL)
+	if err != nil {
+		return hs.URL
+	}
+	u.User = nil
+	if u.RawQuery != "" {
+		u.RawQuery = "..."
+	}
+	return u.String()
+}
+
+func (hs HTTPSource) Open() (io.ReadCloser, error) {
+	d := &httpDownload{source: hs}
+	if err := d.request(); err != nil {
+		return nil, fmt.Errorf("cannot open %s: %w", hs.Name(), err)
+	}
+	return d, nil
+}
+
+// httpStatusError is an error response to a download request.
+type httpStatusError struct {
+	status     int
+	message    string
+	retryAfter time.Duration // from a Retry-After header, if any
+}
+
+func (e *httpStatusError) Error() string {
+	return "server returned " + e.message
+}
+
+// httpDownload is the body of an HTTPSource, which requests the rest of the
+// file again when the connection breaks.
+type httpDownload struct {
+	source    HTTPSource
+	body      io.ReadCloser
+	offset    int64  // bytes read so far
+	validator string // ETag or Last-Modified of the first response
+	failures  int    // requests failed since the last byte read
+}
+
+func (d *httpDownload) Read(p []byte) (int, error) {
+	for {
+		n, err := d.body.Read(p)
+		d.offset += int64(n)
+		if n > 0 {
+			d.failures = 0
+		}
+		if err == nil || errors.Is(err, io.EOF) {
+			return n, err
+		}
+		d.body.Close()
+		if d.failures == d.source.Retries {
+			return n, fmt.Errorf("cannot read %s: %w", d.source.Name(), err)
+		}
+		d.failures++
+		d.warn("Resuming broken download", err)
+		if err := d.request(); err != nil {
+			return n, fmt.Errorf("cannot resume %s: %w", d.source.Name(), err)
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+func (d *httpDownload) Close() error {
+	return d.body.Close()
+}
+
+// request starts the download, or resumes it at offset, retrying failures.
+// A Retry-After header overrides the wait.
+func (d *httpDownload) request() error {
+	wait := d.source.Backoff
+	if wait == 0 {
+		wait = time.Second
+	}
+	for {
+		err := d.send()
+		if err == nil {
+			return nil
+		}
+		var statusErr *httpStatusError
+		transient := !errors.As(err, &statusErr) || statusErr.status == http.StatusTooManyRequests || statusErr.status >= 500
+		if d.failures >= d.source.Retries || !transient {
+			return err
+		}
+		d.failures++
+		d.warn("Retrying download", err)
+		delay := wait
+		if statusErr != nil && statusErr.retryAfter > 0 {
+			delay = statusErr.retryAfter
+		}
+		time.Sleep(delay)
+		wait *= 2
+	}
+}
+
+// send requests the file from offset on. A file that changed since the
+// download started cannot be resumed.
+func (d *httpDownload) send() error {
+	req, err := http.NewRequest(http.MethodGet, d.source.URL, nil)
+	if err != nil {
+		return err
+	}
+	for name, values := range d.source.Header {
+		req.Header[name] = values
+	}
+	if d.offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", d.offset))
+		if d.validator != "" {
+			req.Header.Set("If-Range", d.validator)
+		}
+	}
+
+	client := d.source.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		// Errors of the client repeat the URL, signature and all
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return err
+	}
+	validator := resp.Header.Get("ETag")
+	if validator == "" {
+		validator = resp.Header.Get("Last-Modified")
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusOK && d.offset == 0:
+		d.validator = validator
+	case resp.StatusCode == http.StatusPartialContent && d.offset > 0:
+		if !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", d.offset)) {
+			resp.Body.Close()
+			return fmt.Errorf("server resumed at the wrong offset: Content-Range %q", resp.Header.Get("Content-Range"))
+		}
+	case resp.StatusCode == http.StatusOK:
+		// The server sent the whole file, as servers without range support
+		// do, or because it changed
+		if validator != d.validator {
+			resp.Body.Close()
+			return errors.New("the file changed while it was downloaded")
+		}
+		if _, err := io.CopyN(io.Discard, resp.Body, d.offset); err != nil {
+			resp.Body.Close()
+			return err
+		}
+	default:
+		defer resp.Body.Close()
+		statusErr := &httpStatusError{status: resp.StatusCode, message: resp.Status}
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			statusErr.retryAfter = time.Duration(seconds) * time.Second
+		}
+		return statusErr
+	}
+	d.body = resp.Body
+	return nil
+}
+
+// warn logs a retry.
+func (d *httpDownload) warn(msg string, err error) {
+	if d.source.Logger != nil {
+		d.source.Logger.Warn(msg, "input", d.source.Name(), "offset", d.offset, "attempt", d.failures, "error", err)
+	}
+}
+
+// Format is the kind of data an input holds.
+type Format int
+
+const (
+	FormatUnknown   Format = iota
+	FormatAvro             // Avro object container file
+	FormatNDJSON           // one JSON object per line
+	FormatJSONArray        // a JSON array of objects
+)
+
+func (f Format) String() string {
+	switch f {
+	case FormatAvro:
+		return "Avro"
+	case FormatNDJSON:
+		return "NDJSON"
+	case FormatJSONArray:
+		return "JSON array"
+	}
+	return "unknown"
+}
+
+// avroMagic starts every Avro object container file.
+var avroMagic = []byte("Obj\x01")
+
+// SniffFormat tells the format of an input from its first bytes, without
+// consuming them. Leading whitespace and a UTF-8 byte order mark are
+// skipped when looking for JSON. Empty input is reported as NDJSON, which
+// holds no records.
+func SniffFormat(br *bufio.Reader) (Format, error) {
+	for n := len(avroMagic); ; n++ {
+		head, err := br.Peek(n)
+		if len(head) >= len(avroMagic) && bytes.Equal(head[:len(avroMagic)], avroMagic) {
+			return FormatAvro, nil
+		}
+		text := bytes.TrimLeft(bytes.TrimPrefix(head, []byte("\xef\xbb\xbf")), " \t\r\n")
+		if len(text) > 0 {
+			switch text[0] {
+			case '[':
+				return FormatJSONArray, nil
+			case '{':
+				return FormatNDJSON, nil
+			}
+			return FormatUnknown, nil
+		}
+		if errors.Is(err, io.EOF) {
+			return FormatNDJSON, nil
+		}
+		if err != nil {
+			return FormatUnknown, err
+		}
+	}
+}
+
+// jsonRecordReader reads records from NDJSON or a JSON array. Numbers are
+// kept as json.Number, so they are written out exactly as they were read.
+type jsonRecordReader struct {
+	dec    *json.Decoder
+	array  bool
+	record interface{}
+	// readErr is reported by Read for the current record; err ends the
+	// input, as JSON cannot be resynchronized after a syntax error
+	readErr error
+	err     error
+	done    bool
+}
+
+// NewJSONRecordReader returns a RecordReader over the NDJSON or JSON array
+// records of r. Its records have no schema, so they are decoded with a nil
+// one.
+func NewJSONRecordReader(r io.Reader) (RecordReader, error) {
+	br := bufio.NewReader(r)
+	format, err := SniffFormat(br)
+	if err != nil {
+		return nil, err
+	}
+	if format != FormatNDJSON && format != FormatJSONArray {
+		return nil, fmt.Errorf("input is %s, not NDJSON or a JSON array", format)
+	}
+	if bom, _ := br.Peek(3); bytes.Equal(bom, []byte("\xef\xbb\xbf")) {
+		br.Discard(3)
+	}
+	jr := &jsonRecordReader{dec: json.NewDecoder(br), array: format == FormatJSONArray}
+	jr.dec.UseNumber()
+	if jr.array {
+		if _, err := jr.dec.Token(); err != nil {
+			return nil, err
+		}
+	}
+	return jr, nil
+}
+
+func (jr *jsonRecordReader) Scan() bool {
+	if jr.done {
+		return false
+	}
+	if jr.array && !jr.dec.More() {
+		jr.done = true
+		return false
+	}
+	var v interface{}
+	if err := jr.dec.Decode(&v); err != nil {
+		jr.done = true
+		if !errors.Is(err, io.EOF) || jr.array {
+			jr.err = err
+		}
+		return false
+	}
+	jr.record, jr.readErr = v, nil
+	if _, ok := v.(map[string]interface{}); !ok {
+		jr.readErr = errors.New("record is not a JSON object")
+	}
+	return true
+}
+
+func (jr *jsonRecordReader) Read() (interface{}, error) {
+	return jr.record, jr.readErr
+}
+
+func (jr *jsonRecordReader) Err() error {
+	return jr.err
+}
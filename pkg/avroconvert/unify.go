@@ -0,0 +1,265 @@
+package avroconvert
+
+import (
+	"fmt"
+	"slices"
+)
+
+// UnifySchemas returns a schema that data written with any of schemas can
+// be read as, for converting files whose schema evolved over time to the
+// same output. Records get the fields of every schema, in the order they
+// first appear; fields some schemas lack become nullable with a null
+// default. Enums get all their symbols, numbers are promoted to the widest
+// type, string and bytes are read as string, and types that cannot be
+// promoted to one another become a union of both. Only fixed types of
+// different sizes cannot be unified.
+func UnifySchemas(schemas ...*Schema) (*Schema, error) {
+	if len(schemas) == 0 {
+		return nil, fmt.Errorf("no schemas to unify")
+	}
+	u := &unifier{copies: make(map[*Schema]*Schema), merging: make(map[[2]*Schema]bool)}
+	unified := u.copy(schemas[0])
+	for _, s := range schemas[1:] {
+		var err error
+		if unified, err = u.merge(unified, s); err != nil {
+			return nil, err
+		}
+	}
+	return unified, nil
+}
+
+// numericRank orders the numeric types by the promotions Avro allows.
+var numericRank = map[string]int{"int": 1, "long": 2, "float": 3, "double": 4}
+
+type unifier struct {
+	copies  map[*Schema]*Schema // of the schemas merged in, so they are left as they are
+	merging map[[2]*Schema]bool // named types being merged, for recursive schemas
+}
+
+// copy returns a deep copy of s, which the unifier may change.
+func (u *unifier) copy(s *Schema) *Schema {
+	if c, ok := u.copies[s]; ok {
+		return c
+	}
+	c := *s
+	u.copies[s] = &c
+	c.Aliases = slices.Clone(s.Aliases)
+	c.Symbols = slices.Clone(s.Symbols)
+	if s.Items != nil {
+		c.Items = u.copy(s.Items)
+	}
+	if s.Values != nil {
+		c.Values = u.copy(s.Values)
+	}
+	c.Branches = nil
+	for _, b := range s.Branches {
+		c.Branches = append(c.Branches, u.copy(b))
+	}
+	c.Fields = nil
+	for _, f := range s.Fields {
+		cf := *f
+		cf.Aliases = slices.Clone(f.Aliases)
+		cf.Schema = u.copy(f.Schema)
+		c.Fields = append(c.Fields, &cf)
+	}
+	return &c
+}
+
+// merge widens a, which belongs to the unifier, so data written with s can
+// be read as it too, and returns it.
+func (u *unifier) merge(a, s *Schema) (*Schema, error) {
+	if a.Kind == "union" || s.Kind == "union" {
+		return u.mergeUnion(a, s)
+	}
+	if a.Kind != s.Kind {
+		switch {
+		case numericRank[a.Kind] > 0 && numericRank[s.Kind] > 0:
+			if numericRank[s.Kind] > numericRank[a.Kind] {
+				a.Kind = s.Kind
+			}
+			a.LogicalType = ""
+			return a, nil
+		case (a.Kind == "string" || a.Kind == "bytes") && (s.Kind == "string" || s.Kind == "bytes"):
+			a.Kind, a.LogicalType = "string", ""
+			return a, nil
+		}
+		return &Schema{Kind: "union", Branches: []*Schema{a, u.copy(s)}}, nil
+	}
+
+	key := [2]*Schema{a, s}
+	if u.merging[key] {
+		return a, nil
+	}
+	u.merging[key] = true
+
+	switch a.Kind {
+	case "record":
+		if a.Name != s.Name && !namesMatch(s, a) {
+			a.Aliases = append(a.Aliases, s.Name)
+		}
+		return a, u.mergeFields(a, s)
+	case "enum":
+		if a.Name != s.Name && !namesMatch(s, a) {
+			a.Aliases = append(a.Aliases, s.Name)
+		}
+		for _, symbol := range s.Symbols {
+			if !slices.Contains(a.Symbols, symbol) {
+				a.Symbols = append(a.Symbols, symbol)
+			}
+		}
+		if a.EnumDefault == "" {
+			a.EnumDefault = s.EnumDefault
+		}
+		return a, nil
+	case "fixed":
+		if a.Size != s.Size {
+			return nil, fmt.Errorf("fixed %s has size %d in one schema and %d in another", a.Name, a.Size, s.Size)
+		}
+		return a, nil
+	case "array":
+		items, err := u.merge(a.Items, s.Items)
+		if err != nil {
+			return nil, fmt.Errorf("array items: %w", err)
+		}
+		a.Items = items
+		return a, nil
+	case "map":
+		values, err := u.merge(a.Values, s.Values)
+		if err != nil {
+			return nil, fmt.Errorf("map values: %w", err)
+		}
+		a.Values = values
+		return a, nil
+	}
+	if a.LogicalType != s.LogicalType || a.Precision != s.Precision || a.Scale != s.Scale {
+		// Values are written as the plain type when the files disagree
+		a.LogicalType, a.Precision, a.Scale = "", 0, 0
+	}
+	return a, nil
+}
+
+// mergeFields adds the fields of s to the record a, matched by name or
+// alias, and makes the fields only one of them has nullable.
+func (u *unifier) mergeFields(a, s *Schema) error {
+	matched := make(map[*Field]bool, len(a.Fields))
+	for _, sf := range s.Fields {
+		af := a.Field(sf.Name)
+		for _, alias := range sf.Aliases {
+			if af == nil {
+				af = a.Field(alias)
+			}
+		}
+		if af == nil {
+			for _, f := range a.Fields {
+				if slices.Contains(f.Aliases, sf.Name) {
+					af = f
+				}
+			}
+		}
+		if af == nil {
+			nf := &Field{Name: sf.Name, Aliases: slices.Clone(sf.Aliases), Doc: sf.Doc, Schema: u.copy(sf.Schema), Default: sf.Default, HasDefault: sf.HasDefault}
+			makeOptional(nf)
+			a.Fields = append(a.Fields, nf)
+			matched[nf] = true
+			continue
+		}
+		first := firstKind(af.Schema)
+		merged, err := u.merge(af.Schema, sf.Schema)
+		if err != nil {
+			return fmt.Errorf("record %s field %q: %w", a.Name, af.Name, err)
+		}
+		af.Schema = merged
+		if af.HasDefault && firstKind(merged) != first {
+			// A default has to be of the type, or the first branch of the
+			// union, the field had
+			af.Default, af.HasDefault = nil, false
+		}
+		matched[af] = true
+	}
+	for _, f := range a.Fields {
+		if !matched[f] {
+			makeOptional(f)
+		}
+	}
+	return nil
+}
+
+// mergeUnion merges the branches of s into those of a, matching named types
+// by name and other types by kind, or by the promotions between them.
+func (u *unifier) mergeUnion(a, s *Schema) (*Schema, error) {
+	branches := []*Schema{a}
+	if a.Kind == "union" {
+		branches = a.Branches
+	}
+	others := []*Schema{s}
+	if s.Kind == "union" {
+		others = s.Branches
+	}
+	for _, other := range others {
+		i := slices.IndexFunc(branches, func(b *Schema) bool { return unionMatch(b, other) })
+		if i < 0 {
+			branches = append(branches, u.copy(other))
+			continue
+		}
+		merged, err := u.merge(branches[i], other)
+		if err != nil {
+			return nil, err
+		}
+		branches[i] = merged
+	}
+	if len(branches) == 1 {
+		return branches[0], nil
+	}
+	if a.Kind != "union" {
+		a = &Schema{Kind: "union"}
+	}
+	a.Branches = branches
+	return a, nil
+}
+
+// unionMatch reports whether the union branch b holds values of type s.
+func unionMatch(b, s *Schema) bool {
+	if b.IsNamed() || s.IsNamed() {
+		return b.Kind == s.Kind && namesMatch(s, b)
+	}
+	if b.Kind == s.Kind {
+		return true
+	}
+	if numericRank[b.Kind] > 0 && numericRank[s.Kind] > 0 {
+		return true
+	}
+	return (b.Kind == "string" || b.Kind == "bytes") && (s.Kind == "string" || s.Kind == "bytes")
+}
+
+// firstKind returns the kind of a schema, or of the first branch of a union,
+// which a field default has to be of.
+func firstKind(s *Schema) string {
+	if s.Kind == "union" && len(s.Branches) > 0 {
+		return s.Branches[0].Kind
+	}
+	return s.Kind
+}
+
+// makeOptional lets a field be missing from the data, reading it as its
+// default or else null.
+func makeOptional(f *Field) {
+	if f.HasDefault {
+		return
+	}
+	s := f.Schema
+	switch {
+	case s.Kind == "null":
+	case s.Kind == "union":
+		i := slices.IndexFunc(s.Branches, func(b *Schema) bool { return b.Kind == "null" })
+		if i < 0 {
+			s.Branches = append([]*Schema{{Kind: "null"}}, s.Branches...)
+		} else {
+			// The null default has to match the first branch
+			null := s.Branches[i]
+			s.Branches = append([]*Schema{null}, slices.Delete(s.Branches, i, i+1)...)
+		}
+	default:
+		f.Schema = &Schema{Kind: "union", Branches: []*Schema{{Kind: "null"}, s}}
+	}
+	f.Default, f.HasDefault = nil, true
+}
@@ -0,0 +1,101 @@
+package avroconvert
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUnifySchemas(t *testing.T) {
+	v1, err := ParseSchema(`{"type":"record","name":"Score","fields":[
+	  {"name":"id","type":"int"},
+	  {"name":"level","type":{"type":"enum","name":"Level","symbols":["EASY","HARD"]}},
+	  {"name":"player","type":"string"},
+	  {"name":"tag","type":"int"}
+	]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2, err := ParseSchema(`{"type":"record","name":"Score","fields":[
+	  {"name":"id","type":"long"},
+	  {"name":"level","type":{"type":"enum","name":"Level","symbols":["EASY","NIGHTMARE"]}},
+	  {"name":"player","type":"bytes"},
+	  {"name":"tag","type":"string"},
+	  {"name":"region","type":"string"}
+	]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unified, err := UnifySchemas(v1, v2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kinds := func(s *Schema) []string {
+		var kinds []string
+		for _, b := range append([]*Schema{s}, s.Branches...) {
+			kinds = append(kinds, b.Kind)
+		}
+		return kinds
+	}
+	want := map[string][]string{
+		"id":     {"long"},
+		"level":  {"enum"},
+		"player": {"string"},
+		"tag":    {"union", "int", "string"},
+		"region": {"union", "null", "string"},
+	}
+	var names []string
+	for _, f := range unified.Fields {
+		names = append(names, f.Name)
+		if got := kinds(f.Schema); !reflect.DeepEqual(got, want[f.Name]) {
+			t.Errorf("field %s is %v, want %v", f.Name, got, want[f.Name])
+		}
+	}
+	if got := strings.Join(names, ","); got != "id,level,player,tag,region" {
+		t.Errorf("fields %s", got)
+	}
+	if symbols := unified.Field("level").Schema.Symbols; !reflect.DeepEqual(symbols, []string{"EASY", "HARD", "NIGHTMARE"}) {
+		t.Errorf("level symbols %v", symbols)
+	}
+	if region := unified.Field("region"); !region.HasDefault || region.Default != nil {
+		t.Errorf("region default %v, %v", region.Default, region.HasDefault)
+	}
+	if v1.Field("id").Schema.Kind != "int" || len(v1.Fields) != 4 {
+		t.Error("unifying changed the first schema")
+	}
+
+	// Data written with either schema can be read as the unified one
+	for _, writer := range []*Schema{v1, v2} {
+		if _, err := NewResolver(writer, unified); err != nil {
+			t.Errorf("cannot resolve %s: %v", writer.Name, err)
+		}
+	}
+}
+
+func TestUnifySchemasFixed(t *testing.T) {
+	a, _ := ParseSchema(`{"type":"fixed","name":"Hash","size":16}`)
+	b, _ := ParseSchema(`{"type":"fixed","name":"Hash","size":32}`)
+	if _, err := UnifySchemas(a, b); err == nil || !strings.Contains(err.Error(), "size 16") {
+		t.Fatalf("unified fixed types of different sizes: %v", err)
+	}
+	if _, err := UnifySchemas(); err == nil {
+		t.Fatal("unified no schemas")
+	}
+}
+
+func TestUnifySchemasRecursive(t *testing.T) {
+	spec := `{"type":"record","name":"Node","fields":[{"name":"value","type":"int"},{"name":"next","type":["null","Node"]}]}`
+	a, _ := ParseSchema(spec)
+	b, _ := ParseSchema(strings.Replace(spec, `"int"`, `"long"`, 1))
+	unified, err := UnifySchemas(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kind := unified.Field("value").Schema.Kind; kind != "long" {
+		t.Errorf("value is %s, want long", kind)
+	}
+	if next := unified.Field("next").Schema.Branches[1]; next != unified {
+		t.Error("next does not refer back to the unified record")
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"plugin"
+)
+
+// pluginTransform is the signature of the Transform function a -plugin
+// exports.
+type pluginTransform = func(record map[string]any) (map[string]any, error)
+
+// recordPlugin is a Go plugin loaded with -plugin. Its Transform function
+// runs on every message before it is written, so teams can enrich records
+// with their own code, e.g. mapping item IDs to names, without a fork of
+// the tool. Returning a nil map drops the record.
+type recordPlugin struct {
+	path      string
+	transform pluginTransform
+}
+
+// loadPlugin opens a plugin built with go build -buildmode=plugin and looks
+// up its Transform, a function or a variable holding one.
+func loadPlugin(path string) (*recordPlugin, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load plugin: %w", err)
+	}
+	sym, err := p.Lookup("Transform")
+	if err != nil {
+		return nil, fmt.Errorf("cannot load plugin %s: %w", path, err)
+	}
+	switch fn := sym.(type) {
+	case pluginTransform:
+		return &recordPlugin{path: path, transform: fn}, nil
+	case *pluginTransform:
+		if *fn != nil {
+			return &recordPlugin{path: path, transform: *fn}, nil
+		}
+	}
+	return nil, fmt.Errorf("plugin %s: Transform is a %T, expected a func(map[string]any) (map[string]any, error)", path, sym)
+}
+
+// apply runs the plugin on a JSON message, which must be an object. Numbers
+// are passed as json.Number, so 64-bit IDs keep their digits.
+func (rp *recordPlugin) apply(msg json.RawMessage) ([]json.RawMessage, error) {
+	v, err := parseMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+	record, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("plugin %s: message is not a JSON object", rp.path)
+	}
+	record, err = rp.transform(record)
+	if err != nil {
+		return nil, fmt.Errorf("plugin %s: %w", rp.path, err)
+	}
+	if record == nil {
+		return nil, nil
+	}
+	text, err := json.Marshal(record)
+	if err != nil {
+		return nil, fmt.Errorf("plugin %s: %w", rp.path, err)
+	}
+	return []json.RawMessage{text}, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// testPlugin returns a plugin running transform, as loadPlugin would for a
+// plugin exporting it.
+func testPlugin(transform pluginTransform) *recordPlugin {
+	return &recordPlugin{path: "enrich.so", transform: transform}
+}
+
+func TestPluginApply(t *testing.T) {
+	items := map[string]string{"42": "Sword"}
+	p := testPlugin(func(record map[string]any) (map[string]any, error) {
+		id, ok := record["item_id"].(json.Number)
+		if !ok {
+			return nil, errors.New("no item_id")
+		}
+		if id == "0" {
+			return nil, nil
+		}
+		record["item_name"] = items[id.String()]
+		return record, nil
+	})
+
+	got, err := p.apply(json.RawMessage(`{"item_id":42,"user":9007199254740993}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || string(got[0]) != `{"item_id":42,"item_name":"Sword","user":9007199254740993}` {
+		t.Fatalf("applied to %s", got)
+	}
+	if got, err := p.apply(json.RawMessage(`{"item_id":0}`)); err != nil || got != nil {
+		t.Fatalf("dropping returned %s, %v", got, err)
+	}
+	if _, err := p.apply(json.RawMessage(`{}`)); err == nil || !strings.Contains(err.Error(), "enrich.so: no item_id") {
+		t.Fatalf("plugin error returned %v", err)
+	}
+	if _, err := p.apply(json.RawMessage(`[1]`)); err == nil {
+		t.Fatal("applied the plugin to an array")
+	}
+}
+
+// TestPluginOrder checks the plugin runs before -transform.
+func TestPluginOrder(t *testing.T) {
+	transform, err := parseTransform(`{name: .item_name}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := decodeOptions{
+		plugin: testPlugin(func(record map[string]any) (map[string]any, error) {
+			record["item_name"] = "Sword"
+			return record, nil
+		}),
+		transform: transform,
+	}
+	if !opts.jsonMessages() {
+		t.Error("-plugin output is taken for whole records")
+	}
+	got, err := opts.messageTransform()(json.RawMessage(`{"item_id":42}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || string(got[0]) != `{"name":"Sword"}` {
+		t.Fatalf("transformed to %s", got)
+	}
+}
+
+func TestLoadPluginNotAPlugin(t *testing.T) {
+	path := writeTestFile(t, "enrich.so", []byte("not a shared object"))
+	if _, err := loadPlugin(path); err == nil || !strings.Contains(err.Error(), "cannot load plugin") {
+		t.Fatalf("loaded %s: %v", path, err)
+	}
+}
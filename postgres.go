@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"avroparser/pkg/avroconvert"
+	"github.com/jackc/pgx/v5"
+)
+
+// postgresSeparator joins nested field names into column names, e.g.
+// geo_country, since dots would need quoting in every query.
+const postgresSeparator = "_"
+
+func isPostgresURL(p string) bool {
+	return strings.HasPrefix(p, "postgres://") || strings.HasPrefix(p, "postgresql://")
+}
+
+// postgresOptions holds the settings for loading into Postgres.
+type postgresOptions struct {
+	url         string
+	table       string // possibly schema-qualified, e.g. analytics.events
+	createTable bool   // create the table from the inferred column types if missing
+}
+
+// identifier quotes the table name, split at its schema.
+func (po postgresOptions) identifier() pgx.Identifier {
+	return pgx.Identifier(strings.Split(po.table, "."))
+}
+
+// pgType is a Postgres column type inferred from flattened values.
+type pgType string
+
+const (
+	pgUnknown     pgType = "" // only nulls seen
+	pgBoolean     pgType = "boolean"
+	pgBigint      pgType = "bigint"
+	pgNumeric     pgType = "numeric"
+	pgTimestampTZ pgType = "timestamptz"
+	pgTimestamp   pgType = "timestamp"
+	pgDate        pgType = "date"
+	pgTime        pgType = "time"
+	pgText        pgType = "text"
+	pgJSONB       pgType = "jsonb"
+)
+
+// inferPGType returns the narrowest type that holds a flattened value.
+func inferPGType(f flatField) pgType {
+	switch v := f.value.(type) {
+	case nil:
+		return pgUnknown
+	case bool:
+		return pgBoolean
+	case json.Number:
+		if _, err := strconv.ParseInt(v.String(), 10, 64); err == nil {
+			return pgBigint
+		}
+		return pgNumeric
+	case string:
+		if f.json {
+			return pgJSONB
+		}
+		if _, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return pgTimestampTZ
+		}
+		if _, err := time.Parse(avroconvert.LocalTimestampLayout, v); err == nil {
+			return pgTimestamp
+		}
+		if _, err := time.Parse("2006-01-02", v); err == nil {
+			return pgDate
+		}
+		if _, err := time.Parse("15:04:05.999999", v); err == nil {
+			return pgTime
+		}
+	}
+	return pgText
+}
+
+// widenPGType returns a type that holds values of both a and b.
+func widenPGType(a, b pgType) pgType {
+	switch {
+	case a == b || b == pgUnknown:
+		return a
+	case a == pgUnknown:
+		return b
+	case a == pgBigint && b == pgNumeric, a == pgNumeric && b == pgBigint:
+		return pgNumeric
+	}
+	return pgText
+}
+
+// pgColumnCollector records flattened column names in first-seen order,
+// with the type of the values seen in each.
+type pgColumnCollector struct {
+	columnCollector
+	types map[string]pgType
+}
+
+func newPGColumnCollector() *pgColumnCollector {
+	return &pgColumnCollector{columnCollector: *newColumnCollector(flattener{separator: postgresSeparator}), types: make(map[string]pgType)}
+}
+
+func (pc *pgColumnCollector) WriteRecord(msg json.RawMessage) error {
+	v, err := parseMessage(msg)
+	if err != nil {
+		return err
+	}
+	for _, f := range flattenRecord(v, postgresSeparator) {
+		if !pc.seen[f.name] {
+			pc.seen[f.name] = true
+			pc.names = append(pc.names, f.name)
+			pc.types[f.name] = inferPGType(f)
+			continue
+		}
+		pc.types[f.name] = widenPGType(pc.types[f.name], inferPGType(f))
+	}
+	return nil
+}
+
+// createTableSQL returns the DDL creating a table with the collected
+// columns. Columns that only held nulls become text.
+func (pc *pgColumnCollector) createTableSQL(table pgx.Identifier) string {
+	columns := make([]string, len(pc.names))
+	for i, name := range pc.names {
+		typ := pc.types[name]
+		if typ == pgUnknown {
+			typ = pgText
+		}
+		columns[i] = pgx.Identifier{name}.Sanitize() + " " + string(typ)
+	}
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n\t%s\n)", table.Sanitize(), strings.Join(columns, ",\n\t"))
+}
+
+// loadPostgres copies the records of an input into a table. A first pass
+// finds the flattened columns and their types; the second streams the rows
+// with COPY, so a failure leaves the table as it was. Columns the table
+// doesn't have are dropped with a warning.
+func loadPostgres(in inputFile, po postgresOptions, opts decodeOptions) (avroconvert.Stats, error) {
+	path, cleanup, err := spoolInput(in.path)
+	if err != nil {
+		return avroconvert.Stats{}, err
+	}
+	defer cleanup()
+
+	collector := newPGColumnCollector()
+	stats, err := decodeFile(path, in.path, opts, collector)
+	if err != nil {
+		return stats, err
+	}
+
+	if len(collector.names) == 0 {
+		slog.Info("Copied rows into Postgres", "input", in.path, "rows", 0, "table", po.table, filteredAttr(stats))
+		return stats, nil
+	}
+
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, po.url)
+	if err != nil {
+		return stats, fmt.Errorf("cannot connect to Postgres: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	table := po.identifier()
+	if po.createTable {
+		if _, err := conn.Exec(ctx, collector.createTableSQL(table)); err != nil {
+			return stats, fmt.Errorf("cannot create table %s: %w", po.table, err)
+		}
+	}
+	existing, err := tableColumns(ctx, conn, table)
+	if err != nil {
+		return stats, err
+	}
+	if len(existing) == 0 {
+		return stats, fmt.Errorf("table %s does not exist (use -create-table to create it)", po.table)
+	}
+	var columns []string
+	for _, name := range collector.names {
+		if existing[name] {
+			columns = append(columns, name)
+		} else {
+			slog.Warn("Dropping column, which the table doesn't have", "input", in.path, "column", name, "table", po.table)
+		}
+	}
+	if len(columns) == 0 {
+		return stats, fmt.Errorf("no columns of the records match table %s", po.table)
+	}
+
+	sink := newPostgresSink(ctx, conn, table, columns)
+	quiet := opts
+	quiet.quiet = true
+	if _, err := decodeFile(path, in.path, quiet, sink); err != nil {
+		sink.abort(err)
+		return stats, err
+	}
+	if err := sink.Close(); err != nil {
+		return stats, err
+	}
+
+	slog.Info("Copied rows into Postgres", "input", in.path, "rows", sink.copied, "columns", len(columns), "table", po.table, filteredAttr(stats))
+	return stats, nil
+}
+
+// tableColumns returns the names of a table's columns, or none when it
+// doesn't exist.
+func tableColumns(ctx context.Context, conn *pgx.Conn, table pgx.Identifier) (map[string]bool, error) {
+	rows, err := conn.Query(ctx, `SELECT attname FROM pg_attribute WHERE attrelid = to_regclass($1) AND attnum > 0 AND NOT attisdropped`, table.Sanitize())
+	if err != nil {
+		return nil, fmt.Errorf("cannot read columns of %s: %w", strings.Join(table, "."), err)
+	}
+	names, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, fmt.Errorf("cannot read columns of %s: %w", strings.Join(table, "."), err)
+	}
+	columns := make(map[string]bool, len(names))
+	for _, name := range names {
+		columns[name] = true
+	}
+	return columns, nil
+}
+
+// postgresSink streams records as CSV rows into a COPY ... FROM STDIN
+// running in the background. Every non-null value is quoted, so empty
+// strings stay distinct from NULL.
+type postgresSink struct {
+	index  map[string]int
+	row    []*string
+	pw     *io.PipeWriter
+	w      *bufio.Writer
+	done   chan error
+	copied int64
+}
+
+func newPostgresSink(ctx context.Context, conn *pgx.Conn, table pgx.Identifier, columns []string) *postgresSink {
+	quoted := make([]string, len(columns))
+	index := make(map[string]int, len(columns))
+	for i, name := range columns {
+		quoted[i] = pgx.Identifier{name}.Sanitize()
+		index[name] = i
+	}
+	pr, pw := io.Pipe()
+	ps := &postgresSink{index: index, row: make([]*string, len(columns)), pw: pw, w: bufio.NewWriter(pw), done: make(chan error, 1)}
+
+	copySQL := fmt.Sprintf("COPY %s (%s) FROM STDIN WITH (FORMAT csv)", table.Sanitize(), strings.Join(quoted, ", "))
+	go func() {
+		tag, err := conn.PgConn().CopyFrom(ctx, pr, copySQL)
+		ps.copied = tag.RowsAffected()
+		// Unblock writers if the copy failed before reading everything
+		pr.CloseWithError(err)
+		ps.done <- err
+	}()
+	return ps
+}
+
+func (ps *postgresSink) WriteRecord(msg json.RawMessage) error {
+	v, err := parseMessage(msg)
+	if err != nil {
+		return err
+	}
+	for i := range ps.row {
+		ps.row[i] = nil
+	}
+	for _, f := range flattenRecord(v, postgresSeparator) {
+		if i, ok := ps.index[f.name]; ok && f.value != nil {
+			value := csvValue(f.value)
+			ps.row[i] = &value
+		}
+	}
+	for i, value := range ps.row {
+		if i > 0 {
+			ps.w.WriteByte(',')
+		}
+		if value != nil {
+			ps.w.WriteByte('"')
+			ps.w.WriteString(strings.ReplaceAll(*value, `"`, `""`))
+			ps.w.WriteByte('"')
+		}
+	}
+	return ps.w.WriteByte('\n')
+}
+
+// Flush hands buffered rows to the running COPY.
+func (ps *postgresSink) Flush() error {
+	return ps.w.Flush()
+}
+
+// abort makes the COPY fail, so none of its rows are kept.
+func (ps *postgresSink) abort(err error) {
+	ps.pw.CloseWithError(err)
+	<-ps.done
+}
+
+// Close finishes the COPY and reports whether the server accepted it.
+func (ps *postgresSink) Close() error {
+	err := ps.Flush()
+	ps.pw.CloseWithError(err)
+	if copyErr := <-ps.done; copyErr != nil {
+		return fmt.Errorf("cannot copy rows into Postgres: %w", copyErr)
+	}
+	if err != nil && !errors.Is(err, io.ErrClosedPipe) {
+		return fmt.Errorf("cannot copy rows into Postgres: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestInferPGType(t *testing.T) {
+	for _, tc := range []struct {
+		field flatField
+		want  pgType
+	}{
+		{flatField{value: nil}, pgUnknown},
+		{flatField{value: true}, pgBoolean},
+		{flatField{value: json.Number("12")}, pgBigint},
+		{flatField{value: json.Number("1.5")}, pgNumeric},
+		{flatField{value: json.Number("99999999999999999999")}, pgNumeric},
+		{flatField{value: "2026-01-02T03:04:05.5Z"}, pgTimestampTZ},
+		{flatField{value: "2026-01-02T03:04:05.5"}, pgTimestamp},
+		{flatField{value: "2026-01-02"}, pgDate},
+		{flatField{value: "01:30:01.5"}, pgTime},
+		{flatField{value: "ana"}, pgText},
+		{flatField{value: `["a"]`, json: true}, pgJSONB},
+	} {
+		if got := inferPGType(tc.field); got != tc.want {
+			t.Errorf("inferPGType(%v) = %q, want %q", tc.field.value, got, tc.want)
+		}
+	}
+}
+
+func TestWidenPGType(t *testing.T) {
+	for _, tc := range []struct{ a, b, want pgType }{
+		{pgBigint, pgBigint, pgBigint},
+		{pgUnknown, pgDate, pgDate},
+		{pgDate, pgUnknown, pgDate},
+		{pgBigint, pgNumeric, pgNumeric},
+		{pgNumeric, pgBigint, pgNumeric},
+		{pgBigint, pgBoolean, pgText},
+	} {
+		if got := widenPGType(tc.a, tc.b); got != tc.want {
+			t.Errorf("widenPGType(%q, %q) = %q, want %q", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestPGColumnCollector(t *testing.T) {
+	pc := newPGColumnCollector()
+	for _, msg := range []string{
+		`{"id": 1, "geo": {"country": "DE"}, "score": null, "tags": ["a"]}`,
+		`{"id": 2.5, "geo": {"country": "US"}, "at": "2026-01-02T03:04:05Z", "tags": []}`,
+	} {
+		if err := pc.WriteRecord(json.RawMessage(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	po := postgresOptions{table: "analytics.events"}
+	want := "CREATE TABLE IF NOT EXISTS \"analytics\".\"events\" (\n" +
+		"\t\"geo_country\" text,\n\t\"id\" numeric,\n\t\"score\" text,\n\t\"tags\" jsonb,\n\t\"at\" timestamptz\n)"
+	if got := pc.createTableSQL(po.identifier()); got != want {
+		t.Fatalf("created table with\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestIsPostgresURL(t *testing.T) {
+	for p, want := range map[string]bool{"postgres://localhost/db": true, "postgresql://u@h/db": true, "output": false, "gs://b/": false} {
+		if got := isPostgresURL(p); got != want {
+			t.Errorf("isPostgresURL(%q) = %v", p, got)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// schemaCommands are the subcommands of "schema" that turn an Avro schema
+// into other forms. Without one, schema prints the schema itself.
+var schemaCommands = map[string]func(args []string){
+	"tojsonschema": runSchemaToJSONSchema,
+	"fingerprint":  runSchemaFingerprint,
+}
+
+func runSchema(args []string) {
+	if len(args) > 0 {
+		if command, ok := schemaCommands[args[0]]; ok {
+			command(args[1:])
+			return
+		}
+	}
+	fs := flag.NewFlagSet("schema", flag.ExitOnError)
+	inputPath := fs.String("input", "", "Input Avro file, or - for stdin")
+	schemaOnly := fs.Bool("schema-only", false, "Print only the schema JSON, e.g. to save it as an .avsc file")
+	logging := addLogFlags(fs)
+	parseFlags(fs, args)
+	logging.setup()
+
+	if *inputPath == "" && fs.NArg() > 0 {
+		*inputPath = fs.Arg(0)
+	}
+
+	if *inputPath == "" {
+		fmt.Fprintf(os.Stderr, "Usage: avroparser schema -input <avro_file|-> [-schema-only]\n       avroparser schema <%s> [flags]\n", strings.Join(sortedKeys(schemaCommands), "|"))
+		os.Exit(exitFatal)
+	}
+
+	if err := printSchema(*inputPath, os.Stdout, *schemaOnly); err != nil {
+		slog.Error("Cannot read schema", "input", displayPath(*inputPath), "error", err)
+		os.Exit(exitFatal)
+	}
+}
+
+// printSchema writes the header metadata and pretty-printed writer schema of
+// an Avro file. Only the header is read, so this is instant on large files.
+func printSchema(path string, w io.Writer, schemaOnly bool) error {
+	input, err := openInput(path)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+
+	header, err := readOCFHeader(bufio.NewReader(input))
+	if err != nil {
+		return err
+	}
+
+	var schema bytes.Buffer
+	if err := json.Indent(&schema, header.schema(), "", "  "); err != nil {
+		return fmt.Errorf("cannot parse schema JSON: %w", err)
+	}
+
+	if !schemaOnly {
+		fmt.Fprintf(w, "Codec:       %s\n", header.codec())
+		fmt.Fprintf(w, "Sync marker: %x\n", header.sync)
+
+		var keys []string
+		for key := range header.metadata {
+			if key != "avro.schema" && key != "avro.codec" {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(w, "Metadata:    %s = %s\n", key, metadataValue(header.metadata[key]))
+		}
+		fmt.Fprintln(w)
+	}
+
+	schema.WriteByte('\n')
+	_, err = schema.WriteTo(w)
+	return err
+}
+
+// metadataValue renders a user metadata value, which Avro stores as bytes:
+// text is printed as-is and anything else as hex.
+func metadataValue(v []byte) string {
+	if utf8.Valid(v) {
+		return string(v)
+	}
+	return fmt.Sprintf("%x", v)
+}
+
+// readSchemaSpec returns the schema JSON of an .avsc file, or the writer
+// schema of an Avro file, told apart by the container file's magic bytes.
+func readSchemaSpec(path string) ([]byte, error) {
+	input, err := openInput(path)
+	if err != nil {
+		return nil, err
+	}
+	defer input.Close()
+
+	br := bufio.NewReader(input)
+	if magic, err := br.Peek(len(ocfMagic)); err == nil && bytes.Equal(magic, ocfMagic) {
+		header, err := readOCFHeader(br)
+		if err != nil {
+			return nil, err
+		}
+		return header.schema(), nil
+	}
+	return io.ReadAll(br)
+}
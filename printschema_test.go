@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/linkedin/goavro/v2"
+)
+
+func TestReadOCFHeader(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := goavro.NewOCFWriter(goavro.OCFConfig{
+		W: &buf, Schema: messageSchema, CompressionName: goavro.CompressionDeflateLabel,
+		MetaData: map[string][]byte{"origin": []byte("pulsar")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Append([]interface{}{map[string]interface{}{"message": []byte(`{}`)}}); err != nil {
+		t.Fatal(err)
+	}
+
+	header, err := readOCFHeader(bufio.NewReader(bytes.NewReader(buf.Bytes())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.codec() != "deflate" || string(header.metadata["origin"]) != "pulsar" || !strings.Contains(string(header.schema()), `"Export"`) {
+		t.Fatalf("read header %+v", header)
+	}
+	if !bytes.Contains(buf.Bytes()[len(ocfMagic):], header.sync[:]) {
+		t.Fatalf("sync marker %x not in file", header.sync)
+	}
+
+	if _, err := readOCFHeader(bufio.NewReader(strings.NewReader("not a container"))); err == nil {
+		t.Fatal("read a header from a file that isn't a container")
+	}
+}
+
+func TestPrintSchema(t *testing.T) {
+	path := writeTestFile(t, "export.avro", writeMessageOCF(t, `{"id":1}`))
+
+	var out bytes.Buffer
+	if err := printSchema(path, &out, true); err != nil {
+		t.Fatal(err)
+	}
+	want := "{\n  \"type\": \"record\",\n  \"name\": \"Export\",\n  \"fields\": [\n    {\n      \"name\": \"message\",\n      \"type\": \"bytes\"\n    }\n  ]\n}\n"
+	if out.String() != want {
+		t.Fatalf("printed %q, want %q", out.String(), want)
+	}
+
+	out.Reset()
+	if err := printSchema(path, &out, false); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "Codec:       null\nSync marker: ") || !strings.HasSuffix(out.String(), "\n\n"+want) {
+		t.Fatalf("printed %q", out.String())
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// recordProfile describes every field of a set of records: how often it is
+// null, roughly how many distinct values it has, the range and mean of its
+// numbers and its most frequent values. Nested fields are profiled by their
+// flattened paths, e.g. geo.country.
+type recordProfile struct {
+	records int64
+	fields  map[string]*fieldProfile
+}
+
+func newRecordProfile() *recordProfile {
+	return &recordProfile{fields: make(map[string]*fieldProfile)}
+}
+
+// add profiles the fields of a parsed message.
+func (rp *recordProfile) add(v interface{}) {
+	rp.records++
+	for _, f := range flattenRecord(v, ".") {
+		fp := rp.fields[f.name]
+		if fp == nil {
+			fp = newFieldProfile()
+			rp.fields[f.name] = fp
+		}
+		fp.add(f)
+	}
+}
+
+// merge adds the records profiled by other.
+func (rp *recordProfile) merge(other *recordProfile) {
+	rp.records += other.records
+	for name, ofp := range other.fields {
+		fp := rp.fields[name]
+		if fp == nil {
+			fp = newFieldProfile()
+			rp.fields[name] = fp
+		}
+		fp.merge(ofp)
+	}
+}
+
+// fieldProfile accumulates the values of one field.
+type fieldProfile struct {
+	values   int64            // non-null values
+	types    map[string]int64 // values by JSON type
+	distinct hyperLogLog
+	numbers  int64
+	sum      float64
+	min, max json.Number
+	minF     float64
+	maxF     float64
+	top      frequentValues
+}
+
+func newFieldProfile() *fieldProfile {
+	return &fieldProfile{types: make(map[string]int64), top: frequentValues{counts: make(map[string]int64)}}
+}
+
+func (fp *fieldProfile) add(f flatField) {
+	if f.value == nil {
+		return
+	}
+	fp.values++
+	fp.types[flatType(f)]++
+	key := countKey(f.value)
+	fp.distinct.add(key)
+	fp.top.add(key, 1)
+	if n, ok := f.value.(json.Number); ok {
+		if x, err := n.Float64(); err == nil {
+			fp.addNumber(n, x, 1, x)
+		}
+	}
+}
+
+// addNumber adds count numbers adding up to sum, the smallest and largest
+// of which is n (x as a float).
+func (fp *fieldProfile) addNumber(n json.Number, x float64, count int64, sum float64) {
+	if fp.numbers == 0 || x < fp.minF {
+		fp.min, fp.minF = n, x
+	}
+	if fp.numbers == 0 || x > fp.maxF {
+		fp.max, fp.maxF = n, x
+	}
+	fp.numbers += count
+	fp.sum += sum
+}
+
+func (fp *fieldProfile) merge(other *fieldProfile) {
+	fp.values += other.values
+	for t, n := range other.types {
+		fp.types[t] += n
+	}
+	fp.distinct.merge(&other.distinct)
+	if other.numbers > 0 {
+		fp.addNumber(other.min, other.minF, other.numbers, other.sum)
+		fp.addNumber(other.max, other.maxF, 0, 0)
+	}
+	for value, n := range other.top.counts {
+		fp.top.add(value, n)
+	}
+	fp.top.pruned = fp.top.pruned || other.top.pruned
+}
+
+// flatType names the JSON type of a flattened value.
+func flatType(f flatField) string {
+	switch v := f.value.(type) {
+	case json.Number:
+		return "number"
+	case bool:
+		return "boolean"
+	case string:
+		if f.json && strings.HasPrefix(v, "[") {
+			return "array"
+		} else if f.json {
+			return "object"
+		}
+	}
+	return "string"
+}
+
+// frequentValuesCapacity bounds the values frequentValues counts. Once a
+// field has more distinct values, the least frequent half is dropped, so
+// the counts of the top values are lower bounds.
+const frequentValuesCapacity = 10000
+
+// frequentValues counts the values of a field to find the most frequent
+// ones in bounded memory.
+type frequentValues struct {
+	counts map[string]int64
+	pruned bool // values were dropped, so the counts are approximate
+}
+
+func (fv *frequentValues) add(value string, n int64) {
+	fv.counts[value] += n
+	if len(fv.counts) <= frequentValuesCapacity {
+		return
+	}
+	kept := valueCounts(fv.counts).top(frequentValuesCapacity / 2)
+	fv.counts = make(map[string]int64, frequentValuesCapacity)
+	for _, vc := range kept {
+		fv.counts[vc.Value] = vc.Count
+	}
+	fv.pruned = true
+}
+
+// fieldProfileReport is the profile of a field as reported.
+type fieldProfileReport struct {
+	Values   int64            `json:"values"`
+	Nulls    int64            `json:"nulls"` // null or missing
+	NullRate float64          `json:"null_rate"`
+	Distinct int64            `json:"distinct_estimate"`
+	Types    map[string]int64 `json:"types"`
+	Min      json.Number      `json:"min,omitempty"` // of the numeric values
+	Max      json.Number      `json:"max,omitempty"`
+	Mean     *float64         `json:"mean,omitempty"`
+	Top      []valueCount     `json:"top"`
+	// Approximate is set when the field has too many distinct values for
+	// the counts of the top values to be exact.
+	Approximate bool `json:"top_approximate,omitempty"`
+}
+
+// report returns the profile of each field, with its n most frequent
+// values.
+func (rp *recordProfile) report(n int) map[string]fieldProfileReport {
+	report := make(map[string]fieldProfileReport, len(rp.fields))
+	for name, fp := range rp.fields {
+		r := fieldProfileReport{
+			Values:      fp.values,
+			Nulls:       rp.records - fp.values,
+			Distinct:    fp.distinct.estimate(),
+			Types:       fp.types,
+			Top:         valueCounts(fp.top.counts).top(n),
+			Approximate: fp.top.pruned,
+		}
+		if rp.records > 0 {
+			r.NullRate = float64(r.Nulls) / float64(rp.records)
+		}
+		// The estimate can be off by a little either way
+		r.Distinct = min(r.Distinct, fp.values)
+		if fp.values > 0 {
+			r.Distinct = max(r.Distinct, 1)
+		}
+		if fp.numbers > 0 {
+			mean := fp.sum / float64(fp.numbers)
+			r.Min, r.Max, r.Mean = fp.min, fp.max, &mean
+		}
+		report[name] = r
+	}
+	return report
+}
+
+// writeProfileText writes a profile as a table with a row per field, for a
+// tabwriter to align.
+func writeProfileText(w io.Writer, rp *recordProfile, n int) {
+	fmt.Fprintf(w, "  Profile of %s records:\n", formatCount(rp.records))
+	fmt.Fprintln(w, "    FIELD\tNULLS\tDISTINCT\tTYPES\tMIN\tMAX\tMEAN\tTOP VALUES")
+	report := rp.report(n)
+	for _, name := range sortedKeys(report) {
+		r := report[name]
+		minimum, maximum, mean := "-", "-", "-"
+		if r.Mean != nil {
+			minimum, maximum, mean = r.Min.String(), r.Max.String(), fmt.Sprintf("%.6g", *r.Mean)
+		}
+		// Counts of the top values are at least the ones shown when some
+		// values were dropped
+		approximate := ""
+		if r.Approximate {
+			approximate = "~"
+		}
+		top := make([]string, 0, len(r.Top))
+		for _, vc := range r.Top {
+			top = append(top, fmt.Sprintf("%s (%s%s)", vc.Value, approximate, formatCount(vc.Count)))
+		}
+		fmt.Fprintf(w, "    %s\t%.1f%%\t%s\t%s\t%s\t%s\t%s\t%s\n", name, r.NullRate*100, formatCount(r.Distinct),
+			strings.Join(sortedKeys(r.Types), ","), minimum, maximum, mean, strings.Join(top, ", "))
+	}
+}
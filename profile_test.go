@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// profileMessages profiles JSON messages.
+func profileMessages(t *testing.T, msgs ...string) *recordProfile {
+	t.Helper()
+	rp := newRecordProfile()
+	for _, msg := range msgs {
+		v, err := parseMessage(json.RawMessage(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rp.add(v)
+	}
+	return rp
+}
+
+func TestRecordProfile(t *testing.T) {
+	rp := profileMessages(t,
+		`{"level": 3, "country": "DE", "geo": {"city": "Berlin"}, "tags": ["a"]}`,
+		`{"level": 12, "country": "DE", "geo": {"city": null}}`,
+		`{"level": 1.5, "country": null}`,
+		`{"level": "high"}`,
+	)
+	report := rp.report(2)
+
+	level := report["level"]
+	if level.Values != 4 || level.Nulls != 0 || level.Distinct != 4 || !reflect.DeepEqual(level.Types, map[string]int64{"number": 3, "string": 1}) {
+		t.Fatalf("level profile %+v", level)
+	}
+	if level.Min != "1.5" || level.Max != "12" || *level.Mean != 5.5 {
+		t.Fatalf("level range %s to %s, mean %v", level.Min, level.Max, *level.Mean)
+	}
+
+	// Null and missing values count as nulls
+	country := report["country"]
+	if country.Values != 2 || country.Nulls != 2 || country.NullRate != 0.5 || country.Distinct != 1 || country.Mean != nil {
+		t.Fatalf("country profile %+v", country)
+	}
+	if !reflect.DeepEqual(country.Top, []valueCount{{"DE", 2}}) {
+		t.Fatalf("country top values %v", country.Top)
+	}
+	if city := report["geo.city"]; city.Values != 1 || city.NullRate != 0.75 {
+		t.Fatalf("geo.city profile %+v", city)
+	}
+	if tags := report["tags"]; !reflect.DeepEqual(tags.Types, map[string]int64{"array": 1}) {
+		t.Fatalf("tags profile %+v", tags)
+	}
+
+	// Merging profiles adds their records
+	rp.merge(profileMessages(t, `{"level": -1, "other": true}`))
+	report = rp.report(2)
+	if level := report["level"]; level.Values != 5 || level.Min != "-1" || *level.Mean != 3.875 {
+		t.Fatalf("merged level profile %+v", level)
+	}
+	if other := report["other"]; other.Values != 1 || other.NullRate != 0.8 || other.Types["boolean"] != 1 {
+		t.Fatalf("merged other profile %+v", other)
+	}
+
+	var text strings.Builder
+	writeProfileText(&text, rp, 1)
+	if line := "    country\t60.0%\t1\tstring\t-\t-\t-\tDE (2)\n"; !strings.Contains(text.String(), line) {
+		t.Fatalf("profile has no %q:\n%s", line, text.String())
+	}
+}
+
+func TestFrequentValues(t *testing.T) {
+	fv := frequentValues{counts: make(map[string]int64)}
+	fv.add("common", 100)
+	for i := 0; i < frequentValuesCapacity; i++ {
+		fv.add(fmt.Sprintf("rare-%d", i), 1)
+	}
+	if !fv.pruned || len(fv.counts) > frequentValuesCapacity || fv.counts["common"] != 100 {
+		t.Fatalf("pruned %v to %d values, common counted %d times", fv.pruned, len(fv.counts), fv.counts["common"])
+	}
+}
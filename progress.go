@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"avroparser/pkg/avroconvert"
+)
+
+// progressMeter reports on stderr how far a run has got, for -progress:
+// bytes read of the inputs, records per second and, when the size of every
+// input is known, the time left. On a terminal the line is redrawn every
+// second; otherwise, or with -log-format json, progress is logged every ten
+// seconds.
+type progressMeter struct {
+	total    int64 // bytes of all inputs, or 0 when unknown
+	bytes    atomic.Int64
+	records  atomic.Int64
+	started  time.Time
+	terminal bool
+	done     chan struct{}
+	stopped  chan struct{}
+}
+
+// newProgressMeter sizes up the inputs of a run. Inputs read from remote
+// stores or stdin have no known size, and neither has a watched directory
+// (nil inputs).
+func newProgressMeter(inputs []inputFile) *progressMeter {
+	pm := &progressMeter{done: make(chan struct{}), stopped: make(chan struct{})}
+	if info, err := os.Stderr.Stat(); err == nil {
+		pm.terminal = info.Mode()&os.ModeCharDevice != 0 && !jsonLogs
+	}
+	for _, in := range inputs {
+		size := inputSize(in)
+		if size < 0 {
+			pm.total = 0
+			break
+		}
+		pm.total += size
+	}
+	return pm
+}
+
+// inputSize returns how many bytes of an input are read, or -1 if unknown.
+func inputSize(in inputFile) int64 {
+	if in.blocks != nil {
+		return in.blocks.header + in.blocks.end - in.blocks.start
+	}
+	if in.path == stdioPath || isRemotePath(in.path) {
+		return -1
+	}
+	info, err := os.Stat(in.path)
+	if err != nil {
+		return -1
+	}
+	return info.Size()
+}
+
+// start begins reporting.
+func (pm *progressMeter) start() {
+	pm.started = time.Now()
+	interval := 10 * time.Second
+	if pm.terminal {
+		interval = time.Second
+	}
+	go func() {
+		defer close(pm.stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-pm.done:
+				if pm.terminal {
+					fmt.Fprint(os.Stderr, "\r\033[K")
+				}
+				return
+			case <-ticker.C:
+				if pm.terminal {
+					// Ending at the start of the line lets status messages
+					// overwrite the meter
+					fmt.Fprintf(os.Stderr, "\r\033[K%s\r", pm.line())
+				} else {
+					slog.Info("Progress", "status", pm.line())
+				}
+			}
+		}
+	}()
+}
+
+// stop ends reporting and logs the throughput of the run.
+func (pm *progressMeter) stop() {
+	close(pm.done)
+	<-pm.stopped
+	elapsed := time.Since(pm.started)
+	slog.Info("Read inputs", "bytes", formatBytes(pm.bytes.Load()), "duration", elapsed.Round(time.Millisecond), "records", pm.records.Load(),
+		"records_per_second", formatRate(float64(pm.records.Load()), elapsed), "bytes_per_second", formatBytes(int64(float64(pm.bytes.Load())/elapsed.Seconds())))
+}
+
+func (pm *progressMeter) line() string {
+	elapsed := time.Since(pm.started)
+	read := pm.bytes.Load()
+	rate := formatRate(float64(pm.records.Load()), elapsed)
+	if pm.total <= 0 {
+		return fmt.Sprintf("%s read, %d records, %s records/s", formatBytes(read), pm.records.Load(), rate)
+	}
+	eta := "?"
+	if read > 0 {
+		left := time.Duration(float64(elapsed) * float64(pm.total-read) / float64(read))
+		eta = left.Round(time.Second).String()
+	}
+	return fmt.Sprintf("%s / %s (%d%%), %s records/s, ETA %s", formatBytes(read), formatBytes(pm.total), read*100/pm.total, rate, eta)
+}
+
+// formatBytes renders a byte count with a binary unit, e.g. 1.5 GB.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	value, exp := float64(n)/unit, 0
+	for value >= unit && exp < 4 {
+		value /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", value, "KMGTP"[exp])
+}
+
+// formatRate renders a per-second rate with thousands separators.
+func formatRate(count float64, elapsed time.Duration) string {
+	if elapsed <= 0 {
+		return "0"
+	}
+	return groupDigits(fmt.Sprintf("%.0f", count/elapsed.Seconds()))
+}
+
+// formatCount renders a count with thousands separators, e.g. 1,250,000.
+func formatCount(n int64) string {
+	if n < 0 {
+		return "-" + groupDigits(strconv.FormatInt(-n, 10))
+	}
+	return groupDigits(strconv.FormatInt(n, 10))
+}
+
+// groupDigits inserts thousands separators into a string of digits.
+func groupDigits(digits string) string {
+	var b strings.Builder
+	for i, c := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// meteredReader adds the bytes read through it to a progress meter.
+type meteredReader struct {
+	io.ReadCloser
+	meter *progressMeter
+}
+
+func (mr meteredReader) Read(p []byte) (int, error) {
+	n, err := mr.ReadCloser.Read(p)
+	mr.meter.bytes.Add(int64(n))
+	return n, err
+}
+
+// openDecodeInput opens the selected blocks of an input to decode. With
+// -progress, the bytes read are counted before decompression, except on
+// the quiet second pass over an input.
+func openDecodeInput(path string, opts decodeOptions) (io.ReadCloser, error) {
+	if opts.progress == nil || opts.quiet {
+		return openInputBlocks(path, opts.blocks)
+	}
+	if opts.blocks != nil {
+		r, err := openBlocks(path, *opts.blocks)
+		if err != nil {
+			return nil, err
+		}
+		return meteredReader{r, opts.progress}, nil
+	}
+	r, err := newSource(path).Open()
+	if err != nil {
+		return nil, err
+	}
+	return decompress(meteredReader{r, opts.progress})
+}
+
+// progressSink counts the records written through it.
+type progressSink struct {
+	avroconvert.Sink
+	meter *progressMeter
+}
+
+func (ps progressSink) WriteRecord(msg json.RawMessage) error {
+	ps.meter.records.Add(1)
+	return ps.Sink.WriteRecord(msg)
+}
+
+// progressNativeSink is a progressSink for sinks taking whole records.
+type progressNativeSink struct {
+	progressSink
+	native avroconvert.NativeSink
+}
+
+func (ps progressNativeSink) SetSchema(schema *avroconvert.Schema) error {
+	return ps.native.SetSchema(schema)
+}
+
+func (ps progressNativeSink) WriteNative(record interface{}) error {
+	ps.meter.records.Add(1)
+	return ps.native.WriteNative(record)
+}
+
+// meterRecords wraps a sink to count its records for -progress.
+func meterRecords(writer avroconvert.Sink, opts decodeOptions) avroconvert.Sink {
+	if opts.progress == nil || opts.quiet {
+		return writer
+	}
+	ps := progressSink{Sink: writer, meter: opts.progress}
+	if native, ok := writer.(avroconvert.NativeSink); ok {
+		return progressNativeSink{progressSink: ps, native: native}
+	}
+	return ps
+}
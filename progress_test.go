@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"avroparser/pkg/avroconvert"
+)
+
+func TestFormatBytes(t *testing.T) {
+	for n, want := range map[int64]string{
+		0:               "0 B",
+		1023:            "1023 B",
+		1536:            "1.5 KB",
+		3 << 20:         "3.0 MB",
+		5<<30 + 512<<20: "5.5 GB",
+	} {
+		if got := formatBytes(n); got != want {
+			t.Errorf("formatBytes(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
+
+func TestFormatRate(t *testing.T) {
+	for count, want := range map[float64]string{
+		0:       "0",
+		999:     "999",
+		1000:    "1,000",
+		1234567: "1,234,567",
+	} {
+		if got := formatRate(count, time.Second); got != want {
+			t.Errorf("formatRate(%v) = %q, want %q", count, got, want)
+		}
+	}
+	if got := formatRate(10, 0); got != "0" {
+		t.Errorf("rate without elapsed time = %q", got)
+	}
+}
+
+func TestProgressMeter(t *testing.T) {
+	var inputs []inputFile
+	var total int64
+	for i := 0; i < 2; i++ {
+		data := writeMessageOCF(t, fmt.Sprintf(`{"file":%d}`, i), `{"n":2}`)
+		total += int64(len(data))
+		inputs = append(inputs, inputFile{path: writeTestFile(t, fmt.Sprintf("part-%d.avro", i), data)})
+	}
+	pm := newProgressMeter(inputs)
+	if pm.total != total {
+		t.Fatalf("sized inputs at %d bytes, want %d", pm.total, total)
+	}
+	if newProgressMeter(append(inputs, inputFile{path: stdioPath})).total != 0 {
+		t.Fatal("sized stdin")
+	}
+
+	// Decoding an input counts its bytes and records
+	pm.started = time.Now().Add(-time.Second)
+	opts := testOptions(t, "message")
+	opts.progress = pm
+	var out bytes.Buffer
+	if _, err := decodeFile(inputs[0].path, "part-0.avro", opts, avroconvert.NewNDJSONSink(&out)); err != nil {
+		t.Fatal(err)
+	}
+	if read := pm.bytes.Load(); read == 0 || read > total || pm.records.Load() != 2 {
+		t.Fatalf("counted %d bytes and %d records", read, pm.records.Load())
+	}
+	if line := pm.line(); !strings.Contains(line, "records/s, ETA ") {
+		t.Fatalf("progress line %q", line)
+	}
+
+	// The quiet second pass over an input isn't counted again
+	opts.quiet = true
+	if _, err := decodeFile(inputs[0].path, "part-0.avro", opts, avroconvert.NewNDJSONSink(&out)); err != nil {
+		t.Fatal(err)
+	}
+	if pm.records.Load() != 2 {
+		t.Fatalf("counted %d records", pm.records.Load())
+	}
+}
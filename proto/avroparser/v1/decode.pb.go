@@ -0,0 +1,271 @@
+// Decode service of `avroparser serve`: clients send Avro-encoded messages
+// and get each back as JSON, converted as `avroparser decode` converts
+// records.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: avroparser/v1/decode.proto
+
+package avroparserv1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type DecodeRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Returned as is in the response, to match responses to requests.
+	Id string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	// The Avro binary encoding of one record. Without a writer schema, the
+	// data must start with the Confluent wire format header: a zero byte and
+	// the big-endian 4-byte ID of the writer schema in the schema registry.
+	Data []byte `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	// Types that are valid to be assigned to WriterSchema:
+	//
+	//	*DecodeRequest_Schema
+	//	*DecodeRequest_SchemaId
+	WriterSchema  isDecodeRequest_WriterSchema `protobuf_oneof:"writer_schema"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DecodeRequest) Reset() {
+	*x = DecodeRequest{}
+	mi := &file_avroparser_v1_decode_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DecodeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DecodeRequest) ProtoMessage() {}
+
+func (x *DecodeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_avroparser_v1_decode_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DecodeRequest.ProtoReflect.Descriptor instead.
+func (*DecodeRequest) Descriptor() ([]byte, []int) {
+	return file_avroparser_v1_decode_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *DecodeRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *DecodeRequest) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *DecodeRequest) GetWriterSchema() isDecodeRequest_WriterSchema {
+	if x != nil {
+		return x.WriterSchema
+	}
+	return nil
+}
+
+func (x *DecodeRequest) GetSchema() string {
+	if x != nil {
+		if x, ok := x.WriterSchema.(*DecodeRequest_Schema); ok {
+			return x.Schema
+		}
+	}
+	return ""
+}
+
+func (x *DecodeRequest) GetSchemaId() int32 {
+	if x != nil {
+		if x, ok := x.WriterSchema.(*DecodeRequest_SchemaId); ok {
+			return x.SchemaId
+		}
+	}
+	return 0
+}
+
+type isDecodeRequest_WriterSchema interface {
+	isDecodeRequest_WriterSchema()
+}
+
+type DecodeRequest_Schema struct {
+	// The writer schema as JSON. A stream may send it with every message;
+	// each distinct schema is parsed once per stream.
+	Schema string `protobuf:"bytes,3,opt,name=schema,proto3,oneof"`
+}
+
+type DecodeRequest_SchemaId struct {
+	// The ID of the writer schema in the server's -schema-registry. The
+	// data is the bare record, without a wire format header.
+	SchemaId int32 `protobuf:"varint,4,opt,name=schema_id,json=schemaId,proto3,oneof"`
+}
+
+func (*DecodeRequest_Schema) isDecodeRequest_WriterSchema() {}
+
+func (*DecodeRequest_SchemaId) isDecodeRequest_WriterSchema() {}
+
+type DecodeResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The id of the request.
+	Id string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	// The record as a JSON object, unless it could not be decoded.
+	Json string `protobuf:"bytes,2,opt,name=json,proto3" json:"json,omitempty"`
+	// Why the record could not be decoded, if it couldn't.
+	Error         string `protobuf:"bytes,3,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DecodeResponse) Reset() {
+	*x = DecodeResponse{}
+	mi := &file_avroparser_v1_decode_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DecodeResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DecodeResponse) ProtoMessage() {}
+
+func (x *DecodeResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_avroparser_v1_decode_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DecodeResponse.ProtoReflect.Descriptor instead.
+func (*DecodeResponse) Descriptor() ([]byte, []int) {
+	return file_avroparser_v1_decode_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *DecodeResponse) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *DecodeResponse) GetJson() string {
+	if x != nil {
+		return x.Json
+	}
+	return ""
+}
+
+func (x *DecodeResponse) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+var File_avroparser_v1_decode_proto protoreflect.FileDescriptor
+
+const file_avroparser_v1_decode_proto_rawDesc = "" +
+	"\n" +
+	"\x1aavroparser/v1/decode.proto\x12\ravroparser.v1\"}\n" +
+	"\rDecodeRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n" +
+	"\x04data\x18\x02 \x01(\fR\x04data\x12\x18\n" +
+	"\x06schema\x18\x03 \x01(\tH\x00R\x06schema\x12\x1d\n" +
+	"\tschema_id\x18\x04 \x01(\x05H\x00R\bschemaIdB\x0f\n" +
+	"\rwriter_schema\"J\n" +
+	"\x0eDecodeResponse\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n" +
+	"\x04json\x18\x02 \x01(\tR\x04json\x12\x14\n" +
+	"\x05error\x18\x03 \x01(\tR\x05error2\xa4\x01\n" +
+	"\rDecodeService\x12I\n" +
+	"\x06Decode\x12\x1c.avroparser.v1.DecodeRequest\x1a\x1d.avroparser.v1.DecodeResponse(\x010\x01\x12H\n" +
+	"\tDecodeOne\x12\x1c.avroparser.v1.DecodeRequest\x1a\x1d.avroparser.v1.DecodeResponseB=Z+avroparser/proto/avroparser/v1;avroparserv1\xaa\x02\rAvroParser.V1b\x06proto3"
+
+var (
+	file_avroparser_v1_decode_proto_rawDescOnce sync.Once
+	file_avroparser_v1_decode_proto_rawDescData []byte
+)
+
+func file_avroparser_v1_decode_proto_rawDescGZIP() []byte {
+	file_avroparser_v1_decode_proto_rawDescOnce.Do(func() {
+		file_avroparser_v1_decode_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_avroparser_v1_decode_proto_rawDesc), len(file_avroparser_v1_decode_proto_rawDesc)))
+	})
+	return file_avroparser_v1_decode_proto_rawDescData
+}
+
+var file_avroparser_v1_decode_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_avroparser_v1_decode_proto_goTypes = []any{
+	(*DecodeRequest)(nil),  // 0: avroparser.v1.DecodeRequest
+	(*DecodeResponse)(nil), // 1: avroparser.v1.DecodeResponse
+}
+var file_avroparser_v1_decode_proto_depIdxs = []int32{
+	0, // 0: avroparser.v1.DecodeService.Decode:input_type -> avroparser.v1.DecodeRequest
+	0, // 1: avroparser.v1.DecodeService.DecodeOne:input_type -> avroparser.v1.DecodeRequest
+	1, // 2: avroparser.v1.DecodeService.Decode:output_type -> avroparser.v1.DecodeResponse
+	1, // 3: avroparser.v1.DecodeService.DecodeOne:output_type -> avroparser.v1.DecodeResponse
+	2, // [2:4] is the sub-list for method output_type
+	0, // [0:2] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_avroparser_v1_decode_proto_init() }
+func file_avroparser_v1_decode_proto_init() {
+	if File_avroparser_v1_decode_proto != nil {
+		return
+	}
+	file_avroparser_v1_decode_proto_msgTypes[0].OneofWrappers = []any{
+		(*DecodeRequest_Schema)(nil),
+		(*DecodeRequest_SchemaId)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_avroparser_v1_decode_proto_rawDesc), len(file_avroparser_v1_decode_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_avroparser_v1_decode_proto_goTypes,
+		DependencyIndexes: file_avroparser_v1_decode_proto_depIdxs,
+		MessageInfos:      file_avroparser_v1_decode_proto_msgTypes,
+	}.Build()
+	File_avroparser_v1_decode_proto = out.File
+	file_avroparser_v1_decode_proto_goTypes = nil
+	file_avroparser_v1_decode_proto_depIdxs = nil
+}
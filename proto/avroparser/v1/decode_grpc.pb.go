@@ -0,0 +1,166 @@
+// Decode service of `avroparser serve`: clients send Avro-encoded messages
+// and get each back as JSON, converted as `avroparser decode` converts
+// records.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: avroparser/v1/decode.proto
+
+package avroparserv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	DecodeService_Decode_FullMethodName    = "/avroparser.v1.DecodeService/Decode"
+	DecodeService_DecodeOne_FullMethodName = "/avroparser.v1.DecodeService/DecodeOne"
+)
+
+// DecodeServiceClient is the client API for DecodeService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type DecodeServiceClient interface {
+	// Decode converts a stream of messages. Every request gets a response, in
+	// the order the requests were sent. A message that cannot be decoded gets
+	// a response with its error, and the stream goes on.
+	Decode(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[DecodeRequest, DecodeResponse], error)
+	// DecodeOne converts a single message.
+	DecodeOne(ctx context.Context, in *DecodeRequest, opts ...grpc.CallOption) (*DecodeResponse, error)
+}
+
+type decodeServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewDecodeServiceClient(cc grpc.ClientConnInterface) DecodeServiceClient {
+	return &decodeServiceClient{cc}
+}
+
+func (c *decodeServiceClient) Decode(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[DecodeRequest, DecodeResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &DecodeService_ServiceDesc.Streams[0], DecodeService_Decode_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[DecodeRequest, DecodeResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type DecodeService_DecodeClient = grpc.BidiStreamingClient[DecodeRequest, DecodeResponse]
+
+func (c *decodeServiceClient) DecodeOne(ctx context.Context, in *DecodeRequest, opts ...grpc.CallOption) (*DecodeResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DecodeResponse)
+	err := c.cc.Invoke(ctx, DecodeService_DecodeOne_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// DecodeServiceServer is the server API for DecodeService service.
+// All implementations must embed UnimplementedDecodeServiceServer
+// for forward compatibility.
+type DecodeServiceServer interface {
+	// Decode converts a stream of messages. Every request gets a response, in
+	// the order the requests were sent. A message that cannot be decoded gets
+	// a response with its error, and the stream goes on.
+	Decode(grpc.BidiStreamingServer[DecodeRequest, DecodeResponse]) error
+	// DecodeOne converts a single message.
+	DecodeOne(context.Context, *DecodeRequest) (*DecodeResponse, error)
+	mustEmbedUnimplementedDecodeServiceServer()
+}
+
+// UnimplementedDecodeServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedDecodeServiceServer struct{}
+
+func (UnimplementedDecodeServiceServer) Decode(grpc.BidiStreamingServer[DecodeRequest, DecodeResponse]) error {
+	return status.Error(codes.Unimplemented, "method Decode not implemented")
+}
+func (UnimplementedDecodeServiceServer) DecodeOne(context.Context, *DecodeRequest) (*DecodeResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method DecodeOne not implemented")
+}
+func (UnimplementedDecodeServiceServer) mustEmbedUnimplementedDecodeServiceServer() {}
+func (UnimplementedDecodeServiceServer) testEmbeddedByValue()                       {}
+
+// UnsafeDecodeServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to DecodeServiceServer will
+// result in compilation errors.
+type UnsafeDecodeServiceServer interface {
+	mustEmbedUnimplementedDecodeServiceServer()
+}
+
+func RegisterDecodeServiceServer(s grpc.ServiceRegistrar, srv DecodeServiceServer) {
+	// If the following call panics, it indicates UnimplementedDecodeServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&DecodeService_ServiceDesc, srv)
+}
+
+func _DecodeService_Decode_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(DecodeServiceServer).Decode(&grpc.GenericServerStream[DecodeRequest, DecodeResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type DecodeService_DecodeServer = grpc.BidiStreamingServer[DecodeRequest, DecodeResponse]
+
+func _DecodeService_DecodeOne_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DecodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DecodeServiceServer).DecodeOne(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DecodeService_DecodeOne_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DecodeServiceServer).DecodeOne(ctx, req.(*DecodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// DecodeService_ServiceDesc is the grpc.ServiceDesc for DecodeService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var DecodeService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "avroparser.v1.DecodeService",
+	HandlerType: (*DecodeServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "DecodeOne",
+			Handler:    _DecodeService_DecodeOne_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Decode",
+			Handler:       _DecodeService_Decode_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "avroparser/v1/decode.proto",
+}
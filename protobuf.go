@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protodelim"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"gopkg.in/yaml.v3"
+)
+
+// protoFlags describe the message -format protobuf writes records as.
+type protoFlags struct {
+	descriptor *string
+	message    *string
+	mapping    *string
+}
+
+func addProtoFlags(fs *flag.FlagSet) *protoFlags {
+	return &protoFlags{
+		descriptor: fs.String("proto-descriptor", "", "With -format protobuf, the descriptor set of the message, from protoc --include_imports --descriptor_set_out"),
+		message:    fs.String("proto-message", "", "With -format protobuf, the full name of the message records are written as, e.g. analytics.v1.Event"),
+		mapping:    fs.String("proto-mapping", "", "With -format protobuf, a YAML file mapping message fields to record field paths; other fields take the record field of their name"),
+	}
+}
+
+// protoMessage returns the message the flags describe, or nil when the
+// format isn't protobuf.
+func (pf *protoFlags) protoMessage(format string) (*protoMessage, error) {
+	if format != "protobuf" {
+		if *pf.descriptor != "" || *pf.message != "" || *pf.mapping != "" {
+			return nil, fmt.Errorf("-proto-descriptor, -proto-message and -proto-mapping are only used with -format protobuf")
+		}
+		return nil, nil
+	}
+	if *pf.descriptor == "" || *pf.message == "" {
+		return nil, fmt.Errorf("-format protobuf needs -proto-descriptor and -proto-message")
+	}
+	data, err := os.ReadFile(*pf.descriptor)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read descriptor set: %w", err)
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("cannot parse descriptor set %s: %w", *pf.descriptor, err)
+	}
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load descriptor set %s: %w", *pf.descriptor, err)
+	}
+	d, err := files.FindDescriptorByName(protoreflect.FullName(*pf.message))
+	if err != nil {
+		return nil, fmt.Errorf("cannot find message %s in %s: %w", *pf.message, *pf.descriptor, err)
+	}
+	md, ok := d.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a message", *pf.message)
+	}
+
+	pm := &protoMessage{descriptor: md, paths: make(map[protoreflect.Name][]string)}
+	if *pf.mapping != "" {
+		if err := pm.loadMapping(*pf.mapping); err != nil {
+			return nil, err
+		}
+	}
+	return pm, nil
+}
+
+// protoMessage is the message records are written as. The top-level fields
+// of the message are filled from the record fields at paths, or from the
+// field of their own name; nested messages are filled by field name.
+type protoMessage struct {
+	descriptor protoreflect.MessageDescriptor
+	paths      map[protoreflect.Name][]string
+}
+
+// loadMapping reads a -proto-mapping file:
+//
+//	fields:
+//	  user_id: user_pseudo_id
+//	  country: geo.country
+func (pm *protoMessage) loadMapping(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("cannot read proto mapping: %w", err)
+	}
+	var spec struct {
+		Fields map[string]string `yaml:"fields"`
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&spec); err != nil {
+		return fmt.Errorf("cannot parse proto mapping %s: %w", path, err)
+	}
+	for name, field := range spec.Fields {
+		if pm.descriptor.Fields().ByName(protoreflect.Name(name)) == nil {
+			return fmt.Errorf("%s: message %s has no field %s", path, pm.descriptor.FullName(), name)
+		}
+		if field == "" || strings.HasPrefix(field, ".") || strings.HasSuffix(field, ".") || strings.Contains(field, "..") {
+			return fmt.Errorf("%s: invalid field path %q for %s", path, field, name)
+		}
+		pm.paths[protoreflect.Name(name)] = strings.Split(field, ".")
+	}
+	return nil
+}
+
+// protoWriter writes records as length-delimited protobuf messages, each
+// preceded by its size as a varint, as protodelim and Java's
+// writeDelimitedTo read them.
+type protoWriter struct {
+	w       io.Writer
+	message *protoMessage
+}
+
+func newProtoWriter(w io.Writer, message *protoMessage) *protoWriter {
+	return &protoWriter{w: w, message: message}
+}
+
+func (pw *protoWriter) WriteRecord(msg json.RawMessage) error {
+	v, err := parseMessage(msg)
+	if err != nil {
+		return err
+	}
+	record, ok := v.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("protobuf output needs JSON objects, got %s", msg)
+	}
+	m := dynamicpb.NewMessage(pw.message.descriptor)
+	fields := m.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		var value interface{}
+		if path, ok := pw.message.paths[fd.Name()]; ok {
+			value = lookupPath(record, path)
+		} else {
+			value = record[string(fd.Name())]
+		}
+		if err := setProtoField(m, fd, value); err != nil {
+			return err
+		}
+	}
+	_, err = protodelim.MarshalTo(pw.w, m)
+	return err
+}
+
+func (pw *protoWriter) Flush() error { return nil }
+
+func (pw *protoWriter) Close() error { return nil }
+
+// setProtoField sets a field from a JSON value. Null and missing values
+// leave the field unset.
+func setProtoField(m protoreflect.Message, fd protoreflect.FieldDescriptor, v interface{}) error {
+	if v == nil {
+		return nil
+	}
+	switch {
+	case fd.IsMap():
+		object, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("field %s: expected an object, got %s", fd.Name(), jsonText(v))
+		}
+		entries := m.Mutable(fd).Map()
+		for key, item := range object {
+			k, err := protoScalar(fd.MapKey(), key)
+			if err != nil {
+				return fmt.Errorf("field %s key %q: %w", fd.Name(), key, err)
+			}
+			value, err := protoValue(entries.NewValue, fd.MapValue(), item)
+			if err != nil {
+				return fmt.Errorf("field %s[%q]: %w", fd.Name(), key, err)
+			}
+			entries.Set(k.MapKey(), value)
+		}
+	case fd.IsList():
+		items, ok := v.([]interface{})
+		if !ok {
+			return fmt.Errorf("field %s: expected an array, got %s", fd.Name(), jsonText(v))
+		}
+		list := m.Mutable(fd).List()
+		for i, item := range items {
+			value, err := protoValue(list.NewElement, fd, item)
+			if err != nil {
+				return fmt.Errorf("field %s[%d]: %w", fd.Name(), i, err)
+			}
+			list.Append(value)
+		}
+	default:
+		value, err := protoValue(func() protoreflect.Value { return m.NewField(fd) }, fd, v)
+		if err != nil {
+			return fmt.Errorf("field %s: %w", fd.Name(), err)
+		}
+		m.Set(fd, value)
+	}
+	return nil
+}
+
+// protoValue converts a single JSON value for a field. newMessage returns
+// an empty message for message fields.
+func protoValue(newMessage func() protoreflect.Value, fd protoreflect.FieldDescriptor, v interface{}) (protoreflect.Value, error) {
+	if fd.Kind() != protoreflect.MessageKind && fd.Kind() != protoreflect.GroupKind {
+		return protoScalar(fd, v)
+	}
+	value := newMessage()
+	m := value.Message()
+	if m.Descriptor().FullName() == "google.protobuf.Timestamp" {
+		// RFC 3339 text or epoch numbers, as the converter writes timestamps
+		t, ok := eventTime(v, "")
+		if !ok {
+			return value, fmt.Errorf("expected a timestamp, got %s", jsonText(v))
+		}
+		fields := m.Descriptor().Fields()
+		m.Set(fields.ByName("seconds"), protoreflect.ValueOfInt64(t.Unix()))
+		m.Set(fields.ByName("nanos"), protoreflect.ValueOfInt32(int32(t.Nanosecond())))
+		return value, nil
+	}
+	object, ok := v.(map[string]interface{})
+	if !ok {
+		return value, fmt.Errorf("expected an object, got %s", jsonText(v))
+	}
+	fields := m.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		nested := fields.Get(i)
+		if err := setProtoField(m, nested, object[string(nested.Name())]); err != nil {
+			return value, err
+		}
+	}
+	return value, nil
+}
+
+// protoScalar converts a JSON value for a scalar or enum field. Numbers may
+// be given as numeric strings and enums by name or number.
+func protoScalar(fd protoreflect.FieldDescriptor, v interface{}) (protoreflect.Value, error) {
+	text := jsonText(v)
+	if s, ok := v.(string); ok {
+		text = s
+	}
+	var err error
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		var b bool
+		if b, err = strconv.ParseBool(text); err == nil {
+			return protoreflect.ValueOfBool(b), nil
+		}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		var n int64
+		if n, err = strconv.ParseInt(text, 10, 32); err == nil {
+			return protoreflect.ValueOfInt32(int32(n)), nil
+		}
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		var n int64
+		if n, err = strconv.ParseInt(text, 10, 64); err == nil {
+			return protoreflect.ValueOfInt64(n), nil
+		}
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		var n uint64
+		if n, err = strconv.ParseUint(text, 10, 32); err == nil {
+			return protoreflect.ValueOfUint32(uint32(n)), nil
+		}
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		var n uint64
+		if n, err = strconv.ParseUint(text, 10, 64); err == nil {
+			return protoreflect.ValueOfUint64(n), nil
+		}
+	case protoreflect.FloatKind:
+		var f float64
+		if f, err = strconv.ParseFloat(text, 32); err == nil {
+			return protoreflect.ValueOfFloat32(float32(f)), nil
+		}
+	case protoreflect.DoubleKind:
+		var f float64
+		if f, err = strconv.ParseFloat(text, 64); err == nil {
+			return protoreflect.ValueOfFloat64(f), nil
+		}
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(text), nil
+	case protoreflect.BytesKind:
+		return protoreflect.ValueOfBytes([]byte(text)), nil
+	case protoreflect.EnumKind:
+		if symbol := fd.Enum().Values().ByName(protoreflect.Name(text)); symbol != nil {
+			return protoreflect.ValueOfEnum(symbol.Number()), nil
+		}
+		var n int64
+		if n, err = strconv.ParseInt(text, 10, 32); err == nil {
+			return protoreflect.ValueOfEnum(protoreflect.EnumNumber(n)), nil
+		}
+		err = fmt.Errorf("not a value of enum %s", fd.Enum().FullName())
+	}
+	return protoreflect.Value{}, fmt.Errorf("cannot convert %s to %s: %w", jsonText(v), fd.Kind(), err)
+}
+
+// jsonText renders a value for messages and as the text of string fields.
+func jsonText(v interface{}) string {
+	if n, ok := v.(json.Number); ok {
+		return string(n)
+	}
+	text, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(text)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protodelim"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// writeDescriptorSet writes the descriptor set of analytics.v1.Event to a
+// file, returning its path.
+func writeDescriptorSet(t *testing.T) string {
+	t.Helper()
+	field := func(name string, number int32, kind descriptorpb.FieldDescriptorProto_Type, label descriptorpb.FieldDescriptorProto_Label, typeName string) *descriptorpb.FieldDescriptorProto {
+		f := &descriptorpb.FieldDescriptorProto{Name: proto.String(name), Number: proto.Int32(number), Type: kind.Enum(), Label: label.Enum()}
+		if typeName != "" {
+			f.TypeName = proto.String(typeName)
+		}
+		return f
+	}
+	optional, repeated := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL, descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+	file := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("analytics/v1/event.proto"),
+		Package:    proto.String("analytics.v1"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"google/protobuf/timestamp.proto"},
+		EnumType: []*descriptorpb.EnumDescriptorProto{{
+			Name: proto.String("Platform"),
+			Value: []*descriptorpb.EnumValueDescriptorProto{
+				{Name: proto.String("PLATFORM_UNSPECIFIED"), Number: proto.Int32(0)},
+				{Name: proto.String("IOS"), Number: proto.Int32(1)},
+				{Name: proto.String("ANDROID"), Number: proto.Int32(2)},
+			},
+		}},
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Event"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				field("user_id", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, optional, ""),
+				field("score", 2, descriptorpb.FieldDescriptorProto_TYPE_INT64, optional, ""),
+				field("platform", 3, descriptorpb.FieldDescriptorProto_TYPE_ENUM, optional, ".analytics.v1.Platform"),
+				field("tags", 4, descriptorpb.FieldDescriptorProto_TYPE_STRING, repeated, ""),
+				field("counts", 5, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, repeated, ".analytics.v1.Event.CountsEntry"),
+				field("time", 6, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, optional, ".google.protobuf.Timestamp"),
+			},
+			NestedType: []*descriptorpb.DescriptorProto{{
+				Name: proto.String("CountsEntry"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("key", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, optional, ""),
+					field("value", 2, descriptorpb.FieldDescriptorProto_TYPE_INT32, optional, ""),
+				},
+				Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+			}},
+		}},
+	}
+	set := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{
+		protodesc.ToFileDescriptorProto(timestamppb.File_google_protobuf_timestamp_proto),
+		file,
+	}}
+	data, err := proto.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "event.pb")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// testProtoMessage returns the message the proto flags in args describe.
+func testProtoMessage(t *testing.T, args ...string) (*protoMessage, error) {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	pf := addProtoFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return pf.protoMessage("protobuf")
+}
+
+func TestProtoWriter(t *testing.T) {
+	descriptors := writeDescriptorSet(t)
+	mapping := writeTestFile(t, "mapping.yaml", []byte("fields:\n  user_id: user.pseudo_id\n"))
+	pm, err := testProtoMessage(t, "-proto-descriptor", descriptors, "-proto-message", "analytics.v1.Event", "-proto-mapping", mapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	pw := newProtoWriter(&buf, pm)
+	for _, msg := range []string{
+		`{"user":{"pseudo_id":"u1"},"score":"42","platform":"ANDROID","tags":["a","b"],"counts":{"wins":3},"time":"2026-10-15T12:00:00.5Z"}`,
+		`{"user_id":"ignored","score":null,"platform":1}`,
+	} {
+		if err := pw.WriteRecord(json.RawMessage(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := bufio.NewReader(&buf)
+	fields := pm.descriptor.Fields()
+	first := dynamicpb.NewMessage(pm.descriptor)
+	if err := protodelim.UnmarshalFrom(r, first); err != nil {
+		t.Fatal(err)
+	}
+	if got := first.Get(fields.ByName("user_id")).String(); got != "u1" {
+		t.Errorf("user_id = %q, want u1", got)
+	}
+	if got := first.Get(fields.ByName("score")).Int(); got != 42 {
+		t.Errorf("score = %d, want 42", got)
+	}
+	if got := first.Get(fields.ByName("platform")).Enum(); got != 2 {
+		t.Errorf("platform = %d, want 2", got)
+	}
+	if tags := first.Get(fields.ByName("tags")).List(); tags.Len() != 2 || tags.Get(1).String() != "b" {
+		t.Errorf("tags = %v", tags)
+	}
+	if wins := first.Get(fields.ByName("counts")).Map().Get(protoreflect.ValueOfString("wins").MapKey()); wins.Int() != 3 {
+		t.Errorf("counts[wins] = %v, want 3", wins)
+	}
+	ts := first.Get(fields.ByName("time")).Message()
+	if seconds, nanos := ts.Get(ts.Descriptor().Fields().ByName("seconds")).Int(), ts.Get(ts.Descriptor().Fields().ByName("nanos")).Int(); seconds != 1792065600 || nanos != 500000000 {
+		t.Errorf("time = %d.%d", seconds, nanos)
+	}
+
+	second := dynamicpb.NewMessage(pm.descriptor)
+	if err := protodelim.UnmarshalFrom(r, second); err != nil {
+		t.Fatal(err)
+	}
+	if second.Has(fields.ByName("user_id")) || second.Has(fields.ByName("score")) || second.Get(fields.ByName("platform")).Enum() != 1 {
+		t.Errorf("second message %v", second)
+	}
+
+	if err := pw.WriteRecord(json.RawMessage(`{"score":"many"}`)); err == nil {
+		t.Error("wrote a score that isn't a number")
+	}
+}
+
+func TestProtoMessageErrors(t *testing.T) {
+	descriptors := writeDescriptorSet(t)
+	badMapping := writeTestFile(t, "mapping.yaml", []byte("fields:\n  missing: user_id\n"))
+	for name, args := range map[string][]string{
+		"without message": {"-proto-descriptor", descriptors},
+		"unknown message": {"-proto-descriptor", descriptors, "-proto-message", "analytics.v1.Missing"},
+		"not a message":   {"-proto-descriptor", descriptors, "-proto-message", "analytics.v1.Platform"},
+		"unknown field":   {"-proto-descriptor", descriptors, "-proto-message", "analytics.v1.Event", "-proto-mapping", badMapping},
+	} {
+		if _, err := testProtoMessage(t, args...); err == nil {
+			t.Errorf("%s: protoMessage succeeded", name)
+		}
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	pf := addProtoFlags(fs)
+	if err := fs.Parse([]string{"-proto-message", "analytics.v1.Event"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pf.protoMessage("ndjson"); err == nil {
+		t.Error("accepted -proto-message without -format protobuf")
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/linkedin/goavro/v2"
+)
+
+// Framings of bare Avro datums accepted by -framing.
+const (
+	framingNone      = "none"      // datums written back to back
+	framingLength    = "length"    // each datum prefixed by a 4-byte big-endian length
+	framingConfluent = "confluent" // each datum prefixed by a zero magic byte and a 4-byte schema ID
+)
+
+// rawInput describes schema-less Avro input decoded with an external schema.
+type rawInput struct {
+	codec   *goavro.Codec
+	framing string
+}
+
+// rawReader decodes bare Avro binary datums, such as Kafka message dumps,
+// with a schema given on the command line. The input is read into memory,
+// as unframed datums can only be told apart by decoding them.
+type rawReader struct {
+	codec   *goavro.Codec
+	framing string
+	buf     []byte
+	offset  int
+	record  interface{}
+	err     error
+}
+
+func newRawReader(r io.Reader, input *rawInput) (*rawReader, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read input: %w", err)
+	}
+	return &rawReader{codec: input.codec, framing: input.framing, buf: data}, nil
+}
+
+// Scan decodes the next datum. Decoding stops at the first malformed datum,
+// since the start of the following one cannot be found without it.
+func (rr *rawReader) Scan() bool {
+	if rr.err != nil || len(rr.buf) == 0 {
+		return false
+	}
+
+	datum, next := rr.buf, 0
+	switch rr.framing {
+	case framingLength:
+		if len(rr.buf) < 4 {
+			return rr.fail(errors.New("truncated length prefix"))
+		}
+		n := int(binary.BigEndian.Uint32(rr.buf))
+		if len(rr.buf)-4 < n {
+			return rr.fail(fmt.Errorf("datum of %d bytes is truncated", n))
+		}
+		datum, next = rr.buf[4:4+n], 4+n
+
+	case framingConfluent:
+		if len(rr.buf) < 5 || rr.buf[0] != 0 {
+			return rr.fail(errors.New("missing Confluent wire format header"))
+		}
+		datum = rr.buf[5:]
+	}
+
+	record, rest, err := rr.codec.NativeFromBinary(datum)
+	if err != nil {
+		return rr.fail(err)
+	}
+	if rr.framing == framingLength {
+		if len(rest) != 0 {
+			return rr.fail(fmt.Errorf("datum is %d bytes shorter than its length prefix", len(rest)))
+		}
+	} else {
+		next = len(rr.buf) - len(rest)
+	}
+
+	rr.record = record
+	rr.buf = rr.buf[next:]
+	rr.offset += next
+	return true
+}
+
+func (rr *rawReader) fail(err error) bool {
+	rr.err = fmt.Errorf("cannot decode datum at offset %d: %w", rr.offset, err)
+	return false
+}
+
+func (rr *rawReader) Read() (interface{}, error) {
+	return rr.record, nil
+}
+
+// Raw returns the rest of the input from the datum that could not be
+// decoded. Datums that decode are never reported as failed, so no bytes
+// are kept for them.
+func (rr *rawReader) Raw() []byte {
+	if rr.err == nil {
+		return nil
+	}
+	return rr.buf
+}
+
+func (rr *rawReader) Err() error {
+	return rr.err
+}
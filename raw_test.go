@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"avroparser/pkg/avroconvert"
+	"github.com/linkedin/goavro/v2"
+)
+
+// rawDatums encodes msgs as bare datums of messageSchema in the given
+// framing.
+func rawDatums(t *testing.T, codec *goavro.Codec, framing string, msgs ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	for _, msg := range msgs {
+		datum, err := codec.BinaryFromNative(nil, map[string]interface{}{"message": []byte(msg)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch framing {
+		case framingLength:
+			buf.Write(binary.BigEndian.AppendUint32(nil, uint32(len(datum))))
+		case framingConfluent:
+			buf.Write([]byte{0, 0, 0, 0, 42})
+		}
+		buf.Write(datum)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeRaw(t *testing.T) {
+	codec, err := goavro.NewCodec(messageSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, framing := range []string{framingNone, framingLength, framingConfluent} {
+		opts := testOptions(t, "message")
+		opts.raw = &rawInput{codec: codec, framing: framing}
+
+		var out bytes.Buffer
+		data := rawDatums(t, codec, framing, `{"id":1}`, `{"id":2}`)
+		stats, err := decodeMessages(bytes.NewReader(data), "test.bin", opts, avroconvert.NewNDJSONSink(&out))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "{\"id\":1}\n{\"id\":2}\n"; stats.Messages != 2 || out.String() != want {
+			t.Errorf("framing %s: decoded %+v as %q, want %q", framing, stats, out.String(), want)
+		}
+	}
+}
+
+func TestRawReaderTruncated(t *testing.T) {
+	codec, err := goavro.NewCodec(messageSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := rawDatums(t, codec, framingLength, `{"id":1}`, `{"id":2}`)
+	rr, err := newRawReader(bytes.NewReader(data[:len(data)-1]), &rawInput{codec: codec, framing: framingLength})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for rr.Scan() {
+		n++
+	}
+	if n != 1 || rr.Err() == nil {
+		t.Fatalf("read %d datums from a truncated input, error %v", n, rr.Err())
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"avroparser/pkg/avroconvert"
+	"github.com/linkedin/goavro/v2"
+)
+
+// recompressOptions holds the settings for recompress.
+type recompressOptions struct {
+	codec     string // output codec, one of the ocfCodec constants
+	blockSize int    // uncompressed size to regroup records into blocks of, or 0 to keep blocks
+}
+
+func runRecompress(args []string) {
+	fs := flag.NewFlagSet("recompress", flag.ExitOnError)
+	inputPath := fs.String("input", "", "Input Avro file, directory or glob pattern, or - for stdin")
+	outputDir := fs.String("output", "output", "Output directory for the recompressed files, or - for stdout")
+	codec := fs.String("codec", "zstd", "Block compression of the output: null, deflate, snappy, xz or zstd")
+	blockSize := fs.String("block-size", "", "Regroup records into blocks of about this uncompressed size, e.g. 1MB (default: keep the input's blocks)")
+	workers := fs.Int("workers", 1, "Number of files to convert concurrently")
+	logging := addLogFlags(fs)
+	parseFlags(fs, args)
+	logging.setup()
+
+	if *inputPath == "" && fs.NArg() > 0 {
+		*inputPath = fs.Arg(0)
+	}
+
+	if *inputPath == "" {
+		fmt.Fprintln(os.Stderr, "Usage: avroparser recompress -input <avro_file|dir|glob|-> [-output <output_dir>|-] [-codec null|deflate|snappy|xz|zstd] [-block-size <size>]")
+		os.Exit(exitFatal)
+	}
+
+	var opts recompressOptions
+	var ok bool
+	if opts.codec, ok = ocfCodecs[*codec]; !ok {
+		fmt.Fprintf(os.Stderr, "Unknown codec %q (expected null, deflate, snappy, xz or zstd)\n", *codec)
+		os.Exit(exitFatal)
+	}
+	if *blockSize != "" {
+		size, err := parseSize(*blockSize)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -block-size: %v\n", err)
+			os.Exit(exitFatal)
+		}
+		opts.blockSize = int(size)
+	}
+
+	runBatch(mustExpandInputs(*inputPath), *outputDir, *workers, nil, "", nil, func(in inputFile) fileResult {
+		output := outputPath(in, *outputDir, "avro")
+		return runFile(in, output, func() (avroconvert.Stats, error) {
+			return recompressFile(in.path, output, opts)
+		})
+	})
+}
+
+// recompressFile writes a container file again with another codec or block
+// size. Records are never decoded: blocks are decompressed and compressed
+// again, and only divided into records when regrouping needs it.
+func recompressFile(input, output string, opts recompressOptions) (avroconvert.Stats, error) {
+	var stats avroconvert.Stats
+	r, err := openInput(input)
+	if err != nil {
+		return stats, err
+	}
+	defer r.Close()
+	counter := &countingReader{r: r}
+	or, err := newOCFReader(counter)
+	if err != nil {
+		return stats, err
+	}
+	defer or.Close()
+
+	out, err := openOutput(output)
+	if err != nil {
+		return stats, err
+	}
+	written := &countingWriter{w: out}
+	ow, err := newOCFWriter(written, string(or.header.schema()), opts.codec)
+	if err != nil {
+		abortOutput(out)
+		return stats, err
+	}
+	if opts.blockSize > 0 {
+		ow.blockSize = opts.blockSize
+	}
+
+	err = recompressBlocks(or, ow, opts, &stats)
+	if err == nil {
+		err = ow.Close()
+	}
+	if err != nil {
+		abortOutput(out)
+		return stats, err
+	}
+	if err := out.Close(); err != nil {
+		return stats, fmt.Errorf("cannot write output file: %w", err)
+	}
+
+	slog.Info("Recompressed records", "input", displayPath(input), "records", stats.Messages, "from_codec", or.header.codec(), "to_codec", opts.codec,
+		"input_bytes", counter.n, "output_bytes", written.n, "output", displayPath(output))
+	return stats, nil
+}
+
+func recompressBlocks(or *ocfReader, ow *ocfWriter, opts recompressOptions, stats *avroconvert.Stats) error {
+	var codec *goavro.Codec
+	for {
+		count, data, err := or.next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		stats.Messages += count
+
+		// Blocks kept as they are only need their codec changed
+		if opts.blockSize == 0 && or.header.codec() == ow.name {
+			if err := ow.writeBlock(count, data); err != nil {
+				return err
+			}
+			continue
+		}
+		raw, err := or.decompress(data)
+		if err != nil {
+			return fmt.Errorf("cannot decompress block: %w", err)
+		}
+		if opts.blockSize == 0 {
+			if err := ow.writeEncoded(count, raw); err != nil {
+				return err
+			}
+			if err := ow.flush(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if len(raw) <= opts.blockSize {
+			if err := ow.writeEncoded(count, raw); err != nil {
+				return err
+			}
+			continue
+		}
+		// Blocks larger than the new size are divided between blocks
+		if codec == nil {
+			if codec, err = goavro.NewCodec(string(or.header.schema())); err != nil {
+				return fmt.Errorf("cannot parse schema: %w", err)
+			}
+		}
+		records, err := recordSpans(codec, raw, count)
+		if err != nil {
+			return err
+		}
+		for _, record := range records {
+			if err := ow.writeEncoded(1, record); err != nil {
+				return err
+			}
+		}
+	}
+}
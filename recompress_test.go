@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// countBlocks returns the number of blocks of a container stream and its
+// codec.
+func countBlocks(t *testing.T, data []byte) (int, string) {
+	t.Helper()
+	or, err := newOCFReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer or.Close()
+	n := 0
+	for {
+		if _, _, err := or.next(); err != nil {
+			return n, or.header.codec()
+		}
+		n++
+	}
+}
+
+func TestRecompressFile(t *testing.T) {
+	data := writeTestOCF(t, ocfCodecDeflate, 500, nil, nil)
+	input := writeTestFile(t, "events.avro", data)
+	inputBlocks, _ := countBlocks(t, data)
+
+	for _, tt := range []struct {
+		opts   recompressOptions
+		blocks func(n int) bool
+	}{
+		{recompressOptions{codec: ocfCodecZstandard}, func(n int) bool { return n == inputBlocks }},
+		{recompressOptions{codec: ocfCodecDeflate, blockSize: 4 << 10}, func(n int) bool { return n < inputBlocks }},
+		{recompressOptions{codec: ocfCodecSnappy, blockSize: 100}, func(n int) bool { return n > inputBlocks }},
+	} {
+		output := filepath.Join(t.TempDir(), "events.avro")
+		stats, err := recompressFile(input, output, tt.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := os.ReadFile(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		blocks, codec := countBlocks(t, out)
+		ids := readBlockIDs(t, out)
+		if stats.Messages != 500 || len(ids) != 500 || ids[499] != 499 || codec != tt.opts.codec || !tt.blocks(blocks) {
+			t.Fatalf("%+v: recompressed %d records into %d %s blocks, read %d (input has %d blocks)",
+				tt.opts, stats.Messages, blocks, codec, len(ids), inputBlocks)
+		}
+	}
+}
+
+func TestRecompressFileTruncated(t *testing.T) {
+	data := writeTestOCF(t, ocfCodecDeflate, 500, nil, nil)
+	input := writeTestFile(t, "events.avro", data[:len(data)-10])
+	dir := t.TempDir()
+	if _, err := recompressFile(input, filepath.Join(dir, "events.avro"), recompressOptions{codec: ocfCodecSnappy}); err == nil {
+		t.Fatal("recompressed a truncated file")
+	}
+	checkNoOutputs(t, dir)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Redaction strategies of -redact.
+const (
+	redactRemove   = "remove"   // drop the field
+	redactNull     = "null"     // keep the field as null
+	redactHash     = "hash"     // replace the value by its salted hash
+	redactTruncate = "truncate" // keep the first -truncate-length characters
+)
+
+// redactFlags select personal data to remove from records before they are
+// written, for the commands that write JSON, CSV or Avro records.
+type redactFlags struct {
+	redact   *string
+	strategy *string
+	hashSalt *string
+	truncate *int
+}
+
+func addRedactFlags(fs *flag.FlagSet) *redactFlags {
+	return &redactFlags{
+		redact:   fs.String("redact", "", "Comma-separated field paths to redact, each optionally with its own strategy, e.g. device.advertising_id,user_pseudo_id=hash"),
+		strategy: fs.String("redact-strategy", redactRemove, "How -redact fields are redacted: remove, null, hash (salted HMAC-SHA256) or truncate"),
+		hashSalt: fs.String("hash-salt", "", "Secret salt of the hash strategy, so hashes cannot be reversed by hashing known IDs"),
+		truncate: fs.Int("truncate-length", 4, "Characters the truncate strategy keeps"),
+	}
+}
+
+// redactor returns the redaction the flags describe, or nil when -redact
+// isn't given.
+func (rf *redactFlags) redactor() (*redactor, error) {
+	if *rf.redact == "" {
+		if *rf.hashSalt != "" {
+			return nil, fmt.Errorf("-hash-salt is only used with -redact")
+		}
+		return nil, nil
+	}
+	if *rf.truncate < 1 {
+		return nil, fmt.Errorf("-truncate-length must be at least 1, got %d", *rf.truncate)
+	}
+
+	r := &redactor{salt: []byte(*rf.hashSalt), length: *rf.truncate}
+	for _, spec := range strings.Split(*rf.redact, ",") {
+		field, strategy, ok := strings.Cut(strings.TrimSpace(spec), "=")
+		if !ok {
+			strategy = *rf.strategy
+		}
+		switch strategy {
+		case redactRemove, redactNull, redactTruncate:
+		case redactHash:
+			if *rf.hashSalt == "" {
+				return nil, fmt.Errorf("the hash strategy needs -hash-salt")
+			}
+		default:
+			return nil, fmt.Errorf("unknown redaction strategy %q for %s (expected remove, null, hash or truncate)", strategy, field)
+		}
+		if field == "" || strings.HasPrefix(field, ".") || strings.HasSuffix(field, ".") || strings.Contains(field, "..") {
+			return nil, fmt.Errorf("invalid -redact field %q", field)
+		}
+		r.rules = append(r.rules, redactRule{path: strings.Split(field, "."), strategy: strategy})
+	}
+	return r, nil
+}
+
+// redactor removes or masks fields of JSON records. Paths follow nested
+// objects; where one passes through an array, the field is redacted in every
+// element.
+type redactor struct {
+	rules  []redactRule
+	salt   []byte
+	length int
+}
+
+type redactRule struct {
+	path     []string
+	strategy string
+}
+
+// value redacts a parsed record in place and returns it.
+func (r *redactor) value(v interface{}) interface{} {
+	for _, rule := range r.rules {
+		r.redactPath(v, rule.path, rule.strategy)
+	}
+	return v
+}
+
+// apply redacts a JSON message. It is used as a decoder transform, ahead of
+// any -transform.
+func (r *redactor) apply(msg json.RawMessage) ([]json.RawMessage, error) {
+	v, err := parseMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+	text, err := json.Marshal(r.value(v))
+	if err != nil {
+		return nil, err
+	}
+	return []json.RawMessage{text}, nil
+}
+
+func (r *redactor) redactPath(v interface{}, path []string, strategy string) {
+	switch t := v.(type) {
+	case []interface{}:
+		for _, item := range t {
+			r.redactPath(item, path, strategy)
+		}
+	case map[string]interface{}:
+		value, ok := t[path[0]]
+		if !ok {
+			return
+		}
+		if len(path) > 1 {
+			r.redactPath(value, path[1:], strategy)
+			return
+		}
+		switch strategy {
+		case redactRemove:
+			delete(t, path[0])
+		case redactNull:
+			t[path[0]] = nil
+		case redactHash:
+			if value != nil {
+				t[path[0]] = r.hash(value)
+			}
+		case redactTruncate:
+			if value != nil {
+				t[path[0]] = r.truncate(value)
+			}
+		}
+	}
+}
+
+// hash returns the hex HMAC-SHA256 of a value's text under the salt, so
+// equal values still join across exports without revealing them.
+func (r *redactor) hash(v interface{}) string {
+	mac := hmac.New(sha256.New, r.salt)
+	mac.Write([]byte(redactText(v)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// truncate keeps the first characters of a value's text.
+func (r *redactor) truncate(v interface{}) string {
+	text := []rune(redactText(v))
+	if len(text) > r.length {
+		text = text[:r.length]
+	}
+	return string(text)
+}
+
+// redactText is the text of a value that is hashed or truncated: strings
+// as they are, numbers as written and anything else as JSON.
+func redactText(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case json.Number:
+		return t.String()
+	case bool:
+		return strconv.FormatBool(t)
+	}
+	text, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(text)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"avroparser/pkg/avroconvert"
+)
+
+// testRedactor parses -redact flags.
+func testRedactor(t *testing.T, fields, strategy, salt string) (*redactor, error) {
+	t.Helper()
+	length := 4
+	rf := &redactFlags{redact: &fields, strategy: &strategy, hashSalt: &salt, truncate: &length}
+	return rf.redactor()
+}
+
+func TestRedactor(t *testing.T) {
+	r, err := testRedactor(t, "email, device.advertising_id=null,user_pseudo_id=hash,items.coupon=truncate,missing.field", redactRemove, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := `{"email": "ana@example.com", "device": {"advertising_id": "ad-1", "os": "ios"}, "user_pseudo_id": 42,` +
+		` "items": [{"coupon": "SUMMER2026"}, {"coupon": null}, {"id": 1}], "level": 3}`
+	out, err := r.apply(json.RawMessage(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The hash is HMAC-SHA256 of "42" with the salt "secret"
+	want := `{"device":{"advertising_id":null,"os":"ios"},"items":[{"coupon":"SUMM"},{"coupon":null},{"id":1}],"level":3,` +
+		`"user_pseudo_id":"` + r.hash("42") + `"}`
+	if len(out) != 1 || string(out[0]) != want {
+		t.Fatalf("redacted to %s, want %s", out, want)
+	}
+	if len(r.hash("42")) != 64 || r.hash("42") == r.hash("43") {
+		t.Fatalf("hash %s", r.hash("42"))
+	}
+
+	// Hashes depend on the salt
+	other, err := testRedactor(t, "user_pseudo_id", redactHash, "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.hash("42") == r.hash("42") {
+		t.Fatal("hashes ignore the salt")
+	}
+}
+
+func TestRedactorFlags(t *testing.T) {
+	if r, err := testRedactor(t, "", redactRemove, ""); r != nil || err != nil {
+		t.Fatalf("redactor %+v without -redact: %v", r, err)
+	}
+	for _, tc := range [][3]string{
+		{"", redactRemove, "salt"},           // salt without -redact
+		{"email", redactHash, ""},            // hash without salt
+		{"email=scramble", redactRemove, ""}, // unknown strategy
+		{"email", "scramble", ""},            // unknown default strategy
+		{"device..id", redactRemove, ""},     // invalid path
+	} {
+		if _, err := testRedactor(t, tc[0], tc[1], tc[2]); err == nil {
+			t.Errorf("accepted -redact %q -redact-strategy %s -hash-salt %q", tc[0], tc[1], tc[2])
+		}
+	}
+}
+
+func TestDecodeRedact(t *testing.T) {
+	opts := testOptions(t, "message")
+	var err error
+	if opts.redact, err = testRedactor(t, "email", redactRemove, ""); err != nil {
+		t.Fatal(err)
+	}
+	// Fields are redacted before -transform sees them
+	if opts.transform, err = parseTransform(`{id, email}`); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	data := writeMessageOCF(t, `{"id": 1, "email": "ana@example.com"}`)
+	if _, err := decodeMessages(bytes.NewReader(data), "test.avro", opts, avroconvert.NewNDJSONSink(&out)); err != nil {
+		t.Fatal(err)
+	}
+	if want := "{\"email\":null,\"id\":1}\n"; out.String() != want {
+		t.Fatalf("decoded %q, want %q", out.String(), want)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func runRetention(args []string) {
+	fs := flag.NewFlagSet("retention", flag.ExitOnError)
+	outputPath := fs.String("output", stdioPath, "Output CSV file for the cohort matrix, or - for stdout")
+	daysList := fs.String("days", "1,7,30", "Comma-separated days after the first that retention is reported for")
+	firstTouch := fs.String("first-touch-field", "user_first_touch_timestamp", "Field path of the user's first touch time, which puts them in a cohort; users whose events lack it are put in the cohort of the first day they are seen")
+	counts := fs.Bool("counts", false, "Write the number of retained users instead of the percentage of the cohort")
+	events := addEventFlags(fs)
+	csvOutput := addCSVReportFlags(fs)
+	logging := addLogFlags(fs)
+	parseFlags(fs, args)
+	logging.setup()
+
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: avroparser retention [-days 1,7,30] [-output <file>|-] <avro_or_ndjson_file|dir|glob|->...")
+		os.Exit(exitFatal)
+	}
+	dialect, err := csvOutput.dialect()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	var days []int
+	for _, day := range splitFieldList(*daysList) {
+		n, err := strconv.Atoi(day)
+		if err != nil || n < 1 {
+			fmt.Fprintf(os.Stderr, "Invalid -days entry %q (expected a positive number of days)\n", day)
+			os.Exit(exitFatal)
+		}
+		days = append(days, n)
+	}
+	if len(days) == 0 {
+		fmt.Fprintln(os.Stderr, "-days must name at least one day")
+		os.Exit(exitFatal)
+	}
+	var firstTouchPath []string
+	if *firstTouch != "" {
+		if strings.HasPrefix(*firstTouch, ".") || strings.HasSuffix(*firstTouch, ".") || strings.Contains(*firstTouch, "..") {
+			fmt.Fprintf(os.Stderr, "Invalid -first-touch-field %q\n", *firstTouch)
+			os.Exit(exitFatal)
+		}
+		firstTouchPath = strings.Split(*firstTouch, ".")
+	}
+	source, err := events.source()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+
+	var inputs []inputFile
+	for _, arg := range fs.Args() {
+		inputs = append(inputs, mustExpandInputs(arg)...)
+	}
+
+	users := make(map[string]*retentionUser)
+	lastDay := 0
+	failed, skipped := source.read(inputs, func(user string, t time.Time, event interface{}) {
+		u := users[user]
+		if u == nil {
+			u = &retentionUser{firstSeen: utcDay(t), active: make(map[int]bool)}
+			users[user] = u
+		}
+		day := utcDay(t)
+		u.active[day] = true
+		if day < u.firstSeen {
+			u.firstSeen = day
+		}
+		if day > lastDay {
+			lastDay = day
+		}
+		if firstTouchPath != nil && !u.touched {
+			if touch, ok := eventTime(lookupPath(event, firstTouchPath), ""); ok {
+				u.firstTouch, u.touched = utcDay(touch), true
+			}
+		}
+	})
+	if failed == len(inputs) {
+		os.Exit(exitFatal)
+	}
+	if skipped > 0 {
+		slog.Warn("Left out events without a user or time", "events", skipped)
+	}
+
+	cohorts := make(map[int]*retentionCohort)
+	for _, u := range users {
+		first := u.firstSeen
+		if u.touched {
+			first = u.firstTouch
+		}
+		c := cohorts[first]
+		if c == nil {
+			c = &retentionCohort{retained: make([]int, len(days))}
+			cohorts[first] = c
+		}
+		c.users++
+		for i, n := range days {
+			if u.active[first+n] {
+				c.retained[i]++
+			}
+		}
+	}
+
+	out, err := openOutput(*outputPath)
+	if err == nil {
+		err = writeRetentionCSV(out, cohorts, days, lastDay, *counts, dialect)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		slog.Error("Cannot write cohorts", "output", displayPath(*outputPath), "error", err)
+		os.Exit(exitFatal)
+	}
+	slog.Info("Wrote cohorts", "cohorts", len(cohorts), "users", len(users), "output", displayPath(*outputPath))
+	if failed > 0 {
+		os.Exit(exitPartial)
+	}
+}
+
+// retentionUser is the days a user was active on, as days since the Unix
+// epoch in UTC.
+type retentionUser struct {
+	firstSeen  int
+	firstTouch int
+	touched    bool // firstTouch is known
+	active     map[int]bool
+}
+
+// retentionCohort counts the users that started on the same day, and how
+// many of them came back on each of the reported days.
+type retentionCohort struct {
+	users    int
+	retained []int
+}
+
+// utcDay returns the day of t in UTC, as days since the Unix epoch.
+func utcDay(t time.Time) int {
+	return int(t.UTC().Unix() / 86400)
+}
+
+// writeRetentionCSV writes a row per cohort, by its first day. A day that
+// is later than the last day with any events is left empty, as the cohort
+// hasn't had the chance to return on it yet.
+func writeRetentionCSV(w io.Writer, cohorts map[int]*retentionCohort, days []int, lastDay int, counts bool, dialect csvDialect) error {
+	cw := newCSVWriter(w, dialect)
+	header := []string{"cohort", "users"}
+	for _, n := range days {
+		header = append(header, "day_"+strconv.Itoa(n))
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	first := make([]int, 0, len(cohorts))
+	for day := range cohorts {
+		first = append(first, day)
+	}
+	sort.Ints(first)
+	for _, day := range first {
+		c := cohorts[day]
+		row := []string{time.Unix(int64(day)*86400, 0).UTC().Format(time.DateOnly), strconv.Itoa(c.users)}
+		for i, n := range days {
+			switch {
+			case day+n > lastDay:
+				row = append(row, "")
+			case counts:
+				row = append(row, strconv.Itoa(c.retained[i]))
+			default:
+				row = append(row, strconv.FormatFloat(float64(c.retained[i])*100/float64(c.users), 'f', 2, 64))
+			}
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
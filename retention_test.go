@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestUTCDay(t *testing.T) {
+	east := time.FixedZone("UTC+2", 2*3600)
+	if got := utcDay(time.Date(2026, 10, 16, 1, 0, 0, 0, east)); got != utcDay(time.Date(2026, 10, 15, 23, 0, 0, 0, time.UTC)) {
+		t.Fatalf("day %d isn't taken in UTC", got)
+	}
+	if got := utcDay(time.Unix(86400*3+1, 0)); got != 3 {
+		t.Fatalf("utcDay = %d, want 3", got)
+	}
+}
+
+func TestWriteRetentionCSV(t *testing.T) {
+	day := utcDay(time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC))
+	cohorts := map[int]*retentionCohort{
+		day + 1: {users: 2, retained: []int{0, 0}},
+		day:     {users: 4, retained: []int{3, 1}},
+	}
+	days := []int{1, 7}
+
+	var buf bytes.Buffer
+	if err := writeRetentionCSV(&buf, cohorts, days, day+7, false, csvDialect{}); err != nil {
+		t.Fatal(err)
+	}
+	want := "cohort,users,day_1,day_7\n" +
+		"2026-10-01,4,75.00,25.00\n" +
+		"2026-10-02,2,0.00,\n"
+	if buf.String() != want {
+		t.Fatalf("wrote %q, want %q", buf.String(), want)
+	}
+
+	buf.Reset()
+	if err := writeRetentionCSV(&buf, cohorts, days, day+7, true, csvDialect{}); err != nil {
+		t.Fatal(err)
+	}
+	want = "cohort,users,day_1,day_7\n" +
+		"2026-10-01,4,3,1\n" +
+		"2026-10-02,2,0,\n"
+	if buf.String() != want {
+		t.Fatalf("wrote %q, want %q", buf.String(), want)
+	}
+}
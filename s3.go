@@ -0,0 +1,297 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3Scheme prefixes Amazon S3 paths, e.g. s3://bucket/exports/.
+const s3Scheme = "s3://"
+
+func isS3Path(p string) bool {
+	return strings.HasPrefix(p, s3Scheme)
+}
+
+// splitS3Path splits s3://bucket/key into its bucket and key.
+func splitS3Path(p string) (bucket, key string) {
+	bucket, key, _ = strings.Cut(strings.TrimPrefix(p, s3Scheme), "/")
+	return bucket, key
+}
+
+// s3 talks to the S3 REST API over plain HTTP, signing requests with
+// Signature Version 4 with the credentials awsAuth finds; without any,
+// requests are sent unsigned, which works for public buckets. Set
+// AWS_ENDPOINT_URL_S3 (or AWS_ENDPOINT_URL) to use a compatible store such
+// as MinIO.
+var s3 = newS3Client()
+
+type s3Client struct {
+	endpoint string // custom endpoint, addressed path-style, if set
+	region   string
+	http     *http.Client
+}
+
+func newS3Client() *s3Client {
+	c := &s3Client{
+		region: os.Getenv("AWS_REGION"),
+		http:   &http.Client{},
+	}
+	if c.region == "" {
+		c.region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if c.region == "" {
+		c.region = profileRegion()
+	}
+	if c.region == "" {
+		c.region = "us-east-1"
+	}
+	for _, name := range []string{"AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL"} {
+		if endpoint := os.Getenv(name); endpoint != "" {
+			c.endpoint = strings.TrimSuffix(endpoint, "/")
+			break
+		}
+	}
+	return c
+}
+
+// objectURL returns the URL of a key, or of the bucket for an empty key.
+func (c *s3Client) objectURL(bucket, key string) *url.URL {
+	u := &url.URL{Scheme: "https", Host: fmt.Sprintf("%s.s3.%s.amazonaws.com", bucket, c.region), Path: "/" + key}
+	if c.endpoint != "" {
+		if parsed, err := url.Parse(c.endpoint); err == nil {
+			u.Scheme, u.Host, u.Path = parsed.Scheme, parsed.Host, parsed.Path+"/"+bucket+"/"+key
+		}
+	}
+	u.RawPath = awsEscape(u.Path, true)
+	return u
+}
+
+// do signs and sends a request and turns error responses into errors. The
+// caller closes the response body.
+func (c *s3Client) do(req *http.Request) (*http.Response, error) {
+	keys, err := awsAuth.credentials(c.region)
+	if err != nil {
+		return nil, err
+	}
+	if keys != nil {
+		c.sign(req, keys, time.Now().UTC())
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errObjectNotFound
+	}
+	var apiErr struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if xml.NewDecoder(resp.Body).Decode(&apiErr) == nil && apiErr.Message != "" {
+		return nil, fmt.Errorf("%s: %s: %s", resp.Status, apiErr.Code, apiErr.Message)
+	}
+	return nil, errors.New(resp.Status)
+}
+
+// emptyPayloadHash is the SHA-256 of an empty body, which all requests here
+// have.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// sign adds a Signature Version 4 Authorization header to a request without
+// a body. The query string is rewritten in its canonical form, so the URL
+// sent is the one signed.
+func (c *s3Client) sign(req *http.Request, keys *awsKeys, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+	if keys.token != "" {
+		req.Header.Set("X-Amz-Security-Token", keys.token)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	req.URL.RawQuery = canonicalQuery(req.URL.Query())
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		awsEscape(req.URL.Path, true),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		emptyPayloadHash,
+	}, "\n")
+	scope := date + "/" + c.region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + keys.secretKey)
+	for _, part := range []string{date, c.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		keys.accessKey, scope, signedHeaders, signature))
+}
+
+// canonicalQuery encodes query parameters sorted and escaped as Signature
+// Version 4 requires. Unlike url.Values.Encode, it escapes spaces as %20.
+func canonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var params []string
+	for _, name := range names {
+		values := append([]string(nil), query[name]...)
+		sort.Strings(values)
+		for _, value := range values {
+			params = append(params, awsEscape(name, false)+"="+awsEscape(value, false))
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// awsEscape percent-encodes everything but unreserved characters, and
+// slashes too unless keepSlash is set, as Signature Version 4 requires.
+func awsEscape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '.', c == '_', c == '~', c == '/' && keepSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// exists reports whether an object exists.
+func (c *s3Client) exists(bucket, key string) (bool, error) {
+	req, err := http.NewRequest(http.MethodHead, c.objectURL(bucket, key).String(), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := c.do(req)
+	if errors.Is(err, errObjectNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return true, nil
+}
+
+// list returns the keys of all objects starting with prefix, in
+// lexicographic order.
+func (c *s3Client) list(bucket, prefix string) ([]string, error) {
+	var keys []string
+	continuation := ""
+	for {
+		u := c.objectURL(bucket, "")
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if continuation != "" {
+			query.Set("continuation-token", continuation)
+		}
+		u.RawQuery = canonicalQuery(query)
+		req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.do(req)
+		if err != nil {
+			return nil, fmt.Errorf("cannot list %s%s/%s: %w", s3Scheme, bucket, prefix, err)
+		}
+
+		var page struct {
+			Contents []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("cannot list %s%s/%s: %w", s3Scheme, bucket, prefix, err)
+		}
+
+		for _, item := range page.Contents {
+			keys = append(keys, item.Key)
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return keys, nil
+		}
+		continuation = page.NextContinuationToken
+	}
+}
+
+// open streams an object's contents.
+func (c *s3Client) open(p string) (io.ReadCloser, error) {
+	bucket, key := splitS3Path(p)
+	req, err := http.NewRequest(http.MethodGet, c.objectURL(bucket, key).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open %s: %w", p, err)
+	}
+	return resp.Body, nil
+}
+
+// openRange streams part of an object's contents, as objectStore describes.
+func (c *s3Client) openRange(p string, offset, length int64) (io.ReadCloser, error) {
+	bucket, key := splitS3Path(p)
+	req, err := http.NewRequest(http.MethodGet, c.objectURL(bucket, key).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", byteRangeHeader(offset, length))
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open %s: %w", p, err)
+	}
+	return resp.Body, nil
+}
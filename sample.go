@@ -0,0 +1,111 @@
+package main
+
+import (
+	"hash/fnv"
+	"math/rand/v2"
+
+	"avroparser/pkg/avroconvert"
+)
+
+// sampling selects a subset of each input's records: only count records
+// from position offset on are read, of which the first skip records are
+// dropped, the rest are kept with probability rate, and reading stops after
+// limit records have been kept. offset and count are positions in the
+// input, before filtering, while skip and limit count the records that
+// passed it. Zero values select everything.
+type sampling struct {
+	offset int
+	count  int
+	skip   int
+	rate   float64
+	limit  int
+}
+
+// recordSampler applies a sampling to the records of one input. The random
+// choices are seeded from the input's name, so every pass over an input
+// keeps the same records and repeated runs produce the same extract.
+type recordSampler struct {
+	sampling
+	rng  *rand.Rand
+	seen int // records offered to keep
+	kept int
+}
+
+func newRecordSampler(s sampling, name string) *recordSampler {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return &recordSampler{sampling: s, rng: rand.New(rand.NewPCG(h.Sum64(), 0))}
+}
+
+// Done reports whether the limit has been reached.
+func (rs *recordSampler) Done() bool {
+	return rs.limit > 0 && rs.kept >= rs.limit
+}
+
+// Keep reports whether the next record is part of the sample.
+func (rs *recordSampler) Keep() bool {
+	rs.seen++
+	if rs.seen <= rs.skip {
+		return false
+	}
+	if rs.rate > 0 && rs.rate < 1 && rs.rng.Float64() >= rs.rate {
+		return false
+	}
+	rs.kept++
+	return true
+}
+
+// recordWindow reads the records of an input from position skip on, at
+// most count of them (all with 0), for -offset and -count.
+type recordWindow struct {
+	records avroconvert.RecordReader
+	skip    int
+	count   int
+	read    int
+}
+
+// windowRecords returns the records of reader from position skip on, at
+// most count of them, or reader itself when it reads them all. A reader
+// that keeps its records' bytes still does.
+func windowRecords(records avroconvert.RecordReader, skip, count int) avroconvert.RecordReader {
+	if skip == 0 && count == 0 {
+		return records
+	}
+	window := &recordWindow{records: records, skip: skip, count: count}
+	if raw, ok := records.(avroconvert.RawRecordReader); ok {
+		return rawRecordWindow{window, raw}
+	}
+	return window
+}
+
+func (rw *recordWindow) Scan() bool {
+	for ; rw.skip > 0; rw.skip-- {
+		if !rw.records.Scan() {
+			return false
+		}
+	}
+	if rw.count > 0 && rw.read >= rw.count {
+		return false
+	}
+	rw.read++
+	return rw.records.Scan()
+}
+
+func (rw *recordWindow) Read() (interface{}, error) {
+	return rw.records.Read()
+}
+
+func (rw *recordWindow) Err() error {
+	return rw.records.Err()
+}
+
+// rawRecordWindow is a recordWindow over a reader keeping its records'
+// bytes.
+type rawRecordWindow struct {
+	*recordWindow
+	raw avroconvert.RawRecordReader
+}
+
+func (rw rawRecordWindow) Raw() []byte {
+	return rw.raw.Raw()
+}
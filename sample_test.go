@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"avroparser/pkg/avroconvert"
+)
+
+// sampleIDs decodes records with ids 1 to n and returns the ids kept.
+func sampleIDs(t *testing.T, n int, s sampling) ([]string, avroconvert.Stats) {
+	t.Helper()
+	var msgs []string
+	for i := 1; i <= n; i++ {
+		msgs = append(msgs, fmt.Sprint(i))
+	}
+	opts := testOptions(t, "message")
+	opts.sampling = s
+
+	var out bytes.Buffer
+	stats, err := decodeMessages(bytes.NewReader(writeMessageOCF(t, msgs...)), "events.avro", opts, avroconvert.NewNDJSONSink(&out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Fields(out.String()), stats
+}
+
+func TestSampling(t *testing.T) {
+	ids, stats := sampleIDs(t, 10, sampling{skip: 3, limit: 4})
+	if want := []string{"4", "5", "6", "7"}; !reflect.DeepEqual(ids, want) || stats.Filtered != 3 {
+		t.Fatalf("kept %v (%+v), want %v", ids, stats, want)
+	}
+
+	first, _ := sampleIDs(t, 1000, sampling{rate: 0.1})
+	again, _ := sampleIDs(t, 1000, sampling{rate: 0.1})
+	if !reflect.DeepEqual(first, again) {
+		t.Fatal("sampling the same input twice kept different records")
+	}
+	if len(first) < 50 || len(first) > 150 {
+		t.Fatalf("kept %d of 1000 records at rate 0.1", len(first))
+	}
+
+	if ids, _ := sampleIDs(t, 1000, sampling{rate: 0.5, limit: 5}); len(ids) != 5 {
+		t.Fatalf("kept %d records with limit 5", len(ids))
+	}
+}
+
+func TestSamplingOffsetCount(t *testing.T) {
+	ids, _ := sampleIDs(t, 10, sampling{offset: 2, count: 5, skip: 1, limit: 3})
+	if want := []string{"4", "5", "6"}; !reflect.DeepEqual(ids, want) {
+		t.Fatalf("kept %v, want %v", ids, want)
+	}
+	if ids, _ := sampleIDs(t, 10, sampling{offset: 8, count: 5}); !reflect.DeepEqual(ids, []string{"9", "10"}) {
+		t.Fatalf("kept %v past the end", ids)
+	}
+	if ids, _ := sampleIDs(t, 10, sampling{offset: 20}); len(ids) != 0 {
+		t.Fatalf("kept %v with an offset past the end", ids)
+	}
+
+	// NDJSON input counts its lines the same way
+	opts := testOptions(t, "")
+	opts.sampling = sampling{offset: 1, count: 2}
+	var out bytes.Buffer
+	if _, err := decodeMessages(strings.NewReader("{\"id\":1}\n{\"id\":2}\n{\"id\":3}\n{\"id\":4}\n"), "events.ndjson", opts, avroconvert.NewNDJSONSink(&out)); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "{\"id\":2}\n{\"id\":3}\n" {
+		t.Fatalf("NDJSON window %q", out.String())
+	}
+}
+
+// TestOCFRecordReaderOffset checks the records before an offset are passed
+// over, within a block and across whole blocks, by any number of workers.
+func TestOCFRecordReaderOffset(t *testing.T) {
+	const n = 1000
+	data := writeTestOCF(t, ocfCodecDeflate, n, nil, nil)
+	for _, workers := range []int{1, 4} {
+		for _, offset := range []int{0, 1, 37, 500, n - 1, n, n + 5} {
+			rr, err := newOCFRecordReader(bytes.NewReader(data), workers, offset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			next := int64(offset)
+			for rr.Scan() {
+				v, err := rr.Read()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if id := v.(map[string]interface{})["id"].(int64); id != next {
+					t.Fatalf("workers %d, offset %d: read %d, want %d", workers, offset, id, next)
+				}
+				next++
+			}
+			rr.Close()
+			if err := rr.Err(); err != nil {
+				t.Fatal(err)
+			}
+			if next != max(n, int64(offset)) {
+				t.Fatalf("workers %d, offset %d: stopped before %d", workers, offset, next)
+			}
+		}
+	}
+}
+
+func TestSamplingFlags(t *testing.T) {
+	for _, args := range [][]string{{"-offset", "-1"}, {"-count", "-2"}, {"-skip", "-1"}, {"-sample-rate", "0"}} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		records := addRecordFlags(fs)
+		if err := fs.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := records.sampling(); err == nil {
+			t.Errorf("%v: accepted", args)
+		}
+	}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	records := addRecordFlags(fs)
+	fs.Parse([]string{"-offset", "10", "-count", "5"})
+	if s, err := records.sampling(); err != nil || s != (sampling{offset: 10, count: 5, rate: 1}) {
+		t.Errorf("sampling %+v, %v", s, err)
+	}
+}
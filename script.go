@@ -0,0 +1,298 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	starlarkjson "go.starlark.net/lib/json"
+	starlarkmath "go.starlark.net/lib/math"
+	starlarktime "go.starlark.net/lib/time"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// recordScript is a -script Starlark program. Its transform function runs on
+// every message before it is written and, like a -transform program, may
+// change it, drop it or split it into several messages, but with the
+// statements, lookup tables and date arithmetic of a Python-like language.
+type recordScript struct {
+	path      string
+	thread    *starlark.Thread
+	transform starlark.Callable
+}
+
+// scriptOptions are the Starlark dialect scripts are written in: top-level
+// if and for statements and while loops are allowed, so lookup tables can be
+// built as the script is loaded.
+var scriptOptions = &syntax.FileOptions{Set: true, While: true, TopLevelControl: true, GlobalReassign: true}
+
+// loadScript runs a Starlark file, whose global variables, frozen, are then
+// shared by every call of its transform function.
+func loadScript(path string) (*recordScript, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read script: %w", err)
+	}
+	thread := &starlark.Thread{
+		Name: path,
+		Print: func(_ *starlark.Thread, msg string) {
+			slog.Info(msg, "script", path)
+		},
+	}
+	predeclared := starlark.StringDict{
+		"json":      starlarkjson.Module,
+		"math":      starlarkmath.Module,
+		"time":      starlarktime.Module,
+		"read_csv":  starlark.NewBuiltin("read_csv", scriptReadCSV),
+		"read_file": starlark.NewBuiltin("read_file", scriptReadFile),
+	}
+	globals, err := starlark.ExecFileOptions(scriptOptions, thread, path, src, predeclared)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load script: %w", scriptError(err))
+	}
+	transform, ok := globals["transform"].(starlark.Callable)
+	if !ok {
+		return nil, fmt.Errorf("script %s does not define a transform(record) function", path)
+	}
+	return &recordScript{path: path, thread: thread, transform: transform}, nil
+}
+
+// apply calls the script's transform function on a JSON message. It returns
+// the record, a list of records or None to drop it.
+func (rs *recordScript) apply(msg json.RawMessage) ([]json.RawMessage, error) {
+	v, err := parseMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+	arg, err := toStarlark(v)
+	if err != nil {
+		return nil, err
+	}
+	result, err := starlark.Call(rs.thread, rs.transform, starlark.Tuple{arg}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("script %s: %w", rs.path, scriptError(err))
+	}
+
+	var results []starlark.Value
+	switch t := result.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case *starlark.List:
+		for i := 0; i < t.Len(); i++ {
+			results = append(results, t.Index(i))
+		}
+	default:
+		results = []starlark.Value{result}
+	}
+	outputs := make([]json.RawMessage, 0, len(results))
+	for _, r := range results {
+		v, err := fromStarlark(r)
+		if err != nil {
+			return nil, fmt.Errorf("script %s: transform returned %w", rs.path, err)
+		}
+		text, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("script %s: %w", rs.path, err)
+		}
+		outputs = append(outputs, text)
+	}
+	return outputs, nil
+}
+
+// scriptError adds the script position an evaluation error happened at, in
+// the script rather than a built-in function, to its message.
+func scriptError(err error) error {
+	var evalErr *starlark.EvalError
+	if !errors.As(err, &evalErr) {
+		return err
+	}
+	for i := 0; i < len(evalErr.CallStack); i++ {
+		if pos := evalErr.CallStack.At(i).Pos; pos.Filename() != "<builtin>" {
+			return fmt.Errorf("%s: %s", pos, evalErr.Msg)
+		}
+	}
+	return err
+}
+
+// toStarlark converts a parsed JSON value into Starlark values: objects into
+// dicts, arrays into lists, and numbers into ints, of any size, unless they
+// have a fraction or exponent.
+func toStarlark(v interface{}) (starlark.Value, error) {
+	switch t := v.(type) {
+	case nil:
+		return starlark.None, nil
+	case bool:
+		return starlark.Bool(t), nil
+	case string:
+		return starlark.String(t), nil
+	case json.Number:
+		s := t.String()
+		if !strings.ContainsAny(s, ".eE") {
+			if n, ok := new(big.Int).SetString(s, 10); ok {
+				return starlark.MakeBigInt(n), nil
+			}
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s", s)
+		}
+		return starlark.Float(f), nil
+	case map[string]interface{}:
+		d := starlark.NewDict(len(t))
+		for k, item := range t {
+			sv, err := toStarlark(item)
+			if err != nil {
+				return nil, err
+			}
+			if err := d.SetKey(starlark.String(k), sv); err != nil {
+				return nil, err
+			}
+		}
+		return d, nil
+	case []interface{}:
+		items := make([]starlark.Value, len(t))
+		for i, item := range t {
+			sv, err := toStarlark(item)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = sv
+		}
+		return starlark.NewList(items), nil
+	}
+	return nil, fmt.Errorf("unexpected JSON value %T", v)
+}
+
+// fromStarlark converts a Starlark value back into one json.Marshal writes.
+// Times are written in RFC 3339 and durations as their text, e.g. 1h30m0s.
+func fromStarlark(v starlark.Value) (interface{}, error) {
+	switch t := v.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		return bool(t), nil
+	case starlark.String:
+		return string(t), nil
+	case starlark.Bytes:
+		return string(t), nil
+	case starlark.Int:
+		return json.Number(t.String()), nil
+	case starlark.Float:
+		if math.IsNaN(float64(t)) || math.IsInf(float64(t), 0) {
+			return nil, fmt.Errorf("the number %v, which JSON cannot hold", t)
+		}
+		return float64(t), nil
+	case starlarktime.Time:
+		return time.Time(t).Format(time.RFC3339Nano), nil
+	case starlarktime.Duration:
+		return time.Duration(t).String(), nil
+	case *starlark.Dict:
+		m := make(map[string]interface{}, t.Len())
+		for _, item := range t.Items() {
+			k, ok := item[0].(starlark.String)
+			if !ok {
+				return nil, fmt.Errorf("a dict with a %s key, where JSON objects need strings", item[0].Type())
+			}
+			value, err := fromStarlark(item[1])
+			if err != nil {
+				return nil, err
+			}
+			m[string(k)] = value
+		}
+		return m, nil
+	case starlark.Indexable: // lists and tuples
+		items := make([]interface{}, t.Len())
+		for i := range items {
+			value, err := fromStarlark(t.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			items[i] = value
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("a %s, which cannot be written as JSON", v.Type())
+}
+
+// scriptReadFile is the read_file(path) built-in, returning a file's content
+// as a string, e.g. for json.decode.
+func scriptReadFile(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var path string
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &path); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	return starlark.String(data), nil
+}
+
+// scriptReadCSV is the read_csv(path, key=None) built-in. It returns the
+// rows of a CSV file with a header as a list of dicts of strings, or with
+// key a dict of them by that column, for lookup tables.
+func scriptReadCSV(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var path, key string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "path", &path, "key?", &key); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%s: cannot read header of %s: %w", b.Name(), path, err)
+	}
+	keyColumn := -1
+	for i, name := range header {
+		if name == key {
+			keyColumn = i
+		}
+	}
+	if key != "" && keyColumn < 0 {
+		return nil, fmt.Errorf("%s: %s has no %q column", b.Name(), path, key)
+	}
+
+	var rows []starlark.Value
+	byKey := starlark.NewDict(0)
+	for {
+		record, err := r.Read()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("%s: %w", b.Name(), err)
+		}
+		row := starlark.NewDict(len(header))
+		for i, name := range header {
+			value := starlark.Value(starlark.None)
+			if i < len(record) {
+				value = starlark.String(record[i])
+			}
+			row.SetKey(starlark.String(name), value)
+		}
+		if keyColumn < 0 {
+			rows = append(rows, row)
+		} else if keyColumn < len(record) {
+			byKey.SetKey(starlark.String(record[keyColumn]), row)
+		}
+	}
+	if keyColumn >= 0 {
+		return byKey, nil
+	}
+	return starlark.NewList(rows), nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func loadTestScript(t *testing.T, src string) *recordScript {
+	t.Helper()
+	rs, err := loadScript(writeTestFile(t, "transform.star", []byte(src)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rs
+}
+
+func TestScriptApply(t *testing.T) {
+	items := writeTestFile(t, "items.csv", []byte("id,name\n42,Sword\n7,Shield\n"))
+	rs := loadTestScript(t, `
+items = read_csv("`+items+`", key="id")
+
+def transform(record):
+    if record["kind"] == "noise":
+        return None
+    if record["kind"] == "bundle":
+        return [{"id": i} for i in record["ids"]]
+    record["item_name"] = items[str(record["item_id"])]["name"]
+    record["half"] = record["item_id"] / 2
+    return record
+`)
+	tests := []struct {
+		msg  string
+		want []string
+	}{
+		{`{"kind":"buy","item_id":42,"user":9007199254740993}`, []string{`{"half":21,"item_id":42,"item_name":"Sword","kind":"buy","user":9007199254740993}`}},
+		{`{"kind":"noise"}`, nil},
+		{`{"kind":"bundle","ids":[1,2]}`, []string{`{"id":1}`, `{"id":2}`}},
+	}
+	for _, tt := range tests {
+		got, err := rs.apply(json.RawMessage(tt.msg))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.msg, err)
+		}
+		var texts []string
+		for _, msg := range got {
+			texts = append(texts, string(msg))
+		}
+		if strings.Join(texts, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("%s: got %s, want %s", tt.msg, texts, tt.want)
+		}
+	}
+
+	// A failing call names the script line
+	_, err := rs.apply(json.RawMessage(`{"kind":"buy","item_id":1}`))
+	if err == nil || !strings.Contains(err.Error(), "transform.star:9:") {
+		t.Fatalf("unknown item returned %v", err)
+	}
+}
+
+func TestScriptResults(t *testing.T) {
+	rs := loadTestScript(t, `
+def transform(record):
+    if record.get("t"):
+        return {"at": time.from_timestamp(record["t"]), "d": time.parse_duration("90m")}
+    if record.get("nan"):
+        return {"x": float("nan")}
+    return {1: "a"}
+`)
+	if _, err := rs.apply(json.RawMessage(`{}`)); err == nil || !strings.Contains(err.Error(), "a dict with a int key") {
+		t.Errorf("an int key returned %v", err)
+	}
+	got, err := rs.apply(json.RawMessage(`{"t":1700000000}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || string(got[0]) != `{"at":"2023-11-14T22:13:20Z","d":"1h30m0s"}` {
+		t.Errorf("times written as %s", got)
+	}
+	if _, err := rs.apply(json.RawMessage(`{"nan":true}`)); err == nil || !strings.Contains(err.Error(), "JSON cannot hold") {
+		t.Errorf("NaN returned %v", err)
+	}
+}
+
+func TestLoadScriptErrors(t *testing.T) {
+	tests := []struct{ src, want string }{
+		{"def transform(record):\n    return record\n", ""},
+		{"x = 1\n", "does not define a transform"},
+		{"def transform(record:\n", "cannot load script"},
+		{"fail('no table')\ndef transform(r):\n    return r\n", "no table"},
+	}
+	for _, tt := range tests {
+		_, err := loadScript(writeTestFile(t, "transform.star", []byte(tt.src)))
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("%q: %v", tt.src, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: error %v, want %q", tt.src, err, tt.want)
+		}
+	}
+}
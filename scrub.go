@@ -0,0 +1,539 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"avroparser/pkg/avroconvert"
+)
+
+// Ways -mode scrubs the records of the listed users.
+const (
+	scrubRemove = "remove" // drop the records
+	scrubNull   = "null"   // keep the records with their user ID fields null
+)
+
+func runScrub(args []string) {
+	fs := flag.NewFlagSet("scrub", flag.ExitOnError)
+	ids := fs.String("ids", "", "Comma-separated user IDs whose records are scrubbed")
+	idsFile := fs.String("ids-file", "", "File of user IDs whose records are scrubbed, one per line (# starts a comment)")
+	idFields := fs.String("id-fields", "user_pseudo_id,playerID", "Comma-separated field paths holding the user ID; a record matching any of them is scrubbed")
+	field := fs.String("field", "", "Look up -id-fields in this record field's embedded JSON messages instead of the whole record (e.g. message)")
+	mode := fs.String("mode", scrubRemove, "What to do with the users' records: remove, or null to keep them with their -id-fields null")
+	outputDir := fs.String("output", "", "Directory to write the scrubbed files to, under their input names")
+	inPlace := fs.Bool("in-place", false, "Replace the input files with their scrubbed versions; files without matching records are left untouched")
+	auditPath := fs.String("audit", stdioPath, "NDJSON audit log to append a line per file to, or - for stdout")
+	logging := addLogFlags(fs)
+	parseFlags(fs, args)
+	logging.setup()
+
+	if fs.NArg() == 0 || (*outputDir == "") == !*inPlace {
+		fmt.Fprintln(os.Stderr, "Usage: avroparser scrub -ids <id,...>|-ids-file <file> (-output <dir>|-in-place) [-mode remove|null] [-audit <file>] <avro_or_ndjson_file|dir|glob>...")
+		os.Exit(exitFatal)
+	}
+	if *mode != scrubRemove && *mode != scrubNull {
+		fmt.Fprintf(os.Stderr, "Unknown scrub mode %q (expected remove or null)\n", *mode)
+		os.Exit(exitFatal)
+	}
+	users, err := loadUserIDs(*ids, *idsFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	s := &scrubber{ids: users, field: *field, null: *mode == scrubNull}
+	for _, path := range splitFieldList(*idFields) {
+		if path == "" || strings.HasPrefix(path, ".") || strings.HasSuffix(path, ".") || strings.Contains(path, "..") {
+			fmt.Fprintf(os.Stderr, "Invalid -id-fields path %q\n", path)
+			os.Exit(exitFatal)
+		}
+		s.paths = append(s.paths, strings.Split(path, "."))
+	}
+	if len(s.paths) == 0 {
+		fmt.Fprintln(os.Stderr, "-id-fields must name at least one field")
+		os.Exit(exitFatal)
+	}
+
+	var inputs []inputFile
+	for _, arg := range fs.Args() {
+		inputs = append(inputs, mustExpandInputs(arg)...)
+	}
+	if *inPlace {
+		for _, in := range inputs {
+			if in.path == stdioPath || isRemotePath(in.path) {
+				fmt.Fprintf(os.Stderr, "-in-place only rewrites local files, not %s\n", displayPath(in.path))
+				os.Exit(exitFatal)
+			}
+		}
+	}
+
+	audit, err := appendOutput(*auditPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open audit log: %v\n", err)
+		os.Exit(exitFatal)
+	}
+
+	failed := 0
+	for _, in := range inputs {
+		output := in.path
+		if !*inPlace {
+			output = scrubOutputPath(in, *outputDir)
+		}
+		entry := scrubAudit{Time: time.Now().UTC(), Input: in.path, Output: displayPath(output), Mode: *mode, Status: "ok"}
+		result, err := s.scrubFile(in.path, output, *inPlace)
+		entry.Records, entry.Affected = result.records, result.affected
+		if err != nil {
+			slog.Error("Cannot scrub input", "input", displayPath(in.path), "error", err)
+			entry.Status, entry.Error = "failed", err.Error()
+			failed++
+		} else {
+			slog.Info("Scrubbed file", "input", displayPath(in.path), "records", result.records, "affected", result.affected, "output", displayPath(output))
+		}
+		if err := writeScrubAudit(audit, entry); err != nil {
+			slog.Error("Cannot write audit log", "error", err)
+			os.Exit(exitFatal)
+		}
+	}
+	if err := audit.Close(); err != nil {
+		slog.Error("Cannot write audit log", "error", err)
+		os.Exit(exitFatal)
+	}
+
+	switch {
+	case failed == len(inputs):
+		os.Exit(exitFatal)
+	case failed > 0:
+		os.Exit(exitPartial)
+	}
+}
+
+// loadUserIDs collects the IDs given with -ids and in the -ids-file.
+func loadUserIDs(list, path string) (map[string]bool, error) {
+	ids := make(map[string]bool)
+	for _, id := range strings.Split(list, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids[id] = true
+		}
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read -ids-file: %w", err)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			line, _, _ = strings.Cut(line, "#")
+			if line = strings.TrimSpace(line); line != "" {
+				ids[line] = true
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return nil, errors.New("no user IDs given with -ids or -ids-file")
+	}
+	return ids, nil
+}
+
+// scrubOutputPath is where the scrubbed version of an input is written:
+// under its input name, compression extension included, in outputDir.
+func scrubOutputPath(in inputFile, outputDir string) string {
+	if isGCSPath(outputDir) {
+		return strings.TrimSuffix(outputDir, "/") + "/" + filepath.ToSlash(in.rel)
+	}
+	return filepath.Join(outputDir, in.rel)
+}
+
+// scrubAudit is a line of the audit log.
+type scrubAudit struct {
+	Time     time.Time `json:"time"`
+	Input    string    `json:"input"`
+	Output   string    `json:"output"`
+	Mode     string    `json:"mode"`
+	Status   string    `json:"status"` // ok or failed
+	Error    string    `json:"error,omitempty"`
+	Records  int       `json:"records"`
+	Affected int       `json:"affected"` // records removed or nulled
+}
+
+func writeScrubAudit(w io.Writer, entry scrubAudit) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(line, '\n'))
+	return err
+}
+
+// scrubber removes or nulls the records of a set of users.
+type scrubber struct {
+	ids   map[string]bool
+	paths [][]string // field paths holding the user ID
+	field string     // record field with the JSON message the paths are in, if set
+	null  bool       // null the ID fields instead of removing the records
+}
+
+// scrubResult counts the records of a scrubbed file.
+type scrubResult struct {
+	records  int
+	affected int
+}
+
+// matches reports whether a JSON value belongs to one of the users.
+func (s *scrubber) matches(v interface{}) bool {
+	for _, path := range s.paths {
+		if id := lookupPath(v, path); id != nil && s.ids[countKey(id)] {
+			return true
+		}
+	}
+	return false
+}
+
+// message returns the JSON value the ID paths of a parsed NDJSON record
+// are looked up in: the record, or its -field, parsed if it is JSON text.
+// ok is false when the field is missing or not JSON.
+func (s *scrubber) message(record interface{}) (v interface{}, ok bool) {
+	if s.field == "" {
+		return record, true
+	}
+	m, isMap := record.(map[string]interface{})
+	if !isMap {
+		return nil, false
+	}
+	switch t := m[s.field].(type) {
+	case map[string]interface{}:
+		return t, true
+	case string:
+		v, err := parseMessage(json.RawMessage(t))
+		return v, err == nil
+	}
+	return nil, false
+}
+
+// nativeMessage returns the JSON message in the -field of a goavro native
+// record, and the union branch the field's value is in, if any. ok is false
+// when the field doesn't hold JSON text.
+func (s *scrubber) nativeMessage(schema *avroconvert.Schema, record interface{}) (v interface{}, branch string, ok bool) {
+	m, isMap := record.(map[string]interface{})
+	f := schema.Field(s.field)
+	if !isMap || f == nil {
+		return nil, "", false
+	}
+	value := m[s.field]
+	if f.Schema.Kind == "union" {
+		var bs *avroconvert.Schema
+		if bs, value = avroconvert.UnwrapUnion(f.Schema, value); bs != nil {
+			branch = avroconvert.UnionBranchName(bs)
+		}
+	}
+	var text []byte
+	switch t := value.(type) {
+	case string:
+		text = []byte(t)
+	case []byte:
+		text = t
+	default:
+		return nil, "", false
+	}
+	v, err := parseMessage(json.RawMessage(text))
+	return v, branch, err == nil
+}
+
+// nullIDs sets the ID fields of a JSON value to null.
+func (s *scrubber) nullIDs(v interface{}) {
+	for _, path := range s.paths {
+		if m, ok := lookupPath(v, path[:len(path)-1]).(map[string]interface{}); ok {
+			if _, ok := m[path[len(path)-1]]; ok {
+				m[path[len(path)-1]] = nil
+			}
+		}
+	}
+}
+
+// scrubFile writes the scrubbed version of a container or NDJSON file to
+// output. In place, the input is only replaced, through a temporary file
+// next to it, when some of its records were affected.
+func (s *scrubber) scrubFile(input, output string, inPlace bool) (scrubResult, error) {
+	var result scrubResult
+	r, err := openInput(input)
+	if err != nil {
+		return result, err
+	}
+	defer r.Close()
+	br := bufio.NewReader(r)
+	format, err := avroconvert.SniffFormat(br)
+	if err != nil {
+		return result, fmt.Errorf("cannot read input: %w", err)
+	}
+
+	target := output
+	if inPlace {
+		target = output + ".scrub-tmp"
+	}
+	out, err := openOutput(target)
+	if err != nil {
+		return result, err
+	}
+	w, err := compressOutput(out, outputCompression(output))
+	if err != nil {
+		abortOutput(out)
+		return result, err
+	}
+
+	switch format {
+	case avroconvert.FormatAvro:
+		result, err = s.scrubOCF(br, w)
+	case avroconvert.FormatNDJSON:
+		result, err = s.scrubNDJSON(br, w)
+	default:
+		err = errors.New("JSON array input is not supported; convert it to NDJSON first")
+	}
+	if err != nil {
+		abortOutput(w)
+		return result, err
+	}
+	if err := w.Close(); err != nil {
+		if inPlace {
+			os.Remove(target)
+		}
+		return result, fmt.Errorf("cannot write output file: %w", err)
+	}
+
+	if inPlace {
+		if result.affected == 0 {
+			os.Remove(target)
+			return result, nil
+		}
+		if err := os.Rename(target, output); err != nil {
+			os.Remove(target)
+			return result, fmt.Errorf("cannot replace input: %w", err)
+		}
+	}
+	return result, nil
+}
+
+// outputCompression returns the compression a file name's extension asks
+// for, so scrubbed files are compressed like their inputs.
+func outputCompression(name string) string {
+	switch lower := strings.ToLower(name); {
+	case strings.HasSuffix(lower, ".gz"), strings.HasSuffix(lower, ".gzip"):
+		return compressGzip
+	case strings.HasSuffix(lower, ".zst"), strings.HasSuffix(lower, ".zstd"):
+		return compressZstd
+	}
+	return compressNone
+}
+
+// scrubOCF copies a container file with the same schema and codec, leaving
+// out or nulling the users' records. Records that are kept unchanged are
+// copied without being encoded again. A record that cannot be decoded
+// fails the file, since it might be one of the users'.
+func (s *scrubber) scrubOCF(r io.Reader, w io.Writer) (scrubResult, error) {
+	var result scrubResult
+	records, err := newOCFRecordReader(r, 0, 0)
+	if err != nil {
+		return result, err
+	}
+	defer records.Close()
+	schema, err := avroconvert.ParseSchema(records.codec.Schema())
+	if err != nil {
+		return result, fmt.Errorf("cannot parse writer schema: %w", err)
+	}
+	converter, err := avroconvert.NewJSONConverter(avroconvert.ConverterOptions{TimeZone: "UTC"})
+	if err != nil {
+		return result, err
+	}
+	ow, err := newOCFWriter(w, records.codec.Schema(), records.blocks.header.codec())
+	if err != nil {
+		return result, err
+	}
+
+	for records.Scan() {
+		record, err := records.Read()
+		if err != nil {
+			return result, fmt.Errorf("record %d: %w", result.records+1, err)
+		}
+		result.records++
+		var v interface{}
+		ok := true
+		if s.field == "" {
+			v = converter.Value(schema, record)
+		} else {
+			v, _, ok = s.nativeMessage(schema, record)
+		}
+		if !ok || !s.matches(v) {
+			if err := ow.writeEncoded(1, records.Raw()); err != nil {
+				return result, err
+			}
+			continue
+		}
+		result.affected++
+		if !s.null {
+			continue
+		}
+		if err := s.nullNative(schema, record); err != nil {
+			return result, fmt.Errorf("record %d: %w", result.records, err)
+		}
+		if err := ow.Write(record); err != nil {
+			return result, fmt.Errorf("record %d: %w", result.records, err)
+		}
+	}
+	if err := records.Err(); err != nil {
+		return result, err
+	}
+	return result, ow.Close()
+}
+
+// nullNative nulls the ID fields of a goavro native record, or of the JSON
+// message in its -field.
+func (s *scrubber) nullNative(schema *avroconvert.Schema, record interface{}) error {
+	m, ok := record.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("record is a %T", record)
+	}
+	if s.field == "" {
+		for _, path := range s.paths {
+			if err := nullNativeField(schema, m, path); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// The message is written back as the same type, in the same union
+	// branch
+	v, branch, ok := s.nativeMessage(schema, record)
+	if !ok {
+		return fmt.Errorf("field %s doesn't hold a JSON message", s.field)
+	}
+	s.nullIDs(v)
+	text, err := marshalMessage(v)
+	if err != nil {
+		return err
+	}
+	value := m[s.field]
+	if branch != "" {
+		value = value.(map[string]interface{})[branch]
+	}
+	if _, ok := value.(string); ok {
+		value = string(text)
+	} else {
+		value = text
+	}
+	if branch != "" {
+		value = map[string]interface{}{branch: value}
+	}
+	m[s.field] = value
+	return nil
+}
+
+// marshalMessage renders a JSON value without escaping HTML characters,
+// as the messages are written by the apps.
+func marshalMessage(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// nullNativeField nulls a field of a native record by its path, following
+// nested records. The field must be a union with null.
+func nullNativeField(s *avroconvert.Schema, m map[string]interface{}, path []string) error {
+	f := s.Field(path[0])
+	if f == nil {
+		return nil
+	}
+	value, fs := m[path[0]], f.Schema
+	if len(path) == 1 {
+		if value == nil {
+			return nil
+		}
+		if fs.Kind == "union" {
+			for _, branch := range fs.Branches {
+				if branch.Kind == "null" {
+					m[path[0]] = nil
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("field %s cannot be nulled: it is not nullable", f.Name)
+	}
+	if fs.Kind == "union" {
+		if fs, value = avroconvert.UnwrapUnion(fs, value); fs == nil {
+			return nil
+		}
+	}
+	nested, ok := value.(map[string]interface{})
+	if !ok || fs.Kind != "record" {
+		return nil
+	}
+	return nullNativeField(fs, nested, path[1:])
+}
+
+// scrubNDJSON copies NDJSON records, leaving out or nulling the users'
+// records. Lines that are kept unchanged are copied byte for byte.
+func (s *scrubber) scrubNDJSON(r *bufio.Reader, w io.Writer) (scrubResult, error) {
+	var result scrubResult
+	bw := bufio.NewWriter(w)
+	for line := 1; ; line++ {
+		text, err := r.ReadBytes('\n')
+		if len(text) > 0 {
+			if err := s.scrubLine(text, bw, &result); err != nil {
+				return result, fmt.Errorf("line %d: %w", line, err)
+			}
+		}
+		if err == io.EOF {
+			return result, bw.Flush()
+		}
+		if err != nil {
+			return result, fmt.Errorf("cannot read input: %w", err)
+		}
+	}
+}
+
+func (s *scrubber) scrubLine(text []byte, w *bufio.Writer, result *scrubResult) error {
+	if len(bytes.TrimSpace(text)) == 0 {
+		_, err := w.Write(text)
+		return err
+	}
+	record, err := parseMessage(text)
+	if err != nil {
+		return err
+	}
+	result.records++
+	v, ok := s.message(record)
+	if !ok || !s.matches(v) {
+		_, err := w.Write(text)
+		return err
+	}
+	result.affected++
+	if !s.null {
+		return nil
+	}
+
+	s.nullIDs(v)
+	m, _ := record.(map[string]interface{})
+	if _, isText := m[s.field].(string); s.field != "" && isText {
+		message, err := marshalMessage(v)
+		if err != nil {
+			return err
+		}
+		m[s.field] = string(message)
+	}
+	line, err := marshalMessage(record)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(line, '\n'))
+	return err
+}
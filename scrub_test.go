@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/linkedin/goavro/v2"
+)
+
+func TestLoadUserIDs(t *testing.T) {
+	path := writeTestFile(t, "ids.txt", []byte("# erasure requests\nu-3\n\n  u-4  # by mail\n"))
+	ids, err := loadUserIDs("u-1, u-2,", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]bool{"u-1": true, "u-2": true, "u-3": true, "u-4": true}; !reflect.DeepEqual(ids, want) {
+		t.Fatalf("loaded %v", ids)
+	}
+	if _, err := loadUserIDs(" , ", ""); err == nil {
+		t.Fatal("loaded no IDs")
+	}
+}
+
+// testScrubber scrubs the users u-1 and 7 by user_pseudo_id and playerID.
+func testScrubber(field string, null bool) *scrubber {
+	return &scrubber{
+		ids:   map[string]bool{"u-1": true, "7": true},
+		paths: [][]string{{"user_pseudo_id"}, {"player", "id"}},
+		field: field,
+		null:  null,
+	}
+}
+
+func TestScrubNDJSON(t *testing.T) {
+	input := `{"user_pseudo_id": "u-1", "n": 1}` + "\n" +
+		`{"user_pseudo_id": "u-2",  "n": 2}` + "\n\n" +
+		`{"player": {"id": 7}, "n": 3, "html": "<b>"}` + "\n"
+	dir := t.TempDir()
+	in := writeTestFile(t, "events.ndjson", []byte(input))
+
+	for _, tc := range []struct {
+		null bool
+		want string
+	}{
+		// Kept lines are copied byte for byte
+		{false, `{"user_pseudo_id": "u-2",  "n": 2}` + "\n\n"},
+		{true, `{"n":1,"user_pseudo_id":null}` + "\n" + `{"user_pseudo_id": "u-2",  "n": 2}` + "\n\n" + `{"html":"<b>","n":3,"player":{"id":null}}` + "\n"},
+	} {
+		output := filepath.Join(dir, "out.ndjson")
+		result, err := testScrubber("", tc.null).scrubFile(in, output, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result != (scrubResult{records: 3, affected: 2}) || string(data) != tc.want {
+			t.Fatalf("null %v: scrubbed %+v to %q, want %q", tc.null, result, data, tc.want)
+		}
+	}
+
+	// Messages embedded in a field are scrubbed inside it
+	in = writeTestFile(t, "messages.ndjson", []byte(`{"message": "{\"user_pseudo_id\":\"u-1\"}"}`+"\n"))
+	output := filepath.Join(dir, "messages.ndjson")
+	if _, err := testScrubber("message", true).scrubFile(in, output, false); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(output); string(data) != `{"message":"{\"user_pseudo_id\":null}"}`+"\n" {
+		t.Fatalf("scrubbed message to %s", data)
+	}
+}
+
+func TestScrubOCF(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := goavro.NewOCFWriter(goavro.OCFConfig{W: &buf, Schema: eventSchema, CompressionName: goavro.CompressionDeflateLabel})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, user := range []interface{}{goavro.Union("string", "u-1"), goavro.Union("string", "u-2"), nil} {
+		event := testEvent(int64(i))
+		event["user"] = user
+		if err := w.Append([]interface{}{event}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	in := writeTestFile(t, "events.avro", buf.Bytes())
+
+	s := testScrubber("", true)
+	s.paths = [][]string{{"user"}}
+	output := filepath.Join(t.TempDir(), "events.avro")
+	result, err := s.scrubFile(in, output, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != (scrubResult{records: 3, affected: 1}) {
+		t.Fatalf("scrubbed %+v", result)
+	}
+	var users []interface{}
+	for _, id := range readOCFRecords(t, output) {
+		users = append(users, id["user"])
+	}
+	if want := []interface{}{nil, map[string]interface{}{"string": "u-2"}, nil}; !reflect.DeepEqual(users, want) {
+		t.Fatalf("users %v", users)
+	}
+
+	// Fields that aren't nullable cannot be nulled
+	s.paths = [][]string{{"id"}}
+	s.ids = map[string]bool{"1": true}
+	failed := filepath.Join(t.TempDir(), "events.avro")
+	if _, err := s.scrubFile(in, failed, false); err == nil || !strings.Contains(err.Error(), "not nullable") {
+		t.Fatalf("nulled a field that isn't nullable: %v", err)
+	}
+	checkNoOutputs(t, filepath.Dir(failed))
+
+	// In place, only files with affected records are replaced
+	s.null = false
+	s.ids = map[string]bool{"99": true}
+	before, _ := os.Stat(in)
+	if result, err := s.scrubFile(in, in, true); err != nil || result.affected != 0 {
+		t.Fatalf("scrubbed %+v: %v", result, err)
+	}
+	after, _ := os.Stat(in)
+	if !after.ModTime().Equal(before.ModTime()) {
+		t.Fatal("replaced a file without affected records")
+	}
+	s.ids = map[string]bool{"1": true}
+	if result, err := s.scrubFile(in, in, true); err != nil || result.affected != 1 {
+		t.Fatalf("scrubbed %+v: %v", result, err)
+	}
+	if records := readOCFRecords(t, in); len(records) != 2 {
+		t.Fatalf("%d records left", len(records))
+	}
+	if _, err := os.Stat(in + ".scrub-tmp"); !os.IsNotExist(err) {
+		t.Fatalf("left the temporary file: %v", err)
+	}
+}
+
+// readOCFRecords returns the records of a container file.
+func readOCFRecords(t *testing.T, path string) []map[string]interface{} {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := goavro.NewOCFReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []map[string]interface{}
+	for r.Scan() {
+		v, err := r.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, v.(map[string]interface{}))
+	}
+	return records
+}
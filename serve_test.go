@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"avroparser/pkg/avroconvert"
+	avroparserv1 "avroparser/proto/avroparser/v1"
+	"github.com/linkedin/goavro/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const serveSchema = `{"type":"record","name":"Event","fields":[{"name":"id","type":"long"},{"name":"at","type":{"type":"long","logicalType":"timestamp-millis"}}]}`
+
+// testDecodeClient serves the decode service in memory, as runServe does,
+// and returns a client of it.
+func testDecodeClient(t *testing.T, registry *schemaRegistry) avroparserv1.DecodeServiceClient {
+	t.Helper()
+	converter, err := avroconvert.NewJSONConverter(avroconvert.ConverterOptions{TimeZone: "UTC"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer(grpc.MaxRecvMsgSize(1 << 10))
+	avroparserv1.RegisterDecodeServiceServer(server, &decodeService{converter: converter, registry: registry})
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return avroparserv1.NewDecodeServiceClient(conn)
+}
+
+func serveRecord(t *testing.T, id int64) []byte {
+	t.Helper()
+	codec, err := goavro.NewCodec(serveSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := codec.BinaryFromNative(nil, map[string]interface{}{"id": id, "at": int64(1700000000000)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// testRegistry serves serveSchema as schema 7 of a schema registry.
+func testRegistry(t *testing.T) *schemaRegistry {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/schemas/ids/7" {
+			http.Error(w, `{"error_code":40403,"message":"Schema not found"}`, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"schema": serveSchema})
+	}))
+	t.Cleanup(srv.Close)
+	registry, err := newSchemaRegistry(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return registry
+}
+
+func TestServeDecodeStream(t *testing.T) {
+	client := testDecodeClient(t, testRegistry(t))
+	wire := append([]byte{0, 0, 0, 0, 7}, serveRecord(t, 3)...)
+	requests := []*avroparserv1.DecodeRequest{
+		{Id: "inline", Data: serveRecord(t, 1), WriterSchema: &avroparserv1.DecodeRequest_Schema{Schema: serveSchema}},
+		{Id: "inline again", Data: serveRecord(t, 2), WriterSchema: &avroparserv1.DecodeRequest_Schema{Schema: serveSchema}},
+		{Id: "wire format", Data: wire},
+		{Id: "schema id", Data: serveRecord(t, 4), WriterSchema: &avroparserv1.DecodeRequest_SchemaId{SchemaId: 7}},
+		{Id: "unknown id", Data: serveRecord(t, 5), WriterSchema: &avroparserv1.DecodeRequest_SchemaId{SchemaId: 8}},
+		{Id: "no schema", Data: serveRecord(t, 6)},
+		{Id: "truncated", Data: serveRecord(t, 7)[:1], WriterSchema: &avroparserv1.DecodeRequest_Schema{Schema: serveSchema}},
+	}
+	want := []struct{ json, error string }{
+		{`{"at":"2023-11-14T22:13:20Z","id":1}`, ""},
+		{`{"at":"2023-11-14T22:13:20Z","id":2}`, ""},
+		{`{"at":"2023-11-14T22:13:20Z","id":3}`, ""},
+		{`{"at":"2023-11-14T22:13:20Z","id":4}`, ""},
+		{"", "cannot look up schema 8"},
+		{"", "no Confluent wire format header"},
+		{"", "cannot decode record"},
+	}
+
+	// Compressed, as clients may send messages
+	stream, err := client.Decode(context.Background(), grpc.UseCompressor(gzip.Name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, req := range requests {
+		if err := stream.Send(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	for i, w := range want {
+		resp, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("response %d: %v", i, err)
+		}
+		if resp.GetId() != requests[i].GetId() || resp.GetJson() != w.json || !strings.Contains(resp.GetError(), w.error) || (w.error == "") != (resp.GetError() == "") {
+			t.Fatalf("response %d is %v, want json %s, error %q", i, resp, w.json, w.error)
+		}
+	}
+	if _, err := stream.Recv(); err != io.EOF {
+		t.Fatalf("the stream ended with %v", err)
+	}
+}
+
+func TestServeDecodeOne(t *testing.T) {
+	client := testDecodeClient(t, nil)
+	resp, err := client.DecodeOne(context.Background(), &avroparserv1.DecodeRequest{Id: "a", Data: serveRecord(t, 42), WriterSchema: &avroparserv1.DecodeRequest_Schema{Schema: serveSchema}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.GetId() != "a" || resp.GetJson() != `{"at":"2023-11-14T22:13:20Z","id":42}` || resp.GetError() != "" {
+		t.Fatalf("response is %v", resp)
+	}
+
+	// A schema ID needs a registry
+	resp, err = client.DecodeOne(context.Background(), &avroparserv1.DecodeRequest{Data: serveRecord(t, 42), WriterSchema: &avroparserv1.DecodeRequest_SchemaId{SchemaId: 7}})
+	if err != nil || !strings.Contains(resp.GetError(), "without a -schema-registry") {
+		t.Fatalf("response is %v, %v", resp, err)
+	}
+
+	// Messages past the size limit end the call
+	big := &avroparserv1.DecodeRequest{Data: make([]byte, 2<<10)}
+	if _, err := client.DecodeOne(context.Background(), big); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("a large message returned %v, want %v", err, codes.ResourceExhausted)
+	}
+}
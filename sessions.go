@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+func runFirebaseSessions(args []string) {
+	fs := flag.NewFlagSet("firebase sessions", flag.ExitOnError)
+	outputPath := fs.String("output", stdioPath, "Output file for the sessions, or - for stdout")
+	format := fs.String("format", "csv", "Output format: csv or ndjson")
+	sessionParam := fs.String("session-param", "ga_session_id", "Event parameter identifying the session of a user")
+	screenParam := fs.String("screen-param", "firebase_screen", "Parameter of screen_view events naming the screen")
+	events := addEventFlags(fs)
+	csvOutput := addCSVReportFlags(fs)
+	logging := addLogFlags(fs)
+	parseFlags(fs, args)
+	logging.setup()
+
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: avroparser firebase sessions [-output <file>|-] [-format csv|ndjson] <avro_or_ndjson_file|dir|glob|->...")
+		os.Exit(exitFatal)
+	}
+	dialect, err := csvOutput.dialect()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	if *format != "csv" && *format != "ndjson" {
+		fmt.Fprintf(os.Stderr, "Unknown output format %q (expected csv or ndjson)\n", *format)
+		os.Exit(exitFatal)
+	}
+	source, err := events.source()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+
+	var inputs []inputFile
+	for _, arg := range fs.Args() {
+		inputs = append(inputs, mustExpandInputs(arg)...)
+	}
+
+	sessions := make(map[sessionKey]*session)
+	unsessioned := 0
+	failed, skipped := source.read(inputs, func(user string, t time.Time, event interface{}) {
+		params, _ := firebaseParams(lookupPath(event, []string{"event_params"}))
+		id := params[*sessionParam]
+		if id == nil {
+			unsessioned++
+			return
+		}
+		key := sessionKey{user: user, id: countKey(id)}
+		s := sessions[key]
+		if s == nil {
+			s = &session{sessionKey: key, start: t, end: t}
+			sessions[key] = s
+		}
+		s.add(t, event, params[*screenParam])
+	})
+	if failed == len(inputs) {
+		os.Exit(exitFatal)
+	}
+	if skipped > 0 || unsessioned > 0 {
+		slog.Warn("Left out events", "without_user_or_time", skipped, "without_session", unsessioned)
+	}
+
+	list := make([]*session, 0, len(sessions))
+	for _, s := range sessions {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].user != list[j].user {
+			return list[i].user < list[j].user
+		}
+		return list[i].start.Before(list[j].start)
+	})
+
+	out, err := openOutput(*outputPath)
+	if err == nil {
+		if *format == "csv" {
+			err = writeSessionsCSV(out, list, dialect)
+		} else {
+			err = writeSessionsNDJSON(out, list)
+		}
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		slog.Error("Cannot write sessions", "output", displayPath(*outputPath), "error", err)
+		os.Exit(exitFatal)
+	}
+	slog.Info("Wrote sessions", "sessions", len(list), "output", displayPath(*outputPath))
+	if failed > 0 {
+		os.Exit(exitPartial)
+	}
+}
+
+// sessionKey identifies a session: session IDs are only unique per user.
+type sessionKey struct {
+	user string
+	id   string
+}
+
+// session summarizes the events of one session.
+type session struct {
+	sessionKey
+	start       time.Time
+	end         time.Time
+	events      int
+	screenViews int
+	screens     []string // screens viewed, in order, without repeats of the one before
+	times       []time.Time
+}
+
+// add counts an event of the session. Events may come in any order, so
+// screen views are kept with their times and ordered when written.
+func (s *session) add(t time.Time, event interface{}, screen interface{}) {
+	s.events++
+	if t.Before(s.start) {
+		s.start = t
+	}
+	if t.After(s.end) {
+		s.end = t
+	}
+	if name, _ := lookupPath(event, []string{"event_name"}).(string); name != "screen_view" {
+		return
+	}
+	s.screenViews++
+	if screen != nil {
+		s.screens = append(s.screens, countKey(screen))
+		s.times = append(s.times, t)
+	}
+}
+
+// screenPath returns the screens viewed in order, a screen viewed again
+// right after itself listed once.
+func (s *session) screenPath() []string {
+	order := make([]int, len(s.screens))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return s.times[order[i]].Before(s.times[order[j]]) })
+	path := []string{}
+	for _, i := range order {
+		if len(path) == 0 || path[len(path)-1] != s.screens[i] {
+			path = append(path, s.screens[i])
+		}
+	}
+	return path
+}
+
+// sessionColumns is the header of sessions CSV output.
+var sessionColumns = []string{"user", "session_id", "start", "end", "duration_seconds", "events", "screen_views", "screens"}
+
+func writeSessionsCSV(w io.Writer, sessions []*session, dialect csvDialect) error {
+	cw := newCSVWriter(w, dialect)
+	if err := cw.Write(sessionColumns); err != nil {
+		return err
+	}
+	for _, s := range sessions {
+		screens, err := json.Marshal(s.screenPath())
+		if err != nil {
+			return err
+		}
+		err = cw.Write([]string{
+			s.user,
+			s.id,
+			s.start.Format(time.RFC3339Nano),
+			s.end.Format(time.RFC3339Nano),
+			strconv.FormatFloat(s.end.Sub(s.start).Seconds(), 'f', -1, 64),
+			strconv.Itoa(s.events),
+			strconv.Itoa(s.screenViews),
+			string(screens),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// sessionRecord is the NDJSON form of a session.
+type sessionRecord struct {
+	User            string    `json:"user"`
+	SessionID       string    `json:"session_id"`
+	Start           time.Time `json:"start"`
+	End             time.Time `json:"end"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	Events          int       `json:"events"`
+	ScreenViews     int       `json:"screen_views"`
+	Screens         []string  `json:"screens"`
+}
+
+func writeSessionsNDJSON(w io.Writer, sessions []*session) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for _, s := range sessions {
+		err := enc.Encode(sessionRecord{
+			User:            s.user,
+			SessionID:       s.id,
+			Start:           s.start,
+			End:             s.end,
+			DurationSeconds: s.end.Sub(s.start).Seconds(),
+			Events:          s.events,
+			ScreenViews:     s.screenViews,
+			Screens:         s.screenPath(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
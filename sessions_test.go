@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventSource(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	events := addEventFlags(fs)
+	if err := fs.Parse([]string{"-field", "message"}); err != nil {
+		t.Fatal(err)
+	}
+	source, err := events.source()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := writeMessageOCF(t,
+		`{"user_pseudo_id":"u1","event_timestamp":1760000000000000,"platform":"IOS"}`,
+		`{"user_pseudo_id":"u2","event_timestamp":1760000000000000,"platform":"ANDROID"}`,
+		`{"event_timestamp":1760000000000000,"platform":"IOS"}`,
+		`{"user_pseudo_id":"u3","platform":"IOS"}`,
+	)
+	in := inputFile{path: writeTestFile(t, "events.avro", data), rel: "events.avro"}
+
+	var users []string
+	failed, skipped := source.read([]inputFile{in}, func(user string, ts time.Time, event interface{}) {
+		users = append(users, user)
+		if !ts.Equal(time.Unix(1760000000, 0)) {
+			t.Errorf("read time %v", ts)
+		}
+	})
+	if failed != 0 || skipped != 2 || !reflect.DeepEqual(users, []string{"u1", "u2"}) {
+		t.Fatalf("read users %v, %d failed, %d skipped", users, failed, skipped)
+	}
+
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	events = addEventFlags(fs)
+	if err := fs.Parse([]string{"-user-field", "user."}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := events.source(); err == nil {
+		t.Fatal("accepted an invalid -user-field")
+	}
+}
+
+func TestSession(t *testing.T) {
+	start := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	view := func(screen string) interface{} {
+		return map[string]interface{}{"event_name": "screen_view", "firebase_screen": screen}
+	}
+	s := &session{sessionKey: sessionKey{user: "u1", id: "7"}, start: start.Add(time.Minute), end: start.Add(time.Minute)}
+	s.add(start.Add(time.Minute), view("shop"), "shop")
+	s.add(start, view("menu"), "menu")
+	s.add(start.Add(2*time.Minute), view("shop"), "shop")
+	s.add(start.Add(3*time.Minute), map[string]interface{}{"event_name": "purchase"}, nil)
+	s.add(start.Add(90*time.Second), view("unnamed"), nil)
+
+	if s.events != 5 || s.screenViews != 4 || !s.start.Equal(start) || !s.end.Equal(start.Add(3*time.Minute)) {
+		t.Fatalf("session %+v", s)
+	}
+	if path := s.screenPath(); !reflect.DeepEqual(path, []string{"menu", "shop"}) {
+		t.Fatalf("screen path %v", path)
+	}
+
+	var buf bytes.Buffer
+	if err := writeSessionsCSV(&buf, []*session{s}, csvDialect{}); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join(sessionColumns, ",") + "\n" +
+		`u1,7,2026-10-15T12:00:00Z,2026-10-15T12:03:00Z,180,5,4,"[""menu"",""shop""]"` + "\n"
+	if buf.String() != want {
+		t.Fatalf("wrote %q, want %q", buf.String(), want)
+	}
+
+	buf.Reset()
+	if err := writeSessionsNDJSON(&buf, []*session{s}); err != nil {
+		t.Fatal(err)
+	}
+	want = `{"user":"u1","session_id":"7","start":"2026-10-15T12:00:00Z","end":"2026-10-15T12:03:00Z","duration_seconds":180,"events":5,"screen_views":4,"screens":["menu","shop"]}` + "\n"
+	if buf.String() != want {
+		t.Fatalf("wrote %q, want %q", buf.String(), want)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"avroparser/pkg/avroconvert"
+)
+
+// errObjectNotFound is returned for objects that don't exist.
+var errObjectNotFound = errors.New("object not found")
+
+// objectStore is a bucket store inputs can be listed from, such as Cloud
+// Storage or S3. openRange reads length bytes of an object from offset on,
+// or the rest of it with a negative length.
+type objectStore interface {
+	exists(bucket, object string) (bool, error)
+	list(bucket, prefix string) ([]string, error)
+	open(p string) (io.ReadCloser, error)
+	openRange(p string, offset, length int64) (io.ReadCloser, error)
+}
+
+// storeSource is an object in an objectStore.
+type storeSource struct {
+	store objectStore
+	path  string
+}
+
+func (s storeSource) Name() string { return s.path }
+
+func (s storeSource) Open() (io.ReadCloser, error) {
+	return s.store.open(s.path)
+}
+
+// byteRangeHeader is the Range header requesting length bytes from offset
+// on, or the rest of an object with a negative length.
+func byteRangeHeader(offset, length int64) string {
+	if length < 0 {
+		return fmt.Sprintf("bytes=%d-", offset)
+	}
+	return fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+}
+
+// isHTTPPath reports whether an input is an http:// or https:// URL.
+func isHTTPPath(p string) bool {
+	return strings.HasPrefix(p, "http://") || strings.HasPrefix(p, "https://")
+}
+
+// httpInputHeader is sent with every request for an http(s):// input, such
+// as an Authorization header. It is read from AVROPARSER_HTTP_HEADERS, which
+// holds a "Name: value" header per line.
+var httpInputHeader = func() http.Header {
+	var headers headerFlags
+	for _, line := range strings.Split(os.Getenv("AVROPARSER_HTTP_HEADERS"), "\n") {
+		if strings.TrimSpace(line) != "" {
+			headers.Set(line)
+		}
+	}
+	return headers.header()
+}()
+
+// isRemotePath reports whether an input is read over the network, or out
+// of an archive, rather than from a file of its own.
+func isRemotePath(p string) bool {
+	return isGCSPath(p) || isS3Path(p) || isHTTPPath(p) || isArchiveEntry(p)
+}
+
+// newSource returns the source of an input path: stdin for stdioPath, a
+// gs:// or s3:// object, an http(s):// URL, an archive entry, or else a
+// local file.
+func newSource(p string) avroconvert.Source {
+	switch {
+	case p == stdioPath:
+		return avroconvert.StdinSource{}
+	case isGCSPath(p):
+		return storeSource{store: gcs, path: p}
+	case isS3Path(p):
+		return storeSource{store: s3, path: p}
+	case isHTTPPath(p):
+		return avroconvert.HTTPSource{URL: p, Header: httpInputHeader, Retries: 5, Logger: slog.Default()}
+	case isArchiveEntry(p):
+		return newArchiveSource(p)
+	}
+	return avroconvert.FileSource(p)
+}
+
+// expandStoreInputs resolves a gs:// or s3:// -input the way expandInputs
+// does for local paths: an object, a "directory" prefix searched for .avro
+// objects, or a glob pattern over object names.
+func expandStoreInputs(store objectStore, scheme, input string) ([]inputFile, error) {
+	bucket, object, _ := strings.Cut(strings.TrimPrefix(input, scheme), "/")
+	if bucket == "" {
+		return nil, fmt.Errorf("invalid bucket path %q", input)
+	}
+	base := scheme + bucket + "/"
+
+	if isGlob(object) {
+		prefix := object[:strings.IndexAny(object, "*?[")]
+		root := path.Dir(object)
+		for isGlob(root) {
+			root = path.Dir(root)
+		}
+		names, err := store.list(bucket, prefix)
+		if err != nil {
+			return nil, err
+		}
+		var inputs []inputFile
+		for _, name := range names {
+			if ok, err := path.Match(object, name); err != nil {
+				return nil, err
+			} else if ok {
+				inputs = append(inputs, inputFile{path: base + name, rel: objectRel(root, name)})
+			}
+		}
+		return inputs, nil
+	}
+
+	if object != "" && !strings.HasSuffix(object, "/") {
+		ok, err := store.exists(bucket, object)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read %s: %w", input, err)
+		}
+		if ok {
+			return []inputFile{{path: input, rel: path.Base(object)}}, nil
+		}
+		object += "/"
+	}
+
+	names, err := store.list(bucket, object)
+	if err != nil {
+		return nil, err
+	}
+	var inputs []inputFile
+	for _, name := range names {
+		if isAvroName(name) {
+			inputs = append(inputs, inputFile{path: base + name, rel: strings.TrimPrefix(name, object)})
+		}
+	}
+	return inputs, nil
+}
+
+// objectRel returns an object name relative to a root "directory".
+func objectRel(root, name string) string {
+	if root == "." {
+		return name
+	}
+	return strings.TrimPrefix(name, root+"/")
+}
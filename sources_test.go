@@ -0,0 +1,26 @@
+package main
+
+import (
+	"log/slog"
+	"reflect"
+	"testing"
+
+	"avroparser/pkg/avroconvert"
+)
+
+func TestNewSource(t *testing.T) {
+	for _, tc := range []struct {
+		path string
+		want avroconvert.Source
+	}{
+		{stdioPath, avroconvert.StdinSource{}},
+		{"gs://bucket/a.avro", storeSource{store: gcs, path: "gs://bucket/a.avro"}},
+		{"s3://bucket/a.avro", storeSource{store: s3, path: "s3://bucket/a.avro"}},
+		{"https://example.com/a.avro", avroconvert.HTTPSource{URL: "https://example.com/a.avro", Header: httpInputHeader, Retries: 5, Logger: slog.Default()}},
+		{"exports/a.avro", avroconvert.FileSource("exports/a.avro")},
+	} {
+		if got := newSource(tc.path); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("newSource(%q) = %#v, want %#v", tc.path, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"avroparser/pkg/avroconvert"
+)
+
+// splitLimits bound the size of each output file. Zero means no limit.
+type splitLimits struct {
+	records int   // messages per file
+	bytes   int64 // bytes written to each file, after compression
+}
+
+func (l splitLimits) enabled() bool {
+	return l.records > 0 || l.bytes > 0
+}
+
+// partPath returns the path of a numbered part of an output, e.g.
+// events.part-0001.ndjson.gz for events.ndjson.gz with extension
+// ndjson.gz.
+func partPath(path, ext string, part int) string {
+	return fmt.Sprintf("%s.part-%04d.%s", strings.TrimSuffix(path, "."+ext), part, ext)
+}
+
+// outputFile is a format writer together with the buffered, possibly
+// compressed file it writes to.
+type outputFile struct {
+	path     string
+	writer   avroconvert.Sink
+	buffered *bufio.Writer
+	out      io.WriteCloser
+	counter  *countingWriter
+	raw      bool // uncompressed, so buffered bytes count toward the size as-is
+	records  int64
+	manifest *outputManifest // the file is added to when closed, with -manifest
+	pending  bool            // the file is only moved into place, and added to the manifest, by commit
+}
+
+// openOutputFile creates an output, or opens it for appending, and a format
+// writer on top of it with newWriter. With a manifest, the file's bytes are
+// checksummed as they are written.
+func openOutputFile(path, compression string, appending bool, manifest *outputManifest, newWriter func(w io.Writer) (avroconvert.Sink, error)) (*outputFile, error) {
+	open := openOutput
+	if appending {
+		open = appendOutput
+	}
+	out, err := open(path)
+	if err != nil {
+		return nil, err
+	}
+	counter := &countingWriter{w: out}
+	if manifest != nil {
+		counter.hash = sha256.New()
+	}
+	compressed, err := compressOutput(counter, compression)
+	if err != nil {
+		abortOutput(out)
+		return nil, err
+	}
+	buffered := bufio.NewWriter(compressed)
+	writer, err := newWriter(buffered)
+	if err != nil {
+		abortOutput(out)
+		return nil, err
+	}
+	return &outputFile{path: path, writer: writer, buffered: buffered, out: compressed, counter: counter, raw: compression == compressNone, manifest: manifest}, nil
+}
+
+// size returns how large the file is so far. Data still inside a
+// compressor isn't counted until it is flushed to the file.
+func (f *outputFile) size() int64 {
+	if f.raw {
+		return f.counter.n + int64(f.buffered.Buffered())
+	}
+	return f.counter.n
+}
+
+// Flush writes out what the format writer and the buffer hold. Data inside
+// a compressor stays there until the file is closed.
+func (f *outputFile) Flush() error {
+	if err := f.writer.Flush(); err != nil {
+		return fmt.Errorf("cannot write output file: %w", err)
+	}
+	if err := f.buffered.Flush(); err != nil {
+		return fmt.Errorf("cannot write output file: %w", err)
+	}
+	return nil
+}
+
+// Close finishes the format writer and flushes and closes the file, which
+// only then appears under its path.
+func (f *outputFile) Close() error {
+	if err := f.writer.Close(); err != nil {
+		f.abort()
+		return fmt.Errorf("cannot write output file: %w", err)
+	}
+	if err := f.buffered.Flush(); err != nil {
+		f.abort()
+		return fmt.Errorf("cannot write output file: %w", err)
+	}
+	if err := f.out.Close(); err != nil {
+		return fmt.Errorf("cannot write output file: %w", err)
+	}
+	if f.manifest != nil && !f.pending {
+		f.manifest.add(f.path, f.records, f.counter.n, f.counter.hash.Sum(nil))
+	}
+	return nil
+}
+
+// hold makes the file pending: a local file stays under its temporary name
+// when closed, until commit moves it into place, so that the parts of an
+// output appear together. Cloud Storage objects can't be renamed, and are
+// uploaded when closed.
+func (f *outputFile) hold() {
+	f.pending = true
+	if af, ok := f.counter.w.(*atomicFile); ok {
+		af.held = true
+	}
+}
+
+// commit moves a pending file into place once it is closed, and adds it to
+// the manifest.
+func (f *outputFile) commit() error {
+	if af, ok := f.counter.w.(*atomicFile); ok && af.held {
+		if err := af.commit(); err != nil {
+			return fmt.Errorf("cannot write output file: %w", err)
+		}
+	}
+	if f.manifest != nil {
+		f.manifest.add(f.path, f.records, f.counter.n, f.counter.hash.Sum(nil))
+	}
+	return nil
+}
+
+// abort closes the file without finishing it, so it is not left partly
+// written under its path.
+func (f *outputFile) abort() {
+	abortOutput(f.out)
+}
+
+// remove deletes a closed pending file, after the output it is part of
+// failed.
+func (f *outputFile) remove() {
+	if af, ok := f.counter.w.(*atomicFile); ok && af.held {
+		af.abort()
+		return
+	}
+	if isGCSPath(f.path) {
+		if err := gcs.remove(f.path); err != nil {
+			slog.Warn("Cannot remove part of a failed output", "output", f.path, "error", err)
+		}
+	}
+}
+
+// countingWriter counts the bytes written through it, and checksums them
+// if hash is set.
+type countingWriter struct {
+	w    io.WriteCloser
+	n    int64
+	hash hash.Hash
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	if cw.hash != nil {
+		cw.hash.Write(p[:n])
+	}
+	return n, err
+}
+
+func (cw *countingWriter) Close() error {
+	return cw.w.Close()
+}
+
+func (cw *countingWriter) abort() {
+	abortOutput(cw.w)
+}
+
+// splitWriter writes messages to an output, starting a new numbered part
+// whenever the current one reaches a limit. Without limits everything goes
+// to the output path itself. Sizes are checked between messages, so a part
+// can exceed the byte limit by one message, and compressed parts by what
+// the compressor still holds. Parts are kept under temporary names until
+// the writer is closed, so an output that fails part way leaves no parts.
+type splitWriter struct {
+	path     string
+	ext      string
+	limits   splitLimits
+	open     func(path string) (*outputFile, error)
+	schema   *avroconvert.Schema // passed on to each part of native output
+	file     *outputFile
+	messages int // messages in the current part
+	paths    []string
+	done     []*outputFile // parts closed, not yet committed
+}
+
+// newSplitWriter returns a splitWriter for path, whose extension is ext.
+// open creates each file.
+func newSplitWriter(path, ext string, limits splitLimits, open func(path string) (*outputFile, error)) *splitWriter {
+	return &splitWriter{path: path, ext: ext, limits: limits, open: open}
+}
+
+// native returns the writer as an avroconvert.NativeSink, for parts that
+// take whole records.
+func (sw *splitWriter) native() avroconvert.NativeSink {
+	return splitNativeWriter{sw}
+}
+
+// written lists the files written, for status messages.
+func (sw *splitWriter) written() string {
+	if len(sw.paths) == 0 {
+		return displayPath(sw.path)
+	}
+	names := make([]string, len(sw.paths))
+	for i, path := range sw.paths {
+		names[i] = displayPath(path)
+	}
+	return strings.Join(names, ", ")
+}
+
+// next returns the file the next message goes to.
+func (sw *splitWriter) next() (*outputFile, error) {
+	if sw.file != nil {
+		full := sw.limits.records > 0 && sw.messages >= sw.limits.records ||
+			sw.limits.bytes > 0 && sw.file.size() >= sw.limits.bytes
+		if !full {
+			return sw.file, nil
+		}
+		if err := sw.closeFile(); err != nil {
+			return nil, err
+		}
+	}
+
+	path := sw.path
+	if sw.limits.enabled() {
+		path = partPath(sw.path, sw.ext, len(sw.paths)+1)
+	}
+	file, err := sw.open(path)
+	if err != nil {
+		return nil, err
+	}
+	if sw.schema != nil {
+		if err := file.writer.(avroconvert.NativeSink).SetSchema(sw.schema); err != nil {
+			file.abort()
+			return nil, err
+		}
+	}
+	if sw.limits.enabled() {
+		file.hold()
+	}
+	sw.file, sw.messages = file, 0
+	sw.paths = append(sw.paths, path)
+	return file, nil
+}
+
+func (sw *splitWriter) closeFile() error {
+	err := sw.file.Close()
+	if err == nil {
+		sw.done = append(sw.done, sw.file)
+	}
+	sw.file = nil
+	return err
+}
+
+func (sw *splitWriter) WriteRecord(msg json.RawMessage) error {
+	file, err := sw.next()
+	if err != nil {
+		return err
+	}
+	sw.messages++
+	file.records++
+	return file.writer.WriteRecord(msg)
+}
+
+// Flush flushes the current part.
+func (sw *splitWriter) Flush() error {
+	if sw.file == nil {
+		return nil
+	}
+	return sw.file.Flush()
+}
+
+// Close finishes the last part and moves the parts into place. An output
+// without messages still gets its (first) file, as an empty input always
+// has.
+func (sw *splitWriter) Close() error {
+	if sw.file == nil && len(sw.paths) == 0 {
+		if _, err := sw.next(); err != nil {
+			return err
+		}
+	}
+	if sw.file != nil {
+		if err := sw.closeFile(); err != nil {
+			sw.abort()
+			return err
+		}
+	}
+	// The first part, which -skip-existing looks for, is moved into place
+	// last, once the others are
+	for len(sw.done) > 0 {
+		last := sw.done[len(sw.done)-1]
+		if last.pending {
+			if err := last.commit(); err != nil {
+				sw.done = sw.done[:len(sw.done)-1]
+				sw.abort()
+				return err
+			}
+		}
+		sw.done = sw.done[:len(sw.done)-1]
+	}
+	return nil
+}
+
+// abort removes what was written of the output, after decoding failed: the
+// part being written and the parts finished before it. It does nothing once
+// the writer is closed.
+func (sw *splitWriter) abort() {
+	if sw.file != nil {
+		sw.file.abort()
+		sw.file = nil
+	}
+	for _, file := range sw.done {
+		file.remove()
+	}
+	sw.done = nil
+}
+
+// splitNativeWriter is a splitWriter over parts that take native records.
+type splitNativeWriter struct {
+	*splitWriter
+}
+
+func (sw splitNativeWriter) SetSchema(schema *avroconvert.Schema) error {
+	sw.schema = schema
+	if sw.file != nil {
+		return sw.file.writer.(avroconvert.NativeSink).SetSchema(schema)
+	}
+	return nil
+}
+
+func (sw splitNativeWriter) WriteNative(record interface{}) error {
+	file, err := sw.next()
+	if err != nil {
+		return err
+	}
+	sw.messages++
+	file.records++
+	return file.writer.(avroconvert.NativeSink).WriteNative(record)
+}
+
+// parseSize parses a byte size such as 2GB, 500M or 1048576. Units are
+// powers of 1024.
+func parseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool { return !unicode.IsDigit(r) && r != '.' })
+	number, unit := s, ""
+	if i >= 0 {
+		number, unit = s[:i], strings.ToUpper(strings.TrimSpace(s[i:]))
+	}
+
+	multiplier := map[string]float64{
+		"": 1, "B": 1,
+		"K": 1 << 10, "KB": 1 << 10, "KIB": 1 << 10,
+		"M": 1 << 20, "MB": 1 << 20, "MIB": 1 << 20,
+		"G": 1 << 30, "GB": 1 << 30, "GIB": 1 << 30,
+		"T": 1 << 40, "TB": 1 << 40, "TIB": 1 << 40,
+	}[unit]
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil || multiplier == 0 || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q (expected e.g. 500MB or 2GB)", s)
+	}
+	return int64(n * multiplier), nil
+}
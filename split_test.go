@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConvertSplitRecords(t *testing.T) {
+	var msgs []string
+	for i := 1; i <= 5; i++ {
+		msgs = append(msgs, fmt.Sprintf(`{"id":%d}`, i))
+	}
+	in := inputFile{path: writeTestFile(t, "events.avro", writeMessageOCF(t, msgs...)), rel: "events.avro"}
+
+	dir := t.TempDir()
+	opts := testOptions(t, "message")
+	opts.outputDir, opts.format, opts.split = dir, "ndjson", splitLimits{records: 2}
+	if result := convertFile(in, opts); result.err != nil {
+		t.Fatal(result.err)
+	}
+	for part, want := range []string{"{\"id\":1}\n{\"id\":2}\n", "{\"id\":3}\n{\"id\":4}\n", "{\"id\":5}\n"} {
+		data, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf("events.part-%04d.ndjson", part+1)))
+		if err != nil || string(data) != want {
+			t.Fatalf("part %d: %q, %v", part+1, data, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "events.part-0004.ndjson")); err == nil {
+		t.Fatal("wrote a fourth part")
+	}
+}
+
+func TestConvertSplitSize(t *testing.T) {
+	var msgs []string
+	for i := 0; i < 10; i++ {
+		msgs = append(msgs, fmt.Sprintf(`{"id":%d,"pad":"0123456789"}`, i))
+	}
+	in := inputFile{path: writeTestFile(t, "events.avro", writeMessageOCF(t, msgs...)), rel: "events.avro"}
+
+	dir := t.TempDir()
+	opts := testOptions(t, "message")
+	opts.outputDir, opts.format, opts.split = dir, "ndjson", splitLimits{bytes: 60}
+	if result := convertFile(in, opts); result.err != nil {
+		t.Fatal(result.err)
+	}
+	// Each line is 28 bytes, so a part is full after the third
+	parts, err := filepath.Glob(filepath.Join(dir, "events.part-*.ndjson"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 4 {
+		t.Fatalf("wrote parts %v", parts)
+	}
+	for _, part := range parts[:3] {
+		if info, err := os.Stat(part); err != nil || info.Size() != 84 {
+			t.Fatalf("%s: %v, %v", part, info.Size(), err)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for s, want := range map[string]int64{"1048576": 1 << 20, "500MB": 500 << 20, "2g": 2 << 30, "1.5 KiB": 1536, "10B": 10} {
+		if got, err := parseSize(s); err != nil || got != want {
+			t.Errorf("parseSize(%q) = %d, %v, want %d", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "MB", "-1MB", "5XB", "0"} {
+		if _, err := parseSize(s); err == nil {
+			t.Errorf("parsed invalid size %q", s)
+		}
+	}
+	if got := partPath("out/events.ndjson.gz", "ndjson.gz", 3); got != "out/events.part-0003.ndjson.gz" {
+		t.Errorf("part path %s", got)
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/linkedin/goavro/v2"
+)
+
+func runSplit(args []string) {
+	fs := flag.NewFlagSet("split", flag.ExitOnError)
+	inputPath := fs.String("input", "", "Input Avro file, or - for stdin")
+	outputDir := fs.String("output", "output", "Output directory for the parts")
+	parts := fs.Int("parts", 0, "Split into this many parts with about the same number of records")
+	maxRecords := fs.Int("max-records-per-file", 0, "Split into parts of at most this many records (0 for no limit)")
+	maxSize := fs.String("max-file-size", "", "Split into parts of about this size, e.g. 500MB or 2GB")
+	logging := addLogFlags(fs)
+	parseFlags(fs, args)
+	logging.setup()
+
+	if *inputPath == "" && fs.NArg() > 0 {
+		*inputPath = fs.Arg(0)
+	}
+
+	if *inputPath == "" {
+		fmt.Fprintln(os.Stderr, "Usage: avroparser split -input <avro_file|-> [-output <output_dir>] -parts <n> | -max-records-per-file <n> | -max-file-size <size>")
+		os.Exit(exitFatal)
+	}
+
+	var limits splitLimits
+	if *maxRecords < 0 || *parts < 0 {
+		fmt.Fprintln(os.Stderr, "-parts and -max-records-per-file must not be negative")
+		os.Exit(exitFatal)
+	}
+	limits.records = *maxRecords
+	if *maxSize != "" {
+		size, err := parseSize(*maxSize)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -max-file-size: %v\n", err)
+			os.Exit(exitFatal)
+		}
+		limits.bytes = size
+	}
+	if limits.enabled() == (*parts > 0) {
+		fmt.Fprintln(os.Stderr, "Give either -parts, or -max-records-per-file and/or -max-file-size")
+		os.Exit(exitFatal)
+	}
+	if *outputDir == stdioPath {
+		fmt.Fprintln(os.Stderr, "Output to stdout cannot be split into files")
+		os.Exit(exitFatal)
+	}
+
+	in := inputFile{path: *inputPath, rel: filepath.Base(*inputPath)}
+	if in.path == stdioPath {
+		in.rel = "stdin.avro"
+	}
+	output := outputPath(in, *outputDir, "avro")
+	stats, paths, err := splitFile(in.path, output, limits, *parts)
+	if err != nil {
+		slog.Error("Cannot split input", "input", in.rel, "error", err)
+		os.Exit(exitFatal)
+	}
+	slog.Info("Split records", "input", in.rel, "records", stats.records, "files", len(paths), "output", strings.Join(paths, ", "))
+}
+
+// ocfPart is one of the files a container file is split into.
+type ocfPart struct {
+	out     *countingWriter
+	ow      *ocfWriter
+	records int
+	limit   int // records the part holds at most, 0 for no limit
+}
+
+// full reports whether the part has no room for a block of size bytes.
+func (p *ocfPart) full(limits splitLimits, size int) bool {
+	return p.limit > 0 && p.records >= p.limit ||
+		limits.bytes > 0 && p.records > 0 && p.out.n+int64(size) > limits.bytes
+}
+
+func (p *ocfPart) Close() error {
+	if err := p.ow.Close(); err != nil {
+		abortOutput(p.out)
+		return err
+	}
+	if err := p.out.Close(); err != nil {
+		return fmt.Errorf("cannot write output file: %w", err)
+	}
+	return nil
+}
+
+// splitFile splits a container file into numbered parts with the same
+// schema and codec, and returns their paths. Blocks are copied as they are
+// unless a record limit falls inside one, which is then divided between
+// parts. Size limits are kept at block boundaries. With parts set, the
+// records are spread evenly over exactly that many files, the first ones
+// holding a record more when they don't divide evenly. An input that fails
+// part way leaves no parts.
+func splitFile(input, output string, limits splitLimits, parts int) (blockStats, []string, error) {
+	var stats blockStats
+	path, cleanup, err := spoolInput(input)
+	if err != nil {
+		return stats, nil, err
+	}
+	defer cleanup()
+
+	total := 0
+	if parts > 0 {
+		if total, err = countRecords(path); err != nil {
+			return stats, nil, err
+		}
+	}
+
+	r, err := openInput(path)
+	if err != nil {
+		return stats, nil, err
+	}
+	defer r.Close()
+	or, err := newOCFReader(r)
+	if err != nil {
+		return stats, nil, err
+	}
+	defer or.Close()
+
+	var paths []string
+	var part *ocfPart
+	next := func() error {
+		if part != nil {
+			if err := part.Close(); err != nil {
+				return err
+			}
+		}
+		paths = append(paths, partPath(output, "avro", len(paths)+1))
+		out, err := openOutput(paths[len(paths)-1])
+		if err != nil {
+			return err
+		}
+		part = &ocfPart{out: &countingWriter{w: out}, limit: limits.records}
+		if parts > 0 {
+			part.limit = total / parts
+			if len(paths) <= total%parts {
+				part.limit++
+			}
+		}
+		if part.ow, err = newOCFWriter(part.out, string(or.header.schema()), or.header.codec()); err != nil {
+			abortOutput(out)
+			part = nil
+		}
+		return err
+	}
+
+	var codec *goavro.Codec
+	err = func() error {
+		for {
+			count, data, err := or.next()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			stats.records += count
+			stats.blocks++
+
+			if part == nil || part.full(limits, len(data)) {
+				if err := next(); err != nil {
+					return err
+				}
+			}
+			if part.limit == 0 || part.records+count <= part.limit {
+				if err := part.ow.writeBlock(count, data); err != nil {
+					return err
+				}
+				part.records += count
+				continue
+			}
+
+			// The record limit falls inside this block, so divide its records
+			if codec == nil {
+				if codec, err = goavro.NewCodec(string(or.header.schema())); err != nil {
+					return fmt.Errorf("cannot parse schema: %w", err)
+				}
+			}
+			raw, err := or.decompress(data)
+			if err != nil {
+				return fmt.Errorf("cannot decompress block: %w", err)
+			}
+			records, err := recordSpans(codec, raw, count)
+			if err != nil {
+				return err
+			}
+			for len(records) > 0 {
+				if part.full(limits, 0) {
+					if err := next(); err != nil {
+						return err
+					}
+				}
+				n := min(part.limit-part.records, len(records))
+				var block []byte
+				for _, record := range records[:n] {
+					block = append(block, record...)
+				}
+				if block, err = part.ow.compress(block); err != nil {
+					return fmt.Errorf("cannot compress block: %w", err)
+				}
+				if err := part.ow.writeBlock(n, block); err != nil {
+					return err
+				}
+				part.records += n
+				records = records[n:]
+				stats.recompressed++
+			}
+		}
+	}()
+
+	// An input without records still gets a part, holding just the header,
+	// as do the parts asked for beyond the records there are
+	for err == nil && (part == nil || len(paths) < parts) {
+		err = next()
+	}
+	if err == nil && part != nil {
+		err = part.Close()
+	} else if part != nil {
+		abortOutput(part.out)
+	}
+	if err != nil {
+		// Parts finished before the failure are removed with it
+		for _, p := range paths {
+			os.Remove(p)
+		}
+		return stats, nil, err
+	}
+	return stats, paths, nil
+}
+
+// countRecords adds up the record counts of a container file's blocks.
+func countRecords(path string) (int, error) {
+	r, err := openInput(path)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	or, err := newOCFReader(r)
+	if err != nil {
+		return 0, err
+	}
+	defer or.Close()
+
+	total := 0
+	for {
+		count, _, err := or.next()
+		if errors.Is(err, io.EOF) {
+			return total, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		total += count
+	}
+}
+
+// recordSpans divides the decompressed data of a block into the encoded
+// bytes of each of its records.
+func recordSpans(codec *goavro.Codec, data []byte, count int) ([][]byte, error) {
+	spans := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		_, rest, err := codec.NativeFromBinary(data)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode record %d of block: %w", i+1, err)
+		}
+		spans = append(spans, data[:len(data)-len(rest)])
+		data = rest
+	}
+	return spans, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// splitTestFile splits a container file of n records and returns the ids
+// read from each part.
+func splitTestFile(t *testing.T, data []byte, limits splitLimits, parts int) [][]int64 {
+	t.Helper()
+	input := writeTestFile(t, "events.avro", data)
+	_, paths, err := splitFile(input, filepath.Join(t.TempDir(), "events.avro"), limits, parts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids [][]int64
+	for _, path := range paths {
+		part, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, readBlockIDs(t, part))
+	}
+	return ids
+}
+
+// checkParts checks parts hold the ids from 0 in order, with the given
+// number of records each.
+func checkParts(t *testing.T, parts [][]int64, sizes ...int) {
+	t.Helper()
+	if len(parts) != len(sizes) {
+		t.Fatalf("split into %d parts, want %d", len(parts), len(sizes))
+	}
+	next := int64(0)
+	for i, ids := range parts {
+		if len(ids) != sizes[i] {
+			t.Fatalf("part %d has %d records, want %d", i+1, len(ids), sizes[i])
+		}
+		for _, id := range ids {
+			if id != next {
+				t.Fatalf("part %d has id %d, want %d", i+1, id, next)
+			}
+			next++
+		}
+	}
+}
+
+func TestSplitFileRecords(t *testing.T) {
+	// Blocks of many records are divided where the limit falls inside them
+	for _, codec := range []string{ocfCodecNull, ocfCodecDeflate} {
+		data := writeTestOCF(t, codec, 250, nil, nil)
+		checkParts(t, splitTestFile(t, data, splitLimits{records: 100}, 0), 100, 100, 50)
+	}
+}
+
+func TestSplitFileParts(t *testing.T) {
+	data := writeTestOCF(t, ocfCodecSnappy, 100, nil, nil)
+	checkParts(t, splitTestFile(t, data, splitLimits{}, 4), 25, 25, 25, 25)
+
+	// The first parts get a record more when the records don't divide
+	// evenly, and there are as many parts as asked for even without a
+	// record for each
+	data = writeTestOCF(t, ocfCodecNull, 10, nil, nil)
+	checkParts(t, splitTestFile(t, data, splitLimits{}, 4), 3, 3, 2, 2)
+	data = writeTestOCF(t, ocfCodecDeflate, 9, nil, nil)
+	checkParts(t, splitTestFile(t, data, splitLimits{}, 4), 3, 2, 2, 2)
+	data = writeTestOCF(t, ocfCodecNull, 2, nil, nil)
+	checkParts(t, splitTestFile(t, data, splitLimits{}, 4), 1, 1, 0, 0)
+}
+
+func TestSplitFileSize(t *testing.T) {
+	// Parts end at block boundaries
+	data := writeTestOCF(t, ocfCodecNull, 400, nil, func(i int) []byte { return bytes.Repeat([]byte{byte(i)}, 1000) })
+	parts := splitTestFile(t, data, splitLimits{bytes: 150 << 10}, 0)
+	if len(parts) < 2 {
+		t.Fatalf("split into %d parts", len(parts))
+	}
+	sizes := make([]int, len(parts))
+	for i, ids := range parts {
+		sizes[i] = len(ids)
+	}
+	checkParts(t, parts, sizes...)
+}
+
+func TestSplitFileEmpty(t *testing.T) {
+	data := writeTestOCF(t, ocfCodecNull, 0, nil, nil)
+	checkParts(t, splitTestFile(t, data, splitLimits{records: 10}, 0), 0)
+}
+
+func TestSplitFileTruncated(t *testing.T) {
+	// The parts finished before the broken block are removed with its part
+	data := writeTestOCF(t, ocfCodecNull, 250, nil, nil)
+	input := writeTestFile(t, "events.avro", data[:len(data)-10])
+	dir := t.TempDir()
+	if _, paths, err := splitFile(input, filepath.Join(dir, "events.avro"), splitLimits{records: 100}, 0); err == nil {
+		t.Fatalf("split a truncated file into %v", paths)
+	}
+	checkNoOutputs(t, dir)
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// blockRange selects the data blocks of a container file to decode: those
+// from start up to end, which is where the last complete block ends. header
+// is the size of the file header, which is always read.
+type blockRange struct {
+	header int64
+	start  int64
+	end    int64
+}
+
+// resuming reports whether the range starts after blocks that were
+// converted before.
+func (b *blockRange) resuming() bool {
+	return b != nil && b.start > b.header
+}
+
+// conversionState is the -state file: what has been converted of each input,
+// so a later run over a growing export only converts new data. Container
+// files are tracked block by block; compressed and raw inputs as a whole.
+type conversionState struct {
+	path string
+
+	mu      sync.Mutex
+	Inputs  map[string]*inputState `json:"inputs"`
+	pending map[string]*inputState // states of inputs being converted
+}
+
+type inputState struct {
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	Sync     string    `json:"sync,omitempty"`   // sync marker of a container file, identifying it
+	Offset   int64     `json:"offset,omitempty"` // end of the last converted block
+}
+
+// loadState reads a state file, which doesn't need to exist yet.
+func loadState(path string) (*conversionState, error) {
+	s := &conversionState{path: path, Inputs: make(map[string]*inputState), pending: make(map[string]*inputState)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read state: %w", err)
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("cannot parse state %s: %w", path, err)
+	}
+	if s.Inputs == nil {
+		s.Inputs = make(map[string]*inputState)
+	}
+	return s, nil
+}
+
+// mustPlanState loads the -state file, if any, and returns the inputs left
+// to convert. It exits when the state cannot be used.
+func mustPlanState(path string, inputs []inputFile, canAppend bool) (*conversionState, []inputFile) {
+	if path == "" {
+		return nil, inputs
+	}
+	state, err := loadState(path)
+	if err == nil {
+		var pending []inputFile
+		if pending, err = state.plan(inputs, canAppend); err == nil {
+			if skipped := len(inputs) - len(pending); skipped > 0 {
+				slog.Info("Skipping inputs with nothing new since the last run", "inputs", skipped)
+			}
+			return state, pending
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%v\n", err)
+	os.Exit(exitFatal)
+	return nil, nil
+}
+
+// plan returns the inputs with something left to convert. Container files
+// that grew are resumed at their first new block when canAppend is set, and
+// converted again from the start otherwise. Trailing blocks that are still
+// being written are left for the next run.
+func (s *conversionState) plan(inputs []inputFile, canAppend bool) ([]inputFile, error) {
+	var pending []inputFile
+	for _, in := range inputs {
+		if in.path == stdioPath {
+			return nil, errors.New("-state cannot be used with stdin")
+		}
+		if isRemotePath(in.path) {
+			return nil, fmt.Errorf("-state only supports local input files, not %s", in.path)
+		}
+		info, err := os.Stat(in.path)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read input: %w", err)
+		}
+		current := &inputState{Size: info.Size(), Modified: info.ModTime().UTC()}
+		previous := s.Inputs[in.path]
+
+		blocks, sync, err := scanBlocks(in.path)
+		if err != nil {
+			// Compressed or raw input: convert it again whenever it changes
+			if previous != nil && previous.Sync == "" && previous.Size == current.Size && previous.Modified.Equal(current.Modified) {
+				continue
+			}
+		} else {
+			current.Sync, current.Offset = hex.EncodeToString(sync[:]), blocks.end
+			if previous != nil && previous.Sync == current.Sync {
+				if previous.Offset == blocks.end {
+					continue
+				}
+				if canAppend && previous.Offset >= blocks.header && previous.Offset < blocks.end {
+					blocks.start = previous.Offset
+				}
+			}
+			in.blocks = &blocks
+		}
+
+		s.pending[in.path] = current
+		pending = append(pending, in)
+	}
+	return pending, nil
+}
+
+// track wraps a conversion so every input it converts is recorded. Without
+// a state file the conversion is returned as is.
+func (s *conversionState) track(convert func(inputFile) fileResult) func(inputFile) fileResult {
+	if s == nil {
+		return convert
+	}
+	return func(in inputFile) fileResult {
+		result := convert(in)
+		if result.err == nil {
+			result.err = s.done(in)
+		}
+		return result
+	}
+}
+
+// done records a converted input and saves the state, so an interrupted run
+// keeps the progress of the inputs it finished.
+func (s *conversionState) done(in inputFile) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Inputs[in.path] = s.pending[in.path]
+	return s.save()
+}
+
+// save writes the state file atomically.
+func (s *conversionState) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("cannot save state: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("cannot save state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("cannot save state: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("cannot save state: %w", err)
+	}
+	return nil
+}
+
+// scanBlocks finds the data blocks of an uncompressed container file
+// without decoding them, returning the range of all complete blocks and the
+// file's sync marker.
+func scanBlocks(path string) (blockRange, [16]byte, error) {
+	var blocks blockRange
+	f, err := os.Open(path)
+	if err != nil {
+		return blocks, [16]byte{}, err
+	}
+	defer f.Close()
+
+	counter := &countingReader{r: f}
+	r := bufio.NewReader(counter)
+	offset := func() int64 { return counter.n - int64(r.Buffered()) }
+
+	header, err := readOCFHeader(r)
+	if err != nil {
+		return blocks, [16]byte{}, err
+	}
+	blocks.header = offset()
+	blocks.start, blocks.end = blocks.header, blocks.header
+
+	for {
+		if _, err := binary.ReadVarint(r); err != nil {
+			break
+		}
+		size, err := binary.ReadVarint(r)
+		if err != nil || size < 0 {
+			break
+		}
+		if _, err := r.Discard(int(size)); err != nil {
+			break
+		}
+		var marker [16]byte
+		if _, err := io.ReadFull(r, marker[:]); err != nil || marker != header.sync {
+			break
+		}
+		blocks.end = offset()
+	}
+	return blocks, header.sync, nil
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// openBlocks opens a range of blocks of a container file as a container
+// stream of its own: the header followed by the selected blocks.
+func openBlocks(path string, blocks blockRange) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open input: %w", err)
+	}
+	r := io.MultiReader(io.NewSectionReader(f, 0, blocks.header), io.NewSectionReader(f, blocks.start, blocks.end-blocks.start))
+	return readCloser{Reader: r, close: f.Close}, nil
+}
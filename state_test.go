@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/linkedin/goavro/v2"
+)
+
+func TestStateResumesGrownInput(t *testing.T) {
+	// Every Append writes one block, so the file can be grown block by block
+	var buf bytes.Buffer
+	w, err := goavro.NewOCFWriter(goavro.OCFConfig{W: &buf, Schema: messageSchema})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []string{`{"id":1}`, `{"id":2}`} {
+		if err := w.Append([]interface{}{map[string]interface{}{"message": []byte(msg)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data := buf.Bytes()
+
+	dir := t.TempDir()
+	input := filepath.Join(dir, "events.avro")
+	output := filepath.Join(dir, "out", "events.ndjson")
+	statePath := filepath.Join(dir, "state.json")
+	opts := testOptions(t, "message")
+	opts.outputDir, opts.format = filepath.Join(dir, "out"), "ndjson"
+
+	run := func(content []byte) int {
+		t.Helper()
+		if content != nil {
+			if err := os.WriteFile(input, content, 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		state, err := loadState(statePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pending, err := state.plan([]inputFile{{path: input, rel: "events.avro"}}, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, result := range convertAll(pending, 1, state.track(func(in inputFile) fileResult { return convertFile(in, opts) })) {
+			if result.err != nil {
+				t.Fatal(result.err)
+			}
+		}
+		return len(pending)
+	}
+	assertOutput := func(want string) {
+		t.Helper()
+		got, err := os.ReadFile(output)
+		if err != nil || string(got) != want {
+			t.Fatalf("output %q, %v, want %q", got, err, want)
+		}
+	}
+
+	// The second block is still being written
+	run(data[:len(data)-3])
+	assertOutput("{\"id\":1}\n")
+	if n := run(nil); n != 0 {
+		t.Fatalf("converted %d unchanged inputs", n)
+	}
+
+	run(data)
+	assertOutput("{\"id\":1}\n{\"id\":2}\n")
+	if n := run(nil); n != 0 {
+		t.Fatalf("converted %d unchanged inputs", n)
+	}
+
+	// A different file under the same name is converted from the start
+	run(writeMessageOCF(t, `{"id":3}`))
+	assertOutput("{\"id\":3}\n")
+}
+
+func TestScanBlocks(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := goavro.NewOCFWriter(goavro.OCFConfig{W: &buf, Schema: messageSchema})
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := buf.Len()
+	if err := w.Append([]interface{}{map[string]interface{}{"message": []byte(`{}`)}}); err != nil {
+		t.Fatal(err)
+	}
+	end := buf.Len()
+	blocks, _, err := scanBlocks(writeTestFile(t, "events.avro", buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blocks.header != int64(header) || blocks.start != int64(header) || blocks.end != int64(end) {
+		t.Fatalf("scanned %+v, want header %d and end %d", blocks, header, end)
+	}
+}
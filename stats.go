@@ -0,0 +1,463 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"avroparser/pkg/avroconvert"
+)
+
+func runStats(args []string) {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	fields := fs.String("fields", "", "Comma-separated field paths to report the minimum and maximum of, e.g. event_timestamp,event_date")
+	countBy := fs.String("count-by", "", "Comma-separated field paths to count the distinct values of, e.g. event_name")
+	top := fs.Int("top", 20, "With -count-by, how many of the most frequent values to list (0 for all)")
+	field := fs.String("field", "", "Compute -fields and -count-by over this record field's embedded JSON messages instead of whole records (e.g. message)")
+	profile := fs.Bool("profile", false, "Profile every field: null rate, estimated distinct values, range and mean of numbers and most frequent values")
+	profileTop := fs.Int("profile-top", 3, "With -profile, how many of each field's most frequent values to list")
+	format := fs.String("format", "text", "Report format: text or json")
+	logging := addLogFlags(fs)
+	parseFlags(fs, args)
+	logging.setup()
+
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: avroparser stats [-fields a,b] [-count-by event_name] [-profile] [-format text|json] <avro_file|dir|glob|->...")
+		os.Exit(exitFatal)
+	}
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "Unknown report format %q (expected text or json)\n", *format)
+		os.Exit(exitFatal)
+	}
+	if *top < 0 {
+		fmt.Fprintf(os.Stderr, "-top must not be negative, got %d\n", *top)
+		os.Exit(exitFatal)
+	}
+	if *profileTop < 0 {
+		fmt.Fprintf(os.Stderr, "-profile-top must not be negative, got %d\n", *profileTop)
+		os.Exit(exitFatal)
+	}
+	opts := statsOptions{field: *field, fields: splitFieldList(*fields), countBy: splitFieldList(*countBy), profile: *profile}
+	for _, path := range append(append([]string{}, opts.fields...), opts.countBy...) {
+		if path == "" || strings.HasPrefix(path, ".") || strings.HasSuffix(path, ".") || strings.Contains(path, "..") {
+			fmt.Fprintf(os.Stderr, "Invalid field path %q\n", path)
+			os.Exit(exitFatal)
+		}
+	}
+
+	var inputs []inputFile
+	for _, arg := range fs.Args() {
+		inputs = append(inputs, mustExpandInputs(arg)...)
+	}
+
+	var results []*fileStats
+	failed := 0
+	for _, in := range inputs {
+		result, err := collectStats(in.path, opts)
+		if err != nil {
+			slog.Error("Cannot read input", "input", displayPath(in.path), "error", err)
+			failed++
+			continue
+		}
+		results = append(results, result)
+	}
+
+	var total *fileStats
+	if len(results) > 1 {
+		total = newFileStats("total", opts)
+		for _, result := range results {
+			total.add(result)
+		}
+	}
+	var err error
+	if *format == "json" {
+		err = writeStatsJSON(os.Stdout, results, total, *top, *profileTop)
+	} else {
+		err = writeStatsText(os.Stdout, results, total, *top, *profileTop)
+	}
+	if err != nil {
+		slog.Error("Cannot write report", "error", err)
+		os.Exit(exitFatal)
+	}
+
+	switch {
+	case failed == len(inputs):
+		os.Exit(exitFatal)
+	case failed > 0:
+		os.Exit(exitPartial)
+	}
+}
+
+// splitFieldList splits a comma-separated list of field paths.
+func splitFieldList(list string) []string {
+	if list == "" {
+		return nil
+	}
+	var fields []string
+	for _, field := range strings.Split(list, ",") {
+		fields = append(fields, strings.TrimSpace(field))
+	}
+	return fields
+}
+
+// statsOptions selects what collectStats computes besides the block totals.
+type statsOptions struct {
+	field   string   // record field holding the JSON messages, if any
+	fields  []string // field paths to find the range of
+	countBy []string // field paths to count the values of
+	profile bool     // profile every field
+}
+
+// decodes reports whether the records have to be decoded, rather than
+// only their blocks counted.
+func (opts statsOptions) decodes() bool {
+	return len(opts.fields) > 0 || len(opts.countBy) > 0 || opts.profile
+}
+
+// fileStats describes the records of an input, or of several together.
+type fileStats struct {
+	Input             string                 `json:"input"`
+	Codec             string                 `json:"codec,omitempty"`
+	Records           int64                  `json:"records"`
+	Blocks            int                    `json:"blocks"`
+	CompressedBytes   int64                  `json:"compressed_bytes"`
+	UncompressedBytes int64                  `json:"uncompressed_bytes"`
+	Skipped           int                    `json:"skipped,omitempty"` // records that could not be decoded
+	Fields            map[string]*fieldRange `json:"fields,omitempty"`
+	Counts            map[string]valueCounts `json:"counts,omitempty"`
+	Profile           *recordProfile         `json:"-"`
+
+	paths map[string][]string // split field paths, by field
+}
+
+func newFileStats(input string, opts statsOptions) *fileStats {
+	fs := &fileStats{Input: input, paths: make(map[string][]string)}
+	if len(opts.fields) > 0 {
+		fs.Fields = make(map[string]*fieldRange)
+		for _, field := range opts.fields {
+			fs.Fields[field] = &fieldRange{}
+			fs.paths[field] = strings.Split(field, ".")
+		}
+	}
+	if len(opts.countBy) > 0 {
+		fs.Counts = make(map[string]valueCounts)
+		for _, field := range opts.countBy {
+			fs.Counts[field] = make(valueCounts)
+			fs.paths[field] = strings.Split(field, ".")
+		}
+	}
+	if opts.profile {
+		fs.Profile = newRecordProfile()
+	}
+	return fs
+}
+
+// add accumulates the statistics of other into fs.
+func (fs *fileStats) add(other *fileStats) {
+	if fs.Codec == "" {
+		fs.Codec = other.Codec
+	} else if fs.Codec != other.Codec {
+		fs.Codec = "mixed"
+	}
+	fs.Records += other.Records
+	fs.Blocks += other.Blocks
+	fs.CompressedBytes += other.CompressedBytes
+	fs.UncompressedBytes += other.UncompressedBytes
+	fs.Skipped += other.Skipped
+	for field, r := range other.Fields {
+		fs.Fields[field].merge(r)
+	}
+	for field, counts := range other.Counts {
+		for value, n := range counts {
+			fs.Counts[field][value] += n
+		}
+	}
+	if other.Profile != nil {
+		fs.Profile.merge(other.Profile)
+	}
+}
+
+// WriteRecord takes the ranges and value counts of a converted message, so
+// fileStats is the sink the records of an input are decoded into.
+func (fs *fileStats) WriteRecord(msg json.RawMessage) error {
+	v, err := parseMessage(msg)
+	if err != nil {
+		return err
+	}
+	for field, r := range fs.Fields {
+		r.add(lookupPath(v, fs.paths[field]))
+	}
+	for field, counts := range fs.Counts {
+		counts[countKey(lookupPath(v, fs.paths[field]))]++
+	}
+	if fs.Profile != nil {
+		fs.Profile.add(v)
+	}
+	return nil
+}
+
+func (fs *fileStats) Flush() error { return nil }
+
+func (fs *fileStats) Close() error { return nil }
+
+// fieldRange is the smallest and largest value of a field. Strings and
+// numbers are ordered as -filter orders them; other values are left out.
+type fieldRange struct {
+	Min   interface{} `json:"min"`
+	Max   interface{} `json:"max"`
+	Count int64       `json:"count"` // records the range was taken over
+}
+
+func (fr *fieldRange) add(v interface{}) {
+	switch v.(type) {
+	case nil, bool, map[string]interface{}, []interface{}:
+		return
+	}
+	if fr.Count == 0 {
+		fr.Min, fr.Max, fr.Count = v, v, 1
+		return
+	}
+	if c, ok := filterCompare(v, fr.Min); !ok {
+		return
+	} else if c < 0 {
+		fr.Min = v
+	}
+	if c, ok := filterCompare(v, fr.Max); ok && c > 0 {
+		fr.Max = v
+	}
+	fr.Count++
+}
+
+func (fr *fieldRange) merge(other *fieldRange) {
+	if other.Count == 0 {
+		return
+	}
+	count := fr.Count
+	fr.add(other.Min)
+	fr.add(other.Max)
+	fr.Count = count + other.Count
+}
+
+// valueCounts counts the records by the value of a field.
+type valueCounts map[string]int64
+
+// countKey renders a value to count it by: strings as they are, null and
+// missing values as "(null)", and anything else as JSON.
+func countKey(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return "(null)"
+	case string:
+		return t
+	}
+	text, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(text)
+}
+
+// valueCount is a value and how many records have it.
+type valueCount struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// top returns the n most frequent values, most frequent first and then by
+// value, or all of them when n is 0.
+func (vc valueCounts) top(n int) []valueCount {
+	values := make([]valueCount, 0, len(vc))
+	for value, count := range vc {
+		values = append(values, valueCount{value, count})
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if values[i].Count != values[j].Count {
+			return values[i].Count > values[j].Count
+		}
+		return values[i].Value < values[j].Value
+	})
+	if n > 0 && len(values) > n {
+		values = values[:n]
+	}
+	return values
+}
+
+// collectStats reads an input's blocks and, when opts ask for field
+// statistics, decodes its records. Records that cannot be decoded are
+// reported and counted as skipped.
+func collectStats(path string, opts statsOptions) (*fileStats, error) {
+	input, err := openInput(path)
+	if err != nil {
+		return nil, err
+	}
+	defer input.Close()
+	stats := newFileStats(path, opts)
+
+	if !opts.decodes() {
+		blocks, err := newOCFReader(input)
+		if err != nil {
+			return nil, err
+		}
+		defer blocks.Close()
+		stats.Codec = blocks.header.codec()
+		for {
+			count, data, err := blocks.next()
+			if errors.Is(err, io.EOF) {
+				return stats, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			raw, err := blocks.decompress(data)
+			if err != nil {
+				return nil, fmt.Errorf("cannot decompress block %d: %w", stats.Blocks+1, err)
+			}
+			stats.Records += int64(count)
+			stats.Blocks++
+			stats.CompressedBytes += int64(len(data))
+			stats.UncompressedBytes += int64(len(raw))
+		}
+	}
+
+	records, err := newOCFRecordReader(bufio.NewReader(input), 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer records.Close()
+	schema, err := avroconvert.ParseSchema(records.codec.Schema())
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse writer schema: %w", err)
+	}
+	converter, err := avroconvert.NewJSONConverter(avroconvert.ConverterOptions{TimeZone: "UTC"})
+	if err != nil {
+		return nil, err
+	}
+	decoder := avroconvert.NewDecoder(avroconvert.DecoderOptions{
+		Field:     opts.field,
+		Converter: converter,
+		Logger:    slog.Default().With("input", displayPath(path)),
+	})
+	decoded, err := decoder.DecodeRecords(records, schema, stats)
+	if err != nil {
+		return nil, err
+	}
+	if err := records.Err(); err != nil {
+		return nil, err
+	}
+	stats.Codec = records.blocks.header.codec()
+	stats.Records = int64(decoded.Read)
+	stats.Blocks = records.blockCount
+	stats.CompressedBytes = records.compressed
+	stats.UncompressedBytes = records.uncompressed
+	stats.Skipped = decoded.Skipped
+	return stats, nil
+}
+
+// writeStatsText writes the statistics of each input, and their total if
+// set, for people to read.
+func writeStatsText(w io.Writer, results []*fileStats, total *fileStats, top, profileTop int) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for i, stats := range append(results, total) {
+		if stats == nil {
+			break
+		}
+		if i > 0 {
+			fmt.Fprintln(tw)
+		}
+		fmt.Fprintf(tw, "%s\n", displayPath(stats.Input))
+		fmt.Fprintf(tw, "  Records:\t%s\n", formatCount(stats.Records))
+		fmt.Fprintf(tw, "  Blocks:\t%s\n", formatCount(int64(stats.Blocks)))
+		fmt.Fprintf(tw, "  Codec:\t%s\n", stats.Codec)
+		fmt.Fprintf(tw, "  Compressed size:\t%s\n", formatBytes(stats.CompressedBytes))
+		fmt.Fprintf(tw, "  Uncompressed size:\t%s\n", formatBytes(stats.UncompressedBytes))
+		if stats.Skipped > 0 {
+			fmt.Fprintf(tw, "  Skipped records:\t%s\n", formatCount(int64(stats.Skipped)))
+		}
+		for _, field := range sortedKeys(stats.Fields) {
+			r := stats.Fields[field]
+			if r.Count == 0 {
+				fmt.Fprintf(tw, "  %s:\tno values\n", field)
+				continue
+			}
+			fmt.Fprintf(tw, "  %s:\tmin %v, max %v\n", field, r.Min, r.Max)
+		}
+		for _, field := range sortedKeys(stats.Counts) {
+			counts := stats.Counts[field]
+			fmt.Fprintf(tw, "  %s:\t%s distinct\n", field, formatCount(int64(len(counts))))
+			for _, value := range counts.top(top) {
+				fmt.Fprintf(tw, "    %s\t%s\n", value.Value, formatCount(value.Count))
+			}
+		}
+		if stats.Profile != nil {
+			writeProfileText(tw, stats.Profile, profileTop)
+		}
+	}
+	return tw.Flush()
+}
+
+// statsReport is the JSON form of the statistics.
+type statsReport struct {
+	Inputs []statsReportEntry `json:"inputs"`
+	Total  *statsReportEntry  `json:"total,omitempty"`
+}
+
+type statsReportEntry struct {
+	*fileStats
+	Counts  map[string]statsReportCounts  `json:"counts,omitempty"`
+	Profile map[string]fieldProfileReport `json:"profile,omitempty"`
+}
+
+type statsReportCounts struct {
+	Distinct int          `json:"distinct"`
+	Top      []valueCount `json:"top"`
+}
+
+func newStatsReportEntry(stats *fileStats, top, profileTop int) statsReportEntry {
+	entry := statsReportEntry{fileStats: stats}
+	if stats.Counts != nil {
+		entry.Counts = make(map[string]statsReportCounts)
+		for field, counts := range stats.Counts {
+			entry.Counts[field] = statsReportCounts{Distinct: len(counts), Top: counts.top(top)}
+		}
+	}
+	if stats.Profile != nil {
+		entry.Profile = stats.Profile.report(profileTop)
+	}
+	return entry
+}
+
+// writeStatsJSON writes the statistics as a JSON document.
+func writeStatsJSON(w io.Writer, results []*fileStats, total *fileStats, top, profileTop int) error {
+	report := statsReport{Inputs: []statsReportEntry{}}
+	for _, stats := range results {
+		report.Inputs = append(report.Inputs, newStatsReportEntry(stats, top, profileTop))
+	}
+	if total != nil {
+		entry := newStatsReportEntry(total, top, profileTop)
+		report.Total = &entry
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// sortedKeys returns the keys of a map in order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCollectStats(t *testing.T) {
+	data := writeTestOCF(t, ocfCodecDeflate, 300, nil, nil)
+	path := writeTestFile(t, "events.avro", data)
+
+	// Without fields only the blocks are read
+	blocks, err := collectStats(path, statsOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blocks.Records != 300 || blocks.Blocks < 2 || blocks.Codec != ocfCodecDeflate || blocks.CompressedBytes >= blocks.UncompressedBytes {
+		t.Fatalf("stats %+v", blocks)
+	}
+
+	stats, err := collectStats(path, statsOptions{fields: []string{"id", "name"}, countBy: []string{"name"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Records != 300 || stats.Blocks != blocks.Blocks || stats.CompressedBytes != blocks.CompressedBytes || stats.UncompressedBytes != blocks.UncompressedBytes {
+		t.Fatalf("decoding stats %+v, block stats %+v", stats, blocks)
+	}
+	id, name := stats.Fields["id"], stats.Fields["name"]
+	if id.Min != json.Number("0") || id.Max != json.Number("299") || id.Count != 300 || name.Min != "event-0" || name.Max != "event-1" {
+		t.Fatalf("ranges %+v and %+v", id, name)
+	}
+	if want := (valueCounts{"event-0": 200, "event-1": 100}); !reflect.DeepEqual(stats.Counts["name"], want) {
+		t.Fatalf("counts %v", stats.Counts["name"])
+	}
+
+	// Totals merge ranges and counts
+	total := newFileStats("total", statsOptions{fields: []string{"id", "name"}, countBy: []string{"name"}})
+	total.add(stats)
+	total.add(stats)
+	if total.Records != 600 || total.Fields["id"].Count != 600 || total.Fields["id"].Max != json.Number("299") || total.Counts["name"]["event-1"] != 200 {
+		t.Fatalf("total %+v", total)
+	}
+}
+
+func TestCollectStatsMessages(t *testing.T) {
+	data := writeMessageOCF(t,
+		`{"level": 3, "country": "DE", "ts": "2026-01-10T12:00:00Z"}`,
+		`{"level": 12, "country": null, "ts": "2026-01-09T08:00:00Z"}`,
+		`{"level": "high", "country": "DE"}`,
+		`{"country": {"code": "US"}}`,
+	)
+	stats, err := collectStats(writeTestFile(t, "messages.avro", data), statsOptions{field: "message", fields: []string{"level", "ts"}, countBy: []string{"country"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Values that cannot be ordered with the rest are left out of ranges
+	level, ts := stats.Fields["level"], stats.Fields["ts"]
+	if level.Min != json.Number("3") || level.Max != json.Number("12") || level.Count != 2 {
+		t.Fatalf("level range %+v", level)
+	}
+	if ts.Min != "2026-01-09T08:00:00Z" || ts.Max != "2026-01-10T12:00:00Z" || ts.Count != 2 {
+		t.Fatalf("ts range %+v", ts)
+	}
+	want := valueCounts{"DE": 2, "(null)": 1, `{"code":"US"}`: 1}
+	if !reflect.DeepEqual(stats.Counts["country"], want) {
+		t.Fatalf("counts %v", stats.Counts["country"])
+	}
+	if top := stats.Counts["country"].top(2); !reflect.DeepEqual(top, []valueCount{{"DE", 2}, {"(null)", 1}}) {
+		t.Fatalf("top values %v", top)
+	}
+
+	// -profile reports the null rate of every field, nested ones flattened
+	stats, err = collectStats(writeTestFile(t, "messages.avro", data), statsOptions{field: "message", profile: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := stats.Profile.report(1)
+	if report["country"].NullRate != 0.5 || report["ts"].NullRate != 0.5 || report["level"].Nulls != 1 || report["country.code"].Values != 1 {
+		t.Fatalf("profile %+v", report)
+	}
+}
+
+func TestWriteStats(t *testing.T) {
+	stats, err := collectStats(writeTestFile(t, "events.avro", writeTestOCF(t, ocfCodecNull, 300, nil, nil)), statsOptions{countBy: []string{"name"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var text bytes.Buffer
+	if err := writeStatsText(&text, []*fileStats{stats}, nil, 1, 0); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"Records:            300", "name:               2 distinct", "    event-0           200\n"} {
+		if !strings.Contains(text.String(), line) {
+			t.Fatalf("report has no %q:\n%s", line, text.String())
+		}
+	}
+
+	var report bytes.Buffer
+	if err := writeStatsJSON(&report, []*fileStats{stats}, stats, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	var parsed struct {
+		Inputs []struct {
+			Records int64                        `json:"records"`
+			Counts  map[string]statsReportCounts `json:"counts"`
+		} `json:"inputs"`
+		Total *struct{} `json:"total"`
+	}
+	if err := json.Unmarshal(report.Bytes(), &parsed); err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed.Inputs) != 1 || parsed.Inputs[0].Records != 300 || parsed.Inputs[0].Counts["name"].Distinct != 2 || parsed.Total == nil {
+		t.Fatalf("report %s", report.String())
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"avroparser/pkg/avroconvert"
+)
+
+// runSummary is the machine-readable account of a run written with
+// -summary-json, for schedulers deciding whether a run succeeded.
+type runSummary struct {
+	Status   string         `json:"status"` // ok, partial or failed, following the exit code
+	ExitCode int            `json:"exit_code"`
+	Started  time.Time      `json:"started"`
+	Duration float64        `json:"duration_seconds"`
+	Files    summaryFiles   `json:"files"`
+	Records  summaryRecords `json:"records"`
+	// InputBytes is the size of the inputs whose size is known, as read
+	// before decompression.
+	InputBytes int64          `json:"input_bytes"`
+	Errors     map[string]int `json:"errors"` // failed records by reason
+	Inputs     []summaryInput `json:"inputs"`
+}
+
+type summaryFiles struct {
+	Total     int `json:"total"`
+	Converted int `json:"converted"`
+	Failed    int `json:"failed"`
+	Skipped   int `json:"skipped"` // with -skip-existing
+}
+
+type summaryRecords struct {
+	Read        int `json:"read"`
+	Written     int `json:"written"`
+	Failed      int `json:"failed"`
+	InvalidJSON int `json:"invalid_json"`
+	Filtered    int `json:"filtered"`
+}
+
+type summaryInput struct {
+	Input      string         `json:"input"`
+	Output     string         `json:"output"`
+	Status     string         `json:"status"` // ok, failed or skipped
+	Error      string         `json:"error,omitempty"`
+	InputBytes *int64         `json:"input_bytes,omitempty"` // left out when unknown
+	Duration   float64        `json:"duration_seconds"`
+	Records    summaryRecords `json:"records"`
+	Errors     map[string]int `json:"errors,omitempty"`
+}
+
+func newSummaryRecords(stats avroconvert.Stats) summaryRecords {
+	return summaryRecords{Read: stats.Read, Written: stats.Messages, Failed: stats.Skipped, InvalidJSON: stats.InvalidJSON, Filtered: stats.Filtered}
+}
+
+// writeRunSummary writes the summary of a run that started at start, took
+// wall and ends with the exit code code to path as JSON.
+func writeRunSummary(path string, results []fileResult, start time.Time, wall time.Duration, code int) error {
+	summary := runSummary{
+		Status:   "ok",
+		ExitCode: code,
+		Started:  start.UTC(),
+		Duration: wall.Seconds(),
+		Errors:   map[string]int{},
+		Inputs:   []summaryInput{},
+	}
+	switch code {
+	case exitPartial:
+		summary.Status = "partial"
+	case exitFatal:
+		summary.Status = "failed"
+	}
+
+	var total avroconvert.Stats
+	for _, result := range results {
+		input := summaryInput{
+			Input:    result.input.path,
+			Output:   displayPath(result.output),
+			Status:   "ok",
+			Duration: result.duration.Seconds(),
+			Records:  newSummaryRecords(result.stats),
+			Errors:   result.stats.Failures,
+		}
+		switch {
+		case result.err != nil:
+			input.Status, input.Error = "failed", result.err.Error()
+			summary.Files.Failed++
+		case result.skipped:
+			input.Status = "skipped"
+			summary.Files.Skipped++
+		default:
+			summary.Files.Converted++
+		}
+		if size := inputSize(result.input); size >= 0 {
+			input.InputBytes = &size
+			summary.InputBytes += size
+		}
+		summary.Inputs = append(summary.Inputs, input)
+		total.Add(result.stats)
+	}
+	summary.Files.Total = len(results)
+	summary.Records = newSummaryRecords(total)
+	for reason, n := range total.Failures {
+		summary.Errors[reason] = n
+	}
+
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"avroparser/pkg/avroconvert"
+)
+
+func TestWriteRunSummary(t *testing.T) {
+	data := writeMessageOCF(t, `{"id":1}`)
+	in := inputFile{path: writeTestFile(t, "a.avro", data), rel: "a.avro"}
+	results := []fileResult{
+		{input: in, output: "out/a.json", duration: time.Second, stats: avroconvert.Stats{
+			Read: 5, Messages: 3, Skipped: 2, Failures: map[string]int{"Skipping unreadable record": 2},
+		}},
+		{input: inputFile{path: stdioPath}, output: stdioPath, err: errors.New("cannot read"), stats: avroconvert.Stats{
+			Read: 1, Skipped: 1, Failures: map[string]int{"Skipping unreadable record": 1},
+		}},
+	}
+	path := filepath.Join(t.TempDir(), "summary.json")
+	start := time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC)
+	if err := writeRunSummary(path, results, start, 2*time.Second, exitPartial); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var summary runSummary
+	if err := json.Unmarshal(raw, &summary); err != nil {
+		t.Fatal(err)
+	}
+
+	if summary.Status != "partial" || summary.ExitCode != exitPartial || !summary.Started.Equal(start) || summary.Duration != 2 {
+		t.Fatalf("summary %+v", summary)
+	}
+	if (summary.Files != summaryFiles{Total: 2, Converted: 1, Failed: 1}) || (summary.Records != summaryRecords{Read: 6, Written: 3, Failed: 3}) {
+		t.Fatalf("counted %+v and %+v", summary.Files, summary.Records)
+	}
+	if !reflect.DeepEqual(summary.Errors, map[string]int{"Skipping unreadable record": 3}) || summary.InputBytes != int64(len(data)) {
+		t.Fatalf("errors %v, %d input bytes", summary.Errors, summary.InputBytes)
+	}
+
+	// Inputs of unknown size have no input_bytes
+	first, second := summary.Inputs[0], summary.Inputs[1]
+	if first.Status != "ok" || first.InputBytes == nil || *first.InputBytes != int64(len(data)) || first.Output != "out/a.json" {
+		t.Fatalf("first input %+v", first)
+	}
+	if second.Status != "failed" || second.Error != "cannot read" || second.InputBytes != nil || second.Output != "stdout" {
+		t.Fatalf("second input %+v", second)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"avroparser/pkg/avroconvert"
+)
+
+// timeRange keeps the records whose timestamp field is at or after since
+// and before until. A zero bound is open.
+type timeRange struct {
+	path   []string
+	layout string // Go layout of -time-format, for timestamps formatted with one
+	since  time.Time
+	until  time.Time
+}
+
+// parseTimeRange parses the -since and -until bounds, returning nil when
+// neither is given. timeFormat is the -time-format timestamps are converted
+// with.
+func parseTimeRange(field, since, until, timeFormat string) (*timeRange, error) {
+	if since == "" && until == "" {
+		return nil, nil
+	}
+	if field == "" || strings.HasPrefix(field, ".") || strings.HasSuffix(field, ".") || strings.Contains(field, "..") {
+		return nil, fmt.Errorf("invalid -timestamp-field %q", field)
+	}
+	tr := &timeRange{path: strings.Split(field, "."), layout: timeLayout(timeFormat)}
+
+	var err error
+	if since != "" {
+		if tr.since, err = parseTimeBound(since); err != nil {
+			return nil, fmt.Errorf("invalid -since: %w", err)
+		}
+	}
+	if until != "" {
+		if tr.until, err = parseTimeBound(until); err != nil {
+			return nil, fmt.Errorf("invalid -until: %w", err)
+		}
+	}
+	if !tr.since.IsZero() && !tr.until.IsZero() && !tr.since.Before(tr.until) {
+		return nil, fmt.Errorf("-since %s is not before -until %s", since, until)
+	}
+	return tr, nil
+}
+
+// timeLayout returns the Go layout of a -time-format given as one, or ""
+// for the named formats, which eventTime recognizes by themselves.
+func timeLayout(timeFormat string) string {
+	switch timeFormat {
+	case avroconvert.TimeFormatRFC3339, avroconvert.TimeFormatUnix, avroconvert.TimeFormatUnixMilli,
+		avroconvert.TimeFormatUnixMicro, avroconvert.TimeFormatUnixNano:
+		return ""
+	}
+	return timeFormat
+}
+
+// parseTimeBound parses an RFC 3339 time, a date (midnight UTC) or a Unix
+// epoch number.
+func parseTimeBound(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return epochTime(float64(n), n), nil
+	}
+	return time.Time{}, fmt.Errorf("%q is neither an RFC 3339 time, a date nor a Unix epoch number", s)
+}
+
+// epochTime interprets an epoch number as seconds, milliseconds,
+// microseconds or nanoseconds by its magnitude: times between 1973 and 5138
+// are told apart this way. n is the exact value of integral numbers.
+func epochTime(f float64, n int64) time.Time {
+	switch abs := math.Abs(f); {
+	case abs < 1e11:
+		sec, frac := math.Modf(f)
+		return time.Unix(int64(sec), int64(frac*1e9)).UTC()
+	case abs < 1e14:
+		return time.UnixMilli(n).UTC()
+	case abs < 1e17:
+		return time.UnixMicro(n).UTC()
+	default:
+		return time.Unix(0, n).UTC()
+	}
+}
+
+// eventTime returns the time a converted timestamp value stands for: epoch
+// numbers, as for parseTimeBound, and strings in RFC 3339 or layout, if
+// set.
+func eventTime(v interface{}, layout string) (time.Time, bool) {
+	if s, ok := v.(string); ok {
+		if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			return t, true
+		}
+		if layout != "" {
+			if t, err := time.Parse(layout, s); err == nil {
+				return t, true
+			}
+		}
+	}
+	n, _ := filterNumeric(v)
+	switch n := n.(type) {
+	case int64:
+		return epochTime(float64(n), n), true
+	case float64:
+		if math.IsNaN(n) || math.IsInf(n, 0) {
+			return time.Time{}, false
+		}
+		return epochTime(n, int64(n)), true
+	}
+	return time.Time{}, false
+}
+
+// contains reports whether a converted record's timestamp is in the range.
+// Records without a timestamp aren't.
+func (tr *timeRange) contains(record interface{}) bool {
+	t, ok := eventTime(lookupPath(record, tr.path), tr.layout)
+	if !ok {
+		return false
+	}
+	return (tr.since.IsZero() || !t.Before(tr.since)) && (tr.until.IsZero() || t.Before(tr.until))
+}
+
+// and returns a filter keeping the records in the range that filter, if
+// not nil, keeps.
+func (tr *timeRange) and(filter *recordFilter) *recordFilter {
+	return &recordFilter{eval: func(record interface{}) interface{} {
+		if !tr.contains(record) {
+			return false
+		}
+		if filter == nil {
+			return true
+		}
+		return filter.eval(record)
+	}}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"avroparser/pkg/avroconvert"
+)
+
+func TestParseTimeBound(t *testing.T) {
+	want := time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC)
+	for _, s := range []string{"2026-01-10T12:00:00Z", "2026-01-10T13:00:00+01:00", "1768046400", "1768046400000", "1768046400000000", "1768046400000000000"} {
+		got, err := parseTimeBound(s)
+		if err != nil || !got.Equal(want) {
+			t.Errorf("parseTimeBound(%q) = %v, %v", s, got, err)
+		}
+	}
+	if got, err := parseTimeBound("2026-01-10"); err != nil || !got.Equal(want.Add(-12*time.Hour)) {
+		t.Errorf("parsed date as %v, %v", got, err)
+	}
+	if _, err := parseTimeBound("yesterday"); err == nil {
+		t.Error("parsed yesterday")
+	}
+}
+
+func TestTimeRange(t *testing.T) {
+	tr, err := parseTimeRange("event.ts", "2026-01-10", "2026-01-11", "02/01/2006 15:04")
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := func(ts interface{}) interface{} {
+		return map[string]interface{}{"event": map[string]interface{}{"ts": ts}}
+	}
+	for ts, want := range map[interface{}]bool{
+		"2026-01-10T00:00:00Z":       true,
+		"2026-01-10T23:59:59.9Z":     true,
+		"2026-01-11T00:00:00Z":       false,
+		"2026-01-09T23:59:59Z":       false,
+		"10/01/2026 08:30":           true,
+		json.Number("1768046400"):    true,
+		json.Number("1768046400.5"):  true,
+		json.Number("1767225600000"): false,
+		"soon":                       false,
+		nil:                          false,
+	} {
+		if got := tr.contains(record(ts)); got != want {
+			t.Errorf("contains %v = %v, want %v", ts, got, want)
+		}
+	}
+
+	// The range is combined with -filter
+	filter, err := parseFilter(`kind == "start"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	combined := tr.and(filter)
+	in := map[string]interface{}{"kind": "start", "event": map[string]interface{}{"ts": "2026-01-10T08:00:00Z"}}
+	out := map[string]interface{}{"kind": "end", "event": map[string]interface{}{"ts": "2026-01-10T08:00:00Z"}}
+	if !combined.match(in) || combined.match(out) {
+		t.Fatal("combined filter is wrong")
+	}
+}
+
+func TestParseTimeRangeErrors(t *testing.T) {
+	if tr, err := parseTimeRange("ts", "", "", avroconvert.TimeFormatRFC3339); tr != nil || err != nil {
+		t.Fatalf("range %+v without bounds: %v", tr, err)
+	}
+	for _, bounds := range [][3]string{
+		{"", "2026-01-10", ""},
+		{"a..b", "2026-01-10", ""},
+		{"ts", "2026-01-11", "2026-01-10"},
+		{"ts", "2026-01-10", "2026-01-10"},
+		{"ts", "later", ""},
+	} {
+		if _, err := parseTimeRange(bounds[0], bounds[1], bounds[2], avroconvert.TimeFormatRFC3339); err == nil {
+			t.Errorf("parsed range %q", bounds)
+		}
+	}
+}
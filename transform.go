@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/itchyny/gojq"
+)
+
+// recordTransform is a compiled -transform jq program. It runs on every
+// message before it is written and may emit any number of messages in its
+// place: none drops the record, as with select, and several split it.
+type recordTransform struct {
+	code *gojq.Code
+}
+
+// parseTransform compiles a jq expression.
+func parseTransform(expr string) (*recordTransform, error) {
+	query, err := gojq.Parse(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transform: %w", err)
+	}
+	code, err := gojq.Compile(query)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transform: %w", err)
+	}
+	return &recordTransform{code: code}, nil
+}
+
+// apply runs the program on a JSON message and returns its outputs as JSON.
+func (t *recordTransform) apply(msg json.RawMessage) ([]json.RawMessage, error) {
+	// parseMessage keeps numbers as json.Number, which gojq handles exactly
+	v, err := parseMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	var outputs []json.RawMessage
+	iter := t.code.Run(v)
+	for {
+		result, ok := iter.Next()
+		if !ok {
+			return outputs, nil
+		}
+		if err, ok := result.(error); ok {
+			return nil, err
+		}
+		text, err := gojq.Marshal(result)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, text)
+	}
+}
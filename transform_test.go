@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"avroparser/pkg/avroconvert"
+)
+
+func TestDecodeTransform(t *testing.T) {
+	for expr, want := range map[string]string{
+		`{id, user}`:                                     "{\"id\":1,\"user\":\"ana\"}\n{\"id\":2,\"user\":\"ana\"}\n",
+		`select(.id > 1) | .props`:                       "{\"level\":3,\"none\":null}\n",
+		`.tags[] | {tag: .}`:                             "{\"tag\":\"a\"}\n{\"tag\":\"a\"}\n",
+		`.id * 10000000000000000000`:                     "10000000000000000000\n20000000000000000000\n",
+		`if .id == 1 then . else error("bad") end | .id`: "1\n",
+	} {
+		transform, err := parseTransform(expr)
+		if err != nil {
+			t.Fatalf("%s: %v", expr, err)
+		}
+		opts := testOptions(t, "")
+		opts.transform = transform
+
+		var out bytes.Buffer
+		data := writeEventOCF(t, testEvent(1), testEvent(2))
+		if _, err := decodeMessages(bytes.NewReader(data), "test.avro", opts, avroconvert.NewNDJSONSink(&out)); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != want {
+			t.Errorf("%s: wrote %q, want %q", expr, out.String(), want)
+		}
+	}
+
+	if _, err := parseTransform(`{id`); err == nil {
+		t.Fatal("parsed an invalid transform")
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"avroparser/pkg/avroconvert"
+)
+
+// unifiedSchema reads the writer schemas of inputs, only their headers, and
+// returns a schema all of them can be read as, for -unify-schemas.
+func unifiedSchema(inputs []inputFile) (*avroconvert.Schema, error) {
+	var schemas []*avroconvert.Schema
+	seen := make(map[string]bool)
+	for _, in := range inputs {
+		spec, err := readWriterSchema(in.path)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read writer schema of %s: %w", displayPath(in.path), err)
+		}
+		var compact bytes.Buffer
+		if json.Compact(&compact, spec) == nil {
+			spec = compact.Bytes()
+		}
+		if seen[string(spec)] {
+			continue
+		}
+		seen[string(spec)] = true
+		schema, err := avroconvert.ParseSchema(string(spec))
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse writer schema of %s: %w", displayPath(in.path), err)
+		}
+		schemas = append(schemas, schema)
+	}
+	unified, err := avroconvert.UnifySchemas(schemas...)
+	if err != nil {
+		return nil, fmt.Errorf("cannot unify writer schemas: %w", err)
+	}
+	slog.Info("Unified writer schemas", "inputs", len(inputs), "schemas", len(schemas))
+	return unified, nil
+}
+
+// readWriterSchema returns the writer schema JSON in the header of an Avro
+// container file.
+func readWriterSchema(path string) ([]byte, error) {
+	if path == stdioPath {
+		return nil, fmt.Errorf("stdin cannot be read twice")
+	}
+	input, err := openInput(path)
+	if err != nil {
+		return nil, err
+	}
+	defer input.Close()
+	header, err := readOCFHeader(bufio.NewReader(input))
+	if err != nil {
+		return nil, err
+	}
+	return header.schema(), nil
+}
+
+// unifiedColumns collects the CSV columns of the records of every input, for
+// -unify-schemas, so each output gets all of them in the same order.
+func unifiedColumns(inputs []inputFile, opts csvOptions) ([]csvColumn, error) {
+	collector := newColumnCollector(opts.flatten)
+	// Records that fail are reported when the rows are written
+	quiet := opts.decode
+	quiet.quiet = true
+	for _, in := range inputs {
+		quiet.blocks = in.blocks
+		if _, err := decodeFile(in.path, in.path, quiet, collector); err != nil {
+			return nil, fmt.Errorf("%s: %w", displayPath(in.path), err)
+		}
+	}
+	columns := make([]csvColumn, len(collector.names))
+	for i, name := range collector.names {
+		columns[i] = csvColumn{Name: name}
+	}
+	slog.Info("Collected the columns of all inputs", "inputs", len(inputs), "columns", len(columns))
+	return columns, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/linkedin/goavro/v2"
+
+	"avroparser/pkg/avroconvert"
+)
+
+func TestUnifiedSchema(t *testing.T) {
+	writeVersion := func(name, schema string, record map[string]interface{}) inputFile {
+		t.Helper()
+		var buf bytes.Buffer
+		w, err := goavro.NewOCFWriter(goavro.OCFConfig{W: &buf, Schema: schema})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Append([]interface{}{record}); err != nil {
+			t.Fatal(err)
+		}
+		return inputFile{path: writeTestFile(t, name, buf.Bytes()), rel: name}
+	}
+	inputs := []inputFile{
+		writeVersion("v1.avro", `{"type":"record","name":"Score","fields":[{"name":"id","type":"int"},{"name":"player","type":"string"}]}`,
+			map[string]interface{}{"id": int32(1), "player": "ana"}),
+		writeVersion("v2.avro", `{"type":"record","name":"Score","fields":[{"name":"id","type":"long"},{"name":"region","type":"string"}]}`,
+			map[string]interface{}{"id": int64(2), "region": "eu"}),
+		// The same schema again is only unified once
+		writeVersion("v2b.avro", `{"type": "record", "name": "Score", "fields": [{"name": "id", "type": "long"}, {"name": "region", "type": "string"}]}`,
+			map[string]interface{}{"id": int64(3), "region": "us"}),
+	}
+	unified, err := unifiedSchema(inputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := testOptions(t, "")
+	opts.readerSchema = unified
+	var out bytes.Buffer
+	for _, in := range inputs {
+		if _, err := decodeFile(in.path, in.path, opts, avroconvert.NewNDJSONSink(&out)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := `{"id":1,"player":"ana","region":null}` + "\n" +
+		`{"id":2,"player":null,"region":"eu"}` + "\n" +
+		`{"id":3,"player":null,"region":"us"}` + "\n"
+	if out.String() != want {
+		t.Fatalf("decoded\n%s\nwant\n%s", out.String(), want)
+	}
+
+	if _, err := unifiedSchema([]inputFile{{path: writeTestFile(t, "events.ndjson", []byte("{}\n"))}}); err == nil {
+		t.Error("unified the schema of an NDJSON file")
+	}
+}
+
+func TestUnifiedColumns(t *testing.T) {
+	inputs := []inputFile{
+		{path: writeTestFile(t, "a.avro", writeMessageOCF(t, `{"id":1,"level":3}`)), rel: "a.avro"},
+		{path: writeTestFile(t, "b.avro", writeMessageOCF(t, `{"id":2,"user":{"name":"ana"}}`)), rel: "b.avro"},
+	}
+	opts := csvOptions{decode: testOptions(t, "message"), flatten: flattener{separator: "."}}
+	columns, err := unifiedColumns(inputs, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, c := range columns {
+		names = append(names, c.Name)
+	}
+	if got := fmt.Sprint(names); got != "[id level user.name]" {
+		t.Fatalf("collected columns %s", got)
+	}
+}
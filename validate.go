@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/linkedin/goavro/v2"
+)
+
+func runValidate(args []string) {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	maxProblems := fs.Int("max-problems", 100, "Stop checking a file after this many problems (0 for no limit)")
+	records := fs.Bool("records", true, "Decode every record to check it against the schema; with -records=false only the file structure is checked")
+	logging := addLogFlags(fs)
+	parseFlags(fs, args)
+	logging.setup()
+
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: avroparser validate [-max-problems n] [-records=false] <avro_file|dir|glob|->...")
+		os.Exit(exitFatal)
+	}
+	if *maxProblems < 0 {
+		fmt.Fprintf(os.Stderr, "-max-problems must not be negative, got %d\n", *maxProblems)
+		os.Exit(exitFatal)
+	}
+
+	var inputs []inputFile
+	for _, arg := range fs.Args() {
+		inputs = append(inputs, mustExpandInputs(arg)...)
+	}
+
+	invalid, failed := 0, 0
+	for _, in := range inputs {
+		result, err := validateFile(in.path, *maxProblems, *records, func(p ocfProblem) {
+			fmt.Printf("%s: %s\n", displayPath(in.path), p)
+		})
+		if err != nil {
+			slog.Error("Cannot validate input", "input", displayPath(in.path), "error", err)
+			failed++
+			continue
+		}
+		if result.problems > 0 {
+			invalid++
+		}
+		slog.Info("Validated file", "input", displayPath(in.path), "blocks", result.blocks, "records", result.records, "problems", result.problems)
+	}
+
+	if len(inputs) > 1 {
+		slog.Info("Validated files", "valid", len(inputs)-invalid-failed, "of", len(inputs))
+	}
+	switch {
+	case failed == len(inputs):
+		os.Exit(exitFatal)
+	case invalid > 0 || failed > 0:
+		os.Exit(exitPartial)
+	}
+}
+
+// ocfProblem is something wrong with a container file, found at offset
+// bytes into it. block and record count from 1, and are 0 when the problem
+// isn't about a particular block or record.
+type ocfProblem struct {
+	offset int64
+	block  int
+	record int
+	msg    string
+}
+
+func (p ocfProblem) String() string {
+	where := fmt.Sprintf("offset %d", p.offset)
+	if p.block > 0 {
+		where += fmt.Sprintf(", block %d", p.block)
+	}
+	if p.record > 0 {
+		where += fmt.Sprintf(", record %d", p.record)
+	}
+	return where + ": " + p.msg
+}
+
+// validation counts what validateFile checked.
+type validation struct {
+	blocks   int
+	records  int
+	problems int
+}
+
+// validateFile checks the structure of a container file: its header and
+// schema, the framing, compression and sync marker of every block and, with
+// records set, that every record decodes with the schema and fills its
+// block exactly. Problems are passed to report as they are found. After a
+// damaged block, checking resumes after the next sync marker. Only errors
+// opening or reading the input are returned.
+func validateFile(path string, maxProblems int, records bool, report func(ocfProblem)) (validation, error) {
+	var v validation
+	input, err := openInput(path)
+	if err != nil {
+		return v, err
+	}
+	defer input.Close()
+
+	counter := &countingReader{r: input}
+	r := bufio.NewReader(counter)
+	offset := func() int64 { return counter.n - int64(r.Buffered()) }
+	// problem reports p and whether to go on checking
+	problem := func(p ocfProblem) bool {
+		v.problems++
+		report(p)
+		return maxProblems == 0 || v.problems < maxProblems
+	}
+
+	header, err := readOCFHeader(r)
+	if err != nil {
+		problem(ocfProblem{offset: offset(), msg: "invalid header: " + err.Error()})
+		return v, nil
+	}
+
+	var codec *goavro.Codec
+	schema := header.schema()
+	switch {
+	case len(schema) == 0:
+		if !problem(ocfProblem{msg: "header has no avro.schema"}) {
+			return v, nil
+		}
+	case !json.Valid(schema):
+		if !problem(ocfProblem{msg: "schema is not valid JSON"}) {
+			return v, nil
+		}
+	default:
+		if codec, err = goavro.NewCodec(string(schema)); err != nil {
+			if !problem(ocfProblem{msg: "invalid schema: " + err.Error()}) {
+				return v, nil
+			}
+		}
+	}
+	if !records {
+		codec = nil
+	}
+
+	blocks := &ocfReader{r: r, header: header}
+	decompress := true
+	if blocks.compression, err = newBlockCodec(header.codec()); err == nil {
+		defer blocks.Close()
+	} else {
+		// The blocks can still be framed correctly
+		decompress = false
+		if !problem(ocfProblem{msg: err.Error()}) {
+			return v, nil
+		}
+	}
+
+	for block := 1; ; block++ {
+		start := offset()
+		count, err := binary.ReadVarint(r)
+		if err == io.EOF {
+			return v, nil
+		}
+		var size int64
+		if err == nil {
+			size, err = binary.ReadVarint(r)
+		}
+		if err != nil || count < 0 || size < 0 {
+			msg := fmt.Sprintf("invalid block of %d records in %d bytes", count, size)
+			if err != nil {
+				msg = "cannot read block header: " + err.Error()
+			}
+			if !problem(ocfProblem{offset: start, block: block, msg: msg}) || !skipToSync(r, header.sync) {
+				return v, nil
+			}
+			continue
+		}
+
+		// A corrupt size could be far beyond the end of the file, so the
+		// data isn't allocated up front
+		data, err := io.ReadAll(io.LimitReader(r, size))
+		if err != nil {
+			return v, fmt.Errorf("cannot read input: %w", err)
+		}
+		if int64(len(data)) < size {
+			problem(ocfProblem{offset: start, block: block, msg: fmt.Sprintf("file ends %d bytes into a block of %d bytes", len(data), size)})
+			return v, nil
+		}
+		var marker [16]byte
+		if _, err := io.ReadFull(r, marker[:]); err != nil {
+			problem(ocfProblem{offset: offset(), block: block, msg: "file ends before the block's sync marker"})
+			return v, nil
+		}
+		if marker != header.sync {
+			if !problem(ocfProblem{offset: offset() - 16, block: block, msg: "sync marker mismatch"}) || !skipToSync(r, header.sync) {
+				return v, nil
+			}
+			continue
+		}
+		v.blocks++
+		v.records += int(count)
+
+		if !decompress {
+			continue
+		}
+		raw, err := blocks.decompress(data)
+		if err != nil {
+			if !problem(ocfProblem{offset: start, block: block, msg: "cannot decompress block: " + err.Error()}) {
+				return v, nil
+			}
+			continue
+		}
+		if codec == nil {
+			continue
+		}
+		for record := 1; record <= int(count); record++ {
+			_, rest, err := codec.NativeFromBinary(raw)
+			if err != nil {
+				msg := fmt.Sprintf("record does not match the schema, leaving %d more records of the block unchecked: %v", int(count)-record, err)
+				if !problem(ocfProblem{offset: start, block: block, record: record, msg: msg}) {
+					return v, nil
+				}
+				raw = nil
+				break
+			}
+			raw = rest
+		}
+		if len(raw) > 0 {
+			if !problem(ocfProblem{offset: start, block: block, msg: fmt.Sprintf("%d extra bytes after the block's %d records", len(raw), count)}) {
+				return v, nil
+			}
+		}
+	}
+}
+
+// skipToSync reads up to and including the next occurrence of the sync
+// marker, so checking can resume with the block after it. It reports
+// whether one was found.
+func skipToSync(r *bufio.Reader, sync [16]byte) bool {
+	var window []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return false
+		}
+		window = append(window, b)
+		if len(window) > len(sync) {
+			window = window[1:]
+		}
+		if bytes.Equal(window, sync[:]) {
+			return true
+		}
+	}
+}
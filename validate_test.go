@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// validateTest validates data and returns the problems found.
+func validateTest(t *testing.T, data []byte, maxProblems int) (validation, []string) {
+	t.Helper()
+	var problems []string
+	v, err := validateFile(writeTestFile(t, "test.avro", data), maxProblems, true, func(p ocfProblem) {
+		problems = append(problems, p.String())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v, problems
+}
+
+func TestValidateFile(t *testing.T) {
+	sync := [16]byte{'s', 'y', 'n', 'c', 'm', 'a', 'r', 'k', 'e', 'r', '-', '-', '-', '-', '-', '-'}
+	data := writeTestOCF(t, ocfCodecDeflate, 300, &sync, nil)
+	v, problems := validateTest(t, data, 0)
+	if len(problems) != 0 || v.records != 300 || v.blocks < 3 {
+		t.Fatalf("validated %+v with problems %q", v, problems)
+	}
+	blocks := v.blocks
+
+	// A damaged sync marker of the first block loses it and the next, as
+	// checking resumes after the next marker
+	damaged := bytes.Clone(data)
+	header := bytes.Index(damaged, sync[:]) + len(sync)
+	damaged[header+bytes.Index(damaged[header:], sync[:])] ^= 0xff
+	v, problems = validateTest(t, damaged, 0)
+	if len(problems) != 1 || !strings.Contains(problems[0], "block 1: sync marker mismatch") || v.blocks != blocks-2 {
+		t.Fatalf("validated %+v with problems %q", v, problems)
+	}
+
+	// A truncated file ends the check
+	v, problems = validateTest(t, data[:len(data)-20], 0)
+	if len(problems) != 1 || !strings.Contains(problems[0], "bytes into a block of") || v.blocks != blocks-1 {
+		t.Fatalf("validated %+v with problems %q", v, problems)
+	}
+
+	if _, problems = validateTest(t, []byte("not a container"), 0); len(problems) != 1 || !strings.Contains(problems[0], "invalid header") {
+		t.Fatalf("problems %q", problems)
+	}
+}
+
+func TestValidateFileRecords(t *testing.T) {
+	// Records 5 and 150 get negative payload lengths
+	data := writeTestOCF(t, ocfCodecNull, 300, nil, func(i int) []byte {
+		if i == 5 || i == 150 {
+			return []byte("MARK")
+		}
+		return nil
+	})
+	data = bytes.ReplaceAll(data, []byte("\x08MARK"), []byte("\x07MARK"))
+	v, problems := validateTest(t, data, 0)
+	if len(problems) != 2 || !strings.Contains(problems[0], "block 1, record 6: record does not match the schema") || v.records != 300 {
+		t.Fatalf("validated %+v with problems %q", v, problems)
+	}
+
+	// Checking stops after -max-problems
+	if _, problems := validateTest(t, data, 1); len(problems) != 1 {
+		t.Fatalf("problems %q", problems)
+	}
+}
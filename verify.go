@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"avroparser/pkg/avroconvert"
+)
+
+// verifyReport lists how the output of a conversion differs from a golden
+// file: the records, or CSV rows, that differ, and the CSV columns only one
+// of them has.
+type verifyReport struct {
+	golden, output int64 // records of each
+	differ         int64
+	missingColumns []string // of the golden file, with -format csv
+	extraColumns   []string // not in the golden file, with -format csv
+	records        []recordDiff
+}
+
+func runVerify(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	inputPath := fs.String("input", "", "Input Avro or NDJSON file, directory or glob pattern, or - for stdin")
+	goldenPath := fs.String("golden", "", "Golden file holding the expected output of the conversion")
+	format := fs.String("format", "", "Output format to convert to and compare: json, ndjson or csv (default from the -golden file's extension)")
+	separator := fs.String("separator", ".", "With -format csv, separator joining nested field names into column names (e.g. . or _)")
+	arrays := fs.String("arrays", arraysJSON, "With -format csv, how arrays become columns: json (one column of JSON text) or index (a column per element, e.g. items.0.item_id)")
+	tolerance := fs.Float64("tolerance", 1e-9, "Relative difference up to which numbers are equal, for floats formatted differently (0 for exact)")
+	update := fs.Bool("update", false, "Write the output to the -golden file instead of comparing it, after an intended change")
+	maxDiffs := fs.Int("max-diffs", 20, "Most differing records to list (0 for all); all of them are counted")
+	records := addRecordFlags(fs)
+	csvOutput := addCSVFlags(fs)
+	logging := addLogFlags(fs)
+	parseFlags(fs, args)
+	logging.setup()
+
+	if *inputPath == "" && fs.NArg() > 0 {
+		*inputPath = fs.Arg(0)
+	}
+	if *inputPath == "" || *goldenPath == "" {
+		fmt.Fprintln(os.Stderr, "Usage: avroparser verify -golden <golden_file> [-format json|ndjson|csv] [-update] -input <avro_file|dir|glob|->")
+		os.Exit(exitFatal)
+	}
+	if *format == "" {
+		switch strings.ToLower(filepath.Ext(*goldenPath)) {
+		case ".csv":
+			*format = "csv"
+		case ".json":
+			*format = "json"
+		default:
+			*format = "ndjson"
+		}
+	}
+	if *format != "json" && *format != "ndjson" && *format != "csv" {
+		fmt.Fprintf(os.Stderr, "Unknown format %q (expected json, ndjson or csv)\n", *format)
+		os.Exit(exitFatal)
+	}
+	if *arrays != arraysJSON && *arrays != arraysIndex {
+		fmt.Fprintf(os.Stderr, "Unknown array handling %q (expected json or index)\n", *arrays)
+		os.Exit(exitFatal)
+	}
+	if *tolerance < 0 || *tolerance >= 1 {
+		fmt.Fprintf(os.Stderr, "-tolerance must be at least 0 and less than 1, got %g\n", *tolerance)
+		os.Exit(exitFatal)
+	}
+	if *maxDiffs < 0 {
+		fmt.Fprintf(os.Stderr, "-max-diffs must not be negative, got %d\n", *maxDiffs)
+		os.Exit(exitFatal)
+	}
+	if *records.onError == onErrorCollect {
+		fmt.Fprintln(os.Stderr, "verify cannot collect failed records; use -on-error skip or fail")
+		os.Exit(exitFatal)
+	}
+	dialect, err := csvOutput.dialect()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	opts := mustRecordOptions(records)
+
+	output, err := verifyOutput(mustExpandInputs(*inputPath), *format, opts, flattener{separator: *separator, indexArrays: *arrays == arraysIndex}, dialect)
+	if err != nil {
+		slog.Error("Cannot convert input", "error", err)
+		os.Exit(exitFatal)
+	}
+	if *update {
+		if err := os.WriteFile(*goldenPath, output, 0o644); err != nil {
+			slog.Error("Cannot write golden file", "error", err)
+			os.Exit(exitFatal)
+		}
+		slog.Info("Updated golden file", "golden", *goldenPath, "bytes", len(output))
+		return
+	}
+	golden, err := os.ReadFile(*goldenPath)
+	if err != nil {
+		slog.Error("Cannot read golden file", "error", err)
+		os.Exit(exitFatal)
+	}
+
+	var report *verifyReport
+	if *format == "csv" {
+		report, err = compareCSV(golden, output, dialect, *tolerance, *maxDiffs)
+	} else {
+		report, err = compareJSON(golden, output, *tolerance, *maxDiffs)
+	}
+	if err != nil {
+		slog.Error("Cannot compare with golden file", "golden", *goldenPath, "error", err)
+		os.Exit(exitFatal)
+	}
+	if report.differ == 0 && len(report.missingColumns) == 0 && len(report.extraColumns) == 0 {
+		slog.Info("Output matches golden file", "golden", *goldenPath, "records", report.output)
+		return
+	}
+	if err := writeVerifyText(os.Stdout, report, *goldenPath); err != nil {
+		slog.Error("Cannot write report", "error", err)
+		os.Exit(exitFatal)
+	}
+	os.Exit(exitPartial)
+}
+
+// mustRecordOptions builds the decode options of the record flags, exiting
+// on an invalid flag.
+func mustRecordOptions(records *recordFlags) decodeOptions {
+	converter, err := records.converter()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	readerSchema, err := records.schema()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	filter, err := records.recordFilter()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	transform, err := records.recordTransform()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	plugin, err := records.recordPlugin()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	script, err := records.recordScript()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	epoch, err := records.epochFields(converter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	coerce, err := records.coercion()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	sample, err := records.sampling()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	onError, err := records.errorPolicy("")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFatal)
+	}
+	return decodeOptions{field: *records.field, converter: converter, readerSchema: readerSchema, filter: filter, transform: transform, extendedJSON: records.extendedJSON(converter), epochFields: epoch, coerce: coerce, plugin: plugin, script: script, sampling: sample, onError: onError}
+}
+
+// verifyOutput converts the inputs, in order, as decode or avro2csv would
+// write them to a single file, and returns the output.
+func verifyOutput(inputs []inputFile, format string, opts decodeOptions, flatten flattener, dialect csvDialect) ([]byte, error) {
+	var messages []json.RawMessage
+	collect := messageSink(func(msg json.RawMessage) error {
+		messages = append(messages, append(json.RawMessage(nil), msg...))
+		return nil
+	})
+	for _, in := range inputs {
+		input, err := openDecodeInput(in.path, opts)
+		if err == nil {
+			_, err = decodeMessages(input, in.path, opts, collect)
+			input.Close()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", displayPath(in.path), err)
+		}
+	}
+
+	var buf bytes.Buffer
+	var sink avroconvert.Sink
+	switch format {
+	case "json":
+		sink = avroconvert.NewJSONArraySink(&buf, true)
+	case "ndjson":
+		sink = avroconvert.NewNDJSONSink(&buf)
+	case "csv":
+		columns := newColumnCollector(flatten)
+		for _, msg := range messages {
+			if err := columns.WriteRecord(msg); err != nil {
+				return nil, err
+			}
+		}
+		rows := newCSVRowWriter(&buf, columns.names, flatten, dialect)
+		if err := rows.writeHeader(); err != nil {
+			return nil, err
+		}
+		sink = rows
+	}
+	for _, msg := range messages {
+		if err := sink.WriteRecord(msg); err != nil {
+			return nil, err
+		}
+	}
+	if err := sink.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// compareJSON compares the records of JSON array or NDJSON golden and
+// output files in order.
+func compareJSON(golden, output []byte, tolerance float64, maxDiffs int) (*verifyReport, error) {
+	want, err := parseJSONRecords(golden)
+	if err != nil {
+		return nil, fmt.Errorf("golden file: %w", err)
+	}
+	got, err := parseJSONRecords(output)
+	if err != nil {
+		return nil, fmt.Errorf("output: %w", err)
+	}
+	report := &verifyReport{golden: int64(len(want)), output: int64(len(got))}
+	for i := 0; i < max(len(want), len(got)); i++ {
+		rd := recordDiff{Key: strconv.Itoa(i + 1)}
+		switch {
+		case i >= len(want):
+			rd.Status = diffAdded
+		case i >= len(got):
+			rd.Status = diffRemoved
+		default:
+			compareValues("", want[i], got[i], tolerance, &rd.Fields)
+			if len(rd.Fields) == 0 {
+				continue
+			}
+			rd.Status = diffChanged
+		}
+		report.add(rd, maxDiffs)
+	}
+	return report, nil
+}
+
+// parseJSONRecords parses the records of a JSON array or NDJSON file.
+func parseJSONRecords(data []byte) ([]interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var records []interface{}
+	for {
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		records = append(records, v)
+	}
+	if len(records) == 1 && bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		return records[0].([]interface{}), nil
+	}
+	return records, nil
+}
+
+// compareCSV compares the rows of golden and output CSV files in order,
+// matching their cells by column name, whatever the order of the columns.
+func compareCSV(golden, output []byte, dialect csvDialect, tolerance float64, maxDiffs int) (*verifyReport, error) {
+	want, err := dialect.reader(bytes.NewReader(golden)).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("golden file: %w", err)
+	}
+	got, err := dialect.reader(bytes.NewReader(output)).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("output: %w", err)
+	}
+	// Inputs without records give a file with only an empty header
+	if len(want) == 0 {
+		want = [][]string{nil}
+	}
+	if len(got) == 0 {
+		got = [][]string{nil}
+	}
+	wantHeader, gotHeader := want[0], got[0]
+	want, got = want[1:], got[1:]
+
+	report := &verifyReport{golden: int64(len(want)), output: int64(len(got))}
+	gotIndex := make(map[string]int, len(gotHeader))
+	for i, name := range gotHeader {
+		gotIndex[name] = i
+	}
+	var shared [][2]int // indexes of each column both files have
+	wantColumns := make(map[string]bool, len(wantHeader))
+	for i, name := range wantHeader {
+		wantColumns[name] = true
+		if j, ok := gotIndex[name]; ok {
+			shared = append(shared, [2]int{i, j})
+		} else {
+			report.missingColumns = append(report.missingColumns, name)
+		}
+	}
+	for _, name := range gotHeader {
+		if !wantColumns[name] {
+			report.extraColumns = append(report.extraColumns, name)
+		}
+	}
+
+	cell := func(row []string, i int) string {
+		if i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+	for r := 0; r < max(len(want), len(got)); r++ {
+		rd := recordDiff{Key: strconv.Itoa(r + 1)}
+		switch {
+		case r >= len(want):
+			rd.Status = diffAdded
+		case r >= len(got):
+			rd.Status = diffRemoved
+		default:
+			for _, c := range shared {
+				a, b := cell(want[r], c[0]), cell(got[r], c[1])
+				if a != b && !numbersEqual(a, b, tolerance) {
+					rd.Fields = append(rd.Fields, fieldDiff{Path: wantHeader[c[0]], Left: diffValue(a), Right: diffValue(b)})
+				}
+			}
+			if len(rd.Fields) == 0 {
+				continue
+			}
+			rd.Status = diffChanged
+		}
+		report.add(rd, maxDiffs)
+	}
+	return report, nil
+}
+
+// add counts a differing record, listing up to maxDiffs of them.
+func (vr *verifyReport) add(rd recordDiff, maxDiffs int) {
+	vr.differ++
+	if maxDiffs == 0 || len(vr.records) < maxDiffs {
+		vr.records = append(vr.records, rd)
+	}
+}
+
+// writeVerifyText writes how the output differs from the golden file, with
+// each differing value as golden -> output.
+func writeVerifyText(w io.Writer, report *verifyReport, golden string) error {
+	fmt.Fprintf(w, "Output differs from %s:\n", golden)
+	for _, name := range report.missingColumns {
+		fmt.Fprintf(w, "column %s is missing from the output\n", name)
+	}
+	for _, name := range report.extraColumns {
+		fmt.Fprintf(w, "column %s is not in the golden file\n", name)
+	}
+	for _, rd := range report.records {
+		switch rd.Status {
+		case diffAdded:
+			fmt.Fprintf(w, "record %s is not in the golden file\n", rd.Key)
+		case diffRemoved:
+			fmt.Fprintf(w, "record %s is missing from the output\n", rd.Key)
+		default:
+			fmt.Fprintf(w, "record %s differs\n", rd.Key)
+			for _, f := range rd.Fields {
+				fmt.Fprintf(w, "  %s: %s -> %s\n", f.Path, diffText(f.Left), diffText(f.Right))
+			}
+		}
+	}
+	if listed := int64(len(report.records)); listed < report.differ {
+		fmt.Fprintf(w, "... and %s more differing records\n", formatCount(report.differ-listed))
+	}
+	_, err := fmt.Fprintf(w, "\nGolden: %s records, output: %s records, differing: %s\n",
+		formatCount(report.golden), formatCount(report.output), formatCount(report.differ))
+	return err
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNumbersEqual(t *testing.T) {
+	tests := []struct {
+		a, b      string
+		tolerance float64
+		want      bool
+	}{
+		{"1", "1.0", 0, true},
+		{"1e2", "100", 0, true},
+		{"0.1", "0.10000000000000001", 0, false},
+		{"0.1", "0.10000000000000001", 1e-9, true},
+		{"100", "101", 1e-9, false},
+		{"100", "101", 0.01, true},
+		{"abc", "abc2", 0.5, false},
+	}
+	for _, tt := range tests {
+		if got := numbersEqual(tt.a, tt.b, tt.tolerance); got != tt.want {
+			t.Errorf("numbersEqual(%s, %s, %g) = %v", tt.a, tt.b, tt.tolerance, got)
+		}
+	}
+}
+
+func TestVerifyOutput(t *testing.T) {
+	inputs := []inputFile{
+		{path: writeTestFile(t, "a.avro", writeMessageOCF(t, `{"id":1,"user":{"name":"ana"}}`))},
+		{path: writeTestFile(t, "b.avro", writeMessageOCF(t, `{"id":2,"score":0.5}`))},
+	}
+	opts := testOptions(t, "message")
+	tests := map[string]string{
+		"ndjson": `{"id":1,"user":{"name":"ana"}}` + "\n" + `{"id":2,"score":0.5}` + "\n",
+		"csv":    "id,user.name,score\n1,ana,\n2,,0.5\n",
+	}
+	for format, want := range tests {
+		got, err := verifyOutput(inputs, format, opts, flattener{separator: "."}, csvDialect{delimiter: ','})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s: converted to %q, want %q", format, got, want)
+		}
+	}
+	got, err := verifyOutput(inputs, "json", opts, flattener{}, csvDialect{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := parseJSONRecords(got)
+	if err != nil || len(records) != 2 {
+		t.Fatalf("JSON output %s: %v", got, err)
+	}
+}
+
+func TestCompareJSON(t *testing.T) {
+	golden := []byte(`[{"id":1,"score":0.1},{"id":2,"tags":["a"]},{"id":3}]`)
+	output := []byte(`{"id":1,"score":0.10000000000000001}` + "\n" + `{"id":2,"tags":["b"]}` + "\n")
+	report, err := compareJSON(golden, output, 1e-9, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.golden != 3 || report.output != 2 || report.differ != 2 {
+		t.Fatalf("report %+v", report)
+	}
+	if rd := report.records[0]; rd.Key != "2" || rd.Status != diffChanged || len(rd.Fields) != 1 || rd.Fields[0].Path != "tags.0" {
+		t.Errorf("first difference %+v", rd)
+	}
+	if rd := report.records[1]; rd.Key != "3" || rd.Status != diffRemoved {
+		t.Errorf("second difference %+v", rd)
+	}
+
+	var out bytes.Buffer
+	if err := writeVerifyText(&out, report, "golden.json"); err != nil {
+		t.Fatal(err)
+	}
+	want := "Output differs from golden.json:\n" +
+		"record 2 differs\n" +
+		"  tags.0: \"a\" -> \"b\"\n" +
+		"record 3 is missing from the output\n" +
+		"\nGolden: 3 records, output: 2 records, differing: 2\n"
+	if out.String() != want {
+		t.Errorf("wrote\n%s\nwant\n%s", out.String(), want)
+	}
+
+	if _, err := compareJSON([]byte(`{"id":`), output, 0, 20); err == nil || !strings.Contains(err.Error(), "golden file") {
+		t.Errorf("compared an invalid golden file: %v", err)
+	}
+}
+
+func TestCompareCSV(t *testing.T) {
+	golden := []byte("id,score,name\n1,1.0,ana\n2,2,bo\n")
+	output := []byte("name,id,score,extra\nana,1,1,x\nbob,2,2,y\n3,,,\n")
+	report, err := compareCSV(golden, output, csvDialect{delimiter: ','}, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(report.extraColumns, []string{"extra"}) || report.missingColumns != nil {
+		t.Errorf("columns missing %v, extra %v", report.missingColumns, report.extraColumns)
+	}
+	if report.differ != 2 || len(report.records) != 1 {
+		t.Fatalf("report %+v", report)
+	}
+	if rd := report.records[0]; rd.Key != "2" || len(rd.Fields) != 1 || rd.Fields[0].Path != "name" {
+		t.Errorf("difference %+v", rd)
+	}
+
+	var out bytes.Buffer
+	writeVerifyText(&out, report, "golden.csv")
+	if !strings.Contains(out.String(), "column extra is not in the golden file\n") || !strings.Contains(out.String(), "... and 1 more differing records\n") {
+		t.Errorf("wrote\n%s", out.String())
+	}
+
+	// An empty output has no columns at all
+	if report, err = compareCSV(golden, nil, csvDialect{delimiter: ','}, 0, 20); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.missingColumns) != 3 || report.differ != 2 {
+		t.Errorf("empty output: %+v", report)
+	}
+}
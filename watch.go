@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watcher converts the Avro files under a directory as they appear or
+// change. A file is converted once it has gone unmodified for settle, so
+// files that are still being written aren't picked up half-done.
+type watcher struct {
+	dir       string
+	settle    time.Duration
+	workers   int
+	state     *conversionState
+	canAppend bool
+	convert   func(inputFile) fileResult
+
+	fs      *fsnotify.Watcher
+	changed map[string]time.Time // files waiting to settle, by last change
+}
+
+// runWatch converts the files already in dir and then keeps converting new
+// and changed ones until interrupted. Failed conversions are reported
+// without stopping the watch.
+func runWatch(dir, statePath string, canAppend bool, workers int, settle time.Duration, convert func(inputFile) fileResult) {
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		fmt.Fprintf(os.Stderr, "-watch needs a local input directory, got %q\n", dir)
+		os.Exit(exitFatal)
+	}
+	if workers < 1 {
+		fmt.Fprintf(os.Stderr, "-workers must be at least 1, got %d\n", workers)
+		os.Exit(exitFatal)
+	}
+
+	w := &watcher{dir: dir, settle: settle, workers: workers, canAppend: canAppend, convert: convert, changed: make(map[string]time.Time)}
+	if statePath != "" {
+		var err error
+		if w.state, err = loadState(statePath); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(exitFatal)
+		}
+	}
+	w.convert = w.state.track(convert)
+
+	var err error
+	if w.fs, err = fsnotify.NewWatcher(); err != nil {
+		slog.Error("Cannot watch directory", "dir", dir, "error", err)
+		os.Exit(exitFatal)
+	}
+	defer w.fs.Close()
+
+	// Watch before listing, so files created in between aren't missed
+	existing, err := w.add(dir)
+	if err != nil {
+		slog.Error("Cannot watch directory", "dir", dir, "error", err)
+		os.Exit(exitFatal)
+	}
+	w.run(existing)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	slog.Info("Watching for new Avro files", "dir", dir)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case event, ok := <-w.fs.Events:
+			if !ok {
+				return
+			}
+			w.handle(event)
+
+		case err, ok := <-w.fs.Errors:
+			if !ok {
+				return
+			}
+			slog.Error("Error watching directory", "dir", dir, "error", err)
+
+		case now := <-ticker.C:
+			var ready []string
+			for path, changed := range w.changed {
+				if now.Sub(changed) >= w.settle {
+					ready = append(ready, path)
+					delete(w.changed, path)
+				}
+			}
+			sort.Strings(ready)
+			w.run(ready)
+		}
+	}
+}
+
+// add watches a directory and its subdirectories, which fsnotify doesn't do
+// by itself, and returns the Avro files already in them.
+func (w *watcher) add(root string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return w.fs.Add(path)
+		}
+		if isAvroName(path) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// handle notes changed Avro files and starts watching new directories.
+func (w *watcher) handle(event fsnotify.Event) {
+	switch {
+	case event.Has(fsnotify.Create):
+		if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+			files, err := w.add(event.Name)
+			if err != nil {
+				slog.Error("Cannot watch directory", "dir", event.Name, "error", err)
+			}
+			for _, file := range files {
+				w.changed[file] = time.Now()
+			}
+			return
+		}
+		fallthrough
+
+	case event.Has(fsnotify.Write):
+		if isAvroName(event.Name) {
+			w.changed[event.Name] = time.Now()
+		}
+
+	case event.Has(fsnotify.Remove), event.Has(fsnotify.Rename):
+		delete(w.changed, event.Name)
+	}
+}
+
+// run converts files, skipping those the state says have nothing new.
+func (w *watcher) run(paths []string) {
+	if len(paths) == 0 {
+		return
+	}
+	inputs := make([]inputFile, 0, len(paths))
+	for _, path := range paths {
+		rel, err := filepath.Rel(w.dir, path)
+		if err != nil {
+			rel = filepath.Base(path)
+		}
+		inputs = append(inputs, inputFile{path: path, rel: rel})
+	}
+
+	if w.state != nil {
+		var err error
+		if inputs, err = w.state.plan(inputs, w.canAppend); err != nil {
+			slog.Error("Cannot plan conversion", "error", err)
+			return
+		}
+	}
+	for _, result := range convertAll(inputs, w.workers, w.convert) {
+		if result.err != nil {
+			slog.Error("Cannot convert input", "input", result.input.path, "error", result.err)
+		}
+	}
+}
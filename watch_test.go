@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestWatcherTracksAvroFiles(t *testing.T) {
+	dir := makeTree(t, "a.avro", "notes.txt", "2026/b.avro.gz")
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fsw.Close()
+
+	var converted []inputFile
+	w := &watcher{dir: dir, workers: 1, fs: fsw, changed: make(map[string]time.Time), convert: func(in inputFile) fileResult {
+		converted = append(converted, in)
+		return fileResult{input: in}
+	}}
+	existing, err := w.add(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(existing)
+	if want := []string{filepath.Join(dir, "2026", "b.avro.gz"), filepath.Join(dir, "a.avro")}; !reflect.DeepEqual(existing, want) {
+		t.Fatalf("found %v, want %v", existing, want)
+	}
+
+	// A new directory is watched and the files already in it are picked up
+	sub := filepath.Join(dir, "2027")
+	if err := os.MkdirAll(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(sub, "c.avro"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	w.handle(fsnotify.Event{Name: sub, Op: fsnotify.Create})
+	w.handle(fsnotify.Event{Name: filepath.Join(dir, "notes.txt"), Op: fsnotify.Write})
+	w.handle(fsnotify.Event{Name: filepath.Join(dir, "d.avro"), Op: fsnotify.Create})
+	w.handle(fsnotify.Event{Name: filepath.Join(dir, "d.avro"), Op: fsnotify.Remove})
+	if _, ok := w.changed[filepath.Join(sub, "c.avro")]; !ok || len(w.changed) != 1 {
+		t.Fatalf("waiting for %v", w.changed)
+	}
+
+	w.run([]string{filepath.Join(sub, "c.avro")})
+	if want := []inputFile{{path: filepath.Join(sub, "c.avro"), rel: filepath.Join("2027", "c.avro")}}; !reflect.DeepEqual(converted, want) {
+		t.Fatalf("converted %+v, want %+v", converted, want)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"avroparser/pkg/avroconvert"
+)
+
+// webhookOptions holds the settings for posting records to an HTTP
+// endpoint.
+type webhookOptions struct {
+	url         string
+	header      http.Header
+	batchSize   int           // records per request; 1 posts each record as a JSON object
+	concurrency int           // requests in flight per input
+	retries     int           // times a failed request is sent again
+	backoff     time.Duration // wait before the first retry, doubled after each
+}
+
+// headerFlags collects repeated -header "Name: value" flags.
+type headerFlags []string
+
+func (hf *headerFlags) String() string {
+	return strings.Join(*hf, ", ")
+}
+
+func (hf *headerFlags) Set(value string) error {
+	if name, _, ok := strings.Cut(value, ":"); !ok || strings.TrimSpace(name) == "" {
+		return fmt.Errorf("invalid header %q (expected \"Name: value\")", value)
+	}
+	*hf = append(*hf, value)
+	return nil
+}
+
+// header returns the collected headers.
+func (hf headerFlags) header() http.Header {
+	header := make(http.Header)
+	for _, value := range hf {
+		name, value, _ := strings.Cut(value, ":")
+		header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	return header
+}
+
+// webhookStatusError is an error response of the endpoint.
+type webhookStatusError struct {
+	status     int
+	message    string
+	retryAfter time.Duration // from a Retry-After header, if any
+}
+
+func (e *webhookStatusError) Error() string {
+	return e.message
+}
+
+// webhookSink posts records to an endpoint, each batch as an NDJSON body
+// or, with a batch size of 1, each record as a JSON object. Up to
+// concurrency requests run at once, so batches may arrive out of order.
+type webhookSink struct {
+	opts    webhookOptions
+	client  *http.Client
+	buf     bytes.Buffer
+	pending int
+
+	batches chan webhookBatch
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	err     error // first failed request
+	posted  int64
+}
+
+type webhookBatch struct {
+	body    []byte
+	records int
+}
+
+func newWebhookSink(opts webhookOptions) *webhookSink {
+	ws := &webhookSink{opts: opts, client: &http.Client{Timeout: time.Minute}, batches: make(chan webhookBatch)}
+	for i := 0; i < opts.concurrency; i++ {
+		ws.wg.Add(1)
+		go func() {
+			defer ws.wg.Done()
+			for batch := range ws.batches {
+				err := ws.post(batch.body)
+				ws.mu.Lock()
+				if err != nil && ws.err == nil {
+					ws.err = err
+				} else if err == nil {
+					ws.posted += int64(batch.records)
+				}
+				ws.mu.Unlock()
+			}
+		}()
+	}
+	return ws
+}
+
+// failed returns the error of the first failed request, if any.
+func (ws *webhookSink) failed() error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	return ws.err
+}
+
+func (ws *webhookSink) WriteRecord(msg json.RawMessage) error {
+	if err := ws.failed(); err != nil {
+		return err
+	}
+	if err := json.Compact(&ws.buf, msg); err != nil {
+		return err
+	}
+	ws.buf.WriteByte('\n')
+	ws.pending++
+	if ws.pending >= ws.opts.batchSize {
+		return ws.Flush()
+	}
+	return nil
+}
+
+// Flush hands the pending records to a request. It doesn't wait for the
+// request to finish.
+func (ws *webhookSink) Flush() error {
+	if ws.pending == 0 {
+		return ws.failed()
+	}
+	body := append([]byte(nil), ws.buf.Bytes()...)
+	ws.batches <- webhookBatch{body: body, records: ws.pending}
+	ws.buf.Reset()
+	ws.pending = 0
+	return ws.failed()
+}
+
+// Close posts the remaining records and waits for every request.
+func (ws *webhookSink) Close() error {
+	ws.Flush()
+	close(ws.batches)
+	ws.wg.Wait()
+	return ws.failed()
+}
+
+// post sends one request, retrying network errors, 429 and 5xx responses
+// with exponential backoff. A Retry-After header overrides the wait.
+func (ws *webhookSink) post(body []byte) error {
+	contentType := "application/x-ndjson"
+	if ws.opts.batchSize == 1 {
+		body = bytes.TrimSuffix(body, []byte("\n"))
+		contentType = "application/json"
+	}
+
+	wait := ws.opts.backoff
+	for attempt := 0; ; attempt++ {
+		err := ws.send(body, contentType)
+		if err == nil {
+			return nil
+		}
+		var statusErr *webhookStatusError
+		transient := !errors.As(err, &statusErr) || statusErr.status == http.StatusTooManyRequests || statusErr.status >= 500
+		if attempt == ws.opts.retries || !transient {
+			return fmt.Errorf("cannot post records to %s: %w", ws.opts.url, err)
+		}
+		delay := wait
+		if statusErr != nil && statusErr.retryAfter > 0 {
+			delay = statusErr.retryAfter
+		}
+		time.Sleep(delay)
+		wait *= 2
+	}
+}
+
+func (ws *webhookSink) send(body []byte, contentType string) error {
+	req, err := http.NewRequest(http.MethodPost, ws.opts.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for name, values := range ws.opts.header {
+		req.Header[name] = values
+	}
+
+	resp, err := ws.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode >= 300 {
+		message := resp.Status
+		if text := strings.TrimSpace(string(data)); text != "" {
+			message += ": " + text
+		}
+		statusErr := &webhookStatusError{status: resp.StatusCode, message: message}
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			statusErr.retryAfter = time.Duration(seconds) * time.Second
+		}
+		return statusErr
+	}
+	return nil
+}
+
+// postWebhook posts the records of an input to an endpoint. Requests
+// already made stay made when a later one fails.
+func postWebhook(in inputFile, wo webhookOptions, opts decodeOptions) (avroconvert.Stats, error) {
+	input, err := openDecodeInput(in.path, opts)
+	if err != nil {
+		return avroconvert.Stats{}, err
+	}
+	defer input.Close()
+
+	sink := newWebhookSink(wo)
+	stats, err := decodeMessages(input, in.path, opts, sink)
+	if closeErr := sink.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return stats, err
+	}
+	slog.Info("Posted records", "input", in.path, "records", sink.posted, "url", wo.url, filteredAttr(stats))
+	return stats, nil
+}
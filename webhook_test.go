@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestHeaderFlags(t *testing.T) {
+	var hf headerFlags
+	for _, value := range []string{"Authorization: Bearer abc", "x-source:avroparser"} {
+		if err := hf.Set(value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := hf.Set("no colon"); err == nil {
+		t.Fatal("accepted a header without a value")
+	}
+	header := hf.header()
+	if header.Get("Authorization") != "Bearer abc" || header.Get("X-Source") != "avroparser" {
+		t.Fatalf("header %v", header)
+	}
+}
+
+// fakeWebhook records the bodies posted to it, failing the first failures
+// requests with status.
+type fakeWebhook struct {
+	mu       sync.Mutex
+	failures int
+	status   int
+	bodies   []string
+	types    []string
+	tokens   []string
+}
+
+func (f *fakeWebhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failures > 0 {
+		f.failures--
+		http.Error(w, "try later", f.status)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	f.bodies = append(f.bodies, string(body))
+	f.types = append(f.types, r.Header.Get("Content-Type"))
+	f.tokens = append(f.tokens, r.Header.Get("Authorization"))
+}
+
+func postTestRecords(t *testing.T, opts webhookOptions, msgs ...string) (*webhookSink, error) {
+	t.Helper()
+	sink := newWebhookSink(opts)
+	for _, msg := range msgs {
+		if err := sink.WriteRecord(json.RawMessage(msg)); err != nil {
+			sink.Close()
+			return sink, err
+		}
+	}
+	return sink, sink.Close()
+}
+
+func TestWebhookSink(t *testing.T) {
+	fake := &fakeWebhook{failures: 1, status: http.StatusServiceUnavailable}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	header := http.Header{"Authorization": {"Bearer abc"}}
+
+	// Batches are NDJSON bodies, and a failed request is sent again
+	opts := webhookOptions{url: server.URL, header: header, batchSize: 2, concurrency: 2, retries: 2}
+	sink, err := postTestRecords(t, opts, `{"id": 1}`, `{"id": 2}`, `{"id": 3}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(fake.bodies)
+	want := []string{"{\"id\":1}\n{\"id\":2}\n", "{\"id\":3}\n"}
+	if sink.posted != 3 || strings.Join(fake.bodies, "|") != strings.Join(want, "|") {
+		t.Fatalf("posted %d records as %q", sink.posted, fake.bodies)
+	}
+	if fake.types[0] != "application/x-ndjson" || fake.tokens[0] != "Bearer abc" {
+		t.Fatalf("sent %s with %q", fake.types[0], fake.tokens[0])
+	}
+
+	// A batch size of 1 posts each record as a JSON object
+	fake.bodies, fake.types = nil, nil
+	opts.batchSize = 1
+	if _, err := postTestRecords(t, opts, `{"id": 1}`); err != nil {
+		t.Fatal(err)
+	}
+	if fake.bodies[0] != `{"id":1}` || fake.types[0] != "application/json" {
+		t.Fatalf("posted %q as %s", fake.bodies[0], fake.types[0])
+	}
+}
+
+func TestWebhookSinkFailure(t *testing.T) {
+	for _, tc := range []struct {
+		status   int
+		failures int
+	}{
+		{http.StatusBadRequest, 1},           // not retried
+		{http.StatusInternalServerError, 10}, // retries run out
+	} {
+		fake := &fakeWebhook{failures: tc.failures, status: tc.status}
+		server := httptest.NewServer(fake)
+		sink, err := postTestRecords(t, webhookOptions{url: server.URL, batchSize: 1, concurrency: 1, retries: 2}, `{"id": 1}`)
+		server.Close()
+		if err == nil || sink.posted != 0 {
+			t.Fatalf("status %d: posted %d records", tc.status, sink.posted)
+		}
+		if tried := tc.failures - fake.failures; tc.status == http.StatusBadRequest && tried != 1 || tc.status != http.StatusBadRequest && tried != 3 {
+			t.Fatalf("status %d: sent %d requests", tc.status, tried)
+		}
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	"avroparser/pkg/avroconvert"
+)
+
+// Limits of an Excel worksheet.
+const (
+	xlsxMaxRows      = 1 << 20 // rows of a sheet, with the header
+	xlsxMaxColumns   = 1 << 14
+	xlsxMaxCellChars = 32767
+	xlsxMaxSheetName = 31
+)
+
+// convertXLSX converts an input to an Excel workbook, decoding it once. Each
+// sheet's rows are kept in a temporary file until the workbook is written,
+// as its columns are only known at the end.
+func convertXLSX(in inputFile, output string, opts csvOptions) (avroconvert.Stats, error) {
+	opts.decode.blocks = in.blocks
+	if err := opts.decode.checkOverwrite(output); err != nil {
+		return avroconvert.Stats{}, err
+	}
+	input, err := openDecodeInput(in.path, opts.decode)
+	if err != nil {
+		return avroconvert.Stats{}, err
+	}
+	defer input.Close()
+
+	book := newXLSXBook(opts.flatten, opts.sheetBy)
+	defer book.remove()
+	stats, err := decodeMessages(input, in.path, opts.decode, book)
+	if err != nil {
+		return stats, err
+	}
+
+	out, err := openOutput(output)
+	if err == nil {
+		if err = book.writeTo(out); err != nil {
+			abortOutput(out)
+		} else {
+			err = out.Close()
+		}
+	}
+	if err != nil {
+		return stats, fmt.Errorf("cannot write workbook: %w", err)
+	}
+	slog.Info("Wrote XLSX rows", "input", in.path, "rows", stats.Messages, "sheets", len(book.sheets), "output", displayPath(output), filteredAttr(stats))
+	return stats, nil
+}
+
+// xlsxBook collects the rows of a workbook's sheets. With sheetBy, records
+// go to the sheet named after the value of that field, e.g. event_name.
+type xlsxBook struct {
+	flatten flattener
+	sheetBy []string
+	sheets  []*xlsxSheet
+	current map[string]*xlsxSheet // the sheet taking rows, by value
+	names   map[string]bool       // sheet names in use, in lower case
+	dropped int                   // fields past the column limit
+}
+
+func newXLSXBook(flatten flattener, sheetBy []string) *xlsxBook {
+	return &xlsxBook{flatten: flatten, sheetBy: sheetBy, current: make(map[string]*xlsxSheet), names: make(map[string]bool)}
+}
+
+// xlsxSheet is a sheet's columns, in first-seen order, and its rows so far
+// as worksheet XML.
+type xlsxSheet struct {
+	name    string
+	columns []string
+	index   map[string]int
+	rows    int
+	file    *os.File
+	bw      *bufio.Writer
+}
+
+func (xb *xlsxBook) WriteRecord(msg json.RawMessage) error {
+	v, err := parseMessage(msg)
+	if err != nil {
+		return err
+	}
+	key := "records"
+	if xb.sheetBy != nil {
+		key = countKey(lookupPath(v, xb.sheetBy))
+	}
+	sheet := xb.current[key]
+	if sheet == nil || sheet.rows == xlsxMaxRows-1 {
+		// A full sheet is continued on another, e.g. "level_start (2)"
+		if sheet, err = xb.addSheet(key); err != nil {
+			return err
+		}
+		xb.current[key] = sheet
+	}
+	sheet.rows++
+	row := sheet.rows + 1
+	fmt.Fprintf(sheet.bw, `<row r="%d">`, row)
+	for _, f := range xb.flatten.flatten(v) {
+		i, ok := sheet.index[f.name]
+		if !ok {
+			if len(sheet.columns) == xlsxMaxColumns {
+				xb.dropped++
+				continue
+			}
+			i = len(sheet.columns)
+			sheet.index[f.name] = i
+			sheet.columns = append(sheet.columns, f.name)
+		}
+		if f.value == nil {
+			continue
+		}
+		writeXLSXCell(sheet.bw, xlsxCellRef(i, row), f.value)
+	}
+	sheet.bw.WriteString("</row>")
+	return nil
+}
+
+func (xb *xlsxBook) Flush() error {
+	return nil
+}
+
+func (xb *xlsxBook) Close() error {
+	return nil
+}
+
+// addSheet starts a sheet for the records with the value key, named after
+// it as far as Excel allows.
+func (xb *xlsxBook) addSheet(key string) (*xlsxSheet, error) {
+	base := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`:\/?*[]`, r) {
+			return '_'
+		}
+		return r
+	}, key)
+	if strings.TrimSpace(base) == "" {
+		base = "(empty)"
+	}
+	name := truncateRunes(base, xlsxMaxSheetName)
+	for n := 2; xb.names[strings.ToLower(name)]; n++ {
+		suffix := fmt.Sprintf(" (%d)", n)
+		name = truncateRunes(base, xlsxMaxSheetName-len(suffix)) + suffix
+	}
+	file, err := os.CreateTemp("", "avroparser-sheet-*.xml")
+	if err != nil {
+		return nil, fmt.Errorf("cannot create sheet file: %w", err)
+	}
+	xb.names[strings.ToLower(name)] = true
+	sheet := &xlsxSheet{name: name, index: make(map[string]int), file: file, bw: bufio.NewWriter(file)}
+	xb.sheets = append(xb.sheets, sheet)
+	return sheet, nil
+}
+
+// writeTo writes the workbook as an XLSX file: a sheet per value, each
+// with a bold header row that stays in view when scrolling.
+func (xb *xlsxBook) writeTo(w io.Writer) error {
+	if xb.dropped > 0 {
+		slog.Warn("Left out fields past the last column of the sheet", "fields", xb.dropped, "columns", xlsxMaxColumns)
+	}
+	if len(xb.sheets) == 0 {
+		// A workbook needs a sheet
+		if _, err := xb.addSheet("records"); err != nil {
+			return err
+		}
+	}
+	zw := zip.NewWriter(w)
+	var sheetTypes, sheetRels, sheetList strings.Builder
+	for i, sheet := range xb.sheets {
+		n := i + 1
+		fmt.Fprintf(&sheetTypes, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, n)
+		fmt.Fprintf(&sheetRels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, n, n)
+		fmt.Fprintf(&sheetList, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, xmlEscape(sheet.name), n, n)
+	}
+	parts := []struct{ name, content string }{
+		{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+			`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+			`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+			`<Default Extension="xml" ContentType="application/xml"/>` +
+			`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+			`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+			sheetTypes.String() + `</Types>`},
+		{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+			`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+			`</Relationships>`},
+		{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+			`<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+			`<sheets>` + sheetList.String() + `</sheets></workbook>`},
+		{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+			`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			sheetRels.String() +
+			fmt.Sprintf(`<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>`, len(xb.sheets)+1) +
+			`</Relationships>`},
+		{"xl/styles.xml", xlsxStyles},
+	}
+	for _, part := range parts {
+		pw, err := zw.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(pw, part.content); err != nil {
+			return err
+		}
+	}
+	for i, sheet := range xb.sheets {
+		pw, err := zw.Create(fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1))
+		if err != nil {
+			return err
+		}
+		if err := sheet.writeTo(pw); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// xlsxStyles defines the cell styles: 0 is the default, 1 the bold header
+// and 2 whole numbers, which Excel would otherwise show in scientific
+// notation from 12 digits on.
+const xlsxStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+	`<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+	`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+	`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+	`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+	`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+	`<cellXfs count="3">` +
+	`<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+	`<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/>` +
+	`<xf numFmtId="1" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+	`</cellXfs></styleSheet>`
+
+// writeTo writes the sheet's worksheet XML: the header, a frozen pane below
+// it and the rows from the temporary file.
+func (xs *xlsxSheet) writeTo(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+		`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+		`<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>` +
+		`<sheetData><row r="1">`)
+	for i, name := range xs.columns {
+		fmt.Fprintf(bw, `<c r="%s" s="1" t="inlineStr"><is><t>%s</t></is></c>`, xlsxCellRef(i, 1), xmlEscape(name))
+	}
+	bw.WriteString(`</row>`)
+	if err := xs.bw.Flush(); err != nil {
+		return fmt.Errorf("cannot write sheet file: %w", err)
+	}
+	if _, err := xs.file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("cannot read sheet file: %w", err)
+	}
+	if _, err := io.Copy(bw, xs.file); err != nil {
+		return fmt.Errorf("cannot read sheet file: %w", err)
+	}
+	bw.WriteString(`</sheetData></worksheet>`)
+	return bw.Flush()
+}
+
+// remove deletes the temporary files of the sheets.
+func (xb *xlsxBook) remove() {
+	for _, sheet := range xb.sheets {
+		sheet.file.Close()
+		os.Remove(sheet.file.Name())
+	}
+}
+
+// writeXLSXCell writes a flattened value as a cell: numbers and booleans as
+// such, and anything else as text. Whole numbers of more than 15 digits are
+// written as text, as Excel would round them.
+func writeXLSXCell(w *bufio.Writer, ref string, v interface{}) {
+	switch t := v.(type) {
+	case json.Number:
+		digits := strings.TrimPrefix(t.String(), "-")
+		if _, err := strconv.ParseInt(t.String(), 10, 64); err == nil && len(digits) <= 15 {
+			fmt.Fprintf(w, `<c r="%s" s="2"><v>%s</v></c>`, ref, t)
+			return
+		}
+		if f, err := t.Float64(); err == nil && !strings.ContainsAny(digits, "eE") && len(strings.Replace(digits, ".", "", 1)) <= 15 {
+			fmt.Fprintf(w, `<c r="%s"><v>%s</v></c>`, ref, strconv.FormatFloat(f, 'f', -1, 64))
+			return
+		}
+	case bool:
+		b := 0
+		if t {
+			b = 1
+		}
+		fmt.Fprintf(w, `<c r="%s" t="b"><v>%d</v></c>`, ref, b)
+		return
+	}
+	text := truncateRunes(csvValue(v), xlsxMaxCellChars)
+	fmt.Fprintf(w, `<c r="%s" t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`, ref, xmlEscape(text))
+}
+
+// xlsxCellRef returns the A1 reference of a cell, e.g. AB12.
+func xlsxCellRef(column, row int) string {
+	var letters []byte
+	for n := column + 1; n > 0; n = (n - 1) / 26 {
+		letters = append([]byte{byte('A' + (n-1)%26)}, letters...)
+	}
+	return string(letters) + strconv.Itoa(row)
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// truncateRunes shortens s to at most n characters.
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n])
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"archive/zip"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestXLSXCellRef(t *testing.T) {
+	for _, tt := range []struct {
+		column, row int
+		want        string
+	}{
+		{0, 1, "A1"},
+		{25, 2, "Z2"},
+		{26, 3, "AA3"},
+		{27, 10, "AB10"},
+		{16383, 1, "XFD1"},
+	} {
+		if got := xlsxCellRef(tt.column, tt.row); got != tt.want {
+			t.Errorf("xlsxCellRef(%d, %d) = %s, want %s", tt.column, tt.row, got, tt.want)
+		}
+	}
+}
+
+func TestXLSXSheetNames(t *testing.T) {
+	book := newXLSXBook(flattener{separator: "."}, nil)
+	defer book.remove()
+	var names []string
+	for _, key := range []string{"a/b:c", "Level", "level", "", strings.Repeat("x", 40), strings.Repeat("x", 40)} {
+		sheet, err := book.addSheet(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, sheet.name)
+	}
+	want := []string{"a_b_c", "Level", "level (2)", "(empty)", strings.Repeat("x", 31), strings.Repeat("x", 27) + " (2)"}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("sheet %d named %q, want %q", i, names[i], want[i])
+		}
+	}
+}
+
+func TestConvertXLSX(t *testing.T) {
+	data := writeMessageOCF(t,
+		`{"event_name":"level_start","level":3,"user":"a & b"}`,
+		`{"event_name":"purchase","price":1.25,"big":12345678901234567,"ok":true}`,
+		`{"event_name":"level_start","level":4,"geo":{"country":"DE"}}`,
+	)
+	in := inputFile{path: writeTestFile(t, "events.avro", data), rel: "events.avro"}
+	output := filepath.Join(t.TempDir(), "events.xlsx")
+	opts := csvOptions{decode: testOptions(t, "message"), flatten: flattener{separator: "."}, xlsx: true, sheetBy: []string{"event_name"}}
+
+	stats, err := convertXLSX(in, output, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Messages != 3 {
+		t.Fatalf("converted %+v", stats)
+	}
+
+	zr, err := zip.OpenReader(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	parts := make(map[string]string)
+	for _, f := range zr.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts[f.Name] = string(content)
+	}
+
+	for part, wants := range map[string][]string{
+		"xl/workbook.xml": {`<sheet name="level_start" sheetId="1" r:id="rId1"/>`, `<sheet name="purchase" sheetId="2" r:id="rId2"/>`},
+		"xl/worksheets/sheet1.xml": {
+			`<row r="1"><c r="A1" s="1" t="inlineStr"><is><t>event_name</t></is></c><c r="B1" s="1" t="inlineStr"><is><t>level</t></is></c><c r="C1" s="1" t="inlineStr"><is><t>user</t></is></c><c r="D1" s="1" t="inlineStr"><is><t>geo.country</t></is></c></row>`,
+			`<c r="B2" s="2"><v>3</v></c>`,
+			`<t xml:space="preserve">a &amp; b</t>`,
+			`<row r="3">`,
+		},
+		"xl/worksheets/sheet2.xml": {
+			`<c r="D2"><v>1.25</v></c>`,
+			`<c r="A2" t="inlineStr"><is><t xml:space="preserve">12345678901234567</t></is></c>`,
+			`<c r="C2" t="b"><v>1</v></c>`,
+		},
+	} {
+		for _, want := range wants {
+			if !strings.Contains(parts[part], want) {
+				t.Errorf("%s lacks %s:\n%s", part, want, parts[part])
+			}
+		}
+	}
+}